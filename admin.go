@@ -0,0 +1,305 @@
+package netx
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats 服务器运行统计, 零值可用, 可以并发使用
+type ServerStats struct {
+	once    sync.Once
+	started time.Time
+
+	mu       sync.Mutex
+	queries  uint64
+	errors   uint64
+	duration time.Duration
+	rcodes   map[int]uint64
+	types    map[string]uint64
+}
+
+// ServerStatsSnapshot ServerStats 某一时刻的值
+type ServerStatsSnapshot struct {
+	Started time.Time         `json:"started"`
+	Uptime  string            `json:"uptime"`
+	Queries uint64            `json:"queries"`
+	Errors  uint64            `json:"errors"` // 处理器返回错误的请求
+	Average string            `json:"average"`
+	RCodes  map[int]uint64    `json:"rcodes"`
+	Types   map[string]uint64 `json:"types"`
+	Cache   *CacheStats       `json:"cache,omitempty"`
+}
+
+func (s *ServerStats) start() {
+	s.once.Do(func() { s.started = time.Now() })
+}
+
+// WithStats 统计经过的请求
+func WithStats(s *ServerStats) ServerMiddleware {
+	s.start()
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			start := time.Now()
+			resp, err := next.ServeDNS(ctx, req)
+			s.record(req, resp, err, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+func (s *ServerStats) record(req *DNSRequest, resp *DNSMessage, err error, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rcodes == nil {
+		s.rcodes, s.types = map[int]uint64{}, map[string]uint64{}
+	}
+	s.queries++
+	s.duration += d
+	for _, q := range req.Message.Questions {
+		s.types[DNSType(q.QuestionType).String()]++
+	}
+	switch {
+	case err != nil:
+		s.errors++
+		s.rcodes[DNSRCodeServFail]++
+	case resp != nil:
+		s.rcodes[int(resp.Header.Flags.RCode)]++
+	}
+}
+
+// Snapshot 返回当前的统计值
+func (s *ServerStats) Snapshot() *ServerStatsSnapshot {
+	s.start()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &ServerStatsSnapshot{
+		Started: s.started,
+		Uptime:  time.Since(s.started).Round(time.Second).String(),
+		Queries: s.queries,
+		Errors:  s.errors,
+		RCodes:  map[int]uint64{},
+		Types:   map[string]uint64{},
+	}
+	if s.queries > 0 {
+		snap.Average = (s.duration / time.Duration(s.queries)).String()
+	}
+	for k, v := range s.rcodes {
+		snap.RCodes[k] = v
+	}
+	for k, v := range s.types {
+		snap.Types[k] = v
+	}
+	return snap
+}
+
+// QueryLog 查询日志, 详细模式下每个请求写一行, 否则只记录处理器返回的错误. 可以并发使用
+type QueryLog struct {
+	// Out 日志输出, 为空时使用 os.Stderr
+	Out io.Writer
+
+	verbose int32
+	mu      sync.Mutex
+}
+
+// SetVerbose 开启或关闭详细模式, 可以在运行时调用
+func (l *QueryLog) SetVerbose(verbose bool) {
+	v := int32(0)
+	if verbose {
+		v = 1
+	}
+	atomic.StoreInt32(&l.verbose, v)
+}
+
+// Verbose 是否处于详细模式
+func (l *QueryLog) Verbose() bool {
+	return atomic.LoadInt32(&l.verbose) == 1
+}
+
+// WithQueryLog 将请求写入 l, 每行为: 时间 客户端 协议 名字 类型 rcode 回答数 耗时 [错误]
+func WithQueryLog(l *QueryLog) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			start := time.Now()
+			resp, err := next.ServeDNS(ctx, req)
+			if err != nil || l.Verbose() {
+				l.write(req, resp, err, start)
+			}
+			return resp, err
+		})
+	}
+}
+
+func (l *QueryLog) write(req *DNSRequest, resp *DNSMessage, err error, start time.Time) {
+	name, qtype := "-", "-"
+	if len(req.Message.Questions) > 0 {
+		q := req.Message.Questions[0]
+		name, qtype = q.QuestionName, DNSType(q.QuestionType).String()
+	}
+	rcode, answers := "-", 0
+	if err != nil {
+		rcode = "2"
+	} else if resp != nil {
+		rcode, answers = strconv.Itoa(int(resp.Header.Flags.RCode)), len(resp.Answers())
+	}
+	line := fmt.Sprintf("%s %v %s %s %s %s %d %v", start.Format(time.RFC3339), req.ClientIP(), req.Network,
+		name, qtype, rcode, answers, time.Since(start).Round(time.Microsecond))
+	if err != nil {
+		line += " " + strconv.Quote(err.Error())
+	}
+	out := l.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	l.mu.Lock()
+	_, _ = io.WriteString(out, line+"\n")
+	l.mu.Unlock()
+}
+
+// Admin 运行中服务器的控制接口, 作为 http.Handler 使用, 字段为空时对应的接口返回 404:
+//
+//	GET  /stats                  运行统计与缓存统计
+//	POST /cache/flush[?name=x]   清空缓存, 或只删除 x 及其子域名
+//	POST /reload                 重新加载配置, 包括区域文件与黑名单文件, 成功后清空缓存
+//	GET  /querylog               查询详细日志状态
+//	POST /querylog?verbose=true  开启或关闭详细日志
+//
+// 信任模型: 能连接到控制接口的本机进程都是可信的, Admin 只防范浏览器中的网页借用户之手访问接口.
+// tcp 监听时 Host 必须是 localhost, 回环地址或监听地址本身, 以拒绝 DNS rebinding;
+// POST 接口要求带有 X-Netx-Admin 请求头, 跨域请求带上该头时需要预检, 而控制接口从不应答预检.
+// Token 不为空时 POST 接口改为要求 "Authorization: Bearer <Token>", 监听在非本机地址时应当设置
+type Admin struct {
+	Stats    *ServerStats
+	Cache    *DNSCache
+	QueryLog *QueryLog
+	// Reload 重新加载配置, 通常为 ConfigServer.Reload
+	Reload func() error
+	// Token 修改类接口的访问令牌
+	Token string
+}
+
+// AdminHeader 未设置 Admin.Token 时 POST 请求必须带有的请求头, 值不限
+const AdminHeader = "X-Netx-Admin"
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.allowHost(r) {
+		http.Error(w, "host not allowed", http.StatusForbidden)
+		return
+	}
+	methods := map[string]string{"/stats": "GET", "/cache/flush": "POST", "/reload": "POST", "/querylog": "GET POST"}
+	if allowed, ok := methods[r.URL.Path]; ok && !strings.Contains(allowed, r.Method) {
+		w.Header().Set("Allow", strings.ReplaceAll(allowed, " ", ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == http.MethodPost && !a.authorized(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.URL.Path == "/stats" && a.Stats != nil:
+		snap := a.Stats.Snapshot()
+		if a.Cache != nil {
+			cache := a.Cache.Stats()
+			snap.Cache = &cache
+		}
+		writeJSON(w, http.StatusOK, snap)
+	case r.URL.Path == "/cache/flush" && a.Cache != nil:
+		if name := r.URL.Query().Get("name"); name != "" {
+			writeJSON(w, http.StatusOK, map[string]int{"flushed": a.Cache.FlushName(name)})
+			return
+		}
+		n := a.Cache.Stats().Entries
+		a.Cache.Flush()
+		writeJSON(w, http.StatusOK, map[string]int{"flushed": n})
+	case r.URL.Path == "/reload" && a.Reload != nil:
+		if err := a.Reload(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if a.Cache != nil {
+			a.Cache.Flush()
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+	case r.URL.Path == "/querylog" && a.QueryLog != nil:
+		if r.Method == http.MethodPost {
+			verbose, err := strconv.ParseBool(r.URL.Query().Get("verbose"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad verbose value"})
+				return
+			}
+			a.QueryLog.SetVerbose(verbose)
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"verbose": a.QueryLog.Verbose()})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allowHost 检查 Host 是否为 localhost, 回环地址或连接的本地地址, unix socket 不检查
+func (a *Admin) allowHost(r *http.Request) bool {
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if local != nil && local.Network() == "unix" {
+		return true
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	if tcp, ok := local.(*net.TCPAddr); ok {
+		return ip.Equal(tcp.IP)
+	}
+	return false
+}
+
+// authorized 检查修改类请求的令牌或 AdminHeader
+func (a *Admin) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return r.Header.Get(AdminHeader) != ""
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(a.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ListenAdmin 监听控制接口地址, "unix:" 开头或以 "/" 开头时为 unix socket (删除遗留的文件), 否则为 tcp 地址.
+// unix socket 的访问由文件权限控制; tcp 地址应当只绑定在本机, 否则需要设置 Admin.Token, 见 Admin
+func ListenAdmin(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") || strings.HasPrefix(address, "/") {
+		path := strings.TrimPrefix(address, "unix:")
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
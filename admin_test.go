@@ -0,0 +1,272 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithCache(t *testing.T) {
+	calls := 0
+	cache := &DNSCache{}
+	h := ChainHandler(DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		calls++
+		resp := NewReply(req.Message)
+		switch req.Message.Questions[0].QuestionName {
+		case "www.example.com":
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"}}
+			resp.Header.AnswerRRs = 1
+		case "nx.example.com":
+			resp.Header.Flags.RCode = 3
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: "example.com", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 30, RData: "ns.example.com. hostmaster.example.com. 1 3600 900 604800 30"}}
+			resp.Header.AuthorityRRs = 1
+		default:
+			resp.Header.Flags.RCode = 2
+		}
+		return resp, nil
+	}), WithCache(cache))
+	query := func(name string) *DNSMessage {
+		req := NewQuery(name, DNSTypeA)
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: req})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.TxID != req.Header.TxID {
+			t.Fatalf("txid = %d, want %d", resp.Header.TxID, req.Header.TxID)
+		}
+		return resp
+	}
+	for i := 0; i < 3; i++ {
+		if resp := query("www.example.com"); resp.Answers()[0].RData != "192.0.2.1" {
+			t.Fatalf("answer = %+v", resp.Answers())
+		}
+		if resp := query("nx.example.com"); resp.Header.Flags.RCode != 3 {
+			t.Fatalf("rcode = %d", resp.Header.Flags.RCode)
+		}
+		query("fail.example.com")
+	}
+	if calls != 5 {
+		t.Fatalf("calls = %d", calls)
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Hits != 4 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if n := cache.FlushName("example.com"); n != 2 {
+		t.Fatalf("flushed = %d", n)
+	}
+	query("www.example.com")
+	if calls != 6 {
+		t.Fatalf("calls after flush = %d", calls)
+	}
+
+	// 同一问题的 NOTIFY 与 UPDATE 不读也不写缓存
+	for _, opcode := range []uint16{DNSOpCodeNotify, DNSOpCodeUpdate, DNSOpCodeNotify} {
+		req := NewQuery("www.example.com", DNSTypeA)
+		req.Header.Flags.OpCode = opcode
+		if _, err := h.ServeDNS(context.Background(), &DNSRequest{Message: req}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cache.Stats(); calls != 9 || stats.Entries != 1 {
+		t.Fatalf("calls = %d, stats = %+v", calls, stats)
+	}
+}
+
+func TestWithCacheEDNS(t *testing.T) {
+	calls := 0
+	cache := &DNSCache{}
+	// 上游总是带 DO 位的 OPT, 请求设置 DO 时再加上签名, 与不去掉 DNSSEC 记录的转发相同
+	h := ChainHandler(DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		calls++
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord("www.example.com", "192.0.2.1")}
+		if req.Message.DNSSECOK() {
+			resp.ResourceRecodes = append(resp.ResourceRecodes, &DNSResourceRecode{Name: "www.example.com", RRType: DNSTypeRRSIG, Class: DNSClassIn, TTL: 60, Data: append(make([]byte, 18), 0, 1, 2, 3)})
+		}
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+		WithDNSSECOK()(resp)
+		return resp, nil
+	}), WithCache(cache))
+	query := func(opts ...QueryOption) *DNSMessage {
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example.com", DNSTypeA, opts...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	signed := func(resp *DNSMessage) bool {
+		for _, rr := range resp.Answers() {
+			if rr.RRType == DNSTypeRRSIG {
+				return true
+			}
+		}
+		return false
+	}
+	subnet := func(ip string) QueryOption {
+		return WithEDNSOptions(0, (&ClientSubnet{IP: net.ParseIP(ip).To4(), SourcePrefix: 24}).Option())
+	}
+
+	cases := []struct {
+		name  string
+		opts  []QueryOption
+		calls int
+	}{
+		{"do fill", []QueryOption{WithDNSSECOK()}, 1},
+		{"plain", nil, 2},
+		{"edns", []QueryOption{WithEDNSOptions(0)}, 3},
+		{"cd", []QueryOption{WithDNSSECOK(), WithCheckingDisabled()}, 4},
+		{"subnet a", []QueryOption{subnet("192.0.2.1")}, 5},
+		{"subnet b", []QueryOption{subnet("198.51.100.1")}, 6},
+		{"do hit", []QueryOption{WithDNSSECOK()}, 6},
+		{"plain hit", nil, 6},
+		{"edns hit", []QueryOption{WithEDNSOptions(0)}, 6},
+		{"cd hit", []QueryOption{WithDNSSECOK(), WithCheckingDisabled()}, 6},
+		{"subnet a hit", []QueryOption{subnet("192.0.2.1")}, 6},
+	}
+	for _, c := range cases {
+		req := NewQuery("www.example.com", DNSTypeA, c.opts...)
+		resp := query(c.opts...)
+		if calls != c.calls {
+			t.Fatalf("%s: calls = %d, want %d", c.name, calls, c.calls)
+		}
+		if signed(resp) != req.DNSSECOK() {
+			t.Fatalf("%s: signatures = %v", c.name, signed(resp))
+		}
+		if !strings.HasSuffix(c.name, "hit") {
+			continue
+		}
+		// 命中时 OPT 记录与 DO 位跟随请求
+		if (resp.OPT() != nil) != (req.OPT() != nil) || resp.DNSSECOK() != req.DNSSECOK() {
+			t.Fatalf("%s: opt %+v", c.name, resp.OPT())
+		}
+		if _, err := resp.ToByte(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	stats, cache, log := &ServerStats{}, &DNSCache{}, &QueryLog{}
+	var out bytes.Buffer
+	log.Out = &out
+	reloads := 0
+	h := ChainHandler(DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	}), WithStats(stats), WithQueryLog(log), WithCache(cache))
+	admin := httptest.NewServer(&Admin{Stats: stats, Cache: cache, QueryLog: log, Reload: func() error {
+		reloads++
+		return nil
+	}})
+	defer admin.Close()
+	call := func(method, path string, v interface{}) int {
+		req, _ := http.NewRequest(method, admin.URL+path, nil)
+		if method == "POST" {
+			req.Header.Set(AdminHeader, "1")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var verbose map[string]bool
+	if call("POST", "/querylog?verbose=true", &verbose); !verbose["verbose"] {
+		t.Fatalf("verbose = %v", verbose)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example.com", DNSTypeA), Network: "udp"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 2 || !strings.Contains(out.String(), " www.example.com A 0 1 ") {
+		t.Fatalf("log = %q", out.String())
+	}
+
+	var snap ServerStatsSnapshot
+	call("GET", "/stats", &snap)
+	if snap.Queries != 2 || snap.Types["A"] != 2 || snap.RCodes[0] != 2 || snap.Cache == nil || snap.Cache.Hits != 1 {
+		t.Fatalf("stats = %+v", snap)
+	}
+	var flushed map[string]int
+	if call("POST", "/cache/flush?name=example.com", &flushed); flushed["flushed"] != 1 {
+		t.Fatalf("flushed = %v", flushed)
+	}
+	if code := call("POST", "/reload", nil); code != http.StatusOK || reloads != 1 {
+		t.Fatalf("reload = %d, %d", code, reloads)
+	}
+	if code := call("GET", "/reload", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /reload = %d", code)
+	}
+	if code := call("GET", "/unknown", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown = %d", code)
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	reloads := 0
+	a := &Admin{Reload: func() error {
+		reloads++
+		return nil
+	}}
+	admin := httptest.NewServer(a)
+	defer admin.Close()
+	call := func(method, host string, header http.Header) int {
+		req, _ := http.NewRequest(method, admin.URL+"/reload", nil)
+		if host != "" {
+			req.Host = host
+		}
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	marked := http.Header{AdminHeader: {"1"}}
+
+	// DNS rebinding: 攻击者的域名解析到 127.0.0.1
+	if code := call("POST", "attacker.example:80", marked); code != http.StatusForbidden {
+		t.Fatalf("foreign host = %d", code)
+	}
+	// 浏览器跨域的简单请求不能带自定义头
+	if code := call("POST", "", nil); code != http.StatusForbidden {
+		t.Fatalf("without header = %d", code)
+	}
+	if code := call("OPTIONS", "", http.Header{"Access-Control-Request-Method": {"POST"}}); code != http.StatusMethodNotAllowed {
+		t.Fatalf("preflight = %d", code)
+	}
+	for _, host := range []string{"", "localhost", "localhost:8053", "[::1]:8053"} {
+		if code := call("POST", host, marked); code != http.StatusOK {
+			t.Fatalf("host %q = %d", host, code)
+		}
+	}
+
+	a.Token = "secret"
+	if code := call("POST", "", marked); code != http.StatusForbidden {
+		t.Fatalf("without token = %d", code)
+	}
+	if code := call("POST", "", http.Header{"Authorization": {"Bearer wrong"}}); code != http.StatusForbidden {
+		t.Fatalf("wrong token = %d", code)
+	}
+	if code := call("POST", "", http.Header{"Authorization": {"Bearer secret"}}); code != http.StatusOK || reloads != 5 {
+		t.Fatalf("token = %d, %d", code, reloads)
+	}
+}
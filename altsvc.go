@@ -0,0 +1,186 @@
+package netx
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAltSvcMaxAge Alt-Svc 没有 ma 参数时的有效期, RFC 7838 3.1
+	defaultAltSvcMaxAge = 24 * time.Hour
+	// altSvcBrokenTime HTTP/3 失败后退回 HTTP/2 的时间
+	altSvcBrokenTime = 5 * time.Minute
+)
+
+// AltSvc Alt-Svc 头 (RFC 7838) 中的一个备用服务, Host 为空表示与源相同
+type AltSvc struct {
+	Protocol string // ALPN 协议, 例如 h3
+	Host     string
+	Port     int
+	MaxAge   time.Duration
+}
+
+// ParseAltSvc 解析 Alt-Svc 头, 返回的 clear 为真表示撤销之前的全部声明. 无法解析的项被忽略
+func ParseAltSvc(header string) (alts []AltSvc, clear bool) {
+	if strings.TrimSpace(header) == "clear" {
+		return nil, true
+	}
+	for _, item := range splitQuoted(header, ',') {
+		params := splitQuoted(item, ';')
+		proto, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok {
+			continue
+		}
+		host, port, err := net.SplitHostPort(strings.Trim(authority, `"`))
+		if err != nil {
+			continue
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 0xFFFF {
+			continue
+		}
+		alt := AltSvc{Protocol: strings.TrimSpace(proto), Host: host, Port: p, MaxAge: defaultAltSvcMaxAge}
+		for _, param := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(k, "ma") {
+				if n, err := strconv.ParseUint(strings.Trim(v, `"`), 10, 32); err == nil {
+					alt.MaxAge = time.Duration(n) * time.Second
+				}
+			}
+		}
+		alts = append(alts, alt)
+	}
+	return alts, false
+}
+
+// splitQuoted 按 sep 切分 s, 忽略引号中的分隔符
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// AltSvcCache 按源 (scheme://host:port) 记录服务器声明的 HTTP/3 端点, 以及 HTTP/3 失败后暂停使用的时间
+type AltSvcCache struct {
+	mu      sync.Mutex
+	entries map[string]*altSvcEntry
+}
+
+type altSvcEntry struct {
+	port    int
+	expires time.Time
+	broken  time.Time // 在此之前不使用 HTTP/3
+}
+
+var defaultAltSvcCache = &AltSvcCache{}
+
+// Observe 记录 origin 的 Alt-Svc 响应头, 只保留与源主机相同的 h3 端点
+func (c *AltSvcCache) Observe(origin, header string, now time.Time) {
+	if header == "" {
+		return
+	}
+	alts, clear := ParseAltSvc(header)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if clear {
+		delete(c.entries, origin)
+		return
+	}
+	for _, alt := range alts {
+		if alt.Protocol != "h3" || alt.Host != "" {
+			continue
+		}
+		if c.entries == nil {
+			c.entries = map[string]*altSvcEntry{}
+		}
+		e := c.entries[origin]
+		if e == nil {
+			e = &altSvcEntry{}
+			c.entries[origin] = e
+		}
+		e.port, e.expires = alt.Port, now.Add(alt.MaxAge)
+		return
+	}
+}
+
+// HTTP3Port 返回 origin 可用的 HTTP/3 端口, 没有声明, 已经过期或暂停使用时返回 false
+func (c *AltSvcCache) HTTP3Port(origin string, now time.Time) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[origin]
+	if e == nil || !now.Before(e.expires) || now.Before(e.broken) {
+		return 0, false
+	}
+	return e.port, true
+}
+
+// MarkBroken 在 d 时间内不再对 origin 使用 HTTP/3
+func (c *AltSvcCache) MarkBroken(origin string, now time.Time, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[origin]; e != nil {
+		e.broken = now.Add(d)
+	}
+}
+
+// AdvertiseHTTP3 在 HTTP/1.1 与 HTTP/2 的响应中加上 Alt-Svc: h3=":port", 告知客户端可以改用 HTTP/3.
+// port 为 DNSServer.ServeHTTP3 使用的 udp 端口
+func AdvertiseHTTP3(h http.Handler, port int, maxAge time.Duration) http.Handler {
+	if maxAge <= 0 {
+		maxAge = defaultAltSvcMaxAge
+	}
+	value := `h3=":` + strconv.Itoa(port) + `"; ma=` + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", value)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// HTTP3Server HTTP/3 (QUIC) 的服务端实现, 与 HTTPSTransport.HTTP3 对应, 例如包装 quic-go 的 http3.Server:
+// 在 conn 上用 h 处理请求, 直到 conn 出错或关闭
+type HTTP3Server interface {
+	Serve(conn net.PacketConn, h http.Handler) error
+}
+
+// HTTP3ServerFunc 把函数转换为 HTTP3Server
+type HTTP3ServerFunc func(conn net.PacketConn, h http.Handler) error
+
+func (f HTTP3ServerFunc) Serve(conn net.PacketConn, h http.Handler) error {
+	return f(conn, h)
+}
+
+// ServeHTTP3 通过 srv 在 conn 上提供 HTTP/3 的 DNS over HTTPS, 请求与 ServeHTTP 相同.
+// 与 ServeUDP 一样, Shutdown 时 conn 停止读取并最终关闭, 之后返回 ErrServerClosed.
+// HTTP/1.1 与 HTTP/2 的服务应当用 AdvertiseHTTP3 声明 conn 的端口, 客户端才会改用 HTTP/3
+func (s *DNSServer) ServeHTTP3(conn net.PacketConn, srv HTTP3Server) error {
+	if !s.track(func() { s.packets[conn] = true }) {
+		return ErrServerClosed
+	}
+	defer s.untrack(func() {
+		if !s.closing {
+			delete(s.packets, conn)
+		}
+	})
+	err := srv.Serve(conn, s)
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAltSvc(t *testing.T) {
+	alts, clear := ParseAltSvc(`h3=":8443"; ma=60, h2="alt.example:443", h3-29=":443"; persist=1, bad`)
+	if clear || len(alts) != 3 {
+		t.Fatalf("alts = %+v", alts)
+	}
+	if a := alts[0]; a.Protocol != "h3" || a.Host != "" || a.Port != 8443 || a.MaxAge != time.Minute {
+		t.Fatalf("h3 = %+v", a)
+	}
+	if a := alts[1]; a.Host != "alt.example" || a.MaxAge != defaultAltSvcMaxAge {
+		t.Fatalf("h2 = %+v", a)
+	}
+	if _, clear := ParseAltSvc(" clear"); !clear {
+		t.Fatal("clear")
+	}
+}
+
+// fakeHTTP3 代替 QUIC 实现, 直接交给 handler 处理并标记为 HTTP/3
+type fakeHTTP3 struct {
+	handler http.Handler
+	fail    bool
+	status  int // 不为 0 时直接返回该状态码
+	hosts   []string
+}
+
+func (f *fakeHTTP3) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.hosts = append(f.hosts, r.URL.Host)
+	if f.fail {
+		return nil, errors.New("quic handshake timeout")
+	}
+	if f.status != 0 {
+		w := httptest.NewRecorder()
+		w.WriteHeader(f.status)
+		return w.Result(), nil
+	}
+	r.ProtoMajor, r.Proto = 3, "HTTP/3.0"
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	return w.Result(), nil
+}
+
+func TestHTTPSTransportHTTP3(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := AdvertiseHTTP3(&DNSServer{Handler: z}, 8853, time.Hour)
+	srv := httptest.NewTLSServer(h)
+	defer srv.Close()
+
+	h3 := &fakeHTTP3{handler: h}
+	cache := &AltSvcCache{}
+	r := &Resolver{Server: srv.URL + "/dns-query", Transport: HTTPSTransport{Client: srv.Client(), HTTP3: h3, AltSvc: cache}}
+	lookup := func() {
+		t.Helper()
+		if ips, err := r.LookupHost(context.Background(), "www.example"); err != nil || len(ips) != 1 {
+			t.Fatalf("LookupHost = %v, %v", ips, err)
+		}
+	}
+	// 第一次通过 HTTP/2 得到 Alt-Svc, 之后使用 HTTP/3
+	lookup()
+	if len(h3.hosts) != 0 {
+		t.Fatal("http/3 used before alt-svc")
+	}
+	lookup()
+	if len(h3.hosts) != 1 || !strings.HasSuffix(h3.hosts[0], ":8853") {
+		t.Fatalf("http/3 hosts = %v", h3.hosts)
+	}
+	// HTTP/3 应答 5xx 时同样退回并暂停使用
+	origin := "https://" + srv.Listener.Addr().String()
+	h3.status = http.StatusServiceUnavailable
+	lookup()
+	lookup()
+	if len(h3.hosts) != 2 {
+		t.Fatalf("http/3 retried after 5xx: %v", h3.hosts)
+	}
+	cache.MarkBroken(origin, time.Now(), 0)
+	h3.status = 0
+	// HTTP/3 失败时退回并暂停使用
+	h3.fail = true
+	lookup()
+	lookup()
+	if len(h3.hosts) != 3 {
+		t.Fatalf("http/3 retried while broken: %v", h3.hosts)
+	}
+	if _, ok := cache.HTTP3Port(origin, time.Now().Add(altSvcBrokenTime+time.Second)); !ok {
+		t.Fatal("http/3 not retried after the broken period")
+	}
+	cache.Observe(origin, "clear", time.Now())
+	if _, ok := cache.HTTP3Port(origin, time.Now().Add(altSvcBrokenTime+time.Second)); ok {
+		t.Fatal("alt-svc clear ignored")
+	}
+}
+
+func TestDNSServerServeHTTP3(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	s := &DNSServer{Handler: z}
+	// 代替 QUIC 实现: 交出 handler 后像真正的服务一样读取 conn 直到出错
+	handlers := make(chan http.Handler, 1)
+	srv := HTTP3ServerFunc(func(conn net.PacketConn, h http.Handler) error {
+		handlers <- h
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return err
+			}
+		}
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.ServeHTTP3(conn, srv) }()
+	h3 := &fakeHTTP3{handler: <-handlers}
+
+	web := httptest.NewTLSServer(AdvertiseHTTP3(s, port, time.Hour))
+	defer web.Close()
+	r := &Resolver{Server: web.URL + "/dns-query", Transport: HTTPSTransport{Client: web.Client(), HTTP3: h3, AltSvc: &AltSvcCache{}}}
+	for i := 0; i < 2; i++ {
+		if ips, err := r.LookupHost(context.Background(), "www.example"); err != nil || len(ips) != 1 {
+			t.Fatalf("LookupHost = %v, %v", ips, err)
+		}
+	}
+	if len(h3.hosts) != 1 || !strings.HasSuffix(h3.hosts[0], ":"+strconv.Itoa(port)) {
+		t.Fatalf("http/3 hosts = %v", h3.hosts)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("ServeHTTP3 = %v", err)
+	}
+}
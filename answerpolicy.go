@@ -0,0 +1,145 @@
+package netx
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// AnswerPolicy 调整应答中 A/AAAA 记录的顺序或数量, addrs 可以直接修改
+type AnswerPolicy func(req *DNSRequest, addrs []*DNSResourceRecode) []*DNSResourceRecode
+
+// TargetStatus 提供目标地址的健康状态, 未知地址应视为健康
+type TargetStatus interface {
+	Healthy(addr string) bool
+}
+
+// TargetLatency 可选接口, 提供目标地址最近的延迟, 未知时返回 0
+type TargetLatency interface {
+	Latency(addr string) time.Duration
+}
+
+// WithAnswerPolicy 依次对响应回答部分中的 A/AAAA 记录应用 policies.
+// 其它记录 (如 CNAME) 保持原有顺序并排在地址记录之前
+func WithAnswerPolicy(policies ...AnswerPolicy) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp, err := next.ServeDNS(ctx, req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			applyAnswerPolicies(req, resp, policies)
+			return resp, nil
+		})
+	}
+}
+
+// applyAnswerPolicies 对 resp 回答部分中的 A/AAAA 记录应用 policies, 客户端使用时 req 为空
+func applyAnswerPolicies(req *DNSRequest, resp *DNSMessage, policies []AnswerPolicy) {
+	answers := resp.Answers()
+	var others, addrs []*DNSResourceRecode
+	for _, rr := range answers {
+		if rr.RRType == DNSTypeA || rr.RRType == DNSTypeAAAA {
+			addrs = append(addrs, rr)
+		} else {
+			others = append(others, rr)
+		}
+	}
+	if len(addrs) == 0 {
+		return
+	}
+	for _, policy := range policies {
+		addrs = policy(req, addrs)
+	}
+	rest := resp.ResourceRecodes[len(answers):]
+	records := make([]*DNSResourceRecode, 0, len(others)+len(addrs)+len(rest))
+	records = append(append(append(records, others...), addrs...), rest...)
+	resp.ResourceRecodes = records
+	resp.Header.AnswerRRs = uint16(len(others) + len(addrs))
+}
+
+// RoundRobin 每次应答将地址记录轮转一位
+func RoundRobin() AnswerPolicy {
+	var counter uint64
+	return func(req *DNSRequest, addrs []*DNSResourceRecode) []*DNSResourceRecode {
+		n := int(atomic.AddUint64(&counter, 1) % uint64(len(addrs)))
+		return append(addrs[n:len(addrs):len(addrs)], addrs[:n]...)
+	}
+}
+
+// WeightedAnswers 按权重不放回地随机选出 count 个地址, count 不大于 0 时返回全部地址并按权重排序.
+// weights 以 RData 中的地址为键, 未列出的地址权重为 1, 权重为 0 的地址只在没有其它地址时返回
+func WeightedAnswers(weights map[string]int, count int) AnswerPolicy {
+	return func(req *DNSRequest, addrs []*DNSResourceRecode) []*DNSResourceRecode {
+		var candidates, zero []*DNSResourceRecode
+		total := 0
+		for _, rr := range addrs {
+			w, ok := weights[rr.RData]
+			if !ok {
+				w = 1
+			}
+			if w <= 0 {
+				zero = append(zero, rr)
+				continue
+			}
+			candidates = append(candidates, rr)
+			total += w
+		}
+		if len(candidates) == 0 {
+			candidates = zero
+			zero = nil
+		}
+		if count <= 0 || count > len(candidates) {
+			count = len(candidates)
+		}
+		result := make([]*DNSResourceRecode, 0, len(addrs))
+		for len(result) < count && total > 0 {
+			n := rand.Intn(total)
+			for i, rr := range candidates {
+				w, ok := weights[rr.RData]
+				if !ok {
+					w = 1
+				}
+				if n -= w; n < 0 {
+					result = append(result, rr)
+					candidates = append(candidates[:i], candidates[i+1:]...)
+					total -= w
+					break
+				}
+			}
+		}
+		// 权重全为 0 时按原顺序
+		for i := 0; len(result) < count; i++ {
+			result = append(result, candidates[i])
+		}
+		return result
+	}
+}
+
+// FailoverAnswers 健康的地址排在前面, 同为健康时延迟低的在前 (status 实现 TargetLatency 时).
+// withhold 为 true 时去掉不健康的地址, 全部不健康时仍返回全部地址
+func FailoverAnswers(status TargetStatus, withhold bool) AnswerPolicy {
+	return func(req *DNSRequest, addrs []*DNSResourceRecode) []*DNSResourceRecode {
+		var healthy, unhealthy []*DNSResourceRecode
+		for _, rr := range addrs {
+			if status.Healthy(rr.RData) {
+				healthy = append(healthy, rr)
+			} else {
+				unhealthy = append(unhealthy, rr)
+			}
+		}
+		if latency, ok := status.(TargetLatency); ok {
+			sort.SliceStable(healthy, func(i, j int) bool {
+				a, b := latency.Latency(healthy[i].RData), latency.Latency(healthy[j].RData)
+				// 未知延迟排在已知延迟之后
+				return a > 0 && (b == 0 || a < b)
+			})
+		}
+		if len(healthy) == 0 || !withhold {
+			return append(healthy, unhealthy...)
+		}
+		return healthy
+	}
+}
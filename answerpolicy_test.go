@@ -0,0 +1,69 @@
+package netx
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mapStatus map[string]time.Duration // 负数表示不健康
+
+func (m mapStatus) Healthy(addr string) bool          { return m[addr] >= 0 }
+func (m mapStatus) Latency(addr string) time.Duration { return m[addr] }
+
+func TestAnswerPolicy(t *testing.T) {
+	static := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{
+			{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.1"},
+			{Name: "www.example.com", RRType: DNSTypeCName, Class: DNSClassIn, RData: "lb.example.com"},
+			{Name: "lb.example.com", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.2"},
+			{Name: "lb.example.com", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.3"},
+			{Name: "ns.example.com", RRType: DNSTypeA, Class: DNSClassIn, RData: "198.51.100.1"},
+		}
+		resp.Header.AnswerRRs, resp.Header.AdditionalRRs = 4, 1
+		return resp, nil
+	})
+	serve := func(h DNSHandler) string {
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example.com", DNSTypeA)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		for _, rr := range resp.Answers() {
+			result = append(result, rr.RData)
+		}
+		if len(resp.Additionals()) != 1 {
+			t.Fatalf("additionals = %v", resp.Additionals())
+		}
+		return strings.Join(result, " ")
+	}
+
+	h := ChainHandler(static, WithAnswerPolicy(RoundRobin()))
+	if got := serve(h); got != "lb.example.com 192.0.2.2 192.0.2.3 192.0.2.1" {
+		t.Fatalf("round robin 1 = %s", got)
+	}
+	if got := serve(h); got != "lb.example.com 192.0.2.3 192.0.2.1 192.0.2.2" {
+		t.Fatalf("round robin 2 = %s", got)
+	}
+
+	h = ChainHandler(static, WithAnswerPolicy(WeightedAnswers(map[string]int{"192.0.2.1": 0, "192.0.2.3": 0}, 1)))
+	for i := 0; i < 10; i++ {
+		if got := serve(h); got != "lb.example.com 192.0.2.2" {
+			t.Fatalf("weighted = %s", got)
+		}
+	}
+
+	status := mapStatus{"192.0.2.1": -1, "192.0.2.2": 30 * time.Millisecond, "192.0.2.3": 10 * time.Millisecond}
+	if got := serve(ChainHandler(static, WithAnswerPolicy(FailoverAnswers(status, false)))); got != "lb.example.com 192.0.2.3 192.0.2.2 192.0.2.1" {
+		t.Fatalf("failover = %s", got)
+	}
+	if got := serve(ChainHandler(static, WithAnswerPolicy(FailoverAnswers(status, true)))); got != "lb.example.com 192.0.2.3 192.0.2.2" {
+		t.Fatalf("withhold = %s", got)
+	}
+	down := mapStatus{"192.0.2.1": -1, "192.0.2.2": -1, "192.0.2.3": -1}
+	if got := serve(ChainHandler(static, WithAnswerPolicy(FailoverAnswers(down, true)))); got != "lb.example.com 192.0.2.1 192.0.2.2 192.0.2.3" {
+		t.Fatalf("all down = %s", got)
+	}
+}
@@ -0,0 +1,332 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	arpRequest   = 1
+	arpReply     = 2
+	etherTypeARP = 0x0806
+	arpFrameLen  = 42 // 以太网首部 14 + ARP 28
+)
+
+var (
+	ErrARPShort     = errors.New("arp frame too short")
+	ErrARPFormat    = errors.New("not an ethernet/ipv4 arp packet")
+	ErrARPNoAddress = errors.New("interface has no ipv4 address")
+	ErrARPTimeout   = errors.New("no arp reply")
+)
+
+// ARPPacket 以太网上的 ipv4 ARP 报文
+type ARPPacket struct {
+	Op        uint16
+	SenderMAC net.HardwareAddr
+	SenderIP  net.IP
+	TargetMAC net.HardwareAddr
+	TargetIP  net.IP
+}
+
+// ToFrame 编码为以太网帧, dst 为空时广播
+func (p *ARPPacket) ToFrame(dst net.HardwareAddr) []byte {
+	if dst == nil {
+		dst = ethernetBroadcast
+	}
+	b := make([]byte, arpFrameLen)
+	copy(b[0:6], dst)
+	copy(b[6:12], p.SenderMAC)
+	binary.BigEndian.PutUint16(b[12:14], etherTypeARP)
+	arp := b[14:]
+	binary.BigEndian.PutUint16(arp[0:2], 1) // ethernet
+	binary.BigEndian.PutUint16(arp[2:4], 0x0800)
+	arp[4], arp[5] = 6, 4
+	binary.BigEndian.PutUint16(arp[6:8], p.Op)
+	copy(arp[8:14], p.SenderMAC)
+	copy(arp[14:18], p.SenderIP.To4())
+	copy(arp[18:24], p.TargetMAC)
+	copy(arp[24:28], p.TargetIP.To4())
+	return b
+}
+
+// ParseARPFrame 解析包含以太网首部的 ARP 帧
+func ParseARPFrame(b []byte) (*ARPPacket, error) {
+	if len(b) < arpFrameLen {
+		return nil, ErrARPShort
+	}
+	arp := b[14:]
+	if binary.BigEndian.Uint16(b[12:14]) != etherTypeARP ||
+		binary.BigEndian.Uint16(arp[0:2]) != 1 || binary.BigEndian.Uint16(arp[2:4]) != 0x0800 ||
+		arp[4] != 6 || arp[5] != 4 {
+		return nil, ErrARPFormat
+	}
+	return &ARPPacket{
+		Op:        binary.BigEndian.Uint16(arp[6:8]),
+		SenderMAC: net.HardwareAddr(append([]byte(nil), arp[8:14]...)),
+		SenderIP:  net.IP(append([]byte(nil), arp[14:18]...)),
+		TargetMAC: net.HardwareAddr(append([]byte(nil), arp[18:24]...)),
+		TargetIP:  net.IP(append([]byte(nil), arp[24:28]...)),
+	}, nil
+}
+
+var ethernetBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// frameConn 收发原始以太网帧, 由各平台实现
+type frameConn interface {
+	ReadFrame(b []byte) (int, error)
+	WriteFrame(b []byte) error
+	Close() error
+}
+
+// LANHost 扫描发现的主机
+type LANHost struct {
+	IP     net.IP
+	MAC    net.HardwareAddr
+	Vendor string // 根据 OUI 得到的厂商, 未知时为空
+	RTT    time.Duration
+}
+
+// ARPClient 在一个网卡上收发 ARP, 目前只支持 Linux, 需要 raw socket 权限
+type ARPClient struct {
+	Interface *net.Interface
+	IP        net.IP        // 发送请求使用的源地址, 默认为网卡的第一个 ipv4 地址
+	Timeout   time.Duration // 单次等待应答的时间, 默认 1s
+	Retries   int           // 默认 3
+
+	conn frameConn
+	mu   sync.Mutex
+	subs map[chan *ARPPacket]struct{}
+}
+
+// NewARPClient 打开 ifi 上的 ARP socket
+func NewARPClient(ifi *net.Interface) (*ARPClient, error) {
+	conn, err := openFrameConn(ifi, etherTypeARP)
+	if err != nil {
+		return nil, errors.WithMessage(err, "open arp socket error")
+	}
+	c := &ARPClient{Interface: ifi, conn: conn, subs: map[chan *ARPPacket]struct{}{}}
+	if addrs, err := ifi.Addrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				c.IP = ipNet.IP.To4()
+				break
+			}
+		}
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *ARPClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *ARPClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return time.Second
+}
+
+func (c *ARPClient) retries() int {
+	if c.Retries > 0 {
+		return c.Retries
+	}
+	return 3
+}
+
+// read 将收到的每个 ARP 报文分发给所有订阅者, 订阅者来不及处理时丢弃
+func (c *ARPClient) read() {
+	buf := make([]byte, 1514)
+	for {
+		n, err := c.conn.ReadFrame(buf)
+		if err != nil {
+			return
+		}
+		p, err := ParseARPFrame(buf[:n])
+		if err != nil || bytes.Equal(p.SenderMAC, c.Interface.HardwareAddr) {
+			continue
+		}
+		c.mu.Lock()
+		for ch := range c.subs {
+			select {
+			case ch <- p:
+			default:
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *ARPClient) subscribe() chan *ARPPacket {
+	ch := make(chan *ARPPacket, 64)
+	c.mu.Lock()
+	c.subs[ch] = struct{}{}
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *ARPClient) unsubscribe(ch chan *ARPPacket) {
+	c.mu.Lock()
+	delete(c.subs, ch)
+	c.mu.Unlock()
+}
+
+func (c *ARPClient) request(sender, target net.IP) error {
+	p := &ARPPacket{
+		Op:        arpRequest,
+		SenderMAC: c.Interface.HardwareAddr,
+		SenderIP:  sender,
+		TargetMAC: make(net.HardwareAddr, 6),
+		TargetIP:  target,
+	}
+	return c.conn.WriteFrame(p.ToFrame(nil))
+}
+
+// Resolve 查询 ip 对应的 MAC 地址
+func (c *ARPClient) Resolve(ctx context.Context, ip net.IP) (net.HardwareAddr, error) {
+	if c.IP == nil {
+		return nil, ErrARPNoAddress
+	}
+	ch := c.subscribe()
+	defer c.unsubscribe(ch)
+	for attempt := 0; attempt < c.retries(); attempt++ {
+		if err := c.request(c.IP, ip); err != nil {
+			return nil, errors.WithMessage(err, "write arp error")
+		}
+		timer := time.NewTimer(c.timeout())
+	wait:
+		for {
+			select {
+			case p := <-ch:
+				if p.Op == arpReply && p.SenderIP.Equal(ip) {
+					timer.Stop()
+					return p.SenderMAC, nil
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return nil, ErrARPTimeout
+}
+
+// Probe 按 RFC 5227 检测 ip 是否已被占用, 探测报文的源地址为 0.0.0.0.
+// 返回占用该地址的 MAC 地址, 没有冲突时返回 nil
+func (c *ARPClient) Probe(ctx context.Context, ip net.IP) (net.HardwareAddr, error) {
+	ch := c.subscribe()
+	defer c.unsubscribe(ch)
+	for attempt := 0; attempt < c.retries(); attempt++ {
+		if err := c.request(net.IPv4zero, ip); err != nil {
+			return nil, errors.WithMessage(err, "write arp error")
+		}
+		timer := time.NewTimer(c.timeout())
+	wait:
+		for {
+			select {
+			case p := <-ch:
+				// 有主机在使用该地址, 或其他主机同时在探测该地址
+				if p.SenderIP.Equal(ip) ||
+					p.Op == arpRequest && p.SenderIP.Equal(net.IPv4zero) && p.TargetIP.Equal(ip) {
+					timer.Stop()
+					return p.SenderMAC, nil
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Sweep 向 subnet 中的每个地址发送 ARP 请求, 返回按地址排序的在线主机
+func (c *ARPClient) Sweep(ctx context.Context, subnet *net.IPNet) ([]*LANHost, error) {
+	if c.IP == nil {
+		return nil, ErrARPNoAddress
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits != 32 {
+		return nil, ErrARPFormat
+	}
+	if bits-ones > 16 {
+		return nil, errors.WithMessage(ErrScanTooWide, subnet.String())
+	}
+	ch := c.subscribe()
+	defer c.unsubscribe(ch)
+
+	hosts := map[string]*LANHost{}
+	sent := map[string]time.Time{}
+	collect := func(p *ARPPacket) {
+		key := p.SenderIP.String()
+		start, ok := sent[key]
+		if p.Op != arpReply || !ok || hosts[key] != nil {
+			return
+		}
+		hosts[key] = &LANHost{
+			IP:     p.SenderIP,
+			MAC:    p.SenderMAC,
+			Vendor: OUIVendor(p.SenderMAC),
+			RTT:    time.Since(start),
+		}
+	}
+
+	ip := subnet.IP.Mask(subnet.Mask).To4()
+	for i := 0; i < 1<<uint(bits-ones); i++ {
+		target := ip
+		ip = nextIP(ip)
+		// /31 与 /32 之外跳过网络地址与广播地址
+		if bits-ones > 1 && (i == 0 || i == 1<<uint(bits-ones)-1) || target.Equal(c.IP) {
+			continue
+		}
+		sent[target.String()] = time.Now()
+		if err := c.request(c.IP, target); err != nil {
+			return nil, errors.WithMessage(err, "write arp error")
+		}
+		// 每发送一个请求处理已到达的应答, 同时限制发送速率
+		drain := time.NewTimer(time.Millisecond)
+	pace:
+		for {
+			select {
+			case p := <-ch:
+				collect(p)
+			case <-drain.C:
+				break pace
+			case <-ctx.Done():
+				drain.Stop()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	timer := time.NewTimer(c.timeout())
+	defer timer.Stop()
+	for {
+		select {
+		case p := <-ch:
+			collect(p)
+		case <-timer.C:
+			result := make([]*LANHost, 0, len(hosts))
+			for _, h := range hosts {
+				result = append(result, h)
+			}
+			sort.Slice(result, func(i, j int) bool {
+				return bytes.Compare(result[i].IP.To4(), result[j].IP.To4()) < 0
+			})
+			return result, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package netx
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// packetConn AF_PACKET socket. 读取使用 200ms 的接收超时轮询,
+// 读写期间持有读锁, 保证 Close 之后 fd 不会被继续使用
+type packetConn struct {
+	fd      int
+	ifindex int
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func openFrameConn(ifi *net.Interface, etherType uint16) (frameConn, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(etherType)))
+	if err != nil {
+		return nil, err
+	}
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(etherType), Ifindex: ifi.Index}
+	if err := syscall.Bind(fd, addr); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	tv := syscall.Timeval{Usec: 200000}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return &packetConn{fd: fd, ifindex: ifi.Index}, nil
+}
+
+func (c *packetConn) ReadFrame(b []byte) (int, error) {
+	for {
+		c.mu.RLock()
+		if c.closed {
+			c.mu.RUnlock()
+			return 0, net.ErrClosed
+		}
+		n, _, err := syscall.Recvfrom(c.fd, b, 0)
+		c.mu.RUnlock()
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *packetConn) WriteFrame(b []byte) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return syscall.Sendto(c.fd, b, 0, &syscall.SockaddrLinklayer{Ifindex: c.ifindex})
+}
+
+func (c *packetConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return syscall.Close(c.fd)
+}
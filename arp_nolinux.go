@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package netx
+
+import (
+	"net"
+)
+
+func openFrameConn(ifi *net.Interface, etherType uint16) (frameConn, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,213 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestARPFrame(t *testing.T) {
+	mac, _ := net.ParseMAC("b8:27:eb:01:02:03")
+	p := &ARPPacket{
+		Op:        arpReply,
+		SenderMAC: mac,
+		SenderIP:  net.IPv4(192, 168, 1, 10),
+		TargetMAC: ethernetBroadcast,
+		TargetIP:  net.IPv4(192, 168, 1, 1),
+	}
+	got, err := ParseARPFrame(p.ToFrame(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Op != arpReply || got.SenderMAC.String() != mac.String() ||
+		!got.SenderIP.Equal(p.SenderIP) || !got.TargetIP.Equal(p.TargetIP) {
+		t.Fatalf("unexpected packet %+v", got)
+	}
+	if v := OUIVendor(got.SenderMAC); v != "Raspberry Pi" {
+		t.Fatalf("unexpected vendor %q", v)
+	}
+
+	err = LoadOUI(strings.NewReader("AC-DE-48   (hex)\t\tPrivate\nACDE48     (base 16)\t\tPrivate\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := OUIVendor(net.HardwareAddr{0xac, 0xde, 0x48, 0, 0, 1}); v != "Private" {
+		t.Fatalf("unexpected vendor %q", v)
+	}
+}
+
+func TestParseNeighborAdvertisement(t *testing.T) {
+	target := net.ParseIP("fe80::1")
+	b := append([]byte{136, 0, 0, 0, 0x60, 0, 0, 0}, target...)
+	b = append(b, ndOptTargetLinkAddr, 1, 0x52, 0x54, 0, 0x12, 0x34, 0x56)
+	mac := parseNeighborAdvertisement(b, target)
+	if mac.String() != "52:54:00:12:34:56" {
+		t.Fatalf("unexpected mac %v", mac)
+	}
+	if parseNeighborAdvertisement(b, net.ParseIP("fe80::2")) != nil {
+		t.Fatal("advertisement for another target accepted")
+	}
+}
+
+// fakeFrameConn 把写出的 ARP 请求交给 serve, 返回的报文作为收到的帧
+type fakeFrameConn struct {
+	serve  func(req *ARPPacket) []*ARPPacket
+	frames chan []byte
+
+	mu   sync.Mutex
+	sent []*ARPPacket
+}
+
+func (c *fakeFrameConn) ReadFrame(b []byte) (int, error) {
+	frame, ok := <-c.frames
+	if !ok {
+		return 0, net.ErrClosed
+	}
+	return copy(b, frame), nil
+}
+
+func (c *fakeFrameConn) WriteFrame(b []byte) error {
+	req, err := ParseARPFrame(b)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.sent = append(c.sent, req)
+	c.mu.Unlock()
+	for _, p := range c.serve(req) {
+		c.frames <- p.ToFrame(req.SenderMAC)
+	}
+	return nil
+}
+
+func (c *fakeFrameConn) Close() error {
+	close(c.frames)
+	return nil
+}
+
+func (c *fakeFrameConn) requests() []*ARPPacket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent
+}
+
+var testARPMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+// arpTestClient 返回地址为 192.168.1.1 的客户端, 请求由 serve 应答
+func arpTestClient(t *testing.T, serve func(req *ARPPacket) []*ARPPacket) (*ARPClient, *fakeFrameConn) {
+	conn := &fakeFrameConn{serve: serve, frames: make(chan []byte, 64)}
+	c := &ARPClient{
+		Interface: &net.Interface{Name: "test0", HardwareAddr: testARPMAC},
+		IP:        net.IPv4(192, 168, 1, 1).To4(),
+		Timeout:   20 * time.Millisecond,
+		Retries:   2,
+		conn:      conn,
+		subs:      map[chan *ARPPacket]struct{}{},
+	}
+	go c.read()
+	t.Cleanup(func() { _ = c.Close() })
+	return c, conn
+}
+
+// arpHost 返回 ip 的主机发出的 op 报文
+func arpHost(op uint16, ip net.IP, target net.IP) *ARPPacket {
+	return &ARPPacket{
+		Op:        op,
+		SenderMAC: net.HardwareAddr{0x02, 0, 0, 0, 1, ip.To4()[3]},
+		SenderIP:  ip,
+		TargetMAC: make(net.HardwareAddr, 6),
+		TargetIP:  target,
+	}
+}
+
+func TestARPResolve(t *testing.T) {
+	target, other := net.IPv4(192, 168, 1, 20), net.IPv4(192, 168, 1, 99)
+	c, conn := arpTestClient(t, func(req *ARPPacket) []*ARPPacket {
+		if !req.TargetIP.Equal(target) {
+			return nil
+		}
+		// 其他地址的应答, 目标自己发出的请求与本机的帧都不是要找的应答
+		echo := arpHost(arpReply, target, req.SenderIP)
+		echo.SenderMAC = testARPMAC
+		return []*ARPPacket{arpHost(arpReply, other, req.SenderIP), arpHost(arpRequest, target, req.SenderIP), echo,
+			arpHost(arpReply, target, req.SenderIP)}
+	})
+	mac, err := c.Resolve(context.Background(), target)
+	if err != nil || mac.String() != "02:00:00:00:01:14" {
+		t.Fatalf("resolve = %v, %v", mac, err)
+	}
+	if sent := conn.requests(); len(sent) != 1 || sent[0].Op != arpRequest || !sent[0].SenderIP.Equal(c.IP) || !sent[0].TargetIP.Equal(target) {
+		t.Fatalf("sent %+v", sent)
+	}
+
+	if _, err := c.Resolve(context.Background(), net.IPv4(192, 168, 1, 21)); err != ErrARPTimeout {
+		t.Fatalf("no reply = %v", err)
+	}
+	if sent := conn.requests(); len(sent) != 3 {
+		t.Fatalf("sent %d requests, want 1 + Retries", len(sent))
+	}
+}
+
+func TestARPProbe(t *testing.T) {
+	ip := net.IPv4(192, 168, 1, 50)
+	for _, tc := range []struct {
+		name     string
+		reply    *ARPPacket
+		conflict bool
+	}{
+		{"reply from the address", arpHost(arpReply, ip, net.IPv4zero), true},
+		{"another host probing", arpHost(arpRequest, net.IPv4zero, ip), true},
+		{"another host resolving", arpHost(arpRequest, net.IPv4(192, 168, 1, 7), ip), false},
+		{"reply from another address", arpHost(arpReply, net.IPv4(192, 168, 1, 51), net.IPv4zero), false},
+	} {
+		c, conn := arpTestClient(t, func(req *ARPPacket) []*ARPPacket {
+			return []*ARPPacket{tc.reply}
+		})
+		mac, err := c.Probe(context.Background(), ip)
+		if err != nil || (mac != nil) != tc.conflict || tc.conflict && mac.String() != tc.reply.SenderMAC.String() {
+			t.Fatalf("%s: probe = %v, %v", tc.name, mac, err)
+		}
+		if sent := conn.requests(); !sent[0].SenderIP.Equal(net.IPv4zero) || !sent[0].TargetIP.Equal(ip) {
+			t.Fatalf("%s: probe sent %+v", tc.name, sent[0])
+		}
+	}
+}
+
+func TestARPSweep(t *testing.T) {
+	c, conn := arpTestClient(t, func(req *ARPPacket) []*ARPPacket {
+		switch req.TargetIP.To4()[3] {
+		case 2:
+			// 子网外的主机主动发出的应答
+			return []*ARPPacket{arpHost(arpReply, req.TargetIP, req.SenderIP), arpHost(arpReply, net.IPv4(192, 168, 2, 5), req.SenderIP)}
+		case 5:
+			return []*ARPPacket{arpHost(arpReply, req.TargetIP, req.SenderIP), arpHost(arpReply, req.TargetIP, req.SenderIP)}
+		}
+		return nil
+	})
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/29")
+	hosts, err := c.Sweep(context.Background(), subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || !hosts[0].IP.Equal(net.IPv4(192, 168, 1, 2)) || !hosts[1].IP.Equal(net.IPv4(192, 168, 1, 5)) ||
+		hosts[1].MAC.String() != "02:00:00:00:01:05" {
+		t.Fatalf("hosts %+v", hosts)
+	}
+	// 跳过网络地址, 广播地址与本机地址
+	var targets []string
+	for _, req := range conn.requests() {
+		targets = append(targets, req.TargetIP.String())
+	}
+	if strings.Join(targets, " ") != "192.168.1.2 192.168.1.3 192.168.1.4 192.168.1.5 192.168.1.6" {
+		t.Fatalf("requests to %v", targets)
+	}
+
+	_, wide, _ := net.ParseCIDR("10.0.0.0/8")
+	if _, err := c.Sweep(context.Background(), wide); errors.Cause(err) != ErrScanTooWide {
+		t.Fatalf("wide subnet = %v", err)
+	}
+}
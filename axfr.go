@@ -0,0 +1,95 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+var ErrBadTransfer = errors.New("malformed zone transfer")
+
+// TransferZone 通过 AXFR (RFC 5936) 从 Server 取得整个区域. Transport 为 TCPTransport 时使用它的 Dialer,
+// 因此可以经 WithTLS 完成 XoT. Timeout 作为每条消息的读取超时
+func (r *Resolver) TransferZone(ctx context.Context, zone string) (*Zone, error) {
+	var dialer Dialer = &net.Dialer{}
+	if t, ok := r.Transport.(TCPTransport); ok && t.Dialer != nil {
+		dialer = t.Dialer
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	conn, err := dialer.DialContext(dialCtx, "tcp", r.Server)
+	cancel()
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+
+	req := NewQuery(zone, DNSTypeAXFR)
+	req.Header.Flags.RD = 0
+	toByte, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append([]byte{byte(len(toByte) >> 8), byte(len(toByte))}, toByte...)); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+
+	z := NewZone(zone)
+	soas := 0
+	for soas < 2 {
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithMessage(err, "set deadline error")
+		}
+		resp, err := readTCPMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Header.TxID != req.Header.TxID {
+			return nil, ErrTxIDMismatch
+		}
+		if resp.Header.Flags.RCode != DNSRCodeSuccess {
+			return nil, &RCodeError{RCode: resp.Header.Flags.RCode}
+		}
+		answers := resp.Answers()
+		if len(answers) == 0 {
+			return nil, errors.WithMessage(ErrBadTransfer, "empty message")
+		}
+		for _, rr := range answers {
+			if rr.RRType == DNSTypeSOA {
+				soas++
+				// 第二个 SOA 标志传输结束, 不重复加入
+				if soas == 2 {
+					break
+				}
+			} else if soas == 0 {
+				return nil, errors.WithMessage(ErrBadTransfer, "first record is not SOA")
+			}
+			if err := z.Add(rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return z, nil
+}
+
+// readTCPMessage 读取一条带两字节长度前缀的消息
+func readTCPMessage(conn net.Conn) (*DNSMessage, error) {
+	size := make([]byte, 2)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	buf := make([]byte, int(size[0])<<8|int(size[1]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	return Unpack(buf)
+}
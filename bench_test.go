@@ -0,0 +1,131 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// 端到端的基准, 与 dns_test.go, dnsparser_test.go 中的编解码基准一起构成性能基线,
+// 基线数据见 testdata/bench/baseline.txt, 用 cmd/netxbench 比较两次运行的结果
+
+// compressedMessage 所有名字都用压缩指针指向问题的响应, 与常见的递归服务器输出相同
+func compressedMessage() []byte {
+	msg := &DNSMessage{
+		Header:    &DNSHeader{TxID: 1, Flags: &DNSFlags{QR: 1, RD: 1, RA: 1}, Questions: 1},
+		Questions: []*DNSQuestion{{QuestionName: "www.example.com", QuestionType: DNSTypeA, QuestionClass: DNSClassIn}},
+	}
+	for i := 0; i < 16; i++ {
+		msg.ResourceRecodes = append(msg.ResourceRecodes, &DNSResourceRecode{NamePos: 12, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2." + string(rune('1'+i%9))})
+	}
+	msg.Header.AnswerRRs = uint16(len(msg.ResourceRecodes))
+	packet, _ := msg.ToByte()
+	return packet
+}
+
+func benchZone(b *testing.B) *Zone {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return z
+}
+
+func BenchmarkQueryToByte(b *testing.B) {
+	q := NewQuery("www.example.com", DNSTypeAAAA, WithDNSSECOK())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := q.ToByte(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnpackCompressed(b *testing.B) {
+	packet := compressedMessage()
+	b.SetBytes(int64(len(packet)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg, err := UnpackPooled(packet)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msg.Release()
+	}
+}
+
+func BenchmarkCacheHit(b *testing.B) {
+	h := WithCache(&DNSCache{})(benchZone(b))
+	req := &DNSRequest{Message: NewQuery("www.example", DNSTypeA), Network: "udp"}
+	if _, err := h.ServeDNS(context.Background(), req); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp, err := h.ServeDNS(context.Background(), req); err != nil || len(resp.Answers()) != 1 {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkServe 服务器处理一个报文: 解析, 处理与编码, 不经过 socket
+func BenchmarkServe(b *testing.B) {
+	s := &DNSServer{Handler: benchZone(b)}
+	h := s.handler()
+	packet, _ := NewQuery("www.example", DNSTypeA).ToByte()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if resp := s.serve(h, packet, "udp", nil, nil); resp == nil {
+			b.Fatal("no response")
+		}
+	}
+}
+
+func BenchmarkUDPRoundTrip(b *testing.B) {
+	udp, _ := startDNSServer(b, &DNSServer{Handler: benchZone(b)})
+	r := &Resolver{Server: udp}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := r.Query(context.Background(), "www.example", DNSTypeA)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp.Release()
+	}
+}
+
+func BenchmarkUDPRoundTripParallel(b *testing.B) {
+	udp, _ := startDNSServer(b, &DNSServer{Handler: benchZone(b)})
+	r := &Resolver{Server: udp}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := r.Query(context.Background(), "www.example", DNSTypeA)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			resp.Release()
+		}
+	})
+}
+
+func BenchmarkTCPRoundTrip(b *testing.B) {
+	_, tcp := startDNSServer(b, &DNSServer{Handler: benchZone(b)})
+	// 默认的健康检查每次取出连接最多等待 1ms, 会掩盖传输本身的开销
+	pool := &ConnPool{HealthCheck: func(net.Conn) error { return nil }}
+	r := &Resolver{Server: tcp, Transport: TCPTransport{Pool: pool}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := r.Query(context.Background(), "www.example", DNSTypeA)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp.Release()
+	}
+}
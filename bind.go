@@ -0,0 +1,179 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// Binding 把 socket 绑定到本机的源地址或网卡, 用于多网卡主机与 VPN: 例如查询只从 VPN 网卡发出,
+// 或者服务器只接收某个网卡上的请求. Interface 在 Linux 上使用 SO_BINDTODEVICE (需要 CAP_NET_RAW),
+// 其它系统上改为使用该网卡上同一地址族的地址作为源地址或监听地址. 连接组播地址时还用该网卡发送组播.
+// 空的 Binding 与 nil 不做任何绑定
+type Binding struct {
+	// LocalAddr 源地址, 不含端口
+	LocalAddr netip.Addr
+	// Interface 网卡名, 例如 eth0 或 wg0
+	Interface string
+}
+
+// control 在 socket 创建后, bind 与 connect 之前执行
+func (b *Binding) control(network, address string, raw syscall.RawConn) error {
+	if b == nil || b.Interface == "" || !bindToDeviceSupported {
+		return nil
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = setSockoptBindToDevice(fd, b.Interface)
+	}); err != nil {
+		return err
+	}
+	return errors.WithMessage(serr, "bind to device "+b.Interface)
+}
+
+// localAddr 返回用于 network 与 host (目标或监听地址) 的本地地址, 不需要时无效
+func (b *Binding) localAddr(network, host string) (netip.Addr, error) {
+	if b == nil {
+		return netip.Addr{}, nil
+	}
+	if b.LocalAddr.IsValid() || b.Interface == "" || bindToDeviceSupported {
+		return b.LocalAddr, nil
+	}
+	ifi, err := net.InterfaceByName(b.Interface)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	want6 := strings.HasSuffix(network, "6")
+	if ip, err := netip.ParseAddr(host); err == nil && !ip.IsUnspecified() {
+		want6 = !ip.Unmap().Is4()
+	}
+	var linkLocal netip.Addr
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipnet.IP)
+		if !ok || ip.Unmap().Is4() == want6 {
+			continue
+		}
+		ip = ip.Unmap()
+		if !ip.IsLinkLocalUnicast() {
+			return ip, nil
+		}
+		if !linkLocal.IsValid() {
+			linkLocal = ip.WithZone(ifi.Name)
+		}
+	}
+	if linkLocal.IsValid() {
+		return linkLocal, nil
+	}
+	return netip.Addr{}, errors.WithMessage(ErrNoAddress, "interface "+b.Interface)
+}
+
+// Dialer 返回绑定后的 Dialer, 可以用于 UDPTransport, TCPTransport 与 HTTPDialer 等. unix socket 不受影响
+func (b *Binding) Dialer() Dialer {
+	return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := &net.Dialer{}
+		if strings.HasPrefix(network, "unix") {
+			return d.DialContext(ctx, network, address)
+		}
+		d.Control = b.control
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		local, err := b.localAddr(network, host)
+		if err != nil {
+			return nil, err
+		}
+		if local.IsValid() {
+			ip := net.IP(local.AsSlice())
+			if strings.HasPrefix(network, "udp") {
+				d.LocalAddr = &net.UDPAddr{IP: ip, Zone: local.Zone()}
+			} else {
+				d.LocalAddr = &net.TCPAddr{IP: ip, Zone: local.Zone()}
+			}
+		}
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.multicastInterface(conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+}
+
+// multicastInterface 连接的是组播地址时指定发送的网卡
+func (b *Binding) multicastInterface(conn net.Conn) error {
+	udp, ok := conn.(*net.UDPConn)
+	if b == nil || b.Interface == "" || !ok {
+		return nil
+	}
+	remote, ok := udp.RemoteAddr().(*net.UDPAddr)
+	if !ok || !remote.IP.IsMulticast() {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(b.Interface)
+	if err != nil {
+		return err
+	}
+	if remote.IP.To4() != nil {
+		return ipv4.NewPacketConn(udp).SetMulticastInterface(ifi)
+	}
+	return ipv6.NewPacketConn(udp).SetMulticastInterface(ifi)
+}
+
+// listenAddress 返回实际监听的地址: 没有指定主机时使用 LocalAddr 或网卡的地址
+func (b *Binding) listenAddress(network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || b == nil {
+		return address, nil
+	}
+	if ip, err := netip.ParseAddr(host); host != "" && (err != nil || !ip.IsUnspecified()) {
+		return address, nil
+	}
+	local, err := b.localAddr(network, host)
+	if err != nil || !local.IsValid() {
+		return address, err
+	}
+	return net.JoinHostPort(local.String(), port), nil
+}
+
+// ListenConfig 返回绑定网卡的 net.ListenConfig, 监听地址需要另外处理, 见 Listen
+func (b *Binding) ListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{Control: b.control}
+}
+
+// Listen 在 address 上监听 tcp, 主机为空或未指定时使用 LocalAddr 或网卡的地址
+func (b *Binding) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	if strings.HasPrefix(network, "unix") {
+		return (&net.ListenConfig{}).Listen(ctx, network, address)
+	}
+	address, err := b.listenAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return b.ListenConfig().Listen(ctx, network, address)
+}
+
+// ListenPacket 与 Listen 相同, 用于 udp
+func (b *Binding) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	address, err := b.listenAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return b.ListenConfig().ListenPacket(ctx, network, address)
+}
@@ -0,0 +1,81 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+)
+
+func loopbackInterface(t *testing.T) string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestBinding(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to loopback on linux")
+	}
+	clients := make(chan string, 4)
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		clients <- req.ClientIP().String()
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, "192.0.2.1")}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+
+	// 服务器绑定到回环网卡, 监听所有地址
+	lo := loopbackInterface(t)
+	b := &Binding{Interface: lo}
+	conn, err := b.ListenPacket(context.Background(), "udp4", ":0")
+	if err != nil {
+		t.Skip(err) // 没有 CAP_NET_RAW
+	}
+	defer conn.Close()
+	go s.ServeUDP(conn)
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	server := net.JoinHostPort("127.0.0.1", port)
+
+	client := &Binding{LocalAddr: netip.MustParseAddr("127.0.0.2"), Interface: lo}
+	r := &Resolver{Server: server, Transport: UDPTransport{Dialer: client.Dialer()}}
+	if _, err := r.LookupHost(context.Background(), "bound.example"); err != nil {
+		t.Fatal(err)
+	}
+	if ip := <-clients; ip != "127.0.0.2" {
+		t.Fatalf("query came from %s", ip)
+	}
+
+	// 配置文件中的转发源地址
+	c, err := ParseServerConfig([]byte("forwarders: [{servers: ["+server+"], source: 127.0.0.3, interface: "+lo+"}]"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example", DNSTypeA), Network: "udp"}); err != nil {
+		t.Fatal(err)
+	}
+	if ip := <-clients; ip != "127.0.0.3" {
+		t.Fatalf("forwarded query came from %s", ip)
+	}
+	c, err = ParseServerConfig([]byte("forwarders: [{servers: [192.0.2.1], source: bad}]"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Handler(); err == nil {
+		t.Fatal("bad source address accepted")
+	}
+}
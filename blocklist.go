@@ -0,0 +1,277 @@
+package netx
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// NameMatcher 判断名字是否被拦截, DomainBlocklist, CompiledBlocklist 与 AtomicBlocklist 都实现了它
+type NameMatcher interface {
+	Blocked(name string) bool
+}
+
+// BlocklistCompiler 合并多个黑名单来源与白名单, 编译为不可变的 CompiledBlocklist. 每行一条规则:
+//
+//	0.0.0.0 ads.example.com    hosts 文件格式, 只拦截名字本身, 一行可以有多个名字
+//	ads.example.com            名字本身及其所有子域名
+//	||ads.example.com^         同上, adblock 格式
+//	*.ads.example.com          只拦截子域名
+//	@@||cdn.example.com^       白名单, 也可以通过 Allow 添加
+//
+// # 与 ! 开头的行是注释. 白名单优先于黑名单. 不能并发使用
+type BlocklistCompiler struct {
+	block blockRules
+	allow blockRules
+	// Invalid 无法识别的行数
+	Invalid int
+}
+
+// blockRules 按类型存放规则, 键为规范化的名字
+type blockRules struct {
+	exact    map[string]bool
+	subtree  map[string]bool
+	wildcard map[string]bool
+}
+
+func (r *blockRules) add(kind byte, name string) {
+	if r.exact == nil {
+		r.exact, r.subtree, r.wildcard = map[string]bool{}, map[string]bool{}, map[string]bool{}
+	}
+	switch kind {
+	case '=':
+		r.exact[name] = true
+	case '*':
+		r.wildcard[name] = true
+	default:
+		r.subtree[name] = true
+	}
+}
+
+// hostsIgnored hosts 文件中常见的本机条目, 不作为拦截规则
+var hostsIgnored = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// AddSource 逐行读取一个来源
+func (c *BlocklistCompiler) AddSource(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		c.AddRule(s.Text())
+	}
+	return s.Err()
+}
+
+// AddRule 添加一行规则, 格式见 BlocklistCompiler
+func (c *BlocklistCompiler) AddRule(line string) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '!' {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		if net.ParseIP(fields[0]) == nil {
+			c.Invalid++
+			return
+		}
+		for _, name := range fields[1:] {
+			if name = normalizeDomain(name); !hostsIgnored[name] && validRuleName(name) {
+				c.block.add('=', name)
+			}
+		}
+		return
+	}
+	rules := &c.block
+	if strings.HasPrefix(line, "@@") {
+		rules, line = &c.allow, line[2:]
+	}
+	if !c.addTo(rules, line) {
+		c.Invalid++
+	}
+}
+
+// Allow 添加白名单规则, 格式与黑名单相同 (不含 hosts 格式)
+func (c *BlocklistCompiler) Allow(rules ...string) {
+	for _, line := range rules {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		if !c.addTo(&c.allow, strings.TrimPrefix(line, "@@")) {
+			c.Invalid++
+		}
+	}
+}
+
+func (c *BlocklistCompiler) addTo(rules *blockRules, rule string) bool {
+	kind := byte(0)
+	switch {
+	case strings.HasPrefix(rule, "||"):
+		rule = strings.TrimSuffix(rule[2:], "^")
+	case strings.HasPrefix(rule, "*."):
+		kind, rule = '*', rule[2:]
+	}
+	name := normalizeDomain(rule)
+	if !validRuleName(name) {
+		return false
+	}
+	rules.add(kind, name)
+	return true
+}
+
+func validRuleName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.ContainsAny(label, "/^|*@ \t") {
+			return false
+		}
+	}
+	return true
+}
+
+// Compile 生成匹配器. 被其它规则覆盖的规则在此时去掉, Len 为去重后的规则数
+func (c *BlocklistCompiler) Compile() *CompiledBlocklist {
+	b := &CompiledBlocklist{block: newSuffixTrie(&c.block), allow: newSuffixTrie(&c.allow)}
+	b.n = b.block.n
+	return b
+}
+
+// CompiledBlocklist 编译后的黑名单: 精确规则用哈希表, 子域名规则用按标签倒序的后缀树. 不可修改, 可以并发查询
+type CompiledBlocklist struct {
+	block *suffixTrie
+	allow *suffixTrie
+	n     int
+}
+
+// Len 去重后的黑名单规则数
+func (b *CompiledBlocklist) Len() int {
+	return b.n
+}
+
+// Blocked name 是否被黑名单命中且没有被白名单放行
+func (b *CompiledBlocklist) Blocked(name string) bool {
+	name = normalizeDomain(name)
+	return b.block.match(name) && !b.allow.match(name)
+}
+
+type suffixTrie struct {
+	exact map[string]bool
+	root  *trieNode
+	n     int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	subtree  bool // 名字本身及子域名
+	wildcard bool // 只有子域名
+}
+
+func newSuffixTrie(r *blockRules) *suffixTrie {
+	t := &suffixTrie{exact: map[string]bool{}, root: &trieNode{}}
+	// 先插入覆盖范围大的规则, 之后被覆盖的规则直接跳过
+	for name := range r.subtree {
+		t.insert(name, true)
+	}
+	for name := range r.wildcard {
+		t.insert(name, false)
+	}
+	for name := range r.exact {
+		if !t.match(name) {
+			t.exact[name] = true
+			t.n++
+		}
+	}
+	return t
+}
+
+// insert 插入子域名规则. 已被上级规则覆盖时跳过, 否则去掉其下已有的规则
+func (t *suffixTrie) insert(name string, subtree bool) {
+	labels := strings.Split(name, ".")
+	node := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		child := node.children[labels[i]]
+		if child == nil {
+			if node.children == nil {
+				node.children = map[string]*trieNode{}
+			}
+			child = &trieNode{}
+			node.children[labels[i]] = child
+		}
+		if i > 0 && (child.subtree || child.wildcard) {
+			return
+		}
+		node = child
+	}
+	if node.subtree || node.wildcard && !subtree {
+		return
+	}
+	if node.wildcard {
+		t.n--
+	}
+	t.n += 1 - node.count()
+	node.children = nil
+	node.subtree, node.wildcard = subtree, !subtree
+}
+
+// count 节点之下 (不含自身) 的规则数
+func (n *trieNode) count() int {
+	c := 0
+	for _, child := range n.children {
+		if child.subtree || child.wildcard {
+			c++
+		}
+		c += child.count()
+	}
+	return c
+}
+
+func (t *suffixTrie) match(name string) bool {
+	if t.exact[name] {
+		return true
+	}
+	node := t.root
+	for rest := name; ; {
+		i := strings.LastIndexByte(rest, '.')
+		node = node.children[rest[i+1:]]
+		if node == nil {
+			return false
+		}
+		if i < 0 {
+			return node.subtree
+		}
+		if node.subtree || node.wildcard {
+			return true
+		}
+		rest = rest[:i]
+	}
+}
+
+// AtomicBlocklist 可以在查询的同时整体替换的黑名单, 用于定时重新编译远程来源. 零值不拦截任何名字
+type AtomicBlocklist struct {
+	v atomic.Value // *CompiledBlocklist
+}
+
+// Store 替换当前的黑名单
+func (a *AtomicBlocklist) Store(b *CompiledBlocklist) {
+	a.v.Store(b)
+}
+
+// Load 当前的黑名单, 没有时返回空
+func (a *AtomicBlocklist) Load() *CompiledBlocklist {
+	b, _ := a.v.Load().(*CompiledBlocklist)
+	return b
+}
+
+func (a *AtomicBlocklist) Blocked(name string) bool {
+	b := a.Load()
+	return b != nil && b.Blocked(name)
+}
@@ -0,0 +1,75 @@
+package netx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlocklistCompiler(t *testing.T) {
+	c := &BlocklistCompiler{}
+	hosts := `# hosts
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # inline
+0.0.0.0 x.track.example.net
+:: ipv6.example.org
+`
+	list := `! adblock
+||track.example.net^
+*.cdn.example.org
+cdn.example.org
+||sub.track.example.net^
+@@||good.track.example.net^
+not a rule
+`
+	if err := c.AddSource(strings.NewReader(hosts)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddSource(strings.NewReader(list)); err != nil {
+		t.Fatal(err)
+	}
+	c.Allow("ads.example.com")
+	if c.Invalid != 1 {
+		t.Fatalf("Invalid = %d", c.Invalid)
+	}
+	b := c.Compile()
+	// x.track 与 sub.track 被 track.example.net 覆盖, *.cdn 被 cdn.example.org 覆盖
+	if b.Len() != 5 {
+		t.Fatalf("Len = %d", b.Len())
+	}
+	for name, want := range map[string]bool{
+		"ads.example.com":          false,
+		"tracker.example.com":      true,
+		"a.tracker.example.com":    false,
+		"TRACK.example.net.":       true,
+		"a.b.track.example.net":    true,
+		"good.track.example.net":   false,
+		"x.good.track.example.net": false,
+		"cdn.example.org":          true,
+		"img.cdn.example.org":      true,
+		"ipv6.example.org":         true,
+		"localhost":                false,
+		"example.net":              false,
+		"nottrack.example.net":     false,
+	} {
+		if got := b.Blocked(name); got != want {
+			t.Fatalf("Blocked(%s) = %v", name, got)
+		}
+	}
+
+	w := &BlocklistCompiler{}
+	w.AddRule("*.example.com")
+	w.AddRule("a.b.example.com")
+	wb := w.Compile()
+	if wb.Len() != 1 || wb.Blocked("example.com") || !wb.Blocked("a.example.com") {
+		t.Fatalf("wildcard Len = %d", wb.Len())
+	}
+
+	var a AtomicBlocklist
+	if a.Blocked("ads.example.com") {
+		t.Fatal("empty AtomicBlocklist blocked")
+	}
+	a.Store(wb)
+	if !a.Blocked("x.example.com") {
+		t.Fatal("stored blocklist not used")
+	}
+}
@@ -0,0 +1,268 @@
+package netx
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCacheEntries = 10000
+
+// DNSCache 服务器响应缓存, 零值可用, 可以并发使用.
+// 缓存按问题, RD/CD/DO 位, 是否带 EDNS 与 ECS 区分, 但不区分客户端, 与 ViewHandler 或 WithGeoAnswers
+// 一起使用时应放在视图内部
+type DNSCache struct {
+	// MaxEntries 最多缓存的响应数, 默认 10000, 超出时淘汰最早过期的一半
+	MaxEntries int
+	// MinTTL MaxTTL 限制缓存时间, MaxTTL 为 0 时不限制
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+// cacheKey 影响应答内容的请求字段, 与 coalesceKey 相同: CD 位不同的请求分开缓存,
+// 未经验证的应答因此不会给要求验证的客户端
+type cacheKey struct {
+	name         string
+	qtype, class uint16
+	rd, cd, do   bool
+	clientSubnet string
+	edns         bool
+}
+
+type cacheEntry struct {
+	packet  []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// WithCache 缓存 NOERROR 与 NXDOMAIN 响应, 有效期为记录的最小 TTL, 否定应答使用授权部分 SOA 的 TTL.
+// 命中时按经过的时间减少 TTL. 截断, 出错或没有可用 TTL 的响应不缓存, NOTIFY 与 UPDATE 等非 QUERY 请求不经过缓存
+func WithCache(c *DNSCache) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			if len(req.Message.Questions) != 1 || req.Message.Header.Flags.OpCode != DNSOpCodeQuery {
+				return next.ServeDNS(ctx, req)
+			}
+			key := newCacheKey(req.Message)
+			if resp := c.get(key, req.Message); resp != nil {
+				return resp, nil
+			}
+			resp, err := next.ServeDNS(ctx, req)
+			if err == nil && resp != nil {
+				c.put(key, resp)
+			}
+			return resp, err
+		})
+	}
+}
+
+func (c *DNSCache) get(key cacheKey, req *DNSMessage) *DNSMessage {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	now := time.Now()
+	if ok && now.After(e.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil
+	}
+	c.hits++
+	c.mu.Unlock()
+
+	resp, err := Unpack(e.packet)
+	if err != nil {
+		return nil
+	}
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	for _, rr := range resp.ResourceRecodes {
+		if rr.RRType == DNSTypeOPT {
+			continue
+		}
+		if rr.TTL > elapsed {
+			rr.TTL -= elapsed
+		} else {
+			rr.TTL = 0
+		}
+	}
+	resp.Header.TxID = req.Header.TxID
+	resp.Header.Flags.RD = req.Header.Flags.RD
+	resp.Questions = req.Questions
+	cacheMatchOPT(resp, req)
+	return resp
+}
+
+func newCacheKey(msg *DNSMessage) cacheKey {
+	q := msg.Questions[0]
+	key := cacheKey{
+		name:  normalizeDomain(q.QuestionName),
+		qtype: q.QuestionType,
+		class: q.QuestionClass,
+		rd:    msg.Header.Flags.RD != 0,
+		cd:    msg.Header.Flags.CheckingDisabled(),
+		do:    msg.DNSSECOK(),
+		edns:  msg.OPT() != nil,
+	}
+	for _, o := range msg.EDNSOptions() {
+		if o.Code == EDNSOptionClientSubnet {
+			key.clientSubnet = string(o.Data)
+		}
+	}
+	return key
+}
+
+// cacheMatchOPT 使缓存的响应与请求的 EDNS 一致 (RFC 6891 7): 请求没有 OPT 记录时去掉响应中的 OPT,
+// 有时补上 OPT 并使用请求的 DO 位
+func cacheMatchOPT(resp, req *DNSMessage) {
+	reqOPT := req.OPT()
+	if reqOPT == nil {
+		additionals := int(resp.Header.AnswerRRs) + int(resp.Header.AuthorityRRs)
+		kept := resp.ResourceRecodes[:0]
+		for i, rr := range resp.ResourceRecodes {
+			if i >= additionals && rr.RRType == DNSTypeOPT {
+				resp.Header.AdditionalRRs--
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		resp.ResourceRecodes = kept
+		return
+	}
+	if resp.OPT() == nil {
+		resp.SetEDNS(0, nil)
+	}
+	opt := resp.OPT()
+	opt.TTL = opt.TTL&^optDO | reqOPT.TTL&optDO
+}
+
+func (c *DNSCache) put(key cacheKey, resp *DNSMessage) {
+	if resp.Header.Flags.TC != 0 {
+		return
+	}
+	ttl, ok := cacheTTL(resp)
+	if !ok {
+		return
+	}
+	if ttl < c.MinTTL {
+		ttl = c.MinTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	packet, err := resp.ToByte()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[cacheKey]*cacheEntry{}
+	}
+	max := c.MaxEntries
+	if max <= 0 {
+		max = defaultCacheEntries
+	}
+	if len(c.entries) >= max {
+		c.evict(now)
+	}
+	c.entries[key] = &cacheEntry{packet: packet, stored: now, expires: now.Add(ttl)}
+}
+
+// evict 删除已过期的响应, 仍然超出一半容量时删除最早过期的部分
+func (c *DNSCache) evict(now time.Time) {
+	var cutoff time.Time
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if cutoff.IsZero() || e.expires.Before(cutoff) {
+			cutoff = e.expires
+		}
+	}
+	max := c.MaxEntries
+	if max <= 0 {
+		max = defaultCacheEntries
+	}
+	for len(c.entries) >= max/2 && !cutoff.IsZero() {
+		next := time.Time{}
+		for key, e := range c.entries {
+			if !e.expires.After(cutoff) {
+				delete(c.entries, key)
+			} else if next.IsZero() || e.expires.Before(next) {
+				next = e.expires
+			}
+		}
+		cutoff = next
+	}
+}
+
+// cacheTTL 返回响应可以缓存的时间, 否定应答 (NXDOMAIN 或没有回答) 使用授权部分 SOA 的 TTL
+func cacheTTL(resp *DNSMessage) (time.Duration, bool) {
+	rcode := resp.Header.Flags.RCode
+	if rcode != 0 && rcode != 3 {
+		return 0, false
+	}
+	records, negative := resp.Answers(), false
+	if rcode == 3 || len(records) == 0 {
+		records, negative = resp.Authorities(), true
+	}
+	min, ok := uint32(0), false
+	for _, rr := range records {
+		if negative && rr.RRType != DNSTypeSOA {
+			continue
+		}
+		if !ok || rr.TTL < min {
+			min, ok = rr.TTL, true
+		}
+	}
+	return time.Duration(min) * time.Second, ok
+}
+
+// Flush 清空缓存
+func (c *DNSCache) Flush() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+// FlushName 删除 name 及其子域名的所有缓存, 返回删除的数量
+func (c *DNSCache) FlushName(name string) int {
+	name = normalizeDomain(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key := range c.entries {
+		if key.name == name || strings.HasSuffix(key.name, "."+name) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Stats 返回当前的缓存统计
+func (c *DNSCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
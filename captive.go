@@ -0,0 +1,210 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 默认的检测目标, 与 Android 和 Chrome OS 使用的相同
+var (
+	defaultCaptiveNames = []string{"connectivitycheck.gstatic.com", "www.google.com", "cp.cloudflare.com"}
+	defaultCaptiveURLs  = []string{"http://connectivitycheck.gstatic.com/generate_204", "http://cp.cloudflare.com/generate_204"}
+)
+
+// CaptiveVerdict 网络的分类
+type CaptiveVerdict int
+
+const (
+	CaptiveOpen           CaptiveVerdict = iota // DNS 与 HTTP 都没有被拦截
+	CaptivePortal                               // HTTP 被拦截, 通常需要在门户页面登录
+	CaptiveDNSIntercepted                       // HTTP 正常但 DNS 应答被改写
+	CaptiveOffline                              // 所有 HTTP 检测都失败
+)
+
+func (v CaptiveVerdict) String() string {
+	switch v {
+	case CaptiveOpen:
+		return "open"
+	case CaptivePortal:
+		return "portal"
+	case CaptiveDNSIntercepted:
+		return "dns-intercepted"
+	case CaptiveOffline:
+		return "offline"
+	}
+	return "unknown"
+}
+
+// CaptiveOptions 强制门户检测的选项, 除 Local 外零值使用默认值
+type CaptiveOptions struct {
+	// Local 当前网络提供的解析器, 例如 DHCP 给出的地址. HTTP 检测也通过它解析
+	Local *Resolver
+	// Reference 可信的解析器, 通常是加密的公共解析器, 为空时不比较 DNS
+	Reference *Resolver
+	// Names 比较解析结果的名字, 默认为 Google 与 Cloudflare 的检测域名
+	Names []string
+	// URLs 应当返回 204 的 HTTP 地址
+	URLs []string
+	// Timeout 每个 HTTP 检测的超时, 默认 5s
+	Timeout time.Duration
+}
+
+// CaptiveNameCheck 一个名字的比较结果, 地址已排序
+type CaptiveNameCheck struct {
+	Name      string
+	Local     []string
+	Reference []string
+	LocalErr  error
+	// Intercepted 本地结果与参考没有交集, 并且是内网地址
+	Intercepted bool
+}
+
+// CaptiveHTTPCheck 一个 HTTP 检测的结果, 没有收到响应时 Status 为 0, 见 Err
+type CaptiveHTTPCheck struct {
+	URL      string
+	Status   int
+	Location string // 重定向的目标, 通常是门户页面
+	Err      error
+}
+
+// CaptiveReport 检测的结构化结果
+type CaptiveReport struct {
+	Verdict CaptiveVerdict
+	// PortalURL 门户页面, 来自重定向或被拦截的检测地址, 未知时为空
+	PortalURL string
+	// DNSIntercepted 有名字被判定为改写, 或者不同名字解析到完全相同的地址
+	DNSIntercepted  bool
+	HTTPIntercepted bool
+	Names           []*CaptiveNameCheck
+	HTTP            []*CaptiveHTTPCheck
+}
+
+// DetectCaptivePortal 检测当前网络是否拦截 DNS 或 HTTP. DNS 检测比较 Local 与 Reference 对已知名字的解析结果,
+// CDN 在不同解析器上的结果本来就不同, 所以只有本地结果是内网地址且与参考没有交集, 或者多个名字解析到
+// 同一组地址时才判定为改写. HTTP 检测请求不跟随重定向, 任何不是 204 的响应都视为拦截
+func DetectCaptivePortal(ctx context.Context, opts *CaptiveOptions) *CaptiveReport {
+	o := CaptiveOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if len(o.Names) == 0 {
+		o.Names = defaultCaptiveNames
+	}
+	if len(o.URLs) == 0 {
+		o.URLs = defaultCaptiveURLs
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	report := &CaptiveReport{}
+	if o.Local != nil && o.Reference != nil {
+		report.checkNames(ctx, &o)
+	}
+	report.checkHTTP(ctx, &o)
+
+	online := false
+	for _, c := range report.HTTP {
+		switch {
+		case c.Status == http.StatusNoContent:
+			online = true
+		case c.Status != 0:
+			report.HTTPIntercepted = true
+			if report.PortalURL == "" {
+				report.PortalURL = c.Location
+				if c.Location == "" {
+					report.PortalURL = c.URL
+				}
+			}
+		}
+	}
+	switch {
+	case report.HTTPIntercepted:
+		report.Verdict = CaptivePortal
+	case !online:
+		report.Verdict = CaptiveOffline
+	case report.DNSIntercepted:
+		report.Verdict = CaptiveDNSIntercepted
+	}
+	return report
+}
+
+func (r *CaptiveReport) checkNames(ctx context.Context, o *CaptiveOptions) {
+	answers := map[string]bool{}
+	for _, name := range o.Names {
+		c := &CaptiveNameCheck{Name: name}
+		r.Names = append(r.Names, c)
+		c.Local, c.LocalErr = o.Local.LookupHost(ctx, name)
+		if c.LocalErr != nil {
+			continue
+		}
+		c.Reference, _ = o.Reference.LookupHost(ctx, name)
+		sort.Strings(c.Local)
+		sort.Strings(c.Reference)
+		answers[strings.Join(c.Local, ",")] = true
+		c.Intercepted = captiveIntercepted(c.Local, c.Reference)
+		if c.Intercepted {
+			r.DNSIntercepted = true
+		}
+	}
+	// 门户的 DNS 常把所有名字解析到自己
+	if len(r.Names) > 1 && len(answers) == 1 {
+		for _, c := range r.Names {
+			if c.LocalErr != nil || strings.Join(c.Local, ",") == strings.Join(c.Reference, ",") {
+				return
+			}
+		}
+		r.DNSIntercepted = true
+	}
+}
+
+func captiveIntercepted(local, reference []string) bool {
+	if len(reference) == 0 {
+		return false
+	}
+	internal := false
+	for _, a := range local {
+		for _, b := range reference {
+			if a == b {
+				return false
+			}
+		}
+		if addr, err := netip.ParseAddr(a); err == nil && internalAddr(embeddedIPv4(addr)) {
+			internal = true
+		}
+	}
+	return internal
+}
+
+func (r *CaptiveReport) checkHTTP(ctx context.Context, o *CaptiveOptions) {
+	client := &http.Client{
+		Timeout: o.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if o.Local != nil {
+		client.Transport = (&HTTPDialer{Resolver: o.Local}).Transport()
+	}
+	for _, u := range o.URLs {
+		c := &CaptiveHTTPCheck{URL: u}
+		r.HTTP = append(r.HTTP, c)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			c.Err = err
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			c.Err = err
+			continue
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+		c.Status, c.Location = resp.StatusCode, resp.Header.Get("Location")
+	}
+}
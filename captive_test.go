@@ -0,0 +1,76 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDetectCaptivePortal(t *testing.T) {
+	var portal int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&portal) == 1 {
+			http.Redirect(w, r, "http://portal.test/login", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// answer 为空时按名字应答
+	resolver := func(answer string) *Resolver {
+		s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			name := req.Message.Questions[0].QuestionName
+			ip := answer
+			switch {
+			case name == "canary.test":
+				ip = "127.0.0.1"
+			case ip == "":
+				ip = map[string]string{"a.test": "192.0.2.1", "b.test": "192.0.2.2"}[name]
+			}
+			resp := NewReply(req.Message)
+			resp.ResourceRecodes = []*DNSResourceRecode{aRecord(name, ip)}
+			resp.Header.AnswerRRs = 1
+			return resp, nil
+		})}
+		return &Resolver{Server: "pipe", Transport: PipeTransport{Server: s}}
+	}
+	opts := &CaptiveOptions{
+		Local:     resolver(""),
+		Reference: resolver(""),
+		Names:     []string{"a.test", "b.test"},
+		URLs:      []string{"http://canary.test:" + port + "/generate_204"},
+	}
+	ctx := context.Background()
+	if r := DetectCaptivePortal(ctx, opts); r.Verdict != CaptiveOpen || r.DNSIntercepted || r.HTTP[0].Status != http.StatusNoContent {
+		t.Fatalf("open network: %+v", r)
+	}
+
+	opts.Local = resolver("10.0.0.1")
+	r := DetectCaptivePortal(ctx, opts)
+	if r.Verdict != CaptiveDNSIntercepted || !r.Names[0].Intercepted || r.Names[0].Local[0] != "10.0.0.1" {
+		t.Fatalf("dns interception: %+v %+v", r, r.Names[0])
+	}
+
+	// 公网地址不算改写, 但所有名字得到同一地址时仍然判定为改写
+	opts.Local = resolver("198.51.100.7")
+	if r := DetectCaptivePortal(ctx, opts); r.Names[0].Intercepted || !r.DNSIntercepted {
+		t.Fatalf("single answer for all names: %+v", r)
+	}
+
+	atomic.StoreInt32(&portal, 1)
+	opts.Local = resolver("")
+	r = DetectCaptivePortal(ctx, opts)
+	if r.Verdict != CaptivePortal || r.PortalURL != "http://portal.test/login" || r.Verdict.String() != "portal" {
+		t.Fatalf("portal: %+v", r)
+	}
+
+	ts.Close()
+	if r := DetectCaptivePortal(ctx, opts); r.Verdict != CaptiveOffline || r.HTTP[0].Err == nil {
+		t.Fatalf("offline: %+v", r)
+	}
+}
@@ -0,0 +1,246 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultDSMonitorInterval = time.Hour
+
+// SignalKeys 返回应当由父区域 DS 指向的密钥: 带 SEP 标志的区域密钥, 没有 SEP 密钥时为全部区域密钥
+func SignalKeys(keys []*DNSKEY) []*DNSKEY {
+	var sep, zone []*DNSKEY
+	for _, k := range keys {
+		if k.Flags&DNSKEYFlagZone == 0 || k.Protocol != 3 {
+			continue
+		}
+		zone = append(zone, k)
+		if k.Flags&DNSKEYFlagSEP != 0 {
+			sep = append(sep, k)
+		}
+	}
+	if len(sep) > 0 {
+		return sep
+	}
+	return zone
+}
+
+// MergeDNSKEYs 合并多个签名者 (RFC 8901 多签名者) 的 DNSKEY 集合, 去掉重复的密钥
+func MergeDNSKEYs(sets ...[]*DNSKEY) []*DNSKEY {
+	var merged []*DNSKEY
+	for _, set := range sets {
+	next:
+		for _, k := range set {
+			for _, m := range merged {
+				if m.Flags == k.Flags && m.Algorithm == k.Algorithm && bytes.Equal(m.PublicKey, k.PublicKey) {
+					continue next
+				}
+			}
+			merged = append(merged, k)
+		}
+	}
+	return merged
+}
+
+// NewCDS 返回 zone 的 CDS 记录 (RFC 7344), 每个 SignalKeys 的密钥对每种摘要类型一条, digestTypes 为空时使用 SHA-256
+func NewCDS(zone string, keys []*DNSKEY, ttl uint32, digestTypes ...uint8) ([]*DNSResourceRecode, error) {
+	if len(digestTypes) == 0 {
+		digestTypes = []uint8{DSDigestSHA256}
+	}
+	var rrs []*DNSResourceRecode
+	for _, k := range SignalKeys(keys) {
+		for _, digest := range digestTypes {
+			ds, err := k.ToDS(zone, digest)
+			if err != nil {
+				return nil, err
+			}
+			rrs = append(rrs, &DNSResourceRecode{Name: normalizeDomain(zone), RRType: DNSTypeCDS, Class: DNSClassIn, TTL: ttl, Data: ds.Pack()})
+		}
+	}
+	return rrs, nil
+}
+
+// NewCDNSKEY 返回 zone 的 CDNSKEY 记录, 每个 SignalKeys 的密钥一条
+func NewCDNSKEY(zone string, keys []*DNSKEY, ttl uint32) []*DNSResourceRecode {
+	var rrs []*DNSResourceRecode
+	for _, k := range SignalKeys(keys) {
+		rrs = append(rrs, &DNSResourceRecode{Name: normalizeDomain(zone), RRType: DNSTypeCDNSKEY, Class: DNSClassIn, TTL: ttl, Data: k.Pack()})
+	}
+	return rrs
+}
+
+// CDSDelete 返回要求父区域删除全部 DS 的 CDS 与 CDNSKEY 记录 (RFC 8078 4)
+func CDSDelete(zone string, ttl uint32) []*DNSResourceRecode {
+	name := normalizeDomain(zone)
+	return []*DNSResourceRecode{
+		{Name: name, RRType: DNSTypeCDS, Class: DNSClassIn, TTL: ttl, Data: []byte{0, 0, 0, 0, 0}},
+		{Name: name, RRType: DNSTypeCDNSKEY, Class: DNSClassIn, TTL: ttl, Data: []byte{0, 0, 3, 0, 0}},
+	}
+}
+
+// DSAlignment 父区域的 DS 与区域密钥的对照
+type DSAlignment struct {
+	Zone string
+	// Current 父区域中指向某个 SignalKeys 密钥的 DS
+	Current []*DS
+	// Stale 父区域中不指向任何 SignalKeys 密钥的 DS, 应当删除
+	Stale []*DS
+	// Missing 没有 DS 指向的 SignalKeys 密钥, 父区域应当添加它们的 DS
+	Missing []*DNSKEY
+}
+
+// Aligned 父区域的 DS 是否正好对应全部 SignalKeys 密钥
+func (a *DSAlignment) Aligned() bool {
+	return len(a.Stale) == 0 && len(a.Missing) == 0
+}
+
+// equal 两次对照的结果是否相同
+func (a *DSAlignment) equal(b *DSAlignment) bool {
+	if b == nil || len(a.Current) != len(b.Current) || len(a.Stale) != len(b.Stale) || len(a.Missing) != len(b.Missing) {
+		return false
+	}
+	for i := range a.Current {
+		if !bytes.Equal(a.Current[i].Pack(), b.Current[i].Pack()) {
+			return false
+		}
+	}
+	for i := range a.Stale {
+		if !bytes.Equal(a.Stale[i].Pack(), b.Stale[i].Pack()) {
+			return false
+		}
+	}
+	for i := range a.Missing {
+		if !bytes.Equal(a.Missing[i].Pack(), b.Missing[i].Pack()) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareDS 对照父区域的 DS 集合与区域的密钥
+func CompareDS(zone string, parent []*DS, keys []*DNSKEY) *DSAlignment {
+	a := &DSAlignment{Zone: normalizeDomain(zone)}
+	signal := SignalKeys(keys)
+	for _, ds := range parent {
+		matched := false
+		for _, k := range signal {
+			if ds.Matches(a.Zone, k) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			a.Current = append(a.Current, ds)
+		} else {
+			a.Stale = append(a.Stale, ds)
+		}
+	}
+	for _, k := range signal {
+		if !matchesAnyDS(parent, a.Zone, k) {
+			a.Missing = append(a.Missing, k)
+		}
+	}
+	return a
+}
+
+// DSMonitor 定期对照父区域的 DS 与区域当前的密钥, 结果变化时调用 OnChange,
+// 供密钥轮换时提醒运营者或驱动父区域的更新 (例如通过注册商 API)
+type DSMonitor struct {
+	// Zone 被监视的区域
+	Zone string
+	// Resolver 查询父区域 DS 使用的解析器, Keys 为空时也用它查询 DNSKEY
+	Resolver *Resolver
+	// Keys 返回区域当前的密钥, 多签名者时用 MergeDNSKEYs 合并. 为空时查询区域的 DNSKEY
+	Keys func(ctx context.Context) ([]*DNSKEY, error)
+	// Interval Run 的检查间隔, 默认 1 小时
+	Interval time.Duration
+	// OnChange 对照结果与上一次不同时调用, 第一次检查时总是调用
+	OnChange func(a *DSAlignment)
+
+	mu   sync.Mutex
+	last *DSAlignment
+}
+
+// Check 对照一次并返回结果
+func (m *DSMonitor) Check(ctx context.Context) (*DSAlignment, error) {
+	keys, err := m.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := m.records(ctx, DNSTypeDS)
+	if err != nil {
+		return nil, err
+	}
+	var dsSet []*DS
+	for _, data := range parent {
+		if ds, err := ParseDS(data); err == nil {
+			dsSet = append(dsSet, ds)
+		}
+	}
+	a := CompareDS(m.Zone, dsSet, keys)
+	m.mu.Lock()
+	changed := !a.equal(m.last)
+	m.last = a
+	m.mu.Unlock()
+	if changed && m.OnChange != nil {
+		m.OnChange(a)
+	}
+	return a, nil
+}
+
+func (m *DSMonitor) keys(ctx context.Context) ([]*DNSKEY, error) {
+	if m.Keys != nil {
+		return m.Keys(ctx)
+	}
+	data, err := m.records(ctx, DNSTypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*DNSKEY
+	for _, d := range data {
+		if k, err := ParseDNSKEY(d); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// records 查询区域顶点上 qtype 记录的 RDATA, 名字不存在或没有记录时返回空
+func (m *DSMonitor) records(ctx context.Context, qtype uint16) ([][]byte, error) {
+	resp, err := m.Resolver.Exchange(ctx, NewQuery(m.Zone, qtype, WithDNSSECOK()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Release()
+	if rcode := resp.Header.Flags.RCode; rcode != DNSRCodeSuccess && rcode != DNSRCodeNXDomain {
+		return nil, &RCodeError{RCode: rcode}
+	}
+	var data [][]byte
+	for _, rr := range resp.Answers() {
+		if rr.RRType == qtype && normalizeDomain(rr.Name) == normalizeDomain(m.Zone) {
+			data = append(data, append([]byte{}, rr.Data...))
+		}
+	}
+	return data, nil
+}
+
+// Run 按 Interval 调用 Check, 直到 ctx 结束
+func (m *DSMonitor) Run(ctx context.Context) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = defaultDSMonitorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// 单次失败等下一次检查
+		_, _ = m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
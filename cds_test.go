@@ -0,0 +1,90 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestCDS(t *testing.T) {
+	ksk := newTestZoneKey(t, "example", DNSSECAlgED25519)
+	next := newTestZoneKey(t, "example", DNSSECAlgECDSAP256SHA256)
+	zsk := newTestZoneKey(t, "example", DNSSECAlgED25519)
+	zsk.key.Flags = DNSKEYFlagZone
+
+	if got := SignalKeys([]*DNSKEY{zsk.key, ksk.key}); len(got) != 1 || got[0] != ksk.key {
+		t.Fatalf("SignalKeys = %v", got)
+	}
+	if got := SignalKeys([]*DNSKEY{zsk.key}); len(got) != 1 || got[0] != zsk.key {
+		t.Fatal("SignalKeys without sep keys")
+	}
+	// 另一个签名者发布了相同的 KSK
+	copied := *ksk.key
+	keys := MergeDNSKEYs([]*DNSKEY{ksk.key, zsk.key}, []*DNSKEY{&copied, next.key})
+	if len(keys) != 3 {
+		t.Fatalf("merged %d keys", len(keys))
+	}
+
+	cds, err := NewCDS("Example.", keys, 3600, DSDigestSHA256, DSDigestSHA384)
+	if err != nil || len(cds) != 4 {
+		t.Fatalf("NewCDS: %v, %d records", err, len(cds))
+	}
+	for _, rr := range cds {
+		ds, err := ParseDS(rr.Data)
+		if err != nil || rr.Name != "example" || rr.RRType != DNSTypeCDS || !(ds.Matches("example", ksk.key) || ds.Matches("example", next.key)) {
+			t.Fatalf("cds %+v: %v", rr, err)
+		}
+	}
+	cdnskey := NewCDNSKEY("example", keys, 3600)
+	if len(cdnskey) != 2 || cdnskey[0].RRType != DNSTypeCDNSKEY || !bytes.Equal(cdnskey[0].Data, ksk.key.Pack()) {
+		t.Fatalf("cdnskey: %v", cdnskey)
+	}
+	del := CDSDelete("example", 0)
+	if ds, err := ParseDS(del[0].Data); err != nil || ds.Algorithm != 0 || ds.DigestType != 0 {
+		t.Fatalf("cds delete: %v", err)
+	}
+	if k, err := ParseDNSKEY(del[1].Data); err != nil || k.Flags != 0 || k.Protocol != 3 || k.Algorithm != 0 {
+		t.Fatalf("cdnskey delete: %v", err)
+	}
+
+	// 轮换中: 父区域只有旧 KSK 的 DS 与一条过期的 DS
+	old := newTestZoneKey(t, "example", DNSSECAlgED25519)
+	a := CompareDS("example", []*DS{ksk.ds(t), old.ds(t)}, keys)
+	if a.Aligned() || len(a.Current) != 1 || len(a.Stale) != 1 || len(a.Missing) != 1 || a.Missing[0] != next.key {
+		t.Fatalf("alignment: %+v", a)
+	}
+	if a = CompareDS("example", []*DS{ksk.ds(t), next.ds(t)}, keys); !a.Aligned() {
+		t.Fatalf("aligned: %+v", a)
+	}
+}
+
+func TestDSMonitor(t *testing.T) {
+	ksk := newTestZoneKey(t, "example", DNSSECAlgED25519)
+	next := newTestZoneKey(t, "example", DNSSECAlgED25519)
+	u := &signedUpstream{records: map[string][]*DNSResourceRecode{}}
+	u.add("example", DNSTypeDNSKEY, ksk.dnskey())
+	u.add("example", DNSTypeDS, &DNSResourceRecode{Name: "example", RRType: DNSTypeDS, Class: DNSClassIn, TTL: 3600, Data: ksk.ds(t).Pack()})
+	udp, _ := startDNSServer(t, &DNSServer{Handler: u})
+
+	var changes []*DSAlignment
+	m := &DSMonitor{Zone: "example", Resolver: &Resolver{Server: udp}, OnChange: func(a *DSAlignment) { changes = append(changes, a) }}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		a, err := m.Check(ctx)
+		if err != nil || !a.Aligned() || len(a.Current) != 1 {
+			t.Fatalf("check: %v %+v", err, a)
+		}
+	}
+	if len(changes) != 1 {
+		t.Fatalf("%d changes for an unchanged alignment", len(changes))
+	}
+
+	// 区域发布了新的 KSK, 父区域需要添加 DS
+	m.Keys = func(context.Context) ([]*DNSKEY, error) { return []*DNSKEY{ksk.key, next.key}, nil }
+	if _, err := m.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[1].Aligned() || len(changes[1].Missing) != 1 || changes[1].Missing[0] != next.key {
+		t.Fatalf("changes: %+v", changes)
+	}
+}
@@ -0,0 +1,97 @@
+package netx
+
+import (
+	"context"
+	"strings"
+)
+
+// ServerIdentity 服务器通过 CHAOS 类 TXT 记录与 NSID 报告的身份, 不支持的项为空.
+// 任播地址后面有多个实例时, 用于确认是哪一个实例应答
+type ServerIdentity struct {
+	Version  string // version.bind
+	Hostname string // hostname.bind
+	ID       string // id.server, RFC 4892
+	NSID     []byte // EDNS NSID 选项, RFC 5001, 通常为可打印字符
+}
+
+// WithNSID 在请求中附加空的 NSID 选项, 要求服务器在响应中返回自己的标识
+func WithNSID() QueryOption {
+	return WithEDNSOptions(0, EDNSOption{Code: EDNSOptionNSID})
+}
+
+// NSID 返回响应中 NSID 选项的内容, 没有时返回空
+func (d *DNSMessage) NSID() []byte {
+	for _, o := range d.EDNSOptions() {
+		if o.Code == EDNSOptionNSID {
+			return o.Data
+		}
+	}
+	return nil
+}
+
+// ServerVersion 查询 version.bind CH TXT, 许多服务器出于安全考虑拒绝或返回伪造的版本
+func (r *Resolver) ServerVersion(ctx context.Context) (string, error) {
+	return r.chaosTXT(ctx, "version.bind")
+}
+
+// ServerHostname 查询 hostname.bind CH TXT
+func (r *Resolver) ServerHostname(ctx context.Context) (string, error) {
+	return r.chaosTXT(ctx, "hostname.bind")
+}
+
+// ServerID 查询 id.server CH TXT
+func (r *Resolver) ServerID(ctx context.Context) (string, error) {
+	return r.chaosTXT(ctx, "id.server")
+}
+
+func (r *Resolver) chaosTXT(ctx context.Context, name string, opts ...QueryOption) (string, error) {
+	txt, err := r.lookupTXT(ctx, name, append([]QueryOption{WithClass(DNSClassChaos)}, opts...)...)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(txt, " "), nil
+}
+
+// Identify 查询 Server 的所有身份信息, 单项失败时对应字段为空, 全部失败时返回最后一个错误.
+// 同一次调用中的查询可能被任播路由到不同实例, 结果不一致时应多次调用比较
+func (r *Resolver) Identify(ctx context.Context) (*ServerIdentity, error) {
+	id := &ServerIdentity{}
+	var lastErr error
+	ok := false
+	for _, item := range []struct {
+		name  string
+		field *string
+	}{{"version.bind", &id.Version}, {"hostname.bind", &id.Hostname}, {"id.server", &id.ID}} {
+		v, err := r.chaosTXT(ctx, item.name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		*item.field, ok = v, true
+	}
+	// NSID 随普通查询返回, 不依赖 CHAOS 类的支持
+	resp, err := r.Query(ctx, ".", DNSTypeNS, WithNSID())
+	if err != nil {
+		lastErr = err
+	} else {
+		if nsid := resp.NSID(); nsid != nil {
+			id.NSID, ok = append([]byte{}, nsid...), true
+		}
+		resp.Release()
+	}
+	if !ok && lastErr != nil {
+		return nil, lastErr
+	}
+	return id, nil
+}
+
+// Instance 返回最能区分实例的标识: 依次取 NSID, id.server 与 hostname.bind, 都没有时为空
+func (id *ServerIdentity) Instance() string {
+	switch {
+	case len(id.NSID) > 0:
+		return string(id.NSID)
+	case id.ID != "":
+		return id.ID
+	}
+	return id.Hostname
+}
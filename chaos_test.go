@@ -0,0 +1,43 @@
+package netx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIdentify(t *testing.T) {
+	udpAddr, _ := startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		if q.QuestionClass != DNSClassChaos {
+			if req.Message.OPT() != nil {
+				resp.SetEDNS(0, []EDNSOption{{Code: EDNSOptionNSID, Data: []byte("node-1")}})
+			}
+			return resp, nil
+		}
+		txt := map[string]string{"version.bind": "netx", "id.server": "sha1"}[q.QuestionName]
+		if txt == "" {
+			resp.Header.Flags.RCode = DNSRCodeRefused
+			return resp, nil
+		}
+		rr := txtRR(txt)
+		rr.Name, rr.Class = q.QuestionName, DNSClassChaos
+		resp.ResourceRecodes = []*DNSResourceRecode{rr}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})})
+	r := &Resolver{Server: udpAddr}
+	if v, err := r.ServerVersion(context.Background()); err != nil || v != "netx" {
+		t.Fatalf("ServerVersion = %q, %v", v, err)
+	}
+	if _, err := r.ServerHostname(context.Background()); err == nil {
+		t.Fatal("refused hostname.bind returned no error")
+	}
+	id, err := r.Identify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Version != "netx" || id.Hostname != "" || id.ID != "sha1" || string(id.NSID) != "node-1" {
+		t.Fatalf("identity = %+v", id)
+	}
+}
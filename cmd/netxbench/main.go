@@ -0,0 +1,161 @@
+// netxbench 比较两次 go test -bench 的输出, 用作性能回归检查.
+//
+//	go test -run '^$' -bench . -benchmem -count 5 github.com/moyrne/netx > new.txt
+//	netxbench [-threshold 10] [-allocs=true] testdata/bench/baseline.txt new.txt
+//
+// 同名基准的多次运行取中位数. ns/op 变慢超过 threshold 百分比, 或 allocs/op 增加 (allocs 为真时) 视为回归,
+// 此时以状态 1 退出. 只出现在一侧的基准单独列出, 不影响结果
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// result 一个基准多次运行的各项指标, 键为单位, 例如 ns/op, B/op, allocs/op
+type result map[string][]float64
+
+func main() {
+	threshold := flag.Float64("threshold", 10, "allowed ns/op slowdown in percent")
+	allocs := flag.Bool("allocs", true, "treat any allocs/op increase as a regression")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	regressed, err := run(os.Stdout, flag.Arg(0), flag.Arg(1), *threshold, *allocs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "netxbench:", err)
+		os.Exit(2)
+	}
+	if regressed {
+		os.Exit(1)
+	}
+}
+
+func run(w io.Writer, oldPath, newPath string, threshold float64, allocs bool) (bool, error) {
+	old, err := parseFile(oldPath)
+	if err != nil {
+		return false, err
+	}
+	cur, err := parseFile(newPath)
+	if err != nil {
+		return false, err
+	}
+	return compare(w, old, cur, threshold, allocs), nil
+}
+
+func parseFile(path string) (map[string]result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	results, err := parse(f)
+	return results, errors.WithMessage(err, path)
+}
+
+// parse 读取 go test -bench 的输出, 忽略其他行. 名字去掉 -GOMAXPROCS 后缀
+func parse(r io.Reader) (map[string]result, error) {
+	results := map[string]result{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := fields[0]
+		if i := strings.LastIndexByte(name, '-'); i > 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+		res := results[name]
+		if res == nil {
+			res = result{}
+			results[name] = res
+		}
+		// 其余字段为 "值 单位" 对
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			res[fields[i+1]] = append(res[fields[i+1]], v)
+		}
+	}
+	return results, scanner.Err()
+}
+
+func median(values []float64) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	s := append([]float64{}, values...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2], true
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2, true
+}
+
+// compare 输出对比表, 返回是否有回归
+func compare(w io.Writer, old, cur map[string]result, threshold float64, allocs bool) bool {
+	var names []string
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range cur {
+		if old[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "benchmark\told ns/op\tnew ns/op\tdelta\told allocs\tnew allocs\t")
+	regressed := false
+	for _, name := range names {
+		o, n := old[name], cur[name]
+		if o == nil || n == nil {
+			side := "only in new"
+			if n == nil {
+				side = "only in old"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\t\t\n", name, side)
+			continue
+		}
+		oNs, ok1 := median(o["ns/op"])
+		nNs, ok2 := median(n["ns/op"])
+		if !ok1 || !ok2 {
+			continue
+		}
+		delta := (nNs - oNs) / oNs * 100
+		mark := ""
+		if delta > threshold {
+			mark, regressed = " REGRESSION", true
+		}
+		oAllocs, ok1 := median(o["allocs/op"])
+		nAllocs, ok2 := median(n["allocs/op"])
+		allocText := [2]string{"-", "-"}
+		if ok1 && ok2 {
+			allocText = [2]string{strconv.FormatFloat(oAllocs, 'f', -1, 64), strconv.FormatFloat(nAllocs, 'f', -1, 64)}
+			if allocs && nAllocs > oAllocs && mark == "" {
+				mark, regressed = " ALLOCS", true
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%+.1f%%%s\t%s\t%s\t\n", name, oNs, nNs, delta, mark, allocText[0], allocText[1])
+	}
+	_ = tw.Flush()
+	return regressed
+}
@@ -0,0 +1,252 @@
+// netxsign 离线签名区域文件.
+//
+//	netxsign -origin example.com -ksk ksk.pem -zsk zsk.pem [-nsec3 -salt ab12 -iterations 0] [-o out] example.com.zone
+//	netxsign -origin example.com -keystore keys/ example.com.zone
+//
+// 私钥为 PEM 格式的 PKCS#8, SEC 1 (EC PRIVATE KEY) 或 PKCS#1 (RSA PRIVATE KEY), 或者来自 netx.KeyStore.
+// 输出文件已经存在时作为上一次的签名结果, 内容没有变化的 rrset 沿用其中仍然足够新的签名
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"github.com/moyrne/netx"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileList []string
+
+func (l *fileList) String() string { return strings.Join(*l, ",") }
+
+func (l *fileList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// options 命令行参数
+type options struct {
+	input, origin, output, previous string
+	ksks, zsks                      fileList
+	keystore                        string
+	nsec3                           bool
+	salt                            string
+	iterations                      uint
+	validity, refresh               time.Duration
+	serial                          string
+	zonemd                          bool
+}
+
+func main() {
+	var o options
+	flag.Var(&o.ksks, "ksk", "KSK private key file, may be repeated")
+	flag.Var(&o.zsks, "zsk", "ZSK private key file, may be repeated")
+	flag.StringVar(&o.keystore, "keystore", "", "key store directory; its active keys sign and its published keys are added, passphrase from $NETXSIGN_PASSPHRASE")
+	flag.StringVar(&o.origin, "origin", "", "zone origin (required)")
+	flag.StringVar(&o.output, "o", "", "output file, default <input>.signed")
+	flag.StringVar(&o.previous, "previous", "", "previously signed zone to reuse signatures from, default the output file")
+	flag.BoolVar(&o.nsec3, "nsec3", false, "use NSEC3 instead of NSEC")
+	flag.StringVar(&o.salt, "salt", "", "NSEC3 salt in hex, empty or \"-\" for none")
+	flag.UintVar(&o.iterations, "iterations", 0, "NSEC3 additional hash iterations")
+	flag.DurationVar(&o.validity, "validity", 30*24*time.Hour, "signature validity")
+	flag.DurationVar(&o.refresh, "refresh", 0, "re-sign signatures that expire within this duration, default validity/4")
+	flag.StringVar(&o.serial, "serial", "keep", "soa serial update: keep, increment, date or unixtime")
+	flag.BoolVar(&o.zonemd, "zonemd", false, "add a SHA-384 ZONEMD record")
+	flag.Parse()
+	o.input = flag.Arg(0)
+
+	if err := run(&o); err != nil {
+		fmt.Fprintln(os.Stderr, "netxsign:", err)
+		os.Exit(1)
+	}
+}
+
+func run(o *options) error {
+	if o.input == "" || o.origin == "" || len(o.ksks)+len(o.zsks) == 0 && o.keystore == "" {
+		flag.Usage()
+		return errors.New("input file, -origin and at least one key are required")
+	}
+	output, previous := o.output, o.previous
+	if output == "" {
+		output = o.input + ".signed"
+	}
+	if previous == "" {
+		previous = output
+	}
+	z, err := readZone(o.input, o.origin)
+	if err != nil {
+		return err
+	}
+	signer := &netx.ZoneSigner{Validity: o.validity, Refresh: o.refresh}
+	for i, path := range append(append([]string{}, o.ksks...), o.zsks...) {
+		flags := uint16(netx.DNSKEYFlagZone)
+		if i < len(o.ksks) {
+			flags |= netx.DNSKEYFlagSEP
+		}
+		key, err := readKey(path, flags)
+		if err != nil {
+			return errors.WithMessage(err, path)
+		}
+		signer.Keys = append(signer.Keys, key)
+	}
+	if o.keystore != "" {
+		if err := addStoreKeys(z, signer, o.keystore); err != nil {
+			return errors.WithMessage(err, "keystore")
+		}
+	}
+	if o.nsec3 {
+		if o.iterations > 0xffff {
+			return errors.New("too many nsec3 iterations")
+		}
+		b, err := hex.DecodeString(strings.TrimPrefix(o.salt, "-"))
+		if err != nil {
+			return errors.WithMessage(err, "salt")
+		}
+		signer.NSEC3 = &netx.NSEC3Params{Iterations: uint16(o.iterations), Salt: b}
+	}
+	if err := bumpSerial(z, o.serial); err != nil {
+		return err
+	}
+	if o.zonemd && len(z.Records(z.Origin, netx.DNSTypeZONEMD)) == 0 {
+		// 占位记录, 签名时按最终的数据计算
+		if err := netx.AddZONEMD(z); err != nil {
+			return err
+		}
+	}
+
+	var prev *netx.Zone
+	if _, err := os.Stat(previous); err == nil {
+		if prev, err = readZone(previous, o.origin); err != nil {
+			return errors.WithMessage(err, "previous zone")
+		}
+	}
+	signed, stats, err := signer.Sign(z, prev)
+	if err != nil {
+		return err
+	}
+	if err := writeZone(output, signed); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "netxsign: wrote %s, %d new signatures, %d reused\n", output, stats.Signed, stats.Reused)
+	return nil
+}
+
+// addStoreKeys 使用密钥库中处于 active 的密钥签名, 并发布其中 published 与 retired 的密钥
+func addStoreKeys(z *netx.Zone, signer *netx.ZoneSigner, dir string) error {
+	store := &netx.KeyStore{Dir: dir, Backend: &netx.FileKeyBackend{Dir: dir, Passphrase: []byte(os.Getenv("NETXSIGN_PASSPHRASE"))}}
+	keys, err := store.SigningKeys(context.Background(), z.Origin)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no active key for " + z.Origin)
+	}
+	signer.Keys = append(signer.Keys, keys...)
+	published, err := store.PublishedKeys(z.Origin)
+	if err != nil {
+		return err
+	}
+	soa := z.SOA()
+	if soa == nil {
+		return netx.ErrNoSOA
+	}
+	for _, key := range published {
+		rr := &netx.DNSResourceRecode{Name: z.Origin, RRType: netx.DNSTypeDNSKEY, Class: netx.DNSClassIn, TTL: soa.TTL, Data: key.Pack()}
+		z.Remove(rr)
+		if err := z.Add(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZone(path, origin string) (*netx.Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	z, err := netx.ParseZone(f, origin)
+	return z, errors.WithMessage(err, path)
+}
+
+// writeZone 先写入临时文件再改名, 避免留下不完整的区域
+func writeZone(path string, z *netx.Zone) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := z.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func readKey(path string, flags uint16) (*netx.SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	return netx.NewSigningKey(signer, flags)
+}
+
+// bumpSerial 按 strategy 增加 SOA 序列号, keep 时不变
+func bumpSerial(z *netx.Zone, strategy string) error {
+	var s netx.SerialStrategy
+	switch strategy {
+	case "keep":
+		return nil
+	case "increment":
+		s = netx.SerialIncrement
+	case "date":
+		s = netx.SerialDate
+	case "unixtime":
+		s = netx.SerialUnixTime
+	default:
+		return errors.New("unknown serial strategy " + strategy)
+	}
+	soa := z.SOA()
+	current, ok := netx.SOASerial(soa)
+	if !ok {
+		return netx.ErrNoSOA
+	}
+	fields := strings.Fields(soa.RData)
+	fields[2] = fmt.Sprint(s.Next(current, time.Now()))
+	updated := *soa
+	updated.RData = strings.Join(fields, " ")
+	z.Remove(soa)
+	return z.Add(&updated)
+}
@@ -0,0 +1,496 @@
+// netxupdate 发送动态更新 (RFC 2136), 命令与 nsupdate 兼容.
+//
+//	netxupdate [-server ns1.example.com] [-y hmac-sha256:name:secret | -k Kname.key] [-v] [script]
+//	netxupdate -server ns1.example.com -zone example.com -add 'www.example.com 300 A 192.0.2.1' -delete 'old.example.com A'
+//
+// 没有 -add 与 -delete 时从 script (默认标准输入) 逐行读取命令: server, local, zone, class, ttl, key,
+// prereq (nxdomain, yxdomain, nxrrset, yxrrset), update add/delete (可以省略 update), show, send, answer,
+// debug 与 quit, 空行等同于 send, 以 ; 开头的行为注释. 名字总是绝对名字.
+// 没有指定 zone 时向服务器查询第一个名字的 SOA 得到区域. 每次发送后输出服务器的响应.
+// 语法或网络错误时以状态 1 退出, 服务器拒绝更新时以状态 2 退出
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/moyrne/netx"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type recordList []string
+
+func (l *recordList) String() string { return strings.Join(*l, ",") }
+
+func (l *recordList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// session 一个脚本的状态, 与 nsupdate 相同, 发送之后清空前提条件与更新
+type session struct {
+	client netx.UpdateClient
+	zone   string
+	ttl    uint32
+	update *netx.DNSUpdate
+	answer *netx.DNSMessage
+	debug  bool
+	out    io.Writer
+	// rejected 有更新被服务器拒绝
+	rejected bool
+}
+
+func main() {
+	var adds, deletes recordList
+	s := &session{out: os.Stdout, update: &netx.DNSUpdate{}}
+	server := flag.String("server", "", "primary server, host[:port]")
+	flag.StringVar(&s.zone, "zone", "", "zone to update, default the zone of the first name")
+	keyText := flag.String("y", "", "TSIG key as [algorithm:]name:base64secret")
+	keyFile := flag.String("k", "", "TSIG key file in BIND key syntax")
+	flag.BoolVar(&s.client.TCP, "v", false, "always use TCP")
+	flag.DurationVar(&s.client.Timeout, "t", 10*time.Second, "timeout for each request")
+	ttl := flag.Uint("ttl", 0, "default TTL for added records")
+	flag.BoolVar(&s.debug, "d", false, "print requests before sending")
+	flag.Var(&adds, "add", "record to add in zone file syntax, may be repeated")
+	flag.Var(&deletes, "delete", "name [type [data]] to delete, may be repeated")
+	flag.Parse()
+	s.ttl = uint32(*ttl)
+
+	err := func() error {
+		if *server != "" {
+			if err := s.setServer(*server, ""); err != nil {
+				return err
+			}
+		}
+		switch {
+		case *keyText != "" && *keyFile != "":
+			return errors.New("-y and -k are mutually exclusive")
+		case *keyText != "":
+			key, err := netx.ParseTSIGKey(*keyText)
+			if err != nil {
+				return err
+			}
+			s.client.Key = key
+		case *keyFile != "":
+			key, err := readKeyFile(*keyFile)
+			if err != nil {
+				return errors.WithMessage(err, *keyFile)
+			}
+			s.client.Key = key
+		}
+		if len(adds)+len(deletes) > 0 {
+			for _, rr := range adds {
+				if err := s.command("update add " + rr); err != nil {
+					return err
+				}
+			}
+			for _, rr := range deletes {
+				if err := s.command("update delete " + rr); err != nil {
+					return err
+				}
+			}
+			return s.send()
+		}
+		in := io.Reader(os.Stdin)
+		if flag.NArg() > 0 {
+			f, err := os.Open(flag.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		return s.run(in)
+	}()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "netxupdate:", err)
+		os.Exit(1)
+	}
+	if s.rejected {
+		os.Exit(2)
+	}
+}
+
+// run 执行脚本, 结束时发送尚未发送的更新
+func (s *session) run(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		err := s.command(scanner.Text())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithMessage(err, "line "+strconv.Itoa(lineNo))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return s.send()
+}
+
+// cut 返回第一个词与其余部分
+func cut(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return line, ""
+}
+
+// command 执行一行命令, quit 返回 io.EOF
+func (s *session) command(line string) error {
+	word, rest := cut(line)
+	args := strings.Fields(rest)
+	switch strings.ToLower(word) {
+	case "":
+		return s.send()
+	case "quit":
+		return io.EOF
+	case "send":
+		return s.send()
+	case "show":
+		s.print(s.request())
+	case "answer":
+		if s.answer != nil {
+			s.print(s.answer)
+		}
+	case "debug":
+		s.debug = true
+	case "server":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: server host [port]")
+		}
+		port := ""
+		if len(args) == 2 {
+			port = args[1]
+		}
+		return s.setServer(args[0], port)
+	case "local":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: local address [port]")
+		}
+		return s.setLocal(args)
+	case "zone":
+		if len(args) != 1 {
+			return errors.New("usage: zone name")
+		}
+		s.zone = args[0]
+	case "class":
+		if len(args) != 1 || !strings.EqualFold(args[0], "IN") {
+			return errors.New("only class IN is supported")
+		}
+	case "ttl":
+		if len(args) != 1 {
+			return errors.New("usage: ttl seconds")
+		}
+		ttl, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return errors.WithMessage(err, "ttl")
+		}
+		s.ttl = uint32(ttl)
+	case "key":
+		if len(args) != 2 {
+			return errors.New("usage: key [algorithm:]name secret")
+		}
+		key, err := netx.ParseTSIGKey(args[0] + ":" + args[1])
+		if err != nil {
+			return err
+		}
+		s.client.Key = key
+	case "prereq":
+		return s.prereq(rest)
+	case "update":
+		op, rest := cut(rest)
+		return s.change(op, rest)
+	case "add", "del", "delete":
+		return s.change(word, rest)
+	default:
+		if strings.HasPrefix(word, ";") {
+			return nil
+		}
+		return errors.New("unknown command " + strconv.Quote(word))
+	}
+	return nil
+}
+
+func (s *session) setServer(host, port string) error {
+	if h, p, err := net.SplitHostPort(host); err == nil && port == "" {
+		host, port = h, p
+	}
+	if port == "" {
+		port = "53"
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return errors.New("bad port " + port)
+	}
+	s.client.Server = net.JoinHostPort(host, port)
+	return nil
+}
+
+// setLocal 指定发送请求的本地地址
+func (s *session) setLocal(args []string) error {
+	ip := net.ParseIP(args[0])
+	if ip == nil {
+		return errors.New("bad local address " + args[0])
+	}
+	port := 0
+	if len(args) == 2 {
+		p, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			return errors.New("bad port " + args[1])
+		}
+		port = int(p)
+	}
+	s.client.Dialer = netx.DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip, Port: port}}
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: ip, Port: port}
+		}
+		return d.DialContext(ctx, network, address)
+	})
+	return nil
+}
+
+// prereq nxdomain|yxdomain 名字, nxrrset 名字 [类别] 类型, yxrrset 名字 [类别] 类型 [数据]
+func (s *session) prereq(line string) error {
+	kind, rest := cut(line)
+	args := strings.Fields(rest)
+	if len(args) == 0 {
+		return errors.New("prereq requires a name")
+	}
+	name := args[0]
+	switch strings.ToLower(kind) {
+	case "nxdomain", "yxdomain":
+		if len(args) != 1 {
+			return errors.New("usage: prereq " + kind + " name")
+		}
+		if strings.EqualFold(kind, "nxdomain") {
+			s.update.NameNotInUse(name)
+		} else {
+			s.update.NameInUse(name)
+		}
+		return nil
+	case "nxrrset", "yxrrset":
+	default:
+		return errors.New("unknown prereq " + strconv.Quote(kind))
+	}
+	args = skipClass(args[1:])
+	if len(args) == 0 {
+		return errors.New("prereq " + kind + " requires a type")
+	}
+	rtype, err := netx.ParseType(args[0])
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.EqualFold(kind, "nxrrset"):
+		if len(args) != 1 {
+			return errors.New("usage: prereq nxrrset name [class] type")
+		}
+		s.update.RRsetNotExists(name, uint16(rtype))
+	case len(args) == 1:
+		s.update.RRsetExists(name, uint16(rtype))
+	default:
+		rr, err := netx.ParseRR(name+" "+strings.Join(args, " "), "", 0)
+		if err != nil {
+			return err
+		}
+		s.update.RRsetEquals(rr)
+	}
+	return nil
+}
+
+// change update add 名字 [ttl] [类别] 类型 数据, update delete 名字 [ttl] [类别] [类型 [数据]]
+func (s *session) change(op, line string) error {
+	switch strings.ToLower(op) {
+	case "add":
+		rr, err := netx.ParseRR(line, "", s.ttl)
+		if err != nil {
+			return err
+		}
+		s.update.Add(rr)
+		return nil
+	case "del", "delete":
+	default:
+		return errors.New("unknown update operation " + strconv.Quote(op))
+	}
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return errors.New("update delete requires a name")
+	}
+	name, args := args[0], args[1:]
+	if len(args) > 0 {
+		if _, err := strconv.ParseUint(args[0], 10, 32); err == nil {
+			args = args[1:]
+		}
+	}
+	args = skipClass(args)
+	switch len(args) {
+	case 0:
+		s.update.DeleteName(name)
+		return nil
+	case 1:
+		rtype, err := netx.ParseType(args[0])
+		if err != nil {
+			return err
+		}
+		s.update.DeleteRRset(name, uint16(rtype))
+		return nil
+	}
+	rr, err := netx.ParseRR(name+" "+strings.Join(args, " "), "", 0)
+	if err != nil {
+		return err
+	}
+	s.update.Delete(rr)
+	return nil
+}
+
+// skipClass 跳过可选的类别, 删除与前提条件中常写作 ANY 或 NONE
+func skipClass(args []string) []string {
+	if len(args) > 1 {
+		switch strings.ToUpper(args[0]) {
+		case "IN", "ANY", "NONE":
+			return args[1:]
+		}
+	}
+	return args
+}
+
+func (s *session) request() *netx.DNSMessage {
+	u := *s.update
+	u.Zone = s.zone
+	return u.Message()
+}
+
+// send 发送当前的更新, 没有内容时什么也不做
+func (s *session) send() error {
+	if len(s.update.Prereqs)+len(s.update.Updates) == 0 {
+		return nil
+	}
+	if s.client.Server == "" {
+		return errors.New("no server, use -server or the server command")
+	}
+	ctx := context.Background()
+	if s.zone == "" {
+		zone, err := s.findZone(ctx)
+		if err != nil {
+			return err
+		}
+		s.zone = zone
+		defer func() { s.zone = "" }()
+	}
+	req := s.request()
+	if s.debug {
+		fmt.Fprintln(s.out, ";; sending to", s.client.Server)
+		s.print(req)
+	}
+	resp, err := s.client.Exchange(ctx, req)
+	if err != nil {
+		return err
+	}
+	s.update = &netx.DNSUpdate{}
+	s.answer = resp
+	s.print(resp)
+	if resp.Header.Flags.RCode != netx.DNSRCodeSuccess {
+		s.rejected = true
+		fmt.Fprintln(os.Stderr, "netxupdate: update failed:", netx.DNSRCode(resp.Header.Flags.RCode))
+	}
+	return nil
+}
+
+// findZone 查询第一个名字的 SOA, 回答或授权部分中 SOA 的名字即为区域
+func (s *session) findZone(ctx context.Context) (string, error) {
+	records := append(append([]*netx.DNSResourceRecode{}, s.update.Updates...), s.update.Prereqs...)
+	r := &netx.Resolver{Server: s.client.Server, Timeout: s.client.Timeout}
+	if s.client.TCP {
+		r.Transport = netx.TCPTransport{}
+	}
+	resp, err := r.Query(ctx, records[0].Name, netx.DNSTypeSOA)
+	if err != nil {
+		return "", errors.WithMessage(err, "find zone of "+records[0].Name)
+	}
+	for _, rr := range append(resp.Answers(), resp.Authorities()...) {
+		if rr.RRType == netx.DNSTypeSOA {
+			return rr.Name, nil
+		}
+	}
+	return "", errors.New("no soa for " + records[0].Name + ", use the zone command")
+}
+
+// print 按 nsupdate -d 的格式输出, 分区名字使用 UPDATE 的叫法
+func (s *session) print(m *netx.DNSMessage) {
+	h := m.Header
+	fmt.Fprintf(s.out, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n",
+		netx.DNSOpCode(h.Flags.OpCode), netx.DNSRCode(h.Flags.RCode), h.TxID)
+	fmt.Fprintln(s.out, ";; ZONE SECTION:")
+	for _, q := range m.Questions {
+		fmt.Fprintf(s.out, ";%s\t\t%s\t%s\n", absolute(q.QuestionName), netx.DNSClass(q.QuestionClass), netx.DNSType(q.QuestionType))
+	}
+	for _, section := range []struct {
+		name    string
+		records []*netx.DNSResourceRecode
+	}{
+		{"PREREQUISITE", m.Answers()},
+		{"UPDATE", m.Authorities()},
+		{"ADDITIONAL", m.Additionals()},
+	} {
+		if len(section.records) == 0 {
+			continue
+		}
+		fmt.Fprintf(s.out, "\n;; %s SECTION:\n", section.name)
+		for _, rr := range section.records {
+			if len(rr.Data) == 0 && rr.RData == "" {
+				fmt.Fprintf(s.out, "%s\t%d\t%s\t%s\n", absolute(rr.Name), rr.TTL, netx.DNSClass(rr.Class), netx.DNSType(rr.RRType))
+				continue
+			}
+			fmt.Fprintln(s.out, netx.FormatRR(rr))
+		}
+	}
+	fmt.Fprintln(s.out)
+}
+
+func absolute(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// readKeyFile 读取 tsig-keygen 或 ddns-confgen 生成的 BIND 密钥文件:
+//
+//	key "name" { algorithm hmac-sha256; secret "base64"; };
+func readKeyFile(path string) (*netx.TSIGKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(strings.NewReplacer("{", " ", "}", " ", ";", " ", `"`, " ").Replace(string(data)))
+	var name, alg, secret string
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "key":
+			name = fields[i+1]
+		case "algorithm":
+			alg = fields[i+1]
+		case "secret":
+			secret = fields[i+1]
+		}
+	}
+	if name == "" || secret == "" {
+		return nil, errors.WithMessage(netx.ErrTSIGKey, "no key name or secret")
+	}
+	if alg == "" {
+		alg = netx.DefaultTSIGAlgorithm
+	}
+	return netx.ParseTSIGKey(alg + ":" + name + ":" + secret)
+}
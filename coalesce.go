@@ -0,0 +1,128 @@
+package netx
+
+import (
+	"context"
+	"sync"
+)
+
+// QueryCoalescer 合并相同的并发请求, 零值可用, 可以并发使用
+type QueryCoalescer struct {
+	mu     sync.Mutex
+	calls  map[coalesceKey]*coalescedCall
+	sent   uint64
+	merged uint64
+}
+
+// coalesceKey 影响应答内容的请求字段, ECS 不同的请求不合并
+type coalesceKey struct {
+	name         string
+	qtype, class uint16
+	opcode       uint16
+	rd, cd, do   bool
+	clientSubnet string
+	edns         bool
+}
+
+type coalescedCall struct {
+	done chan struct{}
+	resp *DNSMessage
+	err  error
+}
+
+// CoalesceStats 合并统计
+type CoalesceStats struct {
+	Sent   uint64 `json:"sent"`   // 交给下一个处理器的请求数
+	Merged uint64 `json:"merged"` // 等待其它请求结果的请求数
+}
+
+// WithCoalescing 名字, 类型, 类别, 标志位与 ECS 都相同的请求在处理中时, 后来的请求不再交给 next,
+// 而是等待第一个请求的结果, 各自得到一份换成自己的 TxID 与问题的副本 (问题保留客户端的大小写).
+// 第一个请求出错时所有等待者得到同样的错误. 只合并普通查询: UPDATE 与 NOTIFY 等非 QUERY 请求,
+// 带 TSIG 签名或授权部分的请求总是交给 next
+func WithCoalescing(c *QueryCoalescer) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			msg := req.Message
+			if !coalescable(msg) {
+				return next.ServeDNS(ctx, req)
+			}
+			key := newCoalesceKey(msg)
+			c.mu.Lock()
+			if call, ok := c.calls[key]; ok {
+				c.merged++
+				c.mu.Unlock()
+				select {
+				case <-call.done:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				if call.err != nil || call.resp == nil {
+					return nil, call.err
+				}
+				resp := call.resp.Copy()
+				resp.Header.TxID = msg.Header.TxID
+				resp.Questions = msg.Questions
+				return resp, nil
+			}
+			call := &coalescedCall{done: make(chan struct{})}
+			if c.calls == nil {
+				c.calls = map[coalesceKey]*coalescedCall{}
+			}
+			c.calls[key] = call
+			c.sent++
+			c.mu.Unlock()
+
+			resp, err := next.ServeDNS(ctx, req)
+			if err == nil && resp != nil {
+				// 等待者复制这份副本, 第一个请求的响应可能被之后的中间件修改
+				call.resp = resp.Copy()
+			}
+			call.err = err
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(call.done)
+			return resp, err
+		})
+	}
+}
+
+// coalescable msg 的应答是否只由问题与标志位决定
+func coalescable(msg *DNSMessage) bool {
+	if len(msg.Questions) != 1 || msg.Header.Flags.OpCode != DNSOpCodeQuery || msg.Header.AuthorityRRs != 0 {
+		return false
+	}
+	for _, rr := range msg.ResourceRecodes {
+		if rr.RRType == DNSTypeTSIG {
+			return false
+		}
+	}
+	return true
+}
+
+func newCoalesceKey(msg *DNSMessage) coalesceKey {
+	q := msg.Questions[0]
+	key := coalesceKey{
+		name:   normalizeDomain(q.QuestionName),
+		qtype:  q.QuestionType,
+		class:  q.QuestionClass,
+		opcode: msg.Header.Flags.OpCode,
+		rd:     msg.Header.Flags.RD != 0,
+		cd:     msg.Header.Flags.CheckingDisabled(),
+		do:     msg.DNSSECOK(),
+		edns:   msg.OPT() != nil,
+	}
+	for _, o := range msg.EDNSOptions() {
+		if o.Code == EDNSOptionClientSubnet {
+			key.clientSubnet = string(o.Data)
+		}
+	}
+	return key
+}
+
+// Stats 返回合并统计
+func (c *QueryCoalescer) Stats() CoalesceStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CoalesceStats{Sent: c.sent, Merged: c.merged}
+}
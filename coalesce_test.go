@@ -0,0 +1,150 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescing(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	upstream := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		atomic.AddInt64(&calls, 1)
+		q := req.Message.Questions[0]
+		if q.QuestionType == DNSTypeTXT {
+			return nil, errors.New("upstream failed")
+		}
+		<-release
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(q.QuestionName, "192.0.2.1")}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})
+	c := &QueryCoalescer{}
+	h := ChainHandler(upstream, WithCoalescing(c))
+	ctx := context.Background()
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		name := "www.example.com"
+		if i%2 == 1 {
+			name = "WWW.Example.COM"
+		}
+		req := NewQuery(name, DNSTypeA)
+		req.Header.TxID = uint16(1000 + i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := h.ServeDNS(ctx, &DNSRequest{Message: req})
+			switch {
+			case err != nil:
+				errs <- err
+			case resp.Header.TxID != req.Header.TxID || resp.Questions[0].QuestionName != req.Questions[0].QuestionName:
+				errs <- errors.New("response not rewritten for " + req.Questions[0].QuestionName)
+			case len(resp.Answers()) != 1 || resp.Answers()[0].RData != "192.0.2.1":
+				errs <- errors.New("bad answer")
+			}
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); c.Stats().Merged < n-1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v", c.Stats())
+		}
+	}
+	// 类型不同的请求不合并
+	aaaa := make(chan struct{})
+	go func() {
+		_, _ = h.ServeDNS(ctx, &DNSRequest{Message: NewQuery("www.example.com", DNSTypeAAAA)})
+		close(aaaa)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); c.Stats().Sent < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v", c.Stats())
+		}
+	}
+	close(release)
+	wg.Wait()
+	<-aaaa
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("upstream calls = %d", calls)
+	}
+
+	if _, err := h.ServeDNS(ctx, &DNSRequest{Message: NewQuery("www.example.com", DNSTypeTXT)}); err == nil || !strings.Contains(err.Error(), "upstream") {
+		t.Fatalf("error not propagated: %v", err)
+	}
+	if stats := c.Stats(); stats.Sent != 3 || stats.Merged != n-1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestCoalescingSkipsUpdates(t *testing.T) {
+	var mu sync.Mutex
+	var updates []string
+	release := make(chan struct{})
+	upstream := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		mu.Lock()
+		for _, rr := range req.Message.Authorities() {
+			updates = append(updates, rr.RData)
+		}
+		if req.Message.Header.Flags.OpCode == DNSOpCodeQuery {
+			updates = append(updates, "signed query")
+		}
+		mu.Unlock()
+		<-release
+		return NewReply(req.Message), nil
+	})
+	c := &QueryCoalescer{}
+	h := ChainHandler(upstream, WithCoalescing(c))
+
+	// 同一区域的两个 UPDATE 更新部分不同, 带 TSIG 的两个相同查询各自有签名
+	var reqs []*DNSMessage
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		u := &DNSUpdate{Zone: "example.com"}
+		u.Add(aRecord("www.example.com", ip))
+		reqs = append(reqs, u.Message())
+	}
+	for i := 0; i < 2; i++ {
+		q := NewQuery("www.example.com", DNSTypeA)
+		q.ResourceRecodes = append(q.ResourceRecodes, &DNSResourceRecode{Name: "key.", RRType: DNSTypeTSIG, Class: DNSClassAny})
+		q.Header.AdditionalRRs++
+		reqs = append(reqs, q)
+	}
+	var wg sync.WaitGroup
+	for _, req := range reqs {
+		wg.Add(1)
+		go func(req *DNSMessage) {
+			defer wg.Done()
+			_, _ = h.ServeDNS(context.Background(), &DNSRequest{Message: req})
+		}(req)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(updates)
+		mu.Unlock()
+		if n == len(reqs) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("handler saw %v, stats = %+v", updates, c.Stats())
+		}
+	}
+	close(release)
+	wg.Wait()
+	if stats := c.Stats(); stats.Sent != 0 || stats.Merged != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if !strings.Contains(strings.Join(updates, " "), "192.0.2.1") || !strings.Contains(strings.Join(updates, " "), "192.0.2.2") {
+		t.Fatalf("updates = %v", updates)
+	}
+}
@@ -0,0 +1,690 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrConfig = errors.New("invalid server config")
+
+// ServerConfig 服务器配置, 顶层的区域, 转发与黑名单组成默认视图
+type ServerConfig struct {
+	Listen     []ListenConfig    `yaml:"listen" toml:"listen"`
+	Zones      []ZoneConfig      `yaml:"zones" toml:"zones"`
+	Forwarders []ForwarderConfig `yaml:"forwarders" toml:"forwarders"`
+	Blocklists []BlocklistConfig `yaml:"blocklists" toml:"blocklists"`
+	Views      []ViewConfig      `yaml:"views" toml:"views"`
+
+	dir string // 配置文件所在目录, 用于解析相对路径
+}
+
+// ListenConfig 一个监听地址, Network 为 udp, tcp, tls (DNS over TLS, 需要 Cert 与 Key) 或 unix (Address 为 socket 路径).
+// Sockets 大于 1 时 udp 用 SO_REUSEPORT 打开多个 socket. ProxyProtocol 为真时来自 ProxyTrusted
+// (以空格或逗号分隔的网络, 必须设置) 的 tcp 与 tls 连接必须以 PROXY protocol 头开始, 见 ProxyListener.
+// Interface 不为空时只接收该网卡上的请求, 见 Binding.
+// 重新加载时除证书外的任何一项修改都会关闭并重新打开该监听
+type ListenConfig struct {
+	Network       string `yaml:"network" toml:"network"`
+	Address       string `yaml:"address" toml:"address"`
+	Cert          string `yaml:"cert" toml:"cert"`
+	Key           string `yaml:"key" toml:"key"`
+	Sockets       int    `yaml:"sockets" toml:"sockets"`
+	ProxyProtocol bool   `yaml:"proxy_protocol" toml:"proxy_protocol"`
+	ProxyTrusted  string `yaml:"proxy_trusted" toml:"proxy_trusted"`
+	Interface     string `yaml:"interface" toml:"interface"`
+}
+
+// ZoneConfig 权威区域, 记录来自 File 与 Records (主文件格式的单行记录). File 中有 ZONEMD 时加载时验证区域摘要
+type ZoneConfig struct {
+	Origin  string   `yaml:"origin" toml:"origin"`
+	File    string   `yaml:"file" toml:"file"`
+	Records []string `yaml:"records" toml:"records"`
+}
+
+// ForwarderConfig 将 Zone 下的名字转发给 Servers, Zone 为空或 "." 时转发所有名字.
+// Network 为 udp (默认), tcp, tls 或 unix (Servers 为 socket 路径). DNSSEC 为真时在本地验证响应, 见 WithDNSSECValidation.
+// ProxyProtocol 为 1 或 2 时在 tcp 与 tls 连接上发送该版本的 PROXY protocol 头, 见 WithProxyHeader.
+// Interface 与 Source 指定发出查询的网卡与源地址, 见 Binding
+type ForwarderConfig struct {
+	Zone          string        `yaml:"zone" toml:"zone"`
+	Servers       []string      `yaml:"servers" toml:"servers"`
+	Network       string        `yaml:"network" toml:"network"`
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout"`
+	DNSSEC        bool          `yaml:"dnssec" toml:"dnssec"`
+	ProxyProtocol int           `yaml:"proxy_protocol" toml:"proxy_protocol"`
+	Interface     string        `yaml:"interface" toml:"interface"`
+	Source        string        `yaml:"source" toml:"source"`
+}
+
+// BlocklistConfig 域名黑名单, File 与 Domains 中的规则格式见 BlocklistCompiler, 包括 hosts 文件与 adblock 格式
+type BlocklistConfig struct {
+	File    string   `yaml:"file" toml:"file"`
+	Domains []string `yaml:"domains" toml:"domains"`
+}
+
+// ViewConfig 来自 Networks 的客户端使用的视图
+type ViewConfig struct {
+	Name       string            `yaml:"name" toml:"name"`
+	Networks   []string          `yaml:"networks" toml:"networks"`
+	Zones      []ZoneConfig      `yaml:"zones" toml:"zones"`
+	Forwarders []ForwarderConfig `yaml:"forwarders" toml:"forwarders"`
+	Blocklists []BlocklistConfig `yaml:"blocklists" toml:"blocklists"`
+}
+
+// LoadServerConfig 读取配置文件, .toml 按 TOML 解析, 其它按 YAML (包括 JSON) 解析
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := "yaml"
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		format = "toml"
+	}
+	c, err := ParseServerConfig(data, format)
+	if err != nil {
+		return nil, errors.WithMessage(err, path)
+	}
+	c.dir = filepath.Dir(path)
+	return c, nil
+}
+
+// ParseServerConfig 按 format (yaml 或 toml) 解析配置, 未知字段视为错误
+func ParseServerConfig(data []byte, format string) (*ServerConfig, error) {
+	c := &ServerConfig{}
+	switch format {
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return nil, errors.WithMessage(ErrConfig, err.Error())
+		}
+	case "toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return nil, errors.WithMessage(ErrConfig, err.Error())
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, errors.WithMessage(ErrConfig, "unknown field "+undecoded[0].String())
+		}
+	default:
+		return nil, errors.WithMessage(ErrConfig, "unknown format "+format)
+	}
+	return c, nil
+}
+
+func (c *ServerConfig) path(p string) string {
+	if p == "" || filepath.IsAbs(p) || c.dir == "" {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
+
+// Handler 按配置构建处理器, 读取其中引用的区域与黑名单文件
+func (c *ServerConfig) Handler() (DNSHandler, error) {
+	fallback, err := c.viewHandler(&ViewConfig{Zones: c.Zones, Forwarders: c.Forwarders, Blocklists: c.Blocklists})
+	if err != nil {
+		return nil, err
+	}
+	var views []*View
+	for i := range c.Views {
+		vc := &c.Views[i]
+		h, err := c.viewHandler(vc)
+		if err != nil {
+			return nil, errors.WithMessage(err, "view "+vc.Name)
+		}
+		v := &View{Name: vc.Name, Handler: h}
+		for _, n := range vc.Networks {
+			p, err := netip.ParsePrefix(n)
+			if err != nil {
+				return nil, errors.WithMessage(ErrConfig, "view "+vc.Name+": bad network "+n)
+			}
+			v.Networks = append(v.Networks, p)
+		}
+		views = append(views, v)
+	}
+	// 填充 DoT 与 DoH 客户端要求填充的响应
+	if len(views) == 0 {
+		return WithPadding(nil)(fallback), nil
+	}
+	return WithPadding(nil)(ViewHandler(views, fallback)), nil
+}
+
+func (c *ServerConfig) viewHandler(vc *ViewConfig) (DNSHandler, error) {
+	mux := &ZoneMux{}
+	for _, zc := range vc.Zones {
+		z, err := c.zone(zc)
+		if err != nil {
+			return nil, errors.WithMessage(err, "zone "+zc.Origin)
+		}
+		mux.Handle(z.Origin, z)
+	}
+	for _, fc := range vc.Forwarders {
+		h, err := forwarder(fc)
+		if err != nil {
+			return nil, errors.WithMessage(err, "forwarder "+fc.Zone)
+		}
+		// 同名的区域优先于转发
+		if zone, existing := mux.Match(fc.Zone); existing != nil && zone == normalizeDomain(fc.Zone) {
+			continue
+		}
+		mux.Handle(fc.Zone, h)
+	}
+	compiler := &BlocklistCompiler{}
+	for _, bc := range vc.Blocklists {
+		for _, d := range bc.Domains {
+			compiler.AddRule(d)
+		}
+		if bc.File == "" {
+			continue
+		}
+		f, err := os.Open(c.path(bc.File))
+		if err != nil {
+			return nil, err
+		}
+		err = compiler.AddSource(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.WithMessage(err, "blocklist "+bc.File)
+		}
+	}
+	blocklist := compiler.Compile()
+	if blocklist.Len() == 0 {
+		return mux, nil
+	}
+	return ChainHandler(mux, WithBlocklist(blocklist)), nil
+}
+
+func (c *ServerConfig) zone(zc ZoneConfig) (*Zone, error) {
+	if zc.Origin == "" {
+		return nil, errors.WithMessage(ErrConfig, "zone origin is empty")
+	}
+	z := NewZone(zc.Origin)
+	if zc.File != "" {
+		f, err := os.Open(c.path(zc.File))
+		if err != nil {
+			return nil, err
+		}
+		z, err = ParseZone(f, zc.Origin)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkZONEMD(z); err != nil {
+			return nil, errors.WithMessage(err, zc.File)
+		}
+	}
+	for _, line := range zc.Records {
+		rr, err := ParseRR(line, z.Origin, 3600)
+		if err != nil {
+			return nil, errors.WithMessage(err, line)
+		}
+		if err := z.Add(rr); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+func forwarder(fc ForwarderConfig) (DNSHandler, error) {
+	if len(fc.Servers) == 0 {
+		return nil, errors.WithMessage(ErrConfig, "no servers")
+	}
+	var middlewares []DialMiddleware
+	switch fc.ProxyProtocol {
+	case 0:
+	case 1, 2:
+		if fc.Network != "tcp" && fc.Network != "tls" {
+			return nil, errors.WithMessage(ErrConfig, "proxy protocol requires a tcp or tls forwarder")
+		}
+		middlewares = append(middlewares, WithProxyHeader(fc.ProxyProtocol))
+	default:
+		return nil, errors.WithMessage(ErrConfig, "unknown proxy protocol version "+strconv.Itoa(fc.ProxyProtocol))
+	}
+	var base Dialer
+	if fc.Interface != "" || fc.Source != "" {
+		b := &Binding{Interface: fc.Interface}
+		if fc.Source != "" {
+			addr, err := netip.ParseAddr(fc.Source)
+			if err != nil {
+				return nil, errors.WithMessage(ErrConfig, "bad source address "+fc.Source)
+			}
+			b.LocalAddr = addr
+		}
+		base = b.Dialer()
+	}
+	var transport RoundTripper
+	switch fc.Network {
+	case "", "udp":
+		if base != nil {
+			transport = UDPTransport{Dialer: base}
+		}
+	case "tcp":
+		transport = TCPTransport{}
+		if len(middlewares) > 0 || base != nil {
+			transport = TCPTransport{Dialer: ChainDialer(base, middlewares...)}
+		}
+	case "tls":
+		transport = TCPTransport{Dialer: ChainDialer(base, append([]DialMiddleware{WithTLS(nil)}, middlewares...)...)}
+	case "unix":
+		transport = UnixTransport{}
+	default:
+		return nil, errors.WithMessage(ErrConfig, "unknown network "+fc.Network)
+	}
+	var resolvers []*Resolver
+	for _, server := range fc.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil && fc.Network != "unix" {
+			port := "53"
+			if fc.Network == "tls" {
+				port = "853"
+			}
+			server = net.JoinHostPort(server, port)
+		}
+		r := &Resolver{Server: server, Timeout: fc.Timeout, Transport: transport}
+		if fc.Network == "tls" {
+			r.Interceptors = []Interceptor{PadQueries(nil)}
+		}
+		resolvers = append(resolvers, r)
+	}
+	if fc.DNSSEC {
+		return WithDNSSECValidation(&DNSSECValidator{Resolver: resolvers[0]})(ForwardHandler(resolvers...)), nil
+	}
+	return ForwardHandler(resolvers...), nil
+}
+
+// ConfigServer 按配置文件运行 DNSServer. 收到 SIGHUP (windows 等没有该信号的平台上除外) 或文件修改时间变化时重新加载:
+// 新请求使用新的处理器, 处理中的请求不受影响; 监听地址按需增删, tls 证书原地替换.
+// 删除的监听地址与 Run 结束时都会等待处理中的请求完成
+type ConfigServer struct {
+	Path string
+	// PollInterval 检查文件修改时间的间隔, 默认 5s, 为负时只响应 SIGHUP
+	PollInterval time.Duration
+	// Middlewares 在配置生成的处理器之前执行, 不随配置重新加载
+	Middlewares []ServerMiddleware
+	// OnReload 每次加载后调用, err 不为空时继续使用旧配置
+	OnReload func(err error)
+	// Sockets 不为空时从中取得监听 socket, 用于与新进程交接
+	Sockets *SocketSet
+	// ShutdownTimeout 关闭监听时等待处理中请求的时间, 默认 5s
+	ShutdownTimeout time.Duration
+
+	handler   atomic.Value // DNSHandler
+	mu        sync.Mutex
+	listeners map[ListenConfig]*configListener
+	order     []ListenConfig
+	modTime   time.Time
+}
+
+type configListener struct {
+	config           ListenConfig
+	network, address string // socket 的协议与配置中的地址
+	server           *DNSServer
+	closer           io.Closer
+	addr             net.Addr
+	cert             atomic.Value // *tls.Certificate
+}
+
+// Run 加载配置并开始服务, 直到 ctx 结束. 第一次加载失败时直接返回错误, 成功时调用 Sockets.Ready
+func (s *ConfigServer) Run(ctx context.Context) error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	defer s.closeAll()
+	if s.Sockets != nil {
+		// 配置中不再使用的继承 socket 直接关闭, 然后通知旧进程退出
+		_ = s.Sockets.Close()
+		if err := s.Sockets.Ready(); err != nil {
+			return err
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	notifyReload(hup)
+	defer signal.Stop(hup)
+	interval := s.PollInterval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-hup:
+		case <-tick:
+			info, err := os.Stat(s.Path)
+			s.mu.Lock()
+			unchanged := err == nil && info.ModTime().Equal(s.modTime)
+			s.mu.Unlock()
+			if unchanged {
+				continue
+			}
+		}
+		err := s.Reload()
+		if s.OnReload != nil {
+			s.OnReload(err)
+		}
+	}
+}
+
+// Reload 重新读取配置文件并应用, 出错时保持原配置
+func (s *ConfigServer) Reload() error {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return err
+	}
+	c, err := LoadServerConfig(s.Path)
+	if err != nil {
+		return err
+	}
+	h, err := c.Handler()
+	if err != nil {
+		return err
+	}
+	certs := map[ListenConfig]*tls.Certificate{}
+	for _, lc := range c.Listen {
+		if lc.Network != "tls" {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(c.path(lc.Cert), c.path(lc.Key))
+		if err != nil {
+			return errors.WithMessage(err, "load certificate for "+lc.Address)
+		}
+		certs[lc] = &cert
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = map[ListenConfig]*configListener{}
+	}
+	wanted := map[ListenConfig]bool{}
+	for _, lc := range c.Listen {
+		wanted[listenKey(lc)] = true
+	}
+	var opened, replaced []*configListener
+	for _, lc := range c.Listen {
+		key := listenKey(lc)
+		if l, ok := s.listeners[key]; ok {
+			if cert := certs[lc]; cert != nil {
+				l.cert.Store(cert)
+			}
+			continue
+		}
+		// 同一地址上设置改变的监听要先关闭才能重新绑定: 先停止读取, 等处理中的请求写出响应后再关闭 socket,
+		// 这段时间 (最多 ShutdownTimeout) 内该地址上新到达的请求不会被处理
+		for oldKey, old := range s.listeners {
+			if !wanted[oldKey] && old.network == socketNetwork(lc.Network) && old.address == lc.Address {
+				s.forget(old)
+				s.drain(old)
+				delete(s.listeners, oldKey)
+				replaced = append(replaced, old)
+			}
+		}
+		l, err := s.listen(lc, certs[lc])
+		if err != nil {
+			for _, l := range opened {
+				s.forget(l)
+				_ = l.closer.Close()
+				delete(s.listeners, listenKey(l.config))
+			}
+			s.restore(replaced)
+			return err
+		}
+		opened = append(opened, l)
+		s.listeners[key] = l
+	}
+	s.handler.Store(ChainHandler(h, s.Middlewares...))
+	// 先打开新的监听再关闭不再需要的监听
+	var order []ListenConfig
+	for _, key := range s.order {
+		if !wanted[key] {
+			if l, ok := s.listeners[key]; ok {
+				go s.shutdown(l)
+				delete(s.listeners, key)
+			}
+			continue
+		}
+		order = append(order, key)
+	}
+	for _, lc := range c.Listen {
+		key := listenKey(lc)
+		if !containsListen(order, key) {
+			order = append(order, key)
+		}
+	}
+	s.order = order
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// listenKey 比较监听时使用的配置, 证书路径不参与比较, 以便原地替换
+func listenKey(lc ListenConfig) ListenConfig {
+	lc.Cert, lc.Key = "", ""
+	return lc
+}
+
+// socketNetwork 配置中的协议对应的 socket 协议
+func socketNetwork(network string) string {
+	if network == "tls" {
+		return "tcp"
+	}
+	return network
+}
+
+// restore 重新加载失败时按原配置重新打开为了换绑而关闭的监听, 无法打开的从监听列表中去掉
+func (s *ConfigServer) restore(replaced []*configListener) {
+	for _, old := range replaced {
+		key := listenKey(old.config)
+		cert, _ := old.cert.Load().(*tls.Certificate)
+		l, err := s.listen(old.config, cert)
+		if err != nil {
+			var order []ListenConfig
+			for _, k := range s.order {
+				if k != key {
+					order = append(order, k)
+				}
+			}
+			s.order = order
+			continue
+		}
+		s.listeners[key] = l
+	}
+}
+
+func containsListen(list []ListenConfig, key ListenConfig) bool {
+	for _, k := range list {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ConfigServer) listen(lc ListenConfig, cert *tls.Certificate) (*configListener, error) {
+	server := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		h, _ := s.handler.Load().(DNSHandler)
+		if h == nil {
+			return nil, nil
+		}
+		return h.ServeDNS(ctx, req)
+	})}
+	l := &configListener{config: lc, server: server}
+	var trusted []netip.Prefix
+	if lc.ProxyProtocol {
+		for _, n := range strings.FieldsFunc(lc.ProxyTrusted, func(r rune) bool { return r == ',' || r == ' ' }) {
+			p, err := netip.ParsePrefix(n)
+			if err != nil {
+				return nil, errors.WithMessage(ErrConfig, "bad proxy_trusted network "+n)
+			}
+			trusted = append(trusted, p)
+		}
+		if len(trusted) == 0 {
+			return nil, errors.WithMessage(ErrConfig, "proxy_protocol on "+lc.Address+" requires proxy_trusted")
+		}
+	}
+	var b *Binding
+	if lc.Interface != "" {
+		b = &Binding{Interface: lc.Interface}
+	}
+	switch lc.Network {
+	case "udp":
+		conns, err := s.listenPacket(b, "udp", lc.Address, lc.Sockets)
+		if err != nil {
+			return nil, err
+		}
+		l.network, l.address = "udp", lc.Address
+		l.closer, l.addr = packetConns(conns), conns[0].LocalAddr()
+		go server.ServeUDPConns(conns)
+	case "tcp", "tls":
+		ln, err := s.listenStream(b, "tcp", lc.Address)
+		if err != nil {
+			return nil, err
+		}
+		l.network, l.address = "tcp", lc.Address
+		l.closer, l.addr = ln, ln.Addr()
+		if lc.ProxyProtocol {
+			ln = &ProxyListener{Listener: ln, Trusted: trusted}
+		}
+		if lc.Network == "tls" {
+			l.cert.Store(cert)
+			ln = tls.NewListener(ln, &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return l.cert.Load().(*tls.Certificate), nil
+			}})
+		}
+		go server.ServeTCP(ln)
+	case "unix":
+		// 删除上次异常退出遗留的文件, 继承的监听仍然有效时保留
+		if s.Sockets == nil {
+			if info, err := os.Stat(lc.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+				_ = os.Remove(lc.Address)
+			}
+		}
+		ln, err := s.listenStream(nil, "unix", lc.Address)
+		if err != nil {
+			return nil, err
+		}
+		if s.Sockets != nil {
+			// 交接后旧进程关闭监听时不能删除新进程仍在使用的文件
+			keepUnixSocketFile(ln)
+		}
+		l.network, l.address = "unix", lc.Address
+		l.closer, l.addr = ln, ln.Addr()
+		go server.ServeTCP(ln)
+	default:
+		return nil, errors.WithMessage(ErrConfig, "unknown listen network "+lc.Network)
+	}
+	return l, nil
+}
+
+// Addrs 返回当前监听的地址, 顺序与配置相同
+func (s *ConfigServer) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var addrs []net.Addr
+	for _, key := range s.order {
+		addrs = append(addrs, s.listeners[key].addr)
+	}
+	return addrs
+}
+
+func (s *ConfigServer) listenPacket(b *Binding, network, address string, sockets int) ([]net.PacketConn, error) {
+	if sockets > 1 {
+		opts := &UDPListenOptions{Sockets: sockets, Binding: b}
+		if s.Sockets != nil {
+			return s.Sockets.ListenUDPReusePort(context.Background(), network, address, opts)
+		}
+		return ListenUDPReusePort(context.Background(), network, address, opts)
+	}
+	var conn net.PacketConn
+	var err error
+	if s.Sockets != nil {
+		conn, err = s.Sockets.listenPacket(b, network, address)
+	} else {
+		conn, err = b.ListenPacket(context.Background(), network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []net.PacketConn{conn}, nil
+}
+
+// packetConns 同一地址上的多个 udp socket
+type packetConns []net.PacketConn
+
+func (c packetConns) Close() error {
+	var first error
+	for _, conn := range c {
+		if err := conn.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (s *ConfigServer) listenStream(b *Binding, network, address string) (net.Listener, error) {
+	if s.Sockets != nil {
+		return s.Sockets.listen(b, network, address)
+	}
+	return b.Listen(context.Background(), network, address)
+}
+
+// forget 不再把 l 交给新进程
+func (s *ConfigServer) forget(l *configListener) {
+	if s.Sockets != nil {
+		s.Sockets.Forget(l.network, l.address)
+	}
+}
+
+// shutdown 关闭 l 并等待处理中的请求
+func (s *ConfigServer) shutdown(l *configListener) {
+	s.forget(l)
+	s.drain(l)
+}
+
+// drain 等待 l 上处理中的请求后关闭 l, 不影响交接的 socket
+func (s *ConfigServer) drain(l *configListener) {
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = l.server.Shutdown(ctx)
+	_ = l.closer.Close()
+}
+
+func (s *ConfigServer) closeAll() {
+	s.mu.Lock()
+	var wg sync.WaitGroup
+	for key, l := range s.listeners {
+		wg.Add(1)
+		go func(l *configListener) {
+			defer wg.Done()
+			s.shutdown(l)
+		}(l)
+		delete(s.listeners, key)
+	}
+	s.order = nil
+	s.mu.Unlock()
+	wg.Wait()
+}
@@ -0,0 +1,9 @@
+//go:build js || plan9 || windows
+// +build js plan9 windows
+
+package netx
+
+import "os"
+
+// notifyReload 没有 SIGHUP 的平台上只按文件修改时间重新加载
+func notifyReload(c chan<- os.Signal) {}
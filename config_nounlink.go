@@ -0,0 +1,9 @@
+//go:build plan9
+// +build plan9
+
+package netx
+
+import "net"
+
+// keepUnixSocketFile plan9 没有 unix socket
+func keepUnixSocketFile(ln net.Listener) {}
@@ -0,0 +1,15 @@
+//go:build !js && !plan9 && !windows
+// +build !js,!plan9,!windows
+
+package netx
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload 收到 SIGHUP 时向 c 发送通知
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
@@ -0,0 +1,327 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerConfig(t *testing.T) {
+	upstream, _ := startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Message.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, RData: "203.0.113.1"}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "example.com.zone"), []byte("@ IN SOA ns hostmaster 1 1h 15m 1w 300\nwww IN A 192.0.2.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "block.txt"), []byte("# ads\nads.example.net\n||adblock.example.net^\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hosts.txt"), []byte("127.0.0.1 localhost\n0.0.0.0 tracker.example.net tracker.example.org # ads\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	yamlConfig := `
+zones:
+  - origin: example.com
+    file: example.com.zone
+forwarders:
+  - zone: .
+    servers: ["` + upstream + `"]
+    timeout: 2s
+blocklists:
+  - file: block.txt
+  - file: hosts.txt
+views:
+  - name: internal
+    networks: ["10.0.0.0/8"]
+    zones:
+      - origin: example.com
+        records: ["www IN A 10.0.0.1"]
+`
+	tomlConfig := `
+[[zones]]
+origin = "example.com"
+file = "example.com.zone"
+
+[[forwarders]]
+servers = ["` + upstream + `"]
+timeout = "2s"
+
+[[blocklists]]
+file = "block.txt"
+
+[[blocklists]]
+file = "hosts.txt"
+
+[[views]]
+name = "internal"
+networks = ["10.0.0.0/8"]
+[[views.zones]]
+origin = "example.com"
+records = ["www IN A 10.0.0.1"]
+`
+	for name, data := range map[string]string{"netx.yaml": yamlConfig, "netx.toml": tomlConfig} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := LoadServerConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.Forwarders[0].Timeout != 2*time.Second {
+			t.Fatalf("%s: timeout = %v", name, c.Forwarders[0].Timeout)
+		}
+		h, err := c.Handler()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		query := func(client, host string) string {
+			resp, err := h.ServeDNS(context.Background(), &DNSRequest{
+				Message:    NewQuery(host, DNSTypeA),
+				RemoteAddr: &net.UDPAddr{IP: net.ParseIP(client)},
+			})
+			if err != nil {
+				return err.Error()
+			}
+			if resp.Header.Flags.RCode != 0 {
+				return "rcode " + string(rune('0'+resp.Header.Flags.RCode))
+			}
+			return resp.Answers()[0].RData
+		}
+		for _, c := range [][3]string{
+			{"192.0.2.100", "www.example.com", "192.0.2.1"},
+			{"10.1.2.3", "www.example.com", "10.0.0.1"},
+			{"192.0.2.100", "www.example.org", "203.0.113.1"},
+			{"10.1.2.3", "www.example.org", "rcode 5"},
+			{"192.0.2.100", "x.ads.example.net", "rcode 3"},
+			{"192.0.2.100", "x.adblock.example.net", "rcode 3"},
+			{"192.0.2.100", "tracker.example.org", "rcode 3"},
+			// hosts 格式只拦截名字本身
+			{"192.0.2.100", "x.tracker.example.net", "203.0.113.1"},
+			{"192.0.2.100", "localhost", "203.0.113.1"},
+		} {
+			if got := query(c[0], c[1]); got != c[2] {
+				t.Fatalf("%s: %s from %s = %s, want %s", name, c[1], c[0], got, c[2])
+			}
+		}
+	}
+
+	if _, err := ParseServerConfig([]byte("listen: [{network: udp, adress: x}]"), "yaml"); err == nil || !strings.Contains(err.Error(), "adress") {
+		t.Fatalf("unknown field = %v", err)
+	}
+}
+
+func TestConfigServerReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "netx.yaml")
+	write := func(ip string) {
+		config := "listen:\n  - {network: udp, address: \"127.0.0.1:0\"}\nzones:\n  - origin: example.com\n    records: [\"www IN A " + ip + "\"]\n"
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("192.0.2.1")
+	s := &ConfigServer{Path: path, PollInterval: -1}
+	addr := runConfigServer(t, s)
+	r := &Resolver{Server: addr.String()}
+	if addrs, err := r.LookupHost(context.Background(), "www.example.com"); err != nil || addrs[0] != "192.0.2.1" {
+		t.Fatalf("before reload = %v, %v", addrs, err)
+	}
+	write("192.0.2.2")
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs, err := r.LookupHost(context.Background(), "www.example.com"); err != nil || addrs[0] != "192.0.2.2" {
+		t.Fatalf("after reload = %v, %v", addrs, err)
+	}
+	if s.Addrs()[0].String() != addr.String() {
+		t.Fatal("listener was reopened")
+	}
+	if err := os.WriteFile(path, []byte("zones: [{origin: \"\"}]"), 0o644); err == nil {
+		if err := s.Reload(); err == nil {
+			t.Fatal("bad config accepted")
+		}
+	}
+	if addrs, err := r.LookupHost(context.Background(), "www.example.com"); err != nil || addrs[0] != "192.0.2.2" {
+		t.Fatalf("after failed reload = %v, %v", addrs, err)
+	}
+
+	// PROXY protocol 必须指定信任的来源
+	if err := os.WriteFile(path, []byte("listen: [{network: tcp, address: \"127.0.0.1:0\", proxy_protocol: true}]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); errors.Cause(err) != ErrConfig {
+		t.Fatalf("proxy_protocol without proxy_trusted = %v", err)
+	}
+}
+
+// runConfigServer 在后台运行 s 直到测试结束, 返回第一个监听地址
+func runConfigServer(t *testing.T, s *ConfigServer) net.Addr {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for i := 0; i < 100; i++ {
+		if addrs := s.Addrs(); len(addrs) > 0 {
+			return addrs[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+	return nil
+}
+
+func TestConfigServerReloadSockets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netx.yaml")
+	write := func(ip string) {
+		config := "listen:\n  - {network: udp, address: \"127.0.0.1:0\", sockets: 2}\nzones:\n  - origin: example.com\n    records: [\"www IN A " + ip + "\"]\n"
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("192.0.2.1")
+	s := &ConfigServer{Path: path, PollInterval: -1}
+	addr := runConfigServer(t, s)
+	n := len(s.Addrs())
+
+	// 每个 socket 都使用新的处理器, 多次查询会分散到不同的 socket
+	write("192.0.2.2")
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		r := &Resolver{Server: addr.String()}
+		if addrs, err := r.LookupHost(context.Background(), "www.example.com"); err != nil || addrs[0] != "192.0.2.2" {
+			t.Fatalf("after reload = %v, %v", addrs, err)
+		}
+	}
+	if addrs := s.Addrs(); len(addrs) != n || addrs[0].String() != addr.String() {
+		t.Fatalf("listeners changed: %v", addrs)
+	}
+}
+
+func TestConfigServerReloadListenSettings(t *testing.T) {
+	// 使用固定端口, 重新打开的监听必须绑定到同一地址
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	path := filepath.Join(t.TempDir(), "netx.yaml")
+	write := func(trusted string) {
+		config := "listen:\n  - {network: tcp, address: \"" + address + "\", proxy_protocol: true, proxy_trusted: \"" + trusted + "\"}\n" +
+			"zones:\n  - origin: example.com\n    records: [\"www IN A 192.0.2.1\"]\n" +
+			"views:\n  - name: internal\n    networks: [\"10.0.0.0/8\"]\n    zones:\n      - origin: example.com\n        records: [\"www IN A 10.0.0.1\"]\n"
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("127.0.0.0/8")
+	s := &ConfigServer{Path: path, PollInterval: -1}
+	runConfigServer(t, s)
+
+	lookup := func() (string, error) {
+		fwd := ForwardHandler(&Resolver{Server: address, Timeout: 500 * time.Millisecond, Transport: TCPTransport{Dialer: ChainDialer(nil, WithProxyHeader(2))}})
+		resp, err := fwd.ServeDNS(WithProxyDestination(context.Background(), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53}), &DNSRequest{
+			Message:    NewQuery("www.example.com", DNSTypeA),
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 4444},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(resp.ResourceRecodes) == 0 {
+			return "", errors.New("empty answer")
+		}
+		return resp.ResourceRecodes[0].RData, nil
+	}
+	if ip, err := lookup(); err != nil || ip != "10.0.0.1" {
+		t.Fatalf("trusted proxy = %v, %v", ip, err)
+	}
+
+	// 不再信任本机后, 本机发来的头不能再伪造客户端地址
+	write("192.0.2.0/24")
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if ip, err := lookup(); err == nil {
+		t.Fatalf("header accepted after proxy_trusted changed: %v", ip)
+	}
+	if addrs := s.Addrs(); len(addrs) != 1 || addrs[0].String() != address {
+		t.Fatalf("listeners %v", addrs)
+	}
+	plain := &Resolver{Server: address, Transport: TCPTransport{}}
+	if addrs, err := plain.LookupHost(context.Background(), "www.example.com"); err != nil || addrs[0] != "192.0.2.1" {
+		t.Fatalf("plain query = %v, %v", addrs, err)
+	}
+}
+
+func TestConfigServerReloadInFlight(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	path := filepath.Join(t.TempDir(), "netx.yaml")
+	write := func(sockets int) {
+		config := "listen:\n  - {network: udp, address: \"" + address + "\", sockets: " + strconv.Itoa(sockets) + "}\n" +
+			"zones:\n  - origin: example.com\n    records: [\"slow IN A 192.0.2.1\"]\n"
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1)
+	started := make(chan struct{}, 1)
+	slow := func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			time.Sleep(300 * time.Millisecond)
+			return next.ServeDNS(ctx, req)
+		})
+	}
+	s := &ConfigServer{Path: path, PollInterval: -1, Middlewares: []ServerMiddleware{slow}}
+	runConfigServer(t, s)
+
+	// 修改 sockets 需要在同一地址上重新打开监听, 处理中的查询仍然得到应答
+	type result struct {
+		addrs []string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r := &Resolver{Server: address, Timeout: 2 * time.Second}
+		addrs, err := r.LookupHost(context.Background(), "slow.example.com")
+		done <- result{addrs, err}
+	}()
+	<-started
+	write(2)
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-done; res.err != nil || len(res.addrs) != 1 || res.addrs[0] != "192.0.2.1" {
+		t.Fatalf("in-flight query = %v, %v", res.addrs, res.err)
+	}
+	r := &Resolver{Server: address, Timeout: 2 * time.Second}
+	if addrs, err := r.LookupHost(context.Background(), "slow.example.com"); err != nil || addrs[0] != "192.0.2.1" {
+		t.Fatalf("after reload = %v, %v", addrs, err)
+	}
+}
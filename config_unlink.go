@@ -0,0 +1,13 @@
+//go:build !plan9
+// +build !plan9
+
+package netx
+
+import "net"
+
+// keepUnixSocketFile 关闭 ln 时不删除 socket 文件
+func keepUnixSocketFile(ln net.Listener) {
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+}
@@ -0,0 +1,227 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConnFeedbackFall    = 3
+	defaultConnFeedbackExpire  = 5 * time.Minute
+	defaultConnFeedbackEntries = 10000
+)
+
+// ConnFeedback 记录每个地址的连接结果, 解析时据此调整地址顺序: 最近连接成功的地址按连接耗时排在前面,
+// 连续失败的地址排在后面, 失败达到 Fall 次后不再返回 (例如故障的 anycast 实例). 同一地址族的地址
+// 连续失败 Fall 次时 (例如 IPv6 不通), 该地址族未连接过的地址排在另一地址族之后, 同样在 Expire 后重新尝试.
+// 通过 WithConnFeedback 记录结果, 通过 Interceptor 调整 Resolver 的应答. 零值可用, 可以并发使用
+type ConnFeedback struct {
+	// Fall 连续失败多少次后不再返回该地址, 全部地址都达到时仍返回全部地址, 默认 3
+	Fall int
+	// Expire 失败记录的有效期, 过期后重新尝试该地址, 默认 5m
+	Expire time.Duration
+
+	mu       sync.Mutex
+	addrs    map[netip.Addr]*connResult
+	families [2]connResult // ipv4 与 ipv6 的连续失败次数
+}
+
+type connResult struct {
+	failures int           // 连续失败次数
+	rtt      time.Duration // 最近一次成功的连接耗时
+	updated  time.Time
+}
+
+// 地址的排序等级, 越小越靠前
+const (
+	connRankConnected = iota
+	connRankUnknown
+	connRankFamilyFailing
+	connRankFailing
+	connRankSuppressed
+)
+
+func (f *ConnFeedback) fall() int {
+	if f.Fall <= 0 {
+		return defaultConnFeedbackFall
+	}
+	return f.Fall
+}
+
+func (f *ConnFeedback) expire() time.Duration {
+	if f.Expire <= 0 {
+		return defaultConnFeedbackExpire
+	}
+	return f.Expire
+}
+
+func connFamily(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// Report 记录一次到 addr 的连接结果, elapsed 为连接耗时
+func (f *ConnFeedback) Report(addr netip.Addr, elapsed time.Duration, err error) {
+	addr = addr.Unmap().WithZone("")
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.addrs == nil {
+		f.addrs = map[netip.Addr]*connResult{}
+	}
+	r, ok := f.addrs[addr]
+	if !ok {
+		if len(f.addrs) >= defaultConnFeedbackEntries {
+			f.evict(now)
+		}
+		r = &connResult{}
+		f.addrs[addr] = r
+	}
+	family := &f.families[connFamily(addr)]
+	r.updated, family.updated = now, now
+	if err != nil {
+		r.failures++
+		family.failures++
+		return
+	}
+	r.failures, r.rtt = 0, elapsed
+	family.failures = 0
+}
+
+// evict 删除过期的记录, 仍然太多时全部清空
+func (f *ConnFeedback) evict(now time.Time) {
+	for addr, r := range f.addrs {
+		if now.Sub(r.updated) > f.expire() {
+			delete(f.addrs, addr)
+		}
+	}
+	if len(f.addrs) >= defaultConnFeedbackEntries {
+		f.addrs = map[netip.Addr]*connResult{}
+	}
+}
+
+// rank 返回地址的排序等级与最近的连接耗时, 调用时持有 mu
+func (f *ConnFeedback) rank(addr netip.Addr, now time.Time) (int, time.Duration) {
+	addr = addr.Unmap().WithZone("")
+	r, ok := f.addrs[addr]
+	if ok && r.failures > 0 && now.Sub(r.updated) > f.expire() {
+		ok = false
+	}
+	family := &f.families[connFamily(addr)]
+	switch {
+	case ok && r.failures == 0:
+		return connRankConnected, r.rtt
+	case !ok && family.failures >= f.fall() && now.Sub(family.updated) <= f.expire():
+		return connRankFamilyFailing, 0
+	case !ok:
+		return connRankUnknown, 0
+	case r.failures < f.fall():
+		return connRankFailing, 0
+	}
+	return connRankSuppressed, 0
+}
+
+// Healthy 地址是否没有达到 Fall 次连续失败, 未知或无法解析的地址视为健康. 实现 TargetStatus
+func (f *ConnFeedback) Healthy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rank, _ := f.rank(ip, time.Now())
+	return rank != connRankSuppressed
+}
+
+// Latency 最近一次成功的连接耗时, 未知时为 0. 实现 TargetLatency
+func (f *ConnFeedback) Latency(addr string) time.Duration {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, rtt := f.rank(ip, time.Now())
+	return rtt
+}
+
+// AnswerPolicy 按连接结果排序地址记录并去掉不再返回的地址, 相同等级的地址保持原顺序
+func (f *ConnFeedback) AnswerPolicy() AnswerPolicy {
+	return func(req *DNSRequest, addrs []*DNSResourceRecode) []*DNSResourceRecode {
+		type ranked struct {
+			rr   *DNSResourceRecode
+			rank int
+			rtt  time.Duration
+		}
+		now := time.Now()
+		list := make([]ranked, len(addrs))
+		f.mu.Lock()
+		for i, rr := range addrs {
+			list[i] = ranked{rr: rr, rank: connRankUnknown}
+			if ip, err := netip.ParseAddr(rr.RData); err == nil {
+				list[i].rank, list[i].rtt = f.rank(ip, now)
+			}
+		}
+		f.mu.Unlock()
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].rank != list[j].rank {
+				return list[i].rank < list[j].rank
+			}
+			return list[i].rtt < list[j].rtt
+		})
+		result := make([]*DNSResourceRecode, 0, len(list))
+		for _, r := range list {
+			// 第一个地址也不再返回时说明全部如此, 仍返回全部地址
+			if r.rank == connRankSuppressed && list[0].rank != connRankSuppressed {
+				break
+			}
+			result = append(result, r.rr)
+		}
+		return result
+	}
+}
+
+// Interceptor 对 Resolver 的应答应用 AnswerPolicy, LookupHost 等方法因此按连接结果返回地址
+func (f *ConnFeedback) Interceptor() Interceptor {
+	policies := []AnswerPolicy{f.AnswerPolicy()}
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := next.RoundTrip(ctx, server, req)
+			if err != nil {
+				return nil, err
+			}
+			applyAnswerPolicies(nil, resp, policies)
+			return resp, nil
+		})
+	}
+}
+
+// WithConnFeedback 把连接 IP 地址的结果记录到 f, 主机名与 unix socket 不记录.
+// 调用方取消的连接 (例如 Happy Eyeballs 中落后的一方) 不算失败
+func WithConnFeedback(f *ConnFeedback) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return next.DialContext(ctx, network, address)
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return next.DialContext(ctx, network, address)
+			}
+			start := time.Now()
+			conn, err := next.DialContext(ctx, network, address)
+			if err != nil && ctx.Err() != nil {
+				return nil, err
+			}
+			f.Report(addr, time.Since(start), err)
+			return conn, err
+		})
+	}
+}
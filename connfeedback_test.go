@@ -0,0 +1,124 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConnFeedback(t *testing.T) {
+	f := &ConnFeedback{}
+	server := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		name := req.Message.Questions[0].QuestionName
+		for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+			resp.ResourceRecodes = append(resp.ResourceRecodes, aRecord(name, ip))
+		}
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+		return resp, nil
+	})}
+	r := &Resolver{Server: "192.0.2.53:53", Transport: PipeTransport{Server: server}, Interceptors: []Interceptor{f.Interceptor()}}
+	check := func(want ...string) {
+		t.Helper()
+		addrs, err := r.LookupHost(context.Background(), "www.example.com")
+		if err != nil || !reflect.DeepEqual(addrs, want) {
+			t.Fatalf("LookupHost = %v, %v, want %v", addrs, err, want)
+		}
+	}
+	failed := errors.New("connection refused")
+	check("192.0.2.1", "192.0.2.2", "192.0.2.3")
+
+	// 失败的地址排在未知地址之后, 成功的地址按耗时排在最前
+	f.Report(netip.MustParseAddr("192.0.2.1"), 0, failed)
+	check("192.0.2.2", "192.0.2.3", "192.0.2.1")
+	f.Report(netip.MustParseAddr("192.0.2.2"), 20*time.Millisecond, nil)
+	f.Report(netip.MustParseAddr("192.0.2.3"), 10*time.Millisecond, nil)
+	check("192.0.2.3", "192.0.2.2", "192.0.2.1")
+
+	// 连续失败 Fall 次后不再返回, 全部如此时仍返回全部地址
+	f.Report(netip.MustParseAddr("192.0.2.1"), 0, failed)
+	f.Report(netip.MustParseAddr("192.0.2.1"), 0, failed)
+	check("192.0.2.3", "192.0.2.2")
+	if f.Healthy("192.0.2.1") || !f.Healthy("192.0.2.2") || f.Latency("192.0.2.3") != 10*time.Millisecond {
+		t.Fatal("Healthy or Latency mismatch")
+	}
+	for i := 0; i < 3; i++ {
+		f.Report(netip.MustParseAddr("192.0.2.2"), 0, failed)
+		f.Report(netip.MustParseAddr("192.0.2.3"), 0, failed)
+	}
+	check("192.0.2.1", "192.0.2.2", "192.0.2.3")
+
+	// 失败记录过期后重新尝试
+	f.Expire = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if !f.Healthy("192.0.2.1") {
+		t.Fatal("failure did not expire")
+	}
+}
+
+func TestConnFeedbackFamily(t *testing.T) {
+	f := &ConnFeedback{}
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"} {
+		f.Report(netip.MustParseAddr(ip), 0, errors.New("network unreachable"))
+	}
+	// 未连接过的 IPv6 地址排在 IPv4 之后
+	addrs := []*DNSResourceRecode{
+		{Name: "www.example.com", RRType: DNSTypeAAAA, Class: DNSClassIn, RData: "2001:db8::9"},
+		aRecord("www.example.com", "192.0.2.9"),
+	}
+	result := f.AnswerPolicy()(nil, addrs)
+	if len(result) != 2 || result[0].RData != "192.0.2.9" {
+		t.Fatalf("result = %v %v", result[0].RData, result[1].RData)
+	}
+
+	// 地址族的失败记录过期后, 未连接过的 IPv6 地址恢复原来的顺序
+	f.Expire = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if result = f.AnswerPolicy()(nil, addrs); result[0].RData != "2001:db8::9" {
+		t.Fatalf("after expiry result = %v %v", result[0].RData, result[1].RData)
+	}
+}
+
+func TestWithConnFeedback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	_ = closed.Close()
+
+	f := &ConnFeedback{Fall: 1}
+	d := ChainDialer(&net.Dialer{}, WithConnFeedback(f))
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if _, err := d.DialContext(context.Background(), "tcp", closedAddr); err == nil {
+		t.Fatal("dial closed port succeeded")
+	}
+	if f.Healthy("127.0.0.1") {
+		t.Fatal("failure not recorded")
+	}
+
+	// 调用方取消的连接不记录
+	f = &ConnFeedback{Fall: 1}
+	d = ChainDialer(&net.Dialer{}, WithConnFeedback(f))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DialContext(ctx, "tcp", ln.Addr().String()); err == nil {
+		t.Fatal("dial with canceled context succeeded")
+	}
+	if !f.Healthy("127.0.0.1") {
+		t.Fatal("canceled dial recorded as failure")
+	}
+}
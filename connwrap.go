@@ -0,0 +1,242 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenBucket 按字节计数的令牌桶, 可以被多个连接共享以限制总带宽
+type TokenBucket struct {
+	rate  float64 // 每秒令牌数
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket rate 为每秒字节数, burst 为桶容量, 小于等于 0 时与 rate 相同
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &TokenBucket{rate: float64(rate), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// Burst 单次最多可以取出的令牌数
+func (b *TokenBucket) Burst() int {
+	return b.burst
+}
+
+// WaitN 取出 n 个令牌, 不足时等待. n 大于 Burst 时按 Burst 计算, rate 不大于 0 时不限速
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	if b.rate <= 0 {
+		return nil
+	}
+	if n > b.burst {
+		n = b.burst
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+	// 先扣除令牌再等待, 后来者会排在后面
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedConn 读取后按实际字节数等待, 写入前按分块等待
+type rateLimitedConn struct {
+	net.Conn
+	read, write *TokenBucket
+}
+
+// NewRateLimitedConn read 或 write 为空时该方向不限速
+func NewRateLimitedConn(conn net.Conn, read, write *TokenBucket) net.Conn {
+	return &rateLimitedConn{Conn: conn, read: read, write: write}
+}
+
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(b)
+	}
+	if len(b) > c.read.Burst() {
+		b = b[:c.read.Burst()]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		_ = c.read.WaitN(context.Background(), n)
+	}
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(b)
+	}
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > c.write.Burst() {
+			chunk = chunk[:c.write.Burst()]
+		}
+		_ = c.write.WaitN(context.Background(), len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// SharedRateLimit 所有连接共享 read 与 write 两个令牌桶
+func SharedRateLimit(read, write *TokenBucket) func(net.Conn) net.Conn {
+	return func(conn net.Conn) net.Conn {
+		return NewRateLimitedConn(conn, read, write)
+	}
+}
+
+// PerConnRateLimit 每个连接单独限速, 单位为字节每秒, 0 表示不限
+func PerConnRateLimit(readRate, writeRate int) func(net.Conn) net.Conn {
+	return func(conn net.Conn) net.Conn {
+		var read, write *TokenBucket
+		if readRate > 0 {
+			read = NewTokenBucket(readRate, 0)
+		}
+		if writeRate > 0 {
+			write = NewTokenBucket(writeRate, 0)
+		}
+		return NewRateLimitedConn(conn, read, write)
+	}
+}
+
+// ConnMetrics 连接计数器, 可以同时用于拨号与接受的连接
+type ConnMetrics struct {
+	dials        int64
+	dialErrors   int64
+	dialLatency  int64 // 纳秒累计
+	accepted     int64
+	active       int64
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// ConnMetricsSnapshot ConnMetrics 某一时刻的值
+type ConnMetricsSnapshot struct {
+	Dials        int64
+	DialErrors   int64
+	DialLatency  time.Duration // 成功拨号的平均耗时
+	Accepted     int64
+	Active       int64
+	BytesRead    int64
+	BytesWritten int64
+}
+
+func (m *ConnMetrics) Snapshot() ConnMetricsSnapshot {
+	s := ConnMetricsSnapshot{
+		Dials:        atomic.LoadInt64(&m.dials),
+		DialErrors:   atomic.LoadInt64(&m.dialErrors),
+		Accepted:     atomic.LoadInt64(&m.accepted),
+		Active:       atomic.LoadInt64(&m.active),
+		BytesRead:    atomic.LoadInt64(&m.bytesRead),
+		BytesWritten: atomic.LoadInt64(&m.bytesWritten),
+	}
+	if ok := s.Dials - s.DialErrors; ok > 0 {
+		s.DialLatency = time.Duration(atomic.LoadInt64(&m.dialLatency) / ok)
+	}
+	return s
+}
+
+// WrapConn 统计 conn 的读写字节数与活跃连接数, 用于接受的连接
+func (m *ConnMetrics) WrapConn(conn net.Conn) net.Conn {
+	atomic.AddInt64(&m.accepted, 1)
+	return m.wrap(conn)
+}
+
+func (m *ConnMetrics) wrap(conn net.Conn) net.Conn {
+	atomic.AddInt64(&m.active, 1)
+	return &meteredConn{Conn: conn, m: m}
+}
+
+type meteredConn struct {
+	net.Conn
+	m    *ConnMetrics
+	once sync.Once
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.m.bytesRead, int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.m.bytesWritten, int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.m.active, -1)
+	})
+	return c.Conn.Close()
+}
+
+// WithMetrics 统计拨号次数、失败次数、耗时以及连接的读写字节数
+func WithMetrics(m *ConnMetrics) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			start := time.Now()
+			atomic.AddInt64(&m.dials, 1)
+			conn, err := next.DialContext(ctx, network, address)
+			if err != nil {
+				atomic.AddInt64(&m.dialErrors, 1)
+				return nil, err
+			}
+			atomic.AddInt64(&m.dialLatency, int64(time.Since(start)))
+			return m.wrap(conn), nil
+		})
+	}
+}
+
+// WrapListener 用 wrap 包装 ln 接受的每一个连接, 与 WithConnWrapper 对应
+func WrapListener(ln net.Listener, wrap func(net.Conn) net.Conn) net.Listener {
+	return &wrappedListener{Listener: ln, wrap: wrap}
+}
+
+type wrappedListener struct {
+	net.Listener
+	wrap func(net.Conn) net.Conn
+}
+
+func (l *wrappedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.wrap(conn), nil
+}
@@ -0,0 +1,52 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnWrappers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serverMetrics ConnMetrics
+	ln = WrapListener(ln, serverMetrics.WrapConn)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+
+	var clientMetrics ConnMetrics
+	d := ChainDialer(nil, WithMetrics(&clientMetrics), WithConnWrapper(PerConnRateLimit(0, 4096)))
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4096 字节的初始令牌之后, 另外 2048 字节需要约 0.5s
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 6144)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("write not rate limited, took %v", elapsed)
+	}
+	conn.Close()
+
+	s := clientMetrics.Snapshot()
+	if s.Dials != 1 || s.DialErrors != 0 || s.BytesWritten != 6144 || s.Active != 0 {
+		t.Fatalf("unexpected client metrics %+v", s)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if s := serverMetrics.Snapshot(); s.Accepted != 1 || s.BytesRead != 6144 {
+		t.Fatalf("unexpected server metrics %+v", s)
+	}
+}
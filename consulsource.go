@@ -0,0 +1,190 @@
+package netx
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrConsulStatus = errors.New("unexpected consul api status")
+
+// ConsulSource 按 Consul DNS 接口的命名从 HTTP API 读取服务目录:
+//
+//	[<tag>.]<service>.service[.<dc>].<domain>   通过健康检查的实例, A/AAAA/SRV
+//	<node>.node[.<dc>].<domain>                 节点地址
+type ConsulSource struct {
+	// Address 默认 http://127.0.0.1:8500
+	Address string
+	// Domain 默认 consul
+	Domain string
+	// Token 不为空时作为 X-Consul-Token 头
+	Token string
+	// Client 为空时使用 http.DefaultClient
+	Client *http.Client
+	// TTL 默认 5s
+	TTL uint32
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Node       string `json:"Node"`
+		Address    string `json:"Address"`
+		Datacenter string `json:"Datacenter"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+func (s *ConsulSource) get(ctx context.Context, path string, query url.Values, v interface{}) (string, error) {
+	address := s.Address
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+	u := strings.TrimSuffix(address, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.WithMessage(ErrConsulStatus, resp.Status)
+	}
+	return resp.Header.Get("X-Consul-Index"), json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *ConsulSource) LookupRecords(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error) {
+	domain := s.Domain
+	if domain == "" {
+		domain = "consul"
+	}
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = defaultSourceTTL
+	}
+	name = normalizeDomain(name)
+	if !strings.HasSuffix(name, "."+normalizeDomain(domain)) {
+		return nil, ErrRecordNotFound
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+normalizeDomain(domain)), ".")
+	// 找到 service 或 node 标签, 之后最多还有一个数据中心
+	kind := -1
+	for i, label := range labels {
+		if label == "service" || label == "node" {
+			kind = i
+		}
+	}
+	if kind < 1 || len(labels)-kind > 2 {
+		return nil, ErrRecordNotFound
+	}
+	query := url.Values{}
+	if kind+1 < len(labels) {
+		query.Set("dc", labels[kind+1])
+	}
+
+	if labels[kind] == "node" {
+		if kind != 1 {
+			return nil, ErrRecordNotFound
+		}
+		var node *struct {
+			Node struct {
+				Address string `json:"Address"`
+			} `json:"Node"`
+		}
+		if _, err := s.get(ctx, "/v1/catalog/node/"+url.PathEscape(labels[0]), query, &node); err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, ErrRecordNotFound
+		}
+		if rr := addressRecord("", node.Node.Address, qtype, ttl); rr != nil {
+			return []*DNSResourceRecode{rr}, nil
+		}
+		return nil, nil
+	}
+
+	if kind > 2 {
+		return nil, ErrRecordNotFound
+	}
+	service := labels[kind-1]
+	if kind == 2 {
+		query.Set("tag", labels[0])
+	}
+	query.Set("passing", "1")
+	var entries []consulServiceEntry
+	if _, err := s.get(ctx, "/v1/health/service/"+url.PathEscape(service), query, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	var records []*DNSResourceRecode
+	for _, entry := range entries {
+		addr := entry.Service.Address
+		if addr == "" {
+			addr = entry.Node.Address
+		}
+		if qtype == DNSTypeSRV {
+			target := entry.Node.Node + ".node."
+			if entry.Node.Datacenter != "" {
+				target += entry.Node.Datacenter + "."
+			}
+			records = append(records, srvRecord("", 1, 1, entry.Service.Port, target+normalizeDomain(domain)+".", ttl))
+			continue
+		}
+		if rr := addressRecord("", addr, qtype, ttl); rr != nil {
+			records = append(records, rr)
+		}
+	}
+	return records, nil
+}
+
+// Watch 使用阻塞查询监听服务目录的变化, 每次索引变化调用一次 fn
+func (s *ConsulSource) Watch(ctx context.Context, fn func()) error {
+	index := ""
+	for {
+		query := url.Values{"wait": {"5m"}}
+		if index != "" {
+			query.Set("index", index)
+		}
+		var services map[string][]string
+		next, err := s.get(ctx, "/v1/catalog/services", query, &services)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if index != "" && next != index {
+			fn()
+		}
+		if next == "" {
+			// 不支持阻塞查询时避免空转
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		index = next
+	}
+}
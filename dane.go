@@ -0,0 +1,201 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+)
+
+// TLSA 证书用途, 只支持 DANE-TA 与 DANE-EE
+const (
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+)
+
+var (
+	ErrDNSSECUnvalidated = errors.New("dns response not dnssec validated")
+	ErrNoUsableTLSA      = errors.New("no usable tlsa records")
+	ErrDANEMismatch      = errors.New("certificate does not match tlsa records")
+	ErrBadTLSA           = errors.New("invalid tlsa record")
+)
+
+// TLSARecord RFC 6698 TLSA 记录
+type TLSARecord struct {
+	Usage        uint8 // 0 PKIX-TA, 1 PKIX-EE, 2 DANE-TA, 3 DANE-EE
+	Selector     uint8 // 0 完整证书, 1 SubjectPublicKeyInfo
+	MatchingType uint8 // 0 原始数据, 1 sha256, 2 sha512
+	Data         []byte
+}
+
+// ParseTLSA 解析 TLSA 资源记录的 RDATA
+func ParseTLSA(rr *DNSResourceRecode) (*TLSARecord, error) {
+	if rr.RRType != DNSTypeTLSA || len(rr.Data) < 4 {
+		return nil, ErrBadTLSA
+	}
+	return &TLSARecord{
+		Usage:        rr.Data[0],
+		Selector:     rr.Data[1],
+		MatchingType: rr.Data[2],
+		Data:         append([]byte{}, rr.Data[3:]...),
+	}, nil
+}
+
+// Match cert 是否与记录匹配, 不检查 Usage
+func (t *TLSARecord) Match(cert *x509.Certificate) bool {
+	var data []byte
+	switch t.Selector {
+	case 0:
+		data = cert.Raw
+	case 1:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch t.MatchingType {
+	case 0:
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(data, t.Data)
+}
+
+// usable 只有 DANE-TA 与 DANE-EE 记录参与校验
+func (t *TLSARecord) usable() bool {
+	return (t.Usage == TLSAUsageDANETA || t.Usage == TLSAUsageDANEEE) && t.Selector <= 1 && t.MatchingType <= 2
+}
+
+// LookupTLSA 查询 _port._network.host 的 TLSA 记录. 请求设置 AD 位, 响应未经 DNSSEC 验证时返回 ErrDNSSECUnvalidated,
+// 因此 Resolver.Server 应当是可信的验证型递归服务器 (例如本机)
+func (r *Resolver) LookupTLSA(ctx context.Context, network, host string, port int) ([]*TLSARecord, error) {
+	if err := r.NameProfile.Check(host); err != nil {
+		return nil, err
+	}
+	req := NewQuery("_"+strconv.Itoa(port)+"._"+network+"."+host, DNSTypeTLSA)
+	req.Header.Flags.Z = flagAD
+	resp, err := r.Exchange(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Flags.RCode != DNSRCodeSuccess {
+		return nil, &RCodeError{RCode: resp.Header.Flags.RCode}
+	}
+	if resp.Header.Flags.Z&flagAD == 0 {
+		return nil, ErrDNSSECUnvalidated
+	}
+	var records []*TLSARecord
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeTLSA {
+			continue
+		}
+		record, err := ParseTLSA(rr)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DANEVerifier 返回用于 tls.Config.VerifyConnection 的校验函数 (RFC 7671).
+// DANE-EE 只比较叶子证书, 不检查名字与有效期; DANE-TA 要求链中有匹配的证书, 并以它为根校验叶子证书与 ServerName
+func DANEVerifier(records []*TLSARecord) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return ErrDANEMismatch
+		}
+		leaf := cs.PeerCertificates[0]
+		usable := false
+		for _, t := range records {
+			if !t.usable() {
+				continue
+			}
+			usable = true
+			switch t.Usage {
+			case TLSAUsageDANEEE:
+				if t.Match(leaf) {
+					return nil
+				}
+			case TLSAUsageDANETA:
+				for i, cert := range cs.PeerCertificates {
+					if !t.Match(cert) {
+						continue
+					}
+					roots := x509.NewCertPool()
+					roots.AddCert(cert)
+					intermediates := x509.NewCertPool()
+					for _, c := range cs.PeerCertificates[1:i] {
+						intermediates.AddCert(c)
+					}
+					_, err := leaf.Verify(x509.VerifyOptions{
+						DNSName:       cs.ServerName,
+						Roots:         roots,
+						Intermediates: intermediates,
+					})
+					if err == nil {
+						return nil
+					}
+				}
+			}
+		}
+		if !usable {
+			return ErrNoUsableTLSA
+		}
+		return ErrDANEMismatch
+	}
+}
+
+// DANEConfig 查询 host:port 的 TLSA 记录并返回强制 DANE 校验的 tls.Config, 可用于 SMTP STARTTLS 等场景.
+// base 为空时使用空配置, ServerName 为空时使用 host
+func DANEConfig(ctx context.Context, resolver *Resolver, host string, port int, base *tls.Config) (*tls.Config, error) {
+	records, err := resolver.LookupTLSA(ctx, "tcp", host, port)
+	if err != nil {
+		return nil, errors.WithMessage(err, "lookup tlsa error")
+	}
+	if len(records) == 0 {
+		return nil, ErrNoUsableTLSA
+	}
+	c := &tls.Config{}
+	if base != nil {
+		c = base.Clone()
+	}
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+	// 由 VerifyConnection 代替 PKIX 校验
+	c.InsecureSkipVerify = true
+	c.VerifyConnection = DANEVerifier(records)
+	return c, nil
+}
+
+// WithDANE 与 WithTLS 相同, 但证书必须与目标的 TLSA 记录匹配. 没有经过验证的 TLSA 记录时拨号失败
+func WithDANE(resolver *Resolver, config *tls.Config) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, portStr, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				return nil, err
+			}
+			c, err := DANEConfig(ctx, resolver, host, port, config)
+			if err != nil {
+				return nil, err
+			}
+			return WithTLS(c)(next).DialContext(ctx, network, address)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package netx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"net"
+	"testing"
+)
+
+func TestDANE(t *testing.T) {
+	cert := selfSignedCert(t, "mail.example.com")
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				_ = conn.Close()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	sum := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+	validated := true
+	resolver := &Resolver{
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			if name := req.Questions[0].QuestionName; name != "_"+port+"._tcp.127.0.0.1" {
+				t.Errorf("query name = %s", name)
+			}
+			flags := &DNSFlags{QR: 1}
+			if validated {
+				flags.Z = flagAD
+			}
+			return &DNSMessage{
+				Header: &DNSHeader{TxID: req.Header.TxID, Flags: flags, AnswerRRs: 1},
+				ResourceRecodes: []*DNSResourceRecode{{
+					RRType: DNSTypeTLSA,
+					Class:  DNSClassIn,
+					Data:   append([]byte{TLSAUsageDANEEE, 1, 1}, sum[:]...),
+				}},
+			}, nil
+		}),
+	}
+
+	dialer := ChainDialer(nil, WithDANE(resolver, &tls.Config{ServerName: "mail.example.com"}))
+	conn, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	sum[0] ^= 0xFF
+	if _, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		t.Fatal("mismatched tlsa accepted")
+	}
+
+	validated = false
+	if _, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String()); errors.Cause(err) != ErrDNSSECUnvalidated {
+		t.Fatalf("err = %v, want unvalidated", err)
+	}
+}
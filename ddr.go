@@ -0,0 +1,280 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ddrName 查询加密端点的特殊名字, RFC 9462
+const ddrName = "_dns.resolver.arpa"
+
+// SVCB 参数键, RFC 9460 与 RFC 9461
+const (
+	svcParamALPN     = 1
+	svcParamPort     = 3
+	svcParamIPv4Hint = 4
+	svcParamECH      = 5
+	svcParamIPv6Hint = 6
+	svcParamDoHPath  = 7
+)
+
+var (
+	ErrNoEncryptedResolver = errors.New("no verified encrypted resolver")
+	ErrDDRUnverified       = errors.New("designated resolver certificate does not cover the resolver address")
+)
+
+// EncryptionPolicy 使用加密 DNS 的策略
+type EncryptionPolicy int
+
+const (
+	EncryptionOpportunistic EncryptionPolicy = iota // 优先使用加密端点, 没有或查询失败时回退到明文 Do53
+	EncryptionStrict                                // 只使用 DoT/DoH, 没有可用的加密端点时失败
+	EncryptionPlaintext                             // 只使用明文 Do53
+)
+
+// DesignatedResolver DDR (RFC 9462) 或 DNR (RFC 9463) 发现的加密端点, 每个协议一个
+type DesignatedResolver struct {
+	Priority uint16
+	Target   string   // 证书中的名字
+	Protocol string   // "tls" 或 "https", 与 QueryInfo.Transport 相同
+	Addrs    []string // ipv4hint 与 ipv6hint, DNR 中为选项携带的地址
+	Port     int
+	DoHPath  string // 去掉 {?dns} 的路径, 只用于 https
+}
+
+// Server 连接 addr 时 Resolver.Server 的值
+func (d *DesignatedResolver) Server(addr string) string {
+	if d.Protocol == "https" {
+		return "https://" + net.JoinHostPort(d.Target, strconv.Itoa(d.Port)) + d.DoHPath
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(d.Port))
+}
+
+// Transport 连接 addr 的 Transport, 以 Target 验证证书. config 可以为空
+func (d *DesignatedResolver) Transport(config *tls.Config, addr string) RoundTripper {
+	c := d.tlsConfig(config)
+	if d.Protocol == "https" {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = c
+		// URL 中是 Target, 连接到发现的地址
+		target := net.JoinHostPort(addr, strconv.Itoa(d.Port))
+		t.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, target)
+		}
+		return HTTPSTransport{Client: &http.Client{Transport: t}}
+	}
+	return TCPTransport{Dialer: ChainDialer(nil, WithTLS(c))}
+}
+
+func (d *DesignatedResolver) tlsConfig(config *tls.Config) *tls.Config {
+	c := &tls.Config{}
+	if config != nil {
+		c = config.Clone()
+	}
+	c.ServerName = d.Target
+	if d.Protocol == "tls" {
+		c.NextProtos = []string{"dot"}
+	}
+	return c
+}
+
+// DiscoverDesignatedResolvers 向 Server 查询 _dns.resolver.arpa 的 SVCB 记录 (RFC 9462),
+// 按优先级返回它的加密端点. 结果未经验证, UpgradeEncrypted 会验证证书
+func (r *Resolver) DiscoverDesignatedResolvers(ctx context.Context) ([]*DesignatedResolver, error) {
+	resp, err := r.Query(ctx, ddrName, DNSTypeSVCB)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Release()
+	if resp.Header.Flags.RCode != DNSRCodeSuccess {
+		return nil, &RCodeError{RCode: resp.Header.Flags.RCode}
+	}
+	var result []*DesignatedResolver
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeSVCB || rr.Data == nil {
+			continue
+		}
+		designated, err := parseDesignated(rr.Data)
+		if err != nil {
+			continue
+		}
+		result = append(result, designated...)
+	}
+	sortDesignated(result)
+	return result, nil
+}
+
+// parseDesignated 解析 SVCB 记录的 RDATA, AliasMode 与目标为根的记录对 DDR 没有意义, 返回空
+func parseDesignated(data []byte) ([]*DesignatedResolver, error) {
+	if len(data) < 3 {
+		return nil, ErrBadRData
+	}
+	u := &unpacker{msg: data, off: 2, partial: true}
+	target, _, err := u.name()
+	if err != nil {
+		return nil, err
+	}
+	priority := binary.BigEndian.Uint16(data)
+	if priority == 0 || target == "" {
+		return nil, nil
+	}
+	return designatedEndpoints(DesignatedResolver{Priority: priority, Target: target}, data[u.off:])
+}
+
+// designatedEndpoints 按 SvcParams 中的 alpn 为 base 生成每个协议的端点, DNR 的选项使用相同的编码
+func designatedEndpoints(base DesignatedResolver, params []byte) ([]*DesignatedResolver, error) {
+	var alpn []string
+	for rest := params; len(rest) > 0; {
+		if len(rest) < 4 || len(rest) < 4+int(binary.BigEndian.Uint16(rest[2:])) {
+			return nil, ErrBadRData
+		}
+		key, value := binary.BigEndian.Uint16(rest), rest[4:4+int(binary.BigEndian.Uint16(rest[2:]))]
+		rest = rest[4+len(value):]
+		switch key {
+		case svcParamALPN:
+			for len(value) > 0 && len(value) > int(value[0]) {
+				alpn = append(alpn, string(value[1:1+value[0]]))
+				value = value[1+value[0]:]
+			}
+		case svcParamPort:
+			if len(value) == 2 {
+				base.Port = int(binary.BigEndian.Uint16(value))
+			}
+		case svcParamIPv4Hint:
+			for ; len(value) >= net.IPv4len; value = value[net.IPv4len:] {
+				base.Addrs = append(base.Addrs, net.IP(value[:net.IPv4len]).String())
+			}
+		case svcParamIPv6Hint:
+			for ; len(value) >= net.IPv6len; value = value[net.IPv6len:] {
+				base.Addrs = append(base.Addrs, net.IP(value[:net.IPv6len]).String())
+			}
+		case svcParamDoHPath:
+			base.DoHPath = strings.TrimSuffix(string(value), "{?dns}")
+		}
+	}
+	var result []*DesignatedResolver
+	dot, doh := false, false
+	for _, proto := range alpn {
+		switch {
+		case proto == "dot" && !dot:
+			dot = true
+			d := base
+			d.Protocol = "tls"
+			if d.Port == 0 {
+				d.Port = 853
+			}
+			result = append(result, &d)
+		case (proto == "h2" || proto == "h3") && base.DoHPath != "" && !doh:
+			doh = true
+			d := base
+			d.Protocol = "https"
+			if d.Port == 0 {
+				d.Port = 443
+			}
+			result = append(result, &d)
+		}
+	}
+	return result, nil
+}
+
+// UpgradeEncrypted 按 policy 返回新的 Resolver. 加密端点通过 DDR 发现, 并按 RFC 9462 4.2 验证:
+// 证书对 Target 有效且包含 Server 的 IP 地址. 因此 Server 必须是 IP 地址.
+// 严格模式下没有可用端点时返回 ErrNoEncryptedResolver; 机会模式下没有时返回明文的副本,
+// 有时每个查询失败后再用明文重试一次. config 可以为空, 用于设置 RootCAs 等
+func (r *Resolver) UpgradeEncrypted(ctx context.Context, policy EncryptionPolicy, config *tls.Config) (*Resolver, error) {
+	u := *r
+	if policy == EncryptionPlaintext {
+		return &u, nil
+	}
+	enc, server, err := r.designated(ctx, config)
+	if err != nil {
+		if policy == EncryptionStrict {
+			return nil, errors.WithMessage(ErrNoEncryptedResolver, err.Error())
+		}
+		return &u, nil
+	}
+	return r.encrypted(policy, enc, server), nil
+}
+
+// encrypted 返回使用 enc 与 server 的副本, 机会模式下查询失败后用原来的明文配置重试一次
+func (r *Resolver) encrypted(policy EncryptionPolicy, enc RoundTripper, server string) *Resolver {
+	u := *r
+	u.Server, u.Transport = server, enc
+	if policy == EncryptionOpportunistic {
+		plain, plainServer := r.Transport, r.Server
+		if plain == nil {
+			plain = UDPTransport{}
+		}
+		u.Transport = RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := enc.RoundTrip(ctx, server, req)
+			if err != nil && ctx.Err() == nil {
+				return plain.RoundTrip(ctx, plainServer, req)
+			}
+			return resp, err
+		})
+	}
+	return &u
+}
+
+// designated 依次验证发现的端点, 返回第一个可用的 Transport 与服务器地址
+func (r *Resolver) designated(ctx context.Context, config *tls.Config) (RoundTripper, string, error) {
+	host, _, err := net.SplitHostPort(r.Server)
+	if err != nil {
+		return nil, "", err
+	}
+	if net.ParseIP(host) == nil {
+		return nil, "", errors.WithMessage(ErrDDRUnverified, "resolver address is not an ip")
+	}
+	list, err := r.DiscoverDesignatedResolvers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	err = ErrNoEncryptedResolver
+	for _, d := range list {
+		addrs := d.Addrs
+		if len(addrs) == 0 {
+			if addrs, err = r.LookupHost(ctx, d.Target); err != nil {
+				continue
+			}
+		}
+		for _, addr := range addrs {
+			if err = r.verifyDesignated(ctx, d, config, addr, host); err == nil {
+				return d.Transport(config, addr), d.Server(addr), nil
+			}
+		}
+	}
+	return nil, "", err
+}
+
+// verifyDesignated 与端点完成 TLS 握手, 检查证书是否包含原来的服务器地址. Timeout 作为握手的超时
+func (r *Resolver) verifyDesignated(ctx context.Context, d *DesignatedResolver, config *tls.Config, addr, resolverIP string) error {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c := d.tlsConfig(config)
+	if d.Protocol == "https" {
+		c.NextProtos = []string{"h2", "http/1.1"}
+	}
+	conn, err := ChainDialer(nil, WithTLS(c)).DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(d.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ErrDDRUnverified
+	}
+	if err := certs[0].VerifyHostname(resolverIP); err != nil {
+		return errors.WithMessage(ErrDDRUnverified, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// svcbRData 构造 SVCB 的 RDATA, params 的键必须递增
+func svcbRData(priority uint16, target string, params ...[]byte) []byte {
+	b, _ := appendName([]byte{byte(priority >> 8), byte(priority)}, target)
+	for _, p := range params {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func svcParam(key uint16, value []byte) []byte {
+	return append([]byte{byte(key >> 8), byte(key), byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+// startDoT 在 tls 上提供 DNS 服务, 所有 A 查询回答 ip
+func startDoT(t *testing.T, cert tls.Certificate, ip string) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Message.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: ip}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	go s.ServeTCP(ln)
+	return ln
+}
+
+func TestDDR(t *testing.T) {
+	good := selfSignedCert(t, "dns.example.com", "127.0.0.1")
+	bad := selfSignedCert(t, "dns.example.com")
+	goodLn, badLn := startDoT(t, good, "192.0.2.1"), startDoT(t, bad, "192.0.2.2")
+	roots := x509.NewCertPool()
+	roots.AddCert(good.Leaf)
+	roots.AddCert(bad.Leaf)
+	config := &tls.Config{RootCAs: roots}
+
+	var mu sync.Mutex
+	dotAddr := goodLn.Addr().String()
+	do53 := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		if q.QuestionType == DNSTypeSVCB && q.QuestionName == ddrName {
+			mu.Lock()
+			_, port, _ := net.SplitHostPort(dotAddr)
+			mu.Unlock()
+			p, _ := strconv.Atoi(port)
+			for _, data := range [][]byte{
+				svcbRData(0, "elsewhere.example.com"),
+				svcbRData(2, "dns.example.com", svcParam(svcParamALPN, []byte("\x02h2")), svcParam(svcParamIPv4Hint, []byte{127, 0, 0, 1}), svcParam(svcParamDoHPath, []byte("/dns-query{?dns}"))),
+				svcbRData(1, "dns.example.com", svcParam(svcParamALPN, []byte("\x03dot")),
+					svcParam(svcParamPort, []byte{byte(p >> 8), byte(p)}), svcParam(svcParamIPv4Hint, []byte{127, 0, 0, 1})),
+			} {
+				resp.ResourceRecodes = append(resp.ResourceRecodes, &DNSResourceRecode{Name: ddrName, RRType: DNSTypeSVCB, Class: DNSClassIn, TTL: 300, Data: data})
+			}
+		} else {
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: q.QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.53"}}
+		}
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+		return resp, nil
+	})}
+	udpAddr, _ := startDNSServer(t, do53)
+	plain := &Resolver{Server: udpAddr}
+	ctx := context.Background()
+
+	list, err := plain.DiscoverDesignatedResolvers(ctx)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("discover = %v, %v", list, err)
+	}
+	if d := list[0]; d.Protocol != "tls" || d.Target != "dns.example.com" || len(d.Addrs) != 1 || d.Addrs[0] != "127.0.0.1" {
+		t.Fatalf("dot = %+v", d)
+	}
+	if d := list[1]; d.Protocol != "https" || d.Port != 443 || d.Server("192.0.2.1") != "https://dns.example.com:443/dns-query" {
+		t.Fatalf("doh = %+v", d)
+	}
+
+	strict, err := plain.UpgradeEncrypted(ctx, EncryptionStrict, config)
+	if err != nil || strict.Server != goodLn.Addr().String() {
+		t.Fatalf("strict = %+v, %v", strict, err)
+	}
+	info := &QueryInfo{}
+	if ips, err := strict.LookupHost(WithQueryInfo(ctx, info), "www.example.com"); err != nil || ips[0] != "192.0.2.1" || info.Transport != "tls" {
+		t.Fatalf("strict lookup = %v, %v, %s", ips, err, info.Transport)
+	}
+	if r, _ := plain.UpgradeEncrypted(ctx, EncryptionPlaintext, config); r.Server != udpAddr {
+		t.Fatalf("plaintext = %s", r.Server)
+	}
+
+	opportunistic, err := plain.UpgradeEncrypted(ctx, EncryptionOpportunistic, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = goodLn.Close()
+	if ips, err := opportunistic.LookupHost(ctx, "www.example.com"); err != nil || ips[0] != "192.0.2.53" {
+		t.Fatalf("fallback = %v, %v", ips, err)
+	}
+
+	// 证书不包含原服务器地址时不能使用
+	mu.Lock()
+	dotAddr = badLn.Addr().String()
+	mu.Unlock()
+	if _, err := plain.UpgradeEncrypted(ctx, EncryptionStrict, config); !errors.Is(err, ErrNoEncryptedResolver) {
+		t.Fatalf("unverified strict = %v", err)
+	}
+	if r, err := plain.UpgradeEncrypted(ctx, EncryptionOpportunistic, config); err != nil || r.Server != udpAddr {
+		t.Fatalf("unverified opportunistic = %+v, %v", r, err)
+	}
+}
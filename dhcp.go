@@ -0,0 +1,300 @@
+package netx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	dhcpMagic      = 0x63825363
+	dhcpHeaderLen  = 236
+
+	DHCPOpRequest = 1
+	DHCPOpReply   = 2
+)
+
+// DHCP 消息类型 (option 53)
+const (
+	DHCPDiscover = 1
+	DHCPOffer    = 2
+	DHCPRequest  = 3
+	DHCPDecline  = 4
+	DHCPAck      = 5
+	DHCPNak      = 6
+	DHCPRelease  = 7
+	DHCPInform   = 8
+)
+
+// 常用 DHCP option
+const (
+	DHCPOptSubnetMask      = 1
+	DHCPOptRouter          = 3
+	DHCPOptDNSServers      = 6
+	DHCPOptHostName        = 12
+	DHCPOptDomainName      = 15
+	DHCPOptRequestedIP     = 50
+	DHCPOptLeaseTime       = 51
+	DHCPOptMessageType     = 53
+	DHCPOptServerID        = 54
+	DHCPOptParamRequest    = 55
+	DHCPOptMessage         = 56
+	DHCPOptRenewalTime     = 58
+	DHCPOptRebindingTime   = 59
+	DHCPOptClientID        = 61
+	DHCPOptDomainSearch    = 119
+	DHCPOptClasslessRoutes = 121
+	DHCPOptDNR             = 162
+	dhcpOptPad             = 0
+	dhcpOptEnd             = 255
+)
+
+var (
+	ErrDHCPShort       = errors.New("dhcp message too short")
+	ErrDHCPMagic       = errors.New("dhcp magic cookie mismatch")
+	ErrDHCPOption      = errors.New("malformed dhcp option")
+	ErrDHCPRouteOption = errors.New("malformed classless route option")
+)
+
+// DHCPMessage RFC 2131 报文. Options 不包含 pad 与 end
+type DHCPMessage struct {
+	Op      uint8
+	HType   uint8
+	HLen    uint8
+	Hops    uint8
+	XID     uint32
+	Secs    uint16
+	Flags   uint16
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	SName   string
+	File    string
+	Options map[uint8][]byte
+}
+
+// Type 返回 option 53, 不存在时为 0
+func (m *DHCPMessage) Type() uint8 {
+	if v := m.Options[DHCPOptMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+func (m *DHCPMessage) ToByte() ([]byte, error) {
+	b := make([]byte, dhcpHeaderLen, 300)
+	b[0], b[1], b[2], b[3] = m.Op, m.HType, m.HLen, m.Hops
+	binary.BigEndian.PutUint32(b[4:8], m.XID)
+	binary.BigEndian.PutUint16(b[8:10], m.Secs)
+	binary.BigEndian.PutUint16(b[10:12], m.Flags)
+	for i, ip := range []net.IP{m.CIAddr, m.YIAddr, m.SIAddr, m.GIAddr} {
+		if v4 := ip.To4(); v4 != nil {
+			copy(b[12+i*4:16+i*4], v4)
+		}
+	}
+	copy(b[28:44], m.CHAddr)
+	copy(b[44:108], m.SName)
+	copy(b[108:236], m.File)
+
+	var buffer bytes.Buffer
+	buffer.Write(b)
+	_ = binary.Write(&buffer, binary.BigEndian, uint32(dhcpMagic))
+	// option 53 放在最前面, 其余按编号排序以保证输出稳定
+	codes := make([]int, 0, len(m.Options))
+	for code := range m.Options {
+		if code != DHCPOptMessageType {
+			codes = append(codes, int(code))
+		}
+	}
+	sort.Ints(codes)
+	if _, ok := m.Options[DHCPOptMessageType]; ok {
+		codes = append([]int{DHCPOptMessageType}, codes...)
+	}
+	for _, code := range codes {
+		v := m.Options[uint8(code)]
+		// 超过 255 字节的 option 按 RFC 3396 拆分
+		for first := true; first || len(v) > 0; first = false {
+			chunk := v
+			if len(chunk) > 255 {
+				chunk = chunk[:255]
+			}
+			buffer.WriteByte(uint8(code))
+			buffer.WriteByte(uint8(len(chunk)))
+			buffer.Write(chunk)
+			v = v[len(chunk):]
+		}
+	}
+	buffer.WriteByte(dhcpOptEnd)
+	// BOOTP 要求报文至少 300 字节
+	for buffer.Len() < 300 {
+		buffer.WriteByte(dhcpOptPad)
+	}
+	return buffer.Bytes(), nil
+}
+
+// ParseDHCPMessage 解析 DHCP 报文, 同一 option 出现多次时按 RFC 3396 拼接
+func ParseDHCPMessage(b []byte) (*DHCPMessage, error) {
+	if len(b) < dhcpHeaderLen+4 {
+		return nil, ErrDHCPShort
+	}
+	if binary.BigEndian.Uint32(b[236:240]) != dhcpMagic {
+		return nil, ErrDHCPMagic
+	}
+	hlen := int(b[2])
+	if hlen > 16 {
+		hlen = 16
+	}
+	m := &DHCPMessage{
+		Op:      b[0],
+		HType:   b[1],
+		HLen:    b[2],
+		Hops:    b[3],
+		XID:     binary.BigEndian.Uint32(b[4:8]),
+		Secs:    binary.BigEndian.Uint16(b[8:10]),
+		Flags:   binary.BigEndian.Uint16(b[10:12]),
+		CIAddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		YIAddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		SIAddr:  net.IP(append([]byte(nil), b[20:24]...)),
+		GIAddr:  net.IP(append([]byte(nil), b[24:28]...)),
+		CHAddr:  net.HardwareAddr(append([]byte(nil), b[28:28+hlen]...)),
+		SName:   cString(b[44:108]),
+		File:    cString(b[108:236]),
+		Options: map[uint8][]byte{},
+	}
+	opts := b[240:]
+	for i := 0; i < len(opts); {
+		code := opts[i]
+		i++
+		if code == dhcpOptPad {
+			continue
+		}
+		if code == dhcpOptEnd {
+			break
+		}
+		if i >= len(opts) || i+1+int(opts[i]) > len(opts) {
+			return nil, ErrDHCPOption
+		}
+		length := int(opts[i])
+		m.Options[code] = append(m.Options[code], opts[i+1:i+1+length]...)
+		i += 1 + length
+	}
+	return m, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// DHCPRoute option 121 中的一条路由
+type DHCPRoute struct {
+	Destination *net.IPNet
+	Gateway     net.IP
+}
+
+// DHCPLease 从 OFFER/ACK 中提取的配置
+type DHCPLease struct {
+	IP            net.IP
+	ServerID      net.IP
+	SubnetMask    net.IPMask
+	Routers       []net.IP
+	DNSServers    []net.IP
+	DomainName    string
+	LeaseTime     time.Duration
+	RenewalTime   time.Duration // T1, 缺省为租期的 1/2
+	RebindingTime time.Duration // T2, 缺省为租期的 7/8
+	Routes        []*DHCPRoute
+	DNR           []*DesignatedResolver // option 162 通告的加密端点, 见 Resolver.UpgradeDNR
+	Acquired      time.Time
+
+	// Message 原始应答, 用于读取其他 option
+	Message *DHCPMessage
+}
+
+// NewDHCPLease 解析 m 中的 option
+func NewDHCPLease(m *DHCPMessage) (*DHCPLease, error) {
+	lease := &DHCPLease{
+		IP:       m.YIAddr,
+		Acquired: time.Now(),
+		Message:  m,
+	}
+	if v := m.Options[DHCPOptServerID]; len(v) == 4 {
+		lease.ServerID = net.IP(v)
+	}
+	if v := m.Options[DHCPOptSubnetMask]; len(v) == 4 {
+		lease.SubnetMask = net.IPMask(v)
+	}
+	lease.Routers = dhcpIPs(m.Options[DHCPOptRouter])
+	lease.DNSServers = dhcpIPs(m.Options[DHCPOptDNSServers])
+	lease.DomainName = string(m.Options[DHCPOptDomainName])
+	lease.LeaseTime = dhcpSeconds(m.Options[DHCPOptLeaseTime])
+	lease.RenewalTime = dhcpSeconds(m.Options[DHCPOptRenewalTime])
+	lease.RebindingTime = dhcpSeconds(m.Options[DHCPOptRebindingTime])
+	if lease.RenewalTime == 0 {
+		lease.RenewalTime = lease.LeaseTime / 2
+	}
+	if lease.RebindingTime == 0 {
+		lease.RebindingTime = lease.LeaseTime * 7 / 8
+	}
+	if v, ok := m.Options[DHCPOptClasslessRoutes]; ok {
+		routes, err := ParseClasslessRoutes(v)
+		if err != nil {
+			return nil, err
+		}
+		lease.Routes = routes
+	}
+	// RFC 9463 要求丢弃格式错误的 DNR 选项, 不影响租约的其他部分
+	if v, ok := m.Options[DHCPOptDNR]; ok {
+		lease.DNR, _ = ParseDHCPDNR(v)
+	}
+	return lease, nil
+}
+
+// ParseClasslessRoutes 解析 RFC 3442 option 121
+func ParseClasslessRoutes(b []byte) ([]*DHCPRoute, error) {
+	var routes []*DHCPRoute
+	for i := 0; i < len(b); {
+		width := int(b[i])
+		i++
+		if width > 32 {
+			return nil, ErrDHCPRouteOption
+		}
+		significant := (width + 7) / 8
+		if i+significant+4 > len(b) {
+			return nil, ErrDHCPRouteOption
+		}
+		dst := make(net.IP, 4)
+		copy(dst, b[i:i+significant])
+		i += significant
+		routes = append(routes, &DHCPRoute{
+			Destination: &net.IPNet{IP: dst, Mask: net.CIDRMask(width, 32)},
+			Gateway:     net.IP(append([]byte(nil), b[i:i+4]...)),
+		})
+		i += 4
+	}
+	return routes, nil
+}
+
+func dhcpIPs(b []byte) []net.IP {
+	var ips []net.IP
+	for i := 0; i+4 <= len(b); i += 4 {
+		ips = append(ips, net.IP(append([]byte(nil), b[i:i+4]...)))
+	}
+	return ips
+}
+
+func dhcpSeconds(b []byte) time.Duration {
+	if len(b) != 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+}
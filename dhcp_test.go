@@ -0,0 +1,311 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDHCPMessage(t *testing.T) {
+	m := &DHCPMessage{
+		Op:     DHCPOpReply,
+		HType:  1,
+		HLen:   6,
+		XID:    0x12345678,
+		YIAddr: net.IPv4(192, 168, 1, 10),
+		CHAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		Options: map[uint8][]byte{
+			DHCPOptMessageType: {DHCPAck},
+			DHCPOptServerID:    {192, 168, 1, 1},
+			DHCPOptSubnetMask:  {255, 255, 255, 0},
+			DHCPOptRouter:      {192, 168, 1, 1},
+			DHCPOptDNSServers:  {8, 8, 8, 8, 1, 1, 1, 1},
+			DHCPOptLeaseTime:   {0, 0, 0x0e, 0x10},
+			// 10.0.0.0/8 via 192.168.1.254, 0.0.0.0/0 via 192.168.1.1
+			DHCPOptClasslessRoutes: {8, 10, 192, 168, 1, 254, 0, 192, 168, 1, 1},
+		},
+	}
+	b, err := m.ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseDHCPMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := NewDHCPLease(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.IP.Equal(net.IPv4(192, 168, 1, 10)) || parsed.Type() != DHCPAck {
+		t.Fatalf("unexpected lease %+v", lease)
+	}
+	if len(lease.DNSServers) != 2 || lease.LeaseTime != time.Hour || lease.RenewalTime != 30*time.Minute {
+		t.Fatalf("unexpected options %+v", lease)
+	}
+	if len(lease.Routes) != 2 || lease.Routes[0].Destination.String() != "10.0.0.0/8" || lease.Routes[1].Destination.String() != "0.0.0.0/0" {
+		t.Fatalf("unexpected routes %v %v", lease.Routes[0].Destination, lease.Routes[1].Destination)
+	}
+}
+
+// fakeDHCPConn 代替 68 端口的 socket, 把客户端发出的消息交给 serve, 返回的应答作为收到的数据
+type fakeDHCPConn struct {
+	net.PacketConn
+	serve   func(req *DHCPMessage, dst net.IP) []*DHCPMessage
+	replies chan []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *fakeDHCPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	req, err := ParseDHCPMessage(b)
+	if err != nil {
+		return 0, err
+	}
+	for _, resp := range c.serve(req, addr.(*net.UDPAddr).IP) {
+		data, err := resp.ToByte()
+		if err != nil {
+			return 0, err
+		}
+		c.replies <- data
+	}
+	return len(b), nil
+}
+
+func (c *fakeDHCPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case data := <-c.replies:
+		return copy(b, data), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: dhcpServerPort}, nil
+	case <-timer.C:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *fakeDHCPConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeDHCPConn) Close() error {
+	return nil
+}
+
+// dhcpTestClient 返回使用 serve 应答的客户端, 以及客户端发出的消息与目的地址
+func dhcpTestClient(serve func(req *DHCPMessage, dst net.IP) []*DHCPMessage) (*DHCPClient, func() ([]*DHCPMessage, []net.IP)) {
+	var mu sync.Mutex
+	var sent []*DHCPMessage
+	var dsts []net.IP
+	conn := &fakeDHCPConn{replies: make(chan []byte, 16), serve: func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		mu.Lock()
+		sent, dsts = append(sent, req), append(dsts, dst)
+		mu.Unlock()
+		return serve(req, dst)
+	}}
+	c := &DHCPClient{
+		Interface:    &net.Interface{Name: "test0", HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}},
+		Timeout:      20 * time.Millisecond,
+		Retries:      2,
+		ListenPacket: func(context.Context) (net.PacketConn, error) { return conn, nil },
+	}
+	return c, func() ([]*DHCPMessage, []net.IP) {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent, dsts
+	}
+}
+
+// dhcpReply 构造 server 对 req 的 msgType 应答, lease 秒的租期
+func dhcpReply(req *DHCPMessage, msgType uint8, server, ip net.IP, lease uint32) *DHCPMessage {
+	return &DHCPMessage{
+		Op:     DHCPOpReply,
+		HType:  1,
+		HLen:   6,
+		XID:    req.XID,
+		YIAddr: ip,
+		CHAddr: req.CHAddr,
+		Options: map[uint8][]byte{
+			DHCPOptMessageType: {msgType},
+			DHCPOptServerID:    server.To4(),
+			DHCPOptLeaseTime:   {byte(lease >> 24), byte(lease >> 16), byte(lease >> 8), byte(lease)},
+		},
+	}
+}
+
+func TestDHCPClientAcquire(t *testing.T) {
+	serverA, serverB := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	ipA, ipB := net.IPv4(10, 0, 0, 100), net.IPv4(10, 0, 0, 200)
+	c, sent := dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		switch req.Type() {
+		case DHCPDiscover:
+			stale := dhcpReply(req, DHCPOffer, serverB, ipB, 3600)
+			stale.XID++
+			request := dhcpReply(req, DHCPOffer, serverB, ipB, 3600)
+			request.Op = DHCPOpRequest
+			return []*DHCPMessage{stale, request, dhcpReply(req, DHCPOffer, serverA, ipA, 3600), dhcpReply(req, DHCPOffer, serverB, ipB, 3600)}
+		case DHCPRequest:
+			// 另一台服务器也应答了广播的 REQUEST
+			return []*DHCPMessage{dhcpReply(req, DHCPAck, serverB, ipB, 3600), dhcpReply(req, DHCPAck, serverA, ipA, 3600)}
+		}
+		return nil
+	})
+	lease, err := c.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.IP.Equal(ipA) || !lease.ServerID.Equal(serverA) || lease.LeaseTime != time.Hour {
+		t.Fatalf("lease %+v", lease)
+	}
+	msgs, dsts := sent()
+	if len(msgs) != 2 || msgs[1].Type() != DHCPRequest || msgs[0].XID != msgs[1].XID || !dsts[1].Equal(net.IPv4bcast) ||
+		!net.IP(msgs[1].Options[DHCPOptRequestedIP]).Equal(ipA) || !net.IP(msgs[1].Options[DHCPOptServerID]).Equal(serverA) {
+		t.Fatalf("sent %+v to %v", msgs, dsts)
+	}
+
+	offers, err := c.Discover(context.Background())
+	if err != nil || len(offers) != 2 || !offers[0].ServerID.Equal(serverA) || !offers[1].ServerID.Equal(serverB) {
+		t.Fatalf("discover: %v %+v", err, offers)
+	}
+}
+
+func TestDHCPClientNakAndTimeout(t *testing.T) {
+	c, _ := dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		if req.Type() == DHCPRequest {
+			return []*DHCPMessage{dhcpReply(req, DHCPNak, net.IPv4(10, 0, 0, 1), nil, 0)}
+		}
+		return []*DHCPMessage{dhcpReply(req, DHCPOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 100), 3600)}
+	})
+	if _, err := c.Acquire(context.Background()); errors.Cause(err) != ErrDHCPNak {
+		t.Fatalf("nak: %v", err)
+	}
+
+	// 第一次 DISCOVER 没有应答, 重发后成功; 一直没有应答时重发 Retries 次后超时
+	attempts := 0
+	c, sent := dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		if attempts++; attempts == 1 {
+			return nil
+		}
+		return []*DHCPMessage{dhcpReply(req, DHCPOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 100), 3600)}
+	})
+	if _, err := c.Inform(context.Background(), net.IPv4(10, 0, 0, 100)); err != ErrDHCPTimeout {
+		t.Fatalf("inform answered with an offer: %v", err)
+	}
+	if msgs, _ := sent(); len(msgs) != 2 || msgs[0].XID != msgs[1].XID || !msgs[0].CIAddr.Equal(net.IPv4(10, 0, 0, 100)) {
+		t.Fatalf("inform sent %+v", msgs)
+	}
+	attempts = 0
+	c, sent = dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		if attempts++; attempts == 1 || req.Type() != DHCPDiscover {
+			return nil
+		}
+		return []*DHCPMessage{dhcpReply(req, DHCPOffer, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 100), 3600)}
+	})
+	if _, err := c.Acquire(context.Background()); errors.Cause(err) != ErrDHCPTimeout {
+		t.Fatalf("request without ack: %v", err)
+	}
+	if msgs, _ := sent(); len(msgs) != 4 || msgs[1].Type() != DHCPDiscover || msgs[2].Type() != DHCPRequest {
+		t.Fatalf("sent %d messages", len(msgs))
+	}
+}
+
+func TestDHCPClientRenewAndRelease(t *testing.T) {
+	server := net.IPv4(10, 0, 0, 1)
+	ip := net.IPv4(10, 0, 0, 100)
+	c, sent := dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		if req.Type() != DHCPRequest {
+			return nil
+		}
+		return []*DHCPMessage{dhcpReply(req, DHCPAck, server, req.CIAddr, 7200)}
+	})
+	lease := &DHCPLease{IP: ip, ServerID: server, LeaseTime: time.Hour}
+	for _, rebind := range []bool{false, true} {
+		renewed, err := c.Renew(context.Background(), lease, rebind)
+		if err != nil || !renewed.IP.Equal(ip) || renewed.LeaseTime != 2*time.Hour {
+			t.Fatalf("rebind %v: %v %+v", rebind, err, renewed)
+		}
+	}
+	if err := c.Release(context.Background(), lease); err != nil {
+		t.Fatal(err)
+	}
+	msgs, dsts := sent()
+	if len(msgs) != 3 || !dsts[0].Equal(server) || !dsts[1].Equal(net.IPv4bcast) || !dsts[2].Equal(server) {
+		t.Fatalf("destinations %v", dsts)
+	}
+	if msgs[2].Type() != DHCPRelease || !msgs[2].CIAddr.Equal(ip) || !net.IP(msgs[2].Options[DHCPOptServerID]).Equal(server) {
+		t.Fatalf("release %+v", msgs[2])
+	}
+}
+
+func TestDHCPClientMaintain(t *testing.T) {
+	server := net.IPv4(10, 0, 0, 1)
+	ip := net.IPv4(10, 0, 0, 100)
+	newLease := func() *DHCPLease {
+		return &DHCPLease{IP: ip, ServerID: server, LeaseTime: 400 * time.Millisecond, RenewalTime: 100 * time.Millisecond, RebindingTime: 200 * time.Millisecond, Acquired: time.Now()}
+	}
+
+	// T1 单播续租没有应答, T2 广播重新绑定成功, 之后按新租约的 T1 续租
+	var mu sync.Mutex
+	var events []string
+	c, sent := dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage {
+		if dst.Equal(server) {
+			return nil
+		}
+		// 新租约没有 T1 与 T2 option, 按 1/2 与 7/8 计算
+		return []*DHCPMessage{dhcpReply(req, DHCPAck, server, req.CIAddr, 1)}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	err := c.Maintain(ctx, newLease(), func(l *DHCPLease, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			events = append(events, "error")
+			return
+		}
+		events = append(events, "renewed")
+		if len(events) == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("maintain: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("rebound after %v, before T2", elapsed)
+	}
+	if strings.Join(events, ",") != "error,renewed" {
+		t.Fatalf("events %v", events)
+	}
+	if _, dsts := sent(); len(dsts) != 3 || !dsts[0].Equal(server) || !dsts[2].Equal(net.IPv4bcast) {
+		t.Fatalf("destinations %v", dsts)
+	}
+
+	// 没有任何应答时等到租约过期
+	events = nil
+	c, _ = dhcpTestClient(func(req *DHCPMessage, dst net.IP) []*DHCPMessage { return nil })
+	start = time.Now()
+	lease := newLease()
+	err = c.Maintain(context.Background(), lease, func(l *DHCPLease, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, errors.Cause(err).Error())
+	})
+	if err != ErrDHCPLeaseLost || time.Now().Before(lease.Acquired.Add(lease.LeaseTime)) {
+		t.Fatalf("lease lost: %v after %v", err, time.Since(start))
+	}
+	if len(events) != 3 || events[0] != ErrDHCPTimeout.Error() || events[2] != ErrDHCPLeaseLost.Error() {
+		t.Fatalf("events %v", events)
+	}
+}
@@ -0,0 +1,344 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrDHCPNoInterface = errors.New("dhcp client requires an interface")
+	ErrDHCPNak         = errors.New("dhcp server replied NAK")
+	ErrDHCPTimeout     = errors.New("no dhcp reply")
+	ErrDHCPLeaseLost   = errors.New("dhcp lease expired without renewal")
+)
+
+var defaultDHCPParams = []byte{
+	DHCPOptSubnetMask, DHCPOptRouter, DHCPOptDNSServers, DHCPOptDomainName,
+	DHCPOptLeaseTime, DHCPOptRenewalTime, DHCPOptRebindingTime,
+	DHCPOptDomainSearch, DHCPOptClasslessRoutes, DHCPOptDNR,
+}
+
+// DHCPClient 在 Interface 上进行 DHCPv4 交互, 需要绑定 68 端口的权限.
+// Linux 上会使用 SO_BINDTODEVICE 将 socket 绑定到该网卡
+type DHCPClient struct {
+	Interface *net.Interface
+	Timeout   time.Duration // 单次等待应答的时间, 默认 4s
+	Retries   int           // 默认 3
+	HostName  string
+	ClientID  []byte
+	Params    []byte // parameter request list, 为空时使用常用 option
+	// ListenPacket 不为空时代替在 68 端口监听, 用于在已有的 socket 上运行
+	ListenPacket func(ctx context.Context) (net.PacketConn, error)
+}
+
+func (c *DHCPClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 4 * time.Second
+}
+
+func (c *DHCPClient) retries() int {
+	if c.Retries > 0 {
+		return c.Retries
+	}
+	return 3
+}
+
+func (c *DHCPClient) listen(ctx context.Context) (net.PacketConn, error) {
+	if c.Interface == nil {
+		return nil, ErrDHCPNoInterface
+	}
+	if c.ListenPacket != nil {
+		return c.ListenPacket(ctx)
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, raw syscall.RawConn) error {
+			var serr error
+			if err := raw.Control(func(fd uintptr) {
+				serr = setSockoptBindToDevice(fd, c.Interface.Name)
+			}); err != nil {
+				return err
+			}
+			if serr == ErrNotSupported {
+				return nil
+			}
+			return serr
+		},
+	}
+	conn, err := lc.ListenPacket(ctx, "udp4", ":"+strconv.Itoa(dhcpClientPort))
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen dhcp client port error")
+	}
+	return conn, nil
+}
+
+func (c *DHCPClient) newMessage(msgType uint8, xid uint32) *DHCPMessage {
+	m := &DHCPMessage{
+		Op:      DHCPOpRequest,
+		HType:   1,
+		HLen:    uint8(len(c.Interface.HardwareAddr)),
+		XID:     xid,
+		Flags:   0x8000, // 还没有地址, 要求服务器广播应答
+		CHAddr:  c.Interface.HardwareAddr,
+		Options: map[uint8][]byte{DHCPOptMessageType: {msgType}},
+	}
+	params := c.Params
+	if len(params) == 0 {
+		params = defaultDHCPParams
+	}
+	if msgType != DHCPRelease {
+		m.Options[DHCPOptParamRequest] = params
+	}
+	if c.HostName != "" {
+		m.Options[DHCPOptHostName] = []byte(c.HostName)
+	}
+	if len(c.ClientID) > 0 {
+		m.Options[DHCPOptClientID] = c.ClientID
+	}
+	return m
+}
+
+// exchange 发送 req 并等待 accept 返回 true 的应答, 超时后重发
+func (c *DHCPClient) exchange(ctx context.Context, conn net.PacketConn, req *DHCPMessage, dst net.IP, accept func(*DHCPMessage) bool) (*DHCPMessage, error) {
+	b, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	addr := &net.UDPAddr{IP: dst, Port: dhcpServerPort}
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < c.retries(); attempt++ {
+		if _, err := conn.WriteTo(b, addr); err != nil {
+			return nil, errors.WithMessage(err, "write dhcp error")
+		}
+		deadline := time.Now().Add(c.timeout())
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			resp, err := ParseDHCPMessage(buf[:n])
+			if err != nil || resp.Op != DHCPOpReply || resp.XID != req.XID {
+				continue
+			}
+			if resp.Type() == DHCPNak {
+				return nil, errors.WithMessage(ErrDHCPNak, string(resp.Options[DHCPOptMessage]))
+			}
+			if accept(resp) {
+				return resp, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrDHCPTimeout
+}
+
+// Discover 只发送 DISCOVER 并收集超时前的所有 OFFER, 不会申请地址, 用于发现网络中的 DHCP 服务器
+func (c *DHCPClient) Discover(ctx context.Context) ([]*DHCPLease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := c.newMessage(DHCPDiscover, randUint32())
+	b, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(b, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpServerPort}); err != nil {
+		return nil, errors.WithMessage(err, "write dhcp error")
+	}
+	deadline := time.Now().Add(c.timeout())
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var offers []*DHCPLease
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return offers, nil
+			}
+			return offers, err
+		}
+		resp, err := ParseDHCPMessage(buf[:n])
+		if err != nil || resp.Op != DHCPOpReply || resp.XID != req.XID || resp.Type() != DHCPOffer {
+			continue
+		}
+		lease, err := NewDHCPLease(resp)
+		if err != nil {
+			continue
+		}
+		offers = append(offers, lease)
+	}
+}
+
+// Inform 已经有地址 ip 时只获取网络配置 (DHCPINFORM)
+func (c *DHCPClient) Inform(ctx context.Context, ip net.IP) (*DHCPLease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := c.newMessage(DHCPInform, randUint32())
+	req.CIAddr = ip
+	req.Flags = 0
+	resp, err := c.exchange(ctx, conn, req, net.IPv4bcast, func(m *DHCPMessage) bool {
+		return m.Type() == DHCPAck
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewDHCPLease(resp)
+}
+
+// Acquire 完成 DISCOVER/OFFER/REQUEST/ACK 获取租约
+func (c *DHCPClient) Acquire(ctx context.Context) (*DHCPLease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	xid := randUint32()
+	offer, err := c.exchange(ctx, conn, c.newMessage(DHCPDiscover, xid), net.IPv4bcast, func(m *DHCPMessage) bool {
+		return m.Type() == DHCPOffer
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "discover")
+	}
+
+	req := c.newMessage(DHCPRequest, xid)
+	req.Options[DHCPOptRequestedIP] = offer.YIAddr.To4()
+	req.Options[DHCPOptServerID] = offer.Options[DHCPOptServerID]
+	// REQUEST 是广播的, 只接受选中的服务器的 ACK
+	ack, err := c.exchange(ctx, conn, req, net.IPv4bcast, func(m *DHCPMessage) bool {
+		return m.Type() == DHCPAck && bytes.Equal(m.Options[DHCPOptServerID], offer.Options[DHCPOptServerID])
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "request")
+	}
+	return NewDHCPLease(ack)
+}
+
+// Renew 续租. rebind 为 false 时单播给原服务器 (T1), 为 true 时广播给任意服务器 (T2)
+func (c *DHCPClient) Renew(ctx context.Context, lease *DHCPLease, rebind bool) (*DHCPLease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := c.newMessage(DHCPRequest, randUint32())
+	req.CIAddr = lease.IP
+	req.Flags = 0
+	dst := lease.ServerID
+	if rebind || dst == nil {
+		dst = net.IPv4bcast
+	}
+	ack, err := c.exchange(ctx, conn, req, dst, func(m *DHCPMessage) bool {
+		return m.Type() == DHCPAck
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewDHCPLease(ack)
+}
+
+// Release 归还租约, 服务器不会应答
+func (c *DHCPClient) Release(ctx context.Context, lease *DHCPLease) error {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := c.newMessage(DHCPRelease, randUint32())
+	req.CIAddr = lease.IP
+	req.Flags = 0
+	if lease.ServerID != nil {
+		req.Options[DHCPOptServerID] = lease.ServerID.To4()
+	}
+	b, err := req.ToByte()
+	if err != nil {
+		return err
+	}
+	dst := lease.ServerID
+	if dst == nil {
+		dst = net.IPv4bcast
+	}
+	_, err = conn.WriteTo(b, &net.UDPAddr{IP: dst, Port: dhcpServerPort})
+	return err
+}
+
+// Maintain 在 T1 单播续租, 失败后在 T2 广播重新绑定, 直到 ctx 取消或租约过期.
+// 每次续租完成 (成功或失败) 都会调用 fn
+func (c *DHCPClient) Maintain(ctx context.Context, lease *DHCPLease, fn func(*DHCPLease, error)) error {
+	for lease.LeaseTime > 0 {
+		steps := []struct {
+			at     time.Time
+			rebind bool
+		}{
+			{lease.Acquired.Add(lease.RenewalTime), false},
+			{lease.Acquired.Add(lease.RebindingTime), true},
+		}
+		var renewed *DHCPLease
+		for _, step := range steps {
+			if err := sleepUntil(ctx, step.at); err != nil {
+				return err
+			}
+			var err error
+			renewed, err = c.Renew(ctx, lease, step.rebind)
+			fn(renewed, err)
+			if err == nil {
+				break
+			}
+			if errors.Cause(err) == ErrDHCPNak {
+				return err
+			}
+		}
+		if renewed == nil {
+			if err := sleepUntil(ctx, lease.Acquired.Add(lease.LeaseTime)); err != nil {
+				return err
+			}
+			fn(nil, ErrDHCPLeaseLost)
+			return ErrDHCPLeaseLost
+		}
+		lease = renewed
+	}
+	return nil
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
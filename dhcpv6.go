@@ -0,0 +1,509 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv6"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DHCPv6 消息类型
+const (
+	DHCPv6Solicit            = 1
+	DHCPv6Advertise          = 2
+	DHCPv6Request            = 3
+	DHCPv6Renew              = 5
+	DHCPv6Rebind             = 6
+	DHCPv6Reply              = 7
+	DHCPv6Release            = 8
+	DHCPv6InformationRequest = 11
+)
+
+// DHCPv6 option
+const (
+	DHCPv6OptClientID    = 1
+	DHCPv6OptServerID    = 2
+	DHCPv6OptIANA        = 3
+	DHCPv6OptIAAddr      = 5
+	DHCPv6OptORO         = 6
+	DHCPv6OptPreference  = 7
+	DHCPv6OptElapsedTime = 8
+	DHCPv6OptStatusCode  = 13
+	DHCPv6OptRapidCommit = 14
+	DHCPv6OptDNSServers  = 23
+	DHCPv6OptDomainList  = 24
+	DHCPv6OptIAPD        = 25
+	DHCPv6OptIAPrefix    = 26
+	DHCPv6OptDNR         = 144
+)
+
+const (
+	dhcpv6ClientPort = 546
+	dhcpv6ServerPort = 547
+)
+
+var (
+	// AllDHCPRelayAgentsAndServers ff02::1:2
+	AllDHCPRelayAgentsAndServers = net.ParseIP("ff02::1:2")
+
+	ErrDHCPv6Short   = errors.New("dhcpv6 message too short")
+	ErrDHCPv6Option  = errors.New("malformed dhcpv6 option")
+	ErrDHCPv6Status  = errors.New("dhcpv6 server returned error status")
+	ErrDHCPv6Timeout = errors.New("no dhcpv6 reply")
+)
+
+// DHCPv6Option 按出现顺序保存, 同一 code 可以出现多次
+type DHCPv6Option struct {
+	Code uint16
+	Data []byte
+}
+
+// DHCPv6Message RFC 8415 报文 (不含 relay 报文)
+type DHCPv6Message struct {
+	Type    uint8
+	TxID    uint32 // 低 24 位有效
+	Options []*DHCPv6Option
+}
+
+// Option 返回第一个 code 对应的 option
+func (m *DHCPv6Message) Option(code uint16) []byte {
+	return dhcpv6Find(m.Options, code)
+}
+
+func dhcpv6Find(options []*DHCPv6Option, code uint16) []byte {
+	for _, o := range options {
+		if o.Code == code {
+			return o.Data
+		}
+	}
+	return nil
+}
+
+func (m *DHCPv6Message) ToByte() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte(m.Type)
+	buffer.Write([]byte{byte(m.TxID >> 16), byte(m.TxID >> 8), byte(m.TxID)})
+	if err := packDHCPv6Options(&buffer, m.Options); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func packDHCPv6Options(buffer *bytes.Buffer, options []*DHCPv6Option) error {
+	for _, o := range options {
+		if len(o.Data) > 0xFFFF {
+			return ErrDHCPv6Option
+		}
+		_ = binary.Write(buffer, binary.BigEndian, o.Code)
+		_ = binary.Write(buffer, binary.BigEndian, uint16(len(o.Data)))
+		buffer.Write(o.Data)
+	}
+	return nil
+}
+
+// ParseDHCPv6Message 解析 DHCPv6 报文
+func ParseDHCPv6Message(b []byte) (*DHCPv6Message, error) {
+	if len(b) < 4 {
+		return nil, ErrDHCPv6Short
+	}
+	options, err := parseDHCPv6Options(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &DHCPv6Message{
+		Type:    b[0],
+		TxID:    uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]),
+		Options: options,
+	}, nil
+}
+
+func parseDHCPv6Options(b []byte) ([]*DHCPv6Option, error) {
+	var options []*DHCPv6Option
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrDHCPv6Option
+		}
+		code := binary.BigEndian.Uint16(b[0:2])
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if 4+length > len(b) {
+			return nil, ErrDHCPv6Option
+		}
+		options = append(options, &DHCPv6Option{Code: code, Data: append([]byte(nil), b[4:4+length]...)})
+		b = b[4+length:]
+	}
+	return options, nil
+}
+
+// DHCPv6Address IA_NA 中分配的地址
+type DHCPv6Address struct {
+	IP                net.IP
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+}
+
+// DHCPv6Prefix IA_PD 中委派的前缀
+type DHCPv6Prefix struct {
+	Prefix            *net.IPNet
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+}
+
+// DHCPv6Lease 从 ADVERTISE/REPLY 中提取的配置
+type DHCPv6Lease struct {
+	ServerID   []byte
+	Preference uint8 // ADVERTISE 中的服务器优先级, 没有该 option 时为 0
+	IAID       uint32
+	T1, T2     time.Duration
+	Addresses  []*DHCPv6Address
+	Prefixes   []*DHCPv6Prefix
+	DNSServers []net.IP
+	Domains    []string
+	DNR        []*DesignatedResolver // option 144 通告的加密端点, 按优先级排序
+	Acquired   time.Time
+
+	Message *DHCPv6Message
+}
+
+// NewDHCPv6Lease 解析 m 中的 option, 状态码不为 0 时返回 ErrDHCPv6Status
+func NewDHCPv6Lease(m *DHCPv6Message) (*DHCPv6Lease, error) {
+	if err := dhcpv6Status(m.Option(DHCPv6OptStatusCode)); err != nil {
+		return nil, err
+	}
+	lease := &DHCPv6Lease{
+		ServerID: m.Option(DHCPv6OptServerID),
+		Acquired: time.Now(),
+		Message:  m,
+	}
+	for _, o := range m.Options {
+		switch o.Code {
+		case DHCPv6OptIANA, DHCPv6OptIAPD:
+			if len(o.Data) < 12 {
+				return nil, ErrDHCPv6Option
+			}
+			lease.IAID = binary.BigEndian.Uint32(o.Data[0:4])
+			lease.T1 = time.Duration(binary.BigEndian.Uint32(o.Data[4:8])) * time.Second
+			lease.T2 = time.Duration(binary.BigEndian.Uint32(o.Data[8:12])) * time.Second
+			inner, err := parseDHCPv6Options(o.Data[12:])
+			if err != nil {
+				return nil, err
+			}
+			if err := dhcpv6Status(dhcpv6Find(inner, DHCPv6OptStatusCode)); err != nil {
+				return nil, err
+			}
+			for _, sub := range inner {
+				switch {
+				case sub.Code == DHCPv6OptIAAddr && len(sub.Data) >= 24:
+					lease.Addresses = append(lease.Addresses, &DHCPv6Address{
+						IP:                net.IP(sub.Data[0:16]),
+						PreferredLifetime: time.Duration(binary.BigEndian.Uint32(sub.Data[16:20])) * time.Second,
+						ValidLifetime:     time.Duration(binary.BigEndian.Uint32(sub.Data[20:24])) * time.Second,
+					})
+				case sub.Code == DHCPv6OptIAPrefix && len(sub.Data) >= 25:
+					bits := int(sub.Data[8])
+					if bits > 128 {
+						return nil, ErrDHCPv6Option
+					}
+					lease.Prefixes = append(lease.Prefixes, &DHCPv6Prefix{
+						Prefix:            &net.IPNet{IP: net.IP(sub.Data[9:25]), Mask: net.CIDRMask(bits, 128)},
+						PreferredLifetime: time.Duration(binary.BigEndian.Uint32(sub.Data[0:4])) * time.Second,
+						ValidLifetime:     time.Duration(binary.BigEndian.Uint32(sub.Data[4:8])) * time.Second,
+					})
+				}
+			}
+		case DHCPv6OptPreference:
+			if len(o.Data) != 1 {
+				return nil, ErrDHCPv6Option
+			}
+			lease.Preference = o.Data[0]
+		case DHCPv6OptDNSServers:
+			for i := 0; i+16 <= len(o.Data); i += 16 {
+				lease.DNSServers = append(lease.DNSServers, net.IP(o.Data[i:i+16]))
+			}
+		case DHCPv6OptDomainList:
+			domains, err := parseNameList(o.Data)
+			if err != nil {
+				return nil, err
+			}
+			lease.Domains = domains
+		case DHCPv6OptDNR:
+			// 格式错误的实例被丢弃
+			if resolvers, err := ParseDHCPv6DNR(o.Data); err == nil {
+				lease.DNR = append(lease.DNR, resolvers...)
+			}
+		}
+	}
+	sortDesignated(lease.DNR)
+	return lease, nil
+}
+
+func dhcpv6Status(b []byte) error {
+	if len(b) < 2 {
+		return nil
+	}
+	if code := binary.BigEndian.Uint16(b[0:2]); code != 0 {
+		return errors.WithMessage(ErrDHCPv6Status, string(b[2:]))
+	}
+	return nil
+}
+
+// parseNameList 解析未压缩的域名列表 (DHCPv6 option 24, RA DNSSL)
+func parseNameList(b []byte) ([]string, error) {
+	var names []string
+	u := &unpacker{msg: b, partial: true}
+	for u.off < len(b) {
+		// DNSSL 末尾可能有填充的 0
+		if b[u.off] == 0 {
+			u.off++
+			continue
+		}
+		name, _, err := u.name()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// DHCPv6Client 在 Interface 上进行 DHCPv6 交互, 需要绑定 546 端口的权限
+type DHCPv6Client struct {
+	Interface *net.Interface
+	DUID      []byte        // 为空时使用基于 MAC 的 DUID-LL
+	IAID      uint32        // 为 0 时使用网卡 index
+	Timeout   time.Duration // 单次等待应答的时间, 默认 2s
+	Retries   int           // 默认 3
+	// PrefixDelegation 为 true 时申请 IA_PD 而不是 IA_NA
+	PrefixDelegation bool
+	// ListenPacket 不为空时代替在 546 端口监听, 用于在已有的 socket 上运行
+	ListenPacket func(ctx context.Context) (net.PacketConn, error)
+}
+
+func (c *DHCPv6Client) duid() []byte {
+	if len(c.DUID) > 0 {
+		return c.DUID
+	}
+	// DUID-LL: type 3, hardware type 1 (ethernet)
+	return append([]byte{0, 3, 0, 1}, c.Interface.HardwareAddr...)
+}
+
+func (c *DHCPv6Client) iaid() uint32 {
+	if c.IAID != 0 {
+		return c.IAID
+	}
+	return uint32(c.Interface.Index)
+}
+
+func (c *DHCPv6Client) newMessage(msgType uint8, txID uint32) *DHCPv6Message {
+	oro := []byte{0, DHCPv6OptDNSServers, 0, DHCPv6OptDomainList, 0, DHCPv6OptDNR}
+	return &DHCPv6Message{
+		Type: msgType,
+		TxID: txID & 0xFFFFFF,
+		Options: []*DHCPv6Option{
+			{Code: DHCPv6OptClientID, Data: c.duid()},
+			{Code: DHCPv6OptElapsedTime, Data: []byte{0, 0}},
+			{Code: DHCPv6OptORO, Data: oro},
+		},
+	}
+}
+
+func (c *DHCPv6Client) ia() *DHCPv6Option {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], c.iaid())
+	code := uint16(DHCPv6OptIANA)
+	if c.PrefixDelegation {
+		code = DHCPv6OptIAPD
+	}
+	return &DHCPv6Option{Code: code, Data: data}
+}
+
+func (c *DHCPv6Client) listen(ctx context.Context) (net.PacketConn, error) {
+	if c.Interface == nil {
+		return nil, ErrDHCPNoInterface
+	}
+	if c.ListenPacket != nil {
+		return c.ListenPacket(ctx)
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, raw syscall.RawConn) error {
+			var serr error
+			if err := raw.Control(func(fd uintptr) {
+				serr = setSockoptBindToDevice(fd, c.Interface.Name)
+			}); err != nil {
+				return err
+			}
+			if serr == ErrNotSupported {
+				return nil
+			}
+			return serr
+		},
+	}
+	conn, err := lc.ListenPacket(ctx, "udp6", net.JoinHostPort("::", strconv.Itoa(dhcpv6ClientPort)))
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen dhcpv6 client port error")
+	}
+	if err := ipv6.NewPacketConn(conn).SetMulticastInterface(c.Interface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// exchange 发送 req 并收集应答. collect 为 true 时等待到超时并返回所有应答.
+// 应答必须带有本机的 Client Identifier, req 指定了服务器时还必须来自该服务器 (RFC 8415 16)
+func (c *DHCPv6Client) exchange(ctx context.Context, conn net.PacketConn, req *DHCPv6Message, want uint8, collect bool) ([]*DHCPv6Message, error) {
+	b, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	dst := &net.UDPAddr{IP: AllDHCPRelayAgentsAndServers, Port: dhcpv6ServerPort, Zone: c.Interface.Name}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	retries := c.Retries
+	if retries <= 0 {
+		retries = 3
+	}
+	buf := make([]byte, 65535)
+	var replies []*DHCPv6Message
+	for attempt := 0; attempt < retries; attempt++ {
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			return nil, errors.WithMessage(err, "write dhcpv6 error")
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			resp, err := ParseDHCPv6Message(buf[:n])
+			if err != nil || resp.TxID != req.TxID || resp.Type != want || !c.accept(req, resp) {
+				continue
+			}
+			if !collect {
+				return []*DHCPv6Message{resp}, nil
+			}
+			replies = append(replies, resp)
+		}
+		if len(replies) > 0 {
+			return replies, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrDHCPv6Timeout
+}
+
+func (c *DHCPv6Client) accept(req, resp *DHCPv6Message) bool {
+	if !bytes.Equal(resp.Option(DHCPv6OptClientID), c.duid()) || len(resp.Option(DHCPv6OptServerID)) == 0 {
+		return false
+	}
+	if serverID := req.Option(DHCPv6OptServerID); serverID != nil && !bytes.Equal(resp.Option(DHCPv6OptServerID), serverID) {
+		return false
+	}
+	return true
+}
+
+// Solicit 发送 SOLICIT 并返回所有 ADVERTISE, 不会继续申请, 用于发现 DHCPv6 服务器
+func (c *DHCPv6Client) Solicit(ctx context.Context) ([]*DHCPv6Lease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := c.newMessage(DHCPv6Solicit, randUint32())
+	req.Options = append(req.Options, c.ia())
+	replies, err := c.exchange(ctx, conn, req, DHCPv6Advertise, true)
+	if err != nil {
+		return nil, err
+	}
+	var leases []*DHCPv6Lease
+	for _, reply := range replies {
+		if lease, err := NewDHCPv6Lease(reply); err == nil {
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
+
+// Acquire 完成 SOLICIT/ADVERTISE/REQUEST/REPLY 获取地址或前缀.
+// 向 Preference 最高的服务器申请, 相同时选择最先应答的 (RFC 8415 18.2.9)
+func (c *DHCPv6Client) Acquire(ctx context.Context) (*DHCPv6Lease, error) {
+	advertises, err := c.Solicit(ctx)
+	if err != nil {
+		return nil, errors.WithMessage(err, "solicit")
+	}
+	if len(advertises) == 0 {
+		return nil, ErrDHCPv6Timeout
+	}
+	best := advertises[0]
+	for _, advertise := range advertises[1:] {
+		if advertise.Preference > best.Preference {
+			best = advertise
+		}
+	}
+	return c.request(ctx, DHCPv6Request, best)
+}
+
+// Renew 向原服务器续租, rebind 为 true 时向任意服务器重新绑定
+func (c *DHCPv6Client) Renew(ctx context.Context, lease *DHCPv6Lease, rebind bool) (*DHCPv6Lease, error) {
+	if rebind {
+		return c.request(ctx, DHCPv6Rebind, lease)
+	}
+	return c.request(ctx, DHCPv6Renew, lease)
+}
+
+func (c *DHCPv6Client) request(ctx context.Context, msgType uint8, lease *DHCPv6Lease) (*DHCPv6Lease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := c.newMessage(msgType, randUint32())
+	if msgType != DHCPv6Rebind {
+		req.Options = append(req.Options, &DHCPv6Option{Code: DHCPv6OptServerID, Data: lease.ServerID})
+	}
+	// 原样带回服务器分配的 IA
+	for _, o := range lease.Message.Options {
+		if o.Code == DHCPv6OptIANA || o.Code == DHCPv6OptIAPD {
+			req.Options = append(req.Options, o)
+		}
+	}
+	replies, err := c.exchange(ctx, conn, req, DHCPv6Reply, false)
+	if err != nil {
+		return nil, err
+	}
+	return NewDHCPv6Lease(replies[0])
+}
+
+// InformationRequest 无状态 DHCPv6, 只获取 DNS 等配置
+func (c *DHCPv6Client) InformationRequest(ctx context.Context) (*DHCPv6Lease, error) {
+	conn, err := c.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	replies, err := c.exchange(ctx, conn, c.newMessage(DHCPv6InformationRequest, randUint32()), DHCPv6Reply, false)
+	if err != nil {
+		return nil, err
+	}
+	return NewDHCPv6Lease(replies[0])
+}
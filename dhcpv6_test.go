@@ -0,0 +1,236 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDHCPv6Message(t *testing.T) {
+	m := &DHCPv6Message{
+		Type: DHCPv6Reply,
+		TxID: 0xabcdef,
+		Options: []*DHCPv6Option{
+			{Code: DHCPv6OptClientID, Data: []byte{0, 3, 0, 1, 0, 1, 2, 3, 4, 5}},
+			{Code: DHCPv6OptDNSServers, Data: net.ParseIP("2001:db8::53")},
+			{Code: DHCPv6OptDNSServers, Data: net.ParseIP("2001:db8::54")},
+			{Code: DHCPv6OptRapidCommit},
+		},
+	}
+	b, err := m.ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:4], []byte{DHCPv6Reply, 0xab, 0xcd, 0xef}) || !bytes.Equal(b[4:8], []byte{0, DHCPv6OptClientID, 0, 10}) {
+		t.Fatalf("header % x", b[:8])
+	}
+	parsed, err := ParseDHCPv6Message(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Type != m.Type || parsed.TxID != m.TxID || len(parsed.Options) != len(m.Options) {
+		t.Fatalf("parsed %+v", parsed)
+	}
+	for i, o := range parsed.Options {
+		if o.Code != m.Options[i].Code || !bytes.Equal(o.Data, m.Options[i].Data) {
+			t.Fatalf("option %d: %+v", i, o)
+		}
+	}
+	// 同一 code 出现多次时 Option 返回第一个
+	if !net.IP(parsed.Option(DHCPv6OptDNSServers)).Equal(net.ParseIP("2001:db8::53")) || parsed.Option(DHCPv6OptServerID) != nil {
+		t.Fatalf("option lookup %v", parsed.Option(DHCPv6OptDNSServers))
+	}
+
+	if _, err := ParseDHCPv6Message(b[:3]); err != ErrDHCPv6Short {
+		t.Fatalf("short: %v", err)
+	}
+	if _, err := ParseDHCPv6Message(b[:len(b)-1]); err == nil {
+		t.Fatal("truncated option accepted")
+	}
+	if _, err := (&DHCPv6Message{Options: []*DHCPv6Option{{Data: make([]byte, 0x10000)}}}).ToByte(); err != ErrDHCPv6Option {
+		t.Fatalf("oversized option: %v", err)
+	}
+}
+
+// fakeDHCPv6Conn 代替 546 端口的 socket, 把客户端发出的消息交给 serve, 返回的应答作为收到的数据
+type fakeDHCPv6Conn struct {
+	net.PacketConn
+	serve   func(req *DHCPv6Message) []*DHCPv6Message
+	replies chan []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *fakeDHCPv6Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	req, err := ParseDHCPv6Message(b)
+	if err != nil {
+		return 0, err
+	}
+	for _, resp := range c.serve(req) {
+		data, err := resp.ToByte()
+		if err != nil {
+			return 0, err
+		}
+		c.replies <- data
+	}
+	return len(b), nil
+}
+
+func (c *fakeDHCPv6Conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case data := <-c.replies:
+		return copy(b, data), &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6ServerPort}, nil
+	case <-timer.C:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *fakeDHCPv6Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeDHCPv6Conn) Close() error {
+	return nil
+}
+
+// dhcpv6TestClient 返回使用 serve 应答的客户端, 以及客户端发出的消息
+func dhcpv6TestClient(serve func(req *DHCPv6Message) []*DHCPv6Message) (*DHCPv6Client, func() []*DHCPv6Message) {
+	var mu sync.Mutex
+	var sent []*DHCPv6Message
+	conn := &fakeDHCPv6Conn{replies: make(chan []byte, 16), serve: func(req *DHCPv6Message) []*DHCPv6Message {
+		mu.Lock()
+		sent = append(sent, req)
+		mu.Unlock()
+		return serve(req)
+	}}
+	c := &DHCPv6Client{
+		Interface:    &net.Interface{Name: "test0", Index: 2, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}},
+		Timeout:      20 * time.Millisecond,
+		Retries:      2,
+		ListenPacket: func(context.Context) (net.PacketConn, error) { return conn, nil },
+	}
+	return c, func() []*DHCPv6Message {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent
+	}
+}
+
+// dhcpv6Reply 构造 serverID 对 req 的 msgType 应答, 在 IA_NA 中分配 ip, preference 不为 0 时带上 Preference option
+func dhcpv6Reply(req *DHCPv6Message, msgType uint8, serverID []byte, ip net.IP, preference uint8) *DHCPv6Message {
+	addr := make([]byte, 24)
+	copy(addr, ip.To16())
+	binary.BigEndian.PutUint32(addr[16:20], 1800)
+	binary.BigEndian.PutUint32(addr[20:24], 3600)
+	ia := make([]byte, 12, 40)
+	binary.BigEndian.PutUint32(ia[0:4], 2)
+	binary.BigEndian.PutUint32(ia[4:8], 900)
+	binary.BigEndian.PutUint32(ia[8:12], 1440)
+	ia = append(ia, 0, DHCPv6OptIAAddr, 0, 24)
+	ia = append(ia, addr...)
+	m := &DHCPv6Message{
+		Type: msgType,
+		TxID: req.TxID,
+		Options: []*DHCPv6Option{
+			{Code: DHCPv6OptClientID, Data: req.Option(DHCPv6OptClientID)},
+			{Code: DHCPv6OptServerID, Data: serverID},
+			{Code: DHCPv6OptIANA, Data: ia},
+		},
+	}
+	if preference != 0 {
+		m.Options = append(m.Options, &DHCPv6Option{Code: DHCPv6OptPreference, Data: []byte{preference}})
+	}
+	return m
+}
+
+func TestDHCPv6ClientAcquire(t *testing.T) {
+	serverA, serverB := []byte{0, 3, 0, 1, 0xa, 0, 0, 0, 0, 1}, []byte{0, 3, 0, 1, 0xb, 0, 0, 0, 0, 2}
+	ipA, ipB := net.ParseIP("2001:db8::a"), net.ParseIP("2001:db8::b")
+	c, sent := dhcpv6TestClient(func(req *DHCPv6Message) []*DHCPv6Message {
+		switch req.Type {
+		case DHCPv6Solicit:
+			stale := dhcpv6Reply(req, DHCPv6Advertise, serverB, ipB, 255)
+			stale.TxID ^= 1
+			other := dhcpv6Reply(req, DHCPv6Advertise, serverB, ipB, 255)
+			other.Options[0].Data = []byte{0, 3, 0, 1, 9, 9, 9, 9, 9, 9}
+			anonymous := dhcpv6Reply(req, DHCPv6Advertise, nil, ipB, 255)
+			// B 先应答, 但 A 的 Preference 更高
+			return []*DHCPv6Message{stale, other, anonymous,
+				dhcpv6Reply(req, DHCPv6Advertise, serverB, ipB, 0), dhcpv6Reply(req, DHCPv6Advertise, serverA, ipA, 10)}
+		case DHCPv6Request:
+			// 另一台服务器也应答了组播的 REQUEST
+			return []*DHCPv6Message{dhcpv6Reply(req, DHCPv6Reply, serverB, ipB, 0), dhcpv6Reply(req, DHCPv6Reply, serverA, ipA, 0)}
+		}
+		return nil
+	})
+	lease, err := c.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lease.ServerID, serverA) || len(lease.Addresses) != 1 || !lease.Addresses[0].IP.Equal(ipA) ||
+		lease.IAID != 2 || lease.T1 != 15*time.Minute || lease.Addresses[0].ValidLifetime != time.Hour {
+		t.Fatalf("lease %+v", lease)
+	}
+	msgs := sent()
+	if len(msgs) != 2 || msgs[1].Type != DHCPv6Request || !bytes.Equal(msgs[1].Option(DHCPv6OptServerID), serverA) ||
+		!bytes.Equal(msgs[1].Option(DHCPv6OptClientID), c.duid()) || msgs[1].Option(DHCPv6OptIANA) == nil {
+		t.Fatalf("sent %+v", msgs)
+	}
+
+	advertises, err := c.Solicit(context.Background())
+	if err != nil || len(advertises) != 2 || !bytes.Equal(advertises[0].ServerID, serverB) || advertises[1].Preference != 10 {
+		t.Fatalf("solicit: %v %+v", err, advertises)
+	}
+}
+
+func TestDHCPv6ClientRenew(t *testing.T) {
+	server, rogue := []byte{0, 3, 0, 1, 0xa, 0, 0, 0, 0, 1}, []byte{0, 3, 0, 1, 0xe, 0, 0, 0, 0, 1}
+	ip := net.ParseIP("2001:db8::a")
+	c, sent := dhcpv6TestClient(func(req *DHCPv6Message) []*DHCPv6Message {
+		// 只有 rogue 应答
+		return []*DHCPv6Message{dhcpv6Reply(req, DHCPv6Reply, rogue, ip, 0)}
+	})
+	lease, err := NewDHCPv6Lease(dhcpv6Reply(&DHCPv6Message{TxID: 1}, DHCPv6Reply, server, ip, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Renew(context.Background(), lease, false); err != ErrDHCPv6Timeout {
+		t.Fatalf("renew accepted another server: %v", err)
+	}
+	renewed, err := c.Renew(context.Background(), lease, true)
+	if err != nil || !bytes.Equal(renewed.ServerID, rogue) {
+		t.Fatalf("rebind: %v %+v", err, renewed)
+	}
+	msgs := sent()
+	if len(msgs) != 3 || msgs[0].Type != DHCPv6Renew || !bytes.Equal(msgs[0].Option(DHCPv6OptServerID), server) ||
+		msgs[2].Type != DHCPv6Rebind || msgs[2].Option(DHCPv6OptServerID) != nil || msgs[2].Option(DHCPv6OptIANA) == nil {
+		t.Fatalf("sent %+v", msgs)
+	}
+
+	c, sent = dhcpv6TestClient(func(req *DHCPv6Message) []*DHCPv6Message {
+		reply := dhcpv6Reply(req, DHCPv6Reply, server, nil, 0)
+		reply.Options = append(reply.Options[:2], &DHCPv6Option{Code: DHCPv6OptDNSServers, Data: net.ParseIP("2001:db8::53")})
+		return []*DHCPv6Message{reply}
+	})
+	info, err := c.InformationRequest(context.Background())
+	if err != nil || len(info.DNSServers) != 1 || !info.DNSServers[0].Equal(net.ParseIP("2001:db8::53")) {
+		t.Fatalf("information request: %v %+v", err, info)
+	}
+	if msgs := sent(); len(msgs) != 1 || msgs[0].Type != DHCPv6InformationRequest || msgs[0].Option(DHCPv6OptIANA) != nil {
+		t.Fatalf("sent %+v", msgs)
+	}
+}
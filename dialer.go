@@ -0,0 +1,137 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Dialer 与 net.Dialer 的 DialContext 方法一致, 代理、限速、统计等功能都通过包裹 Dialer 实现
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialerFunc 允许将普通函数作为 Dialer 使用
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// DialMiddleware 包裹下一个 Dialer, 与 Interceptor 对 RoundTripper 的作用相同
+type DialMiddleware func(next Dialer) Dialer
+
+// ChainDialer 用 middlewares 依次包裹 base, 第一个中间件最先收到拨号请求.
+// base 为空时使用 net.Dialer. 典型的顺序为 conn 包装 → TLS → 代理 → 解析:
+//
+//	ChainDialer(&ResolvingDialer{Resolver: r}, WithConnWrapper(meter), WithTLS(cfg), WithProxy(nil))
+func ChainDialer(base Dialer, middlewares ...DialMiddleware) Dialer {
+	d := base
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		d = middlewares[i](d)
+	}
+	return d
+}
+
+// WithProxy 按 proxy 选择代理, 直连与连接代理都经过 next. proxy 为空时读取环境变量
+func WithProxy(proxy func(address string) (*url.URL, error)) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return &ProxyDialer{Proxy: proxy, Direct: next}
+	}
+}
+
+// WithSOCKS5 所有 tcp 与 udp 连接都经过 SOCKS5 代理
+func WithSOCKS5(proxyAddress, username, password string) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return &SOCKS5Dialer{ProxyAddress: proxyAddress, Username: username, Password: password, Forward: next}
+	}
+}
+
+// WithTLS 在 tcp 连接上完成 TLS 握手, config 未设置 ServerName 时使用目标主机名.
+// config 未设置 ClientSessionCache 时使用该中间件共享的缓存, 按服务器名保存会话票据,
+// 之后的连接恢复会话, 省去证书交换与验证. crypto/tls 的客户端不支持 0-RTT 早期数据
+func WithTLS(config *tls.Config) DialMiddleware {
+	sessions := tls.NewLRUClientSessionCache(0)
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := next.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			c := &tls.Config{}
+			if config != nil {
+				c = config.Clone()
+			}
+			if c.ClientSessionCache == nil {
+				c.ClientSessionCache = sessions
+			}
+			if c.ServerName == "" {
+				host, _, _ := net.SplitHostPort(address)
+				c.ServerName = host
+			}
+			tlsConn := tls.Client(conn, c)
+			if deadline, ok := ctx.Deadline(); ok {
+				_ = conn.SetDeadline(deadline)
+				defer conn.SetDeadline(time.Time{})
+			}
+			if err := tlsConn.Handshake(); err != nil {
+				_ = conn.Close()
+				return nil, errors.WithMessage(err, "tls handshake error")
+			}
+			return tlsConn, nil
+		})
+	}
+}
+
+// WithConnWrapper 用 wrap 包装每一个建立成功的连接
+func WithConnWrapper(wrap func(net.Conn) net.Conn) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := next.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(conn), nil
+		})
+	}
+}
+
+// ResolvingDialer 使用 netx Resolver 解析目标主机名, 依次尝试每个地址
+type ResolvingDialer struct {
+	Resolver *Resolver
+	// Next 用于连接解析出的地址, 为空时使用 net.Dialer
+	Next Dialer
+}
+
+func (d *ResolvingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var next Dialer = &net.Dialer{}
+	if d.Next != nil {
+		next = d.Next
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || d.Resolver == nil || net.ParseIP(host) != nil {
+		return next.DialContext(ctx, network, address)
+	}
+	addrs, err := d.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	lastErr := errors.WithMessage(ErrNoAddress, host)
+	for _, addr := range addrs {
+		conn, err := next.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
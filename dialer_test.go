@@ -0,0 +1,78 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestChainDialer(t *testing.T) {
+	var order []string
+	trace := func(name string) DialMiddleware {
+		return func(next Dialer) Dialer {
+			return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+				order = append(order, name)
+				return next.DialContext(ctx, network, address)
+			})
+		}
+	}
+	base := DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		order = append(order, "base:"+address)
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+	wrapped := false
+	d := ChainDialer(base, trace("a"), WithConnWrapper(func(c net.Conn) net.Conn {
+		wrapped = true
+		return c
+	}), trace("b"))
+	conn, err := d.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := strings.Join(order, ","); got != "a,b,base:example.com:80" || !wrapped {
+		t.Fatalf("unexpected dial order %s, wrapped %v", got, wrapped)
+	}
+}
+
+func TestTLSSessionResumptionAndWarm(t *testing.T) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, "dns.example.com")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		return NewReply(req.Message), nil
+	})}
+	go s.ServeTCP(ln)
+
+	tlsDialer := ChainDialer(nil, WithTLS(&tls.Config{ServerName: "dns.example.com", InsecureSkipVerify: true}))
+	r := &Resolver{Server: ln.Addr().String(), Transport: TCPTransport{Dialer: tlsDialer}}
+	for i, resumed := range []bool{false, true} {
+		info := &QueryInfo{}
+		if _, err := r.Query(WithQueryInfo(context.Background(), info), "example.com", DNSTypeA); err != nil {
+			t.Fatal(err)
+		}
+		if info.Transport != "tls" || info.Resumed != resumed {
+			t.Fatalf("query %d: transport %s resumed %v", i, info.Transport, info.Resumed)
+		}
+	}
+
+	pool := &ConnPool{Dialer: tlsDialer}
+	defer pool.Close()
+	r.Transport = TCPTransport{Pool: pool}
+	if err := r.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pool.buckets["tcp|"+r.Server].idle); n != 2 {
+		t.Fatalf("idle = %d", n)
+	}
+	info := &QueryInfo{}
+	if _, err := r.Query(WithQueryInfo(context.Background(), info), "example.com", DNSTypeA); err != nil || !info.Reused {
+		t.Fatalf("warm query reused = %v, %v", info.Reused, err)
+	}
+}
@@ -0,0 +1,133 @@
+package netx
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"time"
+)
+
+var ErrDNROption = errors.New("malformed dnr option")
+
+// ParseDHCPDNR 解析 DHCPv4 option 162 (RFC 9463 5), 一个选项可以包含多个实例.
+// 只有 ADN 的实例 (ADN-only 模式) 需要另外通过 DDR 发现, 不出现在结果中. 结果按优先级排序
+func ParseDHCPDNR(b []byte) ([]*DesignatedResolver, error) {
+	var result []*DesignatedResolver
+	for len(b) > 0 {
+		if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+			return nil, ErrDNROption
+		}
+		inst := b[2 : 2+int(binary.BigEndian.Uint16(b))]
+		b = b[len(inst)+2:]
+		if len(inst) < 3 || len(inst) < 3+int(inst[2]) {
+			return nil, ErrDNROption
+		}
+		adn, rest := inst[3:3+int(inst[2])], inst[3+int(inst[2]):]
+		if len(rest) == 0 {
+			continue
+		}
+		if len(rest) < 1+int(rest[0]) {
+			return nil, ErrDNROption
+		}
+		resolvers, err := dnrEndpoints(binary.BigEndian.Uint16(inst), adn, rest[1:1+int(rest[0])], net.IPv4len, rest[1+int(rest[0]):])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolvers...)
+	}
+	sortDesignated(result)
+	return result, nil
+}
+
+// ParseDHCPv6DNR 解析 DHCPv6 option 144 (RFC 9463 4), 每个选项一个实例, 没有加密端点时返回空
+func ParseDHCPv6DNR(b []byte) ([]*DesignatedResolver, error) {
+	if len(b) < 4 || len(b) < 4+int(binary.BigEndian.Uint16(b[2:])) {
+		return nil, ErrDNROption
+	}
+	adn, rest := b[4:4+int(binary.BigEndian.Uint16(b[2:]))], b[4+int(binary.BigEndian.Uint16(b[2:])):]
+	if len(rest) == 0 {
+		return nil, nil
+	}
+	if len(rest) < 2 || len(rest) < 2+int(binary.BigEndian.Uint16(rest)) {
+		return nil, ErrDNROption
+	}
+	addrs := rest[2 : 2+int(binary.BigEndian.Uint16(rest))]
+	return dnrEndpoints(binary.BigEndian.Uint16(b), adn, addrs, net.IPv6len, rest[2+len(addrs):])
+}
+
+// parseRADNR 解析路由通告 DNR 选项 (RFC 9463 6) 去掉类型与长度之后的内容, 末尾可能有填充
+func parseRADNR(b []byte) ([]*DesignatedResolver, time.Duration, error) {
+	if len(b) < 8 || len(b) < 8+int(binary.BigEndian.Uint16(b[6:])) {
+		return nil, 0, ErrDNROption
+	}
+	lifetime := time.Duration(binary.BigEndian.Uint32(b[2:6])) * time.Second
+	adn, rest := b[8:8+int(binary.BigEndian.Uint16(b[6:]))], b[8+int(binary.BigEndian.Uint16(b[6:])):]
+	// ADN-only 模式下只剩下填充
+	if len(rest) < 2 || binary.BigEndian.Uint16(rest) == 0 {
+		return nil, lifetime, nil
+	}
+	if len(rest) < 4+int(binary.BigEndian.Uint16(rest)) {
+		return nil, 0, ErrDNROption
+	}
+	addrs, rest := rest[2:2+int(binary.BigEndian.Uint16(rest))], rest[2+int(binary.BigEndian.Uint16(rest)):]
+	if len(rest) < 2+int(binary.BigEndian.Uint16(rest)) {
+		return nil, 0, ErrDNROption
+	}
+	resolvers, err := dnrEndpoints(binary.BigEndian.Uint16(b), adn, addrs, net.IPv6len, rest[2:2+int(binary.BigEndian.Uint16(rest))])
+	return resolvers, lifetime, err
+}
+
+// dnrEndpoints 由一个 DNR 实例的各个字段生成端点. 地址长度必须是 size 的倍数, 未指定与组播地址被忽略
+func dnrEndpoints(priority uint16, adn, addrs []byte, size int, params []byte) ([]*DesignatedResolver, error) {
+	u := &unpacker{msg: adn, partial: true}
+	target, _, err := u.name()
+	if err != nil || u.off != len(adn) || target == "" {
+		return nil, errors.WithMessage(ErrDNROption, "authentication domain name")
+	}
+	if len(addrs)%size != 0 {
+		return nil, errors.WithMessage(ErrDNROption, "address length")
+	}
+	base := DesignatedResolver{Priority: priority, Target: target}
+	for ; len(addrs) > 0; addrs = addrs[size:] {
+		ip := net.IP(addrs[:size])
+		if ip.IsUnspecified() || ip.IsMulticast() {
+			continue
+		}
+		base.Addrs = append(base.Addrs, ip.String())
+	}
+	if len(base.Addrs) == 0 {
+		return nil, errors.WithMessage(ErrDNROption, "no usable address")
+	}
+	resolvers, err := designatedEndpoints(base, params)
+	if err != nil {
+		return nil, errors.WithMessage(ErrDNROption, err.Error())
+	}
+	return resolvers, nil
+}
+
+func sortDesignated(list []*DesignatedResolver) {
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Priority < list[j].Priority })
+}
+
+// UpgradeDNR 按 policy 使用网络通告的加密端点, 例如 DHCPLease.DNR, DHCPv6Lease.DNR 或 RouterAdvertisement.DNR.
+// 与 DDR 不同, 端点的地址来自网络配置本身, 只需要证书对 Target (ADN) 有效, 连接时才验证.
+// 使用优先级最高的端点的第一个地址; 严格模式下 list 中没有可用端点时返回 ErrNoEncryptedResolver,
+// 机会模式下查询失败后用原来的明文配置重试. config 可以为空
+func (r *Resolver) UpgradeDNR(policy EncryptionPolicy, config *tls.Config, list []*DesignatedResolver) (*Resolver, error) {
+	if policy != EncryptionPlaintext {
+		sorted := append([]*DesignatedResolver(nil), list...)
+		sortDesignated(sorted)
+		for _, d := range sorted {
+			if len(d.Addrs) > 0 && d.Protocol != "" {
+				return r.encrypted(policy, d.Transport(config, d.Addrs[0]), d.Server(d.Addrs[0])), nil
+			}
+		}
+		if policy == EncryptionStrict {
+			return nil, ErrNoEncryptedResolver
+		}
+	}
+	u := *r
+	return &u, nil
+}
@@ -0,0 +1,84 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNR(t *testing.T) {
+	adn, _ := appendName(nil, "dns.example.net")
+	params := append(svcParam(svcParamALPN, []byte{3, 'd', 'o', 't', 2, 'h', '2'}),
+		svcParam(svcParamDoHPath, []byte("/dns-query{?dns}"))...)
+
+	// DHCPv4: 一个普通实例与一个 ADN-only 实例
+	inst := append([]byte{0, 2, byte(len(adn))}, adn...)
+	inst = append(append(inst, 8, 192, 0, 2, 53, 192, 0, 2, 54), params...)
+	v4 := append([]byte{byte(len(inst) >> 8), byte(len(inst))}, inst...)
+	adnOnly := append([]byte{0, 1, byte(len(adn))}, adn...)
+	v4 = append(append(v4, 0, byte(len(adnOnly))), adnOnly...)
+	list, err := ParseDHCPDNR(v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Protocol != "tls" || list[0].Port != 853 || list[1].Protocol != "https" || list[1].DoHPath != "/dns-query" {
+		t.Fatalf("unexpected v4 resolvers %+v", list)
+	}
+	if list[0].Target != "dns.example.net" || len(list[0].Addrs) != 2 || list[0].Addrs[1] != "192.0.2.54" || list[0].Priority != 2 {
+		t.Fatalf("unexpected v4 resolver %+v", list[0])
+	}
+	if _, err := ParseDHCPDNR(v4[:len(v4)-3]); !errors.Is(err, ErrDNROption) {
+		t.Fatalf("truncated option: %v", err)
+	}
+
+	// DHCPv6 选项与租约
+	v6 := append([]byte{0, 1, 0, byte(len(adn))}, adn...)
+	v6 = append(append(v6, 0, 16), net.ParseIP("2001:db8::53")...)
+	v6 = append(v6, svcParam(svcParamALPN, []byte{3, 'd', 'o', 't'})...)
+	lease, err := NewDHCPv6Lease(&DHCPv6Message{Type: DHCPv6Reply, Options: []*DHCPv6Option{
+		{Code: DHCPv6OptDNR, Data: v6},
+		{Code: DHCPv6OptDNR, Data: []byte{0, 1, 0, 9}}, // 被丢弃
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lease.DNR) != 1 || lease.DNR[0].Addrs[0] != "2001:db8::53" || lease.DNR[0].Protocol != "tls" {
+		t.Fatalf("unexpected v6 resolvers %+v", lease.DNR)
+	}
+
+	// 路由通告, 长度补齐到 8 字节
+	opt := append([]byte{ndOptDNR, 0, 0, 3, 0, 0, 0x0e, 0x10, 0, byte(len(adn))}, adn...)
+	opt = append(append(opt, 0, 16), net.ParseIP("2001:db8::853")...)
+	dot := svcParam(svcParamALPN, []byte{3, 'd', 'o', 't'})
+	opt = append(append(opt, 0, byte(len(dot))), dot...)
+	for len(opt)%8 != 0 {
+		opt = append(opt, 0)
+	}
+	opt[1] = byte(len(opt) / 8)
+	ra, err := ParseRouterAdvertisement(net.ParseIP("fe80::1"), append(make([]byte, 12), opt...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ra.DNR) != 1 || ra.DNR[0].Priority != 3 || ra.DNR[0].Addrs[0] != "2001:db8::853" || ra.DNRLifetime != time.Hour {
+		t.Fatalf("unexpected ra resolvers %+v %v", ra.DNR, ra.DNRLifetime)
+	}
+
+	r := &Resolver{Server: "192.0.2.1:53"}
+	if _, err := r.UpgradeDNR(EncryptionStrict, nil, nil); !errors.Is(err, ErrNoEncryptedResolver) {
+		t.Fatalf("strict without resolvers: %v", err)
+	}
+	u, err := r.UpgradeDNR(EncryptionStrict, nil, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Server != "192.0.2.53:853" || r.Server != "192.0.2.1:53" {
+		t.Fatalf("unexpected server %s", u.Server)
+	}
+	if _, ok := u.Transport.(TCPTransport); !ok {
+		t.Fatalf("unexpected transport %T", u.Transport)
+	}
+	if u, _ := r.UpgradeDNR(EncryptionPlaintext, nil, list); u.Server != r.Server || u.Transport != nil {
+		t.Fatalf("plaintext policy changed resolver: %+v", u)
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"strings"
 )
 
+// ErrCountMismatch 编码时头部的计数与实际的问题或记录数不一致
+var ErrCountMismatch = errors.New("header count mismatch")
+
 func LookUp(serviceIP, host string) (string, error) {
 	conn, err := net.Dial("udp", serviceIP)
 	if err != nil {
@@ -65,31 +68,60 @@ func LookUp(serviceIP, host string) (string, error) {
 type DNSMessage struct {
 	Header          *DNSHeader
 	Questions       []*DNSQuestion
-	ResourceRecodes []*DNSResourceRecode
+	ResourceRecodes []*DNSResourceRecode // 按顺序包含回答、授权、附加三部分, 数量见 Header
+
+	pooled bool // 由 UnpackPooled 从对象池取得, Release 时放回
+}
+
+// section 按 Header 中的计数截取 ResourceRecodes
+func (d *DNSMessage) section(start, count int) []*DNSResourceRecode {
+	if start > len(d.ResourceRecodes) {
+		return nil
+	}
+	end := start + count
+	if end > len(d.ResourceRecodes) {
+		end = len(d.ResourceRecodes)
+	}
+	return d.ResourceRecodes[start:end]
+}
+
+// Answers 回答部分
+func (d *DNSMessage) Answers() []*DNSResourceRecode {
+	return d.section(0, int(d.Header.AnswerRRs))
+}
+
+// Authorities 授权部分
+func (d *DNSMessage) Authorities() []*DNSResourceRecode {
+	return d.section(int(d.Header.AnswerRRs), int(d.Header.AuthorityRRs))
+}
+
+// Additionals 附加部分
+func (d *DNSMessage) Additionals() []*DNSResourceRecode {
+	return d.section(int(d.Header.AnswerRRs)+int(d.Header.AuthorityRRs), int(d.Header.AdditionalRRs))
 }
 
 func (d *DNSMessage) ToByte() ([]byte, error) {
-	var buffer bytes.Buffer
-	header, err := d.Header.ToByte()
-	if err != nil {
-		return nil, errors.WithMessage(err, "get header error")
+	// 大多数报文不超过 512 字节, 一次分配即可
+	b := make([]byte, 0, 512)
+	b = d.Header.appendTo(b)
+	if int(d.Header.Questions) != len(d.Questions) {
+		return nil, errors.WithMessagef(ErrCountMismatch, "%d questions, header says %d", len(d.Questions), d.Header.Questions)
 	}
-	buffer.Write(header)
-	for i := uint16(0); i < d.Header.Questions; i++ {
-		question, err := d.Questions[i].ToByte()
-		if err != nil {
+	if n := int(d.Header.AnswerRRs) + int(d.Header.AuthorityRRs) + int(d.Header.AdditionalRRs); n != len(d.ResourceRecodes) {
+		return nil, errors.WithMessagef(ErrCountMismatch, "%d records, header says %d", len(d.ResourceRecodes), n)
+	}
+	var err error
+	for _, q := range d.Questions {
+		if b, err = q.appendTo(b); err != nil {
 			return nil, errors.WithMessage(err, "write question error")
 		}
-		buffer.Write(question)
 	}
 	for _, recode := range d.ResourceRecodes {
-		toByte, err := recode.ToByte()
-		if err != nil {
+		if b, err = recode.appendTo(b); err != nil {
 			return nil, errors.WithMessage(err, "write resource error")
 		}
-		buffer.Write(toByte)
 	}
-	return buffer.Bytes(), nil
+	return b, nil
 }
 
 type DNSHeader struct {
@@ -105,15 +137,27 @@ type DNSHeader struct {
 }
 
 func (h *DNSHeader) ToByte() ([]byte, error) {
-	var buffer bytes.Buffer
-	for i, u := range [6]uint16{h.TxID, h.Flags.ToBit(), h.Questions, h.AnswerRRs, h.AuthorityRRs, h.AdditionalRRs} {
-		if err := binary.Write(&buffer, binary.BigEndian, u); err != nil {
-			return nil, errors.WithMessage(err, "header error index: "+strconv.Itoa(i))
-		}
-	}
-	return buffer.Bytes(), nil
+	return h.appendTo(make([]byte, 0, 12)), nil
+}
+
+// appendTo 将 12 字节的头部追加到 b
+func (h *DNSHeader) appendTo(b []byte) []byte {
+	var buf [12]byte
+	binary.BigEndian.PutUint16(buf[0:], h.TxID)
+	binary.BigEndian.PutUint16(buf[2:], h.Flags.ToBit())
+	binary.BigEndian.PutUint16(buf[4:], h.Questions)
+	binary.BigEndian.PutUint16(buf[6:], h.AnswerRRs)
+	binary.BigEndian.PutUint16(buf[8:], h.AuthorityRRs)
+	binary.BigEndian.PutUint16(buf[10:], h.AdditionalRRs)
+	return append(b, buf[:]...)
 }
 
+// DNSFlags.Z 中的 AD 与 CD 位, RFC 4035
+const (
+	flagAD = 1 << 1
+	flagCD = 1
+)
+
 type DNSFlags struct {
 	QR     uint16 // 请求/响应的标志信息. 请求:0; 响应:1
 	OpCode uint16 // 0:标准查询; 1:反向查询; 2:服务器状态
@@ -133,16 +177,9 @@ type DNSFlags struct {
 }
 
 func (f *DNSFlags) ToBit() uint16 {
-	return f.QR<<15 + f.OpCode<<11 + f.AA<<10 + f.TC<<9 + f.RD<<8 + f.RA<<7 + f.RCode
+	return f.QR<<15 + f.OpCode<<11 + f.AA<<10 + f.TC<<9 + f.RD<<8 + f.RA<<7 + f.Z<<4 + f.RCode
 }
 
-const (
-	DNSTypeA     = 1
-	DNSTypeNS    = 2
-	DNSTypeCName = 5
-	DNSTypeAAAA  = 28 // IPV6
-)
-
 type DNSQuestion struct {
 	QuestionName  string
 	QuestionType  uint16
@@ -150,32 +187,18 @@ type DNSQuestion struct {
 }
 
 func (q *DNSQuestion) ToByte() ([]byte, error) {
-	var buffer bytes.Buffer
-	for _, seg := range strings.Split(q.QuestionName, ".") {
-		if err := binary.Write(&buffer, binary.BigEndian, byte(len(seg))); err != nil {
-			return nil, errors.WithMessage(err, "write seg len error")
-		}
-		if err := binary.Write(&buffer, binary.BigEndian, []byte(seg)); err != nil {
-			return nil, errors.WithMessage(err, "write seg error")
-		}
-	}
-	if err := binary.Write(&buffer, binary.BigEndian, byte(0x00)); err != nil {
-		return nil, errors.WithMessage(err, "write 0x00 error")
-	}
+	return q.appendTo(make([]byte, 0, len(q.QuestionName)+6))
+}
 
-	if err := binary.Write(&buffer, binary.BigEndian, q.QuestionType); err != nil {
-		return nil, errors.WithMessage(err, "write question type error")
-	}
-	if err := binary.Write(&buffer, binary.BigEndian, q.QuestionClass); err != nil {
-		return nil, errors.WithMessage(err, "write question class error")
+func (q *DNSQuestion) appendTo(b []byte) ([]byte, error) {
+	b, err := appendName(b, q.QuestionName)
+	if err != nil {
+		return nil, errors.WithMessage(err, "write question name error")
 	}
-	return buffer.Bytes(), nil
+	b = appendUint16(b, q.QuestionType)
+	return appendUint16(b, q.QuestionClass), nil
 }
 
-const (
-	DNSClassIn = 1
-)
-
 // DNSResourceRecode 回答字段，授权字段，附加字段
 type DNSResourceRecode struct {
 	Name     string
@@ -183,55 +206,129 @@ type DNSResourceRecode struct {
 	RRType   uint16
 	Class    uint16
 	TTL      uint32
-	RDLength uint16
-	RData    string
+	RDLength uint16 // 解码时为报文中的长度, 编码时根据 RData/Data 重新计算
+	// RData A/AAAA 为 IP, NS/CNAME/PTR 为域名, MX 为 "优先级 域名", SRV 为 "优先级 权重 端口 域名",
+	// SOA 为 "主服务器 邮箱 序列号 刷新 重试 过期 最小TTL"
+	RData string
+	Data  []byte // 未能解析为 RData 的原始数据, 编码时优先使用
 }
 
-var ErrClassNotSupport = errors.New("this class is not supported")
+var (
+	ErrClassNotSupport = errors.New("this class is not supported")
+	ErrTypeNotSupport  = errors.New("this type is not supported")
+	ErrInvalidIP       = errors.New("invalid ip address")
+	ErrRDataTooLong    = errors.New("rdata exceeds 65535 bytes")
+)
 
 func (r *DNSResourceRecode) ToByte() ([]byte, error) {
-	var buffer bytes.Buffer
+	return r.appendTo(make([]byte, 0, len(r.Name)+len(r.RData)+len(r.Data)+12))
+}
+
+func (r *DNSResourceRecode) appendTo(b []byte) ([]byte, error) {
+	var err error
 	if r.NamePos > 0 {
-		if err := binary.Write(&buffer, binary.BigEndian, (0x01<<15)|(0x01<<14)|r.NamePos); err != nil {
-			return nil, errors.WithMessage(err, "name pos zero write error")
-		}
+		b = appendUint16(b, (0x01<<15)|(0x01<<14)|r.NamePos)
+	} else if b, err = appendName(b, r.Name); err != nil {
+		return nil, errors.WithMessage(err, "write name error")
 	}
-	if r.NamePos <= 0 {
-		segments := strings.Split(r.Name, ".")
-		for _, seg := range segments {
-			if err := binary.Write(&buffer, binary.BigEndian, byte(len(seg))); err != nil {
-				return nil, errors.WithMessage(err, "write seg len error")
+	b = appendUint16(b, r.RRType)
+	b = appendUint16(b, r.Class)
+	b = appendUint32(b, r.TTL)
+	// 先占位 RDLength, 写入 RData 后回填
+	at := len(b)
+	b = append(b, 0, 0)
+	if b, err = r.appendRData(b); err != nil {
+		return nil, errors.WithMessage(err, "write RData error")
+	}
+	length := len(b) - at - 2
+	if length > 0xFFFF {
+		return nil, ErrRDataTooLong
+	}
+	binary.BigEndian.PutUint16(b[at:], uint16(length))
+	return b, nil
+}
+
+func (r *DNSResourceRecode) packRData() ([]byte, error) {
+	return r.appendRData(nil)
+}
+
+// appendRData 将 RData 编码后追加到 b
+func (r *DNSResourceRecode) appendRData(b []byte) ([]byte, error) {
+	if r.Data != nil {
+		return append(b, r.Data...), nil
+	}
+	switch r.RRType {
+	case DNSTypeA:
+		ip := net.ParseIP(r.RData).To4()
+		if ip == nil {
+			return nil, ErrInvalidIP
+		}
+		return append(b, ip...), nil
+	case DNSTypeAAAA:
+		ip := net.ParseIP(r.RData)
+		if ip == nil {
+			return nil, ErrInvalidIP
+		}
+		return append(b, ip.To16()...), nil
+	case DNSTypeNS, DNSTypeCName, DNSTypePTR:
+		return appendName(b, r.RData)
+	case DNSTypeSOA:
+		fields := strings.Fields(r.RData)
+		if len(fields) != 7 {
+			return nil, ErrBadRData
+		}
+		var err error
+		for _, name := range fields[:2] {
+			if b, err = appendName(b, name); err != nil {
+				return nil, err
 			}
-			if err := binary.Write(&buffer, binary.BigEndian, []byte(seg)); err != nil {
-				return nil, errors.WithMessage(err, "write seg error")
+		}
+		for _, field := range fields[2:] {
+			v, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, ErrBadRData
 			}
+			b = appendUint32(b, uint32(v))
 		}
-		if err := binary.Write(&buffer, binary.BigEndian, byte(0x00)); err != nil {
-			return nil, errors.WithMessage(err, "write 0x00 error")
+		return b, nil
+	case DNSTypeSRV:
+		fields := strings.Fields(r.RData)
+		if len(fields) != 4 {
+			return nil, ErrBadRData
 		}
+		for _, field := range fields[:3] {
+			v, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return nil, ErrBadRData
+			}
+			b = appendUint16(b, uint16(v))
+		}
+		return appendName(b, fields[3])
+	case DNSTypeMX:
+		fields := strings.Fields(r.RData)
+		if len(fields) != 2 {
+			return nil, ErrBadRData
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, ErrBadRData
+		}
+		return appendName(appendUint16(b, uint16(pref)), fields[1])
 	}
-
-	if err := binary.Write(&buffer, binary.BigEndian, r.RRType); err != nil {
-		return nil, errors.WithMessage(err, "write RRType error")
-	}
-	if err := binary.Write(&buffer, binary.BigEndian, r.Class); err != nil {
-		return nil, errors.WithMessage(err, "write Class error")
-	}
-	if err := binary.Write(&buffer, binary.BigEndian, r.TTL); err != nil {
-		return nil, errors.WithMessage(err, "write TTL error")
-	}
-	if err := binary.Write(&buffer, binary.BigEndian, r.RDLength); err != nil {
-		return nil, errors.WithMessage(err, "RDLength error")
+	if r.RData != "" {
+		return nil, ErrTypeNotSupport
 	}
+	return b, nil
+}
 
-	switch r.Class {
-	case DNSClassIn:
-		if err := binary.Write(&buffer, binary.BigEndian, []byte(net.ParseIP(r.RData).To4())); err != nil {
-			return nil, errors.WithMessage(err, "write RData error")
-		}
-	default:
-		return nil, ErrClassNotSupport
-	}
+func appendUint16(b []byte, v uint16) []byte {
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], v)
+	return b
+}
 
-	return buffer.Bytes(), nil
+func appendUint32(b []byte, v uint32) []byte {
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], v)
+	return b
 }
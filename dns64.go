@@ -0,0 +1,236 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/netip"
+	"sync"
+)
+
+var (
+	ErrBadNAT64Prefix = errors.New("nat64 prefix must be an ipv6 /32, /40, /48, /56, /64 or /96")
+	ErrNoNAT64        = errors.New("no nat64 prefix found")
+)
+
+// WellKnownNAT64Prefix RFC 6052 的知名前缀 64:ff9b::/96
+var WellKnownNAT64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// ipv4only.arpa 的两个知名地址, RFC 7050
+var nat64WKAs = [2]netip.Addr{netip.AddrFrom4([4]byte{192, 0, 0, 170}), netip.AddrFrom4([4]byte{192, 0, 0, 171})}
+
+// nat64PrefixLens 发现前缀时依次检查的长度
+var nat64PrefixLens = []int{96, 64, 56, 48, 40, 32}
+
+// mappedIPv4 RFC 6147 默认排除的 AAAA 记录, 只有这类记录时视为没有 AAAA
+var mappedIPv4 = netip.MustParsePrefix("::ffff:0:0/96")
+
+// DNS64 在 AAAA 查询没有回答时改为查询 A 记录, 用 prefix 合成 AAAA 回答 (RFC 6147), 供只有 IPv6 的网络使用.
+// prefix 为零值时第一次需要时通过 ipv4only.arpa 向同一服务器发现前缀, 发现失败时不合成, 下次再尝试
+func DNS64(prefix netip.Prefix) Interceptor {
+	var mu sync.Mutex
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := next.RoundTrip(ctx, server, req)
+			if err != nil || !needsDNS64(req, resp) {
+				return resp, err
+			}
+			mu.Lock()
+			p := prefix
+			mu.Unlock()
+			if !p.IsValid() {
+				prefixes, err := discoverNAT64(ctx, next, server)
+				if err != nil {
+					return resp, nil
+				}
+				p = prefixes[0]
+				mu.Lock()
+				prefix = p
+				mu.Unlock()
+			}
+			aResp, err := next.RoundTrip(ctx, server, dns64Query(req))
+			if err != nil {
+				return resp, nil
+			}
+			reply := dns64Reply(req, aResp, p)
+			aResp.Release()
+			if reply == nil {
+				return resp, nil
+			}
+			resp.Release()
+			return reply, nil
+		})
+	}
+}
+
+// WithDNS64 服务端的 DNS64: AAAA 查询没有回答时向 next 查询 A 记录并合成 AAAA 回答.
+// prefix 为零值时使用 WellKnownNAT64Prefix
+func WithDNS64(prefix netip.Prefix) ServerMiddleware {
+	if !prefix.IsValid() {
+		prefix = WellKnownNAT64Prefix
+	}
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp, err := next.ServeDNS(ctx, req)
+			if err != nil || resp == nil || !needsDNS64(req.Message, resp) {
+				return resp, err
+			}
+			aResp, err := next.ServeDNS(ctx, &DNSRequest{Message: dns64Query(req.Message), Network: req.Network, RemoteAddr: req.RemoteAddr})
+			if err != nil || aResp == nil {
+				return resp, nil
+			}
+			if reply := dns64Reply(req.Message, aResp, prefix); reply != nil {
+				return reply, nil
+			}
+			return resp, nil
+		})
+	}
+}
+
+// needsDNS64 resp 是否为 IN AAAA 查询的 NOERROR 无数据响应. 客户端要求自己验证 DNSSEC (DO 与 CD) 时不合成
+func needsDNS64(req, resp *DNSMessage) bool {
+	if len(req.Questions) != 1 || req.Questions[0].QuestionType != DNSTypeAAAA || req.Questions[0].QuestionClass != DNSClassIn {
+		return false
+	}
+	if req.DNSSECOK() && req.Header.Flags.CheckingDisabled() {
+		return false
+	}
+	if resp.Header.Flags.RCode != DNSRCodeSuccess || resp.Header.Flags.TC == 1 {
+		return false
+	}
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeAAAA {
+			continue
+		}
+		if addr, err := netip.ParseAddr(rr.RData); err != nil || !mappedIPv4.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// dns64Query 把 AAAA 查询改为 A 查询
+func dns64Query(req *DNSMessage) *DNSMessage {
+	q := req.Copy()
+	q.Questions[0].QuestionType = DNSTypeA
+	return q
+}
+
+// dns64Reply 由 A 查询的响应合成 AAAA 回答, 保留 CNAME 等其它回答. 没有 A 记录时返回空
+func dns64Reply(req, aResp *DNSMessage, prefix netip.Prefix) *DNSMessage {
+	if aResp.Header.Flags.RCode != DNSRCodeSuccess {
+		return nil
+	}
+	var answers []*DNSResourceRecode
+	synthesized := false
+	for _, rr := range aResp.Answers() {
+		c := rr.Copy()
+		if rr.RRType == DNSTypeA {
+			v4, err := netip.ParseAddr(rr.RData)
+			if err != nil {
+				continue
+			}
+			v6, err := embedIPv4(prefix, v4)
+			if err != nil {
+				return nil
+			}
+			c.RRType, c.RData, c.RDLength, c.Data = DNSTypeAAAA, v6.String(), 16, nil
+			synthesized = true
+		}
+		answers = append(answers, c)
+	}
+	if !synthesized {
+		return nil
+	}
+	reply := NewReply(req)
+	reply.Header.Flags.RA = aResp.Header.Flags.RA
+	reply.ResourceRecodes = answers
+	reply.Header.AnswerRRs = uint16(len(answers))
+	return reply
+}
+
+// embedIPv4 按 RFC 6052 2.2 把 v4 嵌入 prefix, /64 以内的前缀跳过第 64-71 位 (u-octet)
+func embedIPv4(prefix netip.Prefix, v4 netip.Addr) (netip.Addr, error) {
+	if !validNAT64Prefix(prefix) {
+		return netip.Addr{}, ErrBadNAT64Prefix
+	}
+	v4 = v4.Unmap()
+	if !v4.Is4() {
+		return netip.Addr{}, errors.WithMessage(ErrBadNAT64Prefix, v4.String()+" is not ipv4")
+	}
+	b := prefix.Masked().Addr().As16()
+	j := prefix.Bits() / 8
+	for _, x := range v4.As4() {
+		if j == 8 {
+			j++
+		}
+		b[j] = x
+		j++
+	}
+	return netip.AddrFrom16(b), nil
+}
+
+// extractIPv4 embedIPv4 的逆运算
+func extractIPv4(bits int, v6 netip.Addr) netip.Addr {
+	b := v6.As16()
+	var v4 [4]byte
+	j := bits / 8
+	for i := range v4 {
+		if j == 8 {
+			j++
+		}
+		v4[i] = b[j]
+		j++
+	}
+	return netip.AddrFrom4(v4)
+}
+
+func validNAT64Prefix(prefix netip.Prefix) bool {
+	if !prefix.IsValid() || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return false
+	}
+	for _, bits := range nat64PrefixLens {
+		if prefix.Bits() == bits {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverNAT64 查询 ipv4only.arpa 的 AAAA 记录, 在每条记录中寻找知名地址得到前缀 (RFC 7050)
+func discoverNAT64(ctx context.Context, rt RoundTripper, server string) ([]netip.Prefix, error) {
+	resp, err := rt.RoundTrip(ctx, server, NewQuery("ipv4only.arpa", DNSTypeAAAA))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Release()
+	if resp.Header.Flags.RCode != DNSRCodeSuccess {
+		return nil, &RCodeError{RCode: resp.Header.Flags.RCode}
+	}
+	var prefixes []netip.Prefix
+	seen := map[netip.Prefix]bool{}
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeAAAA {
+			continue
+		}
+		addr, err := netip.ParseAddr(rr.RData)
+		if err != nil || !addr.Is6() {
+			continue
+		}
+		for _, bits := range nat64PrefixLens {
+			v4 := extractIPv4(bits, addr)
+			if v4 != nat64WKAs[0] && v4 != nat64WKAs[1] {
+				continue
+			}
+			p := netip.PrefixFrom(addr, bits).Masked()
+			if !seen[p] {
+				seen[p] = true
+				prefixes = append(prefixes, p)
+			}
+			break
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil, ErrNoNAT64
+	}
+	return prefixes, nil
+}
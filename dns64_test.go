@@ -0,0 +1,103 @@
+package netx
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestEmbedIPv4(t *testing.T) {
+	// RFC 6052 2.4 的示例
+	v4 := netip.MustParseAddr("192.0.2.33")
+	for prefix, want := range map[string]string{
+		"2001:db8::/32":          "2001:db8:c000:221::",
+		"2001:db8:100::/40":      "2001:db8:1c0:2:21::",
+		"2001:db8:122::/48":      "2001:db8:122:c000:2:2100::",
+		"2001:db8:122:300::/56":  "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64":  "2001:db8:122:344:c0:2:2100:0",
+		"2001:db8:122:344::/96":  "2001:db8:122:344::c000:221",
+		"64:ff9b::/96":           "64:ff9b::c000:221",
+		"2001:db8:122:344::1/96": "2001:db8:122:344::c000:221",
+	} {
+		p := netip.MustParsePrefix(prefix)
+		got, err := embedIPv4(p, v4)
+		if err != nil || got != netip.MustParseAddr(want) {
+			t.Fatalf("embedIPv4(%s) = %s, %v, want %s", prefix, got, err, want)
+		}
+		if back := extractIPv4(p.Bits(), got); back != v4 {
+			t.Fatalf("extractIPv4(%s) = %s", got, back)
+		}
+	}
+	for _, prefix := range []string{"2001:db8::/33", "192.0.2.0/24"} {
+		if _, err := embedIPv4(netip.MustParsePrefix(prefix), v4); err == nil {
+			t.Fatalf("embedIPv4(%s) accepted", prefix)
+		}
+	}
+}
+
+func TestDNS64(t *testing.T) {
+	zone := map[string][]*DNSResourceRecode{
+		"ipv4only.arpa": {
+			{Name: "ipv4only.arpa", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "64:ff9b::c000:aa"},
+			{Name: "ipv4only.arpa", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "64:ff9b::c000:ab"},
+		},
+		"v6.example": {{Name: "v6.example", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "2001:db8::1"}},
+		"www.example": {
+			{Name: "www.example", RRType: DNSTypeCName, Class: DNSClassIn, TTL: 60, RData: "v4.example"},
+			{Name: "v4.example", RRType: DNSTypeA, Class: DNSClassIn, TTL: 30, RData: "192.0.2.33"},
+		},
+	}
+	handler := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		for _, rr := range zone[q.QuestionName] {
+			if rr.RRType == q.QuestionType || rr.RRType == DNSTypeCName {
+				resp.ResourceRecodes = append(resp.ResourceRecodes, rr.Copy())
+			}
+		}
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+		return resp, nil
+	})
+	aaaa := func(r *Resolver, name string) []string {
+		resp, err := r.Query(context.Background(), name, DNSTypeAAAA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Release()
+		var addrs []string
+		for _, rr := range resp.Answers() {
+			if rr.RRType == DNSTypeAAAA {
+				addrs = append(addrs, rr.RData)
+			}
+		}
+		return addrs
+	}
+
+	udpAddr, _ := startDNSServer(t, &DNSServer{Handler: handler})
+	client := &Resolver{Server: udpAddr, Interceptors: []Interceptor{DNS64(netip.Prefix{})}}
+	if got := aaaa(client, "www.example"); len(got) != 1 || got[0] != "64:ff9b::c000:221" {
+		t.Fatalf("client www.example = %v", got)
+	}
+	if got := aaaa(client, "v6.example"); len(got) != 1 || got[0] != "2001:db8::1" {
+		t.Fatalf("client v6.example = %v", got)
+	}
+	if got := aaaa(client, "none.example"); len(got) != 0 {
+		t.Fatalf("client none.example = %v", got)
+	}
+
+	udpAddr, _ = startDNSServer(t, &DNSServer{Handler: handler, Middlewares: []ServerMiddleware{WithDNS64(netip.MustParsePrefix("2001:db8::/32"))}})
+	server := &Resolver{Server: udpAddr}
+	if got := aaaa(server, "www.example"); len(got) != 1 || got[0] != "2001:db8:c000:221::" {
+		t.Fatalf("server www.example = %v", got)
+	}
+	resp, err := server.Query(context.Background(), "www.example", DNSTypeAAAA, WithDNSSECOK(), WithCheckingDisabled())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Release()
+	for _, rr := range resp.Answers() {
+		if rr.RRType == DNSTypeAAAA {
+			t.Fatalf("synthesized for DO+CD query: %+v", rr)
+		}
+	}
+}
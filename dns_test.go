@@ -1,7 +1,12 @@
 package netx
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"github.com/pkg/errors"
+	"strings"
 	"testing"
 )
 
@@ -11,3 +16,111 @@ func TestLookUp(t *testing.T) {
 		return
 	}
 }
+
+func benchMessage() *DNSMessage {
+	return &DNSMessage{
+		Header: &DNSHeader{TxID: 0x1234, Flags: &DNSFlags{QR: 1, RD: 1, RA: 1}, Questions: 1, AnswerRRs: 4, AuthorityRRs: 1},
+		Questions: []*DNSQuestion{
+			{QuestionName: "www.example.com", QuestionType: DNSTypeA, QuestionClass: DNSClassIn},
+		},
+		ResourceRecodes: []*DNSResourceRecode{
+			{NamePos: 12, RRType: DNSTypeCName, Class: DNSClassIn, TTL: 300, RData: "web.example.com"},
+			{Name: "web.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"},
+			{Name: "web.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.2"},
+			{Name: "web.example.com", RRType: DNSTypeMX, Class: DNSClassIn, TTL: 60, RData: "10 mail.example.com"},
+			{Name: "example.com", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 3600, RData: "ns1.example.com hostmaster.example.com 1 7200 900 1209600 300"},
+		},
+	}
+}
+
+// reflectToByte 按 binary.Write 逐字段编码, 用作对照
+func reflectToByte(d *DNSMessage) []byte {
+	var buffer bytes.Buffer
+	h := d.Header
+	for _, u := range [6]uint16{h.TxID, h.Flags.ToBit(), h.Questions, h.AnswerRRs, h.AuthorityRRs, h.AdditionalRRs} {
+		_ = binary.Write(&buffer, binary.BigEndian, u)
+	}
+	writeName := func(name string) {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			buffer.WriteByte(byte(len(label)))
+			buffer.WriteString(label)
+		}
+		buffer.WriteByte(0)
+	}
+	for _, q := range d.Questions {
+		writeName(q.QuestionName)
+		_ = binary.Write(&buffer, binary.BigEndian, q.QuestionType)
+		_ = binary.Write(&buffer, binary.BigEndian, q.QuestionClass)
+	}
+	for _, r := range d.ResourceRecodes {
+		if r.NamePos > 0 {
+			_ = binary.Write(&buffer, binary.BigEndian, 0xC000|r.NamePos)
+		} else {
+			writeName(r.Name)
+		}
+		_ = binary.Write(&buffer, binary.BigEndian, r.RRType)
+		_ = binary.Write(&buffer, binary.BigEndian, r.Class)
+		_ = binary.Write(&buffer, binary.BigEndian, r.TTL)
+		rdata, _ := r.packRData()
+		_ = binary.Write(&buffer, binary.BigEndian, uint16(len(rdata)))
+		buffer.Write(rdata)
+	}
+	return buffer.Bytes()
+}
+
+func TestDNSMessageToByte(t *testing.T) {
+	msg := benchMessage()
+	b, err := msg.ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := reflectToByte(msg); !bytes.Equal(b, want) {
+		t.Fatalf("ToByte = %x, want %x", b, want)
+	}
+	decoded, err := Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Answers()[3].RData; got != "10 mail.example.com" {
+		t.Fatalf("MX = %q", got)
+	}
+
+	for name, want := range map[string]error{"a..b": ErrEmptyLabel, strings.Repeat("x", 64) + ".com": ErrLabelTooLong,
+		strings.Repeat("abcdefghi.", 26) + "com": ErrNameTooLong, `a\1.b`: ErrBadEscape} {
+		if _, err := appendName(nil, name); errors.Cause(err) != want {
+			t.Errorf("appendName(%q) error = %v, want %v", name, err, want)
+		}
+	}
+	for name, want := range map[string]string{"": "00", ".": "00", "a.b.": "0161016200", `a\.b.c`: "03612e62016300", `\065`: "014100"} {
+		b, err := appendName(nil, name)
+		if err != nil || hex.EncodeToString(b) != want {
+			t.Errorf("appendName(%q) = %x, %v, want %s", name, b, err, want)
+		}
+	}
+}
+
+func BenchmarkDNSMessageToByte(b *testing.B) {
+	msg := benchMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.ToByte(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDNSMessageBinaryWrite(b *testing.B) {
+	msg := benchMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reflectToByte(msg)
+	}
+}
+
+func BenchmarkDNSHeaderToByte(b *testing.B) {
+	h := benchMessage().Header
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = h.ToByte()
+	}
+}
@@ -0,0 +1,80 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrDNSBLRefused  = errors.New("dnsbl refused query")
+	ErrDNSBLResponse = errors.New("unexpected dnsbl response")
+)
+
+// DNSBLResult 一个黑名单区域的查询结果
+type DNSBLResult struct {
+	Zone   string
+	Listed bool
+	Codes  []net.IP // 127.0.0.x 返回码, 含义由各区域定义
+	Reason string   // TXT 记录, 只在列入时查询
+	Err    error    // 查询失败或区域拒绝查询时不为空, 此时 Listed 无意义
+}
+
+// dnsblName 返回 ip 在 zone 中的查询名, ipv4 为倒序的四段, ipv6 为倒序的半字节
+func dnsblName(ip net.IP, zone string) string {
+	name := ReverseName(ip)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".in-addr.arpa"), ".ip6.arpa")
+	return name + "." + strings.TrimSuffix(zone, ".")
+}
+
+// CheckDNSBL 并发查询 ip 在各个黑名单区域中的状态, 结果与 zones 顺序相同.
+// 应答在 127.0.0.0/8 内视为列入, NXDOMAIN 视为未列入, 127.255.255.0/24 表示区域拒绝查询 (如经公共递归服务器)
+func (r *Resolver) CheckDNSBL(ctx context.Context, ip net.IP, zones []string) []*DNSBLResult {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	results := make([]*DNSBLResult, len(zones))
+	var wg sync.WaitGroup
+	for i, zone := range zones {
+		wg.Add(1)
+		go func(i int, zone string) {
+			defer wg.Done()
+			results[i] = r.checkDNSBL(ctx, ip, zone)
+		}(i, zone)
+	}
+	wg.Wait()
+	return results
+}
+
+func (r *Resolver) checkDNSBL(ctx context.Context, ip net.IP, zone string) *DNSBLResult {
+	result := &DNSBLResult{Zone: zone}
+	name := dnsblName(ip, zone)
+	addrs, err := r.lookup(ctx, name, DNSTypeA)
+	if err != nil {
+		if !isNXDomain(err) {
+			result.Err = err
+		}
+		return result
+	}
+	for _, addr := range addrs {
+		code := net.ParseIP(addr).To4()
+		if code == nil || code[0] != 127 {
+			result.Err = errors.WithMessage(ErrDNSBLResponse, addr)
+			return result
+		}
+		if code[1] == 255 && code[2] == 255 {
+			result.Err = errors.WithMessage(ErrDNSBLRefused, addr)
+			return result
+		}
+		result.Codes = append(result.Codes, code)
+	}
+	result.Listed = len(result.Codes) > 0
+	if result.Listed {
+		if txts, err := r.LookupTXT(ctx, name); err == nil {
+			result.Reason = strings.Join(txts, "; ")
+		}
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestCheckDNSBL(t *testing.T) {
+	r := zoneResolver(map[string][]*DNSResourceRecode{
+		"2.0.0.127.bl.example.com": {
+			{RRType: DNSTypeA, Class: DNSClassIn, RData: "127.0.0.2"},
+			txtRR("listed for spam"),
+		},
+		"2.0.0.127.refuse.example.com": {{RRType: DNSTypeA, Class: DNSClassIn, RData: "127.255.255.254"}},
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl.example.com": {
+			{RRType: DNSTypeA, Class: DNSClassIn, RData: "127.0.0.4"},
+		},
+	})
+	results := r.CheckDNSBL(context.Background(), net.ParseIP("127.0.0.2"), []string{"bl.example.com", "clean.example.com", "refuse.example.com"})
+	if len(results) != 3 {
+		t.Fatalf("results = %d", len(results))
+	}
+	if !results[0].Listed || results[0].Reason != "listed for spam" || !results[0].Codes[0].Equal(net.ParseIP("127.0.0.2")) {
+		t.Fatalf("bl = %+v", results[0])
+	}
+	if results[1].Listed || results[1].Err != nil {
+		t.Fatalf("clean = %+v", results[1])
+	}
+	if results[2].Err == nil {
+		t.Fatalf("refuse = %+v", results[2])
+	}
+	results = r.CheckDNSBL(context.Background(), net.ParseIP("2001:db8::1"), []string{"bl.example.com."})
+	if !results[0].Listed {
+		t.Fatalf("ipv6 = %+v", results[0])
+	}
+}
@@ -0,0 +1,279 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// IANA 登记的记录类型, 查询类型, 类别, 操作码与响应码. 字段类型均为 uint16, 需要助记符时转换为 DNSType 等类型后调用 String
+
+// 记录类型与查询类型
+const (
+	DNSTypeA          = 1
+	DNSTypeNS         = 2
+	DNSTypeMD         = 3
+	DNSTypeMF         = 4
+	DNSTypeCName      = 5
+	DNSTypeSOA        = 6
+	DNSTypeMB         = 7
+	DNSTypeMG         = 8
+	DNSTypeMR         = 9
+	DNSTypeNULL       = 10
+	DNSTypeWKS        = 11
+	DNSTypePTR        = 12
+	DNSTypeHINFO      = 13
+	DNSTypeMINFO      = 14
+	DNSTypeMX         = 15
+	DNSTypeTXT        = 16
+	DNSTypeRP         = 17
+	DNSTypeAFSDB      = 18
+	DNSTypeX25        = 19
+	DNSTypeISDN       = 20
+	DNSTypeRT         = 21
+	DNSTypeNSAP       = 22
+	DNSTypeNSAPPTR    = 23
+	DNSTypeSIG        = 24
+	DNSTypeKEY        = 25
+	DNSTypePX         = 26
+	DNSTypeGPOS       = 27
+	DNSTypeAAAA       = 28 // IPV6
+	DNSTypeLOC        = 29
+	DNSTypeNXT        = 30
+	DNSTypeEID        = 31
+	DNSTypeNIMLOC     = 32
+	DNSTypeSRV        = 33 // RFC 2782
+	DNSTypeATMA       = 34
+	DNSTypeNAPTR      = 35
+	DNSTypeKX         = 36
+	DNSTypeCERT       = 37
+	DNSTypeA6         = 38
+	DNSTypeDNAME      = 39
+	DNSTypeSINK       = 40
+	DNSTypeOPT        = 41 // EDNS, RFC 6891
+	DNSTypeAPL        = 42
+	DNSTypeDS         = 43
+	DNSTypeSSHFP      = 44
+	DNSTypeIPSECKEY   = 45
+	DNSTypeRRSIG      = 46
+	DNSTypeNSEC       = 47
+	DNSTypeDNSKEY     = 48
+	DNSTypeDHCID      = 49
+	DNSTypeNSEC3      = 50
+	DNSTypeNSEC3PARAM = 51
+	DNSTypeTLSA       = 52 // RFC 6698
+	DNSTypeSMIMEA     = 53
+	DNSTypeHIP        = 55
+	DNSTypeNINFO      = 56
+	DNSTypeRKEY       = 57
+	DNSTypeTALINK     = 58
+	DNSTypeCDS        = 59
+	DNSTypeCDNSKEY    = 60
+	DNSTypeOPENPGPKEY = 61
+	DNSTypeCSYNC      = 62
+	DNSTypeZONEMD     = 63
+	DNSTypeSVCB       = 64
+	DNSTypeHTTPS      = 65
+	DNSTypeSPF        = 99
+	DNSTypeUINFO      = 100
+	DNSTypeUID        = 101
+	DNSTypeGID        = 102
+	DNSTypeUNSPEC     = 103
+	DNSTypeNID        = 104
+	DNSTypeL32        = 105
+	DNSTypeL64        = 106
+	DNSTypeLP         = 107
+	DNSTypeEUI48      = 108
+	DNSTypeEUI64      = 109
+	DNSTypeTKEY       = 249
+	DNSTypeTSIG       = 250
+	DNSTypeIXFR       = 251
+	DNSTypeAXFR       = 252
+	DNSTypeMAILB      = 253
+	DNSTypeMAILA      = 254
+	DNSTypeANY        = 255 // 查询时也写作 *
+	DNSTypeURI        = 256
+	DNSTypeCAA        = 257
+	DNSTypeAVC        = 258
+	DNSTypeDOA        = 259
+	DNSTypeAMTRELAY   = 260
+	DNSTypeTA         = 32768
+	DNSTypeDLV        = 32769
+)
+
+var dnsTypeNames = map[uint16]string{
+	DNSTypeA: "A", DNSTypeNS: "NS", DNSTypeMD: "MD", DNSTypeMF: "MF", DNSTypeCName: "CNAME",
+	DNSTypeSOA: "SOA", DNSTypeMB: "MB", DNSTypeMG: "MG", DNSTypeMR: "MR", DNSTypeNULL: "NULL",
+	DNSTypeWKS: "WKS", DNSTypePTR: "PTR", DNSTypeHINFO: "HINFO", DNSTypeMINFO: "MINFO", DNSTypeMX: "MX",
+	DNSTypeTXT: "TXT", DNSTypeRP: "RP", DNSTypeAFSDB: "AFSDB", DNSTypeX25: "X25", DNSTypeISDN: "ISDN",
+	DNSTypeRT: "RT", DNSTypeNSAP: "NSAP", DNSTypeNSAPPTR: "NSAP-PTR", DNSTypeSIG: "SIG", DNSTypeKEY: "KEY",
+	DNSTypePX: "PX", DNSTypeGPOS: "GPOS", DNSTypeAAAA: "AAAA", DNSTypeLOC: "LOC", DNSTypeNXT: "NXT",
+	DNSTypeEID: "EID", DNSTypeNIMLOC: "NIMLOC", DNSTypeSRV: "SRV", DNSTypeATMA: "ATMA", DNSTypeNAPTR: "NAPTR",
+	DNSTypeKX: "KX", DNSTypeCERT: "CERT", DNSTypeA6: "A6", DNSTypeDNAME: "DNAME", DNSTypeSINK: "SINK",
+	DNSTypeOPT: "OPT", DNSTypeAPL: "APL", DNSTypeDS: "DS", DNSTypeSSHFP: "SSHFP", DNSTypeIPSECKEY: "IPSECKEY",
+	DNSTypeRRSIG: "RRSIG", DNSTypeNSEC: "NSEC", DNSTypeDNSKEY: "DNSKEY", DNSTypeDHCID: "DHCID", DNSTypeNSEC3: "NSEC3",
+	DNSTypeNSEC3PARAM: "NSEC3PARAM", DNSTypeTLSA: "TLSA", DNSTypeSMIMEA: "SMIMEA", DNSTypeHIP: "HIP", DNSTypeNINFO: "NINFO",
+	DNSTypeRKEY: "RKEY", DNSTypeTALINK: "TALINK", DNSTypeCDS: "CDS", DNSTypeCDNSKEY: "CDNSKEY", DNSTypeOPENPGPKEY: "OPENPGPKEY",
+	DNSTypeCSYNC: "CSYNC", DNSTypeZONEMD: "ZONEMD", DNSTypeSVCB: "SVCB", DNSTypeHTTPS: "HTTPS", DNSTypeSPF: "SPF",
+	DNSTypeUINFO: "UINFO", DNSTypeUID: "UID", DNSTypeGID: "GID", DNSTypeUNSPEC: "UNSPEC", DNSTypeNID: "NID",
+	DNSTypeL32: "L32", DNSTypeL64: "L64", DNSTypeLP: "LP", DNSTypeEUI48: "EUI48", DNSTypeEUI64: "EUI64",
+	DNSTypeTKEY: "TKEY", DNSTypeTSIG: "TSIG", DNSTypeIXFR: "IXFR", DNSTypeAXFR: "AXFR", DNSTypeMAILB: "MAILB",
+	DNSTypeMAILA: "MAILA", DNSTypeANY: "ANY", DNSTypeURI: "URI", DNSTypeCAA: "CAA", DNSTypeAVC: "AVC",
+	DNSTypeDOA: "DOA", DNSTypeAMTRELAY: "AMTRELAY", DNSTypeTA: "TA", DNSTypeDLV: "DLV",
+}
+
+// 类别
+const (
+	DNSClassIn     = 1
+	DNSClassCSNET  = 2
+	DNSClassChaos  = 3
+	DNSClassHesiod = 4
+	DNSClassNone   = 254 // RFC 2136 动态更新
+	DNSClassAny    = 255
+)
+
+var dnsClassNames = map[uint16]string{
+	DNSClassIn: "IN", DNSClassCSNET: "CS", DNSClassChaos: "CH", DNSClassHesiod: "HS", DNSClassNone: "NONE", DNSClassAny: "ANY",
+}
+
+// 操作码
+const (
+	DNSOpCodeQuery  = 0
+	DNSOpCodeIQuery = 1 // 已废弃, RFC 3425
+	DNSOpCodeStatus = 2
+	DNSOpCodeNotify = 4 // RFC 1996
+	DNSOpCodeUpdate = 5 // RFC 2136
+	DNSOpCodeDSO    = 6 // RFC 8490
+)
+
+var dnsOpCodeNames = map[uint16]string{
+	DNSOpCodeQuery: "QUERY", DNSOpCodeIQuery: "IQUERY", DNSOpCodeStatus: "STATUS",
+	DNSOpCodeNotify: "NOTIFY", DNSOpCodeUpdate: "UPDATE", DNSOpCodeDSO: "DSO",
+}
+
+// 响应码, 大于 15 的扩展响应码只能通过 EDNS 或 TSIG 传递
+const (
+	DNSRCodeSuccess   = 0
+	DNSRCodeFormErr   = 1
+	DNSRCodeServFail  = 2
+	DNSRCodeNXDomain  = 3
+	DNSRCodeNotImp    = 4
+	DNSRCodeRefused   = 5
+	DNSRCodeYXDomain  = 6
+	DNSRCodeYXRRSet   = 7
+	DNSRCodeNXRRSet   = 8
+	DNSRCodeNotAuth   = 9
+	DNSRCodeNotZone   = 10
+	DNSRCodeDSOTypeNI = 11
+	DNSRCodeBadVers   = 16 // TSIG 中为 BADSIG
+	DNSRCodeBadKey    = 17
+	DNSRCodeBadTime   = 18
+	DNSRCodeBadMode   = 19
+	DNSRCodeBadName   = 20
+	DNSRCodeBadAlg    = 21
+	DNSRCodeBadTrunc  = 22
+	DNSRCodeBadCookie = 23
+)
+
+var dnsRCodeNames = map[uint16]string{
+	DNSRCodeSuccess: "NOERROR", DNSRCodeFormErr: "FORMERR", DNSRCodeServFail: "SERVFAIL", DNSRCodeNXDomain: "NXDOMAIN",
+	DNSRCodeNotImp: "NOTIMP", DNSRCodeRefused: "REFUSED", DNSRCodeYXDomain: "YXDOMAIN", DNSRCodeYXRRSet: "YXRRSET",
+	DNSRCodeNXRRSet: "NXRRSET", DNSRCodeNotAuth: "NOTAUTH", DNSRCodeNotZone: "NOTZONE", DNSRCodeDSOTypeNI: "DSOTYPENI",
+	DNSRCodeBadVers: "BADVERS", DNSRCodeBadKey: "BADKEY", DNSRCodeBadTime: "BADTIME", DNSRCodeBadMode: "BADMODE",
+	DNSRCodeBadName: "BADNAME", DNSRCodeBadAlg: "BADALG", DNSRCodeBadTrunc: "BADTRUNC", DNSRCodeBadCookie: "BADCOOKIE",
+}
+
+var ErrUnknownMnemonic = errors.New("unknown mnemonic")
+
+// DNSType 记录类型, 用于与助记符互相转换
+type DNSType uint16
+
+// String 返回助记符, 未登记的类型按 RFC 3597 写作 TYPEn
+func (t DNSType) String() string {
+	return mnemonic(uint16(t), dnsTypeNames, "TYPE")
+}
+
+// ParseType 解析类型助记符或 TYPEn, 不区分大小写, "*" 表示 ANY
+func ParseType(s string) (DNSType, error) {
+	if s == "*" {
+		return DNSTypeANY, nil
+	}
+	v, err := parseMnemonic(s, dnsTypeNames, "TYPE")
+	if err != nil {
+		return 0, errors.WithMessage(ErrUnknownType, s)
+	}
+	return DNSType(v), nil
+}
+
+// DNSClass 类别
+type DNSClass uint16
+
+// String 返回助记符, 未登记的类别写作 CLASSn
+func (c DNSClass) String() string {
+	return mnemonic(uint16(c), dnsClassNames, "CLASS")
+}
+
+// ParseClass 解析类别助记符或 CLASSn, 也接受 CHAOS 与 HESIOD
+func ParseClass(s string) (DNSClass, error) {
+	switch strings.ToUpper(s) {
+	case "CHAOS":
+		return DNSClassChaos, nil
+	case "HESIOD":
+		return DNSClassHesiod, nil
+	}
+	v, err := parseMnemonic(s, dnsClassNames, "CLASS")
+	return DNSClass(v), err
+}
+
+// DNSOpCode 操作码
+type DNSOpCode uint16
+
+// String 返回助记符, 未登记的操作码写作 OPCODEn
+func (o DNSOpCode) String() string {
+	return mnemonic(uint16(o), dnsOpCodeNames, "OPCODE")
+}
+
+// ParseOpCode 解析操作码助记符或 OPCODEn
+func ParseOpCode(s string) (DNSOpCode, error) {
+	v, err := parseMnemonic(s, dnsOpCodeNames, "OPCODE")
+	return DNSOpCode(v), err
+}
+
+// DNSRCode 响应码
+type DNSRCode uint16
+
+// String 返回助记符, 未登记的响应码写作 RCODEn
+func (r DNSRCode) String() string {
+	return mnemonic(uint16(r), dnsRCodeNames, "RCODE")
+}
+
+// ParseRCode 解析响应码助记符或 RCODEn, BADSIG 与 BADVERS 相同
+func ParseRCode(s string) (DNSRCode, error) {
+	if strings.EqualFold(s, "BADSIG") {
+		return DNSRCodeBadVers, nil
+	}
+	v, err := parseMnemonic(s, dnsRCodeNames, "RCODE")
+	return DNSRCode(v), err
+}
+
+func mnemonic(v uint16, names map[uint16]string, prefix string) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return prefix + strconv.Itoa(int(v))
+}
+
+func parseMnemonic(s string, names map[uint16]string, prefix string) (uint16, error) {
+	upper := strings.ToUpper(s)
+	for v, name := range names {
+		if name == upper {
+			return v, nil
+		}
+	}
+	if strings.HasPrefix(upper, prefix) {
+		if v, err := strconv.ParseUint(upper[len(prefix):], 10, 16); err == nil {
+			return uint16(v), nil
+		}
+	}
+	return 0, errors.WithMessage(ErrUnknownMnemonic, s)
+}
@@ -0,0 +1,57 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestMnemonics(t *testing.T) {
+	for _, table := range []map[uint16]string{dnsTypeNames, dnsClassNames, dnsOpCodeNames, dnsRCodeNames} {
+		seen := map[string]bool{}
+		for _, name := range table {
+			if seen[name] {
+				t.Fatalf("duplicate mnemonic %s", name)
+			}
+			seen[name] = true
+		}
+	}
+	for v := range dnsTypeNames {
+		if got, err := ParseType(DNSType(v).String()); err != nil || uint16(got) != v {
+			t.Fatalf("ParseType(%s) = %d, %v", DNSType(v), got, err)
+		}
+	}
+	if got := DNSType(DNSTypeHTTPS).String(); got != "HTTPS" {
+		t.Fatalf("HTTPS = %s", got)
+	}
+	if got := DNSType(65280).String(); got != "TYPE65280" {
+		t.Fatalf("unknown type = %s", got)
+	}
+	for s, want := range map[string]DNSType{"any": DNSTypeANY, "*": DNSTypeANY, "TYPE65": DNSTypeHTTPS, "nsap-ptr": DNSTypeNSAPPTR, "type1234": 1234} {
+		if got, err := ParseType(s); err != nil || got != want {
+			t.Errorf("ParseType(%q) = %d, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "TYPE", "TYPE65536", "BOGUS"} {
+		if _, err := ParseType(s); errors.Cause(err) != ErrUnknownType {
+			t.Errorf("ParseType(%q) error = %v", s, err)
+		}
+	}
+	if c, err := ParseClass("chaos"); err != nil || c != DNSClassChaos || c.String() != "CH" {
+		t.Fatalf("ParseClass(chaos) = %v, %v", c, err)
+	}
+	if c, err := ParseClass("CLASS42"); err != nil || c.String() != "CLASS42" {
+		t.Fatalf("ParseClass(CLASS42) = %v, %v", c, err)
+	}
+	if o, err := ParseOpCode("update"); err != nil || o != DNSOpCodeUpdate || DNSOpCode(3).String() != "OPCODE3" {
+		t.Fatalf("ParseOpCode(update) = %v, %v", o, err)
+	}
+	if r, err := ParseRCode("BADSIG"); err != nil || r.String() != "BADVERS" {
+		t.Fatalf("ParseRCode(BADSIG) = %v, %v", r, err)
+	}
+	if _, err := ParseRCode("NOPE"); errors.Cause(err) != ErrUnknownMnemonic {
+		t.Fatalf("ParseRCode(NOPE) error = %v", err)
+	}
+	if got := (&RCodeError{RCode: DNSRCodeNXDomain}).Error(); got != "dns response rcode: 3 (NXDOMAIN)" {
+		t.Fatalf("RCodeError = %s", got)
+	}
+}
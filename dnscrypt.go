@@ -0,0 +1,229 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/box"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDNSCryptCert     = errors.New("no valid dnscrypt certificate")
+	ErrDNSCryptResponse = errors.New("invalid dnscrypt response")
+)
+
+var (
+	dnscryptCertMagic     = []byte("DNSC")
+	dnscryptResolverMagic = []byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}
+)
+
+const (
+	dnscryptXSalsa20 = 0x0001 // es-version X25519-XSalsa20Poly1305
+	// dnscryptMinQuery udp 查询填充后的最小长度, 防止放大攻击
+	dnscryptMinQuery = 256
+	dnscryptPadBlock = 64
+)
+
+// DNSCryptTransport DNSCrypt v2 客户端 (X25519-XSalsa20Poly1305), server 为解析器的 ip:port.
+// 第一次查询时以明文 TXT 查询 ProviderName 取得证书并用 ProviderKey 验证签名, 过期前复用.
+// 每个查询使用新的临时密钥, udp 响应被截断时改用 tcp
+type DNSCryptTransport struct {
+	ProviderName string
+	ProviderKey  ed25519.PublicKey
+
+	mu    sync.Mutex
+	certs map[string]*dnscryptCert
+}
+
+// dnscryptCert 解析器证书中查询需要的部分
+type dnscryptCert struct {
+	resolverKey [32]byte
+	clientMagic [8]byte
+	serial      uint32
+	notAfter    time.Time
+}
+
+func (t *DNSCryptTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	cert, err := t.cert(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	query, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	public, secret, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var shared [32]byte
+	box.Precompute(&shared, &cert.resolverKey, secret)
+
+	resp, err := t.exchange(ctx, "udp", server, cert, public, &shared, query)
+	if err == nil && resp.Header.Flags.TC == 1 {
+		resp, err = t.exchange(ctx, "tcp", server, cert, public, &shared, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.TxID != req.Header.TxID {
+		return nil, ErrTxIDMismatch
+	}
+	return resp, nil
+}
+
+// exchange 加密 query 并发送, 解密响应
+func (t *DNSCryptTransport) exchange(ctx context.Context, network, server string, cert *dnscryptCert, public, shared *[32]byte, query []byte) (*DNSMessage, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:12]); err != nil {
+		return nil, err
+	}
+	minLen := dnscryptMinQuery
+	if network == "tcp" {
+		minLen = 0
+	}
+	packet := append(append(append([]byte{}, cert.clientMagic[:]...), public[:]...), nonce[:12]...)
+	packet = box.SealAfterPrecomputation(packet, dnscryptPad(query, minLen), &nonce, shared)
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithMessage(err, "set deadline error")
+		}
+	}
+	var raw []byte
+	if network == "tcp" {
+		if _, err := conn.Write(append([]byte{byte(len(packet) >> 8), byte(len(packet))}, packet...)); err != nil {
+			return nil, errors.WithMessage(err, "write error")
+		}
+		size := make([]byte, 2)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return nil, errors.WithMessage(err, "read error")
+		}
+		raw = make([]byte, binary.BigEndian.Uint16(size))
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			return nil, errors.WithMessage(err, "read error")
+		}
+	} else {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, errors.WithMessage(err, "write error")
+		}
+		raw = make([]byte, 0xFFFF)
+		n, err := conn.Read(raw)
+		if err != nil {
+			return nil, errors.WithMessage(err, "read error")
+		}
+		raw = raw[:n]
+	}
+
+	if len(raw) < 8+24+box.Overhead || !bytes.Equal(raw[:8], dnscryptResolverMagic) || !bytes.Equal(raw[8:20], nonce[:12]) {
+		return nil, ErrDNSCryptResponse
+	}
+	copy(nonce[:], raw[8:32])
+	plain, ok := box.OpenAfterPrecomputation(nil, raw[32:], &nonce, shared)
+	if !ok {
+		return nil, errors.WithMessage(ErrDNSCryptResponse, "decryption failed")
+	}
+	if plain, ok = dnscryptUnpad(plain); !ok {
+		return nil, errors.WithMessage(ErrDNSCryptResponse, "bad padding")
+	}
+	return Unpack(plain)
+}
+
+// cert 返回 server 当前有效的证书, 没有或已过期时重新获取
+func (t *DNSCryptTransport) cert(ctx context.Context, server string) (*dnscryptCert, error) {
+	t.mu.Lock()
+	cert := t.certs[server]
+	t.mu.Unlock()
+	if cert != nil && time.Now().Before(cert.notAfter) {
+		return cert, nil
+	}
+	resp, err := UDPTransport{}.RoundTrip(ctx, server, NewQuery(t.ProviderName, DNSTypeTXT))
+	if err != nil {
+		return nil, errors.WithMessage(err, "fetch certificate")
+	}
+	defer resp.Release()
+	now := time.Now()
+	cert = nil
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeTXT || rr.Data == nil {
+			continue
+		}
+		txt, err := parseTXT(rr.Data)
+		if err != nil {
+			continue
+		}
+		c, err := parseDNSCryptCert([]byte(txt), t.ProviderKey, now)
+		if err == nil && (cert == nil || c.serial > cert.serial) {
+			cert = c
+		}
+	}
+	if cert == nil {
+		return nil, ErrDNSCryptCert
+	}
+	t.mu.Lock()
+	if t.certs == nil {
+		t.certs = map[string]*dnscryptCert{}
+	}
+	t.certs[server] = cert
+	t.mu.Unlock()
+	return cert, nil
+}
+
+// parseDNSCryptCert 解析并验证证书:
+// magic(4) es-version(2) minor(2) signature(64) resolver-pk(32) client-magic(8) serial(4) ts-start(4) ts-end(4) extensions
+func parseDNSCryptCert(b []byte, providerKey ed25519.PublicKey, now time.Time) (*dnscryptCert, error) {
+	if len(b) < 124 || !bytes.Equal(b[:4], dnscryptCertMagic) {
+		return nil, ErrDNSCryptCert
+	}
+	if binary.BigEndian.Uint16(b[4:]) != dnscryptXSalsa20 {
+		return nil, errors.WithMessage(ErrDNSCryptCert, "unsupported es-version")
+	}
+	if len(providerKey) != ed25519.PublicKeySize || !ed25519.Verify(providerKey, b[72:], b[8:72]) {
+		return nil, errors.WithMessage(ErrDNSCryptCert, "bad signature")
+	}
+	c := &dnscryptCert{serial: binary.BigEndian.Uint32(b[112:])}
+	copy(c.resolverKey[:], b[72:104])
+	copy(c.clientMagic[:], b[104:112])
+	notBefore := time.Unix(int64(binary.BigEndian.Uint32(b[116:])), 0)
+	c.notAfter = time.Unix(int64(binary.BigEndian.Uint32(b[120:])), 0)
+	if now.Before(notBefore) || !now.Before(c.notAfter) {
+		return nil, errors.WithMessage(ErrDNSCryptCert, "expired")
+	}
+	return c, nil
+}
+
+// dnscryptPad 在末尾加 0x80 与若干 0, 使长度为 64 的倍数且不小于 minLen
+func dnscryptPad(b []byte, minLen int) []byte {
+	n := len(b) + 1
+	if n < minLen {
+		n = minLen
+	}
+	n = (n + dnscryptPadBlock - 1) / dnscryptPadBlock * dnscryptPadBlock
+	padded := make([]byte, n)
+	copy(padded, b)
+	padded[len(b)] = 0x80
+	return padded
+}
+
+func dnscryptUnpad(b []byte) ([]byte, bool) {
+	i := len(b) - 1
+	for i >= 0 && b[i] == 0 {
+		i--
+	}
+	if i < 0 || b[i] != 0x80 {
+		return nil, false
+	}
+	return b[:i], true
+}
@@ -0,0 +1,146 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/box"
+	"io"
+	"testing"
+	"time"
+)
+
+// dnscryptServer 测试用的 DNSCrypt 服务器, 在同一端口上提供 udp 与 tcp
+type dnscryptServer struct {
+	providerKey ed25519.PublicKey
+	cert        []byte
+	secret      *[32]byte
+	magic       []byte
+}
+
+func startDNSCrypt(t *testing.T) (*dnscryptServer, string) {
+	providerPub, providerKey, _ := ed25519.GenerateKey(rand.Reader)
+	public, secret, _ := box.GenerateKey(rand.Reader)
+	s := &dnscryptServer{providerKey: providerPub, secret: secret, magic: []byte("cl13ntMg")}
+	signed := append(append([]byte{}, public[:]...), s.magic...)
+	signed = append(signed, be32(7)...)
+	signed = append(signed, be32(uint32(time.Now().Add(-time.Hour).Unix()))...)
+	signed = append(signed, be32(uint32(time.Now().Add(time.Hour).Unix()))...)
+	s.cert = append(append([]byte("DNSC\x00\x01\x00\x00"), ed25519.Sign(providerKey, signed)...), signed...)
+
+	conn, ln := listenSamePort(t)
+	go func() {
+		buf := make([]byte, 0xFFFF)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if out := s.handle(buf[:n], "udp"); out != nil {
+				_, _ = conn.WriteTo(out, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			size := make([]byte, 2)
+			if _, err := io.ReadFull(c, size); err == nil {
+				packet := make([]byte, binary.BigEndian.Uint16(size))
+				if _, err := io.ReadFull(c, packet); err == nil {
+					out := s.handle(packet, "tcp")
+					_, _ = c.Write(append([]byte{byte(len(out) >> 8), byte(len(out))}, out...))
+				}
+			}
+			_ = c.Close()
+		}
+	}()
+	return s, conn.LocalAddr().String()
+}
+
+func (s *dnscryptServer) handle(packet []byte, network string) []byte {
+	if !bytes.HasPrefix(packet, s.magic) {
+		// 明文的证书查询
+		req, err := Unpack(packet)
+		if err != nil {
+			return nil
+		}
+		resp := NewReply(req)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Questions[0].QuestionName, RRType: DNSTypeTXT, Class: DNSClassIn, TTL: 60, Data: append([]byte{byte(len(s.cert))}, s.cert...)}}
+		resp.Header.AnswerRRs = 1
+		b, _ := resp.ToByte()
+		return b
+	}
+	if network == "udp" && len(packet) < 8+32+12+dnscryptMinQuery {
+		return nil
+	}
+	var clientKey [32]byte
+	var nonce [24]byte
+	copy(clientKey[:], packet[8:40])
+	copy(nonce[:], packet[40:52])
+	plain, ok := box.Open(nil, packet[52:], &nonce, &clientKey, s.secret)
+	if !ok {
+		return nil
+	}
+	plain, _ = dnscryptUnpad(plain)
+	req, err := Unpack(plain)
+	if err != nil {
+		return nil
+	}
+	resp := NewReply(req)
+	if req.Questions[0].QuestionName == "big.example.com" && network == "udp" {
+		resp.Header.Flags.TC = 1
+	} else {
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.44"}}
+		resp.Header.AnswerRRs = 1
+	}
+	b, _ := resp.ToByte()
+	_, _ = rand.Read(nonce[12:])
+	out := append(append([]byte{}, dnscryptResolverMagic...), nonce[:]...)
+	return box.Seal(out, dnscryptPad(b, 0), &nonce, &clientKey, s.secret)
+}
+
+func TestDNSCrypt(t *testing.T) {
+	s, addr := startDNSCrypt(t)
+	st := &ServerStamp{Protocol: StampDNSCrypt, Addr: addr, PublicKey: s.providerKey, ProviderName: "2.dnscrypt-cert.example.com"}
+	r, err := st.Resolver(nil)
+	if err != nil || r.Server != addr {
+		t.Fatalf("resolver = %+v, %v", r, err)
+	}
+	for _, name := range []string{"www.example.com", "big.example.com"} {
+		ips, err := r.LookupHost(context.Background(), name)
+		if err != nil || len(ips) != 1 || ips[0] != "192.0.2.44" {
+			t.Fatalf("%s = %v, %v", name, ips, err)
+		}
+	}
+	if cert := r.Transport.(*DNSCryptTransport).certs[addr]; cert == nil || cert.serial != 7 {
+		t.Fatalf("cert = %+v", cert)
+	}
+
+	// 提供者公钥不匹配时拒绝证书
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	r.Transport = &DNSCryptTransport{ProviderName: st.ProviderName, ProviderKey: other}
+	if _, err := r.LookupHost(context.Background(), "www.example.com"); !errors.Is(err, ErrDNSCryptCert) {
+		t.Fatalf("bad key = %v", err)
+	}
+
+	if p := dnscryptPad(make([]byte, 10), dnscryptMinQuery); len(p) != 256 || p[10] != 0x80 {
+		t.Fatalf("pad = %d", len(p))
+	}
+	if p := dnscryptPad(make([]byte, 63), 0); len(p) != 64 {
+		t.Fatalf("pad = %d", len(p))
+	}
+	if _, ok := dnscryptUnpad([]byte{1, 2, 0, 0}); ok {
+		t.Fatal("unpad without 0x80")
+	}
+}
+
+func be32(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
@@ -0,0 +1,144 @@
+package netx
+
+import (
+	"context"
+	"github.com/moyrne/netx/ipx"
+	"net/netip"
+	"strings"
+)
+
+// ZoneMux 按问题名字最长匹配的区域选择处理器, 没有匹配时应答 REFUSED. 注册完成后可以并发使用
+type ZoneMux struct {
+	zones map[string]DNSHandler
+}
+
+// Handle 注册 zone 及其下名字的处理器, "" 或 "." 表示根区域, 即默认处理器
+func (m *ZoneMux) Handle(zone string, h DNSHandler) {
+	if m.zones == nil {
+		m.zones = map[string]DNSHandler{}
+	}
+	m.zones[normalizeDomain(zone)] = h
+}
+
+// Match 返回 name 最长匹配的区域与处理器
+func (m *ZoneMux) Match(name string) (string, DNSHandler) {
+	for n := normalizeDomain(name); ; n = parentName(n) {
+		if h, ok := m.zones[n]; ok {
+			return n, h
+		}
+		if n == "" {
+			return "", nil
+		}
+	}
+}
+
+func (m *ZoneMux) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	if len(req.Message.Questions) == 1 {
+		if _, h := m.Match(req.Message.Questions[0].QuestionName); h != nil {
+			return h.ServeDNS(ctx, req)
+		}
+	}
+	resp := NewReply(req.Message)
+	resp.Header.Flags.RCode = DNSRCodeRefused
+	return resp, nil
+}
+
+// ForwardHandler 将请求依次转发给 resolvers, 直到有一个成功. 请求与响应原样转发,
+// 包括 OPT 记录及其中的选项, DO, CD 与 AD 位, 因此不会破坏客户端自己的 DNSSEC 验证.
+// 客户端地址通过 WithClientAddr 传给 Transport, 见 WithProxyHeader
+func ForwardHandler(resolvers ...*Resolver) DNSHandler {
+	return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		if req.RemoteAddr != nil {
+			ctx = WithClientAddr(ctx, req.RemoteAddr)
+		}
+		err := ErrNoAddress
+		for _, r := range resolvers {
+			var resp *DNSMessage
+			if resp, err = r.Exchange(ctx, req.Message); err == nil {
+				return resp, nil
+			}
+		}
+		return nil, err
+	})
+}
+
+// View 按客户端地址选择的处理器, 用于 split-horizon
+type View struct {
+	Name     string
+	Networks []netip.Prefix
+	Handler  DNSHandler
+}
+
+// ViewHandler 按客户端地址最长匹配的网络选择视图, 没有匹配时使用 fallback, fallback 为空时应答 REFUSED
+func ViewHandler(views []*View, fallback DNSHandler) DNSHandler {
+	var table ipx.Table[*View]
+	for _, v := range views {
+		for _, p := range v.Networks {
+			table.Insert(p, v)
+		}
+	}
+	fallback = ChainHandler(fallback)
+	return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		if addr, ok := netip.AddrFromSlice(req.ClientIP()); ok {
+			if _, v, ok := table.Lookup(addr); ok {
+				return v.Handler.ServeDNS(ctx, req)
+			}
+		}
+		return fallback.ServeDNS(ctx, req)
+	})
+}
+
+// DomainBlocklist 域名黑名单, 名字本身及其所有子域名都被拦截. 构建完成后可以并发查询
+type DomainBlocklist struct {
+	domains map[string]bool
+}
+
+// NewDomainBlocklist 返回包含 domains 的黑名单
+func NewDomainBlocklist(domains ...string) *DomainBlocklist {
+	b := &DomainBlocklist{domains: map[string]bool{}}
+	for _, d := range domains {
+		b.Add(d)
+	}
+	return b
+}
+
+// Add 添加域名, 忽略空行与 # 开头的注释, "*." 前缀与域名本身等价
+func (b *DomainBlocklist) Add(domain string) {
+	domain = strings.TrimPrefix(normalizeDomain(strings.TrimSpace(domain)), "*.")
+	if domain == "" || strings.HasPrefix(domain, "#") {
+		return
+	}
+	if b.domains == nil {
+		b.domains = map[string]bool{}
+	}
+	b.domains[domain] = true
+}
+
+// Len 返回域名数量
+func (b *DomainBlocklist) Len() int {
+	return len(b.domains)
+}
+
+// Blocked name 或其任意上级域名是否在黑名单中
+func (b *DomainBlocklist) Blocked(name string) bool {
+	for n := normalizeDomain(name); n != ""; n = parentName(n) {
+		if b.domains[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// WithBlocklist 对黑名单中的名字应答 NXDOMAIN, b 可以是 *DomainBlocklist 或编译后的 *CompiledBlocklist, *AtomicBlocklist
+func WithBlocklist(b NameMatcher) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			if len(req.Message.Questions) > 0 && b.Blocked(req.Message.Questions[0].QuestionName) {
+				resp := NewReply(req.Message)
+				resp.Header.Flags.RCode = DNSRCodeNXDomain
+				return resp, nil
+			}
+			return next.ServeDNS(ctx, req)
+		})
+	}
+}
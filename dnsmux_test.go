@@ -0,0 +1,34 @@
+package netx
+
+import "testing"
+
+func TestZoneMux(t *testing.T) {
+	m := &ZoneMux{}
+	com := DNSHandlerFunc(nil)
+	m.Handle("example.com.", com)
+	m.Handle("sub.example.com", com)
+	for name, want := range map[string]string{
+		"www.example.com":    "example.com",
+		"a.sub.example.com.": "sub.example.com",
+		"Sub.Example.COM":    "sub.example.com",
+		"notexample.com":     "",
+		"example.org":        "",
+	} {
+		if zone, _ := m.Match(name); zone != want {
+			t.Fatalf("Match(%s) = %q, want %q", name, zone, want)
+		}
+	}
+
+	b := NewDomainBlocklist("ads.example.com", "*.track.example.net.")
+	for name, want := range map[string]bool{
+		"ads.example.com":     true,
+		"x.ads.example.com":   true,
+		"badads.example.com":  false,
+		"a.track.example.net": true,
+		"example.com":         false,
+	} {
+		if got := b.Blocked(name); got != want {
+			t.Fatalf("Blocked(%s) = %v", name, got)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package netx
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+)
+
+// DNSSection 报文中的分区
+type DNSSection int
+
+const (
+	DNSSectionQuestion DNSSection = iota
+	DNSSectionAnswer
+	DNSSectionAuthority
+	DNSSectionAdditional
+)
+
+var (
+	ErrSectionDone = errors.New("no more entries")
+	ErrNoRecord    = errors.New("no record header has been read")
+)
+
+// DNSParser 按顺序逐条读取报文, 不构造完整的 DNSMessage. 可以跳过整个分区, 只读取记录头,
+// 或按需取出原始 RDATA 与完整记录, 适合转发与抓包分析. 不能并发使用
+//
+//	p, err := NewDNSParser(packet)
+//	for {
+//		h, err := p.NextRecordHeader()
+//		if err == ErrSectionDone {
+//			break
+//		}
+//		...
+//	}
+type DNSParser struct {
+	u       unpacker
+	header  DNSHeader
+	flags   DNSFlags
+	counts  [4]int
+	section DNSSection
+	index   int // 当前分区已读取的条目数
+
+	// 最近一次 NextRecordHeader 读取的记录
+	pending bool
+	start   int
+	rdata   int
+	rdlen   int
+}
+
+// DNSRecordHeader 记录中 RDATA 之前的部分, 名字在调用 Name 时才解析
+type DNSRecordHeader struct {
+	Section  DNSSection
+	RRType   uint16
+	Class    uint16
+	TTL      uint32
+	RDLength uint16
+
+	msg     []byte
+	nameOff int
+}
+
+// Name 解析记录的名字
+func (h DNSRecordHeader) Name() (string, error) {
+	name, _, err := (&unpacker{msg: h.msg, off: h.nameOff}).name()
+	return name, err
+}
+
+// NewDNSParser 读取 msg 的头部, 之后的内容在读取时才解析. msg 在使用 DNSParser 期间不能修改
+func NewDNSParser(msg []byte) (*DNSParser, error) {
+	p := &DNSParser{u: unpacker{msg: msg}}
+	p.header.Flags = &p.flags
+	if err := p.u.headerInto(&p.header); err != nil {
+		return nil, err
+	}
+	p.counts = [4]int{int(p.header.Questions), int(p.header.AnswerRRs), int(p.header.AuthorityRRs), int(p.header.AdditionalRRs)}
+	return p, nil
+}
+
+// Header 报文头部, 不能修改
+func (p *DNSParser) Header() *DNSHeader {
+	return &p.header
+}
+
+// Section 当前所在的分区, 读完所有记录后大于 DNSSectionAdditional
+func (p *DNSParser) Section() DNSSection {
+	return p.section
+}
+
+// NextQuestion 读取下一个问题, 问题读完或已经开始读取记录时返回 ErrSectionDone
+func (p *DNSParser) NextQuestion() (*DNSQuestion, error) {
+	if p.section != DNSSectionQuestion || p.index >= p.counts[DNSSectionQuestion] {
+		return nil, ErrSectionDone
+	}
+	q, err := p.u.question()
+	if err != nil {
+		return nil, err
+	}
+	p.index++
+	return q, nil
+}
+
+// SkipSection 跳过当前分区剩余的条目, 进入下一个分区
+func (p *DNSParser) SkipSection() error {
+	if p.section > DNSSectionAdditional {
+		return ErrSectionDone
+	}
+	p.finishRData()
+	for ; p.index < p.counts[p.section]; p.index++ {
+		if err := p.u.skipName(); err != nil {
+			return err
+		}
+		if p.section == DNSSectionQuestion {
+			if _, err := p.u.next(4); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := p.u.next(10)
+		if err != nil {
+			return err
+		}
+		if _, err := p.u.next(int(binary.BigEndian.Uint16(b[8:]))); err != nil {
+			return err
+		}
+	}
+	p.section++
+	p.index = 0
+	return nil
+}
+
+// NextRecordHeader 读取下一条记录的头部, 依次经过回答, 授权与附加分区, 未读的问题会被跳过.
+// 所有记录读完时返回 ErrSectionDone. 之后可以调用 RData 或 Record 读取这条记录的其余部分
+func (p *DNSParser) NextRecordHeader() (DNSRecordHeader, error) {
+	if p.section == DNSSectionQuestion {
+		if err := p.SkipSection(); err != nil {
+			return DNSRecordHeader{}, err
+		}
+	}
+	p.finishRData()
+	for p.section <= DNSSectionAdditional && p.index >= p.counts[p.section] {
+		p.section++
+		p.index = 0
+	}
+	if p.section > DNSSectionAdditional {
+		return DNSRecordHeader{}, ErrSectionDone
+	}
+	start := p.u.off
+	if err := p.u.skipName(); err != nil {
+		return DNSRecordHeader{}, errors.WithMessage(err, "read resource name")
+	}
+	b, err := p.u.next(10)
+	if err != nil {
+		return DNSRecordHeader{}, errors.WithMessage(err, "read resource header")
+	}
+	h := DNSRecordHeader{
+		Section:  p.section,
+		RRType:   binary.BigEndian.Uint16(b),
+		Class:    binary.BigEndian.Uint16(b[2:]),
+		TTL:      binary.BigEndian.Uint32(b[4:]),
+		RDLength: binary.BigEndian.Uint16(b[8:]),
+		msg:      p.u.msg,
+		nameOff:  start,
+	}
+	if p.u.off+int(h.RDLength) > len(p.u.msg) {
+		return DNSRecordHeader{}, errors.WithMessage(ErrShortBuffer, "read RData")
+	}
+	p.index++
+	p.pending, p.start, p.rdata, p.rdlen = true, start, p.u.off, int(h.RDLength)
+	return h, nil
+}
+
+// RData 返回当前记录的原始 RDATA, 与报文共用内存, 其中的压缩指针没有展开
+func (p *DNSParser) RData() ([]byte, error) {
+	if !p.pending {
+		return nil, ErrNoRecord
+	}
+	return p.u.msg[p.rdata : p.rdata+p.rdlen], nil
+}
+
+// Record 完整解析当前记录, 结果与 Unpack 中的记录相同
+func (p *DNSParser) Record() (*DNSResourceRecode, error) {
+	if !p.pending {
+		return nil, ErrNoRecord
+	}
+	return (&unpacker{msg: p.u.msg, off: p.start}).resource()
+}
+
+// finishRData 跳过当前记录未读取的 RDATA
+func (p *DNSParser) finishRData() {
+	if p.pending {
+		p.u.off = p.rdata + p.rdlen
+		p.pending = false
+	}
+}
@@ -0,0 +1,132 @@
+package netx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDNSParser(t *testing.T) {
+	packet, err := benchMessage().ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := Unpack(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewDNSParser(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header().TxID != 0x1234 || p.Header().AnswerRRs != 4 {
+		t.Fatalf("header = %+v", p.Header())
+	}
+	if _, err := p.RData(); err != ErrNoRecord {
+		t.Fatalf("RData before header error = %v", err)
+	}
+	q, err := p.NextQuestion()
+	if err != nil || q.QuestionName != "www.example.com" {
+		t.Fatalf("NextQuestion = %+v, %v", q, err)
+	}
+	if _, err := p.NextQuestion(); err != ErrSectionDone {
+		t.Fatalf("second NextQuestion error = %v", err)
+	}
+	var sections []DNSSection
+	for i := 0; ; i++ {
+		h, err := p.NextRecordHeader()
+		if err == ErrSectionDone {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sections = append(sections, h.Section)
+		name, err := h.Name()
+		if err != nil || name != full.ResourceRecodes[i].Name {
+			t.Fatalf("record %d name = %q, %v", i, name, err)
+		}
+		// 只读取部分记录的内容, 其余的由下一次 NextRecordHeader 跳过
+		if i%2 == 1 {
+			continue
+		}
+		r, err := p.Record()
+		if err != nil || !reflect.DeepEqual(r, full.ResourceRecodes[i]) {
+			t.Fatalf("record %d = %+v, %v, want %+v", i, r, err, full.ResourceRecodes[i])
+		}
+	}
+	want := []DNSSection{DNSSectionAnswer, DNSSectionAnswer, DNSSectionAnswer, DNSSectionAnswer, DNSSectionAuthority}
+	if !reflect.DeepEqual(sections, want) {
+		t.Fatalf("sections = %v, want %v", sections, want)
+	}
+
+	// 跳过问题与回答, 直接读取授权分区
+	p, _ = NewDNSParser(packet)
+	for i := 0; i < 2; i++ {
+		if err := p.SkipSection(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := p.NextRecordHeader()
+	if err != nil || h.Section != DNSSectionAuthority || h.RRType != DNSTypeSOA {
+		t.Fatalf("authority header = %+v, %v", h, err)
+	}
+	p, _ = NewDNSParser(packet)
+	h, err = p.NextRecordHeader()
+	if err != nil || h.RRType != DNSTypeCName {
+		t.Fatalf("first header = %+v, %v", h, err)
+	}
+	if h, err = p.NextRecordHeader(); err != nil || h.RRType != DNSTypeA {
+		t.Fatalf("second header = %+v, %v", h, err)
+	}
+	if rdata, err := p.RData(); err != nil || len(rdata) != 4 || rdata[3] != 1 {
+		t.Fatalf("RData = %v, %v", rdata, err)
+	}
+
+	p, _ = NewDNSParser(packet[:len(packet)-10])
+	var last error
+	for last == nil {
+		_, last = p.NextRecordHeader()
+	}
+	if last == ErrSectionDone {
+		t.Fatal("truncated packet parsed without error")
+	}
+	if _, err := NewDNSParser(packet[:5]); err == nil {
+		t.Fatal("short header parsed without error")
+	}
+}
+
+// 只统计回答中的 A 记录, 对比完整解析
+func BenchmarkDNSParserAnswers(b *testing.B) {
+	packet, _ := benchMessage().ToByte()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p, err := NewDNSParser(packet)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n := 0
+		for {
+			h, err := p.NextRecordHeader()
+			if err != nil || h.Section != DNSSectionAnswer {
+				break
+			}
+			if h.RRType == DNSTypeA {
+				n++
+			}
+		}
+		if n != 2 {
+			b.Fatal(n)
+		}
+	}
+}
+
+func BenchmarkUnpack(b *testing.B) {
+	packet, _ := benchMessage().ToByte()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Unpack(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
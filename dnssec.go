@@ -0,0 +1,921 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSSEC 算法, RFC 8624 中推荐实现的部分
+const (
+	DNSSECAlgRSASHA256       = 8
+	DNSSECAlgRSASHA512       = 10
+	DNSSECAlgECDSAP256SHA256 = 13
+	DNSSECAlgECDSAP384SHA384 = 14
+	DNSSECAlgED25519         = 15
+)
+
+// DS 摘要类型
+const (
+	DSDigestSHA1   = 1
+	DSDigestSHA256 = 2
+	DSDigestSHA384 = 4
+)
+
+// DNSKEY 标志位
+const (
+	DNSKEYFlagZone = 1 << 8
+	DNSKEYFlagSEP  = 1
+)
+
+var (
+	ErrDNSSECBogus      = errors.New("dnssec validation failed")
+	ErrDNSSECAlgorithm  = errors.New("unsupported dnssec algorithm")
+	ErrDNSSECSignature  = errors.New("dnssec signature mismatch")
+	ErrDNSSECExpired    = errors.New("dnssec signature outside its validity period")
+	ErrNoDNSSECResolver = errors.New("dnssec validator has no resolver")
+)
+
+// rootAnchors 根区域的 KSK-2017 与 KSK-2024, 见 https://data.iana.org/root-anchors/root-anchors.xml
+var rootAnchors = []string{
+	"20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	"38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// RRSIG 签名记录的 RDATA, RFC 4034 3
+type RRSIG struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OrigTTL     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// DNSKEY 公钥记录的 RDATA, RFC 4034 2
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// DS 委派签名者记录的 RDATA, RFC 4034 5
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// ParseRRSIG 解析 RRSIG 的 RDATA, 签名者名字不压缩
+func ParseRRSIG(data []byte) (*RRSIG, error) {
+	if len(data) < 19 {
+		return nil, ErrBadRData
+	}
+	u := &unpacker{msg: data, off: 18, partial: true}
+	signer, _, err := u.name()
+	if err != nil {
+		return nil, err
+	}
+	return &RRSIG{
+		TypeCovered: binary.BigEndian.Uint16(data),
+		Algorithm:   data[2],
+		Labels:      data[3],
+		OrigTTL:     binary.BigEndian.Uint32(data[4:]),
+		Expiration:  binary.BigEndian.Uint32(data[8:]),
+		Inception:   binary.BigEndian.Uint32(data[12:]),
+		KeyTag:      binary.BigEndian.Uint16(data[16:]),
+		SignerName:  signer,
+		Signature:   append([]byte{}, data[u.off:]...),
+	}, nil
+}
+
+// ParseDNSKEY 解析 DNSKEY 的 RDATA
+func ParseDNSKEY(data []byte) (*DNSKEY, error) {
+	if len(data) < 5 {
+		return nil, ErrBadRData
+	}
+	return &DNSKEY{
+		Flags:     binary.BigEndian.Uint16(data),
+		Protocol:  data[2],
+		Algorithm: data[3],
+		PublicKey: append([]byte{}, data[4:]...),
+	}, nil
+}
+
+// ParseDS 解析 DS 的 RDATA
+func ParseDS(data []byte) (*DS, error) {
+	if len(data) < 5 {
+		return nil, ErrBadRData
+	}
+	return &DS{
+		KeyTag:     binary.BigEndian.Uint16(data),
+		Algorithm:  data[2],
+		DigestType: data[3],
+		Digest:     append([]byte{}, data[4:]...),
+	}, nil
+}
+
+// ParseDSText 解析主文件格式的 DS, 如 "20326 8 2 E06D44B8..."
+func ParseDSText(s string) (*DS, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return nil, ErrBadRData
+	}
+	var v [3]uint64
+	for i := range v {
+		n, err := strconv.ParseUint(fields[i], 10, 16)
+		if err != nil {
+			return nil, ErrBadRData
+		}
+		v[i] = n
+	}
+	digest, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil || v[1] > 0xff || v[2] > 0xff {
+		return nil, ErrBadRData
+	}
+	return &DS{KeyTag: uint16(v[0]), Algorithm: uint8(v[1]), DigestType: uint8(v[2]), Digest: digest}, nil
+}
+
+func (s *RRSIG) pack(signature bool) ([]byte, error) {
+	b := appendUint16(nil, s.TypeCovered)
+	b = append(b, s.Algorithm, s.Labels)
+	b = appendUint32(b, s.OrigTTL)
+	b = appendUint32(b, s.Expiration)
+	b = appendUint32(b, s.Inception)
+	b = appendUint16(b, s.KeyTag)
+	b, err := appendName(b, strings.ToLower(s.SignerName))
+	if err != nil {
+		return nil, err
+	}
+	if signature {
+		b = append(b, s.Signature...)
+	}
+	return b, nil
+}
+
+// Pack 编码为 RDATA
+func (s *RRSIG) Pack() ([]byte, error) {
+	return s.pack(true)
+}
+
+// Pack 编码为 RDATA
+func (k *DNSKEY) Pack() []byte {
+	return append([]byte{byte(k.Flags >> 8), byte(k.Flags), k.Protocol, k.Algorithm}, k.PublicKey...)
+}
+
+// Pack 编码为 RDATA
+func (d *DS) Pack() []byte {
+	return append([]byte{byte(d.KeyTag >> 8), byte(d.KeyTag), d.Algorithm, d.DigestType}, d.Digest...)
+}
+
+// KeyTag RFC 4034 附录 B 的密钥标签
+func (k *DNSKEY) KeyTag() uint16 {
+	var ac uint32
+	for i, b := range k.Pack() {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac)
+}
+
+// ToDS 计算区域 owner 中该密钥的 DS
+func (k *DNSKEY) ToDS(owner string, digestType uint8) (*DS, error) {
+	name, err := appendName(nil, strings.ToLower(strings.TrimSuffix(owner, ".")))
+	if err != nil {
+		return nil, err
+	}
+	data := append(name, k.Pack()...)
+	var digest []byte
+	switch digestType {
+	case DSDigestSHA1:
+		sum := sha1.Sum(data)
+		digest = sum[:]
+	case DSDigestSHA256:
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	case DSDigestSHA384:
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	default:
+		return nil, errors.WithMessagef(ErrDNSSECAlgorithm, "digest type %d", digestType)
+	}
+	return &DS{KeyTag: k.KeyTag(), Algorithm: k.Algorithm, DigestType: digestType, Digest: digest}, nil
+}
+
+// Matches k 是否为 ds 指向的密钥
+func (d *DS) Matches(owner string, k *DNSKEY) bool {
+	if d.KeyTag != k.KeyTag() || d.Algorithm != k.Algorithm {
+		return false
+	}
+	c, err := k.ToDS(owner, d.DigestType)
+	return err == nil && bytes.Equal(c.Digest, d.Digest)
+}
+
+// publicKey 转换为 crypto 的公钥
+func (k *DNSKEY) publicKey() (crypto.PublicKey, error) {
+	key := k.PublicKey
+	switch k.Algorithm {
+	case DNSSECAlgRSASHA256, DNSSECAlgRSASHA512:
+		if len(key) < 3 {
+			return nil, ErrBadRData
+		}
+		n := int(key[0])
+		key = key[1:]
+		if n == 0 {
+			if len(key) < 3 {
+				return nil, ErrBadRData
+			}
+			n, key = int(binary.BigEndian.Uint16(key)), key[2:]
+		}
+		if n == 0 || n > 4 || len(key) <= n {
+			return nil, errors.WithMessage(ErrBadRData, "bad rsa exponent")
+		}
+		e := 0
+		for _, b := range key[:n] {
+			e = e<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(key[n:]), E: e}, nil
+	case DNSSECAlgECDSAP256SHA256, DNSSECAlgECDSAP384SHA384:
+		curve := elliptic.P256()
+		if k.Algorithm == DNSSECAlgECDSAP384SHA384 {
+			curve = elliptic.P384()
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(key) != 2*size {
+			return nil, ErrBadRData
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(key[:size]), Y: new(big.Int).SetBytes(key[size:])}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.WithMessage(ErrBadRData, "point not on curve")
+		}
+		return pub, nil
+	case DNSSECAlgED25519:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, ErrBadRData
+		}
+		return ed25519.PublicKey(key), nil
+	}
+	return nil, errors.WithMessagef(ErrDNSSECAlgorithm, "algorithm %d", k.Algorithm)
+}
+
+// signedData RFC 4034 3.1.8.1 的签名数据: 去掉签名的 RRSIG RDATA 与按规范顺序排列的 rrset
+func signedData(sig *RRSIG, rrset []*DNSResourceRecode) ([]byte, error) {
+	data, err := sig.pack(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rrset) == 0 {
+		return nil, ErrBadRData
+	}
+	owner := strings.ToLower(strings.TrimSuffix(rrset[0].Name, "."))
+	if labels := nameLabels(owner); int(sig.Labels) < labels {
+		// 通配符展开的记录以 *.<最近的 Labels 个标签> 签名
+		parts := strings.Split(owner, ".")
+		owner = "*." + strings.Join(parts[len(parts)-int(sig.Labels):], ".")
+		if sig.Labels == 0 {
+			owner = "*"
+		}
+	} else if int(sig.Labels) > labels {
+		return nil, errors.WithMessage(ErrDNSSECSignature, "rrsig labels exceed owner name")
+	}
+	head, err := appendName(nil, owner)
+	if err != nil {
+		return nil, err
+	}
+	head = appendUint16(head, rrset[0].RRType)
+	head = appendUint16(head, rrset[0].Class)
+	head = appendUint32(head, sig.OrigTTL)
+	var rdatas [][]byte
+	for _, rr := range rrset {
+		rdata, err := canonicalRData(rr)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		data = append(data, head...)
+		data = appendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data, nil
+}
+
+// canonicalRData RFC 4034 6.2: 解析为文本的 RDATA 中的名字转为小写
+func canonicalRData(rr *DNSResourceRecode) ([]byte, error) {
+	if rr.Data == nil {
+		switch rr.RRType {
+		case DNSTypeNS, DNSTypeCName, DNSTypePTR, DNSTypeSOA, DNSTypeMX, DNSTypeSRV:
+			c := *rr
+			c.RData = strings.ToLower(rr.RData)
+			return c.packRData()
+		}
+	}
+	return rr.packRData()
+}
+
+// nameLabels 名字的标签数, 不计根与开头的 "*"
+func nameLabels(name string) int {
+	name = strings.Trim(name, ".")
+	if name == "" {
+		return 0
+	}
+	n := strings.Count(name, ".") + 1
+	if name == "*" || strings.HasPrefix(name, "*.") {
+		n--
+	}
+	return n
+}
+
+// VerifyRRSIG 用 key 验证 sig 对 rrset 的签名, now 必须在签名的有效期内
+func VerifyRRSIG(sig *RRSIG, key *DNSKEY, rrset []*DNSResourceRecode, now time.Time) error {
+	if sig.Algorithm != key.Algorithm || sig.KeyTag != key.KeyTag() || key.Flags&DNSKEYFlagZone == 0 || key.Protocol != 3 {
+		return errors.WithMessage(ErrDNSSECSignature, "key does not match signature")
+	}
+	if !serialInRange(uint32(now.Unix()), sig.Inception, sig.Expiration) {
+		return ErrDNSSECExpired
+	}
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		return err
+	}
+	pub, err := key.publicKey()
+	if err != nil {
+		return err
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		hash := crypto.SHA256
+		if sig.Algorithm == DNSSECAlgRSASHA512 {
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(data)
+		if rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig.Signature) != nil {
+			return ErrDNSSECSignature
+		}
+	case *ecdsa.PublicKey:
+		var digest []byte
+		if sig.Algorithm == DNSSECAlgECDSAP384SHA384 {
+			sum := sha512.Sum384(data)
+			digest = sum[:]
+		} else {
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig.Signature) != 2*size {
+			return ErrDNSSECSignature
+		}
+		r, s := new(big.Int).SetBytes(sig.Signature[:size]), new(big.Int).SetBytes(sig.Signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrDNSSECSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig.Signature) {
+			return ErrDNSSECSignature
+		}
+	}
+	return nil
+}
+
+// serialInRange 按 RFC 1982 序列号算术判断 inception <= t <= expiration
+func serialInRange(t, inception, expiration uint32) bool {
+	return int32(t-inception) >= 0 && int32(expiration-t) >= 0
+}
+
+// DNSSECValidator 本地 DNSSEC 验证器: 从信任锚开始逐级查询 DS 与 DNSKEY, 验证回答部分的每个 rrset.
+// 只有全部 rrset 都通过验证, 并且由所在区域签名时才认为回答是安全的; 通配符展开的回答, 只能由 opt-out 证明的否定应答与
+// 不在签名链上的区域 (父区域证明没有 DS) 认为是不安全的, 即不设置 AD 但也不算验证失败.
+// 没有签名的回答与否定应答通过 SOA 查询找到所在区域, 区域在签名链上时说明签名被去掉, 验证失败 (RFC 4035 5).
+// 已验证的 DNSKEY 按 TTL 缓存, 可以并发使用
+type DNSSECValidator struct {
+	// Resolver 查询 DS 与 DNSKEY 使用的解析器
+	Resolver *Resolver
+	// Anchors 按区域名的信任锚, 为空时使用根区域的 KSK
+	Anchors map[string][]*DS
+	// NSEC 不为空时保存否定应答中经过验证的 NSEC, NSEC3 与 SOA,
+	// WithDNSSECValidation 用它们直接应答可以证明不存在的问题 (RFC 8198)
+	NSEC *NSECCache
+
+	mu   sync.Mutex
+	keys map[string]*zoneKeys
+}
+
+type zoneKeys struct {
+	keys    []*DNSKEY // 为空表示区域不在签名链上
+	expires time.Time
+}
+
+func (v *DNSSECValidator) anchors(zone string) []*DS {
+	if v.Anchors != nil {
+		return v.Anchors[zone]
+	}
+	if zone != "" {
+		return nil
+	}
+	var anchors []*DS
+	for _, s := range rootAnchors {
+		ds, _ := ParseDSText(s)
+		anchors = append(anchors, ds)
+	}
+	return anchors
+}
+
+// Validate 验证响应的回答部分, 返回是否安全; 存在无法通过验证的签名, 或者签名链上的区域返回了
+// 没有签名的数据时返回 ErrDNSSECBogus. 带签名的否定应答必须由问题所在的区域证明不存在.
+// 响应必须以 DO 位查询得到, 否则没有签名, 总是不安全
+func (v *DNSSECValidator) Validate(ctx context.Context, resp *DNSMessage) (bool, error) {
+	if negativeResponse(resp) {
+		if len(resp.Questions) == 0 {
+			return false, nil
+		}
+		for _, rr := range resp.Authorities() {
+			if rr.RRType == DNSTypeRRSIG {
+				return v.validateDenial(ctx, resp)
+			}
+		}
+		return false, v.requireUnsigned(ctx, resp.Questions[0].QuestionName)
+	}
+	if resp.Header.Flags.RCode != DNSRCodeSuccess {
+		return false, nil
+	}
+	secure := true
+	for _, rrset := range groupRRsets(resp.Answers()) {
+		if rrset[0].RRType == DNSTypeRRSIG {
+			continue
+		}
+		sigs := coveringRRSIGs(resp.Answers(), rrset[0])
+		if len(sigs) == 0 {
+			if err := v.requireUnsigned(ctx, rrset[0].Name); err != nil {
+				return false, err
+			}
+			secure = false
+			continue
+		}
+		zone, err := v.signerZone(ctx, rrset[0].Name, rrset[0].RRType)
+		if err != nil {
+			return false, err
+		}
+		ok, wildcard, err := v.verifyRRset(ctx, zone, rrset, sigs)
+		if err != nil {
+			return false, err
+		}
+		if !ok || wildcard {
+			secure = false
+		}
+	}
+	return secure, nil
+}
+
+// validateDenial 验证带签名的否定应答: 授权部分的 SOA, NSEC 与 NSEC3 必须由问题所在的区域签名,
+// 并且能以应答的 rcode 证明问题不存在. 只能由 opt-out 证明时不安全 (RFC 5155 9.2)
+func (v *DNSSECValidator) validateDenial(ctx context.Context, resp *DNSMessage) (bool, error) {
+	q := resp.Questions[0]
+	zone, err := v.signerZone(ctx, q.QuestionName, q.QuestionType)
+	if err != nil {
+		return false, err
+	}
+	keys, err := v.zoneKeys(ctx, zone)
+	if err != nil || keys == nil {
+		return false, err
+	}
+	proof := &NSECCache{}
+	if err := v.verifyDenial(ctx, zone, resp.Authorities(), proof); err != nil {
+		return false, err
+	}
+	if proof.proves(resp, resp.Header.Flags.RCode) {
+		return true, nil
+	}
+	if proof.provesOptOut(resp) {
+		return false, nil
+	}
+	return false, errors.WithMessage(ErrDNSSECBogus, "no denial of existence for "+normalizeDomain(q.QuestionName))
+}
+
+// verifyRRset 用区域 zone 已验证的密钥验证 rrset, 只接受 zone 的签名; zone 不在签名链上时返回不安全
+func (v *DNSSECValidator) verifyRRset(ctx context.Context, zone string, rrset []*DNSResourceRecode, sigs []*RRSIG) (secure, wildcard bool, err error) {
+	keys, err := v.zoneKeys(ctx, zone)
+	if err != nil || keys == nil {
+		return false, false, err
+	}
+	owner := normalizeDomain(rrset[0].Name)
+	err = errors.WithMessage(ErrDNSSECBogus, "no signature by "+zone+" for "+owner)
+	for _, sig := range sigs {
+		if normalizeDomain(sig.SignerName) != zone {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if verr := VerifyRRSIG(sig, key, rrset, time.Now()); verr != nil {
+				err = errors.WithMessage(ErrDNSSECBogus, verr.Error())
+				continue
+			}
+			return true, int(sig.Labels) < nameLabels(owner), nil
+		}
+	}
+	return false, false, err
+}
+
+// verifyDenial 验证授权部分的 SOA, NSEC 与 NSEC3, 它们必须全部由 zone 签名, 通过验证的加入 c.
+// 通配符展开的记录不加入. 过期时间取 TTL 与签名过期时间中较早的
+func (v *DNSSECValidator) verifyDenial(ctx context.Context, zone string, authorities []*DNSResourceRecode, c *NSECCache) error {
+	for _, rrset := range groupRRsets(authorities) {
+		switch rrset[0].RRType {
+		case DNSTypeSOA, DNSTypeNSEC, DNSTypeNSEC3:
+		default:
+			continue
+		}
+		sigs := coveringRRSIGs(authorities, rrset[0])
+		secure, wildcard, err := v.verifyRRset(ctx, zone, rrset, sigs)
+		if err != nil {
+			return err
+		}
+		if !secure || wildcard {
+			continue
+		}
+		expires := time.Now().Add(time.Duration(rrset[0].TTL) * time.Second)
+		for _, sig := range sigs {
+			if t := time.Unix(int64(sig.Expiration), 0); t.Before(expires) {
+				expires = t
+			}
+		}
+		var records []*DNSResourceRecode
+		for _, rr := range authorities {
+			if rr.RRType == DNSTypeRRSIG && strings.EqualFold(normalizeDomain(rr.Name), normalizeDomain(rrset[0].Name)) {
+				if sig, err := ParseRRSIG(rr.Data); err == nil && sig.TypeCovered == rrset[0].RRType {
+					records = append(records, rr)
+				}
+			}
+		}
+		if rrset[0].RRType == DNSTypeSOA {
+			c.addSOA(zone, append(rrset[:1:1], records...), expires)
+			continue
+		}
+		for _, rr := range rrset {
+			c.add(zone, append([]*DNSResourceRecode{rr}, records...), expires)
+		}
+	}
+	return nil
+}
+
+// requireUnsigned 没有签名的数据只有在所在区域不在签名链上时才是不安全的, 否则返回 ErrDNSSECBogus
+func (v *DNSSECValidator) requireUnsigned(ctx context.Context, name string) error {
+	zone, err := v.enclosingZone(ctx, normalizeDomain(name))
+	if err != nil {
+		return err
+	}
+	keys, err := v.zoneKeys(ctx, zone)
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		return errors.WithMessage(ErrDNSSECBogus, "unsigned data in signed zone "+zone)
+	}
+	return nil
+}
+
+// signerZone 应当为 name 上 rrtype 类型的 rrset 签名的区域: DS 由父区域签名, 其他类型由 name 所在的区域签名
+func (v *DNSSECValidator) signerZone(ctx context.Context, name string, rrtype uint16) (string, error) {
+	name = normalizeDomain(name)
+	if rrtype == DNSTypeDS {
+		if name == "" {
+			return "", errors.WithMessage(ErrDNSSECBogus, "ds at the root")
+		}
+		name = parentName(name)
+	}
+	return v.enclosingZone(ctx, name)
+}
+
+// enclosingZone 通过 SOA 查询找到 name 所在的区域: name 是区域顶点时 SOA 在回答部分, 否则在授权部分.
+// 找不到 SOA 时无法判断, 返回 ErrDNSSECBogus
+func (v *DNSSECValidator) enclosingZone(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if v.Resolver == nil {
+		return "", ErrNoDNSSECResolver
+	}
+	resp, err := v.Resolver.Exchange(ctx, NewQuery(name, DNSTypeSOA, WithDNSSECOK(), WithCheckingDisabled()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Release()
+	zone, found := "", false
+	for _, rr := range resp.ResourceRecodes {
+		if rr.RRType != DNSTypeSOA {
+			continue
+		}
+		if z := normalizeDomain(rr.Name); inZone(name, z) && (!found || len(z) > len(zone)) {
+			zone, found = z, true
+		}
+	}
+	if !found {
+		return "", errors.WithMessage(ErrDNSSECBogus, "no soa for "+name)
+	}
+	return zone, nil
+}
+
+// zoneKeys 返回区域经过验证的 DNSKEY, 区域不在签名链上时返回空
+func (v *DNSSECValidator) zoneKeys(ctx context.Context, zone string) ([]*DNSKEY, error) {
+	v.mu.Lock()
+	cached := v.keys[zone]
+	v.mu.Unlock()
+	if cached != nil && time.Now().Before(cached.expires) {
+		return cached.keys, nil
+	}
+	if v.Resolver == nil {
+		return nil, ErrNoDNSSECResolver
+	}
+	dsSet, ttl, err := v.delegation(ctx, zone)
+	if err != nil || dsSet == nil {
+		if err == nil {
+			v.cacheKeys(zone, nil, ttl)
+		}
+		return nil, err
+	}
+	resp, err := v.Resolver.Exchange(ctx, NewQuery(zone, DNSTypeDNSKEY, WithDNSSECOK(), WithCheckingDisabled()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Release()
+	var rrset []*DNSResourceRecode
+	var keys []*DNSKEY
+	for _, rr := range resp.Answers() {
+		if rr.RRType == DNSTypeDNSKEY && normalizeDomain(rr.Name) == zone {
+			key, err := ParseDNSKEY(rr.Data)
+			if err != nil {
+				continue
+			}
+			rrset, keys = append(rrset, rr), append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.WithMessage(ErrDNSSECBogus, "no dnskey for "+zone)
+	}
+	// DNSKEY rrset 必须由 DS 指向的某个密钥签名
+	for _, sig := range coveringRRSIGs(resp.Answers(), rrset[0]) {
+		for _, key := range keys {
+			if !matchesAnyDS(dsSet, zone, key) || VerifyRRSIG(sig, key, rrset, time.Now()) != nil {
+				continue
+			}
+			if rrset[0].TTL < ttl {
+				ttl = rrset[0].TTL
+			}
+			v.cacheKeys(zone, keys, ttl)
+			return keys, nil
+		}
+	}
+	return nil, errors.WithMessage(ErrDNSSECBogus, "dnskey rrset of "+zone+" not signed by a trusted key")
+}
+
+// delegation 返回区域经过验证的 DS 集合与 TTL, 区域不在签名链上时返回空.
+// 父区域在签名链上时, 没有 DS 必须由父区域的 NSEC 或 NSEC3 证明 (RFC 4035 5.2), 否则返回 ErrDNSSECBogus
+func (v *DNSSECValidator) delegation(ctx context.Context, zone string) ([]*DS, uint32, error) {
+	if anchors := v.anchors(zone); len(anchors) > 0 {
+		return anchors, 86400, nil
+	}
+	if !v.anchored(zone) {
+		return nil, 86400, nil
+	}
+	parent, err := v.signerZone(ctx, zone, DNSTypeDS)
+	if err != nil {
+		return nil, 0, err
+	}
+	parentKeys, err := v.zoneKeys(ctx, parent)
+	if err != nil || parentKeys == nil {
+		return nil, 300, err
+	}
+	query := NewQuery(zone, DNSTypeDS, WithDNSSECOK(), WithCheckingDisabled())
+	resp, err := v.Resolver.Exchange(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Release()
+	var rrset []*DNSResourceRecode
+	var dsSet []*DS
+	for _, rr := range resp.Answers() {
+		if rr.RRType == DNSTypeDS && normalizeDomain(rr.Name) == zone {
+			ds, err := ParseDS(rr.Data)
+			if err != nil {
+				continue
+			}
+			rrset, dsSet = append(rrset, rr), append(dsSet, ds)
+		}
+	}
+	if len(dsSet) == 0 {
+		proof := &NSECCache{}
+		if err := v.verifyDenial(ctx, parent, resp.Authorities(), proof); err != nil {
+			return nil, 0, err
+		}
+		if proof.proves(query, DNSRCodeSuccess) || proof.provesOptOut(query) {
+			return nil, 300, nil
+		}
+		return nil, 0, errors.WithMessage(ErrDNSSECBogus, "no proof of missing ds for "+zone)
+	}
+	secure, _, err := v.verifyRRset(ctx, parent, rrset, coveringRRSIGs(resp.Answers(), rrset[0]))
+	if err != nil || !secure {
+		return nil, 300, err
+	}
+	return dsSet, rrset[0].TTL, nil
+}
+
+// anchored zone 或它的某个上级区域有信任锚
+func (v *DNSSECValidator) anchored(zone string) bool {
+	for ; ; zone = parentName(zone) {
+		if len(v.anchors(zone)) > 0 {
+			return true
+		}
+		if zone == "" {
+			return false
+		}
+	}
+}
+
+// learnDenial 把否定应答授权部分中经过验证的 SOA, NSEC 与 NSEC3 加入 v.NSEC
+func (v *DNSSECValidator) learnDenial(ctx context.Context, resp *DNSMessage) {
+	if !negativeResponse(resp) || len(resp.Questions) == 0 {
+		return
+	}
+	q := resp.Questions[0]
+	zone, err := v.signerZone(ctx, q.QuestionName, q.QuestionType)
+	if err != nil {
+		return
+	}
+	if keys, err := v.zoneKeys(ctx, zone); err != nil || keys == nil {
+		return
+	}
+	proof := &NSECCache{}
+	if v.verifyDenial(ctx, zone, resp.Authorities(), proof) != nil {
+		return
+	}
+	v.NSEC.merge(proof)
+}
+
+func (v *DNSSECValidator) cacheKeys(zone string, keys []*DNSKEY, ttl uint32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.keys == nil {
+		v.keys = map[string]*zoneKeys{}
+	}
+	v.keys[zone] = &zoneKeys{keys: keys, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+}
+
+func matchesAnyDS(dsSet []*DS, zone string, key *DNSKEY) bool {
+	for _, ds := range dsSet {
+		if ds.Matches(zone, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupRRsets 按名字与类型分组, 保持第一次出现的顺序
+func groupRRsets(rrs []*DNSResourceRecode) [][]*DNSResourceRecode {
+	var sets [][]*DNSResourceRecode
+	index := map[string]int{}
+	for _, rr := range rrs {
+		key := normalizeDomain(rr.Name) + "/" + strconv.Itoa(int(rr.RRType))
+		if i, ok := index[key]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []*DNSResourceRecode{rr})
+	}
+	return sets
+}
+
+// coveringRRSIGs rrs 中覆盖 rr 所在 rrset 的签名
+func coveringRRSIGs(rrs []*DNSResourceRecode, rr *DNSResourceRecode) []*RRSIG {
+	var sigs []*RRSIG
+	for _, s := range rrs {
+		if s.RRType != DNSTypeRRSIG || !strings.EqualFold(normalizeDomain(s.Name), normalizeDomain(rr.Name)) {
+			continue
+		}
+		if sig, err := ParseRRSIG(s.Data); err == nil && sig.TypeCovered == rr.RRType {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// WithDNSSECValidation 转发前设置 DO 与 CD 位, 用 v 在本地验证上游的响应:
+// 安全的回答在客户端设置了 DO 或 AD 时带 AD 位 (RFC 6840 5.8), 验证失败时应答 SERVFAIL,
+// 客户端设置了 CD 时不做判断, 原样返回. 客户端没有设置 DO 时去掉 DNSSEC 记录, 没有 OPT 记录时也去掉 OPT.
+// v.NSEC 不为空时, 缓存能证明不存在的问题不再转发, 直接应答合成的 NXDOMAIN 或 NODATA, 能证明的否定应答也带 AD 位
+func WithDNSSECValidation(v *DNSSECValidator) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			if len(req.Message.Questions) != 1 {
+				return next.ServeDNS(ctx, req)
+			}
+			clientFlags, clientDO, clientOPT := *req.Message.Header.Flags, req.Message.DNSSECOK(), req.Message.OPT() != nil
+			if v.NSEC != nil && !clientFlags.CheckingDisabled() {
+				if resp := v.NSEC.Synthesize(req.Message); resp != nil {
+					if clientOPT {
+						resp.SetEDNS(0, nil)
+						resp.OPT().TTL = req.Message.OPT().TTL & optDO
+					}
+					if clientDO || clientFlags.AuthenticData() {
+						resp.Header.Flags.Z |= flagAD
+					}
+					if !clientDO {
+						stripDNSSEC(resp, req.Message.Questions[0].QuestionType, clientOPT)
+					}
+					return resp, nil
+				}
+			}
+			upstream := req.Message.Copy()
+			WithDNSSECOK()(upstream)
+			upstream.Header.Flags.Z |= flagCD
+			resp, err := next.ServeDNS(ctx, &DNSRequest{Message: upstream, Network: req.Network, RemoteAddr: req.RemoteAddr})
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			resp.Header.Flags.Z &^= flagAD | flagCD
+			resp.Header.Flags.Z |= clientFlags.Z & flagCD
+			if !clientFlags.CheckingDisabled() {
+				secure, err := v.Validate(ctx, resp)
+				if err == nil && v.NSEC != nil && negativeResponse(resp) {
+					v.learnDenial(ctx, resp)
+					secure = secure || v.NSEC.proves(req.Message, resp.Header.Flags.RCode)
+				}
+				if err != nil {
+					resp.Release()
+					reply := NewReply(req.Message)
+					reply.Header.Flags.RA = 1
+					reply.Header.Flags.RCode = DNSRCodeServFail
+					return reply, nil
+				}
+				if secure && (clientDO || clientFlags.AuthenticData()) {
+					resp.Header.Flags.Z |= flagAD
+				}
+			}
+			if !clientDO {
+				stripDNSSEC(resp, req.Message.Questions[0].QuestionType, clientOPT)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// stripDNSSEC 去掉客户端没有要求的 DNSSEC 记录 (RFC 4035 3.2.1), 保留问题类型本身的记录, keepOPT 为假时去掉 OPT
+func stripDNSSEC(m *DNSMessage, qtype uint16, keepOPT bool) {
+	answers, authorities := int(m.Header.AnswerRRs), int(m.Header.AuthorityRRs)
+	kept := m.ResourceRecodes[:0]
+	var counts [3]uint16
+	for i, rr := range m.ResourceRecodes {
+		section := 2
+		if i < answers {
+			section = 0
+		} else if i < answers+authorities {
+			section = 1
+		}
+		switch rr.RRType {
+		case DNSTypeRRSIG, DNSTypeNSEC, DNSTypeNSEC3:
+			if rr.RRType != qtype {
+				continue
+			}
+		case DNSTypeOPT:
+			if !keepOPT {
+				continue
+			}
+		}
+		kept = append(kept, rr)
+		counts[section]++
+	}
+	m.ResourceRecodes = kept
+	m.Header.AnswerRRs, m.Header.AuthorityRRs, m.Header.AdditionalRRs = counts[0], counts[1], counts[2]
+}
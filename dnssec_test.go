@@ -0,0 +1,443 @@
+package netx
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testZoneKey 测试用的区域密钥
+type testZoneKey struct {
+	zone   string
+	signer crypto.Signer
+	key    *DNSKEY
+}
+
+func newTestZoneKey(t *testing.T, zone string, alg uint8) *testZoneKey {
+	k := &testZoneKey{zone: zone, key: &DNSKEY{Flags: DNSKEYFlagZone | DNSKEYFlagSEP, Protocol: 3, Algorithm: alg}}
+	switch alg {
+	case DNSSECAlgECDSAP256SHA256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y := make([]byte, 32), make([]byte, 32)
+		priv.X.FillBytes(x)
+		priv.Y.FillBytes(y)
+		k.signer, k.key.PublicKey = priv, append(x, y...)
+	case DNSSECAlgED25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k.signer, k.key.PublicKey = priv, pub
+	case DNSSECAlgRSASHA256:
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := []byte{byte(priv.E >> 16), byte(priv.E >> 8), byte(priv.E)}
+		k.signer, k.key.PublicKey = priv, append(append([]byte{3}, e...), priv.N.Bytes()...)
+	}
+	return k
+}
+
+func (k *testZoneKey) ds(t *testing.T) *DS {
+	ds, err := k.key.ToDS(k.zone, DSDigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func (k *testZoneKey) dnskey() *DNSResourceRecode {
+	return &DNSResourceRecode{Name: k.zone, RRType: DNSTypeDNSKEY, Class: DNSClassIn, TTL: 3600, Data: k.key.Pack()}
+}
+
+// sign 返回 rrset 的 RRSIG 记录, signed 不为空时对它签名, 用于构造错误的签名
+func (k *testZoneKey) sign(t *testing.T, rrset []*DNSResourceRecode, labels int, signed ...*DNSResourceRecode) *DNSResourceRecode {
+	now := time.Now()
+	sig := &RRSIG{
+		TypeCovered: rrset[0].RRType,
+		Algorithm:   k.key.Algorithm,
+		Labels:      uint8(labels),
+		OrigTTL:     rrset[0].TTL,
+		Inception:   uint32(now.Add(-time.Hour).Unix()),
+		Expiration:  uint32(now.Add(time.Hour).Unix()),
+		KeyTag:      k.key.KeyTag(),
+		SignerName:  k.zone,
+	}
+	if signed == nil {
+		signed = rrset
+	}
+	data, err := signedData(sig, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch s := k.signer.(type) {
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256(data)
+		r, ss, err := ecdsa.Sign(rand.Reader, s, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb, sb := make([]byte, 32), make([]byte, 32)
+		r.FillBytes(rb)
+		ss.FillBytes(sb)
+		sig.Signature = append(rb, sb...)
+	case ed25519.PrivateKey:
+		sig.Signature = ed25519.Sign(s, data)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256(data)
+		if sig.Signature, err = rsa.SignPKCS1v15(rand.Reader, s, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := sig.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DNSResourceRecode{Name: rrset[0].Name, RRType: DNSTypeRRSIG, Class: DNSClassIn, TTL: rrset[0].TTL, Data: b}
+}
+
+// nsecChain 返回区域按规范顺序排列的 owner 的 NSEC 链及其签名
+func (k *testZoneKey) nsecChain(t *testing.T, owners []string, types map[string][]uint16) []*DNSResourceRecode {
+	var rrs []*DNSResourceRecode
+	for i, owner := range owners {
+		data, err := (&NSEC{NextDomain: owners[(i+1)%len(owners)], Types: types[owner]}).Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrset := []*DNSResourceRecode{{Name: owner, RRType: DNSTypeNSEC, Class: DNSClassIn, TTL: 3600, Data: data}}
+		rrs = append(rrs, rrset[0], k.sign(t, rrset, nameLabels(owner)))
+	}
+	return rrs
+}
+
+func aRecord(name, ip string) *DNSResourceRecode {
+	return &DNSResourceRecode{Name: name, RRType: DNSTypeA, Class: DNSClassIn, TTL: 300, RData: ip}
+}
+
+// signedUpstream 模拟上游递归服务器, 对 example, sub.example 与 plain.example 返回带签名的数据,
+// 没有数据时在授权部分返回所在区域的 SOA 与 NSEC 链, 名字没有任何记录时应答 NXDOMAIN.
+// DS 由父区域应答
+type signedUpstream struct {
+	mu      sync.Mutex
+	records map[string][]*DNSResourceRecode
+	denial  map[string][]*DNSResourceRecode // 按区域的 NSEC 链
+	strip   map[string]bool                 // 对这些名字的应答去掉签名, 模拟路径上的攻击者
+	last    *DNSMessage                     // 收到的最后一个请求
+}
+
+func (u *signedUpstream) add(name string, qtype uint16, rrs ...*DNSResourceRecode) {
+	key := name + "/" + DNSType(qtype).String()
+	u.records[key] = append(u.records[key], rrs...)
+}
+
+func (u *signedUpstream) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	q := req.Message.Questions[0]
+	u.mu.Lock()
+	u.last = req.Message.Copy()
+	name := strings.ToLower(q.QuestionName)
+	rrs := u.records[name+"/"+DNSType(q.QuestionType).String()]
+	zone := name
+	if q.QuestionType == DNSTypeDS {
+		zone = parentName(name)
+	}
+	var soa []*DNSResourceRecode
+	for ; len(rrs) == 0 && soa == nil; zone = parentName(zone) {
+		if soa = u.records[zone+"/SOA"]; soa != nil {
+			soa = append(soa[:len(soa):len(soa)], u.denial[zone]...)
+		}
+		if zone == "" {
+			break
+		}
+	}
+	exists := false
+	for key := range u.records {
+		exists = exists || strings.HasPrefix(key, name+"/")
+	}
+	strip := u.strip[name]
+	u.mu.Unlock()
+	resp := NewReply(req.Message)
+	resp.Header.Flags.RA = 1
+	if !exists {
+		resp.Header.Flags.RCode = DNSRCodeNXDomain
+	}
+	for _, rr := range append(rrs[:len(rrs):len(rrs)], soa...) {
+		if rr.RRType == DNSTypeRRSIG && strip {
+			continue
+		}
+		resp.ResourceRecodes = append(resp.ResourceRecodes, rr.Copy())
+	}
+	if len(rrs) > 0 {
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+	} else {
+		resp.Header.AuthorityRRs = uint16(len(resp.ResourceRecodes))
+	}
+	if opt := req.Message.OPT(); opt != nil {
+		resp.SetEDNS(1232, []EDNSOption{{Code: EDNSOptionNSID, Data: []byte("up")}})
+		resp.OPT().TTL = opt.TTL & optDO
+	}
+	return resp, nil
+}
+
+func newSignedUpstream(t *testing.T) (*signedUpstream, map[string][]*DS) {
+	ex := newTestZoneKey(t, "example", DNSSECAlgECDSAP256SHA256)
+	sub := newTestZoneKey(t, "sub.example", DNSSECAlgED25519)
+	plain := newTestZoneKey(t, "plain.example", DNSSECAlgED25519)
+	u := &signedUpstream{records: map[string][]*DNSResourceRecode{}, denial: map[string][]*DNSResourceRecode{}, strip: map[string]bool{}}
+	for _, k := range []*testZoneKey{ex, sub, plain} {
+		soa := []*DNSResourceRecode{{Name: k.zone, RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 600, RData: "ns." + k.zone + ". admin." + k.zone + ". 1 3600 600 86400 600"}}
+		u.add(k.zone, DNSTypeSOA, append(soa, k.sign(t, soa, nameLabels(k.zone)))...)
+	}
+
+	keys := []*DNSResourceRecode{ex.dnskey()}
+	u.add("example", DNSTypeDNSKEY, append(keys, ex.sign(t, keys, 1))...)
+	ds := []*DNSResourceRecode{{Name: "sub.example", RRType: DNSTypeDS, Class: DNSClassIn, TTL: 3600, Data: sub.ds(t).Pack()}}
+	u.add("sub.example", DNSTypeDS, append(ds, ex.sign(t, ds, 2))...)
+	www := []*DNSResourceRecode{aRecord("www.example", "192.0.2.1")}
+	u.add("www.example", DNSTypeA, append(www, ex.sign(t, www, 2))...)
+
+	keys = []*DNSResourceRecode{sub.dnskey()}
+	u.add("sub.example", DNSTypeDNSKEY, append(keys, sub.sign(t, keys, 2))...)
+	www = []*DNSResourceRecode{aRecord("www.sub.example", "192.0.2.2"), aRecord("www.sub.example", "192.0.2.3")}
+	u.add("www.sub.example", DNSTypeA, append(www, sub.sign(t, www, 3))...)
+	bad := []*DNSResourceRecode{aRecord("bad.sub.example", "192.0.2.4")}
+	u.add("bad.sub.example", DNSTypeA, append(bad, sub.sign(t, bad, 3, aRecord("bad.sub.example", "192.0.2.99")))...)
+	wild := []*DNSResourceRecode{aRecord("x.wild.sub.example", "192.0.2.5")}
+	u.add("x.wild.sub.example", DNSTypeA, append(wild, sub.sign(t, wild, 3))...)
+
+	u.denial["example"] = ex.nsecChain(t, []string{"example", "plain.example", "sub.example", "www.example"}, map[string][]uint16{
+		"example":       {DNSTypeSOA, DNSTypeNS, DNSTypeDNSKEY, DNSTypeRRSIG, DNSTypeNSEC},
+		"plain.example": {DNSTypeNS, DNSTypeRRSIG, DNSTypeNSEC},
+		"sub.example":   {DNSTypeNS, DNSTypeDS, DNSTypeRRSIG, DNSTypeNSEC},
+		"www.example":   {DNSTypeA, DNSTypeRRSIG, DNSTypeNSEC},
+	})
+	u.denial["sub.example"] = sub.nsecChain(t, []string{"sub.example", "bad.sub.example", "x.wild.sub.example", "www.sub.example"}, map[string][]uint16{
+		"sub.example":        {DNSTypeSOA, DNSTypeNS, DNSTypeDNSKEY, DNSTypeRRSIG, DNSTypeNSEC},
+		"bad.sub.example":    {DNSTypeA, DNSTypeRRSIG, DNSTypeNSEC},
+		"x.wild.sub.example": {DNSTypeA, DNSTypeRRSIG, DNSTypeNSEC},
+		"www.sub.example":    {DNSTypeA, DNSTypeRRSIG, DNSTypeNSEC},
+	})
+
+	// plain.example 没有 DS, 父区域的 NSEC 证明它不在签名链上
+	keys = []*DNSResourceRecode{plain.dnskey()}
+	u.add("plain.example", DNSTypeDNSKEY, append(keys, plain.sign(t, keys, 2))...)
+	www = []*DNSResourceRecode{aRecord("www.plain.example", "192.0.2.6")}
+	u.add("www.plain.example", DNSTypeA, append(www, plain.sign(t, www, 3))...)
+	return u, map[string][]*DS{"example": {ex.ds(t)}}
+}
+
+func TestDNSSECValidator(t *testing.T) {
+	u, anchors := newSignedUpstream(t)
+	upstream, _ := startDNSServer(t, &DNSServer{Handler: u})
+	r := &Resolver{Server: upstream}
+	v := &DNSSECValidator{Resolver: r, Anchors: anchors}
+	ctx := context.Background()
+
+	for name, want := range map[string]struct {
+		secure bool
+		bogus  bool
+	}{
+		"www.example":        {secure: true},
+		"www.sub.example":    {secure: true},
+		"WWW.Sub.Example":    {secure: true},
+		"bad.sub.example":    {bogus: true},
+		"x.wild.sub.example": {},
+		"www.plain.example":  {},
+		"none.example":       {secure: true},
+		"none.sub.example":   {secure: true},
+		"none.plain.example": {},
+	} {
+		resp, err := r.Exchange(ctx, NewQuery(name, DNSTypeA, WithDNSSECOK()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		secure, err := v.Validate(ctx, resp)
+		if secure != want.secure || (err != nil) != want.bogus {
+			t.Fatalf("%s: secure = %v, err = %v", name, secure, err)
+		}
+	}
+
+	// 路径上的攻击者去掉签名: 区域在签名链上, 不能当作不安全的回答
+	u.mu.Lock()
+	u.strip["www.sub.example"] = true
+	u.strip["none.sub.example"] = true
+	u.strip["none.plain.example"] = true
+	u.mu.Unlock()
+	for name, bogus := range map[string]bool{"www.sub.example": true, "none.sub.example": true, "none.plain.example": false} {
+		resp, err := r.Exchange(ctx, NewQuery(name, DNSTypeA, WithDNSSECOK()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		secure, err := v.Validate(ctx, resp)
+		if secure || errors.Cause(err) == ErrDNSSECBogus != bogus {
+			t.Fatalf("stripped %s: secure = %v, err = %v", name, secure, err)
+		}
+	}
+
+	// 伪造的回答自称由它自己的区域签名, 伪造的否定应答带着无法验证的签名
+	evil := newTestZoneKey(t, "evil.sub.example", DNSSECAlgED25519)
+	forged := NewReply(NewQuery("evil.sub.example", DNSTypeA, WithDNSSECOK()))
+	answer := []*DNSResourceRecode{aRecord("evil.sub.example", "198.51.100.1")}
+	forged.ResourceRecodes = append(answer, evil.sign(t, answer, 3))
+	forged.Header.AnswerRRs = 2
+	nx := NewReply(NewQuery("www.sub.example", DNSTypeA, WithDNSSECOK()))
+	nx.Header.Flags.RCode = DNSRCodeNXDomain
+	soa := []*DNSResourceRecode{{Name: "sub.example", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 600, RData: "ns.sub.example. admin.sub.example. 2 3600 600 86400 600"}}
+	junk := evil.sign(t, soa, 2)
+	junk.Data[len(junk.Data)-1] ^= 0xff
+	nx.ResourceRecodes = append(soa, junk)
+	nx.Header.AuthorityRRs = 2
+	for name, resp := range map[string]*DNSMessage{"forged answer": forged, "forged nxdomain": nx} {
+		if secure, err := v.Validate(ctx, resp); secure || errors.Cause(err) != ErrDNSSECBogus {
+			t.Fatalf("%s: secure = %v, err = %v", name, secure, err)
+		}
+	}
+	// 攻击者也伪造了 evil.sub.example 的 SOA: 签名链上的父区域不能证明那里没有 DS
+	esoa := []*DNSResourceRecode{{Name: "evil.sub.example", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 600, RData: "ns.evil.sub.example. admin.evil.sub.example. 1 3600 600 86400 600"}}
+	u.mu.Lock()
+	u.add("evil.sub.example", DNSTypeSOA, append(esoa, evil.sign(t, esoa, 3))...)
+	u.mu.Unlock()
+	fresh := &DNSSECValidator{Resolver: r, Anchors: anchors}
+	if secure, err := fresh.Validate(ctx, forged); secure || errors.Cause(err) != ErrDNSSECBogus {
+		t.Fatalf("forged zone: secure = %v, err = %v", secure, err)
+	}
+
+	// 没有信任锚时不能验证到 example
+	resp, _ := r.Exchange(ctx, NewQuery("www.example", DNSTypeA, WithDNSSECOK()))
+	other := &DNSSECValidator{Resolver: r, Anchors: map[string][]*DS{}}
+	if secure, err := other.Validate(ctx, resp); secure || err != nil {
+		t.Fatalf("without anchor: %v, %v", secure, err)
+	}
+}
+
+func TestDNSSECForwarding(t *testing.T) {
+	u, anchors := newSignedUpstream(t)
+	upstream, _ := startDNSServer(t, &DNSServer{Handler: u})
+	up := &Resolver{Server: upstream}
+	passthrough, _ := startDNSServer(t, &DNSServer{Handler: ForwardHandler(up)})
+	validating, _ := startDNSServer(t, &DNSServer{
+		Handler:     ForwardHandler(up),
+		Middlewares: []ServerMiddleware{WithDNSSECValidation(&DNSSECValidator{Resolver: up, Anchors: anchors})},
+	})
+	ctx := context.Background()
+	exchange := func(server, name string, opts ...QueryOption) *DNSMessage {
+		t.Helper()
+		resp, err := (&Resolver{Server: server}).Exchange(ctx, NewQuery(name, DNSTypeA, opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	countSigs := func(m *DNSMessage) int {
+		n := 0
+		for _, rr := range m.ResourceRecodes {
+			if rr.RRType == DNSTypeRRSIG {
+				n++
+			}
+		}
+		return n
+	}
+
+	// 不验证时 DO, CD 与 OPT 选项原样到达上游, 签名与上游的 OPT 原样返回
+	cookie := EDNSOption{Code: 10, Data: []byte("12345678")}
+	resp := exchange(passthrough, "www.sub.example", WithDNSSECOK(), WithCheckingDisabled(), WithEDNSOptions(0, cookie))
+	u.mu.Lock()
+	last := u.last
+	u.mu.Unlock()
+	if !last.DNSSECOK() || !last.Header.Flags.CheckingDisabled() || len(last.EDNSOptions()) != 1 || last.EDNSOptions()[0].Code != 10 {
+		t.Fatalf("upstream saw DO=%v CD=%v options=%v", last.DNSSECOK(), last.Header.Flags.CheckingDisabled(), last.EDNSOptions())
+	}
+	if countSigs(resp) != 1 || !resp.DNSSECOK() || len(resp.EDNSOptions()) != 1 {
+		t.Fatalf("pass-through response: sigs=%d DO=%v options=%v", countSigs(resp), resp.DNSSECOK(), resp.EDNSOptions())
+	}
+
+	// 验证后设置 AD, 客户端没有 DO 时去掉签名与 OPT
+	resp = exchange(validating, "www.sub.example", WithDNSSECOK())
+	if !resp.Header.Flags.AuthenticData() || countSigs(resp) != 1 {
+		t.Fatalf("DO query: AD=%v sigs=%d", resp.Header.Flags.AuthenticData(), countSigs(resp))
+	}
+	resp = exchange(validating, "www.sub.example", WithAuthenticData())
+	if !resp.Header.Flags.AuthenticData() || countSigs(resp) != 0 || resp.OPT() != nil || len(resp.Answers()) != 2 {
+		t.Fatalf("AD query: AD=%v sigs=%d opt=%v answers=%d", resp.Header.Flags.AuthenticData(), countSigs(resp), resp.OPT(), len(resp.Answers()))
+	}
+	if resp = exchange(validating, "www.sub.example"); resp.Header.Flags.AuthenticData() {
+		t.Fatal("AD set for a client that asked for neither DO nor AD")
+	}
+	if resp = exchange(validating, "www.plain.example", WithDNSSECOK()); resp.Header.Flags.AuthenticData() || len(resp.Answers()) != 2 {
+		t.Fatalf("insecure answer: AD=%v answers=%d", resp.Header.Flags.AuthenticData(), len(resp.Answers()))
+	}
+
+	// 验证失败时应答 SERVFAIL, 客户端设置 CD 时原样返回
+	if resp = exchange(validating, "bad.sub.example", WithDNSSECOK()); resp.Header.Flags.RCode != DNSRCodeServFail {
+		t.Fatalf("bogus rcode = %d", resp.Header.Flags.RCode)
+	}
+	resp = exchange(validating, "bad.sub.example", WithDNSSECOK(), WithCheckingDisabled())
+	if resp.Header.Flags.RCode != DNSRCodeSuccess || resp.Header.Flags.AuthenticData() || !resp.Header.Flags.CheckingDisabled() {
+		t.Fatalf("CD query: rcode=%d flags=%+v", resp.Header.Flags.RCode, resp.Header.Flags)
+	}
+
+	// 去掉签名的回答与否定应答不能作为不安全的应答转发
+	u.mu.Lock()
+	u.strip["www.sub.example"] = true
+	u.strip["none.sub.example"] = true
+	u.mu.Unlock()
+	for _, name := range []string{"www.sub.example", "none.sub.example"} {
+		if resp = exchange(validating, name); resp.Header.Flags.RCode != DNSRCodeServFail {
+			t.Fatalf("stripped %s: rcode = %d", name, resp.Header.Flags.RCode)
+		}
+	}
+}
+
+func TestDNSKEYKeyTagAndDS(t *testing.T) {
+	// RFC 4034 5.4 的例子
+	pub, _ := base64.StdEncoding.DecodeString("AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==")
+	key := &DNSKEY{Flags: 256, Protocol: 3, Algorithm: 5, PublicKey: pub}
+	if tag := key.KeyTag(); tag != 60485 {
+		t.Fatalf("KeyTag = %d", tag)
+	}
+	ds, err := key.ToDS("dskey.example.com.", DSDigestSHA1)
+	if err != nil || hex.EncodeToString(ds.Digest) != "2bb183af5f22588179a53b0a98631fad1a292118" {
+		t.Fatalf("ToDS = %x, %v", ds.Digest, err)
+	}
+	text, _ := ParseDSText("60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118")
+	if !text.Matches("dskey.example.com", key) {
+		t.Fatal("DS does not match key")
+	}
+	for _, s := range rootAnchors {
+		if _, err := ParseDSText(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+
+	// RSA 签名
+	k := newTestZoneKey(t, "rsa.example", DNSSECAlgRSASHA256)
+	rrset := []*DNSResourceRecode{aRecord("rsa.example", "192.0.2.8")}
+	sig, err := ParseRRSIG(k.sign(t, rrset, 2).Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRRSIG(sig, k.key, rrset, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRRSIG(sig, k.key, rrset, time.Now().Add(2*time.Hour)); err != ErrDNSSECExpired {
+		t.Fatalf("expired signature: %v", err)
+	}
+	if err := VerifyRRSIG(sig, k.key, []*DNSResourceRecode{aRecord("rsa.example", "192.0.2.9")}, time.Now()); err != ErrDNSSECSignature {
+		t.Fatalf("modified rrset: %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultServerTimeout = 5 * time.Second
+	defaultIdleTimeout   = 10 * time.Second
+)
+
+var ErrServerClosed = errors.New("dns server closed")
+
+// defaultServerUnpack 请求的默认解析上限, 正常的请求远小于这些值
+var defaultServerUnpack = &UnpackOptions{MaxRecords: 512, MaxNameExpansion: 128 << 10}
+
+// DNSRequest 服务器收到的一个请求
+type DNSRequest struct {
+	Message    *DNSMessage
+	Network    string // "udp", "tcp", DoH 的 "https", unix socket 的 "unix" 或 PipeTransport 的 "pipe"
+	RemoteAddr net.Addr
+}
+
+// ClientIP 返回请求来源地址, 无法识别时返回空
+func (r *DNSRequest) ClientIP() net.IP {
+	switch addr := r.RemoteAddr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	if r.RemoteAddr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// DNSHandler 处理一个请求, 返回 nil 响应且错误为空时不应答, 返回错误时应答 SERVFAIL.
+// DNSServer 在响应编码后回收 req.Message, ServeDNS 返回后仍需使用请求时先 CopyToOwn
+type DNSHandler interface {
+	ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error)
+}
+
+// DNSHandlerFunc 允许将普通函数作为 DNSHandler 使用
+type DNSHandlerFunc func(ctx context.Context, req *DNSRequest) (*DNSMessage, error)
+
+func (f DNSHandlerFunc) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	return f(ctx, req)
+}
+
+// ServerMiddleware 包裹下一个 DNSHandler, 与 Interceptor 对 RoundTripper 的作用相同
+type ServerMiddleware func(next DNSHandler) DNSHandler
+
+// ChainHandler 用 middlewares 依次包裹 base, 第一个中间件最先收到请求. base 为空时应答 REFUSED
+func ChainHandler(base DNSHandler, middlewares ...ServerMiddleware) DNSHandler {
+	h := base
+	if h == nil {
+		h = DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp := NewReply(req.Message)
+			resp.Header.Flags.RCode = DNSRCodeRefused
+			return resp, nil
+		})
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// NewReply 构造与 req 对应的空响应
+func NewReply(req *DNSMessage) *DNSMessage {
+	return &DNSMessage{
+		Header: &DNSHeader{
+			TxID: req.Header.TxID,
+			Flags: &DNSFlags{
+				QR:     1,
+				OpCode: req.Header.Flags.OpCode,
+				RD:     req.Header.Flags.RD,
+			},
+			Questions: uint16(len(req.Questions)),
+		},
+		Questions: req.Questions,
+	}
+}
+
+// DNSServer 在 udp 与 tcp 上提供 DNS 服务, 请求依次经过 Middlewares 后交给 Handler
+type DNSServer struct {
+	Handler     DNSHandler
+	Middlewares []ServerMiddleware
+	// Timeout 单个请求的处理超时, 默认 5s
+	Timeout time.Duration
+	// IdleTimeout tcp 连接等待下一个请求的时间, 默认 10s
+	IdleTimeout time.Duration
+	// UnpackOptions 请求的解析选项, 为空时使用 UnpackDefault 并限制问题与记录数及名字展开长度.
+	// 不能解析或超出上限的请求以及有多个问题的请求应答 FORMERR
+	UnpackOptions *UnpackOptions
+
+	mu       sync.Mutex
+	closing  bool
+	packets  map[net.PacketConn]bool
+	lns      map[net.Listener]bool
+	conns    map[net.Conn]bool // tcp 连接 -> 是否空闲
+	loops    int               // 运行中的 ServeUDP 与 ServeTCP
+	inflight int64
+}
+
+func (s *DNSServer) handler() DNSHandler {
+	return ChainHandler(s.Handler, s.Middlewares...)
+}
+
+// ServeUDP 处理 conn 上的请求直到 conn 关闭或 Shutdown, Shutdown 后返回 ErrServerClosed. linux 上批量收发
+func (s *DNSServer) ServeUDP(conn net.PacketConn) error {
+	if !s.track(func() { s.packets[conn] = true }) {
+		return ErrServerClosed
+	}
+	defer s.untrack(func() {
+		// Shutdown 之后由 closeAll 关闭
+		if !s.closing {
+			delete(s.packets, conn)
+		}
+	})
+	h := s.handler()
+	if bc := newBatchConn(conn); bc != nil {
+		return s.serveUDPBatch(bc, h)
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		packet := append(getBuffer()[:0], buf[:n]...)
+		atomic.AddInt64(&s.inflight, 1)
+		go func() {
+			defer atomic.AddInt64(&s.inflight, -1)
+			resp := s.serve(h, packet, "udp", addr, conn.LocalAddr())
+			putBuffer(packet)
+			if resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP 接受 ln 上的连接直到 ln 关闭或 Shutdown, 每个连接可以连续发送多个请求.
+// ln 也可以是 unix socket 的监听, 此时请求的 Network 为 "unix"
+func (s *DNSServer) ServeTCP(ln net.Listener) error {
+	if !s.track(func() { s.lns[ln] = true }) {
+		return ErrServerClosed
+	}
+	defer s.untrack(func() { delete(s.lns, ln) })
+	h := s.handler()
+	network := "tcp"
+	if ln.Addr().Network() == "unix" {
+		network = "unix"
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		closing := s.closing
+		if !closing {
+			s.conns[conn] = true
+		}
+		s.mu.Unlock()
+		if closing {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(h, conn, network)
+	}
+}
+
+// track 在未关闭时执行 fn 登记 socket 并开始一个读取循环
+func (s *DNSServer) track(fn func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.packets == nil {
+		s.packets, s.lns, s.conns = map[net.PacketConn]bool{}, map[net.Listener]bool{}, map[net.Conn]bool{}
+	}
+	fn()
+	s.loops++
+	return true
+}
+
+// untrack 在读取循环结束时执行 fn
+func (s *DNSServer) untrack(fn func()) {
+	s.mu.Lock()
+	fn()
+	s.loops--
+	s.mu.Unlock()
+}
+
+func (s *DNSServer) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// setIdle 标记 tcp 连接是否空闲, 已经 Shutdown 时返回 false, 连接应当关闭
+func (s *DNSServer) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = idle
+	return !(s.closing && idle)
+}
+
+// Shutdown 停止接收新请求并等待处理中的请求完成: udp socket 停止读取, tcp 监听关闭, 空闲的 tcp 连接立即关闭,
+// 其它连接在写出当前响应后关闭. ctx 结束时关闭所有 socket 并返回 ctx 的错误
+func (s *DNSServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for conn := range s.packets {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	for ln := range s.lns {
+		_ = ln.Close()
+	}
+	for conn, idle := range s.conns {
+		if idle {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		done := s.loops == 0 && atomic.LoadInt64(&s.inflight) == 0 && len(s.conns) == 0
+		s.mu.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			s.closeAll()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s.closeAll()
+	return nil
+}
+
+// closeAll 关闭所有登记的 socket
+func (s *DNSServer) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.packets {
+		_ = conn.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *DNSServer) serveConn(h DNSHandler, conn net.Conn, network string) {
+	defer func() {
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	idle := s.IdleTimeout
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	for {
+		// 先设置超时再标记空闲, 以免覆盖 Shutdown 设置的超时
+		_ = conn.SetDeadline(time.Now().Add(idle))
+		if !s.setIdle(conn, true) {
+			return
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		// 读到长度后视为处理中, Shutdown 等待响应写出
+		s.setIdle(conn, false)
+		_ = conn.SetDeadline(time.Now().Add(idle))
+		packet := getBuffer()[:binary.BigEndian.Uint16(length[:])]
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			putBuffer(packet)
+			return
+		}
+		resp := s.serve(h, packet, network, conn.RemoteAddr(), conn.LocalAddr())
+		putBuffer(packet)
+		if resp == nil {
+			continue
+		}
+		out := make([]byte, 2, 2+len(resp))
+		binary.BigEndian.PutUint16(out, uint16(len(resp)))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// serve 解析并处理一个请求, 返回编码后的响应, 不需要应答时返回空. local 为收到请求的本地地址, 可以为空
+func (s *DNSServer) serve(h DNSHandler, packet []byte, network string, addr, local net.Addr) []byte {
+	opts := s.UnpackOptions
+	if opts == nil {
+		opts = defaultServerUnpack
+	}
+	msg, _, err := opts.UnpackPooled(packet)
+	if err != nil {
+		// 头部可读时应答 FORMERR
+		header, err := (&unpacker{msg: packet}).header()
+		if err != nil || header.Flags.QR != 0 {
+			return nil
+		}
+		resp := NewReply(&DNSMessage{Header: header})
+		resp.Header.Flags.RCode = DNSRCodeFormErr
+		b, _ := resp.ToByte()
+		return b
+	}
+	// 响应编码完成后回收请求
+	defer msg.Release()
+	if msg.Header.Flags.QR != 0 {
+		return nil
+	}
+	if len(msg.Questions) > 1 {
+		// 多个问题没有明确的语义, 按 RFC 9619 应答 FORMERR
+		resp := NewReply(&DNSMessage{Header: msg.Header})
+		resp.Header.Flags.RCode = DNSRCodeFormErr
+		b, _ := resp.ToByte()
+		return b
+	}
+	resp := s.exchange(h, msg, network, addr, local)
+	if resp == nil {
+		return nil
+	}
+	b, err := resp.ToByte()
+	if err != nil {
+		resp = NewReply(msg)
+		resp.Header.Flags.RCode = DNSRCodeServFail
+		if b, err = resp.ToByte(); err != nil {
+			return nil
+		}
+	}
+	if network == "udp" && len(b) > msg.UDPSize() {
+		b = truncate(resp, msg.UDPSize())
+	}
+	return b
+}
+
+// exchange 在超时内调用 h, 出错时应答 SERVFAIL. local 通过 WithProxyDestination 传给处理器
+func (s *DNSServer) exchange(h DNSHandler, msg *DNSMessage, network string, addr, local net.Addr) *DNSMessage {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultServerTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if local != nil {
+		ctx = WithProxyDestination(ctx, local)
+	}
+	resp, err := h.ServeDNS(ctx, &DNSRequest{Message: msg, Network: network, RemoteAddr: addr})
+	if err != nil {
+		resp = NewReply(msg)
+		resp.Header.Flags.RCode = DNSRCodeServFail
+	}
+	return resp
+}
+
+// truncate 去掉超过 udp 大小的记录并设置 TC, 保留 OPT 记录
+func truncate(resp *DNSMessage, size int) []byte {
+	t := &DNSMessage{Header: &DNSHeader{}, Questions: resp.Questions}
+	*t.Header = *resp.Header
+	flags := *resp.Header.Flags
+	flags.TC = 1
+	t.Header.Flags = &flags
+	t.Header.AnswerRRs, t.Header.AuthorityRRs, t.Header.AdditionalRRs = 0, 0, 0
+	if opt := resp.OPT(); opt != nil {
+		t.ResourceRecodes = []*DNSResourceRecode{opt}
+		t.Header.AdditionalRRs = 1
+	}
+	b, err := t.ToByte()
+	if err != nil || len(b) > size {
+		t.Questions, t.Header.Questions = nil, 0
+		b, _ = t.ToByte()
+	}
+	return b
+}
@@ -0,0 +1,196 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startDNSServer 在本地随机端口上启动 udp 与 tcp 服务
+func startDNSServer(t testing.TB, s *DNSServer) (udpAddr, tcpAddr string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = ln.Close()
+	})
+	go s.ServeUDP(conn)
+	go s.ServeTCP(ln)
+	return conn.LocalAddr().String(), ln.Addr().String()
+}
+
+// listenSamePort 在 127.0.0.1 的同一端口上监听 udp 与 tcp, 用于 udp 被截断或拒绝后在同一地址改用 tcp 的测试.
+// 内核分配的 udp 端口可能已被 tcp 占用, 因此重试
+func listenSamePort(t testing.TB) (net.PacketConn, net.Listener) {
+	for i := 0; ; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", conn.LocalAddr().String())
+		if err != nil {
+			_ = conn.Close()
+			if i < 10 {
+				continue
+			}
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+			_ = ln.Close()
+		})
+		return conn, ln
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		trace []string
+	)
+	s := &DNSServer{
+		Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			q := req.Message.Questions[0]
+			if q.QuestionName == "fail.example.com" {
+				return nil, ErrInvalidIP
+			}
+			resp := NewReply(req.Message)
+			for i := 0; i < 40; i++ {
+				resp.ResourceRecodes = append(resp.ResourceRecodes, &DNSResourceRecode{
+					Name: q.QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1",
+				})
+				if q.QuestionName != "big.example.com" {
+					break
+				}
+			}
+			resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+			return resp, nil
+		}),
+		Middlewares: []ServerMiddleware{func(next DNSHandler) DNSHandler {
+			return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+				mu.Lock()
+				trace = append(trace, req.Network+" "+req.ClientIP().String())
+				mu.Unlock()
+				return next.ServeDNS(ctx, req)
+			})
+		}},
+	}
+	udpAddr, tcpAddr := startDNSServer(t, s)
+
+	udp := &Resolver{Server: udpAddr}
+	addrs, err := udp.LookupHost(context.Background(), "www.example.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+		t.Fatalf("udp = %v, %v", addrs, err)
+	}
+	if _, err := udp.LookupHost(context.Background(), "fail.example.com"); !strings.Contains(err.Error(), "rcode: 2") {
+		t.Fatalf("fail = %v", err)
+	}
+	resp, err := udp.Query(context.Background(), "big.example.com", DNSTypeA)
+	if err != nil || resp.Header.Flags.TC != 1 || len(resp.Answers()) != 0 {
+		t.Fatalf("big udp = %+v, %v", resp, err)
+	}
+	req := NewQuery("big.example.com", DNSTypeA)
+	req.SetEDNS(4096, nil)
+	if resp, err = udp.Exchange(context.Background(), req); err != nil || len(resp.Answers()) != 40 {
+		t.Fatalf("big edns = %v", err)
+	}
+
+	tcp := &Resolver{Server: tcpAddr, Transport: TCPTransport{}}
+	if resp, err = tcp.Query(context.Background(), "big.example.com", DNSTypeA); err != nil || len(resp.Answers()) != 40 {
+		t.Fatalf("big tcp = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(trace) != 5 || trace[4] != "tcp 127.0.0.1" {
+		t.Fatalf("trace = %v", trace)
+	}
+}
+
+func TestDNSServerShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		started <- struct{}{}
+		<-release
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	udpAddr, tcpAddr := startDNSServer(t, s)
+	idle, err := net.Dial("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	results := make(chan error, 2)
+	for _, r := range []*Resolver{{Server: udpAddr}, {Server: tcpAddr, Transport: TCPTransport{}}} {
+		go func(r *Resolver) {
+			addrs, err := r.LookupHost(context.Background(), "www.example.com")
+			if err == nil && (len(addrs) != 1 || addrs[0] != "192.0.2.1") {
+				err = errors.New("unexpected answer")
+			}
+			results <- err
+		}(r)
+	}
+	<-started
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	// 空闲连接立即关闭
+	_ = idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("idle conn read = %v", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned before requests finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", tcpAddr); err == nil {
+		t.Fatal("listener still open")
+	}
+}
+
+func TestMultipleQuestions(t *testing.T) {
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		return NewReply(req.Message), nil
+	})}
+	udpAddr, _ := startDNSServer(t, s)
+
+	req := NewQuery("a.example.com", DNSTypeA)
+	req.Questions = append(req.Questions, &DNSQuestion{QuestionName: "b.example.com", QuestionType: DNSTypeA, QuestionClass: DNSClassIn})
+	if _, err := req.ToByte(); !errors.Is(err, ErrCountMismatch) {
+		t.Fatalf("count mismatch = %v", err)
+	}
+	req.Header.Questions = 2
+	if _, err := (&Resolver{Server: udpAddr}).Exchange(context.Background(), req); !errors.Is(err, ErrMultipleQuestions) {
+		t.Fatalf("client = %v", err)
+	}
+	resp, err := UDPTransport{}.RoundTrip(context.Background(), udpAddr, req)
+	if err != nil || resp.Header.Flags.RCode != DNSRCodeFormErr || len(resp.Questions) != 0 {
+		t.Fatalf("server = %+v, %v", resp, err)
+	}
+}
@@ -0,0 +1,278 @@
+package netx
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var ErrBadStamp = errors.New("invalid dns stamp")
+
+// StampProtocol DNS stamp 描述的协议, 见 https://dnscrypt.info/stamps-specifications
+type StampProtocol byte
+
+const (
+	StampPlain         StampProtocol = 0x00
+	StampDNSCrypt      StampProtocol = 0x01
+	StampDoH           StampProtocol = 0x02
+	StampDoT           StampProtocol = 0x03
+	StampDoQ           StampProtocol = 0x04
+	StampODoHTarget    StampProtocol = 0x05
+	StampDNSCryptRelay StampProtocol = 0x81
+	StampODoHRelay     StampProtocol = 0x85
+)
+
+// stamp 中服务器声明的属性
+const (
+	StampDNSSEC   uint64 = 1 << 0
+	StampNoLog    uint64 = 1 << 1
+	StampNoFilter uint64 = 1 << 2
+)
+
+// ServerStamp 解析后的 sdns:// 服务器描述, 各协议只使用其中一部分字段
+type ServerStamp struct {
+	Protocol     StampProtocol
+	Props        uint64
+	Addr         string   // ip 或 ip:port, DoH 可以为空
+	PublicKey    []byte   // DNSCrypt 提供者的 Ed25519 公钥
+	ProviderName string   // DNSCrypt 提供者名字, 如 2.dnscrypt-cert.example.com
+	Hashes       [][]byte // 证书链中某个证书 TBS 部分的 SHA256
+	Host         string   // DoH/DoT/ODoH 的主机名, 可以带端口
+	Path         string   // DoH/ODoH 的路径
+	Bootstrap    []string // 解析 Host 使用的服务器
+}
+
+// ParseStamp 解析 sdns:// 形式的 DNS stamp
+func ParseStamp(s string) (*ServerStamp, error) {
+	if !strings.HasPrefix(s, "sdns://") {
+		return nil, errors.WithMessage(ErrBadStamp, "missing sdns:// prefix")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s[len("sdns://"):])
+	if err != nil {
+		return nil, errors.WithMessage(ErrBadStamp, err.Error())
+	}
+	if len(b) < 1 {
+		return nil, ErrBadStamp
+	}
+	st := &ServerStamp{Protocol: StampProtocol(b[0])}
+	r := &stampReader{b: b[1:]}
+	// 只有 DNSCrypt 中继没有属性
+	if st.Protocol != StampDNSCryptRelay {
+		st.Props = r.props()
+	}
+	switch st.Protocol {
+	case StampPlain:
+		st.Addr = string(r.lp())
+	case StampDNSCrypt:
+		st.Addr, st.PublicKey, st.ProviderName = string(r.lp()), r.lp(), string(r.lp())
+	case StampDoH, StampDoT, StampDoQ:
+		st.Addr, st.Hashes, st.Host = string(r.lp()), r.vlp(), string(r.lp())
+		if st.Protocol == StampDoH {
+			st.Path = string(r.lp())
+		}
+		if len(r.b) > 0 {
+			for _, b := range r.vlp() {
+				st.Bootstrap = append(st.Bootstrap, string(b))
+			}
+		}
+	case StampODoHTarget:
+		st.Host, st.Path = string(r.lp()), string(r.lp())
+	case StampDNSCryptRelay:
+		st.Addr = string(r.lp())
+	case StampODoHRelay:
+		st.Addr, st.Hashes, st.Host, st.Path = string(r.lp()), r.vlp(), string(r.lp()), string(r.lp())
+		if len(r.b) > 0 {
+			for _, b := range r.vlp() {
+				st.Bootstrap = append(st.Bootstrap, string(b))
+			}
+		}
+	default:
+		return nil, errors.WithMessagef(ErrBadStamp, "unknown protocol 0x%02x", b[0])
+	}
+	if r.err != nil || len(r.b) != 0 {
+		return nil, errors.WithMessage(ErrBadStamp, "truncated or trailing data")
+	}
+	if st.Protocol == StampDNSCrypt && len(st.PublicKey) != 32 {
+		return nil, errors.WithMessage(ErrBadStamp, "provider key must be 32 bytes")
+	}
+	return st, nil
+}
+
+// String 编码为 sdns:// 形式
+func (st *ServerStamp) String() string {
+	b := []byte{byte(st.Protocol)}
+	props := func() {
+		var p [8]byte
+		binary.LittleEndian.PutUint64(p[:], st.Props)
+		b = append(b, p[:]...)
+	}
+	lp := func(v []byte) {
+		b = append(append(b, byte(len(v))), v...)
+	}
+	vlp := func(vs [][]byte) {
+		if len(vs) == 0 {
+			b = append(b, 0)
+		}
+		for i, v := range vs {
+			n := byte(len(v))
+			if i < len(vs)-1 {
+				n |= 0x80
+			}
+			b = append(append(b, n), v...)
+		}
+	}
+	bootstrap := func() {
+		if len(st.Bootstrap) == 0 {
+			return
+		}
+		var vs [][]byte
+		for _, s := range st.Bootstrap {
+			vs = append(vs, []byte(s))
+		}
+		vlp(vs)
+	}
+	switch st.Protocol {
+	case StampPlain:
+		props()
+		lp([]byte(st.Addr))
+	case StampDNSCrypt:
+		props()
+		lp([]byte(st.Addr))
+		lp(st.PublicKey)
+		lp([]byte(st.ProviderName))
+	case StampDoH, StampDoT, StampDoQ:
+		props()
+		lp([]byte(st.Addr))
+		vlp(st.Hashes)
+		lp([]byte(st.Host))
+		if st.Protocol == StampDoH {
+			lp([]byte(st.Path))
+		}
+		bootstrap()
+	case StampODoHTarget:
+		props()
+		lp([]byte(st.Host))
+		lp([]byte(st.Path))
+	case StampDNSCryptRelay:
+		lp([]byte(st.Addr))
+	case StampODoHRelay:
+		props()
+		lp([]byte(st.Addr))
+		vlp(st.Hashes)
+		lp([]byte(st.Host))
+		lp([]byte(st.Path))
+		bootstrap()
+	}
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ServerAddr Addr 补全默认端口后的 host:port, DNSCrypt 与 DoH 为 443, DoT 为 853, 其它为 53
+func (st *ServerStamp) ServerAddr() string {
+	port := "53"
+	switch st.Protocol {
+	case StampDNSCrypt, StampDoH, StampDNSCryptRelay, StampODoHRelay:
+		port = "443"
+	case StampDoT, StampDoQ:
+		port = "853"
+	}
+	addr := st.Addr
+	if addr == "" {
+		addr = st.Host
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// Resolver 返回使用该服务器的 Resolver, 支持 Plain, DNSCrypt, DoH 与 DoT. config 用于 DoH 与 DoT, 可以为空
+func (st *ServerStamp) Resolver(config *tls.Config) (*Resolver, error) {
+	switch st.Protocol {
+	case StampPlain:
+		return &Resolver{Server: st.ServerAddr()}, nil
+	case StampDNSCrypt:
+		return &Resolver{Server: st.ServerAddr(), Transport: &DNSCryptTransport{ProviderName: st.ProviderName, ProviderKey: st.PublicKey}}, nil
+	case StampDoH:
+		d := &DesignatedResolver{Protocol: "https", Target: hostOnly(st.Host), Port: 443, DoHPath: st.Path}
+		if _, port, err := net.SplitHostPort(st.Host); err == nil {
+			d.Port, _ = strconv.Atoi(port)
+		}
+		addr := hostOnly(st.ServerAddr())
+		return &Resolver{Server: d.Server(addr), Transport: d.Transport(config, addr)}, nil
+	case StampDoT:
+		c := &tls.Config{}
+		if config != nil {
+			c = config.Clone()
+		}
+		if c.ServerName == "" {
+			c.ServerName = hostOnly(st.Host)
+		}
+		return &Resolver{Server: st.ServerAddr(), Transport: TCPTransport{Dialer: ChainDialer(nil, WithTLS(c))}}, nil
+	}
+	return nil, errors.WithMessagef(ErrBadStamp, "unsupported protocol 0x%02x", byte(st.Protocol))
+}
+
+// hostOnly 去掉可能存在的端口与 IPv6 的方括号
+func hostOnly(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
+
+type stampReader struct {
+	b   []byte
+	err error
+}
+
+func (r *stampReader) props() uint64 {
+	if len(r.b) < 8 {
+		r.err = ErrBadStamp
+		r.b = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+// lp 一个字节长度加内容
+func (r *stampReader) lp() []byte {
+	if len(r.b) < 1 || len(r.b) < 1+int(r.b[0]) {
+		r.err = ErrBadStamp
+		r.b = nil
+		return nil
+	}
+	v := r.b[1 : 1+int(r.b[0])]
+	r.b = r.b[1+len(v):]
+	return v
+}
+
+// vlp 一组 lp, 除最后一个外长度字节的最高位为 1
+func (r *stampReader) vlp() [][]byte {
+	var vs [][]byte
+	for {
+		if len(r.b) < 1 {
+			r.err = ErrBadStamp
+			return vs
+		}
+		more := r.b[0]&0x80 != 0
+		n := int(r.b[0] & 0x7f)
+		if len(r.b) < 1+n {
+			r.err = ErrBadStamp
+			r.b = nil
+			return vs
+		}
+		if n > 0 {
+			vs = append(vs, r.b[1:1+n])
+		}
+		r.b = r.b[1+n:]
+		if !more {
+			return vs
+		}
+	}
+}
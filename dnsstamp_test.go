@@ -0,0 +1,48 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestParseStamp(t *testing.T) {
+	// dnscrypt-resolvers 列表中 Cloudflare 的 DoH stamp
+	const cloudflare = "sdns://AgcAAAAAAAAABzEuMC4wLjEAEmRucy5jbG91ZGZsYXJlLmNvbQovZG5zLXF1ZXJ5"
+	st, err := ParseStamp(cloudflare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Protocol != StampDoH || st.Props != StampDNSSEC|StampNoLog|StampNoFilter || st.Addr != "1.0.0.1" ||
+		st.Host != "dns.cloudflare.com" || st.Path != "/dns-query" || len(st.Hashes) != 0 {
+		t.Fatalf("stamp = %+v", st)
+	}
+	if st.String() != cloudflare {
+		t.Fatalf("encode = %s", st.String())
+	}
+	r, err := st.Resolver(nil)
+	if err != nil || r.Server != "https://dns.cloudflare.com:443/dns-query" {
+		t.Fatalf("resolver = %+v, %v", r, err)
+	}
+
+	for _, st := range []*ServerStamp{
+		{Protocol: StampPlain, Props: StampDNSSEC, Addr: "9.9.9.9"},
+		{Protocol: StampDNSCrypt, Props: StampNoLog, Addr: "[2001:db8::1]:8443", PublicKey: make([]byte, 32), ProviderName: "2.dnscrypt-cert.example.com"},
+		{Protocol: StampDoT, Addr: "192.0.2.1", Hashes: [][]byte{make([]byte, 32), {1, 2, 3}}, Host: "dot.example.com", Bootstrap: []string{"9.9.9.9", "1.1.1.1"}},
+		{Protocol: StampDNSCryptRelay, Addr: "192.0.2.2:443"},
+		{Protocol: StampODoHTarget, Host: "odoh.example.com", Path: "/dns-query"},
+	} {
+		got, err := ParseStamp(st.String())
+		if err != nil || got.String() != st.String() || got.Addr != st.Addr || got.Host != st.Host || len(got.Hashes) != len(st.Hashes) || len(got.Bootstrap) != len(st.Bootstrap) {
+			t.Fatalf("round trip %+v = %+v, %v", st, got, err)
+		}
+	}
+	if addr := (&ServerStamp{Protocol: StampDNSCrypt, Addr: "[2001:db8::1]"}).ServerAddr(); addr != "[2001:db8::1]:443" {
+		t.Fatalf("addr = %s", addr)
+	}
+
+	for _, bad := range []string{"https://x", "sdns://!!", "sdns://AQ", "sdns://" + "Dw", (&ServerStamp{Protocol: StampDNSCrypt, PublicKey: []byte{1}}).String()} {
+		if _, err := ParseStamp(bad); !errors.Is(err, ErrBadStamp) {
+			t.Fatalf("%s: %v", bad, err)
+		}
+	}
+}
@@ -3,122 +3,458 @@ package netx
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"github.com/pkg/errors"
 	"net"
+	"strconv"
 	"strings"
 )
 
-func NewDNSMessage(buffer *bytes.Buffer) (*DNSMessage, error) {
-	// 用bytes.Buffer类型来逐个字节读取后处理的优点就是不需要自己计算读取偏移值
-	// 这个对于Question和Answer这种第一段长度不固定的内容处理非常方便
-	id := binary.BigEndian.Uint16(buffer.Next(2))
-	flag := binary.BigEndian.Uint16(buffer.Next(2))
-	dnsMsg := &DNSMessage{
-		Header: &DNSHeader{
-			TxID: id,
-			Flags: &DNSFlags{
-				QR:     flag >> 15,
-				OpCode: (flag >> 11) % (1 << 4),
-				AA:     (flag >> 10) % (1 << 1),
-				TC:     (flag >> 9) % (1 << 1),
-				RD:     (flag >> 8) % (1 << 1),
-				RA:     (flag >> 7) % (1 << 1),
-				Z:      (flag >> 4) % (1 << 3),
-				RCode:  flag % (1 << 4),
-			},
-			Questions:     binary.BigEndian.Uint16(buffer.Next(2)),
-			AnswerRRs:     binary.BigEndian.Uint16(buffer.Next(2)),
-			AuthorityRRs:  binary.BigEndian.Uint16(buffer.Next(2)),
-			AdditionalRRs: binary.BigEndian.Uint16(buffer.Next(2)),
-		},
-	}
-
-	length := uint8(0)
-	if err := binary.Read(buffer, binary.BigEndian, &length); err != nil {
-		return nil, errors.WithMessage(err, "read length")
-	}
-	var segments []string
-	for length > 0 {
-		seg := make([]byte, length)
-		if err := binary.Read(buffer, binary.BigEndian, &seg); err != nil {
-			return nil, errors.WithMessage(err, "read seg")
-		}
-		segments = append(segments, string(seg))
-		if err := binary.Read(buffer, binary.BigEndian, &length); err != nil {
-			return nil, errors.WithMessage(err, "read length")
-		}
-	}
-
-	question := &DNSQuestion{
-		QuestionName: strings.Join(segments, "."),
-	}
-	question.QuestionType = binary.BigEndian.Uint16(buffer.Next(2))
-	question.QuestionClass = binary.BigEndian.Uint16(buffer.Next(2))
-	dnsMsg.Questions = append(dnsMsg.Questions, question)
-	if buffer.Len() > 0 {
-		recode, err := NewDNSResourceRecode(buffer)
-		if err != nil {
-			return nil, err
+const defaultMaxPointerJumps = 64
+
+var (
+	ErrShortBuffer  = errors.New("insufficient data for message")
+	ErrBadPointer   = errors.New("bad compression pointer")
+	ErrBadLabelType = errors.New("unsupported label type")
+	ErrBadRData     = errors.New("rdata length mismatch")
+)
+
+// unpacker 在完整报文上按偏移读取, 以便解析压缩指针
+type unpacker struct {
+	msg []byte
+	off int
+	// partial 为 true 时 msg 不是完整报文, 无法解析的压缩指针只记录位置
+	partial bool
+	// opts 为空时使用默认模式
+	opts     *UnpackOptions
+	warnings []*UnpackWarning
+	// expanded 已展开的名字总长度, 与解析 RDATA 的 unpacker 共用. 没有限制时为空
+	expanded *int
+}
+
+func (u *unpacker) next(n int) ([]byte, error) {
+	if n < 0 || u.off+n > len(u.msg) {
+		return nil, ErrShortBuffer
+	}
+	b := u.msg[u.off : u.off+n]
+	u.off += n
+	return b, nil
+}
+
+func (u *unpacker) uint16() (uint16, error) {
+	b, err := u.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (u *unpacker) uint32() (uint32, error) {
+	b, err := u.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// name 读取一个可能被压缩的域名. 当域名仅由一个压缩指针构成时 pos 为指针的偏移
+func (u *unpacker) name() (name string, pos uint16, err error) {
+	var sb strings.Builder
+	off := u.off
+	// 指针只能向前跳转, 以此防止循环
+	limit := u.off
+	jumped := false
+	jumps := 0
+	total := 1
+	for {
+		if off >= len(u.msg) {
+			return "", 0, ErrShortBuffer
+		}
+		c := int(u.msg[off])
+		off++
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				if err := u.expand(total); err != nil {
+					return "", 0, err
+				}
+				if !jumped {
+					u.off = off
+				}
+				return sb.String(), pos, nil
+			}
+			if off+c > len(u.msg) {
+				return "", 0, ErrShortBuffer
+			}
+			total += c + 1
+			if total > maxNameLength {
+				return "", 0, ErrNameTooLong
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte('.')
+			}
+			escapeLabel(&sb, u.msg[off:off+c])
+			off += c
+		case 0xC0:
+			if off >= len(u.msg) {
+				return "", 0, ErrShortBuffer
+			}
+			ptr := (c&0x3F)<<8 | int(u.msg[off])
+			off++
+			if !jumped {
+				u.off = off
+				if sb.Len() == 0 {
+					pos = uint16(ptr)
+				}
+			}
+			if u.partial {
+				return sb.String(), pos, nil
+			}
+			jumped = true
+			jumps++
+			if ptr >= limit {
+				return "", 0, ErrBadPointer
+			}
+			if max := u.maxPointerJumps(); jumps > max {
+				return "", 0, &LimitError{Limit: "pointer jumps", Max: max}
+			}
+			limit = ptr
+			off = ptr
+		default:
+			return "", 0, ErrBadLabelType
 		}
-		dnsMsg.ResourceRecodes = append(dnsMsg.ResourceRecodes, recode)
 	}
+}
 
-	return dnsMsg, nil
+// skipName 跳过一个域名而不解析, 遇到压缩指针时在指针之后结束
+func (u *unpacker) skipName() error {
+	for {
+		if u.off >= len(u.msg) {
+			return ErrShortBuffer
+		}
+		c := int(u.msg[u.off])
+		u.off++
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				return nil
+			}
+			if _, err := u.next(c); err != nil {
+				return err
+			}
+		case 0xC0:
+			_, err := u.next(1)
+			return err
+		default:
+			return ErrBadLabelType
+		}
+	}
 }
 
-func NewDNSResourceRecode(buffer *bytes.Buffer) (*DNSResourceRecode, error) {
-	tag := buffer.Next(1)[0] >> 6
-	if err := buffer.UnreadByte(); err != nil {
+func (u *unpacker) header() (*DNSHeader, error) {
+	h := &DNSHeader{Flags: &DNSFlags{}}
+	if err := u.headerInto(h); err != nil {
 		return nil, err
 	}
-	r := &DNSResourceRecode{}
-	if tag == 3 {
-		// 最高两位11，右移后是3
-		r.NamePos = (binary.BigEndian.Uint16(buffer.Next(2)) << 2) >> 2
+	return h, nil
+}
+
+// headerInto 将头部读入 h, h.Flags 不能为空
+func (u *unpacker) headerInto(h *DNSHeader) error {
+	var fields [6]uint16
+	for i := range fields {
+		v, err := u.uint16()
+		if err != nil {
+			return errors.WithMessage(err, "read header")
+		}
+		fields[i] = v
+	}
+	flags := h.Flags
+	*h = DNSHeader{
+		TxID:          fields[0],
+		Flags:         flags,
+		Questions:     fields[2],
+		AnswerRRs:     fields[3],
+		AuthorityRRs:  fields[4],
+		AdditionalRRs: fields[5],
+	}
+	*flags = *newDNSFlags(fields[1])
+	return nil
+}
+
+func (u *unpacker) question() (*DNSQuestion, error) {
+	question := &DNSQuestion{}
+	if err := u.questionInto(question); err != nil {
+		return nil, err
 	}
-	r.RRType = binary.BigEndian.Uint16(buffer.Next(2))
-	r.Class = binary.BigEndian.Uint16(buffer.Next(2))
-	r.TTL = binary.BigEndian.Uint32(buffer.Next(4))
-	r.RDLength = binary.BigEndian.Uint16(buffer.Next(2))
+	return question, nil
+}
 
-	if r.RRType == 1 && r.RDLength == 4 {
-		r.RData = net.IPv4(buffer.Next(1)[0], buffer.Next(1)[0], buffer.Next(1)[0], buffer.Next(1)[0]).String()
-	} else {
-		fmt.Println("[rdata]", string(buffer.Next(int(r.RDLength))))
-		// FIXME:
-		// 域名处理
+func (u *unpacker) questionInto(question *DNSQuestion) error {
+	var err error
+	if question.QuestionName, _, err = u.name(); err != nil {
+		return errors.WithMessage(err, "read question name")
+	}
+	if question.QuestionType, err = u.uint16(); err != nil {
+		return errors.WithMessage(err, "read question type")
+	}
+	if question.QuestionClass, err = u.uint16(); err != nil {
+		return errors.WithMessage(err, "read question class")
 	}
+	return nil
+}
 
+func (u *unpacker) resource() (*DNSResourceRecode, error) {
+	r := &DNSResourceRecode{}
+	if err := u.resourceInto(r); err != nil {
+		return nil, err
+	}
 	return r, nil
 }
 
-func NewDNSQuestion(buffer *bytes.Buffer) (*DNSQuestion, error) {
-	// 8bit标记每一级域名的长度
-	// buf := bytes.NewBuffer(data)
-	length := uint8(0)
-	if err := binary.Read(buffer, binary.BigEndian, &length); err != nil {
-		return nil, errors.WithMessage(err, "read length")
+// resourceInto 将一条记录读入 r, r 中原有的 Data 容量会被复用
+func (u *unpacker) resourceInto(r *DNSResourceRecode) error {
+	data := r.Data
+	*r = DNSResourceRecode{}
+	var err error
+	if r.Name, r.NamePos, err = u.name(); err != nil {
+		return errors.WithMessage(err, "read resource name")
+	}
+	if !u.partial {
+		// 完整报文中名字已经展开, 压缩位置只在编码时有意义
+		r.NamePos = 0
+	}
+	if r.RRType, err = u.uint16(); err != nil {
+		return errors.WithMessage(err, "read RRType")
+	}
+	if r.Class, err = u.uint16(); err != nil {
+		return errors.WithMessage(err, "read Class")
+	}
+	if r.TTL, err = u.uint32(); err != nil {
+		return errors.WithMessage(err, "read TTL")
+	}
+	if r.RDLength, err = u.uint16(); err != nil {
+		return errors.WithMessage(err, "read RDLength")
+	}
+	if max := u.opts.maxRDataLength(); max > 0 && int(r.RDLength) > max {
+		return &LimitError{Limit: "rdata length", Max: max}
+	}
+	start := u.off
+	rdata, err := u.next(int(r.RDLength))
+	if err != nil {
+		return errors.WithMessage(err, "read RData")
+	}
+
+	if u.mode() == UnpackStrict && (r.RRType == DNSTypeA && len(rdata) != net.IPv4len || r.RRType == DNSTypeAAAA && len(rdata) != net.IPv6len) {
+		return errors.WithMessagef(ErrBadRData, "type %d length %d", r.RRType, len(rdata))
+	}
+	rdataString, ok, err := u.rdataString(r.RRType, start, rdata)
+	switch {
+	case err != nil && (u.mode() != UnpackLenient || errors.Is(err, ErrLimitExceeded)):
+		return err
+	case err != nil:
+		u.warn(start, errors.WithMessage(err, "keep raw rdata"))
+	case ok:
+		r.RData = rdataString
+		return nil
 	}
-	var segments []string
-	for length > 0 {
-		seg := make([]byte, length)
-		if err := binary.Read(buffer, binary.BigEndian, &seg); err != nil {
-			return nil, errors.WithMessage(err, "read seg")
+	if data == nil {
+		data = []byte{}
+	}
+	r.Data = append(data[:0], rdata...)
+	return nil
+}
+
+// rdataString 将已知类型的 RDATA 解析为 RData 字符串, 其它类型返回 false, 保留原始数据
+func (u *unpacker) rdataString(rrtype uint16, start int, rdata []byte) (string, bool, error) {
+	switch {
+	case len(rdata) == 0:
+		// 动态更新 (RFC 2136) 中删除 rrset 的记录没有 RDATA
+		return "", false, nil
+	case rrtype == DNSTypeA && len(rdata) == net.IPv4len:
+		return net.IP(rdata).String(), true, nil
+	case rrtype == DNSTypeAAAA && len(rdata) == net.IPv6len:
+		return net.IP(rdata).String(), true, nil
+	case isNameType(rrtype) && !u.partial:
+		sub := u.sub(start, len(rdata))
+		name, _, err := sub.name()
+		if err != nil {
+			return "", false, errors.WithMessage(err, "read RData name")
+		}
+		if sub.off != start+len(rdata) {
+			return "", false, ErrBadRData
+		}
+		return name, true, nil
+	case rrtype == DNSTypeMX && len(rdata) > 2 && !u.partial:
+		sub := u.sub(start, len(rdata))
+		sub.off += 2
+		host, _, err := sub.name()
+		if err != nil {
+			return "", false, errors.WithMessage(err, "read MX exchange")
+		}
+		if sub.off != start+len(rdata) {
+			return "", false, ErrBadRData
+		}
+		return strconv.Itoa(int(binary.BigEndian.Uint16(rdata))) + " " + rootName(host), true, nil
+	case rrtype == DNSTypeSOA && !u.partial:
+		sub := u.sub(start, len(rdata))
+		mname, _, err := sub.name()
+		if err != nil {
+			return "", false, errors.WithMessage(err, "read SOA mname")
+		}
+		rname, _, err := sub.name()
+		if err != nil {
+			return "", false, errors.WithMessage(err, "read SOA rname")
+		}
+		fields := []string{rootName(mname), rootName(rname)}
+		for i := 0; i < 5; i++ {
+			v, err := sub.uint32()
+			if err != nil {
+				return "", false, errors.WithMessage(err, "read SOA timers")
+			}
+			fields = append(fields, strconv.FormatUint(uint64(v), 10))
+		}
+		if sub.off != start+len(rdata) {
+			return "", false, ErrBadRData
+		}
+		return strings.Join(fields, " "), true, nil
+	case rrtype == DNSTypeSRV && len(rdata) > 6 && !u.partial:
+		sub := u.sub(start, len(rdata))
+		sub.off += 6
+		target, _, err := sub.name()
+		if err != nil {
+			return "", false, errors.WithMessage(err, "read SRV target")
+		}
+		if sub.off != start+len(rdata) {
+			return "", false, ErrBadRData
+		}
+		return strconv.Itoa(int(binary.BigEndian.Uint16(rdata))) + " " +
+			strconv.Itoa(int(binary.BigEndian.Uint16(rdata[2:]))) + " " +
+			strconv.Itoa(int(binary.BigEndian.Uint16(rdata[4:]))) + " " + rootName(target), true, nil
+	}
+	return "", false, nil
+}
+
+// sub 返回只能读到 start+length 的 unpacker, 用于解析 RDATA 中的域名
+func (u *unpacker) sub(start, length int) *unpacker {
+	return &unpacker{msg: u.msg[:start+length], off: start, opts: u.opts, expanded: u.expanded}
+}
+
+// rootName 多字段的 RData 中根域名写作 ".", 避免出现空字段
+func rootName(name string) string {
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// isNameType RDATA 只包含一个域名的类型
+func isNameType(t uint16) bool {
+	return t == DNSTypeNS || t == DNSTypeCName || t == DNSTypePTR
+}
+
+func newDNSFlags(flag uint16) *DNSFlags {
+	return &DNSFlags{
+		QR:     flag >> 15,
+		OpCode: (flag >> 11) % (1 << 4),
+		AA:     (flag >> 10) % (1 << 1),
+		TC:     (flag >> 9) % (1 << 1),
+		RD:     (flag >> 8) % (1 << 1),
+		RA:     (flag >> 7) % (1 << 1),
+		Z:      (flag >> 4) % (1 << 3),
+		RCode:  flag % (1 << 4),
+	}
+}
+
+// Unpack 解析一个完整的 DNS 报文, 任意输入都只会返回错误而不会 panic
+func Unpack(data []byte) (*DNSMessage, error) {
+	m, _, err := (*UnpackOptions)(nil).Unpack(data)
+	return m, err
+}
+
+// message 将完整报文解析到 m, newQuestion 与 newRecord 提供用于填充的空问题与记录
+func (u *unpacker) message(m *DNSMessage, newQuestion func() *DNSQuestion, newRecord func() *DNSResourceRecode) error {
+	if max := u.opts.maxMessageSize(); max > 0 && len(u.msg) > max {
+		return &LimitError{Limit: "message size", Max: max}
+	}
+	h := m.Header
+	if err := u.headerInto(h); err != nil {
+		return err
+	}
+	if max := u.opts.maxRecords(); max > 0 && int(h.Questions)+int(h.AnswerRRs)+int(h.AuthorityRRs)+int(h.AdditionalRRs) > max {
+		return &LimitError{Limit: "records", Max: max}
+	}
+	for i := uint16(0); i < h.Questions; i++ {
+		start := u.off
+		q := newQuestion()
+		if err := u.questionInto(q); err != nil {
+			if u.mode() != UnpackLenient || errors.Is(err, ErrLimitExceeded) {
+				return err
+			}
+			u.warn(start, errors.WithMessagef(err, "keep %d of %d questions, drop all records", i, h.Questions))
+			h.Questions, h.AnswerRRs, h.AuthorityRRs, h.AdditionalRRs = i, 0, 0, 0
+			return nil
 		}
-		segments = append(segments, string(seg))
-		if err := binary.Read(buffer, binary.BigEndian, &length); err != nil {
-			return nil, errors.WithMessage(err, "read length")
+		m.Questions = append(m.Questions, q)
+	}
+	total := int(h.AnswerRRs) + int(h.AuthorityRRs) + int(h.AdditionalRRs)
+	for i := 0; i < total; i++ {
+		start := u.off
+		rr := newRecord()
+		if err := u.resourceInto(rr); err != nil {
+			if u.mode() != UnpackLenient || errors.Is(err, ErrLimitExceeded) {
+				return err
+			}
+			u.warn(start, errors.WithMessagef(err, "keep %d of %d records", i, total))
+			trimCounts(h, i)
+			return nil
 		}
+		m.ResourceRecodes = append(m.ResourceRecodes, rr)
 	}
+	if trailing := len(u.msg) - u.off; trailing > 0 {
+		switch u.mode() {
+		case UnpackStrict:
+			return errors.WithMessagef(ErrTrailingData, "%d bytes", trailing)
+		case UnpackLenient:
+			u.warn(u.off, errors.WithMessagef(ErrTrailingData, "%d bytes", trailing))
+		}
+	}
+	return nil
+}
 
-	question := &DNSQuestion{
-		QuestionName: strings.Join(segments, "."),
+// trimCounts 只保留前 n 条记录时调整各部分的计数
+func trimCounts(h *DNSHeader, n int) {
+	counts := []*uint16{&h.AnswerRRs, &h.AuthorityRRs, &h.AdditionalRRs}
+	for _, c := range counts {
+		if int(*c) > n {
+			*c = uint16(n)
+		}
+		n -= int(*c)
 	}
+}
 
-	question.QuestionType = binary.BigEndian.Uint16(buffer.Next(2))
-	question.QuestionClass = binary.BigEndian.Uint16(buffer.Next(2))
+func NewDNSMessage(buffer *bytes.Buffer) (*DNSMessage, error) {
+	return Unpack(buffer.Next(buffer.Len()))
+}
 
+// NewDNSResourceRecode 从不完整的报文中读取一条记录, 压缩指针无法展开时只记录 NamePos
+func NewDNSResourceRecode(buffer *bytes.Buffer) (*DNSResourceRecode, error) {
+	u := &unpacker{msg: buffer.Bytes(), partial: true}
+	r, err := u.resource()
+	if err != nil {
+		return nil, err
+	}
+	buffer.Next(u.off)
+	return r, nil
+}
+
+func NewDNSQuestion(buffer *bytes.Buffer) (*DNSQuestion, error) {
+	// 8bit标记每一级域名的长度
+	u := &unpacker{msg: buffer.Bytes(), partial: true}
+	question, err := u.question()
+	if err != nil {
+		return nil, err
+	}
+	buffer.Next(u.off)
 	return question, nil
 }
 
@@ -126,17 +462,8 @@ func NewDNSHeader(buffer *bytes.Buffer) *DNSHeader {
 	id := binary.BigEndian.Uint16(buffer.Next(2))
 	flag := binary.BigEndian.Uint16(buffer.Next(2))
 	return &DNSHeader{
-		TxID: id,
-		Flags: &DNSFlags{
-			QR:     flag >> 15,
-			OpCode: (flag >> 11) % (1 << 4),
-			AA:     (flag >> 10) % (1 << 1),
-			TC:     (flag >> 9) % (1 << 1),
-			RD:     (flag >> 8) % (1 << 1),
-			RA:     (flag >> 7) % (1 << 1),
-			Z:      (flag >> 4) % (1 << 3),
-			RCode:  flag % (1 << 4),
-		},
+		TxID:          id,
+		Flags:         newDNSFlags(flag),
 		Questions:     binary.BigEndian.Uint16(buffer.Next(2)),
 		AnswerRRs:     binary.BigEndian.Uint16(buffer.Next(2)),
 		AuthorityRRs:  binary.BigEndian.Uint16(buffer.Next(2)),
@@ -0,0 +1,147 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+)
+
+const dnsMessageMIME = "application/dns-message"
+
+var ErrDoHStatus = errors.New("unexpected doh response status")
+
+// HTTPSTransport 通过 HTTPS 发送请求 (DNS over HTTPS, RFC 8484), server 为完整的 URL,
+// 例如 https://cloudflare-dns.com/dns-query
+type HTTPSTransport struct {
+	// Client 为空时使用共享的默认客户端, 与 http.DefaultClient 相同但缓存 TLS 会话票据
+	Client *http.Client
+	// HTTP3 为 HTTP/3 (QUIC) 的 RoundTripper, 例如 quic-go 的 http3.RoundTripper. 设置后, 对通过 Alt-Svc
+	// 声明了 h3 的服务器改用 HTTP/3, 出错或状态码不是 2xx 时在本次请求中退回 Client, 并在一段时间内不再尝试
+	HTTP3 http.RoundTripper
+	// AltSvc 记录服务器的 Alt-Svc 声明, 为空时使用共享的缓存
+	AltSvc *AltSvcCache
+}
+
+var defaultDoHClient = &http.Client{Transport: func() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	return t
+}()}
+
+func (t HTTPSTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	toByte, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, server, nil)
+	if err != nil {
+		return nil, err
+	}
+	info := queryInfoFrom(ctx)
+	info.attempt("https")
+	if info != nil {
+		ctx = httptrace.WithClientTrace(ctx, info.httpTrace())
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", dnsMessageMIME)
+	httpReq.Header.Set("Accept", dnsMessageMIME)
+	httpResp, err := t.do(httpReq, toByte)
+	if err != nil {
+		return nil, errors.WithMessage(err, "https request error")
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(ErrDoHStatus, httpResp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, 0xFFFF))
+	if err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	resp, err := UnpackPooled(body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.TxID != req.Header.TxID {
+		resp.Release()
+		return nil, ErrTxIDMismatch
+	}
+	return resp, nil
+}
+
+// do 发送请求, 服务器声明了 h3 且设置了 HTTP3 时先尝试 HTTP/3, 出错或状态码不是 2xx 时退回 Client
+func (t HTTPSTransport) do(httpReq *http.Request, body []byte) (*http.Response, error) {
+	cache := t.AltSvc
+	if cache == nil {
+		cache = defaultAltSvcCache
+	}
+	origin := httpReq.URL.Scheme + "://" + httpReq.URL.Host
+	if port, ok := cache.HTTP3Port(origin, time.Now()); ok && t.HTTP3 != nil && httpReq.URL.Scheme == "https" {
+		h3Req := httpReq.Clone(httpReq.Context())
+		h3Req.URL.Host = net.JoinHostPort(httpReq.URL.Hostname(), strconv.Itoa(port))
+		h3Req.Host = httpReq.URL.Host
+		setBody(h3Req, body)
+		resp, err := t.HTTP3.RoundTrip(h3Req)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			cache.Observe(origin, resp.Header.Get("Alt-Svc"), time.Now())
+			return resp, nil
+		}
+		if err == nil {
+			// HTTP/3 端点可达但不能正常应答 (例如 5xx), 同样退回 Client
+			resp.Body.Close()
+		} else if httpReq.Context().Err() != nil {
+			return nil, err
+		}
+		cache.MarkBroken(origin, time.Now(), altSvcBrokenTime)
+	}
+	client := t.Client
+	if client == nil {
+		client = defaultDoHClient
+	}
+	setBody(httpReq, body)
+	resp, err := client.Do(httpReq)
+	if err == nil {
+		cache.Observe(origin, resp.Header.Get("Alt-Svc"), time.Now())
+	}
+	return resp, err
+}
+
+func setBody(r *http.Request, body []byte) {
+	r.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(body)), nil }
+	r.Body, _ = r.GetBody()
+	r.ContentLength = int64(len(body))
+}
+
+// httpTrace 把 http 请求的各个阶段记录到 i 中, 连接阶段包括 TLS 握手
+func (i *QueryInfo) httpTrace() *httptrace.ClientTrace {
+	var dnsStart, connectStart, writeStart time.Time
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { i.Resolve = time.Since(dnsStart) },
+		ConnectStart: func(string, string) {
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		GotConn: func(c httptrace.GotConnInfo) {
+			i.Reused = c.Reused
+			if tc, ok := c.Conn.(*tls.Conn); ok && !c.Reused {
+				i.Resumed = tc.ConnectionState().DidResume
+			}
+			if !connectStart.IsZero() {
+				i.Connect = time.Since(connectStart)
+			}
+			i.Server = c.Conn.RemoteAddr().String()
+			writeStart = time.Now()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { i.wrote(writeStart) },
+		GotFirstResponseByte: func() { i.firstByte() },
+	}
+}
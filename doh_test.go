@@ -0,0 +1,51 @@
+package netx
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageMIME {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := Unpack(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := NewReply(req)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.7"}}
+		resp.Header.AnswerRRs = 1
+		toByte, _ := resp.ToByte()
+		w.Header().Set("Content-Type", dnsMessageMIME)
+		_, _ = w.Write(toByte)
+	}))
+	defer srv.Close()
+
+	r := &Resolver{Server: srv.URL + "/dns-query", Transport: HTTPSTransport{Client: srv.Client()}}
+	ips, err := r.LookupHost(context.Background(), "www.example.com")
+	if err != nil || len(ips) != 1 || ips[0] != "192.0.2.7" {
+		t.Fatalf("LookupHost = %v, %v", ips, err)
+	}
+	info := &QueryInfo{}
+	resp, err := r.Query(WithQueryInfo(context.Background(), info), "www.example.com", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Release()
+	if info.Transport != "https" || !info.Reused || info.Server != srv.Listener.Addr().String() || info.FirstByte <= 0 {
+		t.Fatalf("info = %+v", info)
+	}
+
+	r.Transport = HTTPSTransport{}
+	if _, err := r.Query(context.Background(), "www.example.com", DNSTypeA); err == nil {
+		t.Fatal("untrusted certificate accepted")
+	}
+}
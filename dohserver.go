@@ -0,0 +1,195 @@
+package netx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const dnsJSONMIME = "application/dns-json"
+
+// dohJSONQuestion 与 dohJSONRecord 为 JSON 接口的格式, 与 Google 和 Cloudflare 的 dns-json 相同
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dohJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type dohJSONResponse struct {
+	Status     uint16            `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AD         bool              `json:"AD"`
+	CD         bool              `json:"CD"`
+	Question   []dohJSONQuestion `json:"Question"`
+	Answer     []dohJSONRecord   `json:"Answer,omitempty"`
+	Authority  []dohJSONRecord   `json:"Authority,omitempty"`
+	Additional []dohJSONRecord   `json:"Additional,omitempty"`
+}
+
+// ServeHTTP 提供 DNS over HTTPS: RFC 8484 的 GET (dns 参数) 与 POST (application/dns-message),
+// 以及 GET ?name=&type= 的 application/dns-json 接口, 可选参数 do=1 与 cd=1. 请求同样经过 Middlewares,
+// DNSRequest.Network 为 "https". 挂在 http.Server 的某个路径上使用, 例如 /dns-query
+func (s *DNSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var packet []byte
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("dns") != "":
+		b, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil {
+			http.Error(w, "bad dns parameter", http.StatusBadRequest)
+			return
+		}
+		packet = b
+	case r.Method == http.MethodGet && r.URL.Query().Get("name") != "":
+		s.serveJSON(w, r)
+		return
+	case r.Method == http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != dnsMessageMIME {
+			http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+			return
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, 0xFFFF+1))
+		if err != nil {
+			return
+		}
+		if len(b) > 0xFFFF {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		packet = b
+	case r.Method == http.MethodGet:
+		http.Error(w, "missing dns or name parameter", http.StatusBadRequest)
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b := s.serve(s.handler(), packet, "https", httpRemoteAddr(r), httpLocalAddr(r))
+	if b == nil {
+		http.Error(w, "no response", http.StatusBadRequest)
+		return
+	}
+	// RFC 8484 5.1: 缓存时间不超过记录的最小 TTL
+	if resp, err := Unpack(b); err == nil {
+		if ttl, ok := minTTL(resp); ok {
+			w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+		}
+	}
+	w.Header().Set("Content-Type", dnsMessageMIME)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	_, _ = w.Write(b)
+}
+
+// serveJSON 处理 application/dns-json 请求, type 可以是助记符或数字, 默认 A
+func (s *DNSServer) serveJSON(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	qtype := DNSType(DNSTypeA)
+	if t := q.Get("type"); t != "" {
+		parsed, err := ParseType(t)
+		if n, nerr := strconv.ParseUint(t, 10, 16); nerr == nil {
+			parsed, err = DNSType(n), nil
+		}
+		if err != nil || parsed == DNSTypeOPT {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown type " + t})
+			return
+		}
+		qtype = parsed
+	}
+	if len(name) > 253 || strings.Contains(name, "..") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid name"})
+		return
+	}
+	var opts []QueryOption
+	if jsonFlag(q.Get("do")) {
+		opts = append(opts, WithDNSSECOK())
+	}
+	if jsonFlag(q.Get("cd")) {
+		opts = append(opts, WithCheckingDisabled())
+	}
+	msg := NewQuery(normalizeDomain(name), uint16(qtype), opts...)
+	resp := s.exchange(s.handler(), msg, "https", httpRemoteAddr(r), httpLocalAddr(r))
+	if resp == nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "no response"})
+		return
+	}
+	out := dohJSONResponse{
+		Status:   resp.Header.Flags.RCode,
+		TC:       resp.Header.Flags.TC != 0,
+		RD:       resp.Header.Flags.RD != 0,
+		RA:       resp.Header.Flags.RA != 0,
+		AD:       resp.Header.Flags.AuthenticData(),
+		CD:       resp.Header.Flags.CheckingDisabled(),
+		Question: []dohJSONQuestion{{Name: absoluteName(normalizeDomain(name)), Type: uint16(qtype)}},
+	}
+	convert := func(rrs []*DNSResourceRecode) []dohJSONRecord {
+		var records []dohJSONRecord
+		for _, rr := range rrs {
+			if rr.RRType == DNSTypeOPT {
+				continue
+			}
+			records = append(records, dohJSONRecord{Name: absoluteName(rr.Name), Type: rr.RRType, TTL: rr.TTL, Data: rdataText(rr)})
+		}
+		return records
+	}
+	out.Answer, out.Authority, out.Additional = convert(resp.Answers()), convert(resp.Authorities()), convert(resp.Additionals())
+	if ttl, ok := minTTL(resp); ok {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", dnsJSONMIME)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// jsonFlag dns-json 的布尔参数, 1 或 true 为真
+func jsonFlag(s string) bool {
+	return s == "1" || strings.EqualFold(s, "true")
+}
+
+// minTTL 响应中除 OPT 外记录的最小 TTL, 没有记录时返回 false
+func minTTL(m *DNSMessage) (uint32, bool) {
+	var ttl uint32
+	found := false
+	for _, rr := range m.ResourceRecodes {
+		if rr.RRType == DNSTypeOPT {
+			continue
+		}
+		if !found || rr.TTL < ttl {
+			ttl, found = rr.TTL, true
+		}
+	}
+	return ttl, found
+}
+
+// httpRemoteAddr 返回 http 请求的来源地址, 无法解析时返回空
+// httpLocalAddr 返回接受 r 的本地地址, 不是 http.Server 接受的请求时返回空
+func httpLocalAddr(r *http.Request) net.Addr {
+	addr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+func httpRemoteAddr(r *http.Request) net.Addr {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: ip, Port: p}
+}
@@ -0,0 +1,91 @@
+package netx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDNSServerServeHTTP(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var network string
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		network = req.Network
+		if req.ClientIP() == nil {
+			t.Error("no client ip")
+		}
+		return z.ServeDNS(ctx, req)
+	})}
+	srv := httptest.NewTLSServer(s)
+	defer srv.Close()
+
+	// RFC 8484 POST
+	r := &Resolver{Server: srv.URL + "/dns-query", Transport: HTTPSTransport{Client: srv.Client()}}
+	ips, err := r.LookupHost(context.Background(), "www.example")
+	if err != nil || len(ips) != 1 || ips[0] != "192.0.2.1" || network != "https" {
+		t.Fatalf("LookupHost = %v, %v, %s", ips, err, network)
+	}
+
+	// RFC 8484 GET
+	packet, _ := NewQuery("www.example", DNSTypeA).ToByte()
+	resp, err := srv.Client().Get(srv.URL + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != dnsMessageMIME || resp.Header.Get("Cache-Control") != "max-age=3600" {
+		t.Fatalf("get: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// dns-json
+	resp, err = srv.Client().Get(srv.URL + "/dns-query?name=www.example&type=TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out dohJSONResponse
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if err != nil || resp.Header.Get("Content-Type") != dnsJSONMIME {
+		t.Fatalf("json: %v %v", err, resp.Header)
+	}
+	if out.Status != DNSRCodeSuccess || len(out.Question) != 1 || out.Question[0].Name != "www.example." || len(out.Answer) != 1 {
+		t.Fatalf("json = %+v", out)
+	}
+	if a := out.Answer[0]; a.Type != DNSTypeTXT || a.TTL != 3600 || a.Data != `"hello \"world\"" "second"` {
+		t.Fatalf("answer = %+v", a)
+	}
+	resp, _ = srv.Client().Get(srv.URL + "/dns-query?name=none.example&type=1")
+	out = dohJSONResponse{}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if out.Status != DNSRCodeNXDomain || len(out.Authority) != 1 || out.Authority[0].Type != DNSTypeSOA {
+		t.Fatalf("nxdomain = %+v", out)
+	}
+
+	for _, c := range []struct {
+		method, query string
+		status        int
+	}{
+		{http.MethodGet, "name=www.example&type=BOGUS", http.StatusBadRequest},
+		{http.MethodGet, "dns=!!", http.StatusBadRequest},
+		{http.MethodGet, "", http.StatusBadRequest},
+		{http.MethodPut, "", http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(c.method, srv.URL+"/dns-query?"+c.query, nil)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Fatalf("%s %s: %d", c.method, c.query, resp.StatusCode)
+		}
+	}
+}
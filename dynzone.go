@@ -0,0 +1,393 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoSOA = errors.New("zone has no soa record")
+
+// ZoneDiff 区域的一次变化, 先删除 Deleted 再添加 Added. 与 IXFR (RFC 1995) 相同,
+// 序列号的变化表示为删除旧的 SOA 并添加新的 SOA
+type ZoneDiff struct {
+	Deleted []*DNSResourceRecode
+	Added   []*DNSResourceRecode
+}
+
+// serials 返回 diff 中旧的与新的 SOA 序列号
+func (d *ZoneDiff) serials() (from, to uint32, ok bool) {
+	var hasFrom, hasTo bool
+	for _, rr := range d.Deleted {
+		if rr.RRType == DNSTypeSOA {
+			t, valid := parseSOATimers(rr)
+			from, hasFrom = t.serial, valid
+		}
+	}
+	for _, rr := range d.Added {
+		if rr.RRType == DNSTypeSOA {
+			t, valid := parseSOATimers(rr)
+			to, hasTo = t.serial, valid
+		}
+	}
+	return from, to, hasFrom && hasTo
+}
+
+// DynamicZone 可以在运行中更新的权威区域, 支持 RFC 2136 的 UPDATE 请求. 可以并发查询与更新
+type DynamicZone struct {
+	// Journal 不为空时每次更新在生效前先写入日志
+	Journal *ZoneJournal
+	// AllowUpdate 判断是否接受一个 UPDATE 请求, 为空时拒绝所有更新
+	AllowUpdate func(req *DNSRequest) bool
+	// SerialStrategy 更新没有改变 SOA 时增加序列号的方式, 默认加一
+	SerialStrategy SerialStrategy
+	// NameProfile 添加的记录的名字需要满足的规则, 不满足时应答 REFUSED. 删除不受限制, 以便清理已有的名字
+	NameProfile NameProfile
+
+	mu   sync.RWMutex
+	zone *Zone
+}
+
+// NewDynamicZone 以 z 为初始内容, z 之后只能通过返回的 DynamicZone 修改
+func NewDynamicZone(z *Zone) *DynamicZone {
+	return &DynamicZone{zone: z}
+}
+
+// OpenDynamicZone 从 j 的快照与日志恢复区域, 没有快照时以 initial 为起点, 之后的更新写入 j
+func OpenDynamicZone(initial *Zone, j *ZoneJournal) (*DynamicZone, error) {
+	z, err := j.Load(initial)
+	if err != nil {
+		return nil, err
+	}
+	return &DynamicZone{Journal: j, zone: z}, nil
+}
+
+// Origin 区域顶点
+func (d *DynamicZone) Origin() string {
+	return d.zone.Origin
+}
+
+// Serial 当前的 SOA 序列号
+func (d *DynamicZone) Serial() uint32 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	t, _ := parseSOATimers(d.zone.SOA())
+	return t.serial
+}
+
+// Records 返回 name 上 qtype 的记录副本, qtype 为 0 时返回全部
+func (d *DynamicZone) Records(name string, qtype uint16) []*DNSResourceRecode {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return copyRecords(d.zone.Records(name, qtype))
+}
+
+// Apply 应用一次变化, diff 没有改变 SOA 时按 SerialStrategy 增加序列号. 设置了 Journal 时先写入日志, 写入失败时区域不变
+func (d *DynamicZone) Apply(diff *ZoneDiff) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.apply(diff)
+}
+
+// apply 调用时持有写锁
+func (d *DynamicZone) apply(diff *ZoneDiff) error {
+	for _, rr := range append(append([]*DNSResourceRecode{}, diff.Deleted...), diff.Added...) {
+		if !inZone(rr.Name, d.zone.Origin) {
+			return errors.WithMessage(ErrOutOfZone, rr.Name)
+		}
+	}
+	diff = &ZoneDiff{Deleted: copyRecords(diff.Deleted), Added: copyRecords(diff.Added)}
+	if _, _, ok := diff.serials(); !ok {
+		soa := d.zone.SOA()
+		if soa == nil {
+			return ErrNoSOA
+		}
+		t, _ := parseSOATimers(soa)
+		diff.Deleted = append(diff.Deleted, soa.Copy())
+		diff.Added = append(diff.Added, withSerial(soa, d.SerialStrategy.Next(t.serial, time.Now())))
+	}
+	if d.Journal != nil {
+		if err := d.Journal.Append(diff); err != nil {
+			return err
+		}
+	}
+	applyDiff(d.zone, diff)
+	if d.Journal != nil {
+		// 快照失败不影响已经写入日志的更新
+		_ = d.Journal.compact(d.zone)
+	}
+	return nil
+}
+
+// applyDiff 在 z 上删除与添加记录
+func applyDiff(z *Zone, diff *ZoneDiff) {
+	for _, rr := range diff.Deleted {
+		z.Remove(rr)
+	}
+	for _, rr := range diff.Added {
+		// 相同的记录只保留一条, TTL 以新的为准
+		z.Remove(rr)
+		_ = z.Add(rr.Copy())
+	}
+}
+
+// withSerial 返回序列号改为 serial 的 SOA 副本
+func withSerial(soa *DNSResourceRecode, serial uint32) *DNSResourceRecode {
+	c := soa.Copy()
+	if fields := strings.Fields(c.RData); len(fields) == 7 {
+		fields[2] = strconv.FormatUint(uint64(serial), 10)
+		c.RData = strings.Join(fields, " ")
+	}
+	return c
+}
+
+// ServeDNS 查询按 Zone.ServeDNS 应答, UPDATE 请求按 RFC 2136 处理
+func (d *DynamicZone) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	if req.Message.Header.Flags.OpCode == DNSOpCodeUpdate {
+		return d.update(req), nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	resp, err := d.zone.ServeDNS(ctx, req)
+	if resp != nil {
+		// 记录在之后的更新中可能被替换, 应答使用副本
+		resp.ResourceRecodes = copyRecords(resp.ResourceRecodes)
+	}
+	return resp, err
+}
+
+// update 处理 UPDATE 请求: 问题部分为区域, 回答部分为前提条件, 授权部分为更新 (RFC 2136 2)
+func (d *DynamicZone) update(req *DNSRequest) *DNSMessage {
+	resp := NewReply(req.Message)
+	resp.Header.Flags.RD = 0
+	msg := req.Message
+	fail := func(rcode uint16) *DNSMessage {
+		resp.Header.Flags.RCode = rcode
+		return resp
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0].QuestionType != DNSTypeSOA {
+		return fail(DNSRCodeFormErr)
+	}
+	if normalizeDomain(msg.Questions[0].QuestionName) != d.zone.Origin {
+		return fail(DNSRCodeNotAuth)
+	}
+	if d.AllowUpdate == nil || !d.AllowUpdate(req) {
+		return fail(DNSRCodeRefused)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if rcode := d.checkPrerequisites(msg.Answers()); rcode != DNSRCodeSuccess {
+		return fail(rcode)
+	}
+	updates := msg.Authorities()
+	if rcode := d.prescan(updates); rcode != DNSRCodeSuccess {
+		return fail(rcode)
+	}
+	diff := d.updateDiff(updates)
+	if len(diff.Deleted) == 0 && len(diff.Added) == 0 {
+		return resp
+	}
+	if err := d.apply(diff); err != nil {
+		return fail(DNSRCodeServFail)
+	}
+	return resp
+}
+
+// checkPrerequisites RFC 2136 3.2
+func (d *DynamicZone) checkPrerequisites(prereqs []*DNSResourceRecode) uint16 {
+	// 值相关的 rrset 前提按名字与类型汇总后比较
+	valueSets := map[string][]*DNSResourceRecode{}
+	for _, rr := range prereqs {
+		if rr.TTL != 0 {
+			return DNSRCodeFormErr
+		}
+		name := normalizeDomain(rr.Name)
+		if !inZone(name, d.zone.Origin) {
+			return DNSRCodeNotZone
+		}
+		switch rr.Class {
+		case DNSClassAny:
+			if len(rr.Data) > 0 || rr.RData != "" {
+				return DNSRCodeFormErr
+			}
+			if rr.RRType == DNSTypeANY {
+				if !d.zone.exists(name) {
+					return DNSRCodeNXDomain
+				}
+			} else if len(d.zone.Records(name, rr.RRType)) == 0 {
+				return DNSRCodeNXRRSet
+			}
+		case DNSClassNone:
+			if len(rr.Data) > 0 || rr.RData != "" {
+				return DNSRCodeFormErr
+			}
+			if rr.RRType == DNSTypeANY {
+				if d.zone.exists(name) {
+					return DNSRCodeYXDomain
+				}
+			} else if len(d.zone.Records(name, rr.RRType)) > 0 {
+				return DNSRCodeYXRRSet
+			}
+		case DNSClassIn:
+			key := name + "/" + strconv.Itoa(int(rr.RRType))
+			valueSets[key] = append(valueSets[key], rr)
+		default:
+			return DNSRCodeFormErr
+		}
+	}
+	for _, want := range valueSets {
+		have := d.zone.Records(want[0].Name, want[0].RRType)
+		if !sameRecordSet(have, want) {
+			return DNSRCodeNXRRSet
+		}
+	}
+	return DNSRCodeSuccess
+}
+
+// sameRecordSet 两组记录的 RDATA 集合是否相同
+func sameRecordSet(a, b []*DNSResourceRecode) bool {
+	contains := func(set []*DNSResourceRecode, rr *DNSResourceRecode) bool {
+		for _, r := range set {
+			if sameRecord(r, rr) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rr := range a {
+		if !contains(b, rr) {
+			return false
+		}
+	}
+	for _, rr := range b {
+		if !contains(a, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+// prescan RFC 2136 3.4.1
+func (d *DynamicZone) prescan(updates []*DNSResourceRecode) uint16 {
+	for _, rr := range updates {
+		if !inZone(rr.Name, d.zone.Origin) {
+			return DNSRCodeNotZone
+		}
+		switch rr.Class {
+		case DNSClassIn:
+			switch rr.RRType {
+			case DNSTypeANY, DNSTypeAXFR, DNSTypeIXFR, DNSTypeMAILA, DNSTypeMAILB, DNSTypeOPT:
+				return DNSRCodeFormErr
+			}
+			if d.NameProfile.Check(rr.Name) != nil {
+				return DNSRCodeRefused
+			}
+		case DNSClassAny:
+			if rr.TTL != 0 || len(rr.Data) > 0 || rr.RData != "" {
+				return DNSRCodeFormErr
+			}
+		case DNSClassNone:
+			if rr.TTL != 0 || rr.RRType == DNSTypeANY {
+				return DNSRCodeFormErr
+			}
+		default:
+			return DNSRCodeFormErr
+		}
+	}
+	return DNSRCodeSuccess
+}
+
+// updateDiff 把更新部分转换为 ZoneDiff (RFC 2136 3.4.2). 顶点的 SOA 与 NS 不能整体删除,
+// 添加的 SOA 只有序列号更新时才替换
+func (d *DynamicZone) updateDiff(updates []*DNSResourceRecode) *ZoneDiff {
+	// 在区域的副本上依次执行, 最后比较得到差异
+	work := NewZone(d.zone.Origin)
+	touched := map[string]bool{}
+	load := func(name string) {
+		if !touched[name] {
+			touched[name] = true
+			for _, rr := range d.zone.Records(name, 0) {
+				_ = work.Add(rr)
+			}
+		}
+	}
+	apex := d.zone.Origin
+	for _, rr := range updates {
+		name := normalizeDomain(rr.Name)
+		load(name)
+		switch rr.Class {
+		case DNSClassIn:
+			add := rr.Copy()
+			add.Name = name
+			if rr.RRType == DNSTypeSOA {
+				if name != apex {
+					continue
+				}
+				current, _ := parseSOATimers(d.zone.SOA())
+				next, ok := parseSOATimers(add)
+				if !ok || !SerialLess(current.serial, next.serial) {
+					continue
+				}
+				for _, old := range work.Records(apex, DNSTypeSOA) {
+					work.Remove(old)
+				}
+			}
+			if rr.RRType == DNSTypeCName && len(work.Records(name, 0)) > len(work.Records(name, DNSTypeCName)) {
+				continue
+			}
+			if rr.RRType != DNSTypeCName && len(work.Records(name, DNSTypeCName)) > 0 {
+				continue
+			}
+			work.Remove(add)
+			_ = work.Add(add)
+		case DNSClassAny:
+			for _, old := range work.Records(name, 0) {
+				if rr.RRType != DNSTypeANY && old.RRType != rr.RRType {
+					continue
+				}
+				if name == apex && (old.RRType == DNSTypeSOA || old.RRType == DNSTypeNS) {
+					continue
+				}
+				work.Remove(old)
+			}
+		case DNSClassNone:
+			if rr.RRType == DNSTypeSOA {
+				continue
+			}
+			if name == apex && rr.RRType == DNSTypeNS && len(work.Records(apex, DNSTypeNS)) == 1 {
+				continue
+			}
+			del := rr.Copy()
+			del.Class = DNSClassIn
+			work.Remove(del)
+		}
+	}
+	diff := &ZoneDiff{}
+	for name := range touched {
+		before, after := d.zone.Records(name, 0), work.Records(name, 0)
+		for _, rr := range before {
+			if !containsExact(after, rr) {
+				diff.Deleted = append(diff.Deleted, rr)
+			}
+		}
+		for _, rr := range after {
+			if !containsExact(before, rr) {
+				diff.Added = append(diff.Added, rr)
+			}
+		}
+	}
+	return diff
+}
+
+// containsExact set 中是否有 RDATA 与 TTL 都相同的记录
+func containsExact(set []*DNSResourceRecode, rr *DNSResourceRecode) bool {
+	for _, r := range set {
+		if sameRecord(r, rr) && r.TTL == rr.TTL {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dynamicZoneText = `$TTL 300
+@	IN SOA ns.example. admin.example. 10 3600 600 86400 300
+	IN NS ns.example.
+ns	IN A 192.0.2.53
+www	IN A 192.0.2.1
+	IN A 192.0.2.2
+mail	IN MX 10 mx.example.
+`
+
+func parseDynamicZone(t *testing.T) *Zone {
+	t.Helper()
+	z, err := ParseZone(strings.NewReader(dynamicZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func newUpdate(zone string, prereqs, updates []*DNSResourceRecode) *DNSMessage {
+	m := NewQuery(zone, DNSTypeSOA)
+	m.Header.Flags.OpCode, m.Header.Flags.RD = DNSOpCodeUpdate, 0
+	m.ResourceRecodes = append(append(m.ResourceRecodes, prereqs...), updates...)
+	m.Header.AnswerRRs, m.Header.AuthorityRRs = uint16(len(prereqs)), uint16(len(updates))
+	return m
+}
+
+func TestDynamicZoneUpdate(t *testing.T) {
+	d := NewDynamicZone(parseDynamicZone(t))
+	d.AllowUpdate = func(req *DNSRequest) bool { return req.RemoteAddr != nil }
+	udp, _ := startDNSServer(t, &DNSServer{Handler: d})
+	r := &Resolver{Server: udp}
+	ctx := context.Background()
+	update := func(prereqs, updates []*DNSResourceRecode) uint16 {
+		t.Helper()
+		resp, err := r.Exchange(ctx, newUpdate("example", prereqs, updates))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Header.Flags.RCode
+	}
+	rr := func(name string, class, rrtype uint16, rdata string) *DNSResourceRecode {
+		ttl := uint32(0)
+		if class == DNSClassIn {
+			ttl = 60
+		}
+		if rdata == "" {
+			return &DNSResourceRecode{Name: name, RRType: rrtype, Class: class, Data: []byte{}}
+		}
+		return &DNSResourceRecode{Name: name, RRType: rrtype, Class: class, TTL: ttl, RData: rdata}
+	}
+	noPrereq := []*DNSResourceRecode(nil)
+
+	// 添加, 序列号自动增加
+	if rc := update(noPrereq, []*DNSResourceRecode{rr("new.example", DNSClassIn, DNSTypeA, "192.0.2.9")}); rc != DNSRCodeSuccess {
+		t.Fatalf("add: %d", rc)
+	}
+	if len(d.Records("new.example", DNSTypeA)) != 1 || d.Serial() != 11 {
+		t.Fatalf("after add: %v, serial %d", d.Records("new.example", DNSTypeA), d.Serial())
+	}
+	resp, err := r.Exchange(ctx, NewQuery("new.example", DNSTypeA))
+	if err != nil || len(resp.Answers()) != 1 || resp.Answers()[0].RData != "192.0.2.9" {
+		t.Fatalf("query after add: %v %+v", err, resp)
+	}
+
+	// 前提条件
+	for _, c := range []struct {
+		prereq *DNSResourceRecode
+		rcode  uint16
+	}{
+		{rr("none.example", DNSClassAny, DNSTypeANY, ""), DNSRCodeNXDomain},
+		{rr("www.example", DNSClassNone, DNSTypeANY, ""), DNSRCodeYXDomain},
+		{rr("www.example", DNSClassAny, DNSTypeAAAA, ""), DNSRCodeNXRRSet},
+		{rr("www.example", DNSClassNone, DNSTypeA, ""), DNSRCodeYXRRSet},
+		{&DNSResourceRecode{Name: "www.example", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.1"}, DNSRCodeNXRRSet},
+		{rr("www.other", DNSClassAny, DNSTypeANY, ""), DNSRCodeNotZone},
+	} {
+		if rc := update([]*DNSResourceRecode{c.prereq}, []*DNSResourceRecode{rr("x.example", DNSClassIn, DNSTypeA, "192.0.2.10")}); rc != c.rcode {
+			t.Fatalf("prereq %s %s class %d: rcode %d", c.prereq.Name, DNSType(c.prereq.RRType), c.prereq.Class, rc)
+		}
+	}
+	if len(d.Records("x.example", 0)) != 0 || d.Serial() != 11 {
+		t.Fatal("update applied although a prerequisite failed")
+	}
+	// 值相关的 rrset 前提满足时删除单条记录与整个 rrset
+	full := []*DNSResourceRecode{
+		{Name: "www.example", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.1"},
+		{Name: "www.example", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.2"},
+	}
+	if rc := update(full, []*DNSResourceRecode{rr("www.example", DNSClassNone, DNSTypeA, "192.0.2.1")}); rc != DNSRCodeSuccess {
+		t.Fatalf("delete rr: %d", rc)
+	}
+	if got := d.Records("www.example", DNSTypeA); len(got) != 1 || got[0].RData != "192.0.2.2" {
+		t.Fatalf("after delete rr: %v", got)
+	}
+	if rc := update(noPrereq, []*DNSResourceRecode{rr("mail.example", DNSClassAny, DNSTypeANY, "")}); rc != DNSRCodeSuccess || len(d.Records("mail.example", 0)) != 0 {
+		t.Fatalf("delete name: %d", rc)
+	}
+	// 顶点的 NS 与 SOA 不能删除
+	if update(noPrereq, []*DNSResourceRecode{rr("example", DNSClassAny, DNSTypeANY, "")}); len(d.Records("example", DNSTypeNS)) == 0 || len(d.Records("example", DNSTypeSOA)) == 0 {
+		t.Fatal("apex ns or soa deleted")
+	}
+	// 显式的 SOA 更新, 序列号没有增加时忽略
+	serial := d.Serial()
+	if update(noPrereq, []*DNSResourceRecode{rr("example", DNSClassIn, DNSTypeSOA, "ns.example. admin.example. 5 3600 600 86400 300")}); d.Serial() != serial {
+		t.Fatal("older soa accepted")
+	}
+	if update(noPrereq, []*DNSResourceRecode{rr("example", DNSClassIn, DNSTypeSOA, "ns.example. admin.example. 2000 3600 600 86400 300")}); d.Serial() != 2000 {
+		t.Fatalf("soa update: serial %d", d.Serial())
+	}
+	// CNAME 与其它数据不能共存
+	if update(noPrereq, []*DNSResourceRecode{rr("www.example", DNSClassIn, DNSTypeCName, "ns.example")}); len(d.Records("www.example", DNSTypeCName)) != 0 {
+		t.Fatal("cname added next to other data")
+	}
+	// 名字规则只限制添加. 运行中的服务器会读取 NameProfile, 因此使用另一个直接调用的区域
+	named := NewDynamicZone(parseDynamicZone(t))
+	named.AllowUpdate = d.AllowUpdate
+	namedUpdate := func(profile NameProfile, updates ...*DNSResourceRecode) uint16 {
+		t.Helper()
+		named.NameProfile = profile
+		resp, err := named.ServeDNS(ctx, &DNSRequest{Message: newUpdate("example", nil, updates), Network: "udp", RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Header.Flags.RCode
+	}
+	if rc := namedUpdate(NameProfileHostname, rr("bad_name.example", DNSClassIn, DNSTypeA, "192.0.2.1")); rc != DNSRCodeRefused {
+		t.Fatalf("invalid hostname: %d", rc)
+	}
+	if rc := namedUpdate(NameProfileService, rr("_srv.example", DNSClassIn, DNSTypeA, "192.0.2.3")); rc != DNSRCodeSuccess || len(named.Records("_srv.example", 0)) != 1 {
+		t.Fatalf("service label: %d", rc)
+	}
+	if rc := namedUpdate(NameProfileHostname, rr("_srv.example", DNSClassAny, DNSTypeANY, "")); rc != DNSRCodeSuccess || len(named.Records("_srv.example", 0)) != 0 {
+		t.Fatalf("delete service label: %d", rc)
+	}
+
+	// 区域外, 不允许的请求
+	if rc := update(noPrereq, []*DNSResourceRecode{rr("a.other", DNSClassIn, DNSTypeA, "192.0.2.1")}); rc != DNSRCodeNotZone {
+		t.Fatalf("out of zone: %d", rc)
+	}
+	resp, err = r.Exchange(ctx, newUpdate("other", nil, nil))
+	if err != nil || resp.Header.Flags.RCode != DNSRCodeNotAuth {
+		t.Fatalf("other zone: %v %+v", err, resp)
+	}
+	d.AllowUpdate = nil
+	resp, _ = d.ServeDNS(ctx, &DNSRequest{Message: newUpdate("example", nil, []*DNSResourceRecode{rr("y.example", DNSClassIn, DNSTypeA, "192.0.2.1")})})
+	if resp.Header.Flags.RCode != DNSRCodeRefused || len(d.Records("y.example", 0)) != 0 {
+		t.Fatal("update accepted without AllowUpdate")
+	}
+
+	d.SerialStrategy = SerialDate
+	before := SerialDate.Next(2000, time.Now())
+	if err := d.Apply(&ZoneDiff{Added: []*DNSResourceRecode{aRecord("z.example", "192.0.2.1")}}); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Serial(); s != before && s != SerialDate.Next(2000, time.Now()) {
+		t.Fatalf("date serial %d, want %d", s, before)
+	}
+}
@@ -0,0 +1,153 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+)
+
+var (
+	ErrNoECHConfig    = errors.New("no ech config")
+	ErrECHUnsupported = errors.New("encrypted client hello requires go 1.23 or later")
+)
+
+// echMaxAlias LookupECHConfig 最多跟随的 AliasMode 记录数
+const echMaxAlias = 8
+
+// ECHConfigList 从 HTTPS/SVCB 记录的 RDATA 中取出 ech 参数 (RFC 9460 的 SvcParamKey 5), 即 TLS 的 ECHConfigList.
+// AliasMode 记录或没有 ech 参数时返回 ErrNoECHConfig
+func ECHConfigList(data []byte) ([]byte, error) {
+	priority, _, value, err := svcbParam(data, svcParamECH)
+	if err != nil {
+		return nil, err
+	}
+	if priority == 0 || value == nil {
+		return nil, ErrNoECHConfig
+	}
+	if len(value) < 2 || len(value) != 2+int(binary.BigEndian.Uint16(value)) {
+		return nil, errors.WithMessage(ErrBadRData, "bad ECHConfigList length")
+	}
+	return value, nil
+}
+
+// svcbParam 解析 SVCB 的 RDATA, 返回优先级, 目标与键为 key 的参数值, 没有该参数时值为空
+func svcbParam(data []byte, key uint16) (uint16, string, []byte, error) {
+	if len(data) < 3 {
+		return 0, "", nil, ErrBadRData
+	}
+	u := &unpacker{msg: data, off: 2, partial: true}
+	target, _, err := u.name()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	var found []byte
+	for rest := data[u.off:]; len(rest) > 0; {
+		if len(rest) < 4 || len(rest) < 4+int(binary.BigEndian.Uint16(rest[2:])) {
+			return 0, "", nil, ErrBadRData
+		}
+		k, value := binary.BigEndian.Uint16(rest), rest[4:4+int(binary.BigEndian.Uint16(rest[2:]))]
+		rest = rest[4+len(value):]
+		if k == key {
+			found = append([]byte{}, value...)
+		}
+	}
+	return binary.BigEndian.Uint16(data), target, found, nil
+}
+
+// LookupECHConfig 查询 host 的 HTTPS 记录, 返回优先级最高且带 ech 参数的 ECHConfigList, 会跟随 AliasMode 记录
+func (r *Resolver) LookupECHConfig(ctx context.Context, host string) ([]byte, error) {
+	if err := r.NameProfile.Check(host); err != nil {
+		return nil, err
+	}
+	name := host
+	for i := 0; i < echMaxAlias; i++ {
+		resp, err := r.Query(ctx, name, DNSTypeHTTPS)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Header.Flags.RCode != DNSRCodeSuccess {
+			rcode := resp.Header.Flags.RCode
+			resp.Release()
+			return nil, &RCodeError{RCode: rcode}
+		}
+		type service struct {
+			priority uint16
+			list     []byte
+		}
+		var services []service
+		alias := ""
+		for _, rr := range resp.Answers() {
+			if rr.RRType != DNSTypeHTTPS || rr.Data == nil {
+				continue
+			}
+			priority, target, _, err := svcbParam(rr.Data, svcParamECH)
+			if err != nil {
+				continue
+			}
+			if priority == 0 {
+				alias = target
+				continue
+			}
+			if list, err := ECHConfigList(rr.Data); err == nil {
+				services = append(services, service{priority: priority, list: list})
+			}
+		}
+		resp.Release()
+		if len(services) > 0 {
+			sort.SliceStable(services, func(i, j int) bool { return services[i].priority < services[j].priority })
+			return services[0].list, nil
+		}
+		if alias == "" {
+			return nil, ErrNoECHConfig
+		}
+		name = alias
+	}
+	return nil, errors.WithMessage(ErrNoECHConfig, "too many aliases")
+}
+
+// ConfigWithECH 返回设置了 ECHConfigList 的 config 副本, config 可以为空. Go 1.23 之前返回 ErrECHUnsupported
+func ConfigWithECH(config *tls.Config, list []byte) (*tls.Config, error) {
+	c := &tls.Config{}
+	if config != nil {
+		c = config.Clone()
+	}
+	if err := setECHConfigList(c, list); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithECH 与 WithTLS 相同, 但握手前通过 r 查询服务器名字的 ECHConfigList 并加密 ClientHello.
+// 没有 ech 参数时使用普通的 TLS; 服务器拒绝并给出新的配置时用新配置重试一次.
+// Go 1.23 之前总是使用普通的 TLS
+func WithECH(r *Resolver, config *tls.Config) DialMiddleware {
+	sessions := tls.NewLRUClientSessionCache(0)
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			c := &tls.Config{}
+			if config != nil {
+				c = config.Clone()
+			}
+			if c.ClientSessionCache == nil {
+				c.ClientSessionCache = sessions
+			}
+			if c.ServerName == "" {
+				c.ServerName = hostOnly(address)
+			}
+			if list, err := r.LookupECHConfig(ctx, c.ServerName); err == nil {
+				_ = setECHConfigList(c, list)
+			}
+			conn, err := WithTLS(c)(next).DialContext(ctx, network, address)
+			if retry, ok := echRetryConfigs(err); ok {
+				if err := setECHConfigList(c, retry); err != nil {
+					return nil, err
+				}
+				conn, err = WithTLS(c)(next).DialContext(ctx, network, address)
+			}
+			return conn, err
+		})
+	}
+}
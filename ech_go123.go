@@ -0,0 +1,23 @@
+//go:build go1.23
+// +build go1.23
+
+package netx
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+func setECHConfigList(c *tls.Config, list []byte) error {
+	c.EncryptedClientHelloConfigList = list
+	return nil
+}
+
+// echRetryConfigs 服务器拒绝 ECH 时给出的新配置
+func echRetryConfigs(err error) ([]byte, bool) {
+	var rejection *tls.ECHRejectionError
+	if errors.As(err, &rejection) && len(rejection.RetryConfigList) > 0 {
+		return rejection.RetryConfigList, true
+	}
+	return nil, false
+}
@@ -0,0 +1,101 @@
+//go:build go1.24
+// +build go1.24
+
+package netx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"golang.org/x/crypto/curve25519"
+	"sync"
+	"testing"
+)
+
+// echConfig 构造使用 DHKEM(X25519, HKDF-SHA256) 与 AES-128-GCM 的 ECHConfig 及其私钥
+func echConfig(t *testing.T, id byte, publicName string) (config, key []byte) {
+	key = make([]byte, curve25519.ScalarSize)
+	_, _ = rand.Read(key)
+	pub, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := []byte{id, 0x00, 0x20, 0x00, byte(len(pub))}
+	c = append(c, pub...)
+	c = append(c, 0x00, 0x04, 0x00, 0x01, 0x00, 0x01, 0, byte(len(publicName)))
+	c = append(append(c, publicName...), 0x00, 0x00)
+	return append([]byte{0xfe, 0x0d, byte(len(c) >> 8), byte(len(c))}, c...), key
+}
+
+func echList(configs ...[]byte) []byte {
+	var b []byte
+	for _, c := range configs {
+		b = append(b, c...)
+	}
+	return append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)
+}
+
+func TestWithECH(t *testing.T) {
+	config, key := echConfig(t, 1, "public.example.com")
+	stale, _ := echConfig(t, 2, "public.example.com")
+	cert := selfSignedCert(t, "secret.example.com", "public.example.com")
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{Config: config, PrivateKey: key, SendAsRetry: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+				_, _ = conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+
+	var mu sync.Mutex
+	current := echList(config)
+	r := echServer(t, func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	dialer := ChainDialer(nil, WithECH(r, &tls.Config{ServerName: "secret.example.com", RootCAs: roots}))
+
+	for _, list := range [][]byte{echList(config), echList(stale)} {
+		mu.Lock()
+		current = list
+		mu.Unlock()
+		conn, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := conn.(*tls.Conn).ConnectionState()
+		_ = conn.Close()
+		if !state.ECHAccepted || state.ServerName != "secret.example.com" {
+			t.Fatalf("ECHAccepted = %v, ServerName = %q", state.ECHAccepted, state.ServerName)
+		}
+	}
+
+	// 没有 ech 参数时使用普通 TLS
+	plain := ChainDialer(nil, WithECH(r, &tls.Config{ServerName: "public.example.com", RootCAs: roots}))
+	conn, err := plain.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.(*tls.Conn).ConnectionState().ECHAccepted {
+		t.Fatal("ECH used without a config")
+	}
+}
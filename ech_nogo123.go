@@ -0,0 +1,14 @@
+//go:build !go1.23
+// +build !go1.23
+
+package netx
+
+import "crypto/tls"
+
+func setECHConfigList(*tls.Config, []byte) error {
+	return ErrECHUnsupported
+}
+
+func echRetryConfigs(error) ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,59 @@
+package netx
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// echServer 回答 HTTPS 查询: secret.example.com 别名到 svc.example.com, 后者带 list 的 ech 参数
+func echServer(t *testing.T, list func() []byte) *Resolver {
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		var data []byte
+		switch {
+		case q.QuestionType == DNSTypeHTTPS && q.QuestionName == "secret.example.com":
+			data = svcbRData(0, "svc.example.com")
+		case q.QuestionType == DNSTypeHTTPS && q.QuestionName == "svc.example.com":
+			data = svcbRData(1, ".", svcParam(svcParamALPN, []byte("\x02h2")), svcParam(svcParamECH, list()))
+		case q.QuestionType == DNSTypeHTTPS:
+			data = svcbRData(1, ".", svcParam(svcParamALPN, []byte("\x02h2")))
+		}
+		if data != nil {
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: q.QuestionName, RRType: DNSTypeHTTPS, Class: DNSClassIn, TTL: 300, Data: data}}
+			resp.Header.AnswerRRs = 1
+		}
+		return resp, nil
+	})}
+	udpAddr, _ := startDNSServer(t, s)
+	return &Resolver{Server: udpAddr}
+}
+
+func TestLookupECHConfig(t *testing.T) {
+	want := []byte{0x00, 0x03, 0xfe, 0x0d, 0x00}
+	var mu sync.Mutex
+	r := echServer(t, func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return want
+	})
+	ctx := context.Background()
+	list, err := r.LookupECHConfig(ctx, "secret.example.com")
+	if err != nil || string(list) != string(want) {
+		t.Fatalf("LookupECHConfig = %x, %v", list, err)
+	}
+	if _, err := r.LookupECHConfig(ctx, "plain.example.com"); err != ErrNoECHConfig {
+		t.Fatalf("no ech param: %v", err)
+	}
+
+	if _, err := ECHConfigList(svcbRData(1, ".", svcParam(svcParamECH, []byte{0x00, 0x05, 0x01}))); err == nil {
+		t.Fatal("bad ECHConfigList length accepted")
+	}
+	if _, err := ECHConfigList(svcbRData(0, "svc.example.com")); err != ErrNoECHConfig {
+		t.Fatalf("alias mode: %v", err)
+	}
+	if c, err := ConfigWithECH(nil, want); err != nil && err != ErrECHUnsupported || err == nil && c == nil {
+		t.Fatalf("ConfigWithECH = %v, %v", c, err)
+	}
+}
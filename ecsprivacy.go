@@ -0,0 +1,78 @@
+package netx
+
+import "context"
+
+// ECSPolicy 转发时对客户端 ECS 选项 (RFC 7871) 的处理, 零值表示全部去掉
+type ECSPolicy struct {
+	// IPv4Prefix 与 IPv6Prefix 转发给上游的最长前缀, 为 0 时去掉该地址族的 ECS 选项.
+	// RFC 7871 11.1 建议不超过 /24 与 /56
+	IPv4Prefix uint8
+	IPv6Prefix uint8
+}
+
+func (p ECSPolicy) limit(ecs *ClientSubnet) uint8 {
+	if ecs.IP.To4() != nil {
+		return p.IPv4Prefix
+	}
+	return p.IPv6Prefix
+}
+
+// WithECSScrubbing 转发前按 policy 截断或去掉请求中的 ECS 选项, 应答中的 ECS 改回客户端发送的地址与前缀,
+// scope 不超过实际发给上游的前缀, 去掉时为 0. 客户端没有发送 ECS 时去掉应答中的 ECS
+func WithECSScrubbing(policy ECSPolicy) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			opt := req.Message.OPT()
+			if opt == nil {
+				return next.ServeDNS(ctx, req)
+			}
+			ecs := req.Message.ClientSubnet()
+			fwd := req.Message.Copy()
+			options := withoutOption(fwd.EDNSOptions(), EDNSOptionClientSubnet)
+			var sent *ClientSubnet
+			if ecs != nil {
+				if limit := policy.limit(ecs); limit > 0 {
+					s := *ecs
+					if s.SourcePrefix > limit {
+						s.SourcePrefix = limit
+					}
+					s.ScopePrefix = 0
+					sent = &s
+					options = append(options, sent.Option())
+				}
+			}
+			fwd.SetEDNS(opt.Class, options)
+
+			resp, err := next.ServeDNS(ctx, &DNSRequest{Message: fwd, Network: req.Network, RemoteAddr: req.RemoteAddr})
+			if err != nil || resp == nil || resp.OPT() == nil {
+				return resp, err
+			}
+			upstream := resp.ClientSubnet()
+			options = withoutOption(resp.EDNSOptions(), EDNSOptionClientSubnet)
+			if ecs != nil {
+				reply := *ecs
+				reply.ScopePrefix = 0
+				if sent != nil && upstream != nil {
+					reply.ScopePrefix = upstream.ScopePrefix
+					if reply.ScopePrefix > sent.SourcePrefix {
+						reply.ScopePrefix = sent.SourcePrefix
+					}
+				}
+				options = append(options, reply.Option())
+			}
+			resp.SetEDNS(resp.OPT().Class, options)
+			return resp, nil
+		})
+	}
+}
+
+// withoutOption 去掉 code 的选项
+func withoutOption(options []EDNSOption, code uint16) []EDNSOption {
+	kept := options[:0]
+	for _, o := range options {
+		if o.Code != code {
+			kept = append(kept, o)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,80 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestECSScrubbing(t *testing.T) {
+	var mu sync.Mutex
+	var seen *ClientSubnet
+	// 上游总是应答 /24 的 scope, 客户端没有 ECS 时也附带一个
+	upstream := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		ecs := req.Message.ClientSubnet()
+		mu.Lock()
+		seen = ecs
+		mu.Unlock()
+		resp := NewReply(req.Message)
+		reply := &ClientSubnet{IP: net.ParseIP("203.0.113.0"), SourcePrefix: 24, ScopePrefix: 24}
+		if ecs != nil {
+			reply = ecs
+			reply.ScopePrefix = 24
+		}
+		resp.SetEDNS(0, []EDNSOption{{Code: EDNSOptionNSID, Data: []byte("up")}, reply.Option()})
+		return resp, nil
+	})
+	ctx := context.Background()
+	query := func(policy ECSPolicy, ecs *ClientSubnet) (sent, got *ClientSubnet, resp *DNSMessage) {
+		t.Helper()
+		h := ChainHandler(upstream, WithECSScrubbing(policy))
+		req := NewQuery("www.example.com", DNSTypeA, WithEDNSOptions(0))
+		if ecs != nil {
+			WithEDNSOptions(0, ecs.Option())(req)
+		}
+		resp, err := h.ServeDNS(ctx, &DNSRequest{Message: req})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return seen, resp.ClientSubnet(), resp
+	}
+
+	client := &ClientSubnet{IP: net.ParseIP("198.51.100.77").To4(), SourcePrefix: 32}
+	policy := ECSPolicy{IPv4Prefix: 24, IPv6Prefix: 56}
+	sent, got, resp := query(policy, client)
+	if sent == nil || sent.SourcePrefix != 24 || !sent.IP.Equal(net.ParseIP("198.51.100.0")) {
+		t.Fatalf("upstream saw %+v", sent)
+	}
+	if got == nil || got.SourcePrefix != 32 || !got.IP.Equal(client.IP) || got.ScopePrefix != 24 {
+		t.Fatalf("client got %+v", got)
+	}
+	if opts := resp.EDNSOptions(); len(opts) != 2 || opts[0].Code != EDNSOptionNSID {
+		t.Fatalf("other options lost: %+v", opts)
+	}
+
+	// 客户端的前缀更短时原样转发, scope 不超过它
+	client20 := &ClientSubnet{IP: net.ParseIP("198.51.96.0").To4(), SourcePrefix: 20}
+	if sent, got, _ = query(policy, client20); sent.SourcePrefix != 20 || got.ScopePrefix != 20 {
+		t.Fatalf("/20: sent %+v, got %+v", sent, got)
+	}
+
+	// 地址族的前缀为 0 时去掉, 应答的 scope 为 0
+	v6 := &ClientSubnet{IP: net.ParseIP("2001:db8:1:2::1"), SourcePrefix: 64}
+	if sent, got, _ = query(ECSPolicy{IPv4Prefix: 24}, v6); sent != nil || got == nil || got.ScopePrefix != 0 || got.SourcePrefix != 64 {
+		t.Fatalf("stripped v6: sent %+v, got %+v", sent, got)
+	}
+	if sent, _, _ = query(policy, v6); sent == nil || sent.SourcePrefix != 56 {
+		t.Fatalf("v6 /56: sent %+v", sent)
+	}
+	if sent, got, _ = query(ECSPolicy{}, client); sent != nil || got.ScopePrefix != 0 {
+		t.Fatalf("zero policy: sent %+v, got %+v", sent, got)
+	}
+
+	// 客户端没有 ECS 时去掉上游加上的
+	if _, got, resp = query(policy, nil); got != nil || len(resp.EDNSOptions()) != 1 {
+		t.Fatalf("no client ecs: got %+v", got)
+	}
+}
@@ -0,0 +1,282 @@
+package netx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"math/big"
+	"net"
+)
+
+const (
+	// EDNSOptionNSID RFC 5001
+	EDNSOptionNSID = 3
+	// EDNSOptionClientSubnet RFC 7871
+	EDNSOptionClientSubnet = 8
+	// EDNSOptionPadding RFC 7830
+	EDNSOptionPadding = 12
+
+	// defaultEDNSSize 添加 OPT 记录时通告的 udp 负载大小
+	defaultEDNSSize = 1232
+
+	// 请求与响应的填充块大小, RFC 8467 推荐值
+	defaultQueryPaddingBlock    = 128
+	defaultResponsePaddingBlock = 468
+)
+
+var ErrBadEDNSOption = errors.New("bad edns option")
+
+// EDNSOption OPT 记录中的一个选项
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// ParseEDNSOptions 解析 OPT 记录的 RDATA
+func ParseEDNSOptions(data []byte) ([]EDNSOption, error) {
+	var options []EDNSOption
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, ErrBadEDNSOption
+		}
+		code, n := binary.BigEndian.Uint16(data), int(binary.BigEndian.Uint16(data[2:]))
+		if 4+n > len(data) {
+			return nil, ErrBadEDNSOption
+		}
+		options = append(options, EDNSOption{Code: code, Data: append([]byte{}, data[4:4+n]...)})
+		data = data[4+n:]
+	}
+	return options, nil
+}
+
+// PackEDNSOptions 编码为 OPT 记录的 RDATA
+func PackEDNSOptions(options []EDNSOption) []byte {
+	data := []byte{}
+	for _, o := range options {
+		data = append(data, byte(o.Code>>8), byte(o.Code), byte(len(o.Data)>>8), byte(len(o.Data)))
+		data = append(data, o.Data...)
+	}
+	return data
+}
+
+// OPT 返回附加部分中的 OPT 记录, 没有时返回空
+func (d *DNSMessage) OPT() *DNSResourceRecode {
+	for _, rr := range d.Additionals() {
+		if rr.RRType == DNSTypeOPT {
+			return rr
+		}
+	}
+	return nil
+}
+
+// EDNSOptions 返回 OPT 记录中的选项, 没有 OPT 记录或无法解析时返回空
+func (d *DNSMessage) EDNSOptions() []EDNSOption {
+	opt := d.OPT()
+	if opt == nil {
+		return nil
+	}
+	options, _ := ParseEDNSOptions(opt.Data)
+	return options
+}
+
+// UDPSize 返回请求方通告的 udp 负载大小, 没有 OPT 记录时为 512
+func (d *DNSMessage) UDPSize() int {
+	if opt := d.OPT(); opt != nil && opt.Class > 512 {
+		return int(opt.Class)
+	}
+	return 512
+}
+
+// SetEDNS 添加或替换 OPT 记录, udpSize 为 0 时使用 1232
+func (d *DNSMessage) SetEDNS(udpSize uint16, options []EDNSOption) {
+	if udpSize == 0 {
+		udpSize = defaultEDNSSize
+	}
+	if opt := d.OPT(); opt != nil {
+		opt.Class = udpSize
+		opt.Data = PackEDNSOptions(options)
+		return
+	}
+	d.ResourceRecodes = append(d.ResourceRecodes, &DNSResourceRecode{
+		RRType: DNSTypeOPT,
+		Class:  udpSize,
+		Data:   PackEDNSOptions(options),
+	})
+	d.Header.AdditionalRRs++
+}
+
+// ClientSubnet EDNS Client Subnet 选项
+type ClientSubnet struct {
+	IP           net.IP // 已按 SourcePrefix 截断
+	SourcePrefix uint8
+	ScopePrefix  uint8
+}
+
+// ParseClientSubnet 解析 ECS 选项的数据
+func ParseClientSubnet(data []byte) (*ClientSubnet, error) {
+	if len(data) < 4 {
+		return nil, ErrBadEDNSOption
+	}
+	family, source, scope := binary.BigEndian.Uint16(data), data[2], data[3]
+	var ip net.IP
+	switch family {
+	case 1:
+		ip = make(net.IP, net.IPv4len)
+	case 2:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, errors.WithMessage(ErrBadEDNSOption, "unknown ecs family")
+	}
+	addr := data[4:]
+	if int(source) > len(ip)*8 || len(addr) != (int(source)+7)/8 {
+		return nil, errors.WithMessage(ErrBadEDNSOption, "bad ecs address length")
+	}
+	copy(ip, addr)
+	return &ClientSubnet{IP: ip.Mask(net.CIDRMask(int(source), len(ip)*8)), SourcePrefix: source, ScopePrefix: scope}, nil
+}
+
+// Option 编码为 EDNS 选项, 地址只保留 SourcePrefix 覆盖的字节
+func (c *ClientSubnet) Option() EDNSOption {
+	family, ip := uint16(2), c.IP.To16()
+	if v4 := c.IP.To4(); v4 != nil {
+		family, ip = 1, v4
+	}
+	source := int(c.SourcePrefix)
+	if source > len(ip)*8 {
+		source = len(ip) * 8
+	}
+	ip = ip.Mask(net.CIDRMask(source, len(ip)*8))
+	data := []byte{0, byte(family), byte(source), c.ScopePrefix}
+	data = append(data, ip[:(source+7)/8]...)
+	return EDNSOption{Code: EDNSOptionClientSubnet, Data: data}
+}
+
+// ClientSubnet 返回请求中的 ECS 选项, 没有或无法解析时返回空
+func (d *DNSMessage) ClientSubnet() *ClientSubnet {
+	for _, o := range d.EDNSOptions() {
+		if o.Code == EDNSOptionClientSubnet {
+			ecs, err := ParseClientSubnet(o.Data)
+			if err != nil {
+				return nil
+			}
+			return ecs
+		}
+	}
+	return nil
+}
+
+// PaddingPolicy 返回长度为 n 的报文 (已包含 padding 选项的 4 字节头部) 填充后的长度, 小于 n 时不填充.
+// 可以使用 RFC 8467 中的 BlockPadding, MaximalPadding 与 RandomPadding
+type PaddingPolicy func(n int) int
+
+// BlockPadding 填充到 block 的整数倍, RFC 8467 推荐的策略: 请求使用 128, 响应使用 468
+func BlockPadding(block int) PaddingPolicy {
+	return func(n int) int {
+		if block <= 0 {
+			return n
+		}
+		return (n + block - 1) / block * block
+	}
+}
+
+// MaximalPadding 填充到 size, 例如 65535 或协商的 udp 大小, 超过 size 的报文不填充
+func MaximalPadding(size int) PaddingPolicy {
+	return func(n int) int {
+		return size
+	}
+}
+
+// RandomPadding 随机填充 0 到 max 字节
+func RandomPadding(max int) PaddingPolicy {
+	return func(n int) int {
+		if max <= 0 {
+			return n
+		}
+		v, err := rand.Int(rand.Reader, big.NewInt(int64(max)+1))
+		if err != nil {
+			return n
+		}
+		return n + int(v.Int64())
+	}
+}
+
+// Pad 按 policy 添加 padding 选项 (RFC 7830), 替换已有的 padding, 没有 OPT 记录时添加.
+// 填充后的报文不超过 65535 字节. TSIG 等签名需要在填充之后计算
+func (d *DNSMessage) Pad(policy PaddingPolicy) error {
+	var options []EDNSOption
+	for _, o := range d.EDNSOptions() {
+		if o.Code != EDNSOptionPadding {
+			options = append(options, o)
+		}
+	}
+	var udpSize uint16
+	if opt := d.OPT(); opt != nil {
+		udpSize = opt.Class
+	}
+	d.SetEDNS(udpSize, options)
+	b, err := d.ToByte()
+	if err != nil {
+		return err
+	}
+	n := len(b) + 4
+	size := policy(n)
+	if size > 0xFFFF {
+		size = 0xFFFF
+	}
+	if size < n {
+		size = n
+	}
+	d.SetEDNS(udpSize, append(options, EDNSOption{Code: EDNSOptionPadding, Data: make([]byte, size-n)}))
+	return nil
+}
+
+// Padded 报文的 OPT 记录中是否有 padding 选项
+func (d *DNSMessage) Padded() bool {
+	for _, o := range d.EDNSOptions() {
+		if o.Code == EDNSOptionPadding {
+			return true
+		}
+	}
+	return false
+}
+
+// PadQueries 按 policy 填充请求, policy 为空时使用 BlockPadding(128). 只应用于加密的 Transport
+// (DoT, DoH 等), 明文的填充没有意义, 只会增加流量
+func PadQueries(policy PaddingPolicy) Interceptor {
+	if policy == nil {
+		policy = BlockPadding(defaultQueryPaddingBlock)
+	}
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			// 填充副本, 转发时 req 是客户端的请求, 之后还要用它判断响应是否需要填充
+			req = req.Copy()
+			if err := req.Pad(policy); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(ctx, server, req)
+		})
+	}
+}
+
+// WithPadding 请求带有 padding 选项且不是通过 udp 收到时, 按 policy 填充响应, policy 为空时使用
+// BlockPadding(468). 按 RFC 7830 不填充没有要求填充的请求; 客户端只在加密连接上填充,
+// 因此 DoT 与 DoH 的响应会被填充, 明文 tcp 只在客户端要求时填充
+func WithPadding(policy PaddingPolicy) ServerMiddleware {
+	if policy == nil {
+		policy = BlockPadding(defaultResponsePaddingBlock)
+	}
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			padded := req.Message.Padded()
+			resp, err := next.ServeDNS(ctx, req)
+			if err != nil || resp == nil || req.Network == "udp" || !padded {
+				return resp, err
+			}
+			if err := resp.Pad(policy); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestPadding(t *testing.T) {
+	q := NewQuery("www.example.com", DNSTypeA, WithDNSSECOK())
+	if err := q.Pad(BlockPadding(128)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := q.ToByte()
+	if err != nil || len(b) != 128 || !q.Padded() || !q.DNSSECOK() {
+		t.Fatalf("padded query is %d bytes, %v", len(b), err)
+	}
+	// 再次填充替换原有的 padding
+	if err := q.Pad(BlockPadding(128)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := q.ToByte(); len(b) != 128 || len(q.EDNSOptions()) != 1 {
+		t.Fatalf("repadded query is %d bytes with %d options", len(b), len(q.EDNSOptions()))
+	}
+	if err := q.Pad(MaximalPadding(512)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := q.ToByte(); len(b) != 512 {
+		t.Fatalf("maximal padding is %d bytes", len(b))
+	}
+	for i := 0; i < 10; i++ {
+		if n := RandomPadding(16)(100); n < 100 || n > 116 {
+			t.Fatalf("random padding %d", n)
+		}
+	}
+}
+
+func TestPaddingMiddleware(t *testing.T) {
+	handler := WithPadding(nil)(DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, "192.0.2.1")}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	}))
+	server := &DNSServer{Handler: handler}
+	var sizes []int
+	r := &Resolver{Server: "192.0.2.53:853", Transport: PipeTransport{Server: server}, Interceptors: []Interceptor{
+		PadQueries(nil),
+		AfterReceive(func(ctx context.Context, req, resp *DNSMessage) (*DNSMessage, error) {
+			b, err := req.ToByte()
+			if err != nil {
+				return nil, err
+			}
+			sizes = append(sizes, len(b))
+			if b, err = resp.ToByte(); err != nil {
+				return nil, err
+			}
+			sizes = append(sizes, len(b))
+			return resp, nil
+		}),
+	}}
+	if _, err := r.LookupHost(context.Background(), "www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] != 128 || sizes[1] != 468 {
+		t.Fatalf("query and response sizes %v", sizes)
+	}
+
+	// 没有要求填充的请求与 udp 请求不填充
+	for _, req := range []*DNSRequest{
+		{Message: NewQuery("www.example.com", DNSTypeA), Network: "tcp"},
+		{Message: NewQuery("www.example.com", DNSTypeA, WithEDNSOptions(0, EDNSOption{Code: EDNSOptionPadding})), Network: "udp"},
+	} {
+		resp, err := handler.ServeDNS(context.Background(), req)
+		if err != nil || resp.Padded() {
+			t.Fatalf("%s response padded: %v", req.Network, err)
+		}
+	}
+}
+
+func TestPaddingForwarded(t *testing.T) {
+	// 经过 tls 转发器时, 客户端的请求不能被填充, 明文 tcp 客户端没有要求时响应也不填充
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	_ = ln.Close()
+	c, err := ParseServerConfig([]byte(`forwarders: [{servers: ["`+closed+`"], network: tls, timeout: 1s}]`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &DNSRequest{Message: NewQuery("www.example.com", DNSTypeA), Network: "tcp"}
+	if _, err := h.ServeDNS(context.Background(), req); err == nil {
+		t.Fatal("forwarded to a closed port")
+	}
+	if req.Message.OPT() != nil {
+		t.Fatalf("client request modified: %+v", req.Message.EDNSOptions())
+	}
+
+	upstream := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		if !req.Message.Padded() {
+			t.Error("upstream query not padded")
+		}
+		return NewReply(req.Message), nil
+	})}
+	r := &Resolver{Server: "192.0.2.53:853", Transport: PipeTransport{Server: upstream}, Interceptors: []Interceptor{PadQueries(nil)}}
+	resp, err := WithPadding(nil)(ForwardHandler(r)).ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example.com", DNSTypeA), Network: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Padded() {
+		t.Fatal("response padded for a client that did not ask for it")
+	}
+}
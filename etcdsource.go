@@ -0,0 +1,210 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrEtcdStatus = errors.New("unexpected etcd gateway status")
+
+// EtcdSource 按 SkyDNS 格式从 etcd v3 的 JSON 网关读取记录.
+// www.example.com 对应键 /skydns/com/example/www, 其下的子键 (如 /skydns/com/example/www/x1) 为同名的多条记录,
+// 值为 {"host": "192.0.2.1", "port": 80, "priority": 10, "weight": 5, "text": "...", "ttl": 60}
+type EtcdSource struct {
+	// Endpoint etcd 地址, 如 http://127.0.0.1:2379
+	Endpoint string
+	// Prefix 默认 /skydns
+	Prefix string
+	// Token 不为空时作为 Authorization 头
+	Token string
+	// Client 为空时使用 http.DefaultClient
+	Client *http.Client
+	// TTL 记录未设置 ttl 时使用, 默认 5s
+	TTL uint32
+}
+
+// SkyDNSRecord etcd 中一个键的值
+type SkyDNSRecord struct {
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	Text     string `json:"text,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+}
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// skyDNSKey 将域名转换为倒序的键
+func (s *EtcdSource) skyDNSKey(name string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "/skydns"
+	}
+	labels := strings.Split(normalizeDomain(name), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.Join(labels, "/")
+}
+
+// etcdRangeEnd 前缀查询的 range_end, 最后一个字节加一
+func etcdRangeEnd(key string) string {
+	b := []byte(key)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xFF {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return "\x00"
+}
+
+func (s *EtcdSource) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.Endpoint, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", s.Token)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, errors.WithMessage(ErrEtcdStatus, resp.Status)
+	}
+	return resp, nil
+}
+
+// records 返回 name 对应的键及其子键中的记录
+func (s *EtcdSource) records(ctx context.Context, name string) ([]*SkyDNSRecord, error) {
+	key := s.skyDNSKey(name)
+	resp, err := s.post(ctx, "/v3/kv/range", map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(key)),
+		"range_end": base64.StdEncoding.EncodeToString([]byte(etcdRangeEnd(key))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var records []*SkyDNSRecord
+	for _, kv := range result.KVs {
+		k, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		// 范围查询会包含 www-1 这样的兄弟键
+		if string(k) != key && !strings.HasPrefix(string(k), key+"/") {
+			continue
+		}
+		v, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		record := &SkyDNSRecord{}
+		if err := json.Unmarshal(v, record); err != nil {
+			return nil, errors.WithMessage(err, "decode "+string(k))
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return records, nil
+}
+
+func (s *EtcdSource) LookupRecords(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error) {
+	records, err := s.records(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var result []*DNSResourceRecode
+	for _, record := range records {
+		ttl := record.TTL
+		if ttl == 0 {
+			ttl = s.TTL
+		}
+		if ttl == 0 {
+			ttl = defaultSourceTTL
+		}
+		switch {
+		case qtype == DNSTypeSRV && record.Host != "":
+			target := record.Host
+			if net.ParseIP(target) == nil {
+				target = strings.TrimSuffix(target, ".") + "."
+			}
+			result = append(result, srvRecord("", record.Priority, record.Weight, record.Port, target, ttl))
+		case qtype == DNSTypeTXT && record.Text != "":
+			result = append(result, txtRecord("", record.Text, ttl))
+		case record.Host == "":
+		case net.ParseIP(record.Host) != nil:
+			if rr := addressRecord("", record.Host, qtype, ttl); rr != nil {
+				result = append(result, rr)
+			}
+		case qtype == DNSTypeA || qtype == DNSTypeAAAA || qtype == DNSTypeCName:
+			// host 为域名时作为 CNAME
+			result = append(result, &DNSResourceRecode{RRType: DNSTypeCName, Class: DNSClassIn, TTL: ttl, RData: record.Host})
+		}
+	}
+	return result, nil
+}
+
+// Watch 监听 Prefix 下所有键的变化, 每批事件调用一次 fn
+func (s *EtcdSource) Watch(ctx context.Context, fn func()) error {
+	key := s.skyDNSKey("")
+	resp, err := s.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]string{
+			"key":       base64.StdEncoding.EncodeToString([]byte(key)),
+			"range_end": base64.StdEncoding.EncodeToString([]byte(etcdRangeEnd(key))),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var message struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if len(message.Result.Events) > 0 {
+			fn()
+		}
+	}
+}
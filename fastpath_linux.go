@@ -0,0 +1,437 @@
+//go:build linux && netx_fastpath
+
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	// ethPAll ETH_P_ALL, 同时接收 IPv4 与 IPv6, 由 BPF 过滤
+	ethPAll = 0x0003
+
+	defaultFastPathMaxAge = time.Second
+)
+
+// FastPath 实验性的 Linux 快速路径, 需要构建标签 netx_fastpath 与 CAP_NET_RAW.
+// 用 AF_PACKET 原始 socket 直接收发以太网帧, 绕过内核的 udp 协议栈: 请求只解析到问题部分,
+// 命中缓存时只改写 TxID 就发回, 未命中时交给 Server 完整处理并缓存可以缓存的响应.
+// 同一端口上另外绑定一个从不读取的 udp socket, 使内核不回复 ICMP 端口不可达; 其接收缓冲区满后内核直接丢弃.
+//
+// 命中时不经过 Server 的中间件, 也不减少 TTL (缓存最多保留 MaxAge), 因此只适合应答与客户端无关的场景.
+// 只处理没有 VLAN 标签, 没有 IP 选项与扩展头, 没有分片的帧. AF_XDP 需要加载 eBPF 程序, 不在此实现
+type FastPath struct {
+	Server    *DNSServer
+	Interface *net.Interface
+	// Addr 监听的地址, 地址为空时接受接口上的所有目的地址, 端口默认 53
+	Addr netip.AddrPort
+	// Workers 通过 PACKET_FANOUT 按流分担的 socket 数, 默认 1
+	Workers int
+	// MaxEntries 最多缓存的响应数, 默认 10000. MaxAge 响应最多缓存的时间, 默认 1s
+	MaxEntries int
+	MaxAge     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+// Serve 在 ctx 结束前处理请求, 返回 ctx 的错误
+func (f *FastPath) Serve(ctx context.Context) error {
+	if f.Server == nil || f.Interface == nil {
+		return errors.New("fast path requires a server and an interface")
+	}
+	port := f.port()
+	kernel, err := net.ListenPacket("udp", net.JoinHostPort(f.bindHost(), strconv.Itoa(int(port))))
+	if err != nil {
+		return errors.WithMessage(err, "bind kernel socket")
+	}
+	defer kernel.Close()
+	filter, err := fastPathFilter(port)
+	if err != nil {
+		return err
+	}
+	workers := f.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	// fanout 组 id 在网络命名空间内唯一, 用进程号与端口区分
+	group := (unix.Getpid() ^ int(port)) & 0xffff
+	var conns []*packetConn
+	defer func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		fc, err := openFrameConn(f.Interface, ethPAll)
+		if err != nil {
+			return errors.WithMessage(err, "open af_packet socket")
+		}
+		c := fc.(*packetConn)
+		conns = append(conns, c)
+		prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+		if err := unix.SetsockoptSockFprog(c.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+			return errors.WithMessage(err, "attach filter")
+		}
+		if workers > 1 {
+			if err := unix.SetsockoptInt(c.fd, unix.SOL_PACKET, unix.PACKET_FANOUT, group|unix.PACKET_FANOUT_HASH<<16); err != nil {
+				return errors.WithMessage(err, "join fanout group")
+			}
+		}
+	}
+	h := f.Server.handler()
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *packetConn) {
+			defer wg.Done()
+			f.loop(c, h)
+		}(c)
+	}
+	<-ctx.Done()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Stats 快速路径缓存的统计
+func (f *FastPath) Stats() CacheStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return CacheStats{Entries: len(f.entries), Hits: f.hits, Misses: f.misses}
+}
+
+func (f *FastPath) port() uint16 {
+	if f.Addr.Port() == 0 {
+		return 53
+	}
+	return f.Addr.Port()
+}
+
+func (f *FastPath) bindHost() string {
+	if !f.Addr.Addr().IsValid() || f.Addr.Addr().IsUnspecified() {
+		return ""
+	}
+	return f.Addr.Addr().String()
+}
+
+func (f *FastPath) loop(c *packetConn, h DNSHandler) {
+	buf := make([]byte, 65536)
+	for {
+		n, err := c.ReadFrame(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		f.handleFrame(buf[:n], h, func(reply []byte) { _ = c.WriteFrame(reply) })
+	}
+}
+
+// handleFrame 处理一个以太网帧, 应答帧交给 send. 命中时同步发送, 未命中时在新的 goroutine 中处理,
+// 不阻塞读取. 不是发给本服务的请求时忽略
+func (f *FastPath) handleFrame(frame []byte, h DNSHandler, send func([]byte)) {
+	p, ok := parseUDPFrame(frame)
+	if !ok || p.dstPort != f.port() || !bytes.Equal(p.dstMAC, f.Interface.HardwareAddr) {
+		return
+	}
+	if addr := f.Addr.Addr(); addr.IsValid() && !addr.IsUnspecified() && addr.Unmap() != p.dst {
+		return
+	}
+	key, qend, ok := fastPathKey(p.payload)
+	if !ok {
+		return
+	}
+	if resp := f.get(key, p.payload[:qend]); resp != nil {
+		send(p.reply(resp))
+		return
+	}
+	// frame 的缓冲区会被下一次读取覆盖
+	c := *p
+	c.dstMAC, c.srcMAC = append(net.HardwareAddr{}, p.dstMAC...), append(net.HardwareAddr{}, p.srcMAC...)
+	c.payload = append([]byte{}, p.payload...)
+	go func() {
+		if reply := f.slowPath(&c, key, h); reply != nil {
+			send(reply)
+		}
+	}()
+}
+
+// slowPath 交给 Server 完整处理, 可以缓存的响应放入缓存
+func (f *FastPath) slowPath(p *udpFrame, key string, h DNSHandler) []byte {
+	resp := f.Server.serve(h, p.payload, "udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(p.src, p.srcPort)),
+		net.UDPAddrFromAddrPort(netip.AddrPortFrom(p.dst, p.dstPort)))
+	if resp == nil {
+		return nil
+	}
+	// 帧不能超过 MTU, 超出时与 udp 截断相同
+	max := f.Interface.MTU - 8 - 20
+	if p.src.Is6() {
+		max = f.Interface.MTU - 8 - 40
+	}
+	msg, err := Unpack(resp)
+	if err != nil {
+		return nil
+	}
+	if len(resp) > max {
+		resp = truncate(msg, max)
+	} else if ttl, ok := cacheTTL(msg); ok && msg.Header.Flags.TC == 0 {
+		f.put(key, resp, ttl)
+	}
+	return p.reply(resp)
+}
+
+// get 返回缓存的响应, TxID 与问题的大小写改为与请求相同. question 为请求到问题名字结束的部分
+func (f *FastPath) get(key string, question []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[key]
+	if ok && time.Now().After(e.expires) {
+		delete(f.entries, key)
+		ok = false
+	}
+	if !ok {
+		f.misses++
+		return nil
+	}
+	f.hits++
+	resp := append([]byte{}, e.packet...)
+	copy(resp, question[:2])
+	// 响应的问题紧跟在头之后且不压缩, 名字长度与请求相同
+	if len(resp) >= len(question) {
+		copy(resp[12:], question[12:])
+	}
+	return resp
+}
+
+func (f *FastPath) put(key string, resp []byte, ttl time.Duration) {
+	maxAge := f.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultFastPathMaxAge
+	}
+	if ttl > maxAge {
+		ttl = maxAge
+	}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.entries == nil {
+		f.entries = map[string]*cacheEntry{}
+	}
+	max := f.MaxEntries
+	if max <= 0 {
+		max = defaultCacheEntries
+	}
+	if len(f.entries) >= max {
+		for k, e := range f.entries {
+			if now.After(e.expires) || len(f.entries) >= max {
+				delete(f.entries, k)
+			}
+		}
+	}
+	f.entries[key] = &cacheEntry{packet: resp, stored: now, expires: now.Add(ttl)}
+}
+
+// fastPathKey 只解析头与问题: 标志位, 小写的问题与问题之后的全部内容 (通常是 OPT) 组成键,
+// 命中的响应因此与请求一一对应. qend 为问题名字结束的位置. 不是单个问题的标准查询时返回 false
+func fastPathKey(query []byte) (key string, qend int, ok bool) {
+	if len(query) < 12 || query[2]&0xf8 != 0 || binary.BigEndian.Uint16(query[4:]) != 1 ||
+		binary.BigEndian.Uint16(query[6:]) != 0 || binary.BigEndian.Uint16(query[8:]) != 0 {
+		return "", 0, false
+	}
+	b := append([]byte{}, query[2:4]...)
+	i := 12
+	for {
+		if i >= len(query) {
+			return "", 0, false
+		}
+		l := int(query[i])
+		if l == 0 {
+			break
+		}
+		// 请求中不应有压缩指针
+		if l > 63 || i+1+l > len(query) {
+			return "", 0, false
+		}
+		b = append(b, byte(l))
+		for _, c := range query[i+1 : i+1+l] {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			b = append(b, c)
+		}
+		i += 1 + l
+	}
+	if i+5 > len(query) {
+		return "", 0, false
+	}
+	return string(append(append(b, 0), query[i+1:]...)), i + 1, true
+}
+
+// udpFrame 解析出的 udp 帧
+type udpFrame struct {
+	dstMAC, srcMAC   net.HardwareAddr
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+	payload          []byte
+}
+
+// parseUDPFrame 解析以太网 + IPv4/IPv6 + udp, 不处理 VLAN, IP 选项, 扩展头与分片
+func parseUDPFrame(frame []byte) (*udpFrame, bool) {
+	if len(frame) < 14 {
+		return nil, false
+	}
+	p := &udpFrame{dstMAC: frame[0:6], srcMAC: frame[6:12]}
+	var udp []byte
+	switch binary.BigEndian.Uint16(frame[12:]) {
+	case etherTypeIPv4:
+		ip := frame[14:]
+		if len(ip) < 20+8 || ip[0] != 0x45 || ip[9] != unix.IPPROTO_UDP || binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+			return nil, false
+		}
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if total < 28 || total > len(ip) {
+			return nil, false
+		}
+		p.src, _ = netip.AddrFromSlice(ip[12:16])
+		p.dst, _ = netip.AddrFromSlice(ip[16:20])
+		udp = ip[20:total]
+	case etherTypeIPv6:
+		ip := frame[14:]
+		if len(ip) < 40+8 || ip[0]>>4 != 6 || ip[6] != unix.IPPROTO_UDP {
+			return nil, false
+		}
+		payload := int(binary.BigEndian.Uint16(ip[4:]))
+		if payload < 8 || 40+payload > len(ip) {
+			return nil, false
+		}
+		p.src, _ = netip.AddrFromSlice(ip[8:24])
+		p.dst, _ = netip.AddrFromSlice(ip[24:40])
+		udp = ip[40 : 40+payload]
+	default:
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		return nil, false
+	}
+	p.srcPort, p.dstPort = binary.BigEndian.Uint16(udp[0:]), binary.BigEndian.Uint16(udp[2:])
+	p.payload = udp[8:length]
+	return p, true
+}
+
+// reply 构造发回请求方的帧, 交换地址与端口, 计算 IPv4 头与 udp 的校验和
+func (p *udpFrame) reply(payload []byte) []byte {
+	udpLen := 8 + len(payload)
+	var frame []byte
+	frame = append(frame, p.srcMAC...)
+	frame = append(frame, p.dstMAC...)
+	var pseudo []byte
+	if p.src.Is4() {
+		frame = append(frame, 0x08, 0x00)
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+udpLen))
+		ip[6] = 0x40 // DF
+		ip[8], ip[9] = 64, unix.IPPROTO_UDP
+		copy(ip[12:], p.dst.AsSlice())
+		copy(ip[16:], p.src.AsSlice())
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+		frame = append(frame, ip...)
+		pseudo = append(append(pseudo, ip[12:20]...), 0, unix.IPPROTO_UDP, byte(udpLen>>8), byte(udpLen))
+	} else {
+		frame = append(frame, 0x86, 0xdd)
+		ip := make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+		ip[6], ip[7] = unix.IPPROTO_UDP, 64
+		copy(ip[8:], p.dst.AsSlice())
+		copy(ip[24:], p.src.AsSlice())
+		frame = append(frame, ip...)
+		pseudo = append(append(pseudo, ip[8:40]...), 0, 0, byte(udpLen>>8), byte(udpLen), 0, 0, 0, unix.IPPROTO_UDP)
+	}
+	udp := make([]byte, 8, udpLen)
+	binary.BigEndian.PutUint16(udp[0:], p.dstPort)
+	binary.BigEndian.PutUint16(udp[2:], p.srcPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	udp = append(udp, payload...)
+	sum := checksum(checksumPartial(0, pseudo), udp)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return append(frame, udp...)
+}
+
+// checksumPartial 累加 16 位的反码和, 不折叠
+func checksumPartial(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+// checksum 互联网校验和
+func checksum(sum uint32, b []byte) uint16 {
+	sum = checksumPartial(sum, b)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// fastPathFilter 编译 fastPathProgram
+func fastPathFilter(port uint16) ([]unix.SockFilter, error) {
+	raw, err := bpf.Assemble(fastPathProgram(port))
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return filter, nil
+}
+
+// fastPathProgram 只接收目的端口为 port 的 udp 帧 (IPv4 不含分片, IPv6 不含扩展头)
+func fastPathProgram(port uint16) []bpf.Instruction {
+	return []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherTypeIPv4, SkipFalse: 7},
+		bpf.LoadAbsolute{Off: 23, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.IPPROTO_UDP, SkipTrue: 11},
+		bpf.LoadAbsolute{Off: 20, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x3fff, SkipTrue: 9},
+		bpf.LoadMemShift{Off: 14},
+		bpf.LoadIndirect{Off: 16, Size: 2},
+		bpf.Jump{Skip: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherTypeIPv6, SkipFalse: 5},
+		bpf.LoadAbsolute{Off: 20, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.IPPROTO_UDP, SkipTrue: 3},
+		bpf.LoadAbsolute{Off: 56, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(port), SkipTrue: 1},
+		bpf.RetConstant{Val: 0x40000},
+		bpf.RetConstant{Val: 0},
+	}
+}
@@ -0,0 +1,139 @@
+//go:build linux && netx_fastpath
+
+package netx
+
+import (
+	"encoding/binary"
+	"golang.org/x/net/bpf"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	fastPathServerMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x53}
+	fastPathClientMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+)
+
+// queryFrame 构造客户端发给服务器 53 端口的帧
+func queryFrame(t *testing.T, client, server string, msg *DNSMessage) []byte {
+	t.Helper()
+	payload, err := msg.ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reply 交换方向, 因此以服务器为源构造
+	p := &udpFrame{dstMAC: fastPathClientMAC, srcMAC: fastPathServerMAC, src: netip.MustParseAddr(server), dst: netip.MustParseAddr(client), srcPort: 53, dstPort: 40000}
+	return p.reply(payload)
+}
+
+// checkReply 解析应答帧并校验方向与校验和
+func checkReply(t *testing.T, frame []byte, client string) *DNSMessage {
+	t.Helper()
+	p, ok := parseUDPFrame(frame)
+	if !ok || p.dst != netip.MustParseAddr(client) || p.dstPort != 40000 || p.srcPort != 53 || p.dstMAC.String() != fastPathClientMAC.String() {
+		t.Fatalf("reply frame %+v", p)
+	}
+	udp := frame[len(frame)-8-len(p.payload):]
+	var pseudo []byte
+	if p.src.Is4() {
+		if checksum(0, frame[14:34]) != 0 {
+			t.Fatal("bad ipv4 header checksum")
+		}
+		pseudo = append(append(p.src.AsSlice(), p.dst.AsSlice()...), 0, 17, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	} else {
+		pseudo = append(append(p.src.AsSlice(), p.dst.AsSlice()...), 0, 0, 0, 0, 0, 0, 0, 17)
+		binary.BigEndian.PutUint16(pseudo[34:], uint16(len(udp)))
+	}
+	if checksum(checksumPartial(0, pseudo), udp) != 0 {
+		t.Fatal("bad udp checksum")
+	}
+	msg, err := Unpack(p.payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestFastPathFilter(t *testing.T) {
+	vm, err := bpf.NewVM(fastPathProgram(53))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuery("www.example", DNSTypeA)
+	v4 := queryFrame(t, "192.0.2.10", "192.0.2.53", q)
+	v6 := queryFrame(t, "2001:db8::10", "2001:db8::53", q)
+	other := append([]byte{}, v4...)
+	binary.BigEndian.PutUint16(other[14+22:], 54)
+	fragment := append([]byte{}, v4...)
+	fragment[14+6] |= 0x20
+	tcp := append([]byte{}, v6...)
+	tcp[14+6] = 6
+	for _, c := range []struct {
+		name   string
+		frame  []byte
+		accept bool
+	}{{"ipv4", v4, true}, {"ipv6", v6, true}, {"other port", other, false}, {"fragment", fragment, false}, {"tcp", tcp, false}} {
+		if n, err := vm.Run(c.frame); err != nil || (n > 0) != c.accept {
+			t.Fatalf("%s: %d %v", c.name, n, err)
+		}
+	}
+}
+
+func TestFastPath(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &FastPath{Server: &DNSServer{Handler: z}, Interface: &net.Interface{MTU: 1500, HardwareAddr: fastPathServerMAC}}
+	sent := make(chan []byte, 1)
+	send := func(b []byte) { sent <- b }
+	recv := func() []byte {
+		select {
+		case b := <-sent:
+			return b
+		case <-time.After(time.Second):
+			t.Fatal("no reply")
+			return nil
+		}
+	}
+	for _, addrs := range [][2]string{{"192.0.2.10", "192.0.2.53"}, {"2001:db8::10", "2001:db8::53"}} {
+		// 未命中时完整处理
+		q := NewQuery("www.example", DNSTypeA)
+		f.handleFrame(queryFrame(t, addrs[0], addrs[1], q), f.Server.handler(), send)
+		resp := checkReply(t, recv(), addrs[0])
+		if resp.Header.TxID != q.Header.TxID || len(resp.Answers()) != 1 {
+			t.Fatalf("slow path reply %+v", resp)
+		}
+		// 命中时改写 TxID 与问题的大小写
+		q = NewQuery("WwW.Example", DNSTypeA)
+		f.handleFrame(queryFrame(t, addrs[0], addrs[1], q), f.Server.handler(), send)
+		select {
+		case b := <-sent:
+			resp = checkReply(t, b, addrs[0])
+		default:
+			t.Fatal("cache hit not answered synchronously")
+		}
+		if resp.Header.TxID != q.Header.TxID || resp.Questions[0].QuestionName != "WwW.Example" || len(resp.Answers()) != 1 {
+			t.Fatalf("fast path reply %+v", resp)
+		}
+		f.mu.Lock()
+		f.entries = nil
+		f.mu.Unlock()
+	}
+	if st := f.Stats(); st.Hits != 2 || st.Misses != 2 {
+		t.Fatalf("stats %+v", st)
+	}
+	// 发给其他 MAC 的帧忽略
+	frame := queryFrame(t, "192.0.2.10", "192.0.2.53", NewQuery("www.example", DNSTypeA))
+	frame[5] = 0x99
+	f.handleFrame(frame, f.Server.handler(), send)
+	select {
+	case <-sent:
+		t.Fatal("frame for another host answered")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,36 @@
+package netx
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+)
+
+var ErrReEncode = errors.New("decode-encode-decode mismatch")
+
+// ReEncode 对 data 做 解码→编码→解码, 用于模糊测试.
+// data 无法解码时返回 Unpack 的错误; 解码成功但无法重新编码或结果不一致时返回 ErrReEncode
+func ReEncode(data []byte) (*DNSMessage, error) {
+	first, err := Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := first.ToByte()
+	if err != nil {
+		return nil, errors.WithMessage(ErrReEncode, "encode: "+err.Error())
+	}
+	second, err := Unpack(encoded)
+	if err != nil {
+		return nil, errors.WithMessage(ErrReEncode, "decode: "+err.Error())
+	}
+	again, err := second.ToByte()
+	if err != nil {
+		return nil, errors.WithMessage(ErrReEncode, "encode: "+err.Error())
+	}
+	if !bytes.Equal(encoded, again) {
+		return nil, errors.WithMessage(ErrReEncode, "wire differs")
+	}
+	if !first.Equal(second) {
+		return nil, errors.WithMessage(ErrReEncode, "message differs")
+	}
+	return first, nil
+}
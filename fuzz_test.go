@@ -0,0 +1,15 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func FuzzUnpack(f *testing.F) {
+	// 种子语料见 testdata/fuzz/FuzzUnpack
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := ReEncode(data); errors.Cause(err) == ErrReEncode {
+			t.Fatal(err)
+		}
+	})
+}
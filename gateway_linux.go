@@ -0,0 +1,47 @@
+package netx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const rtfGateway = 0x2
+
+// DefaultGateway 从 /proc/net/route 读取 ipv4 默认网关
+func DefaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, errors.WithMessage(err, "read route table error")
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfGateway == 0 {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		// 内核按主机字节序输出
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(b))
+		return ip, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrNoGateway
+}
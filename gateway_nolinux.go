@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package netx
+
+import (
+	"net"
+)
+
+func DefaultGateway() (net.IP, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,111 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// GeoInfo 地址的位置信息, 未知的字段为零值
+type GeoInfo struct {
+	Country   string // ISO 3166-1 两位国家代码
+	Continent string // 两位大洲代码, 如 AS, EU, NA
+	ASN       uint32
+	Latitude  float64
+	Longitude float64
+	// Network 数据库中与该地址具有相同信息的网络, 用于 ECS 应答的 scope
+	Network *net.IPNet
+}
+
+// GeoProvider 按地址查询位置, 没有数据时返回 ErrGeoNotFound
+type GeoProvider interface {
+	LookupGeo(ip net.IP) (*GeoInfo, error)
+}
+
+// GeoProviderFunc 允许将普通函数作为 GeoProvider 使用
+type GeoProviderFunc func(ip net.IP) (*GeoInfo, error)
+
+func (f GeoProviderFunc) LookupGeo(ip net.IP) (*GeoInfo, error) {
+	return f(ip)
+}
+
+// GeoAnswers 一个名字按位置区分的记录, 依次按国家, 大洲匹配, 都不匹配或位置未知时使用 Default.
+// 记录的 Name 为空时使用问题中的名字
+type GeoAnswers struct {
+	Country   map[string][]*DNSResourceRecode
+	Continent map[string][]*DNSResourceRecode
+	Default   []*DNSResourceRecode
+}
+
+func (g *GeoAnswers) choose(info *GeoInfo) []*DNSResourceRecode {
+	if info != nil {
+		if rrs, ok := g.Country[strings.ToUpper(info.Country)]; ok && info.Country != "" {
+			return rrs
+		}
+		if rrs, ok := g.Continent[strings.ToUpper(info.Continent)]; ok && info.Continent != "" {
+			return rrs
+		}
+	}
+	return g.Default
+}
+
+// WithGeoAnswers 对 names 中的名字按客户端位置应答, 其它请求交给 next.
+// 请求带有 ECS 选项时使用其中的地址定位, 并在应答中按 GeoInfo.Network 回填 scope
+func WithGeoAnswers(provider GeoProvider, names map[string]*GeoAnswers) ServerMiddleware {
+	zone := make(map[string]*GeoAnswers, len(names))
+	for name, answers := range names {
+		zone[normalizeDomain(name)] = answers
+	}
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			msg := req.Message
+			if len(msg.Questions) != 1 {
+				return next.ServeDNS(ctx, req)
+			}
+			q := msg.Questions[0]
+			answers, ok := zone[normalizeDomain(q.QuestionName)]
+			if !ok {
+				return next.ServeDNS(ctx, req)
+			}
+
+			ip := req.ClientIP()
+			ecs := msg.ClientSubnet()
+			if ecs != nil && ecs.SourcePrefix > 0 {
+				ip = ecs.IP
+			}
+			var info *GeoInfo
+			if ip != nil && provider != nil {
+				info, _ = provider.LookupGeo(ip)
+			}
+
+			resp := NewReply(msg)
+			resp.Header.Flags.AA = 1
+			for _, rr := range answers.choose(info) {
+				if rr.RRType != q.QuestionType && rr.RRType != DNSTypeCName {
+					continue
+				}
+				answer := *rr
+				if answer.Name == "" {
+					answer.Name = q.QuestionName
+				}
+				resp.ResourceRecodes = append(resp.ResourceRecodes, &answer)
+			}
+			resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+			if msg.OPT() != nil {
+				var options []EDNSOption
+				if ecs != nil {
+					// 应答对数据库中的整个网络有效
+					reply := *ecs
+					reply.ScopePrefix = ecs.SourcePrefix
+					if info != nil && info.Network != nil {
+						ones, _ := info.Network.Mask.Size()
+						reply.ScopePrefix = uint8(ones)
+					}
+					options = append(options, reply.Option())
+				}
+				resp.SetEDNS(0, options)
+			}
+			return resp, nil
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+)
+
+// mmdbBuilder 构造测试用的 ipv6 MaxMind DB, 记录大小 24 位.
+// 记录值: -1 为空, 小于 -1 时为 -(数据偏移+2), 其它为节点编号
+type mmdbBuilder struct {
+	nodes [][2]int
+	data  []byte
+}
+
+func (b *mmdbBuilder) insert(cidr string, value []byte) {
+	_, n, _ := net.ParseCIDR(cidr)
+	ones, _ := n.Mask.Size()
+	ip := n.IP.To16()
+	if v4 := n.IP.To4(); v4 != nil {
+		ip = append(make(net.IP, 12), v4...)
+		ones += 96
+	}
+	if len(b.nodes) == 0 {
+		b.nodes = append(b.nodes, [2]int{-1, -1})
+	}
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+		if i == ones-1 {
+			b.nodes[node][bit] = -(len(b.data) + 2)
+			break
+		}
+		if b.nodes[node][bit] < 0 {
+			b.nodes = append(b.nodes, [2]int{-1, -1})
+			b.nodes[node][bit] = len(b.nodes) - 1
+		}
+		node = b.nodes[node][bit]
+	}
+	b.data = append(b.data, value...)
+}
+
+func (b *mmdbBuilder) bytes() []byte {
+	count := len(b.nodes)
+	var out []byte
+	for _, node := range b.nodes {
+		for _, r := range node {
+			v := r
+			switch {
+			case r == -1:
+				v = count
+			case r < -1:
+				v = count + 16 + (-r - 2)
+			}
+			out = append(out, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, b.data...)
+	out = append(out, mmdbMetadataMarker...)
+	return append(out, mmdbMap(
+		mmdbStr("node_count"), []byte{0xC4, byte(count >> 24), byte(count >> 16), byte(count >> 8), byte(count)},
+		mmdbStr("record_size"), []byte{0xA2, 0, 24},
+		mmdbStr("ip_version"), []byte{0xA1, 6},
+		mmdbStr("database_type"), mmdbStr("Test-City"),
+	)...)
+}
+
+func mmdbStr(s string) []byte {
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+func mmdbMap(kv ...[]byte) []byte {
+	out := []byte{0xE0 | byte(len(kv)/2)}
+	for _, b := range kv {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func mmdbDouble(f float64) []byte {
+	out := []byte{0x68, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(out[1:], math.Float64bits(f))
+	return out
+}
+
+func testMMDB(t *testing.T) *MMDB {
+	b := &mmdbBuilder{}
+	b.insert("192.0.2.0/24", mmdbMap(
+		mmdbStr("country"), mmdbMap(mmdbStr("iso_code"), mmdbStr("JP")),
+		mmdbStr("continent"), mmdbMap(mmdbStr("code"), mmdbStr("AS")),
+		mmdbStr("location"), mmdbMap(mmdbStr("latitude"), mmdbDouble(35.69), mmdbStr("longitude"), mmdbDouble(139.69)),
+	))
+	// map 控制字节与 "continent" 键之后即为 continent 的值
+	europe := len(b.data) + 1 + 10
+	b.insert("198.51.100.0/25", mmdbMap(
+		mmdbStr("continent"), mmdbMap(mmdbStr("code"), mmdbStr("EU")),
+		mmdbStr("registered_country"), mmdbMap(mmdbStr("iso_code"), mmdbStr("DE")),
+	))
+	// 通过指针复用上一条记录中的 continent
+	b.insert("2001:db8::/32", mmdbMap(
+		mmdbStr("continent"), []byte{0x20 | byte(europe>>8), byte(europe)},
+		mmdbStr("autonomous_system_number"), []byte{0xC2, 0xFD, 0xE8},
+	))
+	db, err := NewMMDB(b.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMMDB(t *testing.T) {
+	db := testMMDB(t)
+	if db.Metadata().DatabaseType != "Test-City" || db.Metadata().IPVersion != 6 {
+		t.Fatalf("metadata = %+v", db.Metadata())
+	}
+	info, err := db.LookupGeo(net.ParseIP("192.0.2.55"))
+	if err != nil || info.Country != "JP" || info.Continent != "AS" || info.Latitude != 35.69 || info.Network.String() != "192.0.2.0/24" {
+		t.Fatalf("jp = %+v, %v", info, err)
+	}
+	info, err = db.LookupGeo(net.ParseIP("198.51.100.1"))
+	if err != nil || info.Country != "DE" || info.Continent != "EU" {
+		t.Fatalf("de = %+v, %v", info, err)
+	}
+	info, err = db.LookupGeo(net.ParseIP("2001:db8::1"))
+	if err != nil || info.Continent != "EU" || info.ASN != 65000 || info.Network.String() != "2001:db8::/32" {
+		t.Fatalf("v6 = %+v, %v", info, err)
+	}
+	if _, err := db.LookupGeo(net.ParseIP("198.51.100.200")); err != ErrGeoNotFound {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := NewMMDB([]byte("not a database")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestWithGeoAnswers(t *testing.T) {
+	a := func(ip string) *DNSResourceRecode {
+		return &DNSResourceRecode{RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: ip}
+	}
+	h := ChainHandler(nil, WithGeoAnswers(testMMDB(t), map[string]*GeoAnswers{
+		"www.example.com.": {
+			Country:   map[string][]*DNSResourceRecode{"JP": {a("10.0.0.1")}},
+			Continent: map[string][]*DNSResourceRecode{"EU": {a("10.0.0.2")}},
+			Default:   []*DNSResourceRecode{a("10.0.0.3")},
+		},
+	}))
+	serve := func(client string, ecs *ClientSubnet) *DNSMessage {
+		req := NewQuery("WWW.example.com", DNSTypeA)
+		if ecs != nil {
+			req.SetEDNS(0, []EDNSOption{ecs.Option()})
+		}
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{
+			Message:    req,
+			Network:    "udp",
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 5353},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := resp.ToByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, err = Unpack(b); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := serve("192.0.2.9", nil); resp.Answers()[0].RData != "10.0.0.1" || resp.OPT() != nil {
+		t.Fatalf("client jp = %+v", resp.Answers()[0])
+	}
+	if resp := serve("127.0.0.1", nil); resp.Answers()[0].RData != "10.0.0.3" {
+		t.Fatalf("default = %+v", resp.Answers()[0])
+	}
+	resp := serve("127.0.0.1", &ClientSubnet{IP: net.ParseIP("198.51.100.7"), SourcePrefix: 24})
+	ecs := resp.ClientSubnet()
+	if resp.Answers()[0].RData != "10.0.0.2" || ecs == nil || ecs.SourcePrefix != 24 || ecs.ScopePrefix != 25 || ecs.IP.String() != "198.51.100.0" {
+		t.Fatalf("ecs eu = %+v, %+v", resp.Answers()[0], ecs)
+	}
+	req := NewQuery("other.example.com", DNSTypeA)
+	if resp, _ := h.ServeDNS(context.Background(), &DNSRequest{Message: req}); resp.Header.Flags.RCode != 5 {
+		t.Fatalf("passthrough rcode = %d", resp.Header.Flags.RCode)
+	}
+}
@@ -1,5 +1,14 @@
 module github.com/moyrne/netx
 
-go 1.16
+go 1.18
 
-require github.com/pkg/errors v0.9.1
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.10.0
+	golang.org/x/net v0.11.0
+	golang.org/x/sys v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.10.0 // indirect
@@ -0,0 +1,316 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 与 systemd socket 激活相同的环境变量, 继承的 fd 从 3 开始
+const (
+	listenFDsEnv   = "LISTEN_FDS"
+	listenPIDEnv   = "LISTEN_PID"
+	listenNamesEnv = "LISTEN_FDNAMES"
+	listenFDStart  = 3
+	readyName      = "netx-ready"
+)
+
+var ErrHandoff = errors.New("replacement process exited before ready")
+
+// SocketSet 可以交接给新进程的监听 socket, 以 "network/address" 标识.
+// 旧进程用 StartProcess 启动新进程并传递所有 socket, 新进程用 InheritSockets 取回, 通过 Ready 通知旧进程后,
+// 旧进程调用 DNSServer.Shutdown 排空处理中的请求. 期间 socket 一直打开, 客户端的请求不会丢失. 可以并发使用
+type SocketSet struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	active    map[string]fileSocket
+	order     []string
+	ready     *os.File
+}
+
+// InheritSockets 读取父进程或 systemd 传递的 socket. 没有传递时返回空集合, 之后的 Listen 新建 socket
+func InheritSockets() (*SocketSet, error) {
+	s := &SocketSet{inherited: map[string]*os.File{}, active: map[string]fileSocket{}}
+	count := os.Getenv(listenFDsEnv)
+	if count == "" {
+		return s, nil
+	}
+	// systemd 设置 LISTEN_PID, 不属于本进程时忽略
+	if pid := os.Getenv(listenPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return s, nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, errors.WithMessage(ErrHandoff, "bad "+listenFDsEnv+" "+count)
+	}
+	names := strings.Split(os.Getenv(listenNamesEnv), ":")
+	for i := 0; i < n; i++ {
+		name := "fd" + strconv.Itoa(listenFDStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+		}
+		f := os.NewFile(uintptr(listenFDStart+i), name)
+		if name == readyName {
+			s.ready = f
+			continue
+		}
+		s.inherited[name] = f
+	}
+	for _, key := range []string{listenFDsEnv, listenPIDEnv, listenNamesEnv} {
+		_ = os.Unsetenv(key)
+	}
+	return s, nil
+}
+
+// fileSocket 可以复制出 fd 的 socket, 如 *net.TCPListener, *net.UDPConn
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+func socketName(network, address string) string {
+	return network + "/" + address
+}
+
+// take 取出继承的 socket
+func (s *SocketSet) take(name string) *os.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.inherited[name]
+	delete(s.inherited, name)
+	return f
+}
+
+func (s *SocketSet) add(name string, sock fileSocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		s.active = map[string]fileSocket{}
+	}
+	if _, ok := s.active[name]; !ok {
+		s.order = append(s.order, name)
+	}
+	s.active[name] = sock
+}
+
+// Listen 优先使用继承的 socket, 否则新建. network 为 tcp, tcp4, tcp6 或 unix
+func (s *SocketSet) Listen(network, address string) (net.Listener, error) {
+	return s.listen(nil, network, address)
+}
+
+// listen 新建的 socket 按 b 绑定, 继承的 socket 保持原来的绑定
+func (s *SocketSet) listen(b *Binding, network, address string) (net.Listener, error) {
+	name := socketName(network, address)
+	var ln net.Listener
+	var err error
+	if f := s.take(name); f != nil {
+		ln, err = net.FileListener(f)
+		_ = f.Close()
+	} else {
+		ln, err = b.Listen(context.Background(), network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sock, ok := ln.(fileSocket)
+	if !ok {
+		_ = ln.Close()
+		return nil, errors.WithMessage(ErrNotSupported, name)
+	}
+	s.add(name, sock)
+	return ln, nil
+}
+
+// ListenPacket 优先使用继承的 socket, 否则新建. network 为 udp, udp4 或 udp6
+func (s *SocketSet) ListenPacket(network, address string) (net.PacketConn, error) {
+	return s.listenPacket(nil, network, address)
+}
+
+func (s *SocketSet) listenPacket(b *Binding, network, address string) (net.PacketConn, error) {
+	name := socketName(network, address)
+	var conn net.PacketConn
+	var err error
+	if f := s.take(name); f != nil {
+		conn, err = net.FilePacketConn(f)
+		_ = f.Close()
+	} else {
+		conn, err = b.ListenPacket(context.Background(), network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sock, ok := conn.(fileSocket)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.WithMessage(ErrNotSupported, name)
+	}
+	s.add(name, sock)
+	return conn, nil
+}
+
+// ListenUDPReusePort 与 ListenUDPReusePort 相同, 优先使用继承的一组 socket
+func (s *SocketSet) ListenUDPReusePort(ctx context.Context, network, address string, opts *UDPListenOptions) ([]net.PacketConn, error) {
+	name := socketName(network, address)
+	var conns []net.PacketConn
+	for i := 0; ; i++ {
+		f := s.take(name + "#" + strconv.Itoa(i))
+		if f == nil {
+			break
+		}
+		conn, err := net.FilePacketConn(f)
+		_ = f.Close()
+		if err != nil {
+			_ = packetConns(conns).Close()
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		var err error
+		if conns, err = ListenUDPReusePort(ctx, network, address, opts); err != nil {
+			return nil, err
+		}
+	}
+	for i, conn := range conns {
+		sock, ok := conn.(fileSocket)
+		if !ok {
+			_ = packetConns(conns).Close()
+			return nil, errors.WithMessage(ErrNotSupported, name)
+		}
+		s.add(name+"#"+strconv.Itoa(i), sock)
+	}
+	return conns, nil
+}
+
+// Forget 不再把 network/address 传递给新进程, 在关闭对应的 socket 前调用
+func (s *SocketSet) Forget(network, address string) {
+	name := socketName(network, address)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var order []string
+	for _, n := range s.order {
+		if n == name || strings.HasPrefix(n, name+"#") {
+			delete(s.active, n)
+			continue
+		}
+		order = append(order, n)
+	}
+	s.order = order
+}
+
+// Close 关闭没有被 Listen 取回的继承 socket
+func (s *SocketSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, f := range s.inherited {
+		_ = f.Close()
+		delete(s.inherited, name)
+	}
+	return nil
+}
+
+// Ready 通知启动本进程的旧进程已经开始服务, 不是由 StartProcess 启动时不做任何事
+func (s *SocketSet) Ready() error {
+	s.mu.Lock()
+	f := s.ready
+	s.ready = nil
+	s.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	defer f.Close()
+	_, err := f.Write([]byte{1})
+	return err
+}
+
+// Handoff 已启动的新进程
+type Handoff struct {
+	Process *os.Process
+	ready   chan error
+}
+
+// Wait 等待新进程调用 Ready, 新进程在此之前退出时返回 ErrHandoff
+func (h *Handoff) Wait(ctx context.Context) error {
+	select {
+	case err := <-h.ready:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartProcess 启动新进程并传递当前所有 socket, path 为空时使用当前可执行文件, args 不包括程序名.
+// 新进程继承标准输入输出与环境变量. windows 不支持传递 socket
+func (s *SocketSet) StartProcess(path string, args ...string) (*Handoff, error) {
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		path = exe
+	}
+	s.mu.Lock()
+	var files []*os.File
+	var names []string
+	for _, name := range s.order {
+		f, err := s.active[name].File()
+		if err != nil {
+			s.mu.Unlock()
+			closeFiles(files)
+			return nil, errors.WithMessage(err, name)
+		}
+		files = append(files, f)
+		// 名字中的 ":" 与 LISTEN_FDNAMES 的分隔符冲突
+		names = append(names, url.QueryEscape(name))
+	}
+	s.mu.Unlock()
+	defer closeFiles(files)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	files = append(files, w)
+	names = append(names, readyName)
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listenFDsEnv+"="+strconv.Itoa(len(files)),
+		listenNamesEnv+"="+strings.Join(names, ":"),
+	)
+	if err := cmd.Start(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	h := &Handoff{Process: cmd.Process, ready: make(chan error, 1)}
+	go func() {
+		defer r.Close()
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			h.ready <- ErrHandoff
+			return
+		}
+		h.ready <- nil
+	}()
+	// 回收新进程, 避免僵尸进程
+	go func() { _ = cmd.Wait() }()
+	return h, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
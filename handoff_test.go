@@ -0,0 +1,82 @@
+package netx
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestHandoffChild 作为 TestSocketHandoff 启动的新进程运行
+func TestHandoffChild(t *testing.T) {
+	if os.Getenv("NETX_HANDOFF_CHILD") == "" {
+		t.Skip("only runs as replacement process")
+	}
+	sockets, err := InheritSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sockets.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.2"}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	go s.ServeUDP(conn)
+	if err := sockets.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Second)
+}
+
+func TestSocketHandoff(t *testing.T) {
+	sockets, err := InheritSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sockets.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	go s.ServeUDP(conn)
+	r := &Resolver{Server: conn.LocalAddr().String()}
+	lookup := func() string {
+		addrs, err := r.LookupHost(context.Background(), "www.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addrs[0]
+	}
+	if got := lookup(); got != "192.0.2.1" {
+		t.Fatalf("before handoff = %s", got)
+	}
+
+	os.Setenv("NETX_HANDOFF_CHILD", "1")
+	h, err := sockets.StartProcess(os.Args[0], "-test.run=^TestHandoffChild$")
+	os.Unsetenv("NETX_HANDOFF_CHILD")
+	if err != nil {
+		t.Skipf("start process: %v", err)
+	}
+	defer h.Process.Kill()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookup(); got != "192.0.2.2" {
+		t.Fatalf("after handoff = %s", got)
+	}
+}
@@ -0,0 +1,398 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrHealthStatus = errors.New("unexpected health check http status")
+	ErrEchoTimeout  = errors.New("icmp echo timeout")
+)
+
+// HealthCheck 检查一个目标地址 (不含端口), 返回错误表示不健康
+type HealthCheck interface {
+	Check(ctx context.Context, addr string) error
+}
+
+// HealthCheckFunc 允许将普通函数作为 HealthCheck 使用
+type HealthCheckFunc func(ctx context.Context, addr string) error
+
+func (f HealthCheckFunc) Check(ctx context.Context, addr string) error {
+	return f(ctx, addr)
+}
+
+// TCPHealthCheck 能建立 tcp 连接即为健康
+type TCPHealthCheck struct {
+	Port int
+	// Dialer 为空时直接连接
+	Dialer Dialer
+}
+
+func (c *TCPHealthCheck) Check(ctx context.Context, addr string) error {
+	var dialer Dialer = &net.Dialer{}
+	if c.Dialer != nil {
+		dialer = c.Dialer
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(c.Port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HTTPHealthCheck 向目标地址发送 GET 请求, 状态码为 2xx 或等于 Status 时为健康
+type HTTPHealthCheck struct {
+	Scheme string // 默认 http
+	Port   int    // 默认按 Scheme 选择 80 或 443
+	Path   string // 默认 /
+	// Host 请求的 Host 头, 同时作为 https 的 SNI
+	Host   string
+	Status int
+	// Client 为空时使用不跟随重定向的默认客户端
+	Client *http.Client
+}
+
+var healthHTTPClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func (c *HTTPHealthCheck) Check(ctx context.Context, addr string) error {
+	scheme, port, path := c.Scheme, c.Port, c.Path
+	if scheme == "" {
+		scheme = "http"
+	}
+	if port == 0 {
+		port = 80
+		if scheme == "https" {
+			port = 443
+		}
+	}
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequest(http.MethodGet, scheme+"://"+net.JoinHostPort(addr, strconv.Itoa(port))+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = c.Host
+	client := c.Client
+	if client == nil {
+		client = healthHTTPClient
+		if scheme == "https" && c.Host != "" {
+			client = healthHTTPSClient(c.Host)
+		}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != c.Status {
+		return errors.WithMessage(ErrHealthStatus, resp.Status)
+	}
+	return nil
+}
+
+// healthHTTPSClient 按地址连接时 SNI 与证书校验使用 host
+func healthHTTPSClient(host string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{ServerName: host}
+	return &http.Client{Transport: transport, CheckRedirect: healthHTTPClient.CheckRedirect}
+}
+
+// ICMPHealthCheck 收到 ICMP Echo 回应即为健康.
+// 默认使用非特权的 udp ping socket (Linux 需要 net.ipv4.ping_group_range 允许), Privileged 时使用 raw socket
+type ICMPHealthCheck struct {
+	Privileged bool
+}
+
+var icmpEchoSeq uint32
+
+func (c *ICMPHealthCheck) Check(ctx context.Context, addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ErrInvalidIP
+	}
+	network, listen, proto := "udp4", "0.0.0.0", 1
+	var echo, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, listen, proto = "udp6", "::", 58
+		echo, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+	if c.Privileged {
+		network = map[string]string{"udp4": "ip4:icmp", "udp6": "ip6:ipv6-icmp"}[network]
+	}
+	conn, err := icmp.ListenPacket(network, listen)
+	if err != nil {
+		return errors.WithMessage(err, "listen icmp error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	id, seq := os.Getpid()&0xFFFF, int(atomic.AddUint32(&icmpEchoSeq, 1)&0xFFFF)
+	b, err := (&icmp.Message{Type: echo, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("netx")}}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if c.Privileged {
+		dst = &net.IPAddr{IP: ip}
+	}
+	if _, err := conn.WriteTo(b, dst); err != nil {
+		return errors.WithMessage(err, "write icmp error")
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return ErrEchoTimeout
+			}
+			return err
+		}
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || msg.Type != reply {
+			continue
+		}
+		body, ok := msg.Body.(*icmp.Echo)
+		// 非特权 socket 的 ID 由内核改写, 只比较序号与来源
+		if !ok || body.Seq != seq || (c.Privileged && body.ID != id) {
+			continue
+		}
+		var from net.IP
+		switch p := peer.(type) {
+		case *net.UDPAddr:
+			from = p.IP
+		case *net.IPAddr:
+			from = p.IP
+		}
+		if from.Equal(ip) {
+			return nil
+		}
+	}
+}
+
+// TargetHealth 一个目标的检查状态
+type TargetHealth struct {
+	Healthy   bool
+	Latency   time.Duration // 成功检查耗时的滑动平均
+	LastError error
+	LastCheck time.Time
+	successes int // 连续成功次数
+	failures  int // 连续失败次数
+}
+
+// HealthChecker 定期检查一组目标地址, 可作为 FailoverAnswers 的 TargetStatus.
+// 新加入的目标在连续 Fall 次失败之前视为健康
+type HealthChecker struct {
+	Check HealthCheck
+	// Interval 检查间隔, 默认 10s
+	Interval time.Duration
+	// Timeout 单次检查超时, 默认 2s
+	Timeout time.Duration
+	// Rise 不健康的目标连续成功 Rise 次后恢复, 默认 2
+	Rise int
+	// Fall 健康的目标连续失败 Fall 次后摘除, 默认 3
+	Fall int
+	// OnChange 状态变化时调用
+	OnChange func(addr string, healthy bool)
+
+	mu      sync.Mutex
+	targets map[string]*TargetHealth
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Add 添加目标地址, 已存在的地址保持原状态
+func (h *HealthChecker) Add(addrs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.targets == nil {
+		h.targets = map[string]*TargetHealth{}
+	}
+	for _, addr := range addrs {
+		if _, ok := h.targets[addr]; !ok {
+			h.targets[addr] = &TargetHealth{Healthy: true}
+		}
+	}
+}
+
+// Remove 删除目标地址
+func (h *HealthChecker) Remove(addr string) {
+	h.mu.Lock()
+	delete(h.targets, addr)
+	h.mu.Unlock()
+}
+
+// Healthy 未知的地址视为健康
+func (h *HealthChecker) Healthy(addr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.targets[addr]
+	return !ok || t.Healthy
+}
+
+// Latency 返回最近的检查耗时, 未知时为 0
+func (h *HealthChecker) Latency(addr string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t, ok := h.targets[addr]; ok {
+		return t.Latency
+	}
+	return 0
+}
+
+// Status 返回所有目标状态的副本
+func (h *HealthChecker) Status() map[string]TargetHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make(map[string]TargetHealth, len(h.targets))
+	for addr, t := range h.targets {
+		result[addr] = *t
+	}
+	return result
+}
+
+// Start 在后台按 Interval 检查, 立即开始第一轮
+func (h *HealthChecker) Start() {
+	h.mu.Lock()
+	if h.stop != nil {
+		h.mu.Unlock()
+		return
+	}
+	h.stop, h.done = make(chan struct{}), make(chan struct{})
+	stop, done := h.stop, h.done
+	h.mu.Unlock()
+
+	interval := h.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stop
+			cancel()
+		}()
+		for {
+			h.CheckNow(ctx)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close 停止后台检查
+func (h *HealthChecker) Close() error {
+	h.mu.Lock()
+	stop, done := h.stop, h.done
+	h.stop = nil
+	h.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return nil
+}
+
+// CheckNow 并发检查所有目标一次, 返回时状态已更新
+func (h *HealthChecker) CheckNow(ctx context.Context) {
+	h.mu.Lock()
+	addrs := make([]string, 0, len(h.targets))
+	for addr := range h.targets {
+		addrs = append(addrs, addr)
+	}
+	h.mu.Unlock()
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := h.Check.Check(checkCtx, addr)
+			if ctx.Err() != nil {
+				return
+			}
+			h.update(addr, err, time.Since(start))
+		}(addr)
+	}
+	wg.Wait()
+}
+
+func (h *HealthChecker) update(addr string, err error, elapsed time.Duration) {
+	rise, fall := h.Rise, h.Fall
+	if rise <= 0 {
+		rise = 2
+	}
+	if fall <= 0 {
+		fall = 3
+	}
+	h.mu.Lock()
+	t, ok := h.targets[addr]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	t.LastCheck, t.LastError = time.Now(), err
+	changed := false
+	if err == nil {
+		t.successes, t.failures = t.successes+1, 0
+		if t.Latency == 0 {
+			t.Latency = elapsed
+		} else {
+			t.Latency = (t.Latency*7 + elapsed) / 8
+		}
+		if !t.Healthy && t.successes >= rise {
+			t.Healthy, changed = true, true
+		}
+	} else {
+		t.successes, t.failures = 0, t.failures+1
+		if t.Healthy && t.failures >= fall {
+			t.Healthy, changed = false, true
+		}
+	}
+	healthy := t.Healthy
+	h.mu.Unlock()
+	if changed && h.OnChange != nil {
+		h.OnChange(addr, healthy)
+	}
+}
+
+// WithHealthChecks 应答中出现的 A/AAAA 地址自动加入 h, 不健康的地址被去掉, 全部不健康时仍返回全部地址
+func WithHealthChecks(h *HealthChecker) ServerMiddleware {
+	failover := FailoverAnswers(h, true)
+	return WithAnswerPolicy(func(req *DNSRequest, addrs []*DNSResourceRecode) []*DNSResourceRecode {
+		for _, rr := range addrs {
+			h.Add(rr.RData)
+		}
+		return failover(req, addrs)
+	})
+}
@@ -0,0 +1,90 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close()
+	if err := (&TCPHealthCheck{Port: port}).Check(context.Background(), "127.0.0.1"); err == nil {
+		t.Fatal("closed port is healthy")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || r.Host != "app.example.com" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	srvPort, _ := strconv.Atoi(u.Port())
+	if err := (&TCPHealthCheck{Port: srvPort}).Check(context.Background(), "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	check := &HTTPHealthCheck{Port: srvPort, Path: "/healthz", Host: "app.example.com"}
+	if err := check.Check(context.Background(), "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&HTTPHealthCheck{Port: srvPort}).Check(context.Background(), "127.0.0.1"); err == nil {
+		t.Fatal("503 is healthy")
+	}
+
+	var changes []string
+	down := map[string]bool{"192.0.2.2": true}
+	h := &HealthChecker{
+		Check: HealthCheckFunc(func(ctx context.Context, addr string) error {
+			if down[addr] {
+				return ErrEchoTimeout
+			}
+			return nil
+		}),
+		Rise: 1,
+		Fall: 2,
+		OnChange: func(addr string, healthy bool) {
+			changes = append(changes, addr+" "+strconv.FormatBool(healthy))
+		},
+	}
+	static := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{
+			{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.1"},
+			{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, RData: "192.0.2.2"},
+		}
+		resp.Header.AnswerRRs = 2
+		return resp, nil
+	})
+	handler := ChainHandler(static, WithHealthChecks(h))
+	answers := func() int {
+		resp, _ := handler.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example.com", DNSTypeA)})
+		return len(resp.Answers())
+	}
+	if answers() != 2 {
+		t.Fatal("unchecked targets withheld")
+	}
+	h.CheckNow(context.Background())
+	if answers() != 2 {
+		t.Fatal("withheld before Fall failures")
+	}
+	h.CheckNow(context.Background())
+	if answers() != 1 || h.Healthy("192.0.2.2") || h.Status()["192.0.2.2"].LastError == nil {
+		t.Fatalf("status = %+v", h.Status())
+	}
+	delete(down, "192.0.2.2")
+	h.CheckNow(context.Background())
+	if answers() != 2 || len(changes) != 2 || changes[1] != "192.0.2.2 true" {
+		t.Fatalf("changes = %v", changes)
+	}
+	h.Start()
+	_ = h.Close()
+}
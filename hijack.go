@@ -0,0 +1,149 @@
+package netx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	defaultHijackParent  = "example.com"
+	defaultHijackSamples = 3
+	// defaultHijackCanary TEST-NET-1 中的地址, 不会有 DNS 服务器, 能得到应答说明路径上有透明代理
+	defaultHijackCanary = "192.0.2.53:53"
+)
+
+// HijackOptions 劫持检测的选项, 零值使用默认值
+type HijackOptions struct {
+	// Parent 随机子域名的上级, 必须确定没有泛解析, 默认 example.com
+	Parent string
+	// Samples 每个 target 查询的随机名字数, 默认 3
+	Samples int
+	// Canary 没有运行 DNS 的地址, 通过 udp 向它查询, 默认 192.0.2.53:53
+	Canary string
+	// SkipCanary 不做 Canary 检测, 它在没有劫持时需要等待 Timeout
+	SkipCanary bool
+	// Timeout 单次查询超时, 默认与 Resolver 相同, Canary 检测默认 2s
+	Timeout time.Duration
+}
+
+// HijackProbe 一个 target 的检测结果
+type HijackProbe struct {
+	Target string
+	Server string
+	// RCodes 每个随机名字的响应码, 查询失败的名字不记录
+	RCodes []uint16
+	// Rewritten 不存在的名字得到的地址
+	Rewritten []string
+	// Instance 应答实例的标识, 见 ServerIdentity.Instance
+	Instance string
+	// Err 最后一次查询的错误
+	Err error
+}
+
+// HijackReport 劫持检测的结果
+type HijackReport struct {
+	Probes []*HijackProbe
+	// NXRewriting 有 target 对不存在的名字返回了地址
+	NXRewriting bool
+	// Inconsistent 不同 target 对不存在的名字的应答不同, 例如 udp 被改写而 DoT 没有, 说明改写发生在路径上
+	Inconsistent bool
+	// SharedInstance 有 target 报告了与 Canary 相同的实例标识, 说明发往它的请求由路径上的透明代理应答.
+	// 不比较 target 之间的实例: 同一运营方的 anycast 地址 (例如 1.1.1.1 与 1.0.0.1) 本来就可能由同一实例应答,
+	// 因此只把请求转给其它解析器而不应答 Canary 的代理检测不到, SkipCanary 时总是 false
+	SharedInstance bool
+	// CanaryAnswered 没有 DNS 服务的地址返回了应答, 路径上有透明代理
+	CanaryAnswered bool
+	CanaryErr      error
+}
+
+// Hijacked 是否发现任何改写或拦截的迹象
+func (r *HijackReport) Hijacked() bool {
+	return r.NXRewriting || r.Inconsistent || r.SharedInstance || r.CanaryAnswered
+}
+
+// DetectHijack 检测 NXDOMAIN 改写与透明 DNS 代理: 通过每个 target 查询不存在的随机子域名,
+// 比较各 target 的应答, 并向没有 DNS 服务的地址发送查询, 它应答时再与各 target 比较实例标识. targets 通常来自 ProbeTargets,
+// 同一解析器的 udp 与 DoT 应答不同时, 改写的是明文路径上的设备而不是解析器本身
+func DetectHijack(ctx context.Context, targets []ProbeTarget, opts *HijackOptions) *HijackReport {
+	o := HijackOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Parent == "" {
+		o.Parent = defaultHijackParent
+	}
+	if o.Samples <= 0 {
+		o.Samples = defaultHijackSamples
+	}
+	if o.Canary == "" {
+		o.Canary = defaultHijackCanary
+	}
+	report := &HijackReport{}
+	nx, rewritten := false, false
+	for _, t := range targets {
+		p := hijackTarget(ctx, t, &o)
+		report.Probes = append(report.Probes, p)
+		if len(p.Rewritten) > 0 {
+			rewritten = true
+		} else if len(p.RCodes) > 0 {
+			nx = true
+		}
+	}
+	report.NXRewriting = rewritten
+	report.Inconsistent = nx && rewritten
+	if !o.SkipCanary {
+		timeout := o.Timeout
+		if timeout <= 0 {
+			timeout = 2 * time.Second
+		}
+		r := &Resolver{Server: o.Canary, Transport: UDPTransport{}, Timeout: timeout}
+		resp, err := r.Query(ctx, hijackName(o.Parent), DNSTypeA)
+		if err == nil {
+			report.CanaryAnswered = true
+			resp.Release()
+			if id, err := r.Identify(ctx); err == nil && id.Instance() != "" {
+				for _, p := range report.Probes {
+					if p.Instance == id.Instance() {
+						report.SharedInstance = true
+					}
+				}
+			}
+		}
+		report.CanaryErr = err
+	}
+	return report
+}
+
+func hijackTarget(ctx context.Context, t ProbeTarget, o *HijackOptions) *HijackProbe {
+	r := &Resolver{Server: t.Server, Transport: t.Transport, Timeout: o.Timeout}
+	p := &HijackProbe{Target: t.Name, Server: t.Server}
+	for i := 0; i < o.Samples; i++ {
+		resp, err := r.Query(ctx, hijackName(o.Parent), DNSTypeA)
+		if err != nil {
+			p.Err = err
+			continue
+		}
+		p.RCodes = append(p.RCodes, resp.Header.Flags.RCode)
+		for _, rr := range resp.Answers() {
+			if rr.RRType == DNSTypeA || rr.RRType == DNSTypeAAAA {
+				p.Rewritten = append(p.Rewritten, rr.RData)
+			}
+		}
+		resp.Release()
+	}
+	if len(p.RCodes) > 0 {
+		if id, err := r.Identify(ctx); err == nil {
+			p.Instance = id.Instance()
+		}
+	}
+	return p
+}
+
+// hijackName 返回 parent 下的随机名字
+func hijackName(parent string) string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "nx-" + hex.EncodeToString(b) + "." + normalizeDomain(parent)
+}
@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hijackServer 对 nx- 开头的名字应答 NXDOMAIN, rewrite 不为空时改为应答该地址. id 为 id.server 的值
+func hijackServer(id, rewrite string) *DNSServer {
+	return &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		switch {
+		case q.QuestionClass == DNSClassChaos && q.QuestionName == "id.server":
+			rr := txtRR(id)
+			rr.Name, rr.Class = q.QuestionName, DNSClassChaos
+			resp.ResourceRecodes = []*DNSResourceRecode{rr}
+		case q.QuestionClass == DNSClassChaos:
+			resp.Header.Flags.RCode = DNSRCodeRefused
+		case rewrite != "":
+			resp.ResourceRecodes = []*DNSResourceRecode{aRecord(q.QuestionName, rewrite)}
+		case strings.HasPrefix(q.QuestionName, "nx-"):
+			resp.Header.Flags.RCode = DNSRCodeNXDomain
+		}
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+		return resp, nil
+	})}
+}
+
+func TestDetectHijack(t *testing.T) {
+	ctx := context.Background()
+	honest := ProbeTarget{Name: "dot", Server: "192.0.2.1:853", Transport: PipeTransport{Server: hijackServer("resolver-1", "")}}
+	opts := &HijackOptions{SkipCanary: true}
+	if r := DetectHijack(ctx, []ProbeTarget{honest}, opts); r.Hijacked() || len(r.Probes[0].RCodes) != 3 || r.Probes[0].Instance != "resolver-1" {
+		t.Fatalf("honest resolver: %+v %+v", r, r.Probes[0])
+	}
+
+	// udp 被路径上的设备改写, DoT 正常
+	rewritten := ProbeTarget{Name: "udp", Server: "192.0.2.1:53", Transport: PipeTransport{Server: hijackServer("isp-box", "198.51.100.9")}}
+	r := DetectHijack(ctx, []ProbeTarget{rewritten, honest}, opts)
+	if !r.NXRewriting || !r.Inconsistent || r.SharedInstance || r.Probes[0].Rewritten[0] != "198.51.100.9" {
+		t.Fatalf("rewriting: %+v", r)
+	}
+
+	// 同一运营方的 anycast 地址由同一个实例应答是正常的
+	anycast := ProbeTarget{Name: "udp", Server: "198.51.100.1:53", Transport: PipeTransport{Server: hijackServer("resolver-1", "")}}
+	if r := DetectHijack(ctx, []ProbeTarget{honest, anycast}, opts); r.Hijacked() {
+		t.Fatalf("anycast pair: %+v", r)
+	}
+
+	// 本机的 udp 服务器作为 canary, 能应答说明被拦截
+	canary, _ := startDNSServer(t, hijackServer("proxy", ""))
+	if r := DetectHijack(ctx, []ProbeTarget{honest}, &HijackOptions{Canary: canary}); !r.CanaryAnswered || r.SharedInstance || !r.Hijacked() {
+		t.Fatalf("canary: %+v", r)
+	}
+	// target 与 canary 由同一个代理应答
+	proxied := ProbeTarget{Name: "udp", Server: "198.51.100.1:53", Transport: PipeTransport{Server: hijackServer("proxy", "")}}
+	if r := DetectHijack(ctx, []ProbeTarget{honest, proxied}, &HijackOptions{Canary: canary}); !r.SharedInstance || r.NXRewriting {
+		t.Fatalf("shared instance: %+v", r)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := pc.LocalAddr().String()
+	_ = pc.Close()
+	if r := DetectHijack(ctx, nil, &HijackOptions{Canary: closed, Timeout: 200 * time.Millisecond}); r.CanaryAnswered || r.CanaryErr == nil {
+		t.Fatalf("closed canary: %+v", r)
+	}
+}
@@ -0,0 +1,114 @@
+package netx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// HPKE (RFC 9180) 的 base 模式, 只实现 ODoH 需要的 DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM
+const (
+	hpkeKEMX25519 = 0x0020
+	hpkeKDFSHA256 = 0x0001
+	hpkeAES128GCM = 0x0001
+)
+
+var (
+	hpkeKEMSuite = []byte{'K', 'E', 'M', 0x00, 0x20}
+	hpkeSuite    = []byte{'H', 'P', 'K', 'E', 0x00, 0x20, 0x00, 0x01, 0x00, 0x01}
+)
+
+// hpkeContext 加密上下文, 每次 Seal/Open 后序号加一
+type hpkeContext struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	exporter  []byte
+	seq       uint64
+}
+
+func hpkeLabeledExtract(suite, salt []byte, label string, ikm []byte) []byte {
+	labeled := append(append(append([]byte("HPKE-v1"), suite...), label...), ikm...)
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+func hpkeLabeledExpand(suite, prk []byte, label string, info []byte, length int) []byte {
+	labeled := append([]byte{byte(length >> 8), byte(length)}, "HPKE-v1"...)
+	labeled = append(append(append(labeled, suite...), label...), info...)
+	out := make([]byte, length)
+	_, _ = io.ReadFull(hkdf.Expand(sha256.New, prk, labeled), out)
+	return out
+}
+
+// hpkeSetupBaseS 发送方: 用接收方公钥 pkR 封装临时密钥, 返回 enc 与加密上下文
+func hpkeSetupBaseS(pkR, info []byte) ([]byte, *hpkeContext, error) {
+	skE := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, skE); err != nil {
+		return nil, nil, err
+	}
+	pkE, err := curve25519.X25519(skE, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := curve25519.X25519(skE, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, err := hpkeKeySchedule(hpkeSharedSecret(dh, pkE, pkR), info)
+	return pkE, ctx, err
+}
+
+// hpkeSharedSecret DHKEM 的 ExtractAndExpand, kem_context 为 enc || pkR
+func hpkeSharedSecret(dh, enc, pkR []byte) []byte {
+	prk := hpkeLabeledExtract(hpkeKEMSuite, nil, "eae_prk", dh)
+	return hpkeLabeledExpand(hpkeKEMSuite, prk, "shared_secret", append(append([]byte{}, enc...), pkR...), 32)
+}
+
+func hpkeKeySchedule(shared, info []byte) (*hpkeContext, error) {
+	pskIDHash := hpkeLabeledExtract(hpkeSuite, nil, "psk_id_hash", nil)
+	infoHash := hpkeLabeledExtract(hpkeSuite, nil, "info_hash", info)
+	context := append(append([]byte{0}, pskIDHash...), infoHash...)
+	secret := hpkeLabeledExtract(hpkeSuite, shared, "secret", nil)
+	block, err := aes.NewCipher(hpkeLabeledExpand(hpkeSuite, secret, "key", context, 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &hpkeContext{
+		aead:      aead,
+		baseNonce: hpkeLabeledExpand(hpkeSuite, secret, "base_nonce", context, 12),
+		exporter:  hpkeLabeledExpand(hpkeSuite, secret, "exp", context, 32),
+	}, nil
+}
+
+// nonce base_nonce 与序号异或
+func (c *hpkeContext) nonce() []byte {
+	nonce := append([]byte{}, c.baseNonce...)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	for i := range seq {
+		nonce[len(nonce)-8+i] ^= seq[i]
+	}
+	c.seq++
+	return nonce
+}
+
+func (c *hpkeContext) Seal(aad, plaintext []byte) []byte {
+	return c.aead.Seal(nil, c.nonce(), plaintext, aad)
+}
+
+func (c *hpkeContext) Open(aad, ciphertext []byte) ([]byte, error) {
+	return c.aead.Open(nil, c.nonce(), ciphertext, aad)
+}
+
+// Export 导出与上下文绑定的密钥
+func (c *hpkeContext) Export(exporterContext []byte, length int) []byte {
+	return hpkeLabeledExpand(hpkeSuite, c.exporter, "sec", exporterContext, length)
+}
@@ -0,0 +1,145 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+)
+
+var ErrAddressNotAllowed = errors.New("resolved address is not allowed")
+
+// HTTPDialer 为 http.Transport 提供 DialContext, 主机名通过 Resolver 解析, 因此使用它的缓存、
+// 拦截器与加密 Transport. Allowed 不为空时只连接其中网段的地址, 检查的就是实际连接的地址,
+// 解析之后 DNS 记录再变化 (DNS rebinding) 也无法绕过, 可以防止 SSRF 访问内网.
+// 用 PinAddrs 包裹请求的 context 时, 同一请求中对同一主机的连接使用第一次解析的地址
+type HTTPDialer struct {
+	Resolver *Resolver
+	// Allowed 允许连接的网段, 为空时不检查. URL 中直接写的 IP 也要在其中
+	Allowed []netip.Prefix
+	// Next 用于连接解析出的地址, 为空时使用 net.Dialer
+	Next Dialer
+}
+
+// Transport 返回使用 d 拨号的 http.DefaultTransport 副本. 设置了 Allowed 时不使用环境变量中的代理,
+// 否则检查的只是代理的地址
+func (d *HTTPDialer) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = d.DialContext
+	if len(d.Allowed) > 0 {
+		t.Proxy = nil
+	}
+	return t
+}
+
+func (d *HTTPDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var next Dialer = &net.Dialer{}
+	if d.Next != nil {
+		next = d.Next
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	lastErr := errors.WithMessage(ErrNoAddress, host)
+	for _, addr := range addrs {
+		conn, err := next.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// resolve 返回 host 允许连接的地址, 优先使用 ctx 中固定的结果
+func (d *HTTPDialer) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	pins, _ := ctx.Value(pinnedAddrsKey{}).(*pinnedAddrs)
+	if addrs, ok := pins.get(host); ok {
+		return addrs, nil
+	}
+	var names []string
+	if ip, err := netip.ParseAddr(host); err == nil {
+		names = []string{ip.String()}
+	} else {
+		if d.Resolver == nil {
+			return nil, errors.WithMessage(ErrNoAddress, "no resolver")
+		}
+		if names, err = d.Resolver.LookupHost(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	var addrs []netip.Addr
+	for _, name := range names {
+		addr, err := netip.ParseAddr(name)
+		if err != nil || !d.allowed(addr.Unmap()) {
+			continue
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+	if len(addrs) == 0 {
+		if len(names) > 0 {
+			return nil, errors.WithMessage(ErrAddressNotAllowed, host)
+		}
+		return nil, errors.WithMessage(ErrNoAddress, host)
+	}
+	pins.set(host, addrs)
+	return addrs, nil
+}
+
+func (d *HTTPDialer) allowed(addr netip.Addr) bool {
+	if len(d.Allowed) == 0 {
+		return true
+	}
+	for _, prefix := range d.Allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type pinnedAddrsKey struct{}
+
+type pinnedAddrs struct {
+	mu    sync.Mutex
+	hosts map[string][]netip.Addr
+}
+
+func (p *pinnedAddrs) get(host string) ([]netip.Addr, bool) {
+	if p == nil {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addrs, ok := p.hosts[normalizeDomain(host)]
+	return addrs, ok
+}
+
+func (p *pinnedAddrs) set(host string, addrs []netip.Addr) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts[normalizeDomain(host)] = addrs
+}
+
+// PinAddrs 返回固定解析结果的 context: HTTPDialer 在其中第一次解析某个主机后, 之后的连接
+// (例如重试, 重定向回同一主机, 或者请求内的多个连接) 不再查询, 而是使用相同的地址.
+// 已经固定的 ctx 原样返回
+func PinAddrs(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(pinnedAddrsKey{}).(*pinnedAddrs); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, pinnedAddrsKey{}, &pinnedAddrs{hosts: map[string][]netip.Addr{}})
+}
@@ -0,0 +1,82 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPDialer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	var queries int32
+	answer := atomic.Value{}
+	answer.Store("127.0.0.1")
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		ip := answer.Load().(string)
+		if req.Message.Questions[0].QuestionName == "internal.example" {
+			ip = "10.0.0.1"
+		}
+		atomic.AddInt32(&queries, 1)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, ip)}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	d := &HTTPDialer{
+		Resolver: &Resolver{Server: "pipe", Transport: PipeTransport{Server: s}},
+		Allowed:  []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	}
+	client := &http.Client{Transport: d.Transport()}
+	resp, err := client.Get("http://app.example:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "app.example:"+port {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	ctx := context.Background()
+	if _, err := d.DialContext(ctx, "tcp", "internal.example:"+port); !errors.Is(err, ErrAddressNotAllowed) {
+		t.Fatalf("internal address: %v", err)
+	}
+	if _, err := d.DialContext(ctx, "tcp", "10.0.0.1:"+port); !errors.Is(err, ErrAddressNotAllowed) {
+		t.Fatalf("literal address: %v", err)
+	}
+
+	// 固定之后记录变成内网地址也不会被使用, 也不再查询
+	pinned := PinAddrs(ctx)
+	if PinAddrs(pinned) != pinned {
+		t.Fatal("PinAddrs replaced an existing pin table")
+	}
+	conn, err := d.DialContext(pinned, "tcp", "rebind.example:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	answer.Store("10.0.0.2")
+	before := atomic.LoadInt32(&queries)
+	conn, err = d.DialContext(pinned, "tcp", "rebind.example:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if atomic.LoadInt32(&queries) != before {
+		t.Fatal("pinned host was resolved again")
+	}
+	if _, err := d.DialContext(ctx, "tcp", "rebind.example:"+port); !errors.Is(err, ErrAddressNotAllowed) {
+		t.Fatalf("rebound address: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var (
+	ErrProxyScheme = errors.New("unsupported proxy scheme")
+	ErrProxyStatus = errors.New("proxy refused connect")
+)
+
+// HTTPProxyDialer 通过 HTTP CONNECT 建立 tcp 隧道
+type HTTPProxyDialer struct {
+	// ProxyURL scheme 为 http 或 https, 包含用户信息时使用 basic 认证
+	ProxyURL *url.URL
+	// Forward 用于连接代理, 为空时直接连接
+	Forward Dialer
+	// TLSConfig https 代理使用, 为空时按代理主机名校验证书
+	TLSConfig *tls.Config
+}
+
+func (d *HTTPProxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.WithMessage(ErrProxyScheme, "http proxy only tunnels tcp")
+	}
+	var forward Dialer = &net.Dialer{}
+	if d.Forward != nil {
+		forward = d.Forward
+	}
+	proxyAddr := d.ProxyURL.Host
+	if d.ProxyURL.Port() == "" {
+		port := "80"
+		if d.ProxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.ProxyURL.Hostname(), port)
+	}
+	conn, err := forward.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial http proxy error")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	switch d.ProxyURL.Scheme {
+	case "http":
+	case "https":
+		config := d.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: d.ProxyURL.Hostname()}
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, errors.WithMessage(err, "tls handshake with proxy error")
+		}
+		conn = tlsConn
+	default:
+		_ = conn.Close()
+		return nil, errors.WithMessage(ErrProxyScheme, d.ProxyURL.Scheme)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user := d.ProxyURL.User; user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.WithMessage(err, "write connect error")
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.WithMessage(err, "read connect response error")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.WithMessage(ErrProxyStatus, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// 代理在应答之后立即转发了目标的数据
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// ProxyConfig tcp 连接的代理设置, 与同名环境变量含义相同
+type ProxyConfig struct {
+	HTTPSProxy string // http://, https:// 或 socks5:// 地址
+	AllProxy   string // HTTPSProxy 为空时使用
+	NoProxy    string // 逗号分隔的域名, ip 或 CIDR, 回环地址总是直连
+}
+
+// ProxyConfigFromEnvironment 读取 HTTPS_PROXY, ALL_PROXY 与 NO_PROXY (以及小写形式)
+func ProxyConfigFromEnvironment() *ProxyConfig {
+	return &ProxyConfig{
+		HTTPSProxy: getenvAny("HTTPS_PROXY", "https_proxy"),
+		AllProxy:   getenvAny("ALL_PROXY", "all_proxy"),
+		NoProxy:    getenvAny("NO_PROXY", "no_proxy"),
+	}
+}
+
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ProxyFunc 返回 address (host:port) 应使用的代理, 直连时返回 nil
+func (c *ProxyConfig) ProxyFunc() func(address string) (*url.URL, error) {
+	proxy := c.HTTPSProxy
+	if proxy == "" {
+		proxy = c.AllProxy
+	}
+	f := (&httpproxy.Config{HTTPSProxy: proxy, NoProxy: c.NoProxy}).ProxyFunc()
+	return func(address string) (*url.URL, error) {
+		return f(&url.URL{Scheme: "https", Host: address})
+	}
+}
+
+// ProxyDialer 按目标地址选择直连, HTTP CONNECT 或 SOCKS5 代理. 只有 tcp 连接会经过代理
+type ProxyDialer struct {
+	// Proxy 为空时使用 ProxyConfigFromEnvironment
+	Proxy func(address string) (*url.URL, error)
+	// Direct 用于直连与连接代理, 为空时使用 net.Dialer (自带 RFC 6555 Happy Eyeballs)
+	Direct Dialer
+}
+
+func (d *ProxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var direct Dialer = &net.Dialer{}
+	if d.Direct != nil {
+		direct = d.Direct
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return direct.DialContext(ctx, network, address)
+	}
+	proxy := d.Proxy
+	if proxy == nil {
+		proxy = ProxyConfigFromEnvironment().ProxyFunc()
+	}
+	u, err := proxy(address)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return direct.DialContext(ctx, network, address)
+	}
+	var dialer Dialer
+	switch u.Scheme {
+	case "http", "https":
+		dialer = &HTTPProxyDialer{ProxyURL: u, Forward: direct}
+	case "socks5", "socks5h":
+		s := &SOCKS5Dialer{ProxyAddress: u.Host, Forward: direct}
+		if u.Port() == "" {
+			s.ProxyAddress = net.JoinHostPort(u.Hostname(), "1080")
+		}
+		if u.User != nil {
+			s.Username = u.User.Username()
+			s.Password, _ = u.User.Password()
+		}
+		dialer = s
+	default:
+		return nil, errors.WithMessage(ErrProxyScheme, u.Scheme)
+	}
+	return dialer.DialContext(ctx, network, address)
+}
@@ -0,0 +1,88 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProxyDialer(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err == nil {
+			_, _ = io.Copy(conn, conn)
+			conn.Close()
+		}
+	}()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() { _, _ = io.Copy(target, conn) }()
+		_, _ = io.Copy(conn, target)
+		conn.Close()
+		target.Close()
+	}))
+	defer proxy.Close()
+
+	u, _ := url.Parse(proxy.URL)
+	u.User = url.UserPassword("user", "secret")
+	d := &ProxyDialer{Proxy: func(address string) (*url.URL, error) { return u, nil }}
+	conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("unexpected echo %q %v", got, err)
+	}
+
+	u.User = nil
+	if _, err := d.DialContext(context.Background(), "tcp", echo.Addr().String()); err == nil {
+		t.Fatal("expected proxy auth failure")
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	f := (&ProxyConfig{AllProxy: "socks5://proxy.example:1080", NoProxy: "internal.example,10.0.0.0/8"}).ProxyFunc()
+	for address, want := range map[string]string{
+		"www.example.com:443":    "socks5://proxy.example:1080",
+		"db.internal.example:22": "",
+		"10.1.2.3:80":            "",
+		"127.0.0.1:80":           "",
+	} {
+		u, err := f(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != want {
+			t.Errorf("%s: got proxy %q, want %q", address, got, want)
+		}
+	}
+}
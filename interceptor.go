@@ -0,0 +1,47 @@
+package netx
+
+import (
+	"context"
+)
+
+// Interceptor 包裹下一个 RoundTripper, 可以在请求前后做缓存/策略/统计/改写,
+// 也可以不调用 next 直接返回结果
+type Interceptor func(next RoundTripper) RoundTripper
+
+// BeforeSend 在请求发出前调用 fn, fn 返回错误时请求不会发出
+func BeforeSend(fn func(ctx context.Context, server string, req *DNSMessage) error) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			if err := fn(ctx, server, req); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(ctx, server, req)
+		})
+	}
+}
+
+// AfterReceive 在成功收到响应后调用 fn, fn 可以修改或替换响应
+func AfterReceive(fn func(ctx context.Context, req, resp *DNSMessage) (*DNSMessage, error)) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := next.RoundTrip(ctx, server, req)
+			if err != nil {
+				return nil, err
+			}
+			return fn(ctx, req, resp)
+		})
+	}
+}
+
+// OnError 在请求失败时调用 fn, fn 返回非空响应且错误为空时视为恢复
+func OnError(fn func(ctx context.Context, req *DNSMessage, err error) (*DNSMessage, error)) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := next.RoundTrip(ctx, server, req)
+			if err != nil {
+				return fn(ctx, req, err)
+			}
+			return resp, nil
+		})
+	}
+}
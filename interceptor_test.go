@@ -0,0 +1,38 @@
+package netx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	var order []string
+	r := &Resolver{
+		Server: "127.0.0.1:53",
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			order = append(order, "send")
+			return &DNSMessage{Header: &DNSHeader{TxID: req.Header.TxID, Flags: &DNSFlags{QR: 1}}}, nil
+		}),
+		Interceptors: []Interceptor{
+			BeforeSend(func(ctx context.Context, server string, req *DNSMessage) error {
+				order = append(order, "before")
+				return nil
+			}),
+			AfterReceive(func(ctx context.Context, req, resp *DNSMessage) (*DNSMessage, error) {
+				order = append(order, "after")
+				resp.Header.Flags.RCode = 3
+				return resp, nil
+			}),
+		},
+	}
+	resp, err := r.Query(context.Background(), "www.example.com", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Flags.RCode != 3 {
+		t.Fatalf("rcode = %d, want 3", resp.Header.Flags.RCode)
+	}
+	if len(order) != 3 || order[0] != "before" || order[1] != "send" || order[2] != "after" {
+		t.Fatalf("order = %v", order)
+	}
+}
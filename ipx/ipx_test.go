@@ -0,0 +1,126 @@
+package ipx
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func prefixes(items ...string) []netip.Prefix {
+	var result []netip.Prefix
+	for _, item := range items {
+		result = append(result, netip.MustParsePrefix(item))
+	}
+	return result
+}
+
+func equalPrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPrefixMath(t *testing.T) {
+	first, last := Range(netip.MustParsePrefix("192.0.2.77/26"))
+	if first.String() != "192.0.2.64" || last.String() != "192.0.2.127" {
+		t.Fatalf("range = %s - %s", first, last)
+	}
+	if !Contains(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("10.1.0.0/16")) ||
+		Contains(netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("10.0.0.0/8")) {
+		t.Fatal("contains")
+	}
+
+	split, err := Split(netip.MustParsePrefix("2001:db8::/32"), 34)
+	if err != nil || !equalPrefixes(split, prefixes("2001:db8::/34", "2001:db8:4000::/34", "2001:db8:8000::/34", "2001:db8:c000::/34")) {
+		t.Fatalf("split = %v, %v", split, err)
+	}
+	if _, err := Split(netip.MustParsePrefix("10.0.0.0/8"), 32); err != ErrTooMany {
+		t.Fatalf("err = %v", err)
+	}
+
+	got, err := RangeToPrefixes(netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.10"))
+	want := prefixes("192.0.2.1/32", "192.0.2.2/31", "192.0.2.4/30", "192.0.2.8/31", "192.0.2.10/32")
+	if err != nil || !equalPrefixes(got, want) {
+		t.Fatalf("range to prefixes = %v, %v", got, err)
+	}
+	got, _ = RangeToPrefixes(netip.MustParseAddr("::"), netip.MustParseAddr("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))
+	if !equalPrefixes(got, prefixes("::/0")) {
+		t.Fatalf("full range = %v", got)
+	}
+
+	var hosts []string
+	Hosts(netip.MustParsePrefix("192.0.2.0/30"), func(a netip.Addr) bool {
+		hosts = append(hosts, a.String())
+		return true
+	})
+	if len(hosts) != 2 || hosts[0] != "192.0.2.1" || hosts[1] != "192.0.2.2" {
+		t.Fatalf("hosts = %v", hosts)
+	}
+
+	_, n, _ := net.ParseCIDR("198.51.100.0/24")
+	p, ok := FromIPNet(n)
+	if !ok || p.String() != "198.51.100.0/24" || ToIPNet(p).String() != n.String() {
+		t.Fatalf("ipnet = %v", p)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s, err := ParseSet("10.0.0.0/25", "10.0.0.128/25", "10.0.1.0", "2001:db8::/64", "10.0.0.5/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalPrefixes(s.Prefixes(), prefixes("10.0.0.0/24", "10.0.1.0/32", "2001:db8::/64")) {
+		t.Fatalf("prefixes = %v", s.Prefixes())
+	}
+	s.RemovePrefix(netip.MustParsePrefix("10.0.0.0/26"))
+	if s.Contains(netip.MustParseAddr("10.0.0.1")) || !s.Contains(netip.MustParseAddr("10.0.0.64")) {
+		t.Fatal("remove")
+	}
+	if !s.ContainsPrefix(netip.MustParsePrefix("10.0.0.128/25")) || s.ContainsPrefix(netip.MustParsePrefix("10.0.0.0/24")) {
+		t.Fatal("contains prefix")
+	}
+	other, _ := ParseSet("10.0.0.0/16")
+	inter := s.Intersect(other)
+	if !equalPrefixes(inter.Prefixes(), prefixes("10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/32")) {
+		t.Fatalf("intersect = %v", inter.Prefixes())
+	}
+	if u := s.Union(other); !equalPrefixes(u.Prefixes(), prefixes("10.0.0.0/16", "2001:db8::/64")) {
+		t.Fatalf("union = %v", u.Prefixes())
+	}
+
+	// 与逐个地址的模型比较
+	rng := rand.New(rand.NewSource(1))
+	model := map[int]bool{}
+	s = &Set{}
+	base := netip.MustParseAddr("192.0.2.0").As4()
+	addr := func(i int) netip.Addr {
+		b := base
+		b[3] = byte(i)
+		return netip.AddrFrom4(b)
+	}
+	for n := 0; n < 500; n++ {
+		from := rng.Intn(256)
+		to := from + rng.Intn(256-from)
+		add := rng.Intn(3) != 0
+		if add {
+			_ = s.AddRange(addr(from), addr(to))
+		} else {
+			_ = s.RemoveRange(addr(from), addr(to))
+		}
+		for i := from; i <= to; i++ {
+			model[i] = add
+		}
+		for i := 0; i < 256; i++ {
+			if s.Contains(addr(i)) != model[i] {
+				t.Fatalf("step %d: %s = %v", n, addr(i), s.Contains(addr(i)))
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package ipx 提供基于 net/netip 的 CIDR 运算: 包含与重叠判断, 子网拆分与合并, 地址范围与前缀互转, 以及地址集合
+package ipx
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"math/bits"
+	"net"
+	"net/netip"
+)
+
+// maxSplitBits Split 最多拆分出 1<<maxSplitBits 个前缀
+const maxSplitBits = 20
+
+var (
+	ErrInvalidPrefix = errors.New("invalid prefix")
+	ErrInvalidRange  = errors.New("invalid address range")
+	ErrTooMany       = errors.New("too many prefixes")
+)
+
+// uint128 地址的整数形式, ipv4 只使用 lo 的低 32 位
+type uint128 struct {
+	hi, lo uint64
+}
+
+func fromAddr(a netip.Addr) uint128 {
+	if a.Is4() {
+		b := a.As4()
+		return uint128{lo: uint64(binary.BigEndian.Uint32(b[:]))}
+	}
+	b := a.As16()
+	return uint128{hi: binary.BigEndian.Uint64(b[:8]), lo: binary.BigEndian.Uint64(b[8:])}
+}
+
+func (u uint128) addr(is4 bool) netip.Addr {
+	if is4 {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(u.lo))
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], u.hi)
+	binary.BigEndian.PutUint64(b[8:], u.lo)
+	return netip.AddrFrom16(b)
+}
+
+// hostMask 低 n 位为 1
+func hostMask(n int) uint128 {
+	switch {
+	case n <= 0:
+		return uint128{}
+	case n < 64:
+		return uint128{lo: 1<<uint(n) - 1}
+	case n < 128:
+		return uint128{hi: 1<<uint(n-64) - 1, lo: ^uint64(0)}
+	}
+	return uint128{hi: ^uint64(0), lo: ^uint64(0)}
+}
+
+func (u uint128) or(v uint128) uint128 {
+	return uint128{hi: u.hi | v.hi, lo: u.lo | v.lo}
+}
+
+func (u uint128) add(v uint128) uint128 {
+	lo, carry := bits.Add64(u.lo, v.lo, 0)
+	hi, _ := bits.Add64(u.hi, v.hi, carry)
+	return uint128{hi: hi, lo: lo}
+}
+
+// trailingZeros 最多返回 max
+func (u uint128) trailingZeros(max int) int {
+	n := 128
+	if u.lo != 0 {
+		n = bits.TrailingZeros64(u.lo)
+	} else if u.hi != 0 {
+		n = 64 + bits.TrailingZeros64(u.hi)
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// Range 返回前缀的第一个与最后一个地址
+func Range(p netip.Prefix) (first, last netip.Addr) {
+	p = p.Masked()
+	first = p.Addr()
+	hostBits := first.BitLen() - p.Bits()
+	return first, fromAddr(first).or(hostMask(hostBits)).addr(first.Is4())
+}
+
+// Contains a 是否完整包含 b
+func Contains(a, b netip.Prefix) bool {
+	return a.Bits() <= b.Bits() && a.Contains(b.Addr())
+}
+
+// Overlaps a 与 b 是否有公共地址
+func Overlaps(a, b netip.Prefix) bool {
+	return a.Overlaps(b)
+}
+
+// Split 将 p 拆分为长度为 bits 的子网
+func Split(p netip.Prefix, bits int) ([]netip.Prefix, error) {
+	if !p.IsValid() || bits < p.Bits() || bits > p.Addr().BitLen() {
+		return nil, ErrInvalidPrefix
+	}
+	if bits-p.Bits() > maxSplitBits {
+		return nil, ErrTooMany
+	}
+	n := 1 << uint(bits-p.Bits())
+	p = p.Masked()
+	is4 := p.Addr().Is4()
+	step := hostMask(p.Addr().BitLen() - bits).add(uint128{lo: 1})
+	cur := fromAddr(p.Addr())
+	result := make([]netip.Prefix, 0, n)
+	for i := 0; i < n; i++ {
+		result = append(result, netip.PrefixFrom(cur.addr(is4), bits))
+		cur = cur.add(step)
+	}
+	return result, nil
+}
+
+// RangeToPrefixes 返回恰好覆盖 [from, to] 的最少前缀
+func RangeToPrefixes(from, to netip.Addr) ([]netip.Prefix, error) {
+	if !from.IsValid() || !to.IsValid() || from.Is4() != to.Is4() || to.Less(from) {
+		return nil, ErrInvalidRange
+	}
+	var result []netip.Prefix
+	for {
+		bitLen := from.BitLen()
+		// 从 from 对齐允许的最短前缀开始, 直到不超过 to
+		l := bitLen - fromAddr(from).trailingZeros(bitLen)
+		var last netip.Addr
+		for ; ; l++ {
+			_, last = Range(netip.PrefixFrom(from, l))
+			if !to.Less(last) {
+				break
+			}
+		}
+		result = append(result, netip.PrefixFrom(from, l))
+		if last == to {
+			return result, nil
+		}
+		from = last.Next()
+	}
+}
+
+// Hosts 按顺序对 p 中的地址调用 fn, fn 返回 false 时停止.
+// ipv4 前缀短于 /31 时跳过网络地址与广播地址
+func Hosts(p netip.Prefix, fn func(netip.Addr) bool) {
+	first, last := Range(p)
+	if first.Is4() && p.Bits() < 31 {
+		first = first.Next()
+		last = last.Prev()
+	}
+	for a := first; a.IsValid() && !last.Less(a); a = a.Next() {
+		if !fn(a) {
+			return
+		}
+		if a == last {
+			return
+		}
+	}
+}
+
+// FromIPNet 将 net.IPNet 转换为 netip.Prefix, ipv4 地址使用 4 字节形式
+func FromIPNet(n *net.IPNet) (netip.Prefix, bool) {
+	ip := n.IP
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, size := n.Mask.Size()
+	if size == 128 && addr.Is4() && ones >= 96 {
+		ones, size = ones-96, 32
+	}
+	if size != addr.BitLen() {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+// ToIPNet 将 netip.Prefix 转换为 net.IPNet
+func ToIPNet(p netip.Prefix) *net.IPNet {
+	p = p.Masked()
+	return &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())}
+}
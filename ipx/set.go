@@ -0,0 +1,195 @@
+package ipx
+
+import (
+	"net/netip"
+	"sort"
+)
+
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// Set 地址集合, 内部保存有序, 不相交且不相邻的地址范围. 零值为空集合, 修改时不能并发使用
+type Set struct {
+	ranges []ipRange
+}
+
+// NewSet 返回包含 prefixes 的集合
+func NewSet(prefixes ...netip.Prefix) *Set {
+	s := &Set{}
+	for _, p := range prefixes {
+		s.AddPrefix(p)
+	}
+	return s
+}
+
+// ParseSet 解析 CIDR 或单个地址
+func ParseSet(items ...string) (*Set, error) {
+	s := &Set{}
+	for _, item := range items {
+		p, err := ParsePrefixOrAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		s.AddPrefix(p)
+	}
+	return s, nil
+}
+
+// Summarize 合并重叠与相邻的前缀, 返回覆盖相同地址的最少前缀
+func Summarize(prefixes []netip.Prefix) []netip.Prefix {
+	return NewSet(prefixes...).Prefixes()
+}
+
+// Add 添加单个地址
+func (s *Set) Add(a netip.Addr) {
+	if a.IsValid() {
+		s.add(ipRange{a, a})
+	}
+}
+
+// AddPrefix 添加前缀中的所有地址
+func (s *Set) AddPrefix(p netip.Prefix) {
+	if p.IsValid() {
+		first, last := Range(p)
+		s.add(ipRange{first, last})
+	}
+}
+
+// AddRange 添加 [from, to], 两端必须属于同一地址族
+func (s *Set) AddRange(from, to netip.Addr) error {
+	if !from.IsValid() || !to.IsValid() || from.Is4() != to.Is4() || to.Less(from) {
+		return ErrInvalidRange
+	}
+	s.add(ipRange{from, to})
+	return nil
+}
+
+// adjacent a 是否与之后的 b 重叠或相邻
+func adjacent(a, b ipRange) bool {
+	return a.to.Is4() == b.from.Is4() && (!a.to.Less(b.from) || a.to.Next() == b.from)
+}
+
+func (s *Set) add(r ipRange) {
+	// 第一个结束地址不小于 r.from-1 的范围, 从它开始合并所有与 r 重叠或相邻的范围
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return !s.ranges[i].to.Less(r.from) || s.ranges[i].to.Next() == r.from
+	})
+	j := i
+	for j < len(s.ranges) && adjacent(r, s.ranges[j]) {
+		if s.ranges[j].from.Less(r.from) {
+			r.from = s.ranges[j].from
+		}
+		if r.to.Less(s.ranges[j].to) {
+			r.to = s.ranges[j].to
+		}
+		j++
+	}
+	rest := append([]ipRange{r}, s.ranges[j:]...)
+	s.ranges = append(s.ranges[:i], rest...)
+}
+
+// RemovePrefix 移除前缀中的所有地址
+func (s *Set) RemovePrefix(p netip.Prefix) {
+	if p.IsValid() {
+		first, last := Range(p)
+		s.remove(ipRange{first, last})
+	}
+}
+
+// RemoveRange 移除 [from, to]
+func (s *Set) RemoveRange(from, to netip.Addr) error {
+	if !from.IsValid() || !to.IsValid() || from.Is4() != to.Is4() || to.Less(from) {
+		return ErrInvalidRange
+	}
+	s.remove(ipRange{from, to})
+	return nil
+}
+
+func (s *Set) remove(r ipRange) {
+	var result []ipRange
+	for _, x := range s.ranges {
+		if x.to.Less(r.from) || r.to.Less(x.from) {
+			result = append(result, x)
+			continue
+		}
+		if x.from.Less(r.from) {
+			result = append(result, ipRange{x.from, r.from.Prev()})
+		}
+		if r.to.Less(x.to) {
+			result = append(result, ipRange{r.to.Next(), x.to})
+		}
+	}
+	s.ranges = result
+}
+
+// find 第一个结束地址不小于 a 的范围
+func (s *Set) find(a netip.Addr) int {
+	return sort.Search(len(s.ranges), func(i int) bool {
+		return !s.ranges[i].to.Less(a)
+	})
+}
+
+// Contains a 是否在集合中
+func (s *Set) Contains(a netip.Addr) bool {
+	i := s.find(a)
+	return i < len(s.ranges) && !a.Less(s.ranges[i].from)
+}
+
+// ContainsPrefix p 的所有地址是否都在集合中
+func (s *Set) ContainsPrefix(p netip.Prefix) bool {
+	first, last := Range(p)
+	i := s.find(first)
+	return i < len(s.ranges) && !first.Less(s.ranges[i].from) && !s.ranges[i].to.Less(last)
+}
+
+// Overlaps p 是否有地址在集合中
+func (s *Set) Overlaps(p netip.Prefix) bool {
+	first, last := Range(p)
+	i := s.find(first)
+	return i < len(s.ranges) && !last.Less(s.ranges[i].from)
+}
+
+// Union 返回 s 与 o 的并集
+func (s *Set) Union(o *Set) *Set {
+	u := &Set{ranges: append([]ipRange{}, s.ranges...)}
+	for _, r := range o.ranges {
+		u.add(r)
+	}
+	return u
+}
+
+// Intersect 返回 s 与 o 的交集
+func (s *Set) Intersect(o *Set) *Set {
+	result := &Set{}
+	i, j := 0, 0
+	for i < len(s.ranges) && j < len(o.ranges) {
+		a, b := s.ranges[i], o.ranges[j]
+		from, to := a.from, a.to
+		if from.Less(b.from) {
+			from = b.from
+		}
+		if b.to.Less(to) {
+			to = b.to
+		}
+		if !to.Less(from) {
+			result.ranges = append(result.ranges, ipRange{from, to})
+		}
+		if a.to.Less(b.to) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// Prefixes 返回覆盖集合的最少前缀, 按地址排序
+func (s *Set) Prefixes() []netip.Prefix {
+	var result []netip.Prefix
+	for _, r := range s.ranges {
+		prefixes, _ := RangeToPrefixes(r.from, r.to)
+		result = append(result, prefixes...)
+	}
+	return result
+}
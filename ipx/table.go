@@ -0,0 +1,249 @@
+package ipx
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Table 前缀表, 支持精确查找与最长前缀匹配, ipv4 与 ipv6 分别使用一棵二叉前缀树.
+// 零值可以直接使用, 修改时不能并发使用, 只读时可以并发查询
+type Table[V any] struct {
+	v4, v6 *tableNode[V]
+	n      int
+}
+
+type tableNode[V any] struct {
+	child [2]*tableNode[V]
+	value V
+	set   bool
+}
+
+// bit 返回地址从高位起的第 i 位
+func (u uint128) bit(i, bitLen int) int {
+	i = bitLen - 1 - i
+	if i >= 64 {
+		return int(u.hi>>uint(i-64)) & 1
+	}
+	return int(u.lo>>uint(i)) & 1
+}
+
+func (t *Table[V]) root(a netip.Addr, create bool) **tableNode[V] {
+	root := &t.v6
+	if a.Is4() {
+		root = &t.v4
+	}
+	if *root == nil && create {
+		*root = &tableNode[V]{}
+	}
+	return root
+}
+
+// Insert 设置 p 对应的值, 已存在时覆盖
+func (t *Table[V]) Insert(p netip.Prefix, v V) {
+	if !p.IsValid() {
+		return
+	}
+	p = p.Masked()
+	addr := fromAddr(p.Addr())
+	n := *t.root(p.Addr(), true)
+	for i := 0; i < p.Bits(); i++ {
+		b := addr.bit(i, p.Addr().BitLen())
+		if n.child[b] == nil {
+			n.child[b] = &tableNode[V]{}
+		}
+		n = n.child[b]
+	}
+	if !n.set {
+		t.n++
+	}
+	n.value, n.set = v, true
+}
+
+// Get 精确查找 p
+func (t *Table[V]) Get(p netip.Prefix) (V, bool) {
+	var zero V
+	if !p.IsValid() {
+		return zero, false
+	}
+	p = p.Masked()
+	addr := fromAddr(p.Addr())
+	n := *t.root(p.Addr(), false)
+	for i := 0; n != nil && i < p.Bits(); i++ {
+		n = n.child[addr.bit(i, p.Addr().BitLen())]
+	}
+	if n == nil || !n.set {
+		return zero, false
+	}
+	return n.value, true
+}
+
+// Delete 删除 p, 返回是否存在
+func (t *Table[V]) Delete(p netip.Prefix) bool {
+	if !p.IsValid() {
+		return false
+	}
+	p = p.Masked()
+	root := t.root(p.Addr(), false)
+	if !t.delete(root, fromAddr(p.Addr()), 0, p.Bits(), p.Addr().BitLen()) {
+		return false
+	}
+	t.n--
+	return true
+}
+
+// delete 删除后剪掉不再有值的分支
+func (t *Table[V]) delete(np **tableNode[V], addr uint128, depth, bits, bitLen int) bool {
+	n := *np
+	if n == nil {
+		return false
+	}
+	if depth == bits {
+		if !n.set {
+			return false
+		}
+		var zero V
+		n.value, n.set = zero, false
+	} else if !t.delete(&n.child[addr.bit(depth, bitLen)], addr, depth+1, bits, bitLen) {
+		return false
+	}
+	if !n.set && n.child[0] == nil && n.child[1] == nil {
+		*np = nil
+	}
+	return true
+}
+
+// Lookup 最长前缀匹配, ipv4-mapped ipv6 地址按 ipv4 处理
+func (t *Table[V]) Lookup(a netip.Addr) (netip.Prefix, V, bool) {
+	var (
+		value V
+		found bool
+		bits  int
+	)
+	if !a.IsValid() {
+		return netip.Prefix{}, value, false
+	}
+	a = a.Unmap()
+	addr := fromAddr(a)
+	n := *t.root(a, false)
+	for i := 0; n != nil; i++ {
+		if n.set {
+			value, found, bits = n.value, true, i
+		}
+		if i == a.BitLen() {
+			break
+		}
+		n = n.child[addr.bit(i, a.BitLen())]
+	}
+	if !found {
+		return netip.Prefix{}, value, false
+	}
+	p, _ := a.Prefix(bits)
+	return p, value, true
+}
+
+// Contains a 是否被表中任意前缀覆盖
+func (t *Table[V]) Contains(a netip.Addr) bool {
+	_, _, ok := t.Lookup(a)
+	return ok
+}
+
+// Len 返回前缀数量
+func (t *Table[V]) Len() int {
+	return t.n
+}
+
+// Walk 按地址顺序遍历所有前缀, ipv4 在前, 较短的前缀先于其包含的前缀; fn 返回 false 时停止
+func (t *Table[V]) Walk(fn func(netip.Prefix, V) bool) {
+	if walkNode(t.v4, uint128{}, 0, true, fn) {
+		walkNode(t.v6, uint128{}, 0, false, fn)
+	}
+}
+
+func walkNode[V any](n *tableNode[V], addr uint128, depth int, is4 bool, fn func(netip.Prefix, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.set && !fn(netip.PrefixFrom(addr.addr(is4), depth), n.value) {
+		return false
+	}
+	bitLen := 128
+	if is4 {
+		bitLen = 32
+	}
+	for b := 0; b < 2; b++ {
+		next := addr
+		if b == 1 {
+			// 第 depth 位在 addr 中为 0, 加上该位的权重即可置 1
+			next = addr.add(hostMask(bitLen - depth - 1).add(uint128{lo: 1}))
+		}
+		if !walkNode(n.child[b], next, depth+1, is4, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Load 从 r 逐行读取 CIDR 或单个地址并设置为 v, 忽略空行与 # 之后的注释
+func (t *Table[V]) Load(r io.Reader, v V) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		p, err := ParsePrefixOrAddr(text)
+		if err != nil {
+			return errors.WithMessagef(err, "line %d", line)
+		}
+		t.Insert(p, v)
+	}
+	return s.Err()
+}
+
+// ParsePrefixOrAddr 解析 CIDR, 单个地址视为长度等于地址位数的前缀
+func ParsePrefixOrAddr(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, errors.WithMessage(ErrInvalidPrefix, s)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, errors.WithMessage(ErrInvalidPrefix, s)
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// bogonPrefixes 不应出现在公网路由中的地址段
+var bogonPrefixes = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24",
+	"203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "::ffff:0:0/96", "64:ff9b:1::/48", "100::/64", "2001:2::/48", "2001:10::/28",
+	"2001:db8::/32", "3ffe::/16", "fc00::/7", "fe80::/10", "fec0::/10", "ff00::/8",
+}
+
+var (
+	bogonOnce  sync.Once
+	bogonTable Table[struct{}]
+)
+
+// IsBogon a 是否属于私有, 保留, 文档或组播等不应出现在公网上的地址段
+func IsBogon(a netip.Addr) bool {
+	bogonOnce.Do(func() {
+		for _, s := range bogonPrefixes {
+			bogonTable.Insert(netip.MustParsePrefix(s), struct{}{})
+		}
+	})
+	return bogonTable.Contains(a)
+}
@@ -0,0 +1,80 @@
+package ipx
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	var table Table[string]
+	err := table.Load(strings.NewReader(`
+# views
+10.0.0.0/8      # internal
+10.1.0.0/16
+10.1.2.3
+2001:db8::/32
+`), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Insert(netip.MustParsePrefix("10.1.0.0/16"), "b")
+	table.Insert(netip.MustParsePrefix("0.0.0.0/0"), "default")
+	if table.Len() != 5 {
+		t.Fatalf("len = %d", table.Len())
+	}
+
+	for addr, want := range map[string]string{
+		"10.1.2.3":        "10.1.2.3/32 a",
+		"10.1.9.9":        "10.1.0.0/16 b",
+		"10.200.0.1":      "10.0.0.0/8 a",
+		"::ffff:10.1.2.3": "10.1.2.3/32 a",
+		"8.8.8.8":         "0.0.0.0/0 default",
+		"2001:db8:1::1":   "2001:db8::/32 a",
+	} {
+		p, v, ok := table.Lookup(netip.MustParseAddr(addr))
+		if !ok || p.String()+" "+v != want {
+			t.Fatalf("lookup %s = %s %s %v", addr, p, v, ok)
+		}
+	}
+	if table.Contains(netip.MustParseAddr("2001:db9::1")) {
+		t.Fatal("contains")
+	}
+
+	var walked []string
+	table.Walk(func(p netip.Prefix, v string) bool {
+		walked = append(walked, p.String())
+		return true
+	})
+	if strings.Join(walked, " ") != "0.0.0.0/0 10.0.0.0/8 10.1.0.0/16 10.1.2.3/32 2001:db8::/32" {
+		t.Fatalf("walk = %v", walked)
+	}
+
+	if !table.Delete(netip.MustParsePrefix("10.1.0.0/16")) || table.Delete(netip.MustParsePrefix("10.1.0.0/16")) {
+		t.Fatal("delete")
+	}
+	if p, _, _ := table.Lookup(netip.MustParseAddr("10.1.9.9")); p.String() != "10.0.0.0/8" {
+		t.Fatalf("after delete = %s", p)
+	}
+	if _, ok := table.Get(netip.MustParsePrefix("10.1.2.3/32")); !ok {
+		t.Fatal("get")
+	}
+	if err := table.Load(strings.NewReader("10.0.0.0/33\n"), "x"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestIsBogon(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"192.168.1.1":     true,
+		"100.64.0.1":      true,
+		"8.8.8.8":         false,
+		"fe80::1":         true,
+		"2606:4700::1":    false,
+		"::ffff:10.0.0.1": true,
+	} {
+		if IsBogon(netip.MustParseAddr(addr)) != want {
+			t.Fatalf("%s: want %v", addr, want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	defaultSourceTTL     = 5
+)
+
+var ErrKubernetesStatus = errors.New("unexpected kubernetes api status")
+
+// KubernetesSource 按 Kubernetes DNS 规范从 API Server 读取 Service 与 Endpoints:
+//
+//	<svc>.<ns>.svc.<zone>                       ClusterIP, headless 服务为各 Endpoint 地址, ExternalName 为 CNAME
+//	<hostname>.<svc>.<ns>.svc.<zone>            headless 服务中设置了 hostname 的 Endpoint
+//	_<port>._<proto>.<svc>.<ns>.svc.<zone>      SRV
+//
+// 每次查询都会请求 API Server, 高频场景应在外层加缓存并通过 Watch 失效
+type KubernetesSource struct {
+	// APIServer 如 https://10.0.0.1:443, 为空时使用集群内配置
+	APIServer string
+	// Token 为空且 APIServer 为空时读取 service account token
+	Token string
+	// Client 为空且 APIServer 为空时使用 service account 的 CA, 否则使用 http.DefaultClient
+	Client *http.Client
+	// Zone 默认 cluster.local
+	Zone string
+	// TTL 默认 5s
+	TTL uint32
+
+	once    sync.Once
+	initErr error
+}
+
+type k8sPort struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+}
+
+type k8sService struct {
+	Spec struct {
+		Type         string    `json:"type"`
+		ClusterIP    string    `json:"clusterIP"`
+		ClusterIPs   []string  `json:"clusterIPs"`
+		ExternalName string    `json:"externalName"`
+		Ports        []k8sPort `json:"ports"`
+	} `json:"spec"`
+}
+
+type k8sEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP       string `json:"ip"`
+			Hostname string `json:"hostname"`
+		} `json:"addresses"`
+		Ports []k8sPort `json:"ports"`
+	} `json:"subsets"`
+}
+
+func (s *KubernetesSource) init() error {
+	s.once.Do(func() {
+		if s.APIServer != "" {
+			if s.Client == nil {
+				s.Client = http.DefaultClient
+			}
+			return
+		}
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			s.initErr = errors.New("not running in a kubernetes cluster and APIServer is empty")
+			return
+		}
+		s.APIServer = "https://" + net.JoinHostPort(host, port)
+		if s.Token == "" {
+			token, err := os.ReadFile(k8sServiceAccountDir + "token")
+			if err != nil {
+				s.initErr = err
+				return
+			}
+			s.Token = strings.TrimSpace(string(token))
+		}
+		if s.Client == nil {
+			ca, err := os.ReadFile(k8sServiceAccountDir + "ca.crt")
+			if err != nil {
+				s.initErr = err
+				return
+			}
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(ca)
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+			s.Client = &http.Client{Transport: transport}
+		}
+	})
+	return s.initErr
+}
+
+func (s *KubernetesSource) get(ctx context.Context, path string) (*http.Response, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(s.APIServer, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrRecordNotFound
+		}
+		return nil, errors.WithMessage(ErrKubernetesStatus, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *KubernetesSource) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := s.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *KubernetesSource) LookupRecords(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error) {
+	zone := s.Zone
+	if zone == "" {
+		zone = "cluster.local"
+	}
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = defaultSourceTTL
+	}
+	suffix := ".svc." + normalizeDomain(zone)
+	name = normalizeDomain(name)
+	if !strings.HasSuffix(name, suffix) {
+		return nil, ErrRecordNotFound
+	}
+	labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	if len(labels) < 2 || len(labels) > 4 {
+		return nil, ErrRecordNotFound
+	}
+	svcName, ns := labels[len(labels)-2], labels[len(labels)-1]
+	prefix := labels[:len(labels)-2]
+	var svc k8sService
+	if err := s.getJSON(ctx, "/api/v1/namespaces/"+ns+"/services/"+svcName, &svc); err != nil {
+		return nil, err
+	}
+	svcFQDN := svcName + "." + ns + suffix + "."
+	headless := svc.Spec.ClusterIP == "None"
+	var endpoints k8sEndpoints
+	if headless || len(prefix) > 0 {
+		if err := s.getJSON(ctx, "/api/v1/namespaces/"+ns+"/endpoints/"+svcName, &endpoints); err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	var records []*DNSResourceRecode
+	switch {
+	case len(prefix) == 0 && svc.Spec.Type == "ExternalName":
+		if qtype != DNSTypeCName && qtype != DNSTypeA && qtype != DNSTypeAAAA {
+			return nil, nil
+		}
+		records = append(records, &DNSResourceRecode{RRType: DNSTypeCName, Class: DNSClassIn, TTL: ttl, RData: svc.Spec.ExternalName})
+	case len(prefix) == 0 && !headless:
+		ips := svc.Spec.ClusterIPs
+		if len(ips) == 0 {
+			ips = []string{svc.Spec.ClusterIP}
+		}
+		for _, ip := range ips {
+			if rr := addressRecord("", ip, qtype, ttl); rr != nil {
+				records = append(records, rr)
+			}
+		}
+	case len(prefix) == 0:
+		for _, subset := range endpoints.Subsets {
+			for _, addr := range subset.Addresses {
+				if rr := addressRecord("", addr.IP, qtype, ttl); rr != nil {
+					records = append(records, rr)
+				}
+			}
+		}
+	case len(prefix) == 1:
+		// headless 服务的单个 Endpoint
+		found := false
+		for _, subset := range endpoints.Subsets {
+			for _, addr := range subset.Addresses {
+				if addr.Hostname != prefix[0] && strings.ReplaceAll(addr.IP, ".", "-") != prefix[0] {
+					continue
+				}
+				found = true
+				if rr := addressRecord("", addr.IP, qtype, ttl); rr != nil {
+					records = append(records, rr)
+				}
+			}
+		}
+		if !found {
+			return nil, ErrRecordNotFound
+		}
+	default:
+		// _port._proto
+		port, proto := strings.TrimPrefix(prefix[0], "_"), strings.TrimPrefix(prefix[1], "_")
+		found := false
+		for _, p := range svc.Spec.Ports {
+			if p.Name != port || !strings.EqualFold(p.Protocol, proto) {
+				continue
+			}
+			found = true
+			if qtype != DNSTypeSRV {
+				continue
+			}
+			if !headless {
+				records = append(records, srvRecord("", 0, 100, p.Port, svcFQDN, ttl))
+				continue
+			}
+			for _, subset := range endpoints.Subsets {
+				for _, ep := range subset.Ports {
+					if ep.Name != port {
+						continue
+					}
+					for _, addr := range subset.Addresses {
+						host := addr.Hostname
+						if host == "" {
+							host = strings.ReplaceAll(addr.IP, ".", "-")
+						}
+						records = append(records, srvRecord("", 0, 100, ep.Port, host+"."+svcFQDN, ttl))
+					}
+				}
+			}
+		}
+		if !found {
+			return nil, ErrRecordNotFound
+		}
+	}
+	return records, nil
+}
+
+// Watch 监听所有 Service 与 Endpoints 的变化, 每个事件调用一次 fn
+func (s *KubernetesSource) Watch(ctx context.Context, fn func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 2)
+	for _, resource := range []string{"services", "endpoints"} {
+		go func(resource string) {
+			errs <- s.watch(ctx, "/api/v1/"+resource+"?watch=1", fn)
+		}(resource)
+	}
+	err := <-errs
+	cancel()
+	<-errs
+	return err
+}
+
+func (s *KubernetesSource) watch(ctx context.Context, path string, fn func()) error {
+	resp, err := s.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 每行一个 {"type": ..., "object": ...} 事件
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var event struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &event) == nil && event.Type != "" {
+			fn()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("kubernetes watch closed")
+}
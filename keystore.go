@@ -0,0 +1,544 @@
+package netx
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// scrypt 参数, 保存在加密文件的头中
+	keyScryptN = 1 << 15
+	keyScryptR = 8
+	keyScryptP = 1
+
+	keyPEMType          = "PRIVATE KEY"
+	keyEncryptedPEMType = "ENCRYPTED DNSSEC PRIVATE KEY"
+
+	// maxKeyTagAttempts 生成的密钥与已有密钥的标签冲突时重新生成的次数
+	maxKeyTagAttempts = 16
+)
+
+var (
+	ErrKeyNotFound   = errors.New("dnssec key not found")
+	ErrKeyState      = errors.New("invalid key state transition")
+	ErrKeyPassphrase = errors.New("wrong passphrase or corrupt key file")
+)
+
+// GenerateDNSSECKey 生成 algorithm 对应的私钥: ECDSA P-256/P-384, Ed25519, RSA 2048 位
+func GenerateDNSSECKey(algorithm uint8) (crypto.Signer, error) {
+	switch algorithm {
+	case DNSSECAlgECDSAP256SHA256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case DNSSECAlgECDSAP384SHA384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case DNSSECAlgED25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case DNSSECAlgRSASHA256, DNSSECAlgRSASHA512:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return nil, errors.WithMessagef(ErrDNSSECAlgorithm, "algorithm %d", algorithm)
+}
+
+// newSigningKeyWith 与 NewSigningKey 相同, RSA 密钥使用 algorithm 指定的散列
+func newSigningKeyWith(signer crypto.Signer, algorithm uint8, flags uint16) (*SigningKey, error) {
+	k, err := NewSigningKey(signer, flags)
+	if err != nil {
+		return nil, err
+	}
+	if k.DNSKEY.Algorithm == DNSSECAlgRSASHA256 && algorithm == DNSSECAlgRSASHA512 {
+		k.DNSKEY.Algorithm = algorithm
+	}
+	if k.DNSKEY.Algorithm != algorithm {
+		return nil, errors.WithMessagef(ErrDNSSECAlgorithm, "key is algorithm %d, want %d", k.DNSKEY.Algorithm, algorithm)
+	}
+	return k, nil
+}
+
+// KeyBackend 保存私钥的后端. 私钥留在 HSM 或 KMS 中时, 实现返回代理签名的 crypto.Signer
+type KeyBackend interface {
+	// GenerateKey 生成并保存私钥, 返回之后用于取回私钥的引用
+	GenerateKey(ctx context.Context, algorithm uint8) (ref string, signer crypto.Signer, err error)
+	// Signer 返回 ref 引用的私钥
+	Signer(ctx context.Context, ref string) (crypto.Signer, error)
+	// DeleteKey 删除 ref 引用的私钥
+	DeleteKey(ctx context.Context, ref string) error
+}
+
+// FileKeyBackend 将私钥以 PEM 编码的 PKCS#8 保存在 Dir 中. Passphrase 不为空时私钥用 scrypt 派生的密钥
+// 以 AES-256-GCM 加密, 否则以明文保存, 只有文件权限保护
+type FileKeyBackend struct {
+	Dir        string
+	Passphrase []byte
+}
+
+func (b *FileKeyBackend) path(ref string) (string, error) {
+	if ref == "" || strings.ContainsAny(ref, `/\`) || strings.HasPrefix(ref, ".") {
+		return "", errors.WithMessage(ErrKeyNotFound, ref)
+	}
+	return filepath.Join(b.Dir, ref+".private"), nil
+}
+
+// GenerateKey 实现 KeyBackend
+func (b *FileKeyBackend) GenerateKey(ctx context.Context, algorithm uint8) (string, crypto.Signer, error) {
+	signer, err := GenerateDNSSECKey(algorithm)
+	if err != nil {
+		return "", nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	ref := hex.EncodeToString(id)
+	data, err := b.encode(signer)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(b.Dir, 0o700); err != nil {
+		return "", nil, err
+	}
+	path, _ := b.path(ref)
+	if err := writeFileAtomic(path, data, 0o600); err != nil {
+		return "", nil, err
+	}
+	return ref, signer, nil
+}
+
+func (b *FileKeyBackend) encode(signer crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Passphrase) == 0 {
+		return pem.EncodeToMemory(&pem.Block{Type: keyPEMType, Bytes: der}), nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keyCipher(b.Passphrase, salt, keyScryptN, keyScryptR, keyScryptP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: keyEncryptedPEMType,
+		Headers: map[string]string{
+			"KDF":   "scrypt " + strconv.Itoa(keyScryptN) + " " + strconv.Itoa(keyScryptR) + " " + strconv.Itoa(keyScryptP),
+			"Salt":  hex.EncodeToString(salt),
+			"Nonce": hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, der, nil),
+	}), nil
+}
+
+// keyCipher 由口令派生 AES-256-GCM 密钥
+func keyCipher(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Signer 实现 KeyBackend
+func (b *FileKeyBackend) Signer(ctx context.Context, ref string) (crypto.Signer, error) {
+	path, err := b.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.WithMessage(ErrKeyNotFound, ref)
+		}
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.WithMessage(ErrKeyPassphrase, "no pem block")
+	}
+	der := block.Bytes
+	if block.Type == keyEncryptedPEMType {
+		kdf := strings.Fields(block.Headers["KDF"])
+		if len(kdf) != 4 || kdf[0] != "scrypt" {
+			return nil, errors.WithMessage(ErrKeyPassphrase, "unknown kdf")
+		}
+		n, err1 := strconv.Atoi(kdf[1])
+		r, err2 := strconv.Atoi(kdf[2])
+		p, err3 := strconv.Atoi(kdf[3])
+		salt, err4 := hex.DecodeString(block.Headers["Salt"])
+		nonce, err5 := hex.DecodeString(block.Headers["Nonce"])
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+			return nil, errors.WithMessage(ErrKeyPassphrase, "bad key file header")
+		}
+		aead, err := keyCipher(b.Passphrase, salt, n, r, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return nil, errors.WithMessage(ErrKeyPassphrase, "bad nonce")
+		}
+		if der, err = aead.Open(nil, nonce, der, nil); err != nil {
+			return nil, ErrKeyPassphrase
+		}
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrDNSSECAlgorithm
+	}
+	return signer, nil
+}
+
+// DeleteKey 实现 KeyBackend
+func (b *FileKeyBackend) DeleteKey(ctx context.Context, ref string) error {
+	path, err := b.path(ref)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// KeyState 密钥在轮换中的状态, 只能向后转换 (参考 RFC 7583)
+type KeyState int
+
+const (
+	// KeyGenerated 已生成, 尚未发布
+	KeyGenerated KeyState = iota
+	// KeyPublished DNSKEY 已发布, 不签名, 等待旧的 DNSKEY rrset 从缓存中过期
+	KeyPublished
+	// KeyActive 发布并签名
+	KeyActive
+	// KeyRetired 仍然发布, 不再签名, 等待它的签名从缓存中过期
+	KeyRetired
+	// KeyRemoved 不再发布, 可以删除
+	KeyRemoved
+)
+
+var keyStateNames = []string{"generated", "published", "active", "retired", "removed"}
+
+func (s KeyState) String() string {
+	if s >= 0 && int(s) < len(keyStateNames) {
+		return keyStateNames[s]
+	}
+	return "KeyState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ManagedKey KeyStore 管理的密钥
+type ManagedKey struct {
+	// ID 形如 Kexample.+013+12345, 由区域, 算法与密钥标签组成
+	ID     string
+	Zone   string
+	DNSKEY *DNSKEY
+	State  KeyState
+	// Ref 私钥在 KeyBackend 中的引用
+	Ref string
+	// Since 进入每个状态的时间
+	Since map[KeyState]time.Time
+}
+
+// KeyTag 返回密钥标签
+func (k *ManagedKey) KeyTag() uint16 {
+	return k.DNSKEY.KeyTag()
+}
+
+// KSK 是否带 SEP 标志
+func (k *ManagedKey) KSK() bool {
+	return k.DNSKEY.Flags&DNSKEYFlagSEP != 0
+}
+
+// keyRecord ManagedKey 保存在磁盘上的形式
+type keyRecord struct {
+	Zone   string               `json:"zone"`
+	DNSKEY []byte               `json:"dnskey"`
+	State  string               `json:"state"`
+	Ref    string               `json:"ref"`
+	Since  map[string]time.Time `json:"since"`
+}
+
+// KeyStore 保存区域的 DNSSEC 密钥及其轮换状态. 元数据以 JSON 保存在 Dir 中, 私钥保存在 Backend 中,
+// Backend 为空时以明文保存在 Dir 中
+type KeyStore struct {
+	Dir     string
+	Backend KeyBackend
+
+	mu sync.Mutex
+}
+
+func (s *KeyStore) backend() KeyBackend {
+	if s.Backend != nil {
+		return s.Backend
+	}
+	return &FileKeyBackend{Dir: s.Dir}
+}
+
+// keyID 返回与 BIND 相同形式的名字
+func keyID(zone string, key *DNSKEY) string {
+	return "K" + absoluteName(normalizeDomain(zone)) + "+" + leftPad(strconv.Itoa(int(key.Algorithm)), 3) + "+" + leftPad(strconv.Itoa(int(key.KeyTag())), 5)
+}
+
+func leftPad(s string, n int) string {
+	if len(s) < n {
+		s = strings.Repeat("0", n-len(s)) + s
+	}
+	return s
+}
+
+// Generate 为 zone 生成新的密钥, 状态为 KeyGenerated. 密钥标签与区域中已有的密钥冲突时重新生成
+func (s *KeyStore) Generate(ctx context.Context, zone string, algorithm uint8, ksk bool) (*ManagedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.keys(zone)
+	if err != nil {
+		return nil, err
+	}
+	tags := map[uint16]bool{}
+	for _, k := range existing {
+		tags[k.KeyTag()] = true
+	}
+	flags := uint16(DNSKEYFlagZone)
+	if ksk {
+		flags |= DNSKEYFlagSEP
+	}
+	backend := s.backend()
+	for i := 0; i < maxKeyTagAttempts; i++ {
+		ref, signer, err := backend.GenerateKey(ctx, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		sk, err := newSigningKeyWith(signer, algorithm, flags)
+		if err != nil {
+			_ = backend.DeleteKey(ctx, ref)
+			return nil, err
+		}
+		if tags[sk.DNSKEY.KeyTag()] {
+			_ = backend.DeleteKey(ctx, ref)
+			continue
+		}
+		k := &ManagedKey{
+			ID:     keyID(zone, sk.DNSKEY),
+			Zone:   normalizeDomain(zone),
+			DNSKEY: sk.DNSKEY,
+			Ref:    ref,
+			Since:  map[KeyState]time.Time{KeyGenerated: time.Now().UTC()},
+		}
+		if err := s.save(k); err != nil {
+			_ = backend.DeleteKey(ctx, ref)
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, errors.New("could not generate a key with a unique key tag")
+}
+
+// Keys 返回 zone 的全部密钥, 按 ID 排序
+func (s *KeyStore) Keys(zone string) ([]*ManagedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys(zone)
+}
+
+func (s *KeyStore) keys(zone string) ([]*ManagedKey, error) {
+	prefix := "K" + absoluteName(normalizeDomain(zone)) + "+"
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []*ManagedKey
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		k, err := s.load(filepath.Join(s.Dir, name))
+		if err != nil {
+			return nil, errors.WithMessage(err, name)
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *KeyStore) load(path string) (*ManagedKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec keyRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	key, err := ParseDNSKEY(rec.DNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	k := &ManagedKey{ID: keyID(rec.Zone, key), Zone: rec.Zone, DNSKEY: key, Ref: rec.Ref, Since: map[KeyState]time.Time{}}
+	k.State = -1
+	for i, name := range keyStateNames {
+		if name == rec.State {
+			k.State = KeyState(i)
+		}
+		if t, ok := rec.Since[name]; ok {
+			k.Since[KeyState(i)] = t
+		}
+	}
+	if k.State < 0 {
+		return nil, errors.WithMessage(ErrKeyState, "unknown state "+rec.State)
+	}
+	return k, nil
+}
+
+func (s *KeyStore) save(k *ManagedKey) error {
+	rec := keyRecord{Zone: k.Zone, DNSKEY: k.DNSKEY.Pack(), State: k.State.String(), Ref: k.Ref, Since: map[string]time.Time{}}
+	for state, t := range k.Since {
+		rec.Since[state.String()] = t
+	}
+	data, err := json.MarshalIndent(rec, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.Dir, k.ID+".json"), append(data, '\n'), 0o600)
+}
+
+// SetState 将密钥转换到 state 并保存, 只能向后转换, 可以跳过中间的状态
+func (s *KeyStore) SetState(k *ManagedKey, state KeyState) error {
+	if state <= k.State || state > KeyRemoved {
+		return errors.WithMessage(ErrKeyState, k.ID+": "+k.State.String()+" to "+state.String())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, since := k.State, k.Since[state]
+	k.State = state
+	if k.Since == nil {
+		k.Since = map[KeyState]time.Time{}
+	}
+	k.Since[state] = time.Now().UTC()
+	if err := s.save(k); err != nil {
+		k.State, k.Since[state] = prev, since
+		return err
+	}
+	return nil
+}
+
+// Delete 删除尚未发布或已经移除的密钥及其私钥
+func (s *KeyStore) Delete(ctx context.Context, k *ManagedKey) error {
+	if k.State != KeyGenerated && k.State != KeyRemoved {
+		return errors.WithMessage(ErrKeyState, k.ID+" is still "+k.State.String())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.backend().DeleteKey(ctx, k.Ref); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.Dir, k.ID+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SigningKeys 返回 zone 中处于 KeyActive 的密钥, 用于 ZoneSigner
+func (s *KeyStore) SigningKeys(ctx context.Context, zone string) ([]*SigningKey, error) {
+	keys, err := s.Keys(zone)
+	if err != nil {
+		return nil, err
+	}
+	var out []*SigningKey
+	for _, k := range keys {
+		if k.State != KeyActive {
+			continue
+		}
+		signer, err := s.backend().Signer(ctx, k.Ref)
+		if err != nil {
+			return nil, errors.WithMessage(err, k.ID)
+		}
+		sk, err := newSigningKeyWith(signer, k.DNSKEY.Algorithm, k.DNSKEY.Flags)
+		if err != nil {
+			return nil, errors.WithMessage(err, k.ID)
+		}
+		if string(sk.DNSKEY.PublicKey) != string(k.DNSKEY.PublicKey) {
+			return nil, errors.WithMessage(ErrKeyNotFound, k.ID+": private key does not match")
+		}
+		out = append(out, &SigningKey{DNSKEY: k.DNSKEY, Signer: signer})
+	}
+	return out, nil
+}
+
+// PublishedKeys 返回 zone 中应当出现在 DNSKEY rrset 中的密钥: KeyPublished, KeyActive 与 KeyRetired
+func (s *KeyStore) PublishedKeys(zone string) ([]*DNSKEY, error) {
+	keys, err := s.Keys(zone)
+	if err != nil {
+		return nil, err
+	}
+	var out []*DNSKEY
+	for _, k := range keys {
+		if k.State >= KeyPublished && k.State <= KeyRetired {
+			out = append(out, k.DNSKEY)
+		}
+	}
+	return out, nil
+}
+
+// writeFileAtomic 写入临时文件后改名, 不会留下写了一半的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
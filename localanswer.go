@@ -0,0 +1,145 @@
+package netx
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// LocalAnswers 应用在运行时注册的临时应答, 到期之前代替真实的查询, 例如蓝绿切换时让 api.internal
+// 在 10 分钟内解析到新地址, 或者集成测试中把名字指向本地服务. 只匹配完全相同的名字,
+// 已注册的名字没有所查类型的记录时应答 NOERROR 无数据. 零值可用, 可以并发使用
+type LocalAnswers struct {
+	mu      sync.Mutex
+	entries map[string]*localAnswer
+}
+
+type localAnswer struct {
+	records []*DNSResourceRecode
+	expires time.Time
+}
+
+// Set 在 d 时间内用 records 应答 name, 替换 name 之前的记录. records 的 Name 被忽略, Class 为 0 时为 IN.
+// 应答中记录的 TTL 为剩余时间与原 TTL 中较小的一个, 原 TTL 为 0 时只使用剩余时间
+func (l *LocalAnswers) Set(name string, d time.Duration, records ...*DNSResourceRecode) {
+	now := time.Now()
+	key := normalizeDomain(name)
+	e := &localAnswer{records: make([]*DNSResourceRecode, 0, len(records)), expires: now.Add(d)}
+	for _, rr := range records {
+		c := rr.Copy()
+		c.Name = key
+		if c.Class == 0 {
+			c.Class = DNSClassIn
+		}
+		e.records = append(e.records, c)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.entries == nil {
+		l.entries = map[string]*localAnswer{}
+	}
+	for k, old := range l.entries {
+		if !now.Before(old.expires) {
+			delete(l.entries, k)
+		}
+	}
+	l.entries[key] = e
+}
+
+// SetAddr 在 d 时间内把 name 解析为 addrs, 按地址族生成 A 与 AAAA 记录
+func (l *LocalAnswers) SetAddr(name string, d time.Duration, addrs ...netip.Addr) {
+	records := make([]*DNSResourceRecode, 0, len(addrs))
+	for _, addr := range addrs {
+		rr := &DNSResourceRecode{RRType: DNSTypeA, RData: addr.Unmap().String()}
+		if !addr.Unmap().Is4() {
+			rr.RRType = DNSTypeAAAA
+		}
+		records = append(records, rr)
+	}
+	l.Set(name, d, records...)
+}
+
+// Delete 提前删除 name 的应答
+func (l *LocalAnswers) Delete(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, normalizeDomain(name))
+}
+
+// Lookup 返回 name 当前的 qtype 记录 (CNAME 对所有类型返回), ok 为假表示 name 没有注册或已经过期
+func (l *LocalAnswers) Lookup(name string, qtype uint16) (records []*DNSResourceRecode, ok bool) {
+	return l.lookup(name, qtype, time.Now())
+}
+
+func (l *LocalAnswers) lookup(name string, qtype uint16, now time.Time) ([]*DNSResourceRecode, bool) {
+	key := normalizeDomain(name)
+	l.mu.Lock()
+	e := l.entries[key]
+	if e != nil && !now.Before(e.expires) {
+		delete(l.entries, key)
+		e = nil
+	}
+	l.mu.Unlock()
+	if e == nil {
+		return nil, false
+	}
+	// 向上取整, 最后一秒内的应答不会以 TTL 0 返回
+	remaining := uint32((e.expires.Sub(now) + time.Second - 1) / time.Second)
+	var records []*DNSResourceRecode
+	for _, rr := range e.records {
+		if rr.RRType != qtype && rr.RRType != DNSTypeCName && qtype != DNSTypeANY {
+			continue
+		}
+		c := rr.Copy()
+		if c.TTL == 0 || c.TTL > remaining {
+			c.TTL = remaining
+		}
+		records = append(records, c)
+	}
+	return records, true
+}
+
+// reply 在 name 已注册时构造 req 的应答
+func (l *LocalAnswers) reply(req *DNSMessage) *DNSMessage {
+	if len(req.Questions) != 1 {
+		return nil
+	}
+	q := req.Questions[0]
+	records, ok := l.Lookup(q.QuestionName, q.QuestionType)
+	if !ok {
+		return nil
+	}
+	resp := NewReply(req)
+	resp.Header.Flags.RA = 1
+	for _, rr := range records {
+		rr.Name = q.QuestionName
+	}
+	resp.ResourceRecodes = records
+	resp.Header.AnswerRRs = uint16(len(records))
+	return resp
+}
+
+// Interceptor 已注册的名字直接应答, 不发出查询
+func (l *LocalAnswers) Interceptor() Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			if resp := l.reply(req); resp != nil {
+				return resp, nil
+			}
+			return next.RoundTrip(ctx, server, req)
+		})
+	}
+}
+
+// WithLocalAnswers 服务端的 LocalAnswers, 已注册的名字直接应答, 其它请求交给 next
+func WithLocalAnswers(l *LocalAnswers) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			if resp := l.reply(req.Message); resp != nil {
+				return resp, nil
+			}
+			return next.ServeDNS(ctx, req)
+		})
+	}
+}
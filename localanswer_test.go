@@ -0,0 +1,61 @@
+package netx
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLocalAnswers(t *testing.T) {
+	l := &LocalAnswers{}
+	l.SetAddr("API.internal.", 10*time.Minute, netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("fd00::3"))
+	l.Set("www.internal", time.Minute, &DNSResourceRecode{RRType: DNSTypeCName, TTL: 300, RData: "api.internal"})
+
+	now := time.Now()
+	if rrs, ok := l.lookup("api.internal", DNSTypeA, now); !ok || len(rrs) != 1 || rrs[0].RData != "10.1.2.3" || rrs[0].TTL > 600 || rrs[0].TTL < 599 {
+		t.Fatalf("lookup A = %v, %v", rrs, ok)
+	}
+	if rrs, ok := l.lookup("api.internal", DNSTypeMX, now); !ok || len(rrs) != 0 {
+		t.Fatalf("lookup MX = %v, %v", rrs, ok)
+	}
+	if rrs, ok := l.lookup("www.internal", DNSTypeAAAA, now); !ok || len(rrs) != 1 || rrs[0].TTL != 60 {
+		t.Fatalf("lookup cname = %v, %v", rrs, ok)
+	}
+	if _, ok := l.lookup("www.internal", DNSTypeA, now.Add(2*time.Minute)); ok {
+		t.Fatal("expired answer returned")
+	}
+	// 过期的查询已经删除了记录
+	if _, ok := l.Lookup("www.internal", DNSTypeA); ok {
+		t.Fatal("expired entry kept")
+	}
+
+	sent := 0
+	r := &Resolver{
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			sent++
+			resp := NewReply(req)
+			resp.Header.Flags.RCode = DNSRCodeNXDomain
+			return resp, nil
+		}),
+		Interceptors: []Interceptor{l.Interceptor()},
+	}
+	addrs, err := r.LookupHost(context.Background(), "api.internal")
+	if err != nil || len(addrs) != 1 || addrs[0] != "10.1.2.3" || sent != 0 {
+		t.Fatalf("LookupHost = %v, %v, sent %d", addrs, err, sent)
+	}
+	l.Delete("api.internal")
+	if _, err := r.LookupHost(context.Background(), "api.internal"); err == nil || sent == 0 {
+		t.Fatalf("deleted override used: %v", err)
+	}
+
+	l.SetAddr("db.internal", time.Minute, netip.MustParseAddr("10.0.0.9"))
+	h := ChainHandler(nil, WithLocalAnswers(l))
+	resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("DB.internal", DNSTypeA), Network: "udp"})
+	if err != nil || resp.Header.Flags.RCode != DNSRCodeSuccess || len(resp.Answers()) != 1 || resp.Answers()[0].Name != "DB.internal" {
+		t.Fatalf("server answer = %v, %v", resp, err)
+	}
+	if resp, _ := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("other.internal", DNSTypeA), Network: "udp"}); resp.Header.Flags.RCode != DNSRCodeRefused {
+		t.Fatalf("unregistered name: rcode %d", resp.Header.Flags.RCode)
+	}
+}
@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+)
+
+var ErrNoResponse = errors.New("server sent no response")
+
+// UnixTransport 通过 unix socket 发送请求, 格式与 tcp 相同 (两字节长度前缀), server 为 socket 的路径.
+// 用于与同一主机上的 sidecar 通信, 不占用端口. 服务端用 DNSServer.ServeTCP 处理 unix 监听
+type UnixTransport struct {
+	// Dialer 为空时直接连接, 调用时 network 为 "unix"
+	Dialer Dialer
+}
+
+func (t UnixTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	info := queryInfoFrom(ctx)
+	info.attempt("unix")
+	var dialer Dialer = &net.Dialer{}
+	if t.Dialer != nil {
+		dialer = t.Dialer
+	}
+	conn, err := info.dial(ctx, dialer, false, "unix", server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	return tcpExchange(ctx, conn, req)
+}
+
+// PipeTransport 在进程内把请求交给 Server 处理, 不打开任何端口. 请求与响应仍然经过编码与解析,
+// 并经过 Server 的 Middlewares, 行为与真实的服务器一致, 适合快速且隔离的测试. 参数 server 被忽略,
+// 处理器看到的 Network 为 "pipe". Server 不应答时返回 ErrNoResponse
+type PipeTransport struct {
+	Server *DNSServer
+	// RemoteAddr 处理器看到的客户端地址, 例如测试视图时使用 *net.UDPAddr. 为空时为 "pipe"
+	RemoteAddr net.Addr
+}
+
+// pipeAddr 没有指定 RemoteAddr 时的客户端地址, 与 net.Pipe 相同
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func (t PipeTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	queryInfoFrom(ctx).attempt("pipe")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	packet, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	var addr net.Addr = pipeAddr{}
+	if t.RemoteAddr != nil {
+		addr = t.RemoteAddr
+	}
+	b := t.Server.serve(t.Server.handler(), packet, "pipe", addr, nil)
+	if b == nil {
+		return nil, ErrNoResponse
+	}
+	resp, err := Unpack(b)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.TxID != req.Header.TxID {
+		return nil, ErrTxIDMismatch
+	}
+	return resp, nil
+}
@@ -0,0 +1,88 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	networks := make(chan string, 4)
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		networks <- req.Network
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, "192.0.2.7")}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	go s.ServeTCP(ln)
+	defer s.Shutdown(context.Background())
+
+	info := &QueryInfo{}
+	r := &Resolver{Server: path, Transport: UnixTransport{}}
+	addrs, err := r.LookupHost(WithQueryInfo(context.Background(), info), "sidecar.example")
+	if err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.7" {
+		t.Fatalf("LookupHost = %v, %v", addrs, err)
+	}
+	if n := <-networks; n != "unix" || info.Transport != "unix" {
+		t.Fatalf("network %q, transport %q", n, info.Transport)
+	}
+
+	// 配置文件中的 unix 转发
+	c, err := ParseServerConfig([]byte("forwarders: [{network: unix, servers: ["+path+"]}]"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery("www.example", DNSTypeA), Network: "udp"})
+	if err != nil || len(resp.Answers()) != 1 || resp.Answers()[0].RData != "192.0.2.7" {
+		t.Fatalf("unix forwarder = %v, %v", resp, err)
+	}
+}
+
+func TestPipeTransport(t *testing.T) {
+	var got *DNSRequest
+	s := &DNSServer{
+		Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			got = &DNSRequest{Network: req.Network, RemoteAddr: req.RemoteAddr}
+			if req.Message.Questions[0].QuestionName == "drop.example" {
+				return nil, nil
+			}
+			resp := NewReply(req.Message)
+			resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, "192.0.2.8")}
+			resp.Header.AnswerRRs = 1
+			return resp, nil
+		}),
+		Middlewares: []ServerMiddleware{WithBlocklist(NewDomainBlocklist("ads.example"))},
+	}
+	r := &Resolver{Transport: PipeTransport{Server: s}}
+	addrs, err := r.LookupHost(context.Background(), "www.example")
+	if err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.8" {
+		t.Fatalf("LookupHost = %v, %v", addrs, err)
+	}
+	if got.Network != "pipe" || got.RemoteAddr.String() != "pipe" || got.ClientIP() != nil {
+		t.Fatalf("request %+v", got)
+	}
+	// 请求经过服务器的中间件
+	if _, err := r.LookupHost(context.Background(), "x.ads.example"); err == nil {
+		t.Fatal("blocked name resolved")
+	}
+	if _, err := r.Query(context.Background(), "drop.example", DNSTypeA); !errors.Is(err, ErrNoResponse) {
+		t.Fatalf("dropped query: %v", err)
+	}
+
+	r.Transport = PipeTransport{Server: s, RemoteAddr: &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}}
+	if _, err := r.LookupHost(context.Background(), "www.example"); err != nil || got.ClientIP().String() != "10.0.0.1" {
+		t.Fatalf("remote addr: %v, %v", got.RemoteAddr, err)
+	}
+}
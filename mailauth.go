@@ -0,0 +1,265 @@
+package netx
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoDKIMKey   = errors.New("no dkim key record")
+	ErrDKIMKey     = errors.New("invalid dkim key record")
+	ErrNoDMARC     = errors.New("no dmarc record")
+	ErrDMARCRecord = errors.New("invalid dmarc record")
+)
+
+// DKIMKey RFC 6376 3.6.1 密钥记录
+type DKIMKey struct {
+	Version        string   // v=, 存在时必须为 DKIM1
+	KeyType        string   // k=, 默认 rsa
+	HashAlgorithms []string // h=, 为空表示不限
+	ServiceTypes   []string // s=, 默认 *
+	Flags          []string // t=, y 表示测试模式, s 表示严格匹配子域名
+	Notes          string   // n=
+	// PublicKey *rsa.PublicKey 或 ed25519.PublicKey, p= 为空时为 nil 表示密钥已撤销
+	PublicKey crypto.PublicKey
+}
+
+// Revoked 密钥是否已撤销
+func (k *DKIMKey) Revoked() bool {
+	return k.PublicKey == nil
+}
+
+// Testing 是否为测试模式 (t=y)
+func (k *DKIMKey) Testing() bool {
+	for _, f := range k.Flags {
+		if f == "y" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTagList 按 ":" 分隔并去掉空白
+func splitTagList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ":") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ParseDKIMKey 解析 <selector>._domainkey.<domain> TXT 记录
+func ParseDKIMKey(txt string) (*DKIMKey, error) {
+	tags := parseTXTFields(txt)
+	key := &DKIMKey{
+		Version:      tags["v"],
+		KeyType:      "rsa",
+		ServiceTypes: []string{"*"},
+		Notes:        tags["n"],
+	}
+	if key.Version != "" && key.Version != "DKIM1" {
+		return nil, errors.WithMessage(ErrDKIMKey, "bad version "+key.Version)
+	}
+	if v, ok := tags["k"]; ok {
+		key.KeyType = v
+	}
+	if v, ok := tags["h"]; ok {
+		key.HashAlgorithms = splitTagList(v)
+	}
+	if v, ok := tags["s"]; ok {
+		key.ServiceTypes = splitTagList(v)
+	}
+	if v, ok := tags["t"]; ok {
+		key.Flags = splitTagList(v)
+	}
+	p, ok := tags["p"]
+	if !ok {
+		return nil, errors.WithMessage(ErrDKIMKey, "missing p=")
+	}
+	// p= 中允许出现空白
+	p = strings.Join(strings.Fields(p), "")
+	if p == "" {
+		return key, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, errors.WithMessage(ErrDKIMKey, "bad p= encoding")
+	}
+	switch key.KeyType {
+	case "rsa":
+		// 标准为 SubjectPublicKeyInfo, 部分实现使用 PKCS#1
+		if pub, err := x509.ParsePKIXPublicKey(raw); err == nil {
+			key.PublicKey = pub
+		} else if pub, err := x509.ParsePKCS1PublicKey(raw); err == nil {
+			key.PublicKey = pub
+		} else {
+			return nil, errors.WithMessage(ErrDKIMKey, "bad rsa key")
+		}
+	case "ed25519":
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, errors.WithMessage(ErrDKIMKey, "bad ed25519 key")
+		}
+		key.PublicKey = ed25519.PublicKey(raw)
+	default:
+		return nil, errors.WithMessage(ErrDKIMKey, "unknown key type "+key.KeyType)
+	}
+	return key, nil
+}
+
+// LookupDKIMKey 查询 selector 在 domain 下的 DKIM 公钥, 有多条记录时使用第一条可以解析的记录
+func (r *Resolver) LookupDKIMKey(ctx context.Context, selector, domain string) (*DKIMKey, error) {
+	if err := r.NameProfile.Check(domain); err != nil {
+		return nil, err
+	}
+	name := selector + "._domainkey." + strings.TrimSuffix(domain, ".")
+	txts, err := r.lookupTXT(ctx, name)
+	if err != nil {
+		if isNXDomain(err) {
+			return nil, errors.WithMessage(ErrNoDKIMKey, name)
+		}
+		return nil, err
+	}
+	err = errors.WithMessage(ErrNoDKIMKey, name)
+	for _, txt := range txts {
+		key, e := ParseDKIMKey(txt)
+		if e == nil {
+			return key, nil
+		}
+		err = e
+	}
+	return nil, err
+}
+
+// DMARCRecord RFC 7489 _dmarc TXT 记录, 未出现的标签使用默认值
+type DMARCRecord struct {
+	Policy          string // p=, none, quarantine 或 reject
+	SubdomainPolicy string // sp=, 默认与 Policy 相同
+	Percent         int    // pct=, 默认 100
+	ADKIM           string // adkim=, r 或 s, 默认 r
+	ASPF            string // aspf=, r 或 s, 默认 r
+	RUA             []string
+	RUF             []string
+	FailureOptions  string        // fo=, 默认 0
+	ReportInterval  time.Duration // ri=, 默认 86400 秒
+}
+
+func validDMARCPolicy(p string) bool {
+	return p == "none" || p == "quarantine" || p == "reject"
+}
+
+// ParseDMARC 解析 DMARC 记录
+func ParseDMARC(txt string) (*DMARCRecord, error) {
+	if !hasVersionTag(strings.TrimSpace(txt), "v=DMARC1") {
+		return nil, errors.WithMessage(ErrDMARCRecord, "missing v=DMARC1")
+	}
+	tags := parseTXTFields(txt)
+	d := &DMARCRecord{
+		Policy:         strings.ToLower(tags["p"]),
+		Percent:        100,
+		ADKIM:          "r",
+		ASPF:           "r",
+		FailureOptions: "0",
+		ReportInterval: 86400 * time.Second,
+	}
+	if v := tags["rua"]; v != "" {
+		d.RUA = splitURIList(v)
+	}
+	if v := tags["ruf"]; v != "" {
+		d.RUF = splitURIList(v)
+	}
+	if !validDMARCPolicy(d.Policy) {
+		// 缺少 p= 但有 rua 时按 none 处理 (RFC 7489 6.6.3)
+		if d.Policy != "" || len(d.RUA) == 0 {
+			return nil, errors.WithMessage(ErrDMARCRecord, "bad policy "+d.Policy)
+		}
+		d.Policy = "none"
+	}
+	d.SubdomainPolicy = d.Policy
+	if v, ok := tags["sp"]; ok {
+		if d.SubdomainPolicy = strings.ToLower(v); !validDMARCPolicy(d.SubdomainPolicy) {
+			return nil, errors.WithMessage(ErrDMARCRecord, "bad subdomain policy "+v)
+		}
+	}
+	if v, ok := tags["pct"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			return nil, errors.WithMessage(ErrDMARCRecord, "bad pct "+v)
+		}
+		d.Percent = n
+	}
+	for tag, field := range map[string]*string{"adkim": &d.ADKIM, "aspf": &d.ASPF} {
+		if v, ok := tags[tag]; ok {
+			if v != "r" && v != "s" {
+				return nil, errors.WithMessage(ErrDMARCRecord, "bad "+tag+" "+v)
+			}
+			*field = v
+		}
+	}
+	if v, ok := tags["fo"]; ok {
+		d.FailureOptions = v
+	}
+	if v, ok := tags["ri"]; ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, errors.WithMessage(ErrDMARCRecord, "bad ri "+v)
+		}
+		d.ReportInterval = time.Duration(n) * time.Second
+	}
+	return d, nil
+}
+
+// splitURIList 按逗号分隔报告地址, 保留 "!10m" 等大小限制后缀
+func splitURIList(v string) []string {
+	var list []string
+	for _, uri := range strings.Split(v, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			list = append(list, uri)
+		}
+	}
+	return list
+}
+
+// LookupDMARC 查询 _dmarc.<domain>, 没有记录时回退到组织域名 (RegistrableDomain) 的记录 (RFC 7489 6.6.3)
+func (r *Resolver) LookupDMARC(ctx context.Context, domain string) (*DMARCRecord, error) {
+	if err := r.NameProfile.Check(domain); err != nil {
+		return nil, err
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	record, err := r.lookupDMARC(ctx, domain)
+	if errors.Cause(err) != ErrNoDMARC {
+		return record, err
+	}
+	org, e := RegistrableDomain(domain)
+	if e != nil || strings.EqualFold(org, domain) {
+		return nil, err
+	}
+	return r.lookupDMARC(ctx, org)
+}
+
+func (r *Resolver) lookupDMARC(ctx context.Context, domain string) (*DMARCRecord, error) {
+	name := "_dmarc." + domain
+	txts, err := r.lookupTXT(ctx, name)
+	if err != nil {
+		if isNXDomain(err) {
+			return nil, errors.WithMessage(ErrNoDMARC, domain)
+		}
+		return nil, err
+	}
+	txt, err := uniqueRecord(name, txts, "v=DMARC1")
+	if err != nil {
+		return nil, err
+	}
+	if txt == "" {
+		return nil, errors.WithMessage(ErrNoDMARC, domain)
+	}
+	return ParseDMARC(txt)
+}
@@ -0,0 +1,55 @@
+package netx
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestDKIMAndDMARC(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := zoneResolver(map[string][]*DNSResourceRecode{
+		"s1._domainkey.example.com":  {txtRR("v=DKIM1; k=ed25519; t=y; p=" + base64.StdEncoding.EncodeToString(pub))},
+		"old._domainkey.example.com": {txtRR("v=DKIM1; p=")},
+		"_dmarc.example.com":         {txtRR("v=DMARC1; p=reject; sp=quarantine; pct=50; aspf=s; rua=mailto:a@example.com, mailto:b@example.net!10m")},
+	})
+
+	key, err := r.LookupDKIMKey(context.Background(), "s1", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.PublicKey.(ed25519.PublicKey); !ok || !k.Equal(pub) || !key.Testing() {
+		t.Fatalf("key = %+v", key)
+	}
+	key, err = r.LookupDKIMKey(context.Background(), "old", "example.com")
+	if err != nil || !key.Revoked() {
+		t.Fatalf("key = %+v, err = %v", key, err)
+	}
+
+	d, err := r.LookupDMARC(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Policy != "reject" || d.SubdomainPolicy != "quarantine" || d.Percent != 50 || d.ASPF != "s" || d.ADKIM != "r" {
+		t.Fatalf("dmarc = %+v", d)
+	}
+	if len(d.RUA) != 2 || d.RUA[1] != "mailto:b@example.net!10m" || d.ReportInterval != 24*time.Hour {
+		t.Fatalf("dmarc = %+v", d)
+	}
+	// 子域名没有记录时使用组织域名的记录
+	if d, err := r.LookupDMARC(context.Background(), "mail.example.com"); err != nil || d.Policy != "reject" {
+		t.Fatalf("dmarc = %+v, err = %v", d, err)
+	}
+	if _, err := r.LookupDMARC(context.Background(), "example.org"); err == nil {
+		t.Fatal("expected no dmarc")
+	}
+	if _, err := ParseDMARC("v=DMARC1; p=block"); err == nil {
+		t.Fatal("bad policy accepted")
+	}
+}
@@ -0,0 +1,72 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+)
+
+// MDNSAddr mDNS 的 IPv4 组播地址, RFC 6762
+const MDNSAddr = "224.0.0.251:5353"
+
+// MDNSTransport 以一次性查询 (RFC 6762 5.1) 通过组播 DNS 发送请求: 从随机端口发出,
+// 应答方单播回复, 返回第一个 TxID 匹配的响应. server 为空时使用 MDNSAddr
+type MDNSTransport struct {
+	// Interface 发送组播使用的网卡, 为空时由系统选择
+	Interface *net.Interface
+}
+
+func (t MDNSTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	if server == "" {
+		server = MDNSAddr
+	}
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "resolve mdns address error")
+	}
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := ListenMulticast(network, ":0")
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen error")
+	}
+	defer conn.Close()
+	if t.Interface != nil {
+		if err := conn.SetMulticastInterface(t.Interface); err != nil {
+			return nil, err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithMessage(err, "set deadline error")
+		}
+	}
+
+	toByte, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(toByte, addr); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, errors.WithMessage(err, "read error")
+		}
+		resp, err := UnpackPooled(buf[:n])
+		if err != nil {
+			continue
+		}
+		// 多个应答方时可能收到迟到的旧响应
+		if resp.Header.TxID != req.Header.TxID || resp.Header.Flags.QR != 1 {
+			resp.Release()
+			continue
+		}
+		return resp, nil
+	}
+}
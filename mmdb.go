@@ -0,0 +1,367 @@
+package netx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker 元数据前的标记, 位于文件末尾 128KiB 之内
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	mmdbMetadataSearch = 128 << 10
+	mmdbMaxDepth       = 64
+)
+
+var (
+	ErrMMDBFormat   = errors.New("invalid mmdb file")
+	ErrGeoNotFound  = errors.New("address not found in geo database")
+	ErrMMDBIPFamily = errors.New("ipv6 lookup in ipv4-only mmdb")
+)
+
+// MMDBMetadata MaxMind DB 元数据中常用的字段
+type MMDBMetadata struct {
+	NodeCount    uint32
+	RecordSize   uint16 // 24, 28 或 32
+	IPVersion    uint16 // 4 或 6
+	DatabaseType string
+	Languages    []string
+	BuildEpoch   uint64
+}
+
+// MMDB MaxMind DB 格式 (GeoIP2/GeoLite2 等) 的只读数据库, 整个文件读入内存, 可以并发查询
+type MMDB struct {
+	meta      MMDBMetadata
+	tree      []byte
+	data      []byte
+	nodeBytes int
+	ipv4Start uint32 // ipv6 库中 ::/96 对应的节点
+	ipv4Depth int
+}
+
+// OpenMMDB 读取 path 处的 .mmdb 文件
+func OpenMMDB(path string) (*MMDB, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDB(b)
+}
+
+// NewMMDB 解析内存中的 .mmdb 数据, b 在之后不应被修改
+func NewMMDB(b []byte) (*MMDB, error) {
+	search := b
+	if len(search) > mmdbMetadataSearch {
+		search = search[len(search)-mmdbMetadataSearch:]
+	}
+	i := bytes.LastIndex(search, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.WithMessage(ErrMMDBFormat, "metadata not found")
+	}
+	metaStart := len(b) - len(search) + i + len(mmdbMetadataMarker)
+	metaDecoder := &mmdbDecoder{data: b[metaStart:]}
+	v, _, err := metaDecoder.decode(0, 0)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode metadata")
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.WithMessage(ErrMMDBFormat, "metadata is not a map")
+	}
+	m := &MMDB{}
+	m.meta.NodeCount = uint32(mmdbUint(meta["node_count"]))
+	m.meta.RecordSize = uint16(mmdbUint(meta["record_size"]))
+	m.meta.IPVersion = uint16(mmdbUint(meta["ip_version"]))
+	m.meta.BuildEpoch = mmdbUint(meta["build_epoch"])
+	m.meta.DatabaseType, _ = meta["database_type"].(string)
+	if languages, ok := meta["languages"].([]interface{}); ok {
+		for _, l := range languages {
+			if s, ok := l.(string); ok {
+				m.meta.Languages = append(m.meta.Languages, s)
+			}
+		}
+	}
+	switch m.meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, errors.WithMessage(ErrMMDBFormat, "unsupported record size")
+	}
+	if m.meta.IPVersion != 4 && m.meta.IPVersion != 6 {
+		return nil, errors.WithMessage(ErrMMDBFormat, "unsupported ip version")
+	}
+	m.nodeBytes = int(m.meta.RecordSize) / 4
+	treeSize := int(m.meta.NodeCount) * m.nodeBytes
+	// 搜索树与数据区之间有 16 字节的 0
+	dataStart := treeSize + 16
+	if dataStart > metaStart-len(mmdbMetadataMarker) {
+		return nil, errors.WithMessage(ErrMMDBFormat, "search tree exceeds file")
+	}
+	m.tree = b[:treeSize]
+	m.data = b[dataStart : metaStart-len(mmdbMetadataMarker)]
+	if m.meta.IPVersion == 6 {
+		for m.ipv4Depth = 0; m.ipv4Depth < 96 && m.ipv4Start < m.meta.NodeCount; m.ipv4Depth++ {
+			m.ipv4Start = m.record(m.ipv4Start, 0)
+		}
+	}
+	return m, nil
+}
+
+// Metadata 返回数据库的元数据
+func (m *MMDB) Metadata() MMDBMetadata {
+	return m.meta
+}
+
+// record 读取节点的左 (bit=0) 或右 (bit=1) 记录
+func (m *MMDB) record(node uint32, bit int) uint32 {
+	b := m.tree[int(node)*m.nodeBytes:]
+	switch m.meta.RecordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xF0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0F)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	}
+	return binary.BigEndian.Uint32(b[bit*4:])
+}
+
+// Lookup 返回 ip 对应的数据以及数据所属网络, 没有数据时返回 ErrGeoNotFound
+func (m *MMDB) Lookup(ip net.IP) (interface{}, *net.IPNet, error) {
+	v4 := ip.To4()
+	addr, node, depth := ip.To16(), uint32(0), 0
+	if addr == nil {
+		return nil, nil, ErrInvalidIP
+	}
+	switch {
+	case v4 != nil && m.meta.IPVersion == 6:
+		addr, node, depth = v4, m.ipv4Start, 0
+	case v4 != nil:
+		addr = v4
+	case m.meta.IPVersion == 4:
+		return nil, nil, ErrMMDBIPFamily
+	}
+	bitLen := len(addr) * 8
+	for ; depth < bitLen && node < m.meta.NodeCount; depth++ {
+		bit := int(addr[depth/8]>>(7-uint(depth%8))) & 1
+		node = m.record(node, bit)
+	}
+	if node < m.meta.NodeCount {
+		return nil, nil, errors.WithMessage(ErrMMDBFormat, "search tree too deep")
+	}
+	// 从 ::/96 开始时前缀长度不包括前 96 位
+	ones := depth
+	if v4 != nil && m.meta.IPVersion == 6 && m.ipv4Start >= m.meta.NodeCount {
+		ones = 0
+	}
+	network := &net.IPNet{IP: addr.Mask(net.CIDRMask(ones, bitLen)), Mask: net.CIDRMask(ones, bitLen)}
+	if node == m.meta.NodeCount {
+		return nil, network, ErrGeoNotFound
+	}
+	offset := int(node-m.meta.NodeCount) - 16
+	if offset < 0 || offset >= len(m.data) {
+		return nil, nil, errors.WithMessage(ErrMMDBFormat, "bad data pointer")
+	}
+	v, _, err := (&mmdbDecoder{data: m.data}).decode(offset, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, network, nil
+}
+
+// LookupGeo 从 GeoIP2/GeoLite2 City, Country 或 ASN 库中读取位置信息
+func (m *MMDB) LookupGeo(ip net.IP) (*GeoInfo, error) {
+	v, network, err := m.Lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := v.(map[string]interface{})
+	info := &GeoInfo{Network: network}
+	info.Country = mmdbString(record, "country", "iso_code")
+	if info.Country == "" {
+		info.Country = mmdbString(record, "registered_country", "iso_code")
+	}
+	info.Continent = mmdbString(record, "continent", "code")
+	if location, ok := record["location"].(map[string]interface{}); ok {
+		info.Latitude, _ = location["latitude"].(float64)
+		info.Longitude, _ = location["longitude"].(float64)
+	}
+	info.ASN = uint32(mmdbUint(record["autonomous_system_number"]))
+	return info, nil
+}
+
+func mmdbString(record map[string]interface{}, keys ...string) string {
+	var v interface{} = record
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func mmdbUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
+
+// mmdbDecoder 解码数据区, 偏移与指针都相对于 data 起始
+type mmdbDecoder struct {
+	data []byte
+}
+
+func (d *mmdbDecoder) next(off, n int) ([]byte, int, error) {
+	if n < 0 || off+n > len(d.data) {
+		return nil, 0, errors.WithMessage(ErrMMDBFormat, "data section truncated")
+	}
+	return d.data[off : off+n], off + n, nil
+}
+
+// decode 解码 off 处的值, 返回值与之后的偏移
+func (d *mmdbDecoder) decode(off, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.WithMessage(ErrMMDBFormat, "data nested too deep")
+	}
+	b, off, err := d.next(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	typ := int(ctrl >> 5)
+	if typ == 1 {
+		target, off, err := d.pointer(ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(target, depth+1)
+		return v, off, err
+	}
+	if typ == 0 {
+		if b, off, err = d.next(off, 1); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(b[0])
+	}
+	size := int(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if b, off, err = d.next(off, n); err != nil {
+			return nil, 0, err
+		}
+		extra := 0
+		for _, c := range b {
+			extra = extra<<8 | int(c)
+		}
+		size = [...]int{29, 285, 65821}[n-1] + extra
+	}
+
+	switch typ {
+	case 2:
+		b, off, err := d.next(off, size)
+		return string(b), off, err
+	case 3:
+		if size != 8 {
+			return nil, 0, errors.WithMessage(ErrMMDBFormat, "bad double size")
+		}
+		b, off, err := d.next(off, 8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 4:
+		b, off, err := d.next(off, size)
+		return append([]byte{}, b...), off, err
+	case 5, 6, 9:
+		if size > 8 {
+			return nil, 0, errors.WithMessage(ErrMMDBFormat, "bad integer size")
+		}
+		b, off, err := d.next(off, size)
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, err
+	case 8:
+		if size > 4 {
+			return nil, 0, errors.WithMessage(ErrMMDBFormat, "bad integer size")
+		}
+		b, off, err := d.next(off, size)
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int32(n), off, err
+	case 10:
+		b, off, err := d.next(off, size)
+		return new(big.Int).SetBytes(b), off, err
+	case 7:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.WithMessage(ErrMMDBFormat, "map key is not a string")
+			}
+			if m[key], off, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, off, nil
+	case 11:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			v, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, v), next
+		}
+		return a, off, nil
+	case 14:
+		return size != 0, off, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errors.WithMessage(ErrMMDBFormat, "bad float size")
+		}
+		b, off, err := d.next(off, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), off, nil
+	}
+	return nil, 0, errors.WithMessage(ErrMMDBFormat, "unknown data type")
+}
+
+func (d *mmdbDecoder) pointer(ctrl byte, off int) (int, int, error) {
+	n := int(ctrl>>3)&0x3 + 1
+	b, off, err := d.next(off, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	v := int(ctrl & 0x7)
+	if n == 4 {
+		v = 0
+	}
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	v += [...]int{0, 2048, 526336, 0}[n-1]
+	return v, off, nil
+}
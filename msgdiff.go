@@ -0,0 +1,201 @@
+package netx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Copy 返回 m 的深拷贝
+func (m *DNSMessage) Copy() *DNSMessage {
+	c := &DNSMessage{}
+	if m.Header != nil {
+		h := *m.Header
+		if h.Flags != nil {
+			flags := *h.Flags
+			h.Flags = &flags
+		}
+		c.Header = &h
+	}
+	for _, q := range m.Questions {
+		qc := *q
+		c.Questions = append(c.Questions, &qc)
+	}
+	for _, rr := range m.ResourceRecodes {
+		c.ResourceRecodes = append(c.ResourceRecodes, rr.Copy())
+	}
+	return c
+}
+
+// Copy 返回 r 的深拷贝
+func (r *DNSResourceRecode) Copy() *DNSResourceRecode {
+	c := *r
+	if r.Data != nil {
+		c.Data = append([]byte{}, r.Data...)
+	}
+	return &c
+}
+
+// Equal 比较两个消息的头部, 问题与全部记录是否相同. RDLength 随编码变化, 不参与比较
+func (m *DNSMessage) Equal(o *DNSMessage) bool {
+	if m == nil || o == nil {
+		return m == o
+	}
+	if (m.Header == nil) != (o.Header == nil) {
+		return false
+	}
+	if m.Header != nil {
+		a, b := *m.Header, *o.Header
+		if (a.Flags == nil) != (b.Flags == nil) || a.Flags != nil && *a.Flags != *b.Flags {
+			return false
+		}
+		a.Flags, b.Flags = nil, nil
+		if a != b {
+			return false
+		}
+	}
+	if len(m.Questions) != len(o.Questions) || len(m.ResourceRecodes) != len(o.ResourceRecodes) {
+		return false
+	}
+	for i, q := range m.Questions {
+		if *q != *o.Questions[i] {
+			return false
+		}
+	}
+	for i, r := range m.ResourceRecodes {
+		if !r.Equal(o.ResourceRecodes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal 比较除 RDLength 以外的所有字段
+func (r *DNSResourceRecode) Equal(o *DNSResourceRecode) bool {
+	return r.Name == o.Name && r.NamePos == o.NamePos && r.RRType == o.RRType && r.Class == o.Class &&
+		r.TTL == o.TTL && r.RData == o.RData && (r.Data == nil) == (o.Data == nil) && string(r.Data) == string(o.Data)
+}
+
+// RecordDiff 一条记录的变化, Old 为空表示新增, New 为空表示删除, 都不为空时只有 TTL 不同
+type RecordDiff struct {
+	Section DNSSection
+	Old     *DNSResourceRecode
+	New     *DNSResourceRecode
+}
+
+// MessageDiff 两个响应之间的差异
+type MessageDiff struct {
+	Header  []string // 不同的头部字段, 如 "RCode 0 -> 3", 不比较 TxID 与计数
+	Records []RecordDiff
+}
+
+// Empty 两个响应的内容相同
+func (d *MessageDiff) Empty() bool {
+	return len(d.Header) == 0 && len(d.Records) == 0
+}
+
+// String 每行一处差异, 记录以 "+", "-", "~" 开头
+func (d *MessageDiff) String() string {
+	var sb strings.Builder
+	for _, h := range d.Header {
+		sb.WriteString("header " + h + "\n")
+	}
+	for _, r := range d.Records {
+		switch {
+		case r.Old == nil:
+			sb.WriteString("+ " + recordString(r.New) + "\n")
+		case r.New == nil:
+			sb.WriteString("- " + recordString(r.Old) + "\n")
+		default:
+			sb.WriteString("~ " + recordString(r.New) + " (ttl " + strconv.FormatUint(uint64(r.Old.TTL), 10) + ")\n")
+		}
+	}
+	return sb.String()
+}
+
+// Diff 比较 a 与 b 的头部标志, 问题与各部分的记录. 记录按名字 (不区分大小写), 类型, 类别与 RDATA 对应,
+// 与顺序无关; 只有 TTL 不同的记录视为修改. 适合比较不同服务器或不同时间的响应
+func Diff(a, b *DNSMessage) *MessageDiff {
+	d := &MessageDiff{}
+	fa, fb := *a.Header.Flags, *b.Header.Flags
+	for _, f := range []struct {
+		name string
+		a, b uint16
+	}{
+		{"QR", fa.QR, fb.QR}, {"OpCode", fa.OpCode, fb.OpCode}, {"AA", fa.AA, fb.AA}, {"TC", fa.TC, fb.TC},
+		{"RD", fa.RD, fb.RD}, {"RA", fa.RA, fb.RA}, {"Z", fa.Z, fb.Z}, {"RCode", fa.RCode, fb.RCode},
+	} {
+		if f.a != f.b {
+			d.Header = append(d.Header, fmt.Sprintf("%s %d -> %d", f.name, f.a, f.b))
+		}
+	}
+	if questionsString(a.Questions) != questionsString(b.Questions) {
+		d.Header = append(d.Header, fmt.Sprintf("Questions %s -> %s", questionsString(a.Questions), questionsString(b.Questions)))
+	}
+	sections := func(m *DNSMessage) [][]*DNSResourceRecode {
+		return [][]*DNSResourceRecode{m.Answers(), m.Authorities(), m.Additionals()}
+	}
+	sa, sb := sections(a), sections(b)
+	for i := range sa {
+		d.Records = append(d.Records, diffSection(DNSSectionAnswer+DNSSection(i), sa[i], sb[i])...)
+	}
+	return d
+}
+
+func diffSection(section DNSSection, old, new []*DNSResourceRecode) []RecordDiff {
+	remaining := map[string][]*DNSResourceRecode{}
+	for _, r := range old {
+		k := recordKey(r)
+		remaining[k] = append(remaining[k], r)
+	}
+	var diffs, added []RecordDiff
+	for _, r := range new {
+		k := recordKey(r)
+		if rs := remaining[k]; len(rs) > 0 {
+			remaining[k] = rs[1:]
+			if rs[0].TTL != r.TTL {
+				diffs = append(diffs, RecordDiff{Section: section, Old: rs[0], New: r})
+			}
+			continue
+		}
+		added = append(added, RecordDiff{Section: section, New: r})
+	}
+	// 删除的记录按原来的顺序列出
+	for _, r := range old {
+		k := recordKey(r)
+		for i, left := range remaining[k] {
+			if left == r {
+				diffs = append(diffs, RecordDiff{Section: section, Old: r})
+				remaining[k] = append(remaining[k][:i:i], remaining[k][i+1:]...)
+				break
+			}
+		}
+	}
+	return append(diffs, added...)
+}
+
+// recordKey 除 TTL 以外用于对应记录的字段
+func recordKey(r *DNSResourceRecode) string {
+	return strings.ToLower(r.Name) + "\x00" + strconv.Itoa(int(r.RRType)) + "\x00" + strconv.Itoa(int(r.Class)) + "\x00" + recordRData(r)
+}
+
+// recordRData 展示格式的 RDATA, 原始数据按 RFC 3597 写作 \# 长度 十六进制
+func recordRData(r *DNSResourceRecode) string {
+	if r.Data != nil {
+		return `\# ` + strconv.Itoa(len(r.Data)) + " " + hex.EncodeToString(r.Data)
+	}
+	return r.RData
+}
+
+func recordString(r *DNSResourceRecode) string {
+	return fmt.Sprintf("%s %d %s %s", rootName(r.Name), r.TTL, DNSType(r.RRType).String(), recordRData(r))
+}
+
+func questionsString(qs []*DNSQuestion) string {
+	var names []string
+	for _, q := range qs {
+		names = append(names, rootName(q.QuestionName)+" "+DNSType(q.QuestionType).String())
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
@@ -0,0 +1,62 @@
+package netx
+
+import (
+	"testing"
+)
+
+func TestMessageEqualDiff(t *testing.T) {
+	a := benchMessage()
+	a.ResourceRecodes[0].Name, a.ResourceRecodes[0].NamePos = "www.example.com", 0
+	b := a.Copy()
+	if !a.Equal(b) || !Diff(a, b).Empty() {
+		t.Fatal("copy differs from original")
+	}
+	b.ResourceRecodes[1].RDLength = 4
+	if !a.Equal(b) {
+		t.Fatal("RDLength should not affect Equal")
+	}
+	b.Header.Flags.AA = 1
+	if a.Equal(b) || a.Header.Flags.AA != 0 {
+		t.Fatal("Copy shares flags with original")
+	}
+
+	// 调换顺序, 修改 TTL, 替换一个地址, 修改 rcode
+	b = a.Copy()
+	b.ResourceRecodes[1], b.ResourceRecodes[2] = b.ResourceRecodes[2], b.ResourceRecodes[1]
+	b.ResourceRecodes[0].TTL = 30
+	b.ResourceRecodes[1].RData = "192.0.2.9"
+	b.ResourceRecodes[4].Name = "EXAMPLE.com"
+	b.Header.Flags.RCode = 3
+	if a.Equal(b) {
+		t.Fatal("Equal ignored changes")
+	}
+	d := Diff(a, b)
+	if len(d.Header) != 1 || d.Header[0] != "RCode 0 -> 3" {
+		t.Fatalf("header diff = %v", d.Header)
+	}
+	if len(d.Records) != 3 {
+		t.Fatalf("record diff:\n%s", d)
+	}
+	changed, removed, added := d.Records[0], d.Records[1], d.Records[2]
+	if changed.Old.TTL != 300 || changed.New.TTL != 30 || changed.Section != DNSSectionAnswer {
+		t.Fatalf("changed = %+v", changed)
+	}
+	if removed.New != nil || removed.Old.RData != "192.0.2.2" {
+		t.Fatalf("removed = %+v", removed)
+	}
+	if added.Old != nil || added.New.RData != "192.0.2.9" {
+		t.Fatalf("added = %+v", added)
+	}
+	want := "header RCode 0 -> 3\n" +
+		"~ www.example.com 30 CNAME web.example.com (ttl 300)\n" +
+		"- web.example.com 60 A 192.0.2.2\n" +
+		"+ web.example.com 60 A 192.0.2.9\n"
+	if d.String() != want {
+		t.Fatalf("String =\n%s", d)
+	}
+
+	raw := &DNSResourceRecode{Name: "x", RRType: 65280, Data: []byte{1, 2}}
+	if recordString(raw) != `x 0 TYPE65280 \# 2 0102` {
+		t.Fatalf("raw record = %s", recordString(raw))
+	}
+}
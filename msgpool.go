@@ -0,0 +1,72 @@
+package netx
+
+import (
+	"sync"
+)
+
+// maxPooledRecords 记录数超过该值的消息不放回对象池, 以免池中长期持有大对象
+const maxPooledRecords = 256
+
+var (
+	messagePool  = sync.Pool{New: func() interface{} { return &DNSMessage{Header: &DNSHeader{Flags: &DNSFlags{}}} }}
+	questionPool = sync.Pool{New: func() interface{} { return &DNSQuestion{} }}
+	recordPool   = sync.Pool{New: func() interface{} { return &DNSResourceRecode{} }}
+	bufferPool   = sync.Pool{New: func() interface{} { return make([]byte, 65535) }}
+)
+
+// UnpackPooled 与 Unpack 相同, 但消息, 问题与记录取自对象池. 使用完后调用 Release 放回,
+// 不调用时由垃圾回收处理. 需要在 Release 之后继续使用其中的内容时先 CopyToOwn
+func UnpackPooled(data []byte) (*DNSMessage, error) {
+	m, _, err := (*UnpackOptions)(nil).UnpackPooled(data)
+	return m, err
+}
+
+func getQuestion() *DNSQuestion {
+	return questionPool.Get().(*DNSQuestion)
+}
+
+func getRecord() *DNSResourceRecode {
+	return recordPool.Get().(*DNSResourceRecode)
+}
+
+// Release 将 UnpackPooled 返回的消息及其问题与记录放回对象池, 之后不能再使用 m 与其中的任何指针.
+// 对其它方式构造的消息不做任何事, 因此总是可以安全调用
+func (m *DNSMessage) Release() {
+	if m == nil || !m.pooled {
+		return
+	}
+	m.pooled = false
+	for _, q := range m.Questions {
+		*q = DNSQuestion{}
+		questionPool.Put(q)
+	}
+	for _, rr := range m.ResourceRecodes {
+		*rr = DNSResourceRecode{Data: rr.Data[:0]}
+		recordPool.Put(rr)
+	}
+	if cap(m.Questions) > maxPooledRecords || cap(m.ResourceRecodes) > maxPooledRecords || m.Header == nil || m.Header.Flags == nil {
+		return
+	}
+	flags := m.Header.Flags
+	*flags = DNSFlags{}
+	*m.Header = DNSHeader{Flags: flags}
+	m.Questions = m.Questions[:0]
+	m.ResourceRecodes = m.ResourceRecodes[:0]
+	messagePool.Put(m)
+}
+
+// CopyToOwn 返回 m 的深拷贝, 不属于对象池, 在 m Release 之后仍然可以使用
+func (m *DNSMessage) CopyToOwn() *DNSMessage {
+	return m.Copy()
+}
+
+// getBuffer 取出长度为 65535 的读缓冲区
+func getBuffer() []byte {
+	return bufferPool.Get().([]byte)
+}
+
+func putBuffer(b []byte) {
+	if cap(b) >= 65535 {
+		bufferPool.Put(b[:65535])
+	}
+}
@@ -0,0 +1,57 @@
+package netx
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnpackPooled(t *testing.T) {
+	msg := NewReply(NewQuery("www.example.com", DNSTypeA))
+	msg.ResourceRecodes = []*DNSResourceRecode{
+		{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"},
+		{Name: "www.example.com", RRType: DNSTypeTXT, Class: DNSClassIn, TTL: 60, Data: []byte("\x05hello")},
+		{Name: "", RRType: DNSTypeOPT, Class: 1232, Data: []byte{}},
+	}
+	msg.Header.AnswerRRs, msg.Header.AdditionalRRs = 2, 1
+	packet, err := msg.ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Unpack(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON, _ := json.Marshal(want)
+
+	var owned *DNSMessage
+	for i := 0; i < 3; i++ {
+		got, err := UnpackPooled(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotJSON, _ := json.Marshal(got)
+		if string(gotJSON) != string(wantJSON) {
+			t.Fatalf("pooled = %s, want %s", gotJSON, wantJSON)
+		}
+		if got.ResourceRecodes[2].Data == nil {
+			t.Fatal("empty rdata decoded as nil")
+		}
+		if owned == nil {
+			owned = got.CopyToOwn()
+		}
+		got.Release()
+		got.Release()
+	}
+	// 放回对象池的记录被复用后, 拷贝不受影响
+	if _, err := UnpackPooled([]byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatal("short message accepted")
+	}
+	ownedJSON, _ := json.Marshal(owned)
+	if string(ownedJSON) != string(wantJSON) {
+		t.Fatalf("owned = %s", ownedJSON)
+	}
+	want.Release()
+	if want.Header == nil || len(want.ResourceRecodes) != 3 {
+		t.Fatal("Release changed an unpooled message")
+	}
+}
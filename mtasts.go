@@ -0,0 +1,290 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxMTASTSPolicyBytes = 64 << 10
+	maxMTASTSMaxAge      = 31557600
+)
+
+var (
+	ErrNoMTASTS        = errors.New("no mta-sts record")
+	ErrNoTLSRPT        = errors.New("no tlsrpt record")
+	ErrMTASTSRecord    = errors.New("invalid mta-sts record")
+	ErrMTASTSPolicy    = errors.New("invalid mta-sts policy")
+	ErrMTASTSFetch     = errors.New("fetch mta-sts policy failed")
+	ErrTLSRPTRecord    = errors.New("invalid tlsrpt record")
+	ErrMultipleRecords = errors.New("multiple policy records")
+)
+
+// MTASTSPolicy RFC 8461 策略文件
+type MTASTSPolicy struct {
+	ID      string // 来自 _mta-sts TXT 记录, 变化时需要重新获取
+	Mode    string // enforce, testing 或 none
+	MX      []string
+	MaxAge  time.Duration
+	Fetched time.Time
+}
+
+// Expired 策略在 now 时是否已过期
+func (p *MTASTSPolicy) Expired(now time.Time) bool {
+	return now.After(p.Fetched.Add(p.MaxAge))
+}
+
+// MatchMX mx 主机名是否被策略允许, "*.example.com" 只匹配一级子域名
+func (p *MTASTSPolicy) MatchMX(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.MX {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if strings.HasPrefix(pattern, "*.") {
+			i := strings.IndexByte(host, '.')
+			if i > 0 && host[i+1:] == pattern[2:] {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMTASTSPolicy 解析策略文件内容, 不填充 ID 与 Fetched
+func ParseMTASTSPolicy(r io.Reader) (*MTASTSPolicy, error) {
+	p := &MTASTSPolicy{}
+	var version string
+	maxAge := -1
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, errors.WithMessage(ErrMTASTSPolicy, line)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "version":
+			version = value
+		case "mode":
+			p.Mode = value
+		case "mx":
+			p.MX = append(p.MX, value)
+		case "max_age":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, errors.WithMessage(ErrMTASTSPolicy, "bad max_age "+value)
+			}
+			if n > maxMTASTSMaxAge {
+				n = maxMTASTSMaxAge
+			}
+			maxAge = n
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if version != "STSv1" {
+		return nil, errors.WithMessage(ErrMTASTSPolicy, "bad version "+version)
+	}
+	switch p.Mode {
+	case "enforce", "testing":
+		if len(p.MX) == 0 {
+			return nil, errors.WithMessage(ErrMTASTSPolicy, "missing mx")
+		}
+	case "none":
+	default:
+		return nil, errors.WithMessage(ErrMTASTSPolicy, "bad mode "+p.Mode)
+	}
+	if maxAge < 0 {
+		return nil, errors.WithMessage(ErrMTASTSPolicy, "missing max_age")
+	}
+	p.MaxAge = time.Duration(maxAge) * time.Second
+	return p, nil
+}
+
+// TLSRPTRecord RFC 8460 _smtp._tls TXT 记录
+type TLSRPTRecord struct {
+	RUA []string // mailto: 或 https: 报告地址
+}
+
+// parseTXTFields 解析 "k=v; k=v" 格式的记录
+func parseTXTFields(txt string) map[string]string {
+	fields := map[string]string{}
+	for _, part := range strings.Split(txt, ";") {
+		part = strings.TrimSpace(part)
+		if i := strings.IndexByte(part, '='); i > 0 {
+			fields[strings.TrimSpace(part[:i])] = strings.TrimSpace(part[i+1:])
+		}
+	}
+	return fields
+}
+
+// MTASTSClient 发现并缓存 MTA-STS 策略, 可以并发使用
+type MTASTSClient struct {
+	// Resolver 为空时使用系统解析
+	Resolver *Resolver
+	// HTTPClient 为空时使用 http.DefaultClient, 获取策略时总是不跟随重定向
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	policies map[string]*MTASTSPolicy
+}
+
+func (c *MTASTSClient) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	if c.Resolver != nil {
+		return c.Resolver.LookupTXT(ctx, name)
+	}
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+// findRecord 返回 name 下以 version 开头的唯一一条 TXT 记录
+func (c *MTASTSClient) findRecord(ctx context.Context, name, version string) (string, error) {
+	txts, err := c.lookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if isNXDomain(err) || errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return uniqueRecord(name, txts, version)
+}
+
+// uniqueRecord 从 txts 中选出以 version 标签开头的记录, 没有时返回空字符串, 多于一条时返回错误
+func uniqueRecord(name string, txts []string, version string) (string, error) {
+	var found []string
+	for _, txt := range txts {
+		if hasVersionTag(txt, version) {
+			found = append(found, txt)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+	return "", errors.WithMessage(ErrMultipleRecords, name)
+}
+
+// hasVersionTag txt 是否以 version 开头且其后为空格, 分号或结尾, 不区分大小写
+func hasVersionTag(txt, version string) bool {
+	if len(txt) < len(version) || !strings.EqualFold(txt[:len(version)], version) {
+		return false
+	}
+	return len(txt) == len(version) || txt[len(version)] == ' ' || txt[len(version)] == ';'
+}
+
+// Policy 返回 domain 的 MTA-STS 策略. TXT 记录中的 id 未变化且缓存未过期时使用缓存,
+// 查询 TXT 记录失败时也使用未过期的缓存. 没有策略时返回 ErrNoMTASTS
+func (c *MTASTSClient) Policy(ctx context.Context, domain string) (*MTASTSPolicy, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	c.mu.Lock()
+	cached := c.policies[domain]
+	c.mu.Unlock()
+	if cached != nil && cached.Expired(time.Now()) {
+		cached = nil
+	}
+
+	txt, err := c.findRecord(ctx, "_mta-sts."+domain, "v=STSv1")
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	if txt == "" {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, errors.WithMessage(ErrNoMTASTS, domain)
+	}
+	id := parseTXTFields(txt)["id"]
+	if id == "" {
+		return nil, errors.WithMessage(ErrMTASTSRecord, txt)
+	}
+	if cached != nil && cached.ID == id {
+		return cached, nil
+	}
+
+	policy, err := c.fetch(ctx, domain)
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	policy.ID = id
+	policy.Fetched = time.Now()
+	c.mu.Lock()
+	if c.policies == nil {
+		c.policies = map[string]*MTASTSPolicy{}
+	}
+	c.policies[domain] = policy
+	c.mu.Unlock()
+	return policy, nil
+}
+
+// fetch 获取 https://mta-sts.<domain>/.well-known/mta-sts.txt
+func (c *MTASTSClient) fetch(ctx context.Context, domain string) (*MTASTSPolicy, error) {
+	hc := http.Client{}
+	if c.HTTPClient != nil {
+		hc = *c.HTTPClient
+	}
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://mta-sts."+domain+"/.well-known/mta-sts.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithMessage(ErrMTASTSFetch, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(ErrMTASTSFetch, resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/plain" {
+		return nil, errors.WithMessage(ErrMTASTSFetch, "content type "+mediaType)
+	}
+	return ParseMTASTSPolicy(io.LimitReader(resp.Body, maxMTASTSPolicyBytes))
+}
+
+// TLSRPT 查询 domain 的 TLS 报告策略
+func (c *MTASTSClient) TLSRPT(ctx context.Context, domain string) (*TLSRPTRecord, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	txt, err := c.findRecord(ctx, "_smtp._tls."+domain, "v=TLSRPTv1")
+	if err != nil {
+		return nil, err
+	}
+	if txt == "" {
+		return nil, errors.WithMessage(ErrNoTLSRPT, domain)
+	}
+	rua := parseTXTFields(txt)["rua"]
+	if rua == "" {
+		return nil, errors.WithMessage(ErrTLSRPTRecord, txt)
+	}
+	record := &TLSRPTRecord{}
+	for _, uri := range strings.Split(rua, ",") {
+		record.RUA = append(record.RUA, strings.TrimSpace(uri))
+	}
+	return record, nil
+}
@@ -0,0 +1,88 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMTASTSClient(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "mta-sts.example.com" || r.URL.Path != "/.well-known/mta-sts.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 86400\r\n"))
+	}))
+	defer srv.Close()
+	hc := srv.Client()
+	transport := hc.Transport.(*http.Transport)
+	transport.TLSClientConfig = &tls.Config{RootCAs: transport.TLSClientConfig.RootCAs, ServerName: "example.com"}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+
+	records := map[string]string{
+		"_mta-sts.example.com":   "v=STSv1; id=20240101000000Z;",
+		"_smtp._tls.example.com": "v=TLSRPTv1; rua=mailto:tls@example.com, https://report.example.com/v1",
+	}
+	resolver := &Resolver{
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp := &DNSMessage{Header: &DNSHeader{TxID: req.Header.TxID, Flags: &DNSFlags{QR: 1}}}
+			txt, ok := records[req.Questions[0].QuestionName]
+			if !ok {
+				resp.Header.Flags.RCode = 3
+				return resp, nil
+			}
+			resp.Header.AnswerRRs = 1
+			resp.ResourceRecodes = []*DNSResourceRecode{{
+				RRType: DNSTypeTXT,
+				Class:  DNSClassIn,
+				Data:   append([]byte{byte(len(txt))}, txt...),
+			}}
+			return resp, nil
+		}),
+	}
+	c := &MTASTSClient{Resolver: resolver, HTTPClient: hc}
+
+	for i := 0; i < 2; i++ {
+		policy, err := c.Policy(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy.Mode != "enforce" || policy.ID != "20240101000000Z" || policy.MaxAge.Hours() != 24 {
+			t.Fatalf("policy = %+v", policy)
+		}
+		if !policy.MatchMX("mail.example.com.") || !policy.MatchMX("mx1.example.net") || policy.MatchMX("a.b.example.net") {
+			t.Fatal("mx matching")
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("fetches = %d, want 1", fetches)
+	}
+	// id 变化后重新获取
+	records["_mta-sts.example.com"] = "v=STSv1; id=20240102000000Z"
+	if _, err := c.Policy(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Fatalf("fetches = %d, want 2", fetches)
+	}
+
+	rpt, err := c.TLSRPT(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rpt.RUA) != 2 || rpt.RUA[1] != "https://report.example.com/v1" {
+		t.Fatalf("rua = %v", rpt.RUA)
+	}
+	if _, err := c.Policy(context.Background(), "example.org"); err == nil {
+		t.Fatal("expected error for domain without policy")
+	}
+}
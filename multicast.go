@@ -0,0 +1,113 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+)
+
+var ErrGroupFamily = errors.New("group address family does not match conn")
+
+// MulticastConn 屏蔽 ipv4 与 ipv6 在组播控制上的差异
+type MulticastConn struct {
+	net.PacketConn
+
+	p4 *ipv4.PacketConn
+	p6 *ipv6.PacketConn
+}
+
+// ListenMulticast 监听 address 并返回可加入组播组的连接, network 为 udp4 或 udp6
+func ListenMulticast(network, address string) (*MulticastConn, error) {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewMulticastConn(conn), nil
+}
+
+// NewMulticastConn 根据 conn 的本地地址判断地址族, 双栈监听 (如 "udp" ":5353") 会被视为 ipv6
+func NewMulticastConn(conn net.PacketConn) *MulticastConn {
+	m := &MulticastConn{PacketConn: conn}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil && len(addr.IP) == net.IPv6len {
+		m.p6 = ipv6.NewPacketConn(conn)
+		return m
+	}
+	m.p4 = ipv4.NewPacketConn(conn)
+	return m
+}
+
+// IsIPv6 连接是否为 ipv6
+func (m *MulticastConn) IsIPv6() bool {
+	return m.p6 != nil
+}
+
+func (m *MulticastConn) checkGroup(group net.IP) error {
+	if (group.To4() != nil) == m.IsIPv6() {
+		return ErrGroupFamily
+	}
+	return nil
+}
+
+// JoinGroup 在 ifi 上加入 group, ifi 为 nil 时由系统选择网卡
+func (m *MulticastConn) JoinGroup(ifi *net.Interface, group net.IP) error {
+	if err := m.checkGroup(group); err != nil {
+		return err
+	}
+	addr := &net.UDPAddr{IP: group}
+	if m.p6 != nil {
+		return errors.WithMessage(m.p6.JoinGroup(ifi, addr), "join group error")
+	}
+	return errors.WithMessage(m.p4.JoinGroup(ifi, addr), "join group error")
+}
+
+// LeaveGroup 在 ifi 上离开 group
+func (m *MulticastConn) LeaveGroup(ifi *net.Interface, group net.IP) error {
+	if err := m.checkGroup(group); err != nil {
+		return err
+	}
+	addr := &net.UDPAddr{IP: group}
+	if m.p6 != nil {
+		return errors.WithMessage(m.p6.LeaveGroup(ifi, addr), "leave group error")
+	}
+	return errors.WithMessage(m.p4.LeaveGroup(ifi, addr), "leave group error")
+}
+
+// SetMulticastTTL 设置组播报文的 TTL (ipv4) 或 hop limit (ipv6)
+func (m *MulticastConn) SetMulticastTTL(ttl int) error {
+	if m.p6 != nil {
+		return m.p6.SetMulticastHopLimit(ttl)
+	}
+	return m.p4.SetMulticastTTL(ttl)
+}
+
+// SetMulticastLoopback 设置本机发出的组播报文是否回环给本机
+func (m *MulticastConn) SetMulticastLoopback(on bool) error {
+	if m.p6 != nil {
+		return m.p6.SetMulticastLoopback(on)
+	}
+	return m.p4.SetMulticastLoopback(on)
+}
+
+// SetMulticastInterface 设置发送组播报文使用的网卡
+func (m *MulticastConn) SetMulticastInterface(ifi *net.Interface) error {
+	if m.p6 != nil {
+		return m.p6.SetMulticastInterface(ifi)
+	}
+	return m.p4.SetMulticastInterface(ifi)
+}
+
+// MulticastInterfaces 返回已启用且支持组播的网卡
+func MulticastInterfaces() ([]net.Interface, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var result []net.Interface
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 {
+			result = append(result, ifi)
+		}
+	}
+	return result, nil
+}
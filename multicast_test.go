@@ -0,0 +1,53 @@
+package netx
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMulticastConnFamily(t *testing.T) {
+	v4Group, v6Group := net.ParseIP("224.0.0.251"), net.ParseIP("ff02::fb")
+
+	m, err := ListenMulticast("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.IsIPv6() {
+		t.Fatal("udp4 conn reported as ipv6")
+	}
+	if err := m.checkGroup(v4Group); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.JoinGroup(nil, v6Group); err != ErrGroupFamily {
+		t.Fatalf("join ipv6 group on udp4: %v", err)
+	}
+	if err := m.LeaveGroup(nil, v6Group); err != ErrGroupFamily {
+		t.Fatalf("leave ipv6 group on udp4: %v", err)
+	}
+	if err := m.SetMulticastTTL(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetMulticastLoopback(true); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 not available:", err)
+	}
+	m6 := NewMulticastConn(conn)
+	defer m6.Close()
+	if !m6.IsIPv6() {
+		t.Fatal("udp6 conn reported as ipv4")
+	}
+	if err := m6.checkGroup(v6Group); err != nil {
+		t.Fatal(err)
+	}
+	// 16 字节 (IPv4 映射) 与 4 字节的 ipv4 组都被拒绝
+	for _, group := range []net.IP{v4Group, v4Group.To4()} {
+		if err := m6.JoinGroup(nil, group); err != ErrGroupFamily {
+			t.Fatalf("join %v on udp6: %v", group, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxLabelLength = 63
+	maxNameLength  = 255
+)
+
+var (
+	ErrLabelTooLong = errors.New("label exceeds 63 bytes")
+	ErrNameTooLong  = errors.New("name exceeds 255 bytes")
+	ErrEmptyLabel   = errors.New("empty label")
+	ErrBadEscape    = errors.New("bad escape sequence")
+)
+
+// splitName 将展示格式的域名拆分为 label, 支持 \. \\ 和 \DDD 转义.
+// "" 与 "." 都表示根域名
+func splitName(name string) ([][]byte, error) {
+	if name == "" || name == "." {
+		return nil, nil
+	}
+	var labels [][]byte
+	var label []byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch c {
+		case '.':
+			if len(label) == 0 {
+				return nil, ErrEmptyLabel
+			}
+			labels = append(labels, label)
+			label = nil
+		case '\\':
+			if i+1 >= len(name) {
+				return nil, ErrBadEscape
+			}
+			if isDigit(name[i+1]) {
+				if i+3 >= len(name) || !isDigit(name[i+2]) || !isDigit(name[i+3]) {
+					return nil, ErrBadEscape
+				}
+				v, _ := strconv.Atoi(name[i+1 : i+4])
+				if v > 255 {
+					return nil, ErrBadEscape
+				}
+				label = append(label, byte(v))
+				i += 3
+				continue
+			}
+			label = append(label, name[i+1])
+			i++
+		default:
+			label = append(label, c)
+		}
+	}
+	if len(label) > 0 {
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// appendName 将域名编码为未压缩的 wire 格式追加到 b. 没有转义时直接按 "." 切分, 不分配 label
+func appendName(b []byte, name string) ([]byte, error) {
+	if name == "" || name == "." {
+		return append(b, 0x00), nil
+	}
+	if strings.IndexByte(name, '\\') >= 0 {
+		labels, err := splitName(name)
+		if err != nil {
+			return nil, errors.WithMessage(err, name)
+		}
+		total := 1
+		for _, label := range labels {
+			if total, err = checkLabel(name, len(label), total); err != nil {
+				return nil, err
+			}
+			b = append(append(b, byte(len(label))), label...)
+		}
+		return append(b, 0x00), nil
+	}
+	total := 1
+	for rest := name; rest != ""; {
+		label := rest
+		if i := strings.IndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		if label == "" {
+			return nil, errors.WithMessage(ErrEmptyLabel, name)
+		}
+		var err error
+		if total, err = checkLabel(name, len(label), total); err != nil {
+			return nil, err
+		}
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0x00), nil
+}
+
+// checkLabel 检查 label 长度, 返回加上该 label 后的名字长度
+func checkLabel(name string, length, total int) (int, error) {
+	if length > maxLabelLength {
+		return 0, errors.WithMessage(ErrLabelTooLong, name)
+	}
+	total += length + 1
+	if total > maxNameLength {
+		return 0, errors.WithMessage(ErrNameTooLong, name)
+	}
+	return total, nil
+}
+
+// escapeLabel 将 wire 格式的 label 转换为展示格式
+func escapeLabel(sb *strings.Builder, label []byte) {
+	for _, c := range label {
+		switch {
+		case c == '.' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < '!' || c > '~':
+			sb.WriteByte('\\')
+			s := strconv.Itoa(int(c))
+			sb.WriteString(strings.Repeat("0", 3-len(s)))
+			sb.WriteString(s)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,128 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidName = errors.New("invalid domain name")
+
+// NormalizeName 将 name 转为小写并去掉末尾的点, 然后按主机名规则 (LDH, RFC 952/1123) 检查:
+// label 只能包含字母, 数字与连字符, 连字符不能在首尾, 长度不超过 63, 整个名字不超过 253.
+// allowService 为真时还允许以下划线开头的服务标签, 例如 _sip._tcp.example.com 与 _dmarc.example.com.
+// "" 与 "." 表示根. 不合法时返回的错误包含 ErrInvalidName 与具体原因
+func NormalizeName(name string, allowService bool) (string, error) {
+	normalized := normalizeDomain(name)
+	if err := checkHostname(normalized, allowService); err != nil {
+		return "", errors.WithMessage(err, strconv.Quote(name))
+	}
+	return normalized, nil
+}
+
+// checkHostname 检查已经去掉末尾点的名字
+func checkHostname(name string, allowService bool) error {
+	if name == "" {
+		return nil
+	}
+	// 展示格式的最大长度: 255 字节的 wire 格式去掉首个长度与结尾的 0
+	if len(name) > maxNameLength-2 {
+		return errors.WithMessage(ErrInvalidName, "name longer than 253 characters")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if err := checkHostLabel(label, allowService); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkHostLabel(label string, allowService bool) error {
+	switch {
+	case label == "":
+		return errors.WithMessage(ErrInvalidName, "empty label")
+	case len(label) > maxLabelLength:
+		return errors.WithMessage(ErrInvalidName, "label "+strconv.Quote(label)+" longer than 63 characters")
+	case label[0] == '-' || label[len(label)-1] == '-':
+		return errors.WithMessage(ErrInvalidName, "label "+strconv.Quote(label)+" starts or ends with a hyphen")
+	}
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-':
+		case c == '_' && i == 0 && allowService && len(label) > 1:
+		case c == '_':
+			return errors.WithMessage(ErrInvalidName, "label "+strconv.Quote(label)+" contains an underscore, allow service labels to query it")
+		case c == ' ' || c == '\t':
+			return errors.WithMessage(ErrInvalidName, "label "+strconv.Quote(label)+" contains whitespace")
+		case c >= 0x80:
+			return errors.WithMessage(ErrInvalidName, "label "+strconv.Quote(label)+" is not ascii, convert it to punycode first")
+		default:
+			return errors.WithMessage(ErrInvalidName, "label "+strconv.Quote(label)+" contains "+strconv.QuoteRune(rune(c)))
+		}
+	}
+	return nil
+}
+
+// WithQueryNormalization 在发送前用 NormalizeName 规范化并检查问题中的名字, 不合法时返回错误, 不发送任何报文.
+// 规范化之后的名字便于缓存与日志比较. SRV, TLSA 与 DKIM 等名字带有服务标签, 查询它们需要 allowService
+func WithQueryNormalization(allowService bool) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			for _, q := range req.Questions {
+				name, err := NormalizeName(q.QuestionName, allowService)
+				if err != nil {
+					return nil, err
+				}
+				q.QuestionName = name
+			}
+			return next.RoundTrip(ctx, server, req)
+		})
+	}
+}
+
+// NameProfile 名字的检查规则, 用于 Resolver 的 Lookup 系列方法与 DynamicZone 接受的更新
+type NameProfile int
+
+const (
+	// NameProfileRaw 任意 DNS 名字, 只检查转义, label 与名字的长度, 是默认值
+	NameProfileRaw NameProfile = iota
+	// NameProfileService 主机名, 另外允许以下划线开头的服务标签, 见 NormalizeName
+	NameProfileService
+	// NameProfileHostname 严格的主机名 (LDH) 规则
+	NameProfileHostname
+)
+
+var nameProfileNames = []string{"raw", "service", "hostname"}
+
+func (p NameProfile) String() string {
+	if p >= 0 && int(p) < len(nameProfileNames) {
+		return nameProfileNames[p]
+	}
+	return "NameProfile(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParseNameProfile 解析 raw, service 或 hostname
+func ParseNameProfile(s string) (NameProfile, error) {
+	for i, name := range nameProfileNames {
+		if strings.EqualFold(s, name) {
+			return NameProfile(i), nil
+		}
+	}
+	return 0, errors.WithMessage(ErrUnknownMnemonic, "name profile "+s)
+}
+
+// Check 按规则检查 name, 末尾的点与大小写不影响结果. 不合法时返回的错误包含 ErrInvalidName
+func (p NameProfile) Check(name string) error {
+	var err error
+	switch p {
+	case NameProfileService, NameProfileHostname:
+		err = checkHostname(normalizeDomain(name), p == NameProfileService)
+	default:
+		if _, e := appendName(nil, name); e != nil {
+			err = errors.WithMessage(ErrInvalidName, errors.Cause(e).Error())
+		}
+	}
+	return errors.WithMessage(err, strconv.Quote(name))
+}
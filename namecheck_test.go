@@ -0,0 +1,120 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	for _, c := range []struct {
+		name, want string
+		service    bool
+		reason     string
+	}{
+		{"WWW.Example.COM.", "www.example.com", false, ""},
+		{".", "", false, ""},
+		{"1.2.0.192.in-addr.arpa", "1.2.0.192.in-addr.arpa", false, ""},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", false, ""},
+		{"_sip._tcp.example.com", "_sip._tcp.example.com", true, ""},
+		{"_sip._tcp.example.com", "", false, "underscore"},
+		{"my host.example", "", false, "whitespace"},
+		{"-bad.example", "", false, "hyphen"},
+		{"bad-.example", "", false, "hyphen"},
+		{"a..example", "", false, "empty label"},
+		{"bücher.example", "", false, "punycode"},
+		{"a_b.example", "", true, "underscore"},
+		{"_.example", "", true, "underscore"},
+		{"a\\.b.example", "", false, `'\\'`},
+		{strings.Repeat("a", 64) + ".example", "", false, "longer than 63"},
+		{strings.Repeat("abcdefghi.", 26), "", false, "longer than 253"},
+	} {
+		got, err := NormalizeName(c.name, c.service)
+		if c.reason == "" {
+			if err != nil || got != c.want {
+				t.Fatalf("NormalizeName(%q) = %q, %v", c.name, got, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidName) || !strings.Contains(err.Error(), c.reason) {
+			t.Fatalf("NormalizeName(%q) error = %v, want %s", c.name, err, c.reason)
+		}
+	}
+}
+
+func TestWithQueryNormalization(t *testing.T) {
+	var sent []string
+	r := &Resolver{
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			sent = append(sent, req.Questions[0].QuestionName)
+			return NewReply(req), nil
+		}),
+		Interceptors: []Interceptor{WithQueryNormalization(false)},
+	}
+	if _, err := r.LookupHost(context.Background(), "WWW.Example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupHost(context.Background(), "bad name.example"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("invalid name: %v", err)
+	}
+	if _, err := r.LookupSRV(context.Background(), "sip", "tcp", "example.com"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("service name without allowService: %v", err)
+	}
+	r.Interceptors = []Interceptor{WithQueryNormalization(true)}
+	if _, err := r.LookupSRV(context.Background(), "sip", "tcp", "Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0] != "www.example.com" || sent[1] != "_sip._tcp.example.com" {
+		t.Fatalf("sent %v", sent)
+	}
+}
+
+func TestNameProfile(t *testing.T) {
+	for _, c := range []struct {
+		name                   string
+		raw, service, hostname bool
+	}{
+		{"www.example.com.", true, true, true},
+		{"_dmarc.example.com", true, true, false},
+		{"my_host.example", true, false, false},
+		{"a b.example", true, false, false},
+		{"a..example", false, false, false},
+		{strings.Repeat("a", 64) + ".example", false, false, false},
+	} {
+		for p, ok := range map[NameProfile]bool{NameProfileRaw: c.raw, NameProfileService: c.service, NameProfileHostname: c.hostname} {
+			if err := p.Check(c.name); (err == nil) != ok || err != nil && !errors.Is(err, ErrInvalidName) {
+				t.Fatalf("%s.Check(%q) = %v", p, c.name, err)
+			}
+		}
+	}
+	if p, err := ParseNameProfile("Hostname"); err != nil || p != NameProfileHostname || p.String() != "hostname" {
+		t.Fatalf("ParseNameProfile = %v, %v", p, err)
+	}
+	if _, err := ParseNameProfile("strict"); err == nil {
+		t.Fatal("unknown profile parsed")
+	}
+
+	var sent []string
+	r := &Resolver{
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			sent = append(sent, req.Questions[0].QuestionName)
+			return NewReply(req), nil
+		}),
+		NameProfile: NameProfileHostname,
+	}
+	ctx := context.Background()
+	if _, err := r.LookupMX(ctx, "mail_server.example"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("LookupMX: %v", err)
+	}
+	// 方法自己加上的服务标签不受限制
+	if _, err := r.LookupSRV(ctx, "sip", "tcp", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupDMARC(ctx, "example.com"); err == nil || errors.Is(err, ErrInvalidName) {
+		t.Fatalf("LookupDMARC: %v", err)
+	}
+	if len(sent) == 0 || sent[0] != "_sip._tcp.example.com" || sent[1] != "_dmarc.example.com" {
+		t.Fatalf("sent %v", sent)
+	}
+}
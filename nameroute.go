@@ -0,0 +1,165 @@
+package netx
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RouteAction 特殊用途域名的处理方式
+type RouteAction int
+
+const (
+	// RouteForward 发给 NameRoute.Server, Server 为空时仍发给原来的服务器, 用于在更短的后缀被拦截时放行
+	RouteForward RouteAction = iota
+	// RouteMDNS 通过组播 DNS 在本地链路上查询
+	RouteMDNS
+	// RouteNXDomain 不发出查询, 直接应答 NXDOMAIN
+	RouteNXDomain
+)
+
+// NameRoute 一个后缀及其下所有名字的解析方式
+type NameRoute struct {
+	Suffix string
+	Action RouteAction
+	// Server RouteForward 与 RouteMDNS 的目标地址, RouteMDNS 为空时使用 MDNSAddr
+	Server string
+	// Transport 为空时 RouteForward 使用原来的 Transport, RouteMDNS 使用 MDNSTransport
+	Transport RoundTripper
+}
+
+// DefaultNameRoutes 不应发给公共解析器的特殊用途域名: .local 走 mDNS (RFC 6762),
+// .onion (RFC 7686), .invalid (RFC 6761) 与 home.arpa (RFC 8375) 直接应答 NXDOMAIN.
+// 家庭网络中有本地解析器时可以把 home.arpa 改为 RouteForward 到它
+func DefaultNameRoutes() []NameRoute {
+	return []NameRoute{
+		{Suffix: "local", Action: RouteMDNS},
+		{Suffix: "onion", Action: RouteNXDomain},
+		{Suffix: "invalid", Action: RouteNXDomain},
+		{Suffix: "home.arpa", Action: RouteNXDomain},
+	}
+}
+
+// RouteNames 按问题名字最长匹配的后缀选择解析方式, 没有匹配的名字交给原来的 Transport.
+// 需要在运行时修改规则时使用 RouteTable
+func RouteNames(routes ...NameRoute) Interceptor {
+	return NewRouteTable(routes...).Interceptor()
+}
+
+// RouteTable 按后缀把名字分配给不同上游的路由表, 可以在查询进行时修改.
+// Suffix 可以写成 "*.corp" 或 "corp", 为 "." 时匹配所有名字, 作为默认路由
+type RouteTable struct {
+	mu    sync.RWMutex
+	index map[string]NameRoute
+}
+
+func NewRouteTable(routes ...NameRoute) *RouteTable {
+	t := &RouteTable{}
+	t.Replace(routes...)
+	return t
+}
+
+func routeKey(suffix string) string {
+	return strings.ToLower(strings.Trim(strings.TrimPrefix(suffix, "*."), "."))
+}
+
+// Set 添加或替换一条规则
+func (t *RouteTable) Set(route NameRoute) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.index == nil {
+		t.index = map[string]NameRoute{}
+	}
+	t.index[routeKey(route.Suffix)] = route
+}
+
+// Delete 删除后缀的规则
+func (t *RouteTable) Delete(suffix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.index, routeKey(suffix))
+}
+
+// Replace 一次替换所有规则, 查询不会看到新旧规则的混合
+func (t *RouteTable) Replace(routes ...NameRoute) {
+	index := make(map[string]NameRoute, len(routes))
+	for _, r := range routes {
+		index[routeKey(r.Suffix)] = r
+	}
+	t.mu.Lock()
+	t.index = index
+	t.mu.Unlock()
+}
+
+// Routes 按后缀排序返回当前的规则
+func (t *RouteTable) Routes() []NameRoute {
+	t.mu.RLock()
+	routes := make([]NameRoute, 0, len(t.index))
+	for _, r := range t.index {
+		routes = append(routes, r)
+	}
+	t.mu.RUnlock()
+	sort.Slice(routes, func(i, j int) bool { return routeKey(routes[i].Suffix) < routeKey(routes[j].Suffix) })
+	return routes
+}
+
+// Match 返回 name 最长匹配的规则
+func (t *RouteTable) Match(name string) (NameRoute, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return matchNameRoute(t.index, name)
+}
+
+// Interceptor 按当前的规则分配查询, 没有匹配的名字交给原来的 Transport
+func (t *RouteTable) Interceptor() Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			if len(req.Questions) == 0 {
+				return next.RoundTrip(ctx, server, req)
+			}
+			route, ok := t.Match(req.Questions[0].QuestionName)
+			if !ok {
+				return next.RoundTrip(ctx, server, req)
+			}
+			switch route.Action {
+			case RouteMDNS:
+				var rt RoundTripper = MDNSTransport{}
+				if route.Transport != nil {
+					rt = route.Transport
+				}
+				return rt.RoundTrip(ctx, route.Server, req)
+			case RouteNXDomain:
+				resp := NewReply(req)
+				resp.Header.Flags.RA = 1
+				resp.Header.Flags.RCode = DNSRCodeNXDomain
+				return resp, nil
+			}
+			rt := next
+			if route.Transport != nil {
+				rt = route.Transport
+			}
+			if route.Server != "" {
+				server = route.Server
+			}
+			return rt.RoundTrip(ctx, server, req)
+		})
+	}
+}
+
+// matchNameRoute 从 name 本身开始逐级去掉标签查找后缀, 最后查找默认路由
+func matchNameRoute(index map[string]NameRoute, name string) (NameRoute, bool) {
+	name = strings.ToLower(strings.Trim(name, "."))
+	for name != "" {
+		if r, ok := index[name]; ok {
+			return r, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	r, ok := index[""]
+	return r, ok
+}
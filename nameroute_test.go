@@ -0,0 +1,90 @@
+package netx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRouteNames(t *testing.T) {
+	answer := func(rdata string) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp := NewReply(req.Message)
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Message.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: rdata}}
+			resp.Header.AnswerRRs = 1
+			return resp, nil
+		})
+	}
+	public, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.1")})
+	mdns, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.2")})
+	home, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.3")})
+
+	routes := append(DefaultNameRoutes(), NameRoute{Suffix: "printer.home.arpa.", Action: RouteForward, Server: home})
+	routes[0].Server = mdns // .local 发给模拟的 mDNS 应答方
+	r := &Resolver{Server: public, Interceptors: []Interceptor{RouteNames(routes...)}}
+	for name, want := range map[string]string{
+		"www.example.com":        "192.0.2.1",
+		"host.LOCAL.":            "192.0.2.2",
+		"printer.home.arpa":      "192.0.2.3",
+		"ink.printer.home.arpa":  "192.0.2.3",
+		"nas.home.arpa":          "",
+		"xyz.onion":              "",
+		"localonion.example.com": "192.0.2.1",
+	} {
+		addrs, err := r.LookupHost(context.Background(), name)
+		if want == "" {
+			if rerr, ok := err.(*RCodeError); !ok || rerr.RCode != DNSRCodeNXDomain {
+				t.Fatalf("%s: err = %v", name, err)
+			}
+			continue
+		}
+		if err != nil || len(addrs) != 1 || addrs[0] != want {
+			t.Fatalf("%s = %v, %v, want %s", name, addrs, err, want)
+		}
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	answer := func(rdata string) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp := NewReply(req.Message)
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Message.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: rdata}}
+			resp.Header.AnswerRRs = 1
+			return resp, nil
+		})
+	}
+	system, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.1")})
+	corp, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.2")})
+	local, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.3")})
+	def, _ := startDNSServer(t, &DNSServer{Handler: answer("192.0.2.4")})
+
+	table := NewRouteTable(
+		NameRoute{Suffix: "*.corp", Server: corp},
+		NameRoute{Suffix: "cn", Server: local},
+	)
+	r := &Resolver{Server: system, Interceptors: []Interceptor{table.Interceptor()}}
+	check := func(name, want string) {
+		t.Helper()
+		addrs, err := r.LookupHost(context.Background(), name)
+		if err != nil || len(addrs) != 1 || addrs[0] != want {
+			t.Fatalf("%s = %v, %v, want %s", name, addrs, err, want)
+		}
+	}
+	check("git.CORP", "192.0.2.2")
+	check("www.example.cn", "192.0.2.3")
+	check("www.example.com", "192.0.2.1")
+
+	table.Set(NameRoute{Suffix: ".", Server: def})
+	table.Set(NameRoute{Suffix: "public.corp", Server: def})
+	check("www.example.com", "192.0.2.4")
+	check("www.public.corp", "192.0.2.4")
+	check("git.corp", "192.0.2.2")
+
+	table.Delete("cn")
+	check("www.example.cn", "192.0.2.4")
+	if routes := table.Routes(); len(routes) != 3 || routes[0].Suffix != "." || routes[1].Suffix != "*.corp" {
+		t.Fatalf("Routes = %+v", routes)
+	}
+
+	table.Replace()
+	check("git.corp", "192.0.2.1")
+}
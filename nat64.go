@@ -0,0 +1,72 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+// DiscoverNAT64Prefix 按 RFC 7050 查询 ipv4only.arpa 的 AAAA 记录得到网络的 NAT64 前缀,
+// 可能有多个, 第一个优先. 网络没有 DNS64 时返回 ErrNoNAT64
+func (r *Resolver) DiscoverNAT64Prefix(ctx context.Context) ([]netip.Prefix, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return discoverNAT64(ctx, r.transport(), r.Server)
+}
+
+// NAT64Addr 把 IPv4 地址映射到 prefix 下 (RFC 6052), 得到可以在只有 IPv6 的网络中连接的地址
+func NAT64Addr(prefix netip.Prefix, v4 netip.Addr) (netip.Addr, error) {
+	return embedIPv4(prefix, v4)
+}
+
+// NAT64ToIPv4 从 prefix 下的 IPv6 地址中取出原始的 IPv4 地址, addr 不在 prefix 内时返回 false
+func NAT64ToIPv4(prefix netip.Prefix, addr netip.Addr) (netip.Addr, bool) {
+	if !validNAT64Prefix(prefix) || !addr.Is6() || !prefix.Masked().Contains(addr) {
+		return netip.Addr{}, false
+	}
+	return extractIPv4(prefix.Bits(), addr), true
+}
+
+// NAT64HostPort 把 host:port 中的 IPv4 字面量映射到 prefix 下, 其它地址原样返回.
+// 相当于 464XLAT 中 CLAT 对应用使用的 IPv4 字面量所做的转换
+func NAT64HostPort(prefix netip.Prefix, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	v4, err := netip.ParseAddr(host)
+	if err != nil || !v4.Unmap().Is4() {
+		return address, nil
+	}
+	v6, err := embedIPv4(prefix, v4)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(v6.String(), port), nil
+}
+
+// WithNAT64 连接 IPv4 字面量时改为连接 prefix 下的映射地址, 使只有 IPv6 的主机可以使用写死的 IPv4 地址.
+// 主机名不做处理, 由 DNS64 负责
+func WithNAT64(prefix netip.Prefix) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			mapped, err := NAT64HostPort(prefix, address)
+			if err != nil {
+				return nil, err
+			}
+			if mapped != address {
+				switch network {
+				case "tcp4":
+					network = "tcp6"
+				case "udp4":
+					network = "udp6"
+				}
+			}
+			return next.DialContext(ctx, network, mapped)
+		})
+	}
+}
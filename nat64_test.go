@@ -0,0 +1,72 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestDiscoverNAT64Prefix(t *testing.T) {
+	udpAddr, _ := startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		if req.Message.Questions[0].QuestionName == "ipv4only.arpa" {
+			resp.ResourceRecodes = []*DNSResourceRecode{
+				{Name: "ipv4only.arpa", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "2001:db8:c000:aa::"},
+				{Name: "ipv4only.arpa", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "2001:db8:c000:ab::"},
+				{Name: "ipv4only.arpa", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "64:ff9b::c000:aa"},
+			}
+			resp.Header.AnswerRRs = 3
+		}
+		return resp, nil
+	})})
+	prefixes, err := (&Resolver{Server: udpAddr}).DiscoverNAT64Prefix(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 || prefixes[0].String() != "2001:db8::/32" || prefixes[1] != WellKnownNAT64Prefix {
+		t.Fatalf("prefixes = %v", prefixes)
+	}
+
+	udpAddr, _ = startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		return NewReply(req.Message), nil
+	})})
+	if _, err := (&Resolver{Server: udpAddr}).DiscoverNAT64Prefix(context.Background()); err != ErrNoNAT64 {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestNAT64Helpers(t *testing.T) {
+	prefix := netip.MustParsePrefix("2001:db8:122::/48")
+	v6, err := NAT64Addr(prefix, netip.MustParseAddr("192.0.2.33"))
+	if err != nil || v6.String() != "2001:db8:122:c000:2:2100::" {
+		t.Fatalf("NAT64Addr = %s, %v", v6, err)
+	}
+	if v4, ok := NAT64ToIPv4(prefix, v6); !ok || v4.String() != "192.0.2.33" {
+		t.Fatalf("NAT64ToIPv4 = %s, %v", v4, ok)
+	}
+	if _, ok := NAT64ToIPv4(prefix, netip.MustParseAddr("2001:db8:123::1")); ok {
+		t.Fatal("address outside prefix accepted")
+	}
+	for in, want := range map[string]string{
+		"192.0.2.33:443":         "[2001:db8:122:c000:2:2100::]:443",
+		"example.com:443":        "example.com:443",
+		"[2001:db8::1]:443":      "[2001:db8::1]:443",
+		"[::ffff:192.0.2.33]:80": "[2001:db8:122:c000:2:2100::]:80",
+	} {
+		if got, err := NAT64HostPort(prefix, in); err != nil || got != want {
+			t.Fatalf("NAT64HostPort(%s) = %s, %v", in, got, err)
+		}
+	}
+
+	var dialed []string
+	d := WithNAT64(prefix)(DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		return nil, nil
+	}))
+	_, _ = d.DialContext(context.Background(), "tcp4", "192.0.2.33:80")
+	_, _ = d.DialContext(context.Background(), "tcp4", "example.com:80")
+	if len(dialed) != 2 || dialed[0] != "tcp6 [2001:db8:122:c000:2:2100::]:80" || dialed[1] != "tcp4 example.com:80" {
+		t.Fatalf("dialed = %v", dialed)
+	}
+}
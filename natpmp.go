@@ -0,0 +1,302 @@
+package netx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	natpmpPort       = 5351
+	natpmpVersion    = 0
+	pcpVersion       = 2
+	pcpOpAnnounce    = 0
+	pcpOpMap         = 1
+	pcpHeaderLen     = 24
+	pcpMapLen        = 36
+	defaultPMPLife   = 2 * time.Hour
+	pmpInitialWait   = 250 * time.Millisecond
+	pmpDefaultTries  = 4
+	pmpResultSuccess = 0
+)
+
+// natpmpOpcode NAT-PMP 与 PCP 的协议号, 0 表示不支持
+func natpmpOpcode(protocol string) (byte, byte) {
+	switch protocol {
+	case "udp":
+		return 1, 17
+	case "tcp":
+		return 2, 6
+	}
+	return 0, 0
+}
+
+// natpmpAddr 网关的 NAT-PMP/PCP 地址, port 为 0 时使用 5351
+func natpmpAddr(gateway net.IP, port int) string {
+	if port == 0 {
+		port = natpmpPort
+	}
+	return net.JoinHostPort(gateway.String(), strconv.Itoa(port))
+}
+
+// pmpExchange 向 gateway 的 port 端口发送 req, 按 RFC 6886 从 250ms 开始倍增等待时间重传
+func pmpExchange(ctx context.Context, gateway net.IP, port int, req []byte, tries int, accept func([]byte) bool) ([]byte, error) {
+	if gateway == nil {
+		return nil, ErrNoGateway
+	}
+	if tries <= 0 {
+		tries = pmpDefaultTries
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", natpmpAddr(gateway, port))
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial gateway error")
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1100)
+	wait := pmpInitialWait
+	for attempt := 0; attempt < tries; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, errors.WithMessage(err, "write gateway error")
+		}
+		deadline := time.Now().Add(wait)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if accept(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		wait *= 2
+	}
+	return nil, ErrNoPortMapper
+}
+
+// NATPMPClient RFC 6886 NAT-PMP 客户端
+type NATPMPClient struct {
+	Gateway net.IP
+	Port    int // 网关端口, 默认 5351
+	Retries int // 重传次数, 默认 4 (约 4s)
+}
+
+// ExternalIP 获取网关的外部地址
+func (c *NATPMPClient) ExternalIP(ctx context.Context) (net.IP, error) {
+	resp, err := pmpExchange(ctx, c.Gateway, c.Port, []byte{natpmpVersion, 0}, c.Retries, func(b []byte) bool {
+		return len(b) >= 12 && b[0] == natpmpVersion && b[1] == 128
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != pmpResultSuccess {
+		return nil, errors.WithMessage(ErrPortMapRefused, "nat-pmp result code "+strconv.Itoa(int(code)))
+	}
+	return net.IP(append([]byte(nil), resp[8:12]...)), nil
+}
+
+// AddMapping 创建或续期映射, Lifetime 为 0 时使用 2 小时
+func (c *NATPMPClient) AddMapping(ctx context.Context, m *PortMapping) (*PortMapping, error) {
+	lifetime := m.Lifetime
+	if lifetime <= 0 {
+		lifetime = defaultPMPLife
+	}
+	return c.mapping(ctx, m, lifetime)
+}
+
+// DeleteMapping 以生命周期 0 删除映射
+func (c *NATPMPClient) DeleteMapping(ctx context.Context, m *PortMapping) error {
+	_, err := c.mapping(ctx, m, 0)
+	return err
+}
+
+func (c *NATPMPClient) mapping(ctx context.Context, m *PortMapping, lifetime time.Duration) (*PortMapping, error) {
+	op, _ := natpmpOpcode(m.Protocol)
+	if op == 0 {
+		return nil, ErrPortMapProtocol
+	}
+	req := make([]byte, 12)
+	req[0], req[1] = natpmpVersion, op
+	binary.BigEndian.PutUint16(req[4:6], uint16(m.InternalPort))
+	if lifetime > 0 {
+		binary.BigEndian.PutUint16(req[6:8], uint16(m.ExternalPort))
+	}
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := pmpExchange(ctx, c.Gateway, c.Port, req, c.Retries, func(b []byte) bool {
+		return len(b) >= 16 && b[0] == natpmpVersion && b[1] == 128+op &&
+			binary.BigEndian.Uint16(b[8:10]) == uint16(m.InternalPort)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != pmpResultSuccess {
+		return nil, errors.WithMessage(ErrPortMapRefused, "nat-pmp result code "+strconv.Itoa(int(code)))
+	}
+	result := *m
+	result.ExternalPort = int(binary.BigEndian.Uint16(resp[10:12]))
+	result.Lifetime = time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second
+	result.Acquired = time.Now()
+	if lifetime > 0 && result.ExternalIP == nil {
+		// NAT-PMP 映射应答不包含外部地址
+		result.ExternalIP, _ = c.ExternalIP(ctx)
+	}
+	return &result, nil
+}
+
+// PCPClient RFC 6887 PCP 客户端, 只实现 MAP
+type PCPClient struct {
+	Gateway net.IP
+	Port    int // 网关端口, 默认 5351
+	Retries int // 重传次数, 默认 4 (约 4s)
+}
+
+// pcpRequest 生成 PCP 公共首部, client 为本机到网关使用的地址
+func pcpRequest(opcode byte, lifetime time.Duration, client net.IP, payload []byte) []byte {
+	b := make([]byte, pcpHeaderLen, pcpHeaderLen+len(payload))
+	b[0], b[1] = pcpVersion, opcode
+	binary.BigEndian.PutUint32(b[4:8], uint32(lifetime/time.Second))
+	copy(b[8:24], client.To16())
+	return append(b, payload...)
+}
+
+// localAddr 本机到网关使用的地址
+func (c *PCPClient) localAddr() (net.IP, error) {
+	if c.Gateway == nil {
+		return nil, ErrNoGateway
+	}
+	conn, err := net.Dial("udp", natpmpAddr(c.Gateway, c.Port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func pcpResult(b []byte) error {
+	if code := b[3]; code != pmpResultSuccess {
+		return errors.WithMessage(ErrPortMapRefused, "pcp result code "+strconv.Itoa(int(code)))
+	}
+	return nil
+}
+
+// Announce 发送 ANNOUNCE 检查网关是否支持 PCP
+func (c *PCPClient) Announce(ctx context.Context) error {
+	client, err := c.localAddr()
+	if err != nil {
+		return err
+	}
+	resp, err := pmpExchange(ctx, c.Gateway, c.Port, pcpRequest(pcpOpAnnounce, 0, client, nil), c.Retries, func(b []byte) bool {
+		// 只支持 NAT-PMP 的网关会以版本 0 回复 unsupported version
+		return len(b) >= 4 && b[1] == 0x80|pcpOpAnnounce
+	})
+	if err != nil {
+		return err
+	}
+	if resp[0] != pcpVersion || len(resp) < pcpHeaderLen {
+		return errors.WithMessage(ErrNoPortMapper, "gateway does not speak pcp")
+	}
+	return pcpResult(resp)
+}
+
+// ExternalIP PCP 没有单独的查询操作, 通过创建并立即删除一个临时 udp 映射获得外部地址
+func (c *PCPClient) ExternalIP(ctx context.Context) (net.IP, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	m, err := c.AddMapping(ctx, &PortMapping{
+		Protocol:     "udp",
+		InternalPort: conn.LocalAddr().(*net.UDPAddr).Port,
+		Lifetime:     time.Minute,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = c.DeleteMapping(ctx, m)
+	return m.ExternalIP, nil
+}
+
+// AddMapping 创建或续期映射, 续期时需要传入上次返回的 PortMapping
+func (c *PCPClient) AddMapping(ctx context.Context, m *PortMapping) (*PortMapping, error) {
+	lifetime := m.Lifetime
+	if lifetime <= 0 {
+		lifetime = defaultPMPLife
+	}
+	return c.mapping(ctx, m, lifetime)
+}
+
+// DeleteMapping 以生命周期 0 删除映射
+func (c *PCPClient) DeleteMapping(ctx context.Context, m *PortMapping) error {
+	_, err := c.mapping(ctx, m, 0)
+	return err
+}
+
+func (c *PCPClient) mapping(ctx context.Context, m *PortMapping, lifetime time.Duration) (*PortMapping, error) {
+	_, proto := natpmpOpcode(m.Protocol)
+	if proto == 0 {
+		return nil, ErrPortMapProtocol
+	}
+	client, err := c.localAddr()
+	if err != nil {
+		return nil, err
+	}
+	result := *m
+	if result.nonce == [12]byte{} {
+		_, _ = rand.Read(result.nonce[:])
+	}
+	payload := make([]byte, pcpMapLen)
+	copy(payload[0:12], result.nonce[:])
+	payload[12] = proto
+	binary.BigEndian.PutUint16(payload[16:18], uint16(m.InternalPort))
+	binary.BigEndian.PutUint16(payload[18:20], uint16(m.ExternalPort))
+	suggested := net.IPv4zero
+	if m.ExternalIP != nil {
+		suggested = m.ExternalIP
+	}
+	copy(payload[20:36], suggested.To16())
+
+	resp, err := pmpExchange(ctx, c.Gateway, c.Port, pcpRequest(pcpOpMap, lifetime, client, payload), c.Retries, func(b []byte) bool {
+		if len(b) >= 4 && b[0] == natpmpVersion {
+			// 网关不支持 PCP
+			return true
+		}
+		return len(b) >= pcpHeaderLen+pcpMapLen && b[0] == pcpVersion && b[1] == 0x80|pcpOpMap &&
+			string(b[pcpHeaderLen:pcpHeaderLen+12]) == string(result.nonce[:])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] != pcpVersion {
+		return nil, errors.WithMessage(ErrNoPortMapper, "gateway does not speak pcp")
+	}
+	if err := pcpResult(resp); err != nil {
+		return nil, err
+	}
+	body := resp[pcpHeaderLen:]
+	result.Lifetime = time.Duration(binary.BigEndian.Uint32(resp[4:8])) * time.Second
+	result.ExternalPort = int(binary.BigEndian.Uint16(body[18:20]))
+	result.ExternalIP = net.IP(append([]byte(nil), body[20:36]...))
+	if v4 := result.ExternalIP.To4(); v4 != nil {
+		result.ExternalIP = v4
+	}
+	result.Acquired = time.Now()
+	return &result, nil
+}
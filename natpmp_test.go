@@ -0,0 +1,157 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startPortMapGateway 在本机随机端口模拟网关的 5351 端口, 对每个请求返回 serve 的应答
+func startPortMapGateway(t *testing.T, serve func(req []byte) [][]byte) (net.IP, int) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			for _, resp := range serve(append([]byte(nil), buf[:n]...)) {
+				_, _ = conn.WriteToUDP(resp, src)
+			}
+		}
+	}()
+	return net.IPv4(127, 0, 0, 1), conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// natpmpResponse NAT-PMP 应答首部, body 为 epoch 之后的内容
+func natpmpResponse(op byte, code uint16, body ...byte) []byte {
+	b := []byte{natpmpVersion, 128 + op, byte(code >> 8), byte(code), 0, 0, 0, 1}
+	return append(b, body...)
+}
+
+// pcpMapResponse 对 PCP MAP 请求 req 的应答, nonce 与协议原样带回
+func pcpMapResponse(req []byte, code byte, lifetime uint32, externalPort uint16, externalIP net.IP) []byte {
+	b := make([]byte, pcpHeaderLen+pcpMapLen)
+	b[0], b[1], b[3] = pcpVersion, 0x80|req[1], code
+	binary.BigEndian.PutUint32(b[4:8], lifetime)
+	copy(b[pcpHeaderLen:], req[pcpHeaderLen:pcpHeaderLen+20])
+	binary.BigEndian.PutUint16(b[pcpHeaderLen+18:], externalPort)
+	copy(b[pcpHeaderLen+20:], externalIP.To16())
+	return b
+}
+
+func TestNATPMPClient(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]byte
+	gateway, port := startPortMapGateway(t, func(req []byte) [][]byte {
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		switch {
+		case len(req) == 2 && req[1] == 0:
+			return [][]byte{natpmpResponse(0, pmpResultSuccess, 203, 0, 113, 7)}
+		case len(req) == 12 && req[1] == 2 && binary.BigEndian.Uint16(req[4:6]) == 8080:
+			other := natpmpResponse(2, pmpResultSuccess, 0x1f, 0x91, 0x1f, 0x91, 0, 0, 0x0e, 0x10)
+			lifetime := req[8:12]
+			return [][]byte{other, natpmpResponse(2, pmpResultSuccess, 0x1f, 0x90, 0x23, 0x28, lifetime[0], lifetime[1], lifetime[2], lifetime[3])}
+		case len(req) == 12:
+			// 2 Not Authorized/Refused
+			return [][]byte{natpmpResponse(req[1], 2, req[4], req[5], 0, 0, 0, 0, 0, 0)}
+		}
+		return nil
+	})
+	c := &NATPMPClient{Gateway: gateway, Port: port, Retries: 1}
+
+	m, err := c.AddMapping(context.Background(), &PortMapping{Protocol: "tcp", InternalPort: 8080, ExternalPort: 9000, Lifetime: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ExternalPort != 9000 || m.Lifetime != time.Hour || !m.ExternalIP.Equal(net.IPv4(203, 0, 113, 7)) {
+		t.Fatalf("mapping %+v", m)
+	}
+	if err := c.DeleteMapping(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	del := requests[len(requests)-1]
+	mu.Unlock()
+	if binary.BigEndian.Uint16(del[6:8]) != 0 || binary.BigEndian.Uint32(del[8:12]) != 0 {
+		t.Fatalf("delete request % x", del)
+	}
+
+	if _, err := c.AddMapping(context.Background(), &PortMapping{Protocol: "udp", InternalPort: 53}); errors.Cause(err) != ErrPortMapRefused {
+		t.Fatalf("refused: %v", err)
+	}
+	if _, err := c.AddMapping(context.Background(), &PortMapping{Protocol: "sctp", InternalPort: 53}); err != ErrPortMapProtocol {
+		t.Fatalf("protocol: %v", err)
+	}
+}
+
+func TestPCPClient(t *testing.T) {
+	external := net.IPv4(203, 0, 113, 7)
+	var mu sync.Mutex
+	var requests [][]byte
+	gateway, port := startPortMapGateway(t, func(req []byte) [][]byte {
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		if req[0] != pcpVersion || req[1] != pcpOpMap || len(req) != pcpHeaderLen+pcpMapLen {
+			return nil
+		}
+		if binary.BigEndian.Uint16(req[pcpHeaderLen+16:]) == 53 {
+			// 8 NO_RESOURCES
+			return [][]byte{pcpMapResponse(req, 8, 0, 0, net.IPv4zero)}
+		}
+		// 先返回 nonce 不同的应答, 应当被忽略
+		stale := pcpMapResponse(req, pmpResultSuccess, 7200, 1, external)
+		stale[pcpHeaderLen] ^= 0xff
+		lifetime := binary.BigEndian.Uint32(req[4:8])
+		return [][]byte{stale, pcpMapResponse(req, pmpResultSuccess, lifetime, 9000, external)}
+	})
+	c := &PCPClient{Gateway: gateway, Port: port, Retries: 1}
+
+	m, err := c.AddMapping(context.Background(), &PortMapping{Protocol: "udp", InternalPort: 8080, Lifetime: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ExternalPort != 9000 || m.Lifetime != time.Hour || !m.ExternalIP.Equal(external) || m.ExternalIP.To4() == nil {
+		t.Fatalf("mapping %+v", m)
+	}
+	renewed, err := c.AddMapping(context.Background(), m)
+	if err != nil || renewed.nonce != m.nonce {
+		t.Fatalf("renew: %v %+v", err, renewed)
+	}
+	if err := c.DeleteMapping(context.Background(), renewed); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	first, del := requests[0], requests[len(requests)-1]
+	mu.Unlock()
+	if binary.BigEndian.Uint32(del[4:8]) != 0 || string(del[pcpHeaderLen:pcpHeaderLen+12]) != string(first[pcpHeaderLen:pcpHeaderLen+12]) ||
+		del[pcpHeaderLen+12] != 17 || !net.IP(del[8:24]).Equal(gateway) {
+		t.Fatalf("delete request % x", del)
+	}
+
+	if _, err := c.AddMapping(context.Background(), &PortMapping{Protocol: "udp", InternalPort: 53}); errors.Cause(err) != ErrPortMapRefused {
+		t.Fatalf("refused: %v", err)
+	}
+	// 只有 nonce 不同的应答时超时
+	gateway, port = startPortMapGateway(t, func(req []byte) [][]byte {
+		stale := pcpMapResponse(req, pmpResultSuccess, 7200, 9000, external)
+		stale[pcpHeaderLen] ^= 0xff
+		return [][]byte{stale}
+	})
+	c = &PCPClient{Gateway: gateway, Port: port, Retries: 1}
+	if _, err := c.AddMapping(context.Background(), &PortMapping{Protocol: "tcp", InternalPort: 8080}); err != ErrNoPortMapper {
+		t.Fatalf("nonce mismatch: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"net"
+	"time"
+)
+
+const ndOptTargetLinkAddr = 2
+
+var ErrNDPTimeout = errors.New("no neighbor advertisement")
+
+// solicitedNode 返回 ip 的 solicited-node 组播地址 ff02::1:ffXX:XXXX
+func solicitedNode(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+// NDPResolve 在 ifi 上发送邻居请求, 返回 ip 对应的 MAC 地址. 需要 raw socket 权限
+func NDPResolve(ctx context.Context, ifi *net.Interface, ip net.IP) (net.HardwareAddr, error) {
+	target := ip.To16()
+	if target == nil || ip.To4() != nil {
+		return nil, errors.WithMessage(ErrInvalidIP, ip.String())
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen icmpv6 error")
+	}
+	defer conn.Close()
+
+	p := conn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	if err := p.SetICMPFilter(&filter); err != nil {
+		return nil, err
+	}
+	if err := p.SetMulticastInterface(ifi); err != nil {
+		return nil, err
+	}
+	// 邻居发现报文的 hop limit 必须为 255
+	if err := p.SetMulticastHopLimit(255); err != nil {
+		return nil, err
+	}
+	if err := p.SetHopLimit(255); err != nil {
+		return nil, err
+	}
+
+	// reserved(4) + target(16) + source link-layer address option
+	body := make([]byte, 20, 28)
+	copy(body[4:20], target)
+	if len(ifi.HardwareAddr) == 6 {
+		body = append(body, ndOptSourceLinkAddr, 1)
+		body = append(body, ifi.HardwareAddr...)
+	}
+	msg := icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{Data: body}}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	dst := &net.IPAddr{IP: solicitedNode(target), Zone: ifi.Name}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			return nil, errors.WithMessage(err, "send neighbor solicitation error")
+		}
+		deadline := time.Now().Add(time.Second)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if mac := parseNeighborAdvertisement(buf[:n], target); mac != nil {
+				return mac, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrNDPTimeout
+}
+
+// parseNeighborAdvertisement 从针对 target 的邻居通告中取出目标链路层地址
+func parseNeighborAdvertisement(b []byte, target net.IP) net.HardwareAddr {
+	// type(1) code(1) checksum(2) flags(4) target(16)
+	if len(b) < 24 || b[0] != byte(ipv6.ICMPTypeNeighborAdvertisement) || !net.IP(b[8:24]).Equal(target) {
+		return nil
+	}
+	for opts := b[24:]; len(opts) >= 8; {
+		size := int(opts[1]) * 8
+		if size == 0 || size > len(opts) {
+			return nil
+		}
+		if opts[0] == ndOptTargetLinkAddr {
+			return net.HardwareAddr(append([]byte(nil), opts[2:size]...))
+		}
+		opts = opts[size:]
+	}
+	return nil
+}
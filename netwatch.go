@@ -0,0 +1,236 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"time"
+)
+
+// NetworkState 网卡地址与默认路由的快照, 用于判断网络是否变化
+type NetworkState struct {
+	// Addrs 启用的网卡上的地址, 格式为 "网卡名 地址/前缀", 已排序
+	Addrs []string
+	// Gateway ipv4 默认网关, 没有或无法获取时为空
+	Gateway net.IP
+}
+
+// Equal 两个快照是否相同
+func (s *NetworkState) Equal(o *NetworkState) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+	if len(s.Addrs) != len(o.Addrs) || !s.Gateway.Equal(o.Gateway) {
+		return false
+	}
+	for i := range s.Addrs {
+		if s.Addrs[i] != o.Addrs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CurrentNetworkState 读取当前的网卡地址与默认网关
+func CurrentNetworkState() (*NetworkState, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	s := &NetworkState{}
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			s.Addrs = append(s.Addrs, ifi.Name+" "+a.String())
+		}
+	}
+	sort.Strings(s.Addrs)
+	if gw, err := DefaultGateway(); err == nil {
+		s.Gateway = gw
+	}
+	return s, nil
+}
+
+// NetworkChange 一次网络变化
+type NetworkChange struct {
+	Time time.Time
+	Old  *NetworkState
+	New  *NetworkState
+}
+
+// Added 新出现的地址
+func (c *NetworkChange) Added() []string {
+	return stringsMissing(c.New.Addrs, c.Old.Addrs)
+}
+
+// Removed 消失的地址
+func (c *NetworkChange) Removed() []string {
+	return stringsMissing(c.Old.Addrs, c.New.Addrs)
+}
+
+// stringsMissing 返回 a 中不在 b 中的元素, 两者都已排序
+func stringsMissing(a, b []string) []string {
+	var missing []string
+	for _, s := range a {
+		if i := sort.SearchStrings(b, s); i == len(b) || b[i] != s {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// NetworkAction 网络变化后执行的动作
+type NetworkAction func(ctx context.Context, change *NetworkChange) error
+
+// FlushCacheAction 清空缓存, 旧网络上的应答 (例如内网地址或门户页面) 在新网络上可能无效
+func FlushCacheAction(c *DNSCache) NetworkAction {
+	return func(ctx context.Context, change *NetworkChange) error {
+		c.Flush()
+		return nil
+	}
+}
+
+// ResolvConfAction 重新读取 resolv.conf 格式的文件并在 s 上生效, 例如 DHCP 或 VPN 客户端更新了它
+func ResolvConfAction(path string, s *ProfileSwitcher) NetworkAction {
+	return func(ctx context.Context, change *NetworkChange) error {
+		p, err := LoadResolvConf(path)
+		if err != nil {
+			return err
+		}
+		s.Activate(p)
+		return nil
+	}
+}
+
+// HealthCheckAction 立即重新检查所有上游, 不等待下一个检查周期
+func HealthCheckAction(h *HealthChecker) NetworkAction {
+	return func(ctx context.Context, change *NetworkChange) error {
+		h.CheckNow(ctx)
+		return nil
+	}
+}
+
+// NetworkWatcher 检测网卡地址与默认路由的变化并依次执行 Actions. Linux 上通过 netlink 接收通知,
+// 其它系统上定期比较快照. 通知只用于触发比较, 地址与路由不变的事件不会执行 Actions
+type NetworkWatcher struct {
+	Actions []NetworkAction
+	// Interval 比较快照的间隔, 默认 5s. 使用 netlink 时只作为兜底, 为负时不轮询
+	Interval time.Duration
+	// Debounce 收到通知后等待的时间, 合并网络切换时的一连串事件, 默认 500ms
+	Debounce time.Duration
+	// Timeout 一次变化执行全部 Actions 的超时, 默认 10s
+	Timeout time.Duration
+	// OnChange 执行 Actions 之后调用, err 为第一个失败的动作的错误
+	OnChange func(change *NetworkChange, err error)
+
+	state   func() (*NetworkState, error) // 测试时替换
+	current *NetworkState
+}
+
+// Run 读取初始状态并开始检测, 直到 ctx 结束
+func (w *NetworkWatcher) Run(ctx context.Context) error {
+	if w.state == nil {
+		w.state = CurrentNetworkState
+	}
+	current, err := w.state()
+	if err != nil {
+		return errors.WithMessage(err, "read network state")
+	}
+	w.current = current
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events, err := watchNetwork(stop)
+	if err != nil {
+		events = nil // 不支持或没有权限, 只轮询
+	}
+	interval := w.Interval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	debounce := w.Debounce
+	if debounce <= 0 {
+		debounce = 500 * time.Millisecond
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if !w.debounce(ctx, events, debounce) {
+				return ctx.Err()
+			}
+		}
+		w.check(ctx)
+	}
+}
+
+// debounce 等待 d 内没有新的通知, ctx 结束时返回 false
+func (w *NetworkWatcher) debounce(ctx context.Context, events <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			// 新的通知重新开始计时
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(d)
+		}
+	}
+}
+
+// check 比较快照, 变化时执行 Actions
+func (w *NetworkWatcher) check(ctx context.Context) {
+	next, err := w.state()
+	if err != nil || next.Equal(w.current) {
+		return
+	}
+	change := &NetworkChange{Time: time.Now(), Old: w.current, New: next}
+	w.current = next
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var firstErr error
+	for _, action := range w.Actions {
+		if err := action(ctx, change); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if w.OnChange != nil {
+		w.OnChange(change, firstErr)
+	}
+}
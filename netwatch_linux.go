@@ -0,0 +1,51 @@
+package netx
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// watchNetwork 订阅 netlink 的网卡, 地址与路由变化, 每收到一批消息发送一次通知, stop 关闭后退出并关闭通道.
+// 读取使用 200ms 的接收超时轮询 stop
+func watchNetwork(stop <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	groups := unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+		unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: uint32(groups)}); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	tv := unix.Timeval{Usec: 200000}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer unix.Close(fd)
+		b := make([]byte, 64*1024)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_, _, err := unix.Recvfrom(fd, b, 0)
+			switch err {
+			case nil, unix.ENOBUFS: // 缓冲区溢出说明丢失了消息, 同样需要重新比较
+			case unix.EAGAIN, unix.EINTR:
+				continue
+			default:
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, nil
+}
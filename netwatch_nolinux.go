@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package netx
+
+// watchNetwork 其它系统上没有通知, NetworkWatcher 只轮询
+func watchNetwork(stop <-chan struct{}) (<-chan struct{}, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,123 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNetworkWatcher(t *testing.T) {
+	var mu sync.Mutex
+	state := &NetworkState{Addrs: []string{"eth0 192.168.1.2/24"}}
+	setState := func(s *NetworkState) {
+		mu.Lock()
+		state = s
+		mu.Unlock()
+	}
+
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(resolvConf, []byte("nameserver 10.8.0.1\nsearch corp.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache := &DNSCache{entries: map[cacheKey]*cacheEntry{{name: "www.example.com"}: {}}}
+	profiles := NewProfileSwitcher(&ResolutionProfile{Name: "home"})
+	changes := make(chan *NetworkChange, 4)
+	failing := errors.New("action failed")
+	w := &NetworkWatcher{
+		Actions: []NetworkAction{
+			FlushCacheAction(cache),
+			ResolvConfAction(resolvConf, profiles),
+			func(ctx context.Context, change *NetworkChange) error { return failing },
+		},
+		Interval: 10 * time.Millisecond,
+		OnChange: func(change *NetworkChange, err error) {
+			if err != failing {
+				t.Errorf("OnChange err = %v", err)
+			}
+			changes <- change
+		},
+		state: func() (*NetworkState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return state, nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	// 内容相同的新快照不算变化
+	time.Sleep(30 * time.Millisecond)
+	setState(&NetworkState{Addrs: []string{"eth0 192.168.1.2/24"}})
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change %+v", change)
+	default:
+	}
+
+	setState(&NetworkState{Addrs: []string{"eth0 192.168.1.2/24", "tun0 10.8.0.2/24"}, Gateway: []byte{10, 8, 0, 1}})
+	select {
+	case change := <-changes:
+		if added := change.Added(); !reflect.DeepEqual(added, []string{"tun0 10.8.0.2/24"}) || len(change.Removed()) != 0 {
+			t.Fatalf("added %v removed %v", added, change.Removed())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+	if cache.Stats().Entries != 0 {
+		t.Fatal("cache not flushed")
+	}
+	if p := profiles.Active(); p.Name != resolvConf || p.Server != "10.8.0.1:53" || p.SearchDomains[0] != "corp.example" {
+		t.Fatalf("profile = %+v", p)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestCurrentNetworkState(t *testing.T) {
+	s, err := CurrentNetworkState()
+	if err != nil {
+		t.Skip(err)
+	}
+	again, err := CurrentNetworkState()
+	if err != nil || !s.Equal(again) {
+		t.Fatalf("state changed: %v %v", s, again)
+	}
+	if s.Equal(&NetworkState{Addrs: append(s.Addrs, "x 192.0.2.1/32")}) {
+		t.Fatal("different states are equal")
+	}
+}
+
+func TestNetworkWatcherDebounce(t *testing.T) {
+	w := &NetworkWatcher{}
+	events := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			events <- struct{}{}
+		}
+	}()
+	// 一连串相隔 20ms 的通知, 在最后一个之后安静 50ms 才返回
+	start := time.Now()
+	if !w.debounce(context.Background(), events, 50*time.Millisecond) {
+		t.Fatal("debounce canceled")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("debounce returned after %v, during the burst", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if w.debounce(ctx, events, time.Hour) {
+		t.Fatal("debounce ignored ctx")
+	}
+}
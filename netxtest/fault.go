@@ -0,0 +1,143 @@
+package netxtest
+
+import (
+	"context"
+	"github.com/moyrne/netx"
+	"github.com/pkg/errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrInjectedLoss = errors.New("injected packet loss")
+
+// FaultKind 注入的故障类型
+type FaultKind int
+
+const (
+	FaultLoss      FaultKind = iota // 请求或响应丢失, 等到 ctx 结束后返回错误
+	FaultDelay                      // 转发前等待 Delay
+	FaultTruncate                   // 响应被截断: 设置 TC 位并去掉所有记录
+	FaultWrongTxID                  // 响应的 TxID 与请求不同
+	FaultMalformed                  // 响应无法解析, 返回 Unpack 的错误
+)
+
+// FaultTransport 按概率向 Transport 注入故障, 用于测试应用与重试逻辑在真实故障下的表现.
+// 各概率取值 0 到 1, 每次请求最多注入一种响应故障, 延迟可以与其它故障叠加. 可以并发使用
+type FaultTransport struct {
+	// Transport 为空时使用 netx.UDPTransport
+	Transport netx.RoundTripper
+
+	Loss      float64
+	DelayRate float64
+	Delay     time.Duration
+	// Jitter 在 Delay 的基础上再随机增加 [0, Jitter) 的时间
+	Jitter    time.Duration
+	Truncate  float64
+	WrongTxID float64
+	Malformed float64
+
+	// Seed 不为 0 时使用固定的随机序列, 便于复现
+	Seed int64
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected map[FaultKind]int
+}
+
+func (t *FaultTransport) RoundTrip(ctx context.Context, server string, req *netx.DNSMessage) (*netx.DNSMessage, error) {
+	if t.roll(FaultLoss, t.Loss) {
+		<-ctx.Done()
+		return nil, errors.WithMessage(ErrInjectedLoss, ctx.Err().Error())
+	}
+	if t.roll(FaultDelay, t.DelayRate) {
+		delay := t.Delay + t.jitter()
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = netx.UDPTransport{}
+	}
+	resp, err := transport.RoundTrip(ctx, server, req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.roll(FaultTruncate, t.Truncate):
+		truncated := resp.Copy()
+		resp.Release()
+		truncated.ResourceRecodes = nil
+		truncated.Header.AnswerRRs, truncated.Header.AuthorityRRs, truncated.Header.AdditionalRRs = 0, 0, 0
+		truncated.Header.Flags.TC = 1
+		return truncated, nil
+	case t.roll(FaultWrongTxID, t.WrongTxID):
+		resp.Header.TxID = ^req.Header.TxID
+		return resp, nil
+	case t.roll(FaultMalformed, t.Malformed):
+		toByte, err := resp.ToByte()
+		resp.Release()
+		if err != nil {
+			return nil, err
+		}
+		// 回答计数多于实际内容, 解析时读到报文末尾
+		toByte[6], toByte[7] = 0xFF, 0xFF
+		return netx.Unpack(toByte)
+	}
+	return resp, nil
+}
+
+// Injected 返回每种故障已经注入的次数
+func (t *FaultTransport) Injected() map[FaultKind]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[FaultKind]int, len(t.injected))
+	for k, v := range t.injected {
+		counts[k] = v
+	}
+	return counts
+}
+
+// roll 以概率 rate 返回 true 并计数
+func (t *FaultTransport) roll(kind FaultKind, rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.random().Float64() >= rate {
+		return false
+	}
+	if t.injected == nil {
+		t.injected = map[FaultKind]int{}
+	}
+	t.injected[kind]++
+	return true
+}
+
+func (t *FaultTransport) jitter() time.Duration {
+	if t.Jitter <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(t.random().Int63n(int64(t.Jitter)))
+}
+
+// random 调用时持有锁
+func (t *FaultTransport) random() *rand.Rand {
+	if t.rand == nil {
+		seed := t.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		t.rand = rand.New(rand.NewSource(seed))
+	}
+	return t.rand
+}
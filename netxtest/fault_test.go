@@ -0,0 +1,59 @@
+package netxtest
+
+import (
+	"context"
+	"github.com/moyrne/netx"
+	"testing"
+	"time"
+)
+
+func TestFaultTransport(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AddRecord(A("www.example.com", 60, "192.0.2.1"))
+
+	query := func(ft *FaultTransport) (*netx.DNSMessage, error) {
+		r := &netx.Resolver{Server: s.Addr, Transport: ft, Timeout: 50 * time.Millisecond}
+		return r.Query(context.Background(), "www.example.com", netx.DNSTypeA)
+	}
+
+	if _, err := query(&FaultTransport{Loss: 1}); err == nil {
+		t.Fatal("lost query answered")
+	}
+	resp, err := query(&FaultTransport{Truncate: 1})
+	if err != nil || resp.Header.Flags.TC != 1 || len(resp.ResourceRecodes) != 0 || resp.Header.AnswerRRs != 0 {
+		t.Fatalf("truncated = %+v, %v", resp, err)
+	}
+	req := netx.NewQuery("www.example.com", netx.DNSTypeA)
+	resp, err = (&FaultTransport{WrongTxID: 1}).RoundTrip(context.Background(), s.Addr, req)
+	if err != nil || resp.Header.TxID == req.Header.TxID {
+		t.Fatalf("wrong txid = %+v, %v", resp, err)
+	}
+	if _, err := query(&FaultTransport{Malformed: 1}); err == nil {
+		t.Fatal("malformed response parsed")
+	}
+	start := time.Now()
+	if _, err := query(&FaultTransport{DelayRate: 1, Delay: 10 * time.Millisecond}); err != nil || time.Since(start) < 10*time.Millisecond {
+		t.Fatalf("delayed query: %v after %v", err, time.Since(start))
+	}
+	if _, err := query(&FaultTransport{DelayRate: 1, Delay: time.Second}); err == nil {
+		t.Fatal("delay beyond timeout answered")
+	}
+
+	// 固定种子时注入的次数可以复现
+	counts := func() int {
+		ft := &FaultTransport{Truncate: 0.5, Seed: 1}
+		for i := 0; i < 20; i++ {
+			if _, err := query(ft); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ft.Injected()[FaultTruncate]
+	}
+	if n := counts(); n == 0 || n == 20 || n != counts() {
+		t.Fatalf("truncate count = %d", n)
+	}
+}
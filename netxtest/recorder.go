@@ -0,0 +1,93 @@
+package netxtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"github.com/moyrne/netx"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RecordEnv 设置该环境变量后 Recorder 默认进入录制模式
+const RecordEnv = "NETX_RECORD"
+
+var ErrNoGolden = errors.New("golden file not found")
+
+// Recorder 录制模式下通过 Transport 发出真实请求并将响应写入 Dir 下的 golden 文件,
+// 回放模式下只读取 golden 文件, 不访问网络
+type Recorder struct {
+	Dir       string
+	Record    bool
+	Transport netx.RoundTripper
+}
+
+// NewRecorder 根据 RecordEnv 决定是否录制
+func NewRecorder(dir string, transport netx.RoundTripper) *Recorder {
+	return &Recorder{
+		Dir:       dir,
+		Record:    os.Getenv(RecordEnv) != "",
+		Transport: transport,
+	}
+}
+
+func (r *Recorder) RoundTrip(ctx context.Context, server string, req *netx.DNSMessage) (*netx.DNSMessage, error) {
+	path := filepath.Join(r.Dir, GoldenName(req))
+	if r.Record {
+		transport := r.Transport
+		if transport == nil {
+			transport = netx.UDPTransport{}
+		}
+		resp, err := transport.RoundTrip(ctx, server, req)
+		if err != nil {
+			return nil, err
+		}
+		toByte, err := resp.ToByte()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(r.Dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(toByte)+"\n"), 0644); err != nil {
+			return nil, errors.WithMessage(err, "write golden error")
+		}
+		return resp, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.WithMessage(ErrNoGolden, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode golden error")
+	}
+	resp, err := netx.NewDNSMessage(bytes.NewBuffer(raw))
+	if err != nil {
+		return nil, err
+	}
+	// 回放时的 TxID 需要与本次请求一致
+	resp.Header.TxID = req.Header.TxID
+	return resp, nil
+}
+
+// GoldenName 返回请求对应的 golden 文件名
+func GoldenName(req *netx.DNSMessage) string {
+	if len(req.Questions) == 0 {
+		return "empty.golden"
+	}
+	q := req.Questions[0]
+	name := canonicalName(q.QuestionName)
+	if name == "" {
+		name = "root"
+	}
+	return name + "_" + strconv.Itoa(int(q.QuestionType)) + ".golden"
+}
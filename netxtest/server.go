@@ -0,0 +1,183 @@
+// Package netxtest 提供测试用的 DNS 服务器以及录制/回放 Transport
+package netxtest
+
+import (
+	"bytes"
+	"github.com/moyrne/netx"
+	"net"
+	"strings"
+	"sync"
+)
+
+type recordKey struct {
+	name  string
+	qtype uint16
+}
+
+// HandlerFunc 自定义应答, 返回 nil 时回退到预置的记录
+type HandlerFunc func(req *netx.DNSMessage) *netx.DNSMessage
+
+// Server 进程内的假 DNS 服务器, 监听 127.0.0.1 的随机 UDP 端口
+type Server struct {
+	Addr string
+
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	records map[recordKey][]*netx.DNSResourceRecode
+	names   map[string]bool
+	rcodes  map[recordKey]uint16
+	handler HandlerFunc
+	queries []*netx.DNSMessage
+}
+
+// NewServer 启动服务器, 使用完毕后需要调用 Close
+func NewServer() (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:    conn.LocalAddr().String(),
+		conn:    conn,
+		records: map[recordKey][]*netx.DNSResourceRecode{},
+		names:   map[string]bool{},
+		rcodes:  map[recordKey]uint16{},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// A 构造一条 A 记录
+func A(name string, ttl uint32, ip string) *netx.DNSResourceRecode {
+	return &netx.DNSResourceRecode{
+		Name:     name,
+		RRType:   netx.DNSTypeA,
+		Class:    netx.DNSClassIn,
+		TTL:      ttl,
+		RDLength: 4,
+		RData:    ip,
+	}
+}
+
+// AddRecord 添加应答记录, 按 (Name, RRType) 匹配问题
+func (s *Server) AddRecord(rrs ...*netx.DNSResourceRecode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rr := range rrs {
+		name := canonicalName(rr.Name)
+		key := recordKey{name: name, qtype: rr.RRType}
+		s.records[key] = append(s.records[key], rr)
+		s.names[name] = true
+	}
+}
+
+// SetRCode 对 (name, qtype) 固定返回 rcode
+func (s *Server) SetRCode(name string, qtype uint16, rcode uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcodes[recordKey{name: canonicalName(name), qtype: qtype}] = rcode
+}
+
+// Handle 设置自定义应答函数
+func (s *Server) Handle(fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = fn
+}
+
+// Queries 返回服务器收到的所有请求
+func (s *Server) Queries() []*netx.DNSMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*netx.DNSMessage(nil), s.queries...)
+}
+
+// Resolver 返回指向该服务器的 Resolver
+func (s *Server) Resolver() *netx.Resolver {
+	return &netx.Resolver{Server: s.Addr}
+}
+
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := netx.NewDNSMessage(bytes.NewBuffer(append([]byte(nil), buf[:n]...)))
+		if err != nil || len(req.Questions) == 0 {
+			continue
+		}
+		resp := s.answer(req)
+		if resp == nil {
+			continue
+		}
+		toByte, err := resp.ToByte()
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(toByte, addr)
+	}
+}
+
+func (s *Server) answer(req *netx.DNSMessage) *netx.DNSMessage {
+	s.mu.Lock()
+	s.queries = append(s.queries, req)
+	handler := s.handler
+	s.mu.Unlock()
+
+	if handler != nil {
+		if resp := handler(req); resp != nil {
+			return resp
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := req.Questions[0]
+	key := recordKey{name: canonicalName(q.QuestionName), qtype: q.QuestionType}
+	resp := Reply(req)
+	if rcode, ok := s.rcodes[key]; ok {
+		resp.Header.Flags.RCode = rcode
+		return resp
+	}
+	if !s.names[key.name] {
+		resp.Header.Flags.RCode = netx.DNSRCodeNXDomain
+		return resp
+	}
+	for _, rr := range s.records[key] {
+		// 与问题同名的记录使用压缩指针指向问题中的名字 (报文偏移 12)
+		answer := *rr
+		answer.NamePos = 12
+		resp.ResourceRecodes = append(resp.ResourceRecodes, &answer)
+	}
+	resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+	return resp
+}
+
+// Reply 构造与 req 对应的空响应
+func Reply(req *netx.DNSMessage) *netx.DNSMessage {
+	return &netx.DNSMessage{
+		Header: &netx.DNSHeader{
+			TxID: req.Header.TxID,
+			Flags: &netx.DNSFlags{
+				QR:     1,
+				OpCode: req.Header.Flags.OpCode,
+				AA:     1,
+				RD:     req.Header.Flags.RD,
+				RA:     1,
+			},
+			Questions: uint16(len(req.Questions)),
+		},
+		Questions: req.Questions,
+	}
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,64 @@
+package netxtest
+
+import (
+	"context"
+	"github.com/moyrne/netx"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AddRecord(A("www.example.com", 60, "192.0.2.1"))
+
+	resp, err := s.Resolver().Query(context.Background(), "www.example.com", netx.DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ResourceRecodes) != 1 || resp.ResourceRecodes[0].RData != "192.0.2.1" {
+		t.Fatalf("unexpected answer: %+v", resp.ResourceRecodes)
+	}
+
+	resp, err = s.Resolver().Query(context.Background(), "missing.example.com", netx.DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Flags.RCode != 3 {
+		t.Fatalf("rcode = %d, want 3", resp.Header.Flags.RCode)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddRecord(A("www.example.com", 60, "192.0.2.1"))
+
+	dir, err := ioutil.TempDir("", "netxtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rec := &Recorder{Dir: dir, Record: true}
+	r := &netx.Resolver{Server: s.Addr, Transport: rec}
+	if _, err := r.Query(context.Background(), "www.example.com", netx.DNSTypeA); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	rec.Record = false
+	resp, err := r.Query(context.Background(), "www.example.com", netx.DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ResourceRecodes) != 1 || resp.ResourceRecodes[0].RData != "192.0.2.1" {
+		t.Fatalf("unexpected replay: %+v", resp.ResourceRecodes)
+	}
+}
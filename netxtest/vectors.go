@@ -0,0 +1,173 @@
+package netxtest
+
+import (
+	"bufio"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"github.com/moyrne/netx"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadVector      = errors.New("malformed test vector")
+	ErrVectorMismatch = errors.New("decoded message does not match test vector")
+)
+
+//go:embed testdata/vectors/*.vec
+var corpus embed.FS
+
+// Vector 一条报文测试向量: 线上格式的报文与期望的解析结果. 文件格式:
+//
+//	# 说明
+//	wire
+//	<十六进制报文, 可以分多行>
+//	expect
+//	<Describe 的输出, 每行一条>
+type Vector struct {
+	Name    string // 文件名去掉 .vec
+	Comment string
+	Wire    []byte
+	Expect  []string
+}
+
+// Corpus 内置的测试向量, 覆盖 A, AAAA, CNAME 链, NXDOMAIN, NODATA, EDNS 与 DNSSEC 等常见响应
+func Corpus() ([]*Vector, error) {
+	return LoadVectors(corpus, "testdata/vectors")
+}
+
+// LoadVectors 读取 fsys 中 dir 下所有 .vec 文件, 按文件名排序. 使用磁盘上的目录时传入 os.DirFS
+func LoadVectors(fsys fs.FS, dir string) ([]*Vector, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.vec"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var vectors []*Vector
+	for _, name := range names {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := ParseVector(strings.TrimSuffix(path.Base(name), ".vec"), f)
+		f.Close()
+		if err != nil {
+			return nil, errors.WithMessage(err, name)
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+// ParseVector 解析一个测试向量文件
+func ParseVector(name string, r io.Reader) (*Vector, error) {
+	v := &Vector{Name: name}
+	var section, wire string
+	var comments []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			comments = append(comments, strings.TrimSpace(line[1:]))
+		case line == "wire" || line == "expect":
+			section = line
+		case section == "wire":
+			wire += line
+		case section == "expect":
+			v.Expect = append(v.Expect, line)
+		default:
+			return nil, errors.WithMessagef(ErrBadVector, "unexpected line %q", line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(wire)
+	if err != nil {
+		return nil, errors.WithMessage(ErrBadVector, err.Error())
+	}
+	if len(raw) == 0 || len(v.Expect) == 0 {
+		return nil, errors.WithMessage(ErrBadVector, "missing wire or expect section")
+	}
+	v.Wire, v.Comment = raw, strings.Join(comments, "\n")
+	return v, nil
+}
+
+// Check 严格解析 Wire 并与 Expect 比较, 再编码后重新解析一次, 确认往返之后结果不变
+func (v *Vector) Check() error {
+	m, _, err := (&netx.UnpackOptions{Mode: netx.UnpackStrict}).Unpack(v.Wire)
+	if err != nil {
+		return errors.WithMessage(err, v.Name)
+	}
+	if err := diffLines(v.Expect, Describe(m)); err != nil {
+		return errors.WithMessage(err, v.Name)
+	}
+	b, err := m.ToByte()
+	if err != nil {
+		return errors.WithMessagef(err, "%s: encode", v.Name)
+	}
+	again, err := netx.Unpack(b)
+	if err != nil {
+		return errors.WithMessagef(err, "%s: decode re-encoded", v.Name)
+	}
+	if err := diffLines(v.Expect, Describe(again)); err != nil {
+		return errors.WithMessagef(err, "%s: round trip", v.Name)
+	}
+	return nil
+}
+
+func diffLines(want, got []string) error {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return errors.WithMessagef(ErrVectorMismatch, "line %d: want %q, got %q", i+1, w, g)
+		}
+	}
+	return nil
+}
+
+// Describe 按固定格式逐行描述解析结果: 头部, 问题, 然后每条记录一行
+// "<部分> <名字> <TTL> <类别> <类型> <RDATA>", 没有解析的 RDATA 按 RFC 3597 写作 \# 长度 十六进制
+func Describe(m *netx.DNSMessage) []string {
+	h, f := m.Header, m.Header.Flags
+	lines := []string{fmt.Sprintf("header id=%d qr=%d opcode=%s aa=%d tc=%d rd=%d ra=%d z=%d rcode=%s",
+		h.TxID, f.QR, netx.DNSOpCode(f.OpCode), f.AA, f.TC, f.RD, f.RA, f.Z, netx.DNSRCode(f.RCode))}
+	for _, q := range m.Questions {
+		lines = append(lines, fmt.Sprintf("question %s %s %s", describeName(q.QuestionName), netx.DNSType(q.QuestionType), netx.DNSClass(q.QuestionClass)))
+	}
+	sections := []struct {
+		name    string
+		records []*netx.DNSResourceRecode
+	}{{"answer", m.Answers()}, {"authority", m.Authorities()}, {"additional", m.Additionals()}}
+	for _, s := range sections {
+		for _, rr := range s.records {
+			rdata := rr.RData
+			if rr.Data != nil {
+				rdata = strings.TrimSpace(`\# ` + strconv.Itoa(len(rr.Data)) + " " + hex.EncodeToString(rr.Data))
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %d %s %s %s", s.name, describeName(rr.Name), rr.TTL, netx.DNSClass(rr.Class), netx.DNSType(rr.RRType), rdata))
+		}
+	}
+	return lines
+}
+
+func describeName(name string) string {
+	if name == "" {
+		return "."
+	}
+	return name
+}
@@ -0,0 +1,41 @@
+package netxtest
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	vectors, err := Corpus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) < 10 {
+		t.Fatalf("corpus has %d vectors", len(vectors))
+	}
+	for _, v := range vectors {
+		if err := v.Check(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestVectorMismatch(t *testing.T) {
+	vectors, err := Corpus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := *vectors[0]
+	v.Expect = append([]string{}, v.Expect...)
+	v.Expect[0] = strings.Replace(v.Expect[0], "rcode=NOERROR", "rcode=NXDOMAIN", 1)
+	if err := v.Check(); !errors.Is(err, ErrVectorMismatch) {
+		t.Fatalf("check = %v", err)
+	}
+
+	for _, bad := range []string{"wire\nzz\nexpect\nx", "expect\nheader", "wire\n00\n", "garbage"} {
+		if _, err := ParseVector("bad", strings.NewReader(bad)); !errors.Is(err, ErrBadVector) {
+			t.Fatalf("%q: %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package netx
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNSECEntries = 10000
+	// nsec3MaxIterations 超过该迭代次数的 NSEC3 不使用, RFC 9276 3.2
+	nsec3MaxIterations = 150
+	nsec3FlagOptOut    = 1
+)
+
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// NSEC NSEC 记录的 RDATA, RFC 4034 4
+type NSEC struct {
+	NextDomain string
+	Types      []uint16
+}
+
+// NSEC3 NSEC3 记录的 RDATA, RFC 5155 3
+type NSEC3 struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []uint16
+}
+
+// ParseNSEC 解析 NSEC 的 RDATA
+func ParseNSEC(data []byte) (*NSEC, error) {
+	u := &unpacker{msg: data, partial: true}
+	next, _, err := u.name()
+	if err != nil {
+		return nil, err
+	}
+	types, err := parseTypeBitmap(data[u.off:])
+	if err != nil {
+		return nil, err
+	}
+	return &NSEC{NextDomain: next, Types: types}, nil
+}
+
+// ParseNSEC3 解析 NSEC3 的 RDATA
+func ParseNSEC3(data []byte) (*NSEC3, error) {
+	if len(data) < 5 || len(data) < 5+int(data[4])+1 {
+		return nil, ErrBadRData
+	}
+	n := &NSEC3{HashAlgorithm: data[0], Flags: data[1], Iterations: binary.BigEndian.Uint16(data[2:])}
+	n.Salt = append([]byte{}, data[5:5+int(data[4])]...)
+	rest := data[5+len(n.Salt):]
+	if len(rest) < 1+int(rest[0]) || rest[0] == 0 {
+		return nil, ErrBadRData
+	}
+	n.NextHashed = append([]byte{}, rest[1:1+int(rest[0])]...)
+	types, err := parseTypeBitmap(rest[1+len(n.NextHashed):])
+	if err != nil {
+		return nil, err
+	}
+	n.Types = types
+	return n, nil
+}
+
+// Pack 编码为 RDATA
+func (n *NSEC) Pack() ([]byte, error) {
+	b, err := appendName(nil, n.NextDomain)
+	if err != nil {
+		return nil, err
+	}
+	return appendTypeBitmap(b, n.Types), nil
+}
+
+// Pack 编码为 RDATA
+func (n *NSEC3) Pack() []byte {
+	b := []byte{n.HashAlgorithm, n.Flags, byte(n.Iterations >> 8), byte(n.Iterations), byte(len(n.Salt))}
+	b = append(append(b, n.Salt...), byte(len(n.NextHashed)))
+	return appendTypeBitmap(append(b, n.NextHashed...), n.Types)
+}
+
+// appendTypeBitmap 按窗口编码类型位图
+func appendTypeBitmap(b []byte, types []uint16) []byte {
+	sorted := append([]uint16{}, types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i := 0; i < len(sorted); {
+		window := sorted[i] >> 8
+		var bitmap [32]byte
+		n := 0
+		for ; i < len(sorted) && sorted[i]>>8 == window; i++ {
+			low := sorted[i] & 0xff
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			n = int(low/8) + 1
+		}
+		b = append(append(b, byte(window), byte(n)), bitmap[:n]...)
+	}
+	return b
+}
+
+// parseTypeBitmap 解析 RFC 4034 4.1.2 的类型位图
+func parseTypeBitmap(b []byte) ([]uint16, error) {
+	var types []uint16
+	for len(b) > 0 {
+		if len(b) < 2 || b[1] == 0 || b[1] > 32 || len(b) < 2+int(b[1]) {
+			return nil, ErrBadRData
+		}
+		window, bitmap := uint16(b[0])<<8, b[2:2+int(b[1])]
+		for i, octet := range bitmap {
+			for bit := 0; bit < 8; bit++ {
+				if octet&(0x80>>uint(bit)) != 0 {
+					types = append(types, window|uint16(i*8+bit))
+				}
+			}
+		}
+		b = b[2+len(bitmap):]
+	}
+	return types, nil
+}
+
+func hasType(types []uint16, t uint16) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalCompare RFC 4034 6.1 的名字规范顺序, 从最右边的标签开始逐个比较小写的标签
+func canonicalCompare(a, b string) int {
+	la, lb := strings.Split(normalizeDomain(a), "."), strings.Split(normalizeDomain(b), ".")
+	if la[0] == "" {
+		la = nil
+	}
+	if lb[0] == "" {
+		lb = nil
+	}
+	for i, j := len(la)-1, len(lb)-1; i >= 0 || j >= 0; i, j = i-1, j-1 {
+		switch {
+		case i < 0:
+			return -1
+		case j < 0:
+			return 1
+		}
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// nsec3Hash RFC 5155 5 的名字散列, 只支持 SHA-1
+func nsec3Hash(name string, salt []byte, iterations uint16) []byte {
+	wire, _ := appendName(nil, normalizeDomain(name))
+	h := sha1.Sum(append(wire, salt...))
+	for i := 0; i < int(iterations); i++ {
+		h = sha1.Sum(append(h[:], salt...))
+	}
+	return h[:]
+}
+
+// NSECCache RFC 8198 的激进否定缓存: 保存经过验证的 NSEC, NSEC3 与区域的 SOA,
+// 对它们能证明不存在的名字与类型直接合成 NXDOMAIN 或 NODATA 应答. 零值可用, 可以并发使用.
+// 只有 NSEC3 使用 SHA-1 且迭代次数不超过 150, 不带 opt-out 时才用于 NXDOMAIN
+type NSECCache struct {
+	// MaxEntries 最多保存的 NSEC 与 NSEC3 记录数, 默认 10000, 超出时不再添加
+	MaxEntries int
+
+	mu      sync.Mutex
+	zones   map[string]*denialZone
+	entries int
+	hits    uint64
+}
+
+type denialZone struct {
+	soa     []*DNSResourceRecode // SOA 及其 RRSIG
+	expires time.Time
+	nsec    []*nsecEntry // 按 owner 规范顺序排列
+	nsec3   []*nsecEntry // 按散列排列
+}
+
+type nsecEntry struct {
+	owner   string
+	hash    []byte // NSEC3 的 owner 散列
+	nsec    *NSEC
+	nsec3   *NSEC3
+	rrs     []*DNSResourceRecode // 记录及其 RRSIG
+	expires time.Time
+}
+
+// Hits 返回合成否定应答的次数
+func (c *NSECCache) Hits() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// addSOA 保存区域经过验证的 SOA
+func (c *NSECCache) addSOA(zone string, rrs []*DNSResourceRecode, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	z := c.zone(zone)
+	z.soa, z.expires = copyRecords(rrs), expires
+}
+
+// add 保存经过验证的 NSEC 或 NSEC3 记录, rrs 的第一条为记录本身, 其余为 RRSIG
+func (c *NSECCache) add(zone string, rrs []*DNSResourceRecode, expires time.Time) {
+	e := &nsecEntry{owner: normalizeDomain(rrs[0].Name), rrs: copyRecords(rrs), expires: expires}
+	var err error
+	if rrs[0].RRType == DNSTypeNSEC {
+		e.nsec, err = ParseNSEC(rrs[0].Data)
+	} else {
+		e.nsec3, err = ParseNSEC3(rrs[0].Data)
+		if err == nil {
+			label := strings.SplitN(e.owner, ".", 2)[0]
+			e.hash, err = nsec3Encoding.DecodeString(strings.ToUpper(label))
+		}
+	}
+	if err != nil {
+		return
+	}
+	max := c.MaxEntries
+	if max <= 0 {
+		max = defaultNSECEntries
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	z := c.zone(zone)
+	list, less := &z.nsec, func(a, b *nsecEntry) int { return canonicalCompare(a.owner, b.owner) }
+	if e.nsec3 != nil {
+		list, less = &z.nsec3, func(a, b *nsecEntry) int { return bytes.Compare(a.hash, b.hash) }
+	}
+	i := sort.Search(len(*list), func(i int) bool { return less((*list)[i], e) >= 0 })
+	if i < len(*list) && less((*list)[i], e) == 0 {
+		(*list)[i] = e
+		return
+	}
+	if c.entries >= max {
+		c.purge(time.Now())
+		if c.entries >= max {
+			return
+		}
+	}
+	*list = append(*list, nil)
+	copy((*list)[i+1:], (*list)[i:])
+	(*list)[i] = e
+	c.entries++
+}
+
+func (c *NSECCache) zone(name string) *denialZone {
+	if c.zones == nil {
+		c.zones = map[string]*denialZone{}
+	}
+	z := c.zones[name]
+	if z == nil {
+		z = &denialZone{}
+		c.zones[name] = z
+	}
+	return z
+}
+
+// purge 删除过期的记录
+func (c *NSECCache) purge(now time.Time) {
+	for name, z := range c.zones {
+		for _, list := range []*[]*nsecEntry{&z.nsec, &z.nsec3} {
+			kept := (*list)[:0]
+			for _, e := range *list {
+				if now.Before(e.expires) {
+					kept = append(kept, e)
+				} else {
+					c.entries--
+				}
+			}
+			*list = kept
+		}
+		if len(z.nsec) == 0 && len(z.nsec3) == 0 && !now.Before(z.expires) {
+			delete(c.zones, name)
+		}
+	}
+}
+
+// Synthesize 用缓存的记录证明 req 的问题不存在, 返回带 SOA 与证明记录 (含 RRSIG) 的否定应答, 不能证明时返回空
+func (c *NSECCache) Synthesize(req *DNSMessage) *DNSMessage {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	z, rcode, proof := c.prove(req, now)
+	if proof == nil {
+		return nil
+	}
+	c.hits++
+	resp := NewReply(req)
+	resp.Header.Flags.RA = 1
+	resp.Header.Flags.RCode = rcode
+	expires := z.expires
+	for _, e := range proof {
+		if e.expires.Before(expires) {
+			expires = e.expires
+		}
+	}
+	ttl := uint32(expires.Sub(now) / time.Second)
+	add := func(rrs []*DNSResourceRecode) {
+		for _, rr := range rrs {
+			c := rr.Copy()
+			c.TTL = ttl
+			resp.ResourceRecodes = append(resp.ResourceRecodes, c)
+		}
+	}
+	add(z.soa)
+	seen := map[*nsecEntry]bool{}
+	for _, e := range proof {
+		if !seen[e] {
+			seen[e] = true
+			add(e.rrs)
+		}
+	}
+	resp.Header.AuthorityRRs = uint16(len(resp.ResourceRecodes))
+	return resp
+}
+
+// proves 缓存能否证明 req 的问题以 rcode 不存在, 用于判断上游的否定应答是否安全
+func (c *NSECCache) proves(req *DNSMessage, rcode uint16) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, got, proof := c.prove(req, time.Now())
+	return proof != nil && got == rcode
+}
+
+// provesOptOut 缓存能否证明 req 的名字落在 NSEC3 的 opt-out 区间, 这样的否定应答与没有 DS 的委派是不安全的
+func (c *NSECCache) provesOptOut(req *DNSMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(req.Questions) != 1 {
+		return false
+	}
+	name, now := normalizeDomain(req.Questions[0].QuestionName), time.Now()
+	for zone := name; ; zone = parentName(zone) {
+		if z := c.zones[zone]; z != nil && len(z.soa) > 0 && now.Before(z.expires) {
+			return z.optOut(name, zone, now)
+		}
+		if zone == "" {
+			return false
+		}
+	}
+}
+
+// merge 加入 other 中的全部记录
+func (c *NSECCache) merge(other *NSECCache) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	for name, z := range other.zones {
+		if len(z.soa) > 0 {
+			c.addSOA(name, z.soa, z.expires)
+		}
+		for _, e := range append(z.nsec[:len(z.nsec):len(z.nsec)], z.nsec3...) {
+			c.add(name, e.rrs, e.expires)
+		}
+	}
+}
+
+// prove 在包含问题名字的最长区域中查找证明, 调用时持有锁
+func (c *NSECCache) prove(req *DNSMessage, now time.Time) (*denialZone, uint16, []*nsecEntry) {
+	if len(req.Questions) != 1 || req.Questions[0].QuestionClass != DNSClassIn {
+		return nil, 0, nil
+	}
+	q := req.Questions[0]
+	name := normalizeDomain(q.QuestionName)
+	for zone := name; ; zone = parentName(zone) {
+		if z := c.zones[zone]; z != nil && len(z.soa) > 0 && now.Before(z.expires) {
+			rcode, proof := z.proveNSEC(name, zone, q.QuestionType, now)
+			if proof == nil {
+				rcode, proof = z.proveNSEC3(name, zone, q.QuestionType, now)
+			}
+			return z, rcode, proof
+		}
+		if zone == "" {
+			return nil, 0, nil
+		}
+	}
+}
+
+// delegationNSEC NSEC 在父区域一侧的委派点 (有 NS 没有 SOA) 只能证明 DS 不存在, 不能证明其下的名字
+func delegationNSEC(types []uint16) bool {
+	return hasType(types, DNSTypeNS) && !hasType(types, DNSTypeSOA)
+}
+
+// proveNSEC 用 NSEC 证明不存在, 返回 rcode 与用到的记录
+func (z *denialZone) proveNSEC(name, zone string, qtype uint16, now time.Time) (uint16, []*nsecEntry) {
+	if len(z.nsec) == 0 {
+		return 0, nil
+	}
+	// 不大于 name 的最后一条, 没有时为回绕的最后一条
+	find := func(n string) *nsecEntry {
+		i := sort.Search(len(z.nsec), func(i int) bool { return canonicalCompare(z.nsec[i].owner, n) > 0 })
+		e := z.nsec[len(z.nsec)-1]
+		if i > 0 {
+			e = z.nsec[i-1]
+		}
+		if !now.Before(e.expires) {
+			return nil
+		}
+		return e
+	}
+	e := find(name)
+	if e == nil {
+		return 0, nil
+	}
+	if e.owner == name {
+		types := e.nsec.Types
+		if hasType(types, qtype) || hasType(types, DNSTypeCName) || (delegationNSEC(types) && qtype != DNSTypeDS) {
+			return 0, nil
+		}
+		return DNSRCodeSuccess, []*nsecEntry{e}
+	}
+	if !nsecCovers(e.owner, e.nsec.NextDomain, name, zone) || (delegationNSEC(e.nsec.Types) && inZone(name, e.owner)) {
+		return 0, nil
+	}
+	// 最近的存在的祖先是 name 与 owner, next 的最长公共祖先, 它的通配符也必须不存在
+	encloser := commonAncestor(name, e.owner)
+	if other := commonAncestor(name, e.nsec.NextDomain); len(other) > len(encloser) {
+		encloser = other
+	}
+	if !inZone(encloser, zone) {
+		encloser = zone
+	}
+	wildcard := "*." + encloser
+	if encloser == "" {
+		wildcard = "*"
+	}
+	w := find(wildcard)
+	if w == nil || w.owner == wildcard || !nsecCovers(w.owner, w.nsec.NextDomain, wildcard, zone) {
+		return 0, nil
+	}
+	return DNSRCodeNXDomain, []*nsecEntry{e, w}
+}
+
+// nsecCovers name 是否在 owner 与 next 之间, next 不大于 owner 时表示区域中的最后一条
+func nsecCovers(owner, next, name, zone string) bool {
+	if canonicalCompare(owner, name) >= 0 || !inZone(name, zone) {
+		return false
+	}
+	if canonicalCompare(owner, next) >= 0 {
+		return true
+	}
+	return canonicalCompare(name, next) < 0
+}
+
+// commonAncestor 两个名字的最长公共祖先
+func commonAncestor(a, b string) string {
+	la, lb := strings.Split(normalizeDomain(a), "."), strings.Split(normalizeDomain(b), ".")
+	var common []string
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0 && la[i] == lb[j] && la[i] != ""; i, j = i-1, j-1 {
+		common = append([]string{la[i]}, common...)
+	}
+	return strings.Join(common, ".")
+}
+
+// proveNSEC3 用 NSEC3 证明不存在 (RFC 5155 8.4 与 8.5)
+func (z *denialZone) proveNSEC3(name, zone string, qtype uint16, now time.Time) (uint16, []*nsecEntry) {
+	params := z.nsec3Params()
+	if params == nil {
+		return 0, nil
+	}
+	if e := z.nsec3Match(params, name, now); e != nil {
+		types := e.nsec3.Types
+		if hasType(types, qtype) || hasType(types, DNSTypeCName) || (delegationNSEC(types) && qtype != DNSTypeDS) {
+			return 0, nil
+		}
+		return DNSRCodeSuccess, []*nsecEntry{e}
+	}
+	ce, next := z.closestEncloser(params, name, zone, now)
+	if ce == nil || delegationNSEC(ce.nsec3.Types) {
+		return 0, nil
+	}
+	// 最近的存在的祖先, 其下一级名字与通配符都被覆盖
+	nc := z.nsec3Cover(params, next, now)
+	wildcard := "*"
+	if encloser := parentName(next); encloser != "" {
+		wildcard = "*." + encloser
+	}
+	wc := z.nsec3Cover(params, wildcard, now)
+	if nc == nil || wc == nil || nc.nsec3.Flags&nsec3FlagOptOut != 0 {
+		return 0, nil
+	}
+	return DNSRCodeNXDomain, []*nsecEntry{ce, nc, wc}
+}
+
+// optOut name 没有匹配的 NSEC3, 它的下一级名字落在带 opt-out 的区间中 (RFC 5155 8.6 与 9.2)
+func (z *denialZone) optOut(name, zone string, now time.Time) bool {
+	params := z.nsec3Params()
+	if params == nil || z.nsec3Match(params, name, now) != nil {
+		return false
+	}
+	ce, next := z.closestEncloser(params, name, zone, now)
+	if ce == nil {
+		return false
+	}
+	nc := z.nsec3Cover(params, next, now)
+	return nc != nil && nc.nsec3.Flags&nsec3FlagOptOut != 0
+}
+
+// nsec3Params 区域 NSEC3 的散列参数, 不支持的算法或迭代次数过多时返回空
+func (z *denialZone) nsec3Params() *NSEC3 {
+	if len(z.nsec3) == 0 {
+		return nil
+	}
+	params := z.nsec3[0].nsec3
+	if params.HashAlgorithm != 1 || params.Iterations > nsec3MaxIterations {
+		return nil
+	}
+	return params
+}
+
+// nsec3Match 散列与 name 相同的 NSEC3
+func (z *denialZone) nsec3Match(params *NSEC3, name string, now time.Time) *nsecEntry {
+	h := nsec3Hash(name, params.Salt, params.Iterations)
+	i := sort.Search(len(z.nsec3), func(i int) bool { return bytes.Compare(z.nsec3[i].hash, h) >= 0 })
+	if i < len(z.nsec3) && bytes.Equal(z.nsec3[i].hash, h) && now.Before(z.nsec3[i].expires) {
+		return z.nsec3[i]
+	}
+	return nil
+}
+
+// nsec3Cover 散列区间覆盖 name 的 NSEC3
+func (z *denialZone) nsec3Cover(params *NSEC3, name string, now time.Time) *nsecEntry {
+	h := nsec3Hash(name, params.Salt, params.Iterations)
+	i := sort.Search(len(z.nsec3), func(i int) bool { return bytes.Compare(z.nsec3[i].hash, h) >= 0 })
+	e := z.nsec3[len(z.nsec3)-1]
+	if i > 0 {
+		e = z.nsec3[i-1]
+	}
+	if !now.Before(e.expires) || bytes.Equal(e.hash, h) || !bytes.Equal(e.nsec3.Salt, params.Salt) || e.nsec3.Iterations != params.Iterations {
+		return nil
+	}
+	if bytes.Compare(e.hash, e.nsec3.NextHashed) >= 0 || bytes.Compare(h, e.nsec3.NextHashed) < 0 {
+		return e
+	}
+	return nil
+}
+
+// closestEncloser 区域中 name 最近的存在的祖先的 NSEC3 与它下一级的名字
+func (z *denialZone) closestEncloser(params *NSEC3, name, zone string, now time.Time) (*nsecEntry, string) {
+	next := name
+	for encloser := parentName(name); inZone(encloser, zone); encloser = parentName(encloser) {
+		if ce := z.nsec3Match(params, encloser, now); ce != nil {
+			return ce, next
+		}
+		next = encloser
+		if encloser == zone {
+			break
+		}
+	}
+	return nil, ""
+}
+
+// negativeResponse NXDOMAIN 或没有回答的 NOERROR
+func negativeResponse(resp *DNSMessage) bool {
+	rcode := resp.Header.Flags.RCode
+	return rcode == DNSRCodeNXDomain || (rcode == DNSRCodeSuccess && len(resp.Answers()) == 0)
+}
+
+func copyRecords(rrs []*DNSResourceRecode) []*DNSResourceRecode {
+	c := make([]*DNSResourceRecode, 0, len(rrs))
+	for _, rr := range rrs {
+		c = append(c, rr.Copy())
+	}
+	return c
+}
@@ -0,0 +1,250 @@
+package netx
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTypeBitmap(t *testing.T) {
+	n := &NSEC{NextDomain: "b.example", Types: []uint16{DNSTypeRRSIG, DNSTypeA, DNSTypeNSEC, DNSTypeCAA, DNSTypeMX}}
+	b, err := n.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseNSEC(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NextDomain != "b.example" || len(got.Types) != 5 || got.Types[0] != DNSTypeA || got.Types[4] != DNSTypeCAA {
+		t.Fatalf("got %+v", got)
+	}
+	if _, err = ParseNSEC(append(b, 0, 0)); err == nil {
+		t.Fatal("empty window accepted")
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// RFC 4034 6.1 的例子
+	names := []string{"example", "a.example", "yljkjljk.a.example", "Z.a.example", "zABC.a.EXAMPLE", "z.example"}
+	for i := 1; i < len(names); i++ {
+		if canonicalCompare(names[i-1], names[i]) >= 0 {
+			t.Fatalf("%s >= %s", names[i-1], names[i])
+		}
+	}
+	if canonicalCompare("Example.", "example") != 0 {
+		t.Fatal("case or trailing dot matters")
+	}
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	// RFC 5155 附录 A
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	for name, want := range map[string]string{
+		"example":   "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example": "35mthgpgcu1qg68fab165klnsnk3dpvl",
+	} {
+		if got := strings.ToLower(nsec3Encoding.EncodeToString(nsec3Hash(name, salt, 12))); got != want {
+			t.Fatalf("%s: %s", name, got)
+		}
+	}
+}
+
+// negativeUpstream 在 signedUpstream 没有数据时应答带签名的否定应答, 授权部分附带 example 区域的全部 NSEC
+type negativeUpstream struct {
+	*signedUpstream
+	authority []*DNSResourceRecode
+	owners    []string // NSEC 链上的名字, 其余名字应答 NXDOMAIN
+
+	mu      sync.Mutex
+	queries int
+}
+
+func newNegativeUpstream(t *testing.T) (*negativeUpstream, map[string][]*DS) {
+	u, anchors := newSignedUpstream(t)
+	// newSignedUpstream 的 example 密钥没有导出, 重新生成并替换
+	ex := newTestZoneKey(t, "example", DNSSECAlgECDSAP256SHA256)
+	keys := []*DNSResourceRecode{ex.dnskey()}
+	u.records["example/DNSKEY"] = append(keys, ex.sign(t, keys, 1))
+	www := []*DNSResourceRecode{aRecord("www.example", "192.0.2.1")}
+	u.records["www.example/A"] = append(www, ex.sign(t, www, 2))
+	anchors["example"] = []*DS{ex.ds(t)}
+
+	n := &negativeUpstream{signedUpstream: u}
+	soa := []*DNSResourceRecode{{Name: "example", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 600, RData: "ns.example. admin.example. 1 3600 600 86400 600"}}
+	n.authority = append(soa, ex.sign(t, soa, 1))
+	n.owners = []string{"example", "a.example", "plain.example", "sub.example", "www.example"}
+	n.authority = append(n.authority, ex.nsecChain(t, n.owners, map[string][]uint16{
+		"example":       {DNSTypeSOA, DNSTypeNS, DNSTypeDNSKEY, DNSTypeRRSIG, DNSTypeNSEC},
+		"a.example":     {DNSTypeTXT, DNSTypeRRSIG, DNSTypeNSEC},
+		"plain.example": {DNSTypeNS, DNSTypeNSEC, DNSTypeRRSIG},
+		"sub.example":   {DNSTypeNS, DNSTypeDS, DNSTypeRRSIG, DNSTypeNSEC},
+		"www.example":   {DNSTypeA, DNSTypeRRSIG, DNSTypeNSEC},
+	})...)
+	return n, anchors
+}
+
+func (n *negativeUpstream) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	q := req.Message.Questions[0]
+	if q.QuestionType != DNSTypeDNSKEY && q.QuestionType != DNSTypeDS && q.QuestionType != DNSTypeSOA {
+		n.mu.Lock()
+		n.queries++
+		n.mu.Unlock()
+	}
+	resp, err := n.signedUpstream.ServeDNS(ctx, req)
+	if err != nil || resp.Header.AnswerRRs > 0 || !inZone(q.QuestionName, "example") {
+		return resp, err
+	}
+	resp.Header.Flags.RCode = DNSRCodeNXDomain
+	for _, owner := range n.owners {
+		if strings.EqualFold(q.QuestionName, owner) {
+			resp.Header.Flags.RCode = DNSRCodeSuccess
+		}
+	}
+	opt := resp.OPT()
+	resp.ResourceRecodes = copyRecords(n.authority)
+	resp.Header.AuthorityRRs, resp.Header.AdditionalRRs = uint16(len(n.authority)), 0
+	if opt != nil {
+		resp.SetEDNS(1232, nil)
+		resp.OPT().TTL = opt.TTL
+	}
+	return resp, nil
+}
+
+func (n *negativeUpstream) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.queries
+}
+
+func TestAggressiveNSEC(t *testing.T) {
+	u, anchors := newNegativeUpstream(t)
+	upstream, _ := startDNSServer(t, &DNSServer{Handler: u})
+	up := &Resolver{Server: upstream}
+	cache := &NSECCache{}
+	h := ChainHandler(ForwardHandler(up), WithDNSSECValidation(&DNSSECValidator{Resolver: up, Anchors: anchors, NSEC: cache}))
+	ctx := context.Background()
+	query := func(name string, qtype uint16, opts ...QueryOption) *DNSMessage {
+		t.Helper()
+		resp, err := h.ServeDNS(ctx, &DNSRequest{Message: NewQuery(name, qtype, opts...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	count := func(m *DNSMessage, rrtype uint16) int {
+		n := 0
+		for _, rr := range m.Authorities() {
+			if rr.RRType == rrtype {
+				n++
+			}
+		}
+		return n
+	}
+
+	// 第一个否定应答经过验证后缓存, 它本身也是安全的
+	resp := query("b.example", DNSTypeA, WithDNSSECOK())
+	if resp.Header.Flags.RCode != DNSRCodeNXDomain || !resp.Header.Flags.AuthenticData() || u.count() != 1 {
+		t.Fatalf("first: rcode %d, ad %v, queries %d", resp.Header.Flags.RCode, resp.Header.Flags.AuthenticData(), u.count())
+	}
+
+	// 被 a.example -> plain.example 覆盖, 通配符被 example -> a.example 覆盖
+	resp = query("C.example", DNSTypeA, WithDNSSECOK())
+	if resp.Header.Flags.RCode != DNSRCodeNXDomain || !resp.Header.Flags.AuthenticData() || u.count() != 1 || cache.Hits() != 1 {
+		t.Fatalf("synthesized: rcode %d, queries %d, hits %d", resp.Header.Flags.RCode, u.count(), cache.Hits())
+	}
+	if count(resp, DNSTypeSOA) != 1 || count(resp, DNSTypeNSEC) != 2 || count(resp, DNSTypeRRSIG) != 3 {
+		t.Fatalf("authority %+v", resp.Authorities())
+	}
+	if ttl := resp.Authorities()[0].TTL; ttl == 0 || ttl > 600 {
+		t.Fatalf("ttl %d", ttl)
+	}
+	if resp.Questions[0].QuestionName != "C.example" || !resp.DNSSECOK() {
+		t.Fatal("question or do bit not echoed")
+	}
+
+	// NODATA
+	if resp = query("www.example", DNSTypeAAAA); resp.Header.Flags.RCode != DNSRCodeSuccess || len(resp.Answers()) != 0 || u.count() != 1 {
+		t.Fatalf("nodata: rcode %d, queries %d", resp.Header.Flags.RCode, u.count())
+	}
+	// 没有 DO 时只保留 SOA, 也不带 AD
+	if resp.Header.Flags.AuthenticData() || count(resp, DNSTypeSOA) != 1 || len(resp.ResourceRecodes) != 1 {
+		t.Fatalf("without do: %+v", resp.ResourceRecodes)
+	}
+
+	// 存在的类型, 委派点之下的名字与 CD 请求都要转发
+	for i, c := range []struct {
+		name  string
+		qtype uint16
+		opts  []QueryOption
+	}{
+		{"www.example", DNSTypeA, nil},
+		{"x.sub.example", DNSTypeA, nil},
+		{"www.plain.example", DNSTypeA, nil},
+		{"sub.example", DNSTypeA, nil},
+		{"d.example", DNSTypeA, []QueryOption{WithCheckingDisabled()}},
+	} {
+		query(c.name, c.qtype, c.opts...)
+		if u.count() != i+2 {
+			t.Fatalf("%s was not forwarded", c.name)
+		}
+	}
+	if cache.Hits() != 2 {
+		t.Fatalf("hits %d", cache.Hits())
+	}
+}
+
+func TestNSEC3Denial(t *testing.T) {
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	names := []string{"example", "a.example", "www.example"}
+	types := map[string][]uint16{
+		"example":     {DNSTypeSOA, DNSTypeNS, DNSTypeDNSKEY, DNSTypeRRSIG, DNSTypeNSEC3PARAM},
+		"a.example":   {DNSTypeTXT, DNSTypeRRSIG},
+		"www.example": {DNSTypeA, DNSTypeRRSIG},
+	}
+	build := func(flags uint8) *NSECCache {
+		hashes := map[string][]byte{}
+		for _, name := range names {
+			hashes[name] = nsec3Hash(name, salt, 12)
+		}
+		sorted := append([]string{}, names...)
+		for i := range sorted {
+			for j := i + 1; j < len(sorted); j++ {
+				if string(hashes[sorted[j]]) < string(hashes[sorted[i]]) {
+					sorted[i], sorted[j] = sorted[j], sorted[i]
+				}
+			}
+		}
+		c := &NSECCache{}
+		expires := time.Now().Add(time.Hour)
+		c.addSOA("example", []*DNSResourceRecode{{Name: "example", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 600, RData: "ns.example. admin.example. 1 3600 600 86400 600"}}, expires)
+		for i, name := range sorted {
+			n := &NSEC3{HashAlgorithm: 1, Flags: flags, Iterations: 12, Salt: salt, NextHashed: hashes[sorted[(i+1)%len(sorted)]], Types: types[name]}
+			owner := strings.ToLower(nsec3Encoding.EncodeToString(hashes[name])) + ".example"
+			c.add("example", []*DNSResourceRecode{{Name: owner, RRType: DNSTypeNSEC3, Class: DNSClassIn, TTL: 600, Data: n.Pack()}}, expires)
+		}
+		return c
+	}
+	c := build(0)
+	resp := c.Synthesize(NewQuery("nope.example", DNSTypeA))
+	if resp == nil || resp.Header.Flags.RCode != DNSRCodeNXDomain || len(resp.Authorities()) < 3 {
+		t.Fatalf("nxdomain: %+v", resp)
+	}
+	if resp = c.Synthesize(NewQuery("www.example", DNSTypeMX)); resp == nil || resp.Header.Flags.RCode != DNSRCodeSuccess || len(resp.Authorities()) != 2 {
+		t.Fatalf("nodata: %+v", resp)
+	}
+	if resp = c.Synthesize(NewQuery("www.example", DNSTypeA)); resp != nil {
+		t.Fatal("existing type denied")
+	}
+	// opt-out 时不能证明名字不存在
+	optOut := build(nsec3FlagOptOut)
+	if resp = optOut.Synthesize(NewQuery("nope.example", DNSTypeA)); resp != nil {
+		t.Fatal("opt-out span used for nxdomain")
+	}
+	// 只能证明名字落在 opt-out 区间, 验证器把它当作不安全的应答
+	if !optOut.provesOptOut(NewQuery("nope.example", DNSTypeDS)) || c.provesOptOut(NewQuery("nope.example", DNSTypeDS)) || optOut.provesOptOut(NewQuery("www.example", DNSTypeDS)) {
+		t.Fatal("opt-out proof")
+	}
+}
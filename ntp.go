@@ -0,0 +1,211 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"time"
+)
+
+const (
+	ntpPacketLen  = 48
+	ntpVersion    = 4
+	ntpModeClient = 3
+	ntpModeServer = 4
+	ntpPort       = "123"
+
+	// 1900-01-01 到 1970-01-01 的秒数
+	ntpEpochOffset = 2208988800
+)
+
+var (
+	ErrNTPShortPacket     = errors.New("ntp packet too short")
+	ErrNTPMode            = errors.New("ntp response is not in server mode")
+	ErrNTPOriginMismatch  = errors.New("ntp originate timestamp mismatch")
+	ErrNTPUnsynchronized  = errors.New("ntp server clock is not synchronized")
+	ErrNTPKissOfDeath     = errors.New("ntp kiss-o'-death received")
+	ErrNTPStratum         = errors.New("ntp stratum out of range")
+	ErrNTPRootDispersion  = errors.New("ntp root dispersion too large")
+	ErrNTPZeroTransmit    = errors.New("ntp transmit timestamp is zero")
+	ErrNTPNoUsableServers = errors.New("no usable ntp server")
+)
+
+// NTPOptions 零值字段使用默认值
+type NTPOptions struct {
+	Timeout           time.Duration // 单个服务器超时, 默认 5s
+	MaxRootDispersion time.Duration // 默认 1s
+}
+
+// NTPResponse SNTP 查询结果
+type NTPResponse struct {
+	Server string
+
+	Time           time.Time     // 服务器发送时间
+	ClockOffset    time.Duration // 本地时钟加上该值即为服务器时钟
+	RTT            time.Duration
+	Stratum        uint8
+	Leap           uint8
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	ReferenceID    uint32
+	KissCode       string // 仅在收到 kiss-o'-death 时有效
+}
+
+type ntpTime uint64
+
+func toNTPTime(t time.Time) ntpTime {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return ntpTime(sec<<32 | frac)
+}
+
+func (t ntpTime) Time() time.Time {
+	sec := uint64(t) >> 32
+	frac := uint64(t) & 0xFFFFFFFF
+	nsec := frac * 1e9 >> 32
+	return time.Unix(int64(sec)-ntpEpochOffset, int64(nsec))
+}
+
+// ntpShort 16.16 定点数
+func ntpShort(v uint32) time.Duration {
+	return time.Duration(uint64(v) * 1e9 >> 16)
+}
+
+// QueryNTP 按 RFC 4330 向 server 发送一次 SNTP 请求, server 未指定端口时使用 123
+func QueryNTP(ctx context.Context, server string, opts *NTPOptions) (*NTPResponse, error) {
+	o := NTPOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.MaxRootDispersion <= 0 {
+		o.MaxRootDispersion = time.Second
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, ntpPort)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	req := make([]byte, ntpPacketLen)
+	req[0] = ntpVersion<<3 | ntpModeClient
+	start := time.Now()
+	origin := toNTPTime(start)
+	binary.BigEndian.PutUint64(req[40:48], uint64(origin))
+	if _, err := conn.Write(req); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	// 使用单调时钟计算往返时间
+	elapsed := time.Since(start)
+	end := start.Add(elapsed)
+
+	resp, err := parseNTP(buf[:n], origin, start, end)
+	if err != nil {
+		return nil, err
+	}
+	resp.Server = server
+	if err := resp.validate(&o); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+func parseNTP(b []byte, origin ntpTime, start, end time.Time) (*NTPResponse, error) {
+	if len(b) < ntpPacketLen {
+		return nil, ErrNTPShortPacket
+	}
+	if b[0]&0x07 != ntpModeServer {
+		return nil, ErrNTPMode
+	}
+	if ntpTime(binary.BigEndian.Uint64(b[24:32])) != origin {
+		return nil, ErrNTPOriginMismatch
+	}
+	receive := ntpTime(binary.BigEndian.Uint64(b[32:40]))
+	transmit := ntpTime(binary.BigEndian.Uint64(b[40:48]))
+	if transmit == 0 {
+		return nil, ErrNTPZeroTransmit
+	}
+
+	t2, t3 := receive.Time(), transmit.Time()
+	resp := &NTPResponse{
+		Time:           t3,
+		ClockOffset:    (t2.Sub(start) + t3.Sub(end)) / 2,
+		RTT:            end.Sub(start) - t3.Sub(t2),
+		Stratum:        b[1],
+		Leap:           b[0] >> 6,
+		RootDelay:      ntpShort(binary.BigEndian.Uint32(b[4:8])),
+		RootDispersion: ntpShort(binary.BigEndian.Uint32(b[8:12])),
+		ReferenceID:    binary.BigEndian.Uint32(b[12:16]),
+	}
+	if resp.RTT < 0 {
+		resp.RTT = 0
+	}
+	if resp.Stratum == 0 {
+		resp.KissCode = string(b[12:16])
+	}
+	return resp, nil
+}
+
+func (r *NTPResponse) validate(o *NTPOptions) error {
+	switch {
+	case r.Stratum == 0:
+		return errors.WithMessage(ErrNTPKissOfDeath, r.KissCode)
+	case r.Stratum > 15:
+		return ErrNTPStratum
+	case r.Leap == 3:
+		return ErrNTPUnsynchronized
+	case r.RootDispersion > o.MaxRootDispersion:
+		return ErrNTPRootDispersion
+	}
+	return nil
+}
+
+// QueryNTPServers 依次查询 servers, 返回通过检查且往返时间最短的结果
+func QueryNTPServers(ctx context.Context, servers []string, opts *NTPOptions) (*NTPResponse, error) {
+	var best *NTPResponse
+	var lastErr error = ErrNTPNoUsableServers
+	for _, server := range servers {
+		resp, err := QueryNTP(ctx, server, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if best == nil || resp.RTT < best.RTT {
+			best = resp
+		}
+	}
+	if best == nil {
+		return nil, lastErr
+	}
+	return best, nil
+}
+
+// QueryNTPPool 先通过 Resolver 解析 pool (如 pool.ntp.org), 再查询解析出的所有地址
+func (r *Resolver) QueryNTPPool(ctx context.Context, pool string, opts *NTPOptions) (*NTPResponse, error) {
+	addrs, err := r.LookupHost(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	return QueryNTPServers(ctx, addrs, opts)
+}
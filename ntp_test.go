@@ -0,0 +1,139 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startNTPServer 运行一个服务器时钟比本地快 skew 的 SNTP 服务器, modify 不为空时在发送前修改应答
+func startNTPServer(t *testing.T, skew time.Duration, modify func(resp []byte)) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			now := toNTPTime(time.Now().Add(skew))
+			resp := make([]byte, 48)
+			resp[0] = ntpVersion<<3 | ntpModeServer
+			resp[1] = 2
+			copy(resp[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(resp[32:40], uint64(now))
+			binary.BigEndian.PutUint64(resp[40:48], uint64(now))
+			if modify != nil {
+				modify(resp)
+			}
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQueryNTP(t *testing.T) {
+	// 服务器时钟比本地快 2s
+	skew := 2 * time.Second
+	resp, err := QueryNTP(context.Background(), startNTPServer(t, skew, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := resp.ClockOffset - skew; diff > 100*time.Millisecond || diff < -100*time.Millisecond {
+		t.Fatalf("offset = %v, want about %v", resp.ClockOffset, skew)
+	}
+	if resp.Stratum != 2 {
+		t.Fatalf("stratum = %d", resp.Stratum)
+	}
+}
+
+func TestNTPParse(t *testing.T) {
+	origin := toNTPTime(time.Now())
+	now := time.Now()
+	packet := func(modify func(b []byte)) []byte {
+		b := make([]byte, 48)
+		b[0] = ntpVersion<<3 | ntpModeServer
+		b[1] = 1
+		binary.BigEndian.PutUint64(b[24:32], uint64(origin))
+		binary.BigEndian.PutUint64(b[32:40], uint64(toNTPTime(now)))
+		binary.BigEndian.PutUint64(b[40:48], uint64(toNTPTime(now)))
+		if modify != nil {
+			modify(b)
+		}
+		return b
+	}
+	for name, c := range map[string]struct {
+		b   []byte
+		err error
+	}{
+		"ok":        {b: packet(nil)},
+		"short":     {b: packet(nil)[:47], err: ErrNTPShortPacket},
+		"mode":      {b: packet(func(b []byte) { b[0] = ntpVersion<<3 | ntpModeClient }), err: ErrNTPMode},
+		"origin":    {b: packet(func(b []byte) { b[31]++ }), err: ErrNTPOriginMismatch},
+		"transmit":  {b: packet(func(b []byte) { copy(b[40:48], make([]byte, 8)) }), err: ErrNTPZeroTransmit},
+		"kiss code": {b: packet(func(b []byte) { b[1] = 0; copy(b[12:16], "RATE") })},
+	} {
+		resp, err := parseNTP(c.b, origin, now, now)
+		if err != c.err {
+			t.Fatalf("%s: err = %v, want %v", name, err, c.err)
+		}
+		if name == "kiss code" && resp.KissCode != "RATE" {
+			t.Fatalf("kiss code %q", resp.KissCode)
+		}
+	}
+}
+
+func TestNTPValidate(t *testing.T) {
+	o := &NTPOptions{MaxRootDispersion: time.Second}
+	for _, c := range []struct {
+		resp NTPResponse
+		err  error
+	}{
+		{NTPResponse{Stratum: 1}, nil},
+		{NTPResponse{Stratum: 15, Leap: 1, RootDispersion: time.Second}, nil},
+		{NTPResponse{Stratum: 0, KissCode: "DENY"}, ErrNTPKissOfDeath},
+		{NTPResponse{Stratum: 16}, ErrNTPStratum},
+		{NTPResponse{Stratum: 2, Leap: 3}, ErrNTPUnsynchronized},
+		{NTPResponse{Stratum: 2, RootDispersion: 2 * time.Second}, ErrNTPRootDispersion},
+	} {
+		if err := c.resp.validate(o); errors.Cause(err) != c.err {
+			t.Fatalf("%+v: err = %v, want %v", c.resp, err, c.err)
+		}
+	}
+	kod := &NTPResponse{KissCode: "RATE"}
+	if err := kod.validate(o); err == nil || err.Error() != "RATE: "+ErrNTPKissOfDeath.Error() {
+		t.Fatalf("kiss-o'-death err = %v", err)
+	}
+}
+
+func TestQueryNTPServers(t *testing.T) {
+	kod := startNTPServer(t, 0, func(b []byte) { b[1] = 0; copy(b[12:16], "RATE") })
+	unsynced := startNTPServer(t, 0, func(b []byte) { b[0] |= 3 << 6 })
+	stratum := startNTPServer(t, 0, func(b []byte) { b[1] = 16 })
+	spoofed := startNTPServer(t, 0, func(b []byte) { b[31]++ })
+	// 时间戳在等待之前取得, 等待的 50ms 计入往返时间
+	slow := startNTPServer(t, time.Second, func(b []byte) { time.Sleep(50 * time.Millisecond) })
+	fast := startNTPServer(t, 3*time.Second, nil)
+	ctx := context.Background()
+
+	resp, err := QueryNTPServers(ctx, []string{kod, unsynced, slow, stratum, fast, spoofed}, &NTPOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Server != fast {
+		t.Fatalf("selected %s, want %s", resp.Server, fast)
+	}
+	if _, err := QueryNTPServers(ctx, []string{kod, unsynced}, nil); errors.Cause(err) != ErrNTPUnsynchronized {
+		t.Fatalf("no usable server: %v", err)
+	}
+	if _, err := QueryNTPServers(ctx, nil, nil); err != ErrNTPNoUsableServers {
+		t.Fatalf("no servers: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	odohMIME        = "application/oblivious-dns-message"
+	odohConfigsPath = "/.well-known/odohconfigs"
+	odohVersion     = 0x0001
+	odohQuery       = 0x01
+	odohResponse    = 0x02
+	// odohPadBlock 明文填充到该长度的倍数, 隐藏查询的长度
+	odohPadBlock = 128
+)
+
+var (
+	ErrODoHConfig   = errors.New("no supported odoh config")
+	ErrODoHResponse = errors.New("invalid odoh response")
+)
+
+// ODoHTransport Oblivious DoH 客户端 (RFC 9230), server 为目标的 URL, 例如 https://odoh.cloudflare-dns.com/dns-query.
+// 查询用目标的 HPKE 公钥加密后经 Proxy 转发, 代理只知道客户端的地址, 目标只知道查询内容.
+// 目标的配置从 /.well-known/odohconfigs 获取并缓存, 解密失败时重新获取
+type ODoHTransport struct {
+	// Proxy 代理的 URL, 例如 https://proxy.example/proxy. 为空时直接发送给目标, 不隐藏客户端地址
+	Proxy string
+	// Client 为空时使用与 HTTPSTransport 相同的默认客户端
+	Client *http.Client
+
+	mu      sync.Mutex
+	configs map[string]*odohConfig
+}
+
+// odohConfig 目标的 ObliviousDoHConfigContents
+type odohConfig struct {
+	publicKey []byte
+	keyID     []byte
+}
+
+func (t *ODoHTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	target, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	config, err := t.config(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	query, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	plain := odohPlaintext(query)
+	enc, hctx, err := hpkeSetupBaseS(config.publicKey, []byte("odoh query"))
+	if err != nil {
+		return nil, err
+	}
+	sealed := append(enc, hctx.Seal(odohAAD(odohQuery, config.keyID), plain)...)
+
+	body, err := t.post(ctx, t.endpoint(target), odohMessage(odohQuery, config.keyID, sealed))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := odohOpenResponse(hctx, plain, body)
+	if err != nil {
+		// 目标可能已经轮换密钥, 下次重新获取配置
+		t.mu.Lock()
+		delete(t.configs, target.Host)
+		t.mu.Unlock()
+		return nil, err
+	}
+	if resp.Header.TxID != req.Header.TxID {
+		return nil, ErrTxIDMismatch
+	}
+	return resp, nil
+}
+
+// endpoint 发送查询的 URL, 使用代理时目标由 targethost 与 targetpath 参数给出
+func (t *ODoHTransport) endpoint(target *url.URL) string {
+	if t.Proxy == "" {
+		return target.String()
+	}
+	q := url.Values{}
+	q.Set("targethost", target.Host)
+	q.Set("targetpath", target.EscapedPath())
+	if strings.Contains(t.Proxy, "?") {
+		return t.Proxy + "&" + q.Encode()
+	}
+	return t.Proxy + "?" + q.Encode()
+}
+
+func (t *ODoHTransport) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return defaultDoHClient
+}
+
+func (t *ODoHTransport) post(ctx context.Context, endpoint string, body []byte) ([]byte, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", odohMIME)
+	httpReq.Header.Set("Accept", odohMIME)
+	return t.do(httpReq)
+}
+
+func (t *ODoHTransport) do(httpReq *http.Request) ([]byte, error) {
+	httpResp, err := t.client().Do(httpReq)
+	if err != nil {
+		return nil, errors.WithMessage(err, "https request error")
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(ErrDoHStatus, httpResp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, 0xFFFF))
+	if err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	return body, nil
+}
+
+// config 返回目标的配置, 没有缓存时从目标获取
+func (t *ODoHTransport) config(ctx context.Context, target *url.URL) (*odohConfig, error) {
+	t.mu.Lock()
+	config := t.configs[target.Host]
+	t.mu.Unlock()
+	if config != nil {
+		return config, nil
+	}
+	u := url.URL{Scheme: target.Scheme, Host: target.Host, Path: odohConfigsPath}
+	httpReq, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithMessage(err, "fetch odoh configs")
+	}
+	if config, err = parseODoHConfigs(body); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	if t.configs == nil {
+		t.configs = map[string]*odohConfig{}
+	}
+	t.configs[target.Host] = config
+	t.mu.Unlock()
+	return config, nil
+}
+
+// parseODoHConfigs 解析 ObliviousDoHConfigs, 返回第一个支持的配置:
+// length(2) { version(2) length(2) { kem(2) kdf(2) aead(2) public_key<2> } }
+func parseODoHConfigs(b []byte) (*odohConfig, error) {
+	if len(b) < 2 || len(b) != 2+int(binary.BigEndian.Uint16(b)) {
+		return nil, errors.WithMessage(ErrODoHConfig, "bad length")
+	}
+	for rest := b[2:]; len(rest) > 0; {
+		if len(rest) < 4 || len(rest) < 4+int(binary.BigEndian.Uint16(rest[2:])) {
+			return nil, errors.WithMessage(ErrODoHConfig, "truncated config")
+		}
+		version, contents := binary.BigEndian.Uint16(rest), rest[4:4+int(binary.BigEndian.Uint16(rest[2:]))]
+		rest = rest[4+len(contents):]
+		if version != odohVersion || len(contents) < 8 {
+			continue
+		}
+		kem, kdf, aead := binary.BigEndian.Uint16(contents), binary.BigEndian.Uint16(contents[2:]), binary.BigEndian.Uint16(contents[4:])
+		key := contents[8:]
+		if kem != hpkeKEMX25519 || kdf != hpkeKDFSHA256 || aead != hpkeAES128GCM ||
+			len(key) != int(binary.BigEndian.Uint16(contents[6:])) || len(key) != 32 {
+			continue
+		}
+		return &odohConfig{
+			publicKey: append([]byte{}, key...),
+			keyID:     odohExpand(hkdf.Extract(sha256.New, contents, nil), "odoh key id", sha256.Size),
+		}, nil
+	}
+	return nil, ErrODoHConfig
+}
+
+// odohPlaintext ObliviousDoHMessagePlaintext: dns_message<2> padding<2>
+func odohPlaintext(msg []byte) []byte {
+	n := 4 + len(msg)
+	pad := (n+odohPadBlock-1)/odohPadBlock*odohPadBlock - n
+	b := make([]byte, n+pad)
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	binary.BigEndian.PutUint16(b[2+len(msg):], uint16(pad))
+	return b
+}
+
+// odohMessage ObliviousDoHMessage: message_type(1) key_id<2> encrypted_message<2>
+func odohMessage(typ byte, keyID, sealed []byte) []byte {
+	b := append(odohAAD(typ, keyID), byte(len(sealed)>>8), byte(len(sealed)))
+	return append(b, sealed...)
+}
+
+// odohAAD message_type(1) key_id<2>
+func odohAAD(typ byte, keyID []byte) []byte {
+	return append([]byte{typ, byte(len(keyID) >> 8), byte(len(keyID))}, keyID...)
+}
+
+// odohOpenResponse 用查询的 HPKE 上下文导出的密钥解密响应
+func odohOpenResponse(hctx *hpkeContext, plain, body []byte) (*DNSMessage, error) {
+	if len(body) < 3 || body[0] != odohResponse {
+		return nil, ErrODoHResponse
+	}
+	n := int(binary.BigEndian.Uint16(body[1:]))
+	if len(body) < 3+n+2 || len(body) != 3+n+2+int(binary.BigEndian.Uint16(body[3+n:])) {
+		return nil, errors.WithMessage(ErrODoHResponse, "bad length")
+	}
+	nonce, sealed := body[3:3+n], body[3+n+2:]
+	aead, aeadNonce, err := odohResponseKey(hctx, plain, nonce)
+	if err != nil {
+		return nil, err
+	}
+	out, err := aead.Open(nil, aeadNonce, sealed, odohAAD(odohResponse, nonce))
+	if err != nil {
+		return nil, errors.WithMessage(ErrODoHResponse, "decryption failed")
+	}
+	if len(out) < 2 || len(out) < 2+int(binary.BigEndian.Uint16(out)) {
+		return nil, errors.WithMessage(ErrODoHResponse, "bad plaintext")
+	}
+	return Unpack(out[2 : 2+int(binary.BigEndian.Uint16(out))])
+}
+
+// odohResponseKey 由导出的密钥, 查询明文与响应 nonce 派生响应的 AEAD 密钥与 nonce (RFC 9230 6.4)
+func odohResponseKey(hctx *hpkeContext, plain, nonce []byte) (cipher.AEAD, []byte, error) {
+	secret := hctx.Export([]byte("odoh response"), 16)
+	salt := append(append(append([]byte{}, plain...), byte(len(nonce)>>8), byte(len(nonce))), nonce...)
+	prk := hkdf.Extract(sha256.New, secret, salt)
+	block, err := aes.NewCipher(odohExpand(prk, "odoh key", 16))
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, odohExpand(prk, "odoh nonce", 12), nil
+}
+
+func odohExpand(prk []byte, info string, length int) []byte {
+	out := make([]byte, length)
+	_, _ = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), out)
+	return out
+}
@@ -0,0 +1,126 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"golang.org/x/crypto/curve25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// odohTarget 测试用的目标, 解密查询并以 192.0.2.9 回答
+func odohTarget(t *testing.T) *httptest.Server {
+	skR := make([]byte, curve25519.ScalarSize)
+	_, _ = rand.Read(skR)
+	pkR, err := curve25519.X25519(skR, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01, 0x00, 32}
+	contents = append(contents, pkR...)
+	config := append([]byte{0x00, 0x01, 0x00, byte(len(contents))}, contents...)
+	// 第一个配置使用不支持的版本, 应该被跳过
+	configs := append([]byte{0xff, 0x00, 0x00, 0x01, 0x00}, config...)
+	configs = append([]byte{0x00, byte(len(configs))}, configs...)
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == odohConfigsPath {
+			_, _ = w.Write(configs)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != odohMIME || len(body) < 3 || body[0] != odohQuery {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		n := int(binary.BigEndian.Uint16(body[1:]))
+		keyID, sealed := body[3:3+n], body[3+n+2:]
+		enc, ct := sealed[:32], sealed[32:]
+		dh, err := curve25519.X25519(skR, enc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hctx, _ := hpkeKeySchedule(hpkeSharedSecret(dh, enc, pkR), []byte("odoh query"))
+		plain, err := hctx.Open(odohAAD(odohQuery, keyID), ct)
+		if err != nil {
+			http.Error(w, "decryption failed", http.StatusBadRequest)
+			return
+		}
+		req, err := Unpack(plain[2 : 2+binary.BigEndian.Uint16(plain)])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := NewReply(req)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: req.Questions[0].QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.9"}}
+		resp.Header.AnswerRRs = 1
+		toByte, _ := resp.ToByte()
+
+		nonce := make([]byte, 16)
+		_, _ = rand.Read(nonce)
+		aead, aeadNonce, _ := odohResponseKey(hctx, plain, nonce)
+		out := aead.Seal(nil, aeadNonce, odohPlaintext(toByte), odohAAD(odohResponse, nonce))
+		w.Header().Set("Content-Type", odohMIME)
+		_, _ = w.Write(odohMessage(odohResponse, nonce, out))
+	}))
+}
+
+func TestODoHTransport(t *testing.T) {
+	target := odohTarget(t)
+	defer target.Close()
+
+	var mu sync.Mutex
+	var relayed [][]byte
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		relayed = append(relayed, body)
+		mu.Unlock()
+		u := "https://" + r.URL.Query().Get("targethost") + r.URL.Query().Get("targetpath")
+		resp, err := target.Client().Post(u, r.Header.Get("Content-Type"), bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		out, _ := ioutil.ReadAll(resp.Body)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = w.Write(out)
+	}))
+	defer proxy.Close()
+
+	tr := &ODoHTransport{Proxy: proxy.URL + "/proxy", Client: target.Client()}
+	r := &Resolver{Server: target.URL + "/dns-query", Transport: tr}
+	for i := 0; i < 2; i++ {
+		ips, err := r.LookupHost(context.Background(), "secret.example.com")
+		if err != nil || len(ips) != 1 || ips[0] != "192.0.2.9" {
+			t.Fatalf("LookupHost = %v, %v", ips, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(relayed) == 0 {
+		t.Fatal("query not relayed through the proxy")
+	}
+	for _, body := range relayed {
+		if bytes.Contains(body, []byte("secret")) {
+			t.Fatal("proxy saw the plaintext query")
+		}
+	}
+
+	// 直接发送给目标
+	direct := &Resolver{Server: target.URL + "/dns-query", Transport: &ODoHTransport{Client: target.Client()}}
+	if ips, err := direct.LookupHost(context.Background(), "www.example.com"); err != nil || len(ips) != 1 {
+		t.Fatalf("direct LookupHost = %v, %v", ips, err)
+	}
+
+	if _, err := parseODoHConfigs([]byte{0x00, 0x04, 0x00, 0x02, 0x00, 0x00}); err != ErrODoHConfig {
+		t.Fatalf("unsupported configs: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package netx
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+var (
+	ouiMu sync.RWMutex
+	// ouiVendors 内置的常见厂商, 完整列表可以通过 LoadOUI 载入
+	ouiVendors = map[[3]byte]string{
+		{0x00, 0x00, 0x0C}: "Cisco",
+		{0x00, 0x03, 0x93}: "Apple",
+		{0x00, 0x04, 0x4B}: "NVIDIA",
+		{0x00, 0x05, 0x69}: "VMware",
+		{0x00, 0x0C, 0x29}: "VMware",
+		{0x00, 0x0D, 0xB9}: "PC Engines",
+		{0x00, 0x11, 0x32}: "Synology",
+		{0x00, 0x15, 0x5D}: "Microsoft Hyper-V",
+		{0x00, 0x16, 0x3E}: "Xen",
+		{0x00, 0x17, 0x88}: "Philips Lighting",
+		{0x00, 0x1A, 0x11}: "Google",
+		{0x00, 0x1B, 0x21}: "Intel",
+		{0x00, 0x1C, 0x42}: "Parallels",
+		{0x00, 0x1C, 0xB3}: "Apple",
+		{0x00, 0x25, 0x90}: "Super Micro",
+		{0x00, 0x50, 0x56}: "VMware",
+		{0x00, 0x90, 0xA9}: "Western Digital",
+		{0x00, 0xE0, 0x4C}: "Realtek",
+		{0x02, 0x42, 0xAC}: "Docker",
+		{0x08, 0x00, 0x27}: "VirtualBox",
+		{0x18, 0xB4, 0x30}: "Nest Labs",
+		{0x3C, 0x5A, 0xB4}: "Google",
+		{0x52, 0x54, 0x00}: "QEMU/KVM",
+		{0xB8, 0x27, 0xEB}: "Raspberry Pi",
+		{0xDC, 0xA6, 0x32}: "Raspberry Pi",
+		{0xE4, 0x5F, 0x01}: "Raspberry Pi",
+		{0xF0, 0x9F, 0xC2}: "Ubiquiti",
+	}
+)
+
+// OUIVendor 返回 mac 前三字节对应的厂商, 未知时返回空字符串
+func OUIVendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+	ouiMu.RLock()
+	defer ouiMu.RUnlock()
+	return ouiVendors[[3]byte{mac[0], mac[1], mac[2]}]
+}
+
+// LoadOUI 从 IEEE 发布的 oui.txt 中载入厂商, 与已有条目合并.
+// 只解析 "XX-XX-XX   (hex)  Vendor" 格式的行
+func LoadOUI(r io.Reader) error {
+	vendors := map[[3]byte]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, "(hex)")
+		if i < 0 {
+			continue
+		}
+		b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(line[:i]), "-", ""))
+		if err != nil || len(b) != 3 {
+			continue
+		}
+		vendors[[3]byte{b[0], b[1], b[2]}] = strings.TrimSpace(line[i+len("(hex)"):])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	ouiMu.Lock()
+	defer ouiMu.Unlock()
+	for k, v := range vendors {
+		ouiVendors[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	ipv4HeaderLen = 20
+	icmpHeaderLen = 8
+	minIPv4MTU    = 68
+)
+
+var ErrPMTUUnreachable = errors.New("target did not answer any probe")
+
+// PMTUOptions 零值字段使用默认值. 探测需要 raw socket 权限, 目前只支持 Linux
+type PMTUOptions struct {
+	Min     int           // 默认 576
+	Max     int           // 默认 1500
+	Timeout time.Duration // 单次探测超时, 默认 1s
+	Retries int           // 超时后重试次数, 默认 2
+}
+
+// DiscoverPMTU 向 host 发送设置了 DF 的 ICMP Echo, 二分查找可用的路径 MTU.
+// 收到 Fragmentation Needed 时直接使用其中的下一跳 MTU 缩小范围
+func DiscoverPMTU(ctx context.Context, host string, opts *PMTUOptions) (int, error) {
+	o := pmtuDefaults(opts)
+	dst, err := traceTarget(ctx, host, nil)
+	if err != nil {
+		return 0, err
+	}
+	p, err := newPMTUProber(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer p.conn.Close()
+	return searchPMTU(ctx, p, &o)
+}
+
+// pmtuDefaults 填充默认值, Max 小于 Min 时以 Max 为准, 但都不小于 IPv4 的最小 MTU
+func pmtuDefaults(opts *PMTUOptions) PMTUOptions {
+	o := PMTUOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Max <= 0 {
+		o.Max = 1500
+	}
+	if o.Max > 0xFFFF {
+		o.Max = 0xFFFF
+	}
+	if o.Min <= 0 {
+		o.Min = 576
+	}
+	if o.Min > o.Max {
+		o.Min = o.Max
+	}
+	if o.Min < minIPv4MTU {
+		o.Min = minIPv4MTU
+	}
+	if o.Max < o.Min {
+		o.Max = o.Min
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+	if o.Retries <= 0 {
+		o.Retries = 2
+	}
+	return o
+}
+
+// pmtuTrier 发送一次探测, 见 pmtuProber.try
+type pmtuTrier interface {
+	try(ctx context.Context, size int, o *PMTUOptions) (bool, int, error)
+}
+
+// searchPMTU 在 [o.Min, o.Max] 中二分查找 p 能通过的最大报文长度
+func searchPMTU(ctx context.Context, p pmtuTrier, o *PMTUOptions) (int, error) {
+	// 先确认目标可达
+	if ok, _, err := p.try(ctx, o.Min, o); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, ErrPMTUUnreachable
+	}
+
+	low, high := o.Min, o.Max
+	for low < high {
+		mid := (low + high + 1) / 2
+		ok, nextHop, err := p.try(ctx, mid, o)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case ok:
+			low = mid
+		case nextHop >= low && nextHop < mid:
+			high = nextHop
+		default:
+			high = mid - 1
+		}
+	}
+	return low, nil
+}
+
+type pmtuProber struct {
+	dst  net.IP
+	conn *net.IPConn
+	id   int
+	seq  int
+}
+
+func newPMTUProber(dst net.IP) (*pmtuProber, error) {
+	conn, err := net.ListenIP("ip4:icmp", &net.IPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen icmp error")
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = setSockoptDF(fd)
+	}); err != nil {
+		serr = err
+	}
+	if serr != nil {
+		conn.Close()
+		return nil, errors.WithMessage(serr, "set DF error")
+	}
+	return &pmtuProber{dst: dst, conn: conn, id: os.Getpid() & 0xFFFF}, nil
+}
+
+// try 发送总长度为 size 的 IP 报文. 返回是否收到回应以及 Fragmentation Needed 中的下一跳 MTU
+func (p *pmtuProber) try(ctx context.Context, size int, o *PMTUOptions) (bool, int, error) {
+	for i := 0; i <= o.Retries; i++ {
+		if err := ctx.Err(); err != nil {
+			return false, 0, err
+		}
+		p.seq = (p.seq + 1) & 0xFFFF
+		msg := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: p.id, Seq: p.seq, Data: make([]byte, size-ipv4HeaderLen-icmpHeaderLen)},
+		}
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			return false, 0, err
+		}
+		if _, err := p.conn.WriteTo(b, &net.IPAddr{IP: p.dst}); err != nil {
+			// 超过本机已知的 MTU 时内核直接拒绝发送
+			if msgTooLong(err) {
+				return false, 0, nil
+			}
+			return false, 0, err
+		}
+		ok, nextHop, timeout, err := p.wait(ctx, o.Timeout)
+		if err != nil {
+			return false, 0, err
+		}
+		if !timeout {
+			return ok, nextHop, nil
+		}
+	}
+	return false, 0, nil
+}
+
+func (p *pmtuProber) wait(ctx context.Context, timeout time.Duration) (ok bool, nextHop int, timedOut bool, err error) {
+	deadline := time.Now().Add(timeout)
+	if d, has := ctx.Deadline(); has && d.Before(deadline) {
+		deadline = d
+	}
+	if err := p.conn.SetReadDeadline(deadline); err != nil {
+		return false, 0, false, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, isNet := err.(net.Error); isNet && ne.Timeout() {
+				return false, 0, true, nil
+			}
+			return false, 0, false, err
+		}
+		msg, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil {
+			continue
+		}
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if msg.Type == ipv4.ICMPTypeEchoReply && body.ID == p.id && body.Seq == p.seq {
+				return true, 0, false, nil
+			}
+		case *icmp.DstUnreach:
+			// code 4: 需要分片但设置了 DF, 报文第 6-7 字节为下一跳 MTU
+			if msg.Code == 4 && n >= 8 && p.quotes(body.Data) {
+				return false, int(binary.BigEndian.Uint16(buf[6:8])), false, nil
+			}
+		}
+	}
+}
+
+// quotes 判断 ICMP 差错报文引用的是否是本次探测
+func (p *pmtuProber) quotes(inner []byte) bool {
+	if len(inner) < ipv4HeaderLen {
+		return false
+	}
+	ihl := int(inner[0]&0x0F) * 4
+	if len(inner) < ihl+icmpHeaderLen || !net.IP(inner[16:20]).Equal(p.dst) {
+		return false
+	}
+	header := inner[ihl:]
+	return int(binary.BigEndian.Uint16(header[4:6])) == p.id && int(binary.BigEndian.Uint16(header[6:8])) == p.seq
+}
@@ -0,0 +1,79 @@
+package netx
+
+import (
+	"context"
+	"math/bits"
+	"testing"
+)
+
+// fakePMTU 模拟一条路径: 不超过 mtu 的报文得到回应, 更大的报文被丢弃,
+// nextHop 不为 0 时改为返回带有该下一跳 MTU 的 Fragmentation Needed
+type fakePMTU struct {
+	mtu     int
+	nextHop int
+	sizes   []int
+}
+
+func (f *fakePMTU) try(ctx context.Context, size int, o *PMTUOptions) (bool, int, error) {
+	f.sizes = append(f.sizes, size)
+	if size <= f.mtu {
+		return true, 0, nil
+	}
+	return false, f.nextHop, nil
+}
+
+func TestSearchPMTU(t *testing.T) {
+	cases := []struct {
+		name string
+		opts *PMTUOptions
+		path fakePMTU
+		want int
+	}{
+		{"ethernet", nil, fakePMTU{mtu: 1500}, 1500},
+		{"black hole", nil, fakePMTU{mtu: 1400}, 1400},
+		{"fragmentation needed", nil, fakePMTU{mtu: 1280, nextHop: 1280}, 1280},
+		// 比 Min 还小的下一跳 MTU 不可信, 按普通的失败处理
+		{"bogus next hop", nil, fakePMTU{mtu: 1280, nextHop: 100}, 1280},
+		// 下一跳 MTU 偏大时继续二分
+		{"optimistic next hop", nil, fakePMTU{mtu: 1200, nextHop: 1300}, 1200},
+		{"minimum", nil, fakePMTU{mtu: 576}, 576},
+		{"jumbo capped by max", nil, fakePMTU{mtu: 9000}, 1500},
+		{"custom range", &PMTUOptions{Min: 1000, Max: 9000}, fakePMTU{mtu: 8000, nextHop: 8000}, 8000},
+		{"max below default min", &PMTUOptions{Max: 500}, fakePMTU{mtu: 1500}, 500},
+		{"min below ipv4 minimum", &PMTUOptions{Min: 1, Max: 10}, fakePMTU{mtu: 1500}, minIPv4MTU},
+	}
+	for _, c := range cases {
+		o := pmtuDefaults(c.opts)
+		path := c.path
+		got, err := searchPMTU(context.Background(), &path, &o)
+		if err != nil || got != c.want {
+			t.Fatalf("%s: %d, %v, probes %v", c.name, got, err, path.sizes)
+		}
+		for _, size := range path.sizes {
+			if size < o.Min || size > o.Max {
+				t.Fatalf("%s: probe %d outside [%d, %d]", c.name, size, o.Min, o.Max)
+			}
+		}
+		// 二分查找的探测次数不超过范围的对数加上确认可达的一次
+		if len(path.sizes) > 2+bits.Len(uint(o.Max-o.Min)) {
+			t.Fatalf("%s: %d probes %v", c.name, len(path.sizes), path.sizes)
+		}
+	}
+
+	o := pmtuDefaults(nil)
+	if _, err := searchPMTU(context.Background(), &fakePMTU{mtu: 100}, &o); err != ErrPMTUUnreachable {
+		t.Fatalf("unreachable: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := searchPMTU(ctx, &cancelledPMTU{}, &o); err != context.Canceled {
+		t.Fatalf("cancelled: %v", err)
+	}
+}
+
+// cancelledPMTU 与 pmtuProber.try 一样在 ctx 结束后返回其错误
+type cancelledPMTU struct{}
+
+func (cancelledPMTU) try(ctx context.Context, size int, o *PMTUOptions) (bool, int, error) {
+	return false, 0, ctx.Err()
+}
@@ -0,0 +1,180 @@
+package netx
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPoisonThreshold = 10
+	defaultPoisonWindow    = time.Minute
+	defaultPoisonTCPOnly   = 10 * time.Minute
+	// poisonBirthdayLimit 对同一上游同时等待的相同查询超过该数量时视为生日攻击的迹象
+	poisonBirthdayLimit = 2
+)
+
+// PoisonEvent 可能是缓存投毒的迹象
+type PoisonEvent int
+
+const (
+	// PoisonTxIDMismatch 问题相同但 TxID 不同的响应, 可能是在猜测 TxID
+	PoisonTxIDMismatch PoisonEvent = iota
+	// PoisonUnsolicited 与请求的 TxID 和问题都不匹配, 或无法解析的响应
+	PoisonUnsolicited
+	// PoisonBirthday 对同一上游同时有多个相同的查询在等待, 伪造者一次可以命中其中任意一个
+	PoisonBirthday
+)
+
+func (e PoisonEvent) String() string {
+	switch e {
+	case PoisonTxIDMismatch:
+		return "txid-mismatch"
+	case PoisonUnsolicited:
+		return "unsolicited"
+	case PoisonBirthday:
+		return "birthday"
+	}
+	return "PoisonEvent(" + strconv.Itoa(int(e)) + ")"
+}
+
+// PoisonStats 一个上游的可疑事件计数
+type PoisonStats struct {
+	TxIDMismatch uint64    `json:"txid_mismatch"`
+	Unsolicited  uint64    `json:"unsolicited"`
+	Birthday     uint64    `json:"birthday"`
+	TCPOnlyUntil time.Time `json:"tcp_only_until,omitempty"` // 零值表示没有切换到 TCP
+}
+
+// PoisonGuard 按上游统计可能的缓存投毒迹象, 在 Window 内累计 Threshold 个事件时调用 OnAlert,
+// 并在 TCPOnly 时长内让使用它的 UDPTransport 改用 TCP. 零值可用, 可以并发使用
+type PoisonGuard struct {
+	// Threshold 默认 10
+	Threshold int
+	// Window 默认 1 分钟
+	Window time.Duration
+	// TCPOnly 切换到 TCP 的时长, 默认 10 分钟
+	TCPOnly time.Duration
+	// OnAlert 触发阈值时调用, 不能阻塞
+	OnAlert func(server string, stats PoisonStats)
+
+	mu      sync.Mutex
+	servers map[string]*poisonState
+}
+
+type poisonState struct {
+	stats   PoisonStats
+	recent  []time.Time
+	waiting map[string]int
+}
+
+func (g *PoisonGuard) state(server string) *poisonState {
+	if g.servers == nil {
+		g.servers = map[string]*poisonState{}
+	}
+	s := g.servers[server]
+	if s == nil {
+		s = &poisonState{waiting: map[string]int{}}
+		g.servers[server] = s
+	}
+	return s
+}
+
+// Report 记录 server 的一个可疑事件, 供其它 Transport 使用
+func (g *PoisonGuard) Report(server string, event PoisonEvent) {
+	threshold, window, tcpOnly := g.Threshold, g.Window, g.TCPOnly
+	if threshold <= 0 {
+		threshold = defaultPoisonThreshold
+	}
+	if window <= 0 {
+		window = defaultPoisonWindow
+	}
+	if tcpOnly <= 0 {
+		tcpOnly = defaultPoisonTCPOnly
+	}
+	now := time.Now()
+	g.mu.Lock()
+	s := g.state(server)
+	switch event {
+	case PoisonTxIDMismatch:
+		s.stats.TxIDMismatch++
+	case PoisonUnsolicited:
+		s.stats.Unsolicited++
+	case PoisonBirthday:
+		s.stats.Birthday++
+	}
+	kept := s.recent[:0]
+	for _, t := range s.recent {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+	s.recent = append(kept, now)
+	alert := len(s.recent) >= threshold
+	if alert {
+		s.recent = s.recent[:0]
+		s.stats.TCPOnlyUntil = now.Add(tcpOnly)
+	}
+	stats := s.stats
+	g.mu.Unlock()
+	if alert && g.OnAlert != nil {
+		g.OnAlert(server, stats)
+	}
+}
+
+// UseTCP server 当前是否应只使用 TCP
+func (g *PoisonGuard) UseTCP(server string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.servers[server]
+	return s != nil && time.Now().Before(s.stats.TCPOnlyUntil)
+}
+
+// Stats 返回 server 的计数
+func (g *PoisonGuard) Stats(server string) PoisonStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s := g.servers[server]; s != nil {
+		return s.stats
+	}
+	return PoisonStats{}
+}
+
+// begin 记录一个等待中的查询, 返回结束时调用的函数
+func (g *PoisonGuard) begin(server string, req *DNSMessage) func() {
+	if len(req.Questions) == 0 {
+		return func() {}
+	}
+	q := req.Questions[0]
+	key := strings.ToLower(q.QuestionName) + "/" + strconv.Itoa(int(q.QuestionType)) + "/" + strconv.Itoa(int(q.QuestionClass))
+	g.mu.Lock()
+	s := g.state(server)
+	s.waiting[key]++
+	birthday := s.waiting[key] > poisonBirthdayLimit
+	g.mu.Unlock()
+	if birthday {
+		g.Report(server, PoisonBirthday)
+	}
+	return func() {
+		g.mu.Lock()
+		if s.waiting[key]--; s.waiting[key] <= 0 {
+			delete(s.waiting, key)
+		}
+		g.mu.Unlock()
+	}
+}
+
+// sameQuestion 响应的问题是否与请求相同, 名字不区分大小写
+func sameQuestion(req, resp *DNSMessage) bool {
+	if len(req.Questions) != len(resp.Questions) {
+		return false
+	}
+	for i, q := range req.Questions {
+		r := resp.Questions[i]
+		if !strings.EqualFold(normalizeDomain(q.QuestionName), normalizeDomain(r.QuestionName)) || q.QuestionType != r.QuestionType || q.QuestionClass != r.QuestionClass {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package netx
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestPoisonGuard(t *testing.T) {
+	conn, ln := listenSamePort(t)
+	tcp := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, "192.0.2.53")}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	go tcp.ServeTCP(ln)
+
+	// udp 上每个回答之前先发送一个 TxID 错误与一个问题错误的响应
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := Unpack(buf[:n])
+			if err != nil {
+				continue
+			}
+			resp := NewReply(req)
+			resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Questions[0].QuestionName, "192.0.2.1")}
+			resp.Header.AnswerRRs = 1
+			good, _ := resp.ToByte()
+			resp.Header.TxID++
+			spoofed, _ := resp.ToByte()
+			resp.Header.TxID--
+			resp.Questions = []*DNSQuestion{{QuestionName: "other.example.com", QuestionType: DNSTypeA, QuestionClass: DNSClassIn}}
+			resp.Header.Questions = 1
+			unsolicited, _ := resp.ToByte()
+			for _, b := range [][]byte{spoofed, unsolicited, good} {
+				_, _ = conn.WriteTo(b, addr)
+			}
+		}
+	}()
+
+	server := conn.LocalAddr().String()
+	var mu sync.Mutex
+	var alerts []PoisonStats
+	g := &PoisonGuard{Threshold: 4, OnAlert: func(s string, stats PoisonStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		if s == server {
+			alerts = append(alerts, stats)
+		}
+	}}
+	r := &Resolver{Server: server, Transport: UDPTransport{Guard: g}}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if addrs, err := r.LookupHost(ctx, "www.example.com"); err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Fatalf("udp LookupHost = %v, %v", addrs, err)
+		}
+	}
+	stats := g.Stats(server)
+	if stats.TxIDMismatch != 2 || stats.Unsolicited != 2 || stats.TCPOnlyUntil.IsZero() || !g.UseTCP(server) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	mu.Lock()
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	mu.Unlock()
+	if addrs, err := r.LookupHost(ctx, "www.example.com"); err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.53" {
+		t.Fatalf("tcp-only LookupHost = %v, %v", addrs, err)
+	}
+
+	// 没有 Guard 时保持原来的行为
+	if _, err := (&Resolver{Server: server}).LookupHost(ctx, "www.example.com"); err != ErrTxIDMismatch {
+		t.Fatalf("without guard: %v", err)
+	}
+
+	// 同时等待的相同查询
+	b := &PoisonGuard{}
+	q := NewQuery("www.example.com", DNSTypeA)
+	var ends []func()
+	for i := 0; i < poisonBirthdayLimit+2; i++ {
+		ends = append(ends, b.begin("192.0.2.9:53", q))
+	}
+	for _, end := range ends {
+		end()
+	}
+	_ = b.begin("192.0.2.9:53", q)
+	if stats := b.Stats("192.0.2.9:53"); stats.Birthday != 2 || !stats.TCPOnlyUntil.IsZero() {
+		t.Fatalf("birthday stats = %+v", stats)
+	}
+}
@@ -0,0 +1,214 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("connection pool closed")
+
+// ConnPool 按 network 与地址复用连接, 本身也是一个 Dialer:
+// DialContext 返回的连接 Close 时会放回连接池
+type ConnPool struct {
+	// Dialer 用于建立新连接, 为空时使用 net.Dialer
+	Dialer Dialer
+	// MaxIdle 每个地址最多保留的空闲连接, 默认 2
+	MaxIdle int
+	// MaxActive 每个地址最多同时打开的连接 (含空闲), 0 表示不限. 达到上限时 Get 会等待
+	MaxActive int
+	// MaxLifetime 连接建立后的最长使用时间, 0 表示不限
+	MaxLifetime time.Duration
+	// IdleTimeout 空闲超过该时间的连接被丢弃, 0 表示不限
+	IdleTimeout time.Duration
+	// HealthCheck 取出空闲连接时调用, 返回错误时丢弃连接. 为空时检查对端是否已关闭连接
+	HealthCheck func(conn net.Conn) error
+
+	mu      sync.Mutex
+	buckets map[string]*poolBucket
+	closed  bool
+}
+
+type poolBucket struct {
+	idle   []*PooledConn
+	open   int
+	notify chan struct{} // 有连接放回或关闭时关闭并替换
+}
+
+// PooledConn 连接池中的连接
+type PooledConn struct {
+	net.Conn
+	pool    *ConnPool
+	key     string
+	created time.Time
+	idleAt  time.Time
+	broken  bool
+	once    sync.Once
+}
+
+// MarkBroken 标记连接不可复用, Close 时会真正关闭
+func (c *PooledConn) MarkBroken() {
+	c.broken = true
+}
+
+// Close 将连接放回连接池
+func (c *PooledConn) Close() error {
+	c.once.Do(func() {
+		c.pool.put(c)
+	})
+	return nil
+}
+
+func (p *ConnPool) maxIdle() int {
+	if p.MaxIdle > 0 {
+		return p.MaxIdle
+	}
+	return 2
+}
+
+func (p *ConnPool) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return p.Get(ctx, network, address)
+}
+
+// Get 取出一个通过健康检查的空闲连接, 没有时建立新连接
+func (p *ConnPool) Get(ctx context.Context, network, address string) (*PooledConn, error) {
+	key := network + "|" + address
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if p.buckets == nil {
+			p.buckets = map[string]*poolBucket{}
+		}
+		b := p.buckets[key]
+		if b == nil {
+			b = &poolBucket{notify: make(chan struct{})}
+			p.buckets[key] = b
+		}
+		// 后放回的连接最先取出, 健康检查在锁外进行
+		if len(b.idle) > 0 {
+			c := b.idle[len(b.idle)-1]
+			b.idle = b.idle[:len(b.idle)-1]
+			p.mu.Unlock()
+			if p.usable(c) {
+				c.once = sync.Once{}
+				return c, nil
+			}
+			_ = c.Conn.Close()
+			p.mu.Lock()
+			p.release(key)
+			p.mu.Unlock()
+			continue
+		}
+		if p.MaxActive <= 0 || b.open < p.MaxActive {
+			b.open++
+			p.mu.Unlock()
+			return p.dial(ctx, network, address, key)
+		}
+		notify := b.notify
+		p.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *ConnPool) dial(ctx context.Context, network, address, key string) (*PooledConn, error) {
+	var dialer Dialer = &net.Dialer{}
+	if p.Dialer != nil {
+		dialer = p.Dialer
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		p.mu.Lock()
+		p.release(key)
+		p.mu.Unlock()
+		return nil, err
+	}
+	return &PooledConn{Conn: conn, pool: p, key: key, created: time.Now()}, nil
+}
+
+// usable 检查生命周期、空闲时间与健康状态
+func (p *ConnPool) usable(c *PooledConn) bool {
+	now := time.Now()
+	if p.MaxLifetime > 0 && now.Sub(c.created) > p.MaxLifetime {
+		return false
+	}
+	if p.IdleTimeout > 0 && now.Sub(c.idleAt) > p.IdleTimeout {
+		return false
+	}
+	check := p.HealthCheck
+	if check == nil {
+		check = peerClosed
+	}
+	return check(c.Conn) == nil
+}
+
+// peerClosed 以极短超时的读取探测连接, 读到数据或 EOF 都说明连接不能复用.
+// 截止时间已过时 net 包不会真正读取, 所以这里需要一个将来的时间
+func peerClosed(conn net.Conn) error {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	var b [1]byte
+	if _, err := conn.Read(b[:]); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+	return errors.New("unexpected data on idle connection")
+}
+
+func (p *ConnPool) put(c *PooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.buckets[c.key]
+	if p.closed || c.broken || b == nil || len(b.idle) >= p.maxIdle() ||
+		p.MaxLifetime > 0 && time.Since(c.created) > p.MaxLifetime {
+		_ = c.Conn.Close()
+		p.release(c.key)
+		return
+	}
+	_ = c.Conn.SetDeadline(time.Time{})
+	c.idleAt = time.Now()
+	b.idle = append(b.idle, c)
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// release 减少打开的连接数并唤醒等待者, 调用时持有锁
+func (p *ConnPool) release(key string) {
+	b := p.buckets[key]
+	if b == nil {
+		return
+	}
+	b.open--
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Close 关闭所有空闲连接, 之后放回的连接会被直接关闭
+func (p *ConnPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, b := range p.buckets {
+		for _, c := range b.idle {
+			_ = c.Conn.Close()
+		}
+		b.open -= len(b.idle)
+		b.idle = nil
+		close(b.notify)
+		b.notify = make(chan struct{})
+	}
+	return nil
+}
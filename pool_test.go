@@ -0,0 +1,95 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveTCPDNS 对每个请求原样返回并设置 QR, 每个连接最多处理 perConn 个请求
+func serveTCPDNS(t *testing.T, perConn int) (string, *int64) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	var accepted int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&accepted, 1)
+			go func() {
+				defer conn.Close()
+				for i := 0; i < perConn; i++ {
+					size := make([]byte, 2)
+					if _, err := io.ReadFull(conn, size); err != nil {
+						return
+					}
+					buf := make([]byte, int(size[0])<<8|int(size[1]))
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					msg, err := NewDNSMessage(bytes.NewBuffer(buf))
+					if err != nil {
+						return
+					}
+					msg.Header.Flags.QR = 1
+					b, _ := msg.ToByte()
+					_, _ = conn.Write(append([]byte{byte(len(b) >> 8), byte(len(b))}, b...))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), &accepted
+}
+
+func TestConnPool(t *testing.T) {
+	addr, accepted := serveTCPDNS(t, 2)
+	pool := &ConnPool{MaxActive: 1}
+	defer pool.Close()
+	r := &Resolver{Server: addr, Transport: TCPTransport{Pool: pool}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := r.Query(context.Background(), "www.example.com", DNSTypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Flags.QR != 1 {
+			t.Fatal("not a response")
+		}
+	}
+	if n := atomic.LoadInt64(accepted); n != 1 {
+		t.Fatalf("accepted = %d, want 1", n)
+	}
+
+	// 服务端在两个请求后关闭连接, 取出时的健康检查应丢弃它
+	time.Sleep(50 * time.Millisecond)
+	if _, err := r.Query(context.Background(), "www.example.com", DNSTypeA); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(accepted); n != 2 {
+		t.Fatalf("accepted = %d, want 2", n)
+	}
+
+	// 达到 MaxActive 时等待直到 ctx 结束
+	conn, err := pool.Get(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx, "tcp", addr); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	_ = conn.Close()
+	if _, err := pool.Get(context.Background(), "tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+}
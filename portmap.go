@@ -0,0 +1,102 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"time"
+)
+
+var (
+	ErrNoGateway       = errors.New("no default gateway")
+	ErrNoPortMapper    = errors.New("no port mapping service found on gateway")
+	ErrPortMapProtocol = errors.New("port mapping protocol must be tcp or udp")
+	ErrPortMapRefused  = errors.New("port mapping refused by gateway")
+)
+
+// PortMapping 一条端口映射. 请求时填写 Protocol, InternalPort 与 Lifetime,
+// ExternalPort 为期望的外部端口, 0 表示由网关分配
+type PortMapping struct {
+	Protocol     string // tcp 或 udp
+	InternalPort int
+	ExternalPort int
+	ExternalIP   net.IP
+	Lifetime     time.Duration
+	Description  string // 仅 UPnP 使用
+	Acquired     time.Time
+
+	nonce [12]byte // PCP 续期与删除时需要使用相同的 nonce
+}
+
+// PortMapper 由 PCP, NAT-PMP 与 UPnP IGD 客户端实现
+type PortMapper interface {
+	ExternalIP(ctx context.Context) (net.IP, error)
+	// AddMapping 创建或续期映射, 返回网关实际分配的结果
+	AddMapping(ctx context.Context, m *PortMapping) (*PortMapping, error)
+	DeleteMapping(ctx context.Context, m *PortMapping) error
+}
+
+// DiscoverPortMapper 依次尝试默认网关上的 PCP, NAT-PMP 与局域网内的 UPnP IGD,
+// 返回第一个可用的客户端
+func DiscoverPortMapper(ctx context.Context) (PortMapper, error) {
+	var lastErr error = ErrNoPortMapper
+	if gateway, err := DefaultGateway(); err == nil {
+		var mapper PortMapper
+		if mapper, lastErr = gatewayPortMapper(ctx, gateway, 0, 0); lastErr == nil {
+			return mapper, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	clients, err := DiscoverUPnP(ctx, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) > 0 {
+		return clients[0], nil
+	}
+	return nil, errors.WithMessage(ErrNoPortMapper, lastErr.Error())
+}
+
+// gatewayPortMapper 先尝试 gateway 上的 PCP, 网关只支持 NAT-PMP 时回退到 NAT-PMP
+func gatewayPortMapper(ctx context.Context, gateway net.IP, port, retries int) (PortMapper, error) {
+	pcp := &PCPClient{Gateway: gateway, Port: port, Retries: retries}
+	if err := pcp.Announce(ctx); err == nil {
+		return pcp, nil
+	}
+	pmp := &NATPMPClient{Gateway: gateway, Port: port, Retries: retries}
+	if _, err := pmp.ExternalIP(ctx); err != nil {
+		return nil, err
+	}
+	return pmp, nil
+}
+
+// MaintainMapping 在映射生命周期过半时续期, 失败后在剩余时间过半时重试, 直到 ctx 取消或映射过期.
+// 每次续期后调用 fn (可以为 nil). ctx 取消后映射不会被删除, 需要时由调用方调用 DeleteMapping
+func MaintainMapping(ctx context.Context, mapper PortMapper, m *PortMapping, fn func(*PortMapping, error)) error {
+	if m.Lifetime <= 0 {
+		// UPnP 永久映射不需要续期
+		return nil
+	}
+	next := m.Acquired.Add(m.Lifetime / 2)
+	for {
+		if err := sleepUntil(ctx, next); err != nil {
+			return err
+		}
+		renewed, err := mapper.AddMapping(ctx, m)
+		if fn != nil {
+			fn(renewed, err)
+		}
+		if err == nil {
+			m = renewed
+			next = m.Acquired.Add(m.Lifetime / 2)
+			continue
+		}
+		remain := time.Until(m.Acquired.Add(m.Lifetime))
+		if remain < 2*time.Second {
+			return err
+		}
+		next = time.Now().Add(remain / 2)
+	}
+}
@@ -0,0 +1,61 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestGatewayPortMapper(t *testing.T) {
+	// 只支持 NAT-PMP 的网关对 PCP 请求回复 1 Unsupported Version
+	gateway, port := startPortMapGateway(t, func(req []byte) [][]byte {
+		if req[0] != natpmpVersion {
+			return [][]byte{natpmpResponse(req[1], 1)}
+		}
+		return [][]byte{natpmpResponse(0, pmpResultSuccess, 203, 0, 113, 7)}
+	})
+	pcp := &PCPClient{Gateway: gateway, Port: port, Retries: 1}
+	if _, err := pcp.AddMapping(context.Background(), &PortMapping{Protocol: "tcp", InternalPort: 8080}); errors.Cause(err) != ErrNoPortMapper {
+		t.Fatalf("pcp on nat-pmp gateway: %v", err)
+	}
+	mapper, err := gatewayPortMapper(context.Background(), gateway, port, 1)
+	if _, ok := mapper.(*NATPMPClient); err != nil || !ok {
+		t.Fatalf("fallback: %v %T", err, mapper)
+	}
+
+	gateway, port = startPortMapGateway(t, func(req []byte) [][]byte {
+		resp := make([]byte, pcpHeaderLen)
+		resp[0], resp[1] = pcpVersion, 0x80|req[1]
+		return [][]byte{resp}
+	})
+	mapper, err = gatewayPortMapper(context.Background(), gateway, port, 1)
+	if _, ok := mapper.(*PCPClient); err != nil || !ok {
+		t.Fatalf("pcp: %v %T", err, mapper)
+	}
+}
+
+// countingMapper 每次续期返回新的映射, count 次之后取消 ctx
+type countingMapper struct {
+	PortMapper
+	count  int
+	cancel context.CancelFunc
+}
+
+func (m *countingMapper) AddMapping(ctx context.Context, pm *PortMapping) (*PortMapping, error) {
+	if m.count--; m.count == 0 {
+		m.cancel()
+	}
+	renewed := *pm
+	renewed.Acquired = time.Now()
+	return &renewed, nil
+}
+
+func TestMaintainMappingNilCallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mapper := &countingMapper{count: 2, cancel: cancel}
+	err := MaintainMapping(ctx, mapper, &PortMapping{Lifetime: 40 * time.Millisecond, Acquired: time.Now()}, nil)
+	if err != context.Canceled || mapper.count != 0 {
+		t.Fatalf("maintain: %v after %d renewals", err, 2-mapper.count)
+	}
+}
@@ -0,0 +1,207 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultProbeSamples = 5
+
+// ProbeTarget 一种到达被测解析器的方式
+type ProbeTarget struct {
+	Name      string // 报告中使用的名称, 如 udp, tcp, dot, doh
+	Server    string
+	Transport RoundTripper
+}
+
+// ProbeTargets 为公共解析器 ip 生成 UDP, TCP 与 DoT 三种方式, dohURL 不为空时再加上 DoH.
+// serverName 用于校验 DoT 证书, 为空时使用 ip
+func ProbeTargets(ip, serverName, dohURL string) []ProbeTarget {
+	if serverName == "" {
+		serverName = ip
+	}
+	targets := []ProbeTarget{
+		{Name: "udp", Server: net.JoinHostPort(ip, "53"), Transport: UDPTransport{}},
+		{Name: "tcp", Server: net.JoinHostPort(ip, "53"), Transport: TCPTransport{}},
+		{Name: "dot", Server: net.JoinHostPort(ip, "853"), Transport: TCPTransport{
+			Dialer: WithTLS(&tls.Config{ServerName: serverName})(&net.Dialer{}),
+		}},
+	}
+	if dohURL != "" {
+		targets = append(targets, ProbeTarget{Name: "doh", Server: dohURL, Transport: HTTPSTransport{}})
+	}
+	return targets
+}
+
+// ProbeOptions 探测选项, 零值使用默认值
+type ProbeOptions struct {
+	// Name 用于比较回答与计时的查询, 默认 example.com
+	Name string
+	// Type 默认 A
+	Type uint16
+	// Samples 每种方式的计时查询次数, 默认 5
+	Samples int
+	// Timeout 单次查询超时, 默认与 Resolver 相同
+	Timeout time.Duration
+}
+
+// TransportProbe 一种方式的探测结果
+type TransportProbe struct {
+	Target string
+	// Identity 应答实例的身份, 失败时为空, 错误见 IdentityErr
+	Identity    *ServerIdentity
+	IdentityErr error
+	// RTT 每次成功查询的耗时, 第一次包含建立连接与握手的时间
+	RTT []time.Duration
+	// Answers 第一次成功响应中与查询类型相同的回答, 已排序
+	Answers []string
+	RCode   uint16
+	// Err 最后一次查询的错误, 所有查询都失败时 Answers 为空
+	Err error
+}
+
+// MinRTT 最小耗时, 最接近网络往返时间, 可以粗略估计实例的距离
+func (p *TransportProbe) MinRTT() time.Duration {
+	var min time.Duration
+	for i, d := range p.RTT {
+		if i == 0 || d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// MedianRTT 耗时的中位数
+func (p *TransportProbe) MedianRTT() time.Duration {
+	if len(p.RTT) == 0 {
+		return 0
+	}
+	rtt := append([]time.Duration{}, p.RTT...)
+	sort.Slice(rtt, func(i, j int) bool { return rtt[i] < rtt[j] })
+	return rtt[len(rtt)/2]
+}
+
+// Instance 应答实例的标识, 见 ServerIdentity.Instance
+func (p *TransportProbe) Instance() string {
+	if p.Identity == nil {
+		return ""
+	}
+	return p.Identity.Instance()
+}
+
+func (p *TransportProbe) ok() bool {
+	return len(p.RTT) > 0
+}
+
+// ProbeReport 所有方式的探测结果, 顺序与 targets 相同
+type ProbeReport struct {
+	Probes []*TransportProbe
+}
+
+// Consistent 所有成功的方式回答与 rcode 是否相同
+func (r *ProbeReport) Consistent() bool {
+	var first *TransportProbe
+	for _, p := range r.Probes {
+		if !p.ok() {
+			continue
+		}
+		if first == nil {
+			first = p
+			continue
+		}
+		if p.RCode != first.RCode || strings.Join(p.Answers, "\n") != strings.Join(first.Answers, "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// Instances 各方式报告的实例标识, 去重后按出现顺序返回. 多于一个时说明不同方式被路由到了不同实例,
+// 或者 DoT/DoH 由单独的前端处理
+func (r *ProbeReport) Instances() []string {
+	var instances []string
+	seen := map[string]bool{}
+	for _, p := range r.Probes {
+		if id := p.Instance(); id != "" && !seen[id] {
+			seen[id] = true
+			instances = append(instances, id)
+		}
+	}
+	return instances
+}
+
+// String 每种方式一行的摘要
+func (r *ProbeReport) String() string {
+	var b strings.Builder
+	for _, p := range r.Probes {
+		b.WriteString(p.Target)
+		if !p.ok() {
+			b.WriteString(" error: ")
+			b.WriteString(p.Err.Error())
+			b.WriteByte('\n')
+			continue
+		}
+		b.WriteString(" instance=" + strconv.Quote(p.Instance()))
+		b.WriteString(" min=" + p.MinRTT().String() + " median=" + p.MedianRTT().String())
+		b.WriteString(" rcode=" + DNSRCode(p.RCode).String())
+		b.WriteString(" answers=[" + strings.Join(p.Answers, ", ") + "]\n")
+	}
+	if !r.Consistent() {
+		b.WriteString("answers differ between transports\n")
+	}
+	return b.String()
+}
+
+// Probe 从本机依次通过每个 target 查询身份与计时, 用于判断任播解析器由哪个实例应答,
+// 以及不同方式的回答是否一致. 各方式顺序执行, 避免相互影响计时
+func Probe(ctx context.Context, targets []ProbeTarget, opts *ProbeOptions) *ProbeReport {
+	o := ProbeOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Name == "" {
+		o.Name = "example.com"
+	}
+	if o.Type == 0 {
+		o.Type = DNSTypeA
+	}
+	if o.Samples <= 0 {
+		o.Samples = defaultProbeSamples
+	}
+	report := &ProbeReport{}
+	for _, t := range targets {
+		report.Probes = append(report.Probes, probeTarget(ctx, t, &o))
+	}
+	return report
+}
+
+func probeTarget(ctx context.Context, t ProbeTarget, o *ProbeOptions) *TransportProbe {
+	r := &Resolver{Server: t.Server, Transport: t.Transport, Timeout: o.Timeout}
+	p := &TransportProbe{Target: t.Name}
+	p.Identity, p.IdentityErr = r.Identify(ctx)
+	for i := 0; i < o.Samples; i++ {
+		start := time.Now()
+		resp, err := r.Query(ctx, o.Name, o.Type)
+		if err != nil {
+			p.Err = err
+			continue
+		}
+		p.RTT = append(p.RTT, time.Since(start))
+		if len(p.RTT) == 1 {
+			p.RCode = resp.Header.Flags.RCode
+			for _, rr := range resp.Answers() {
+				if rr.RRType == o.Type {
+					p.Answers = append(p.Answers, recordRData(rr))
+				}
+			}
+			sort.Strings(p.Answers)
+		}
+		resp.Release()
+	}
+	return p
+}
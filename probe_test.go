@@ -0,0 +1,56 @@
+package netx
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	udpAddr, tcpAddr := startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		if req.Message.OPT() != nil {
+			resp.SetEDNS(0, []EDNSOption{{Code: EDNSOptionNSID, Data: []byte("fra-1")}})
+		}
+		if q := req.Message.Questions[0]; q.QuestionType == DNSTypeA {
+			resp.ResourceRecodes = []*DNSResourceRecode{{Name: q.QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"}}
+			resp.Header.AnswerRRs = 1
+		}
+		return resp, nil
+	})})
+	targets := []ProbeTarget{
+		{Name: "udp", Server: udpAddr, Transport: UDPTransport{}},
+		{Name: "tcp", Server: tcpAddr, Transport: TCPTransport{}},
+	}
+	report := Probe(context.Background(), targets, &ProbeOptions{Samples: 3})
+	if len(report.Probes) != 2 {
+		t.Fatalf("probes = %d", len(report.Probes))
+	}
+	for _, p := range report.Probes {
+		if len(p.RTT) != 3 || p.MinRTT() > p.MedianRTT() {
+			t.Fatalf("%s rtt = %v", p.Target, p.RTT)
+		}
+		if p.Instance() != "fra-1" || len(p.Answers) != 1 || p.Answers[0] != "192.0.2.1" {
+			t.Fatalf("%s = %+v", p.Target, p)
+		}
+	}
+	if !report.Consistent() || len(report.Instances()) != 1 {
+		t.Fatalf("report:\n%s", report)
+	}
+
+	targets = append(targets, ProbeTarget{Name: "alt", Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+		resp := NewReply(req)
+		resp.Header.Flags.RCode = DNSRCodeNXDomain
+		return resp, nil
+	})}, ProbeTarget{Name: "down", Server: "127.0.0.1:1", Transport: TCPTransport{}})
+	report = Probe(context.Background(), targets, &ProbeOptions{Samples: 1})
+	if report.Consistent() {
+		t.Fatalf("nxdomain transport reported consistent:\n%s", report)
+	}
+	if down := report.Probes[3]; down.Err == nil || len(down.RTT) != 0 {
+		t.Fatalf("down = %+v", down)
+	}
+	if s := report.String(); !strings.Contains(s, "down error:") || !strings.Contains(s, "answers differ") {
+		t.Fatalf("report:\n%s", s)
+	}
+}
@@ -0,0 +1,210 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ResolutionProfile 一组完整的解析配置, 例如家庭网络与连接 VPN 之后的配置
+type ResolutionProfile struct {
+	Name string
+	// Server 与 Transport 为空时使用 Resolver 自己的值
+	Server    string
+	Transport RoundTripper
+	// Routes 分流规则, 例如 VPN 连接后把 corp.example 转发给 VPN 内的服务器, 见 RouteTable
+	Routes []NameRoute
+	// SearchDomains 短名字的搜索域, 见 ProfileSwitcher.SearchNames
+	SearchDomains []string
+	// NDots 名字中的点不少于 NDots 时先按完整名字查询, 默认 1, 与 resolv.conf 相同
+	NDots int
+}
+
+// activeProfile 生效的配置与编译后的路由表, 一起替换
+type activeProfile struct {
+	profile *ResolutionProfile
+	routes  *RouteTable
+}
+
+// ProfileSwitcher 持有当前生效的 ResolutionProfile, 可以在查询进行时整体替换, 例如 VPN 连接或断开时.
+// 每个查询只读取一次当前配置, 不会看到新旧配置的混合. 替换后按订阅顺序同步通知订阅者,
+// 例如清空缓存或重新建立连接. 可以并发使用
+type ProfileSwitcher struct {
+	v atomic.Value // *activeProfile
+
+	mu     sync.Mutex // 保证通知的顺序与替换的顺序一致
+	subs   map[int]func(old, current *ResolutionProfile)
+	nextID int
+}
+
+func NewProfileSwitcher(p *ResolutionProfile) *ProfileSwitcher {
+	s := &ProfileSwitcher{}
+	s.v.Store(newActiveProfile(p))
+	return s
+}
+
+func newActiveProfile(p *ResolutionProfile) *activeProfile {
+	if p == nil {
+		p = &ResolutionProfile{}
+	}
+	return &activeProfile{profile: p, routes: NewRouteTable(p.Routes...)}
+}
+
+func (s *ProfileSwitcher) load() *activeProfile {
+	a, _ := s.v.Load().(*activeProfile)
+	if a == nil {
+		return newActiveProfile(nil)
+	}
+	return a
+}
+
+// Active 当前的配置, 不能修改
+func (s *ProfileSwitcher) Active() *ResolutionProfile {
+	return s.load().profile
+}
+
+// Activate 替换当前配置并通知订阅者, p 在之后不能修改. 返回之前的配置
+func (s *ProfileSwitcher) Activate(p *ResolutionProfile) *ResolutionProfile {
+	next := newActiveProfile(p)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.load().profile
+	s.v.Store(next)
+	ids := make([]int, 0, len(s.subs))
+	for id := range s.subs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		s.subs[id](old, next.profile)
+	}
+	return old
+}
+
+// Subscribe 在每次 Activate 之后调用 fn, fn 在 Activate 的 goroutine 中执行, 不能调用 Activate.
+// 返回取消订阅的函数
+func (s *ProfileSwitcher) Subscribe(fn func(old, current *ResolutionProfile)) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[int]func(old, current *ResolutionProfile){}
+	}
+	id := s.nextID
+	s.nextID++
+	s.subs[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+// Interceptor 按当前配置发送查询: 先按 Routes 分流, 其余名字发给配置的 Server 与 Transport
+func (s *ProfileSwitcher) Interceptor() Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			a := s.load()
+			rt := next
+			if a.profile.Transport != nil {
+				rt = a.profile.Transport
+			}
+			if a.profile.Server != "" {
+				server = a.profile.Server
+			}
+			return a.routes.Interceptor()(rt).RoundTrip(ctx, server, req)
+		})
+	}
+}
+
+// SearchNames 按当前配置的搜索域返回 name 依次尝试的完整名字. 以点结尾的名字只查询本身,
+// 点数不少于 NDots 的名字先查询本身, 否则最后查询
+func (s *ProfileSwitcher) SearchNames(name string) []string {
+	p := s.load().profile
+	if strings.HasSuffix(name, ".") || len(p.SearchDomains) == 0 {
+		return []string{strings.TrimSuffix(name, ".")}
+	}
+	ndots := p.NDots
+	if ndots <= 0 {
+		ndots = 1
+	}
+	names := make([]string, 0, len(p.SearchDomains)+1)
+	for _, domain := range p.SearchDomains {
+		names = append(names, name+"."+normalizeDomain(domain))
+	}
+	if strings.Count(name, ".") >= ndots {
+		return append([]string{name}, names...)
+	}
+	return append(names, name)
+}
+
+// LookupHost 按 SearchNames 的顺序通过 r 查询, 返回第一个有地址的结果. 全部失败时返回最后一个错误.
+// r 通常在 Interceptors 中包含 s.Interceptor()
+func (s *ProfileSwitcher) LookupHost(ctx context.Context, r *Resolver, host string) ([]string, error) {
+	var lastErr error
+	for _, name := range s.SearchNames(host) {
+		addrs, err := r.LookupHost(ctx, name)
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = ErrNoAddress
+	}
+	return nil, lastErr
+}
+
+// ParseResolvConf 把 resolv.conf 转为 ResolutionProfile: 第一个 nameserver 作为 Server,
+// search 或 domain 中后出现的作为 SearchDomains, 以及 options ndots. 不认识的行被忽略
+func ParseResolvConf(data []byte) *ResolutionProfile {
+	p := &ResolutionProfile{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if p.Server == "" && net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]) != nil {
+				p.Server = net.JoinHostPort(fields[1], "53")
+			}
+		case "search":
+			p.SearchDomains = append([]string(nil), fields[1:]...)
+		case "domain":
+			p.SearchDomains = []string{fields[1]}
+		case "options":
+			for _, opt := range fields[1:] {
+				if v := strings.TrimPrefix(opt, "ndots:"); v != opt {
+					if n, err := strconv.Atoi(v); err == nil {
+						p.NDots = n
+					}
+				}
+			}
+		}
+	}
+	return p
+}
+
+// LoadResolvConf 读取 path 并调用 ParseResolvConf, Name 为 path
+func LoadResolvConf(path string) (*ResolutionProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := ParseResolvConf(data)
+	p.Name = path
+	return p, nil
+}
@@ -0,0 +1,87 @@
+package netx
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestProfileSwitcher(t *testing.T) {
+	answer := func(rdata string) RoundTripper {
+		return PipeTransport{Server: &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp := NewReply(req.Message)
+			if name := req.Message.Questions[0].QuestionName; name != "short" {
+				resp.ResourceRecodes = []*DNSResourceRecode{aRecord(name, rdata)}
+			}
+			resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+			return resp, nil
+		})}}
+	}
+	home := &ResolutionProfile{Name: "home", Server: "192.0.2.1:53", Transport: answer("192.0.2.1")}
+	vpn := &ResolutionProfile{
+		Name:          "vpn",
+		Routes:        []NameRoute{{Suffix: "corp.example", Server: "10.0.0.53:53", Transport: answer("10.0.0.1")}},
+		SearchDomains: []string{"corp.example"},
+	}
+	s := NewProfileSwitcher(home)
+	r := &Resolver{Server: "192.0.2.9:53", Transport: answer("192.0.2.9"), Interceptors: []Interceptor{s.Interceptor()}}
+	check := func(name, want string) {
+		t.Helper()
+		addrs, err := s.LookupHost(context.Background(), r, name)
+		if err != nil || len(addrs) != 1 || addrs[0] != want {
+			t.Fatalf("%s = %v, %v, want %s", name, addrs, err, want)
+		}
+	}
+	check("git.corp.example", "192.0.2.1")
+
+	var events []string
+	cancel := s.Subscribe(func(old, current *ResolutionProfile) {
+		events = append(events, old.Name+">"+current.Name)
+	})
+	if old := s.Activate(vpn); old != home || s.Active() != vpn {
+		t.Fatalf("Activate returned %v", old)
+	}
+	// vpn 没有指定 Server, 使用 Resolver 自己的
+	check("git.corp.example", "10.0.0.1")
+	check("www.example.com", "192.0.2.9")
+	check("short", "10.0.0.1")
+
+	cancel()
+	s.Activate(home)
+	if !reflect.DeepEqual(events, []string{"home>vpn"}) {
+		t.Fatalf("events = %v", events)
+	}
+	if _, err := s.LookupHost(context.Background(), r, "short"); err == nil {
+		t.Fatal("short name resolved without search domains")
+	}
+}
+
+func TestProfileSearchNames(t *testing.T) {
+	s := NewProfileSwitcher(&ResolutionProfile{SearchDomains: []string{"corp.example.", "example.net"}})
+	for name, want := range map[string][]string{
+		"git":      {"git.corp.example", "git.example.net", "git"},
+		"git.team": {"git.team", "git.team.corp.example", "git.team.example.net"},
+		"git.":     {"git"},
+	} {
+		if got := s.SearchNames(name); !reflect.DeepEqual(got, want) {
+			t.Fatalf("SearchNames(%s) = %v", name, got)
+		}
+	}
+	s.Activate(&ResolutionProfile{SearchDomains: []string{"corp.example"}, NDots: 2})
+	if got := s.SearchNames("git.team"); got[0] != "git.team.corp.example" {
+		t.Fatalf("ndots 2: %v", got)
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	p := ParseResolvConf([]byte(`# generated
+domain home.example
+nameserver fe80::1%eth0
+nameserver 192.0.2.53
+search corp.example example.net
+options edns0 ndots:2
+`))
+	if p.Server != "[fe80::1%eth0]:53" || p.NDots != 2 || !reflect.DeepEqual(p.SearchDomains, []string{"corp.example", "example.net"}) {
+		t.Fatalf("%+v", p)
+	}
+}
@@ -0,0 +1,372 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+// proxyV2Signature PROXY protocol v2 头的前 12 字节
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLen             = 107
+	defaultProxyHeaderTimeout = 5 * time.Second
+)
+
+// ProxyHeader HAProxy PROXY protocol 头, 由负载均衡器在连接开始时发送, 描述真实的客户端地址
+type ProxyHeader struct {
+	Version int // 1 或 2
+	// Local 为真表示负载均衡器自己的连接 (v2 LOCAL 或 v1 UNKNOWN), 例如健康检查, 此时地址无效
+	Local bool
+	// Source, Destination 为客户端与负载均衡器接收连接的地址, 类型为 *net.TCPAddr 或 *net.UDPAddr.
+	// 地址族不是 IP 时为空
+	Source, Destination net.Addr
+	// TLVs v2 地址之后的扩展字段, 原样保留
+	TLVs []byte
+}
+
+// ReadProxyHeader 从 r 读取一个 v1 或 v2 的 PROXY protocol 头, 只读取头本身
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(r)
+	case '\r':
+		return readProxyV2(r)
+	}
+	return nil, ErrProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLen {
+			return nil, errors.WithMessage(ErrProxyHeader, "v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.WithMessage(ErrProxyHeader, "v1 header not terminated by crlf")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, ErrProxyHeader
+	}
+	h := &ProxyHeader{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		h.Local = true
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errors.WithMessage(ErrProxyHeader, "v1 protocol "+fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errors.WithMessage(ErrProxyHeader, "v1 field count")
+	}
+	src, err1 := netip.ParseAddr(fields[2])
+	dst, err2 := netip.ParseAddr(fields[3])
+	sport, err3 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err4 := strconv.ParseUint(fields[5], 10, 16)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || src.Is4() != (fields[1] == "TCP4") || dst.Is4() != src.Is4() {
+		return nil, errors.WithMessage(ErrProxyHeader, "v1 addresses")
+	}
+	h.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(sport)))
+	h.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, uint16(dport)))
+	return h, nil
+}
+
+func readProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:12], proxyV2Signature) || fixed[12]>>4 != 2 {
+		return nil, errors.WithMessage(ErrProxyHeader, "v2 signature")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	h := &ProxyHeader{Version: 2}
+	switch fixed[12] & 0x0f {
+	case 0:
+		h.Local = true
+		return h, nil
+	case 1:
+	default:
+		return nil, errors.WithMessage(ErrProxyHeader, "v2 command")
+	}
+	var n int
+	switch fixed[13] >> 4 {
+	case 1:
+		n = 4
+	case 2:
+		n = 16
+	default:
+		// AF_UNSPEC 与 AF_UNIX 没有 IP 地址
+		return h, nil
+	}
+	if len(body) < 2*n+4 {
+		return nil, errors.WithMessage(ErrProxyHeader, "v2 address length")
+	}
+	src, _ := netip.AddrFromSlice(body[:n])
+	dst, _ := netip.AddrFromSlice(body[n : 2*n])
+	sport, dport := binary.BigEndian.Uint16(body[2*n:]), binary.BigEndian.Uint16(body[2*n+2:])
+	if fixed[13]&0x0f == 2 {
+		h.Source = net.UDPAddrFromAddrPort(netip.AddrPortFrom(src, sport))
+		h.Destination = net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, dport))
+	} else {
+		h.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, sport))
+		h.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, dport))
+	}
+	if len(body) > 2*n+4 {
+		h.TLVs = body[2*n+4:]
+	}
+	return h, nil
+}
+
+// addrPort 返回 tcp 或 udp 地址, 其他类型返回 false
+func addrPort(addr net.Addr) (netip.AddrPort, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort(), a != nil
+	case *net.UDPAddr:
+		return a.AddrPort(), a != nil
+	}
+	return netip.AddrPort{}, false
+}
+
+// Bytes 编码头. 源与目的地址族不同时都按 IPv6 (IPv4 映射地址) 编码, 地址不是 IP 时编码为 LOCAL/UNKNOWN
+func (h *ProxyHeader) Bytes() []byte {
+	src, ok1 := addrPort(h.Source)
+	dst, ok2 := addrPort(h.Destination)
+	if h.Local || !ok1 || !ok2 {
+		if h.Version == 1 {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		b := append(append([]byte{}, proxyV2Signature...), 0x20, 0, 0, 0)
+		binary.BigEndian.PutUint16(b[14:], uint16(len(h.TLVs)))
+		return append(b, h.TLVs...)
+	}
+	v4 := src.Addr().Unmap().Is4() && dst.Addr().Unmap().Is4()
+	srcIP, dstIP := netip.AddrFrom16(src.Addr().As16()), netip.AddrFrom16(dst.Addr().As16())
+	if v4 {
+		srcIP, dstIP = srcIP.Unmap(), dstIP.Unmap()
+	}
+	if h.Version == 1 {
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		return []byte("PROXY " + proto + " " + srcIP.String() + " " + dstIP.String() + " " +
+			strconv.Itoa(int(src.Port())) + " " + strconv.Itoa(int(dst.Port())) + "\r\n")
+	}
+	family := byte(0x21)
+	if v4 {
+		family = 0x11
+	}
+	if _, ok := h.Source.(*net.UDPAddr); ok {
+		family++
+	}
+	b := append(append([]byte{}, proxyV2Signature...), 0x21, family, 0, 0)
+	b = append(append(b, srcIP.AsSlice()...), dstIP.AsSlice()...)
+	b = append(b, byte(src.Port()>>8), byte(src.Port()), byte(dst.Port()>>8), byte(dst.Port()))
+	b = append(b, h.TLVs...)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(b)-16))
+	return b
+}
+
+// ProxyListener 接受以 PROXY protocol v1 或 v2 头开始的连接, 连接的 RemoteAddr 与 LocalAddr 为头中的地址,
+// 因此 ACL, 视图, 限速与日志看到的是真实的客户端. 可以包在 tls.NewListener 与 http.Server 的监听之下,
+// 用于 tcp, DoT 与 DoH. 头在第一次 Read 或取地址时读取, 不阻塞 Accept
+type ProxyListener struct {
+	net.Listener
+	// Trusted 允许发送头的来源, 为空时不信任任何来源, 信任所有来源需要明确写出 0.0.0.0/0 与 ::/0.
+	// 其他来源的连接不读取头, 使用连接本身的地址, 因此不能伪造客户端地址绕过 ACL
+	Trusted []netip.Prefix
+	// Optional 为真时没有头的连接按原样处理, 否则关闭
+	Optional bool
+	// HeaderTimeout 读取头的超时, 默认 5s
+	HeaderTimeout time.Duration
+}
+
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, l: l}, nil
+}
+
+func (l *ProxyListener) trusted(addr net.Addr) bool {
+	ap, ok := addrPort(addr)
+	if !ok {
+		return false
+	}
+	for _, p := range l.Trusted {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyConn struct {
+	net.Conn
+	l *ProxyListener
+
+	once   sync.Once
+	r      io.Reader
+	header *ProxyHeader
+	err    error
+
+	mu       sync.Mutex
+	deadline time.Time // 调用者设置的读超时, 读取头之后恢复
+}
+
+// init 读取头, 只执行一次
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.r = c.Conn
+		if !c.l.trusted(c.Conn.RemoteAddr()) {
+			return
+		}
+		timeout := c.l.HeaderTimeout
+		if timeout <= 0 {
+			timeout = defaultProxyHeaderTimeout
+		}
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+		if d := time.Now().Add(timeout); deadline.IsZero() || d.Before(deadline) {
+			_ = c.Conn.SetReadDeadline(d)
+		}
+		br := bufio.NewReader(c.Conn)
+		c.r = br
+		if first, err := br.Peek(1); err == nil && c.l.Optional && first[0] != 'P' && first[0] != '\r' {
+			_ = c.Conn.SetReadDeadline(deadline)
+			return
+		}
+		c.header, c.err = ReadProxyHeader(br)
+		if c.err != nil {
+			c.err = errors.WithMessage(c.err, "read proxy header")
+			_ = c.Conn.Close()
+			return
+		}
+		_ = c.Conn.SetReadDeadline(deadline)
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.header != nil && !c.header.Local && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.header != nil && !c.header.Local && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+type clientAddrKey struct{}
+
+// WithClientAddr 在 context 中记录发起请求的客户端地址, ForwardHandler 自动记录, 供 WithProxyHeader 使用
+func WithClientAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, clientAddrKey{}, addr)
+}
+
+// ClientAddrFrom 返回 WithClientAddr 记录的地址
+func ClientAddrFrom(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(clientAddrKey{}).(net.Addr)
+	return addr
+}
+
+type proxyDestinationKey struct{}
+
+// WithProxyDestination 在 context 中记录客户端连接的本地地址, DNSServer 自动记录, 作为 WithProxyHeader 的目的地址
+func WithProxyDestination(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, proxyDestinationKey{}, addr)
+}
+
+// ProxyDestinationFrom 返回 WithProxyDestination 记录的地址
+func ProxyDestinationFrom(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(proxyDestinationKey{}).(net.Addr)
+	return addr
+}
+
+// WithProxyHeader 在新建立的 tcp 连接上先发送 version (1 或 2) 的 PROXY protocol 头, 源地址与目的地址为
+// context 中的客户端地址 (ClientAddrFrom) 与客户端连接的本地地址 (ProxyDestinationFrom), 缺少任一个时发送 LOCAL (v1 为 UNKNOWN). 放在 WithTLS 之后, 使头在 TLS 握手之前发送:
+//
+//	ChainDialer(nil, WithTLS(nil), WithProxyHeader(2))
+//
+// 头只描述建立连接时的客户端, 因此不要与 ConnPool 一起使用
+func WithProxyHeader(version int) DialMiddleware {
+	return func(next Dialer) Dialer {
+		return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := next.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			h := &ProxyHeader{Version: version, Source: ClientAddrFrom(ctx), Destination: ProxyDestinationFrom(ctx)}
+			if deadline, ok := ctx.Deadline(); ok {
+				_ = conn.SetWriteDeadline(deadline)
+				defer conn.SetWriteDeadline(time.Time{})
+			}
+			if _, err := conn.Write(h.Bytes()); err != nil {
+				_ = conn.Close()
+				return nil, errors.WithMessage(err, "write proxy header")
+			}
+			return conn, nil
+		})
+	}
+}
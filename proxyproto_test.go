@@ -0,0 +1,121 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxyHeader(t *testing.T) {
+	tcp := func(s string) net.Addr { return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s)) }
+	cases := []struct {
+		h        ProxyHeader
+		src, dst string
+	}{
+		{ProxyHeader{Version: 1, Source: tcp("192.0.2.1:5353"), Destination: tcp("198.51.100.1:53")}, "192.0.2.1:5353", "198.51.100.1:53"},
+		{ProxyHeader{Version: 1, Source: tcp("[2001:db8::1]:1000"), Destination: tcp("192.0.2.2:53")}, "[2001:db8::1]:1000", "192.0.2.2:53"},
+		{ProxyHeader{Version: 2, Source: tcp("192.0.2.1:5353"), Destination: tcp("198.51.100.1:853"), TLVs: []byte{1, 0, 2, 'h', '2'}}, "192.0.2.1:5353", "198.51.100.1:853"},
+		{ProxyHeader{Version: 2, Source: net.UDPAddrFromAddrPort(netip.MustParseAddrPort("[2001:db8::2]:53000")), Destination: tcp("[2001:db8::53]:53")}, "[2001:db8::2]:53000", "[2001:db8::53]:53"},
+		{ProxyHeader{Version: 1, Local: true}, "", ""},
+		{ProxyHeader{Version: 2, Source: tcp("192.0.2.1:1")}, "", ""},
+	}
+	for _, c := range cases {
+		b := c.h.Bytes()
+		r := bufio.NewReader(bytes.NewReader(append(b, "rest"...)))
+		got, err := ReadProxyHeader(r)
+		if err != nil {
+			t.Fatalf("%q: %v", b, err)
+		}
+		if c.src == "" {
+			if !got.Local {
+				t.Fatalf("%q: not local", b)
+			}
+		} else if got.Version != c.h.Version || got.Source.String() != c.src || got.Destination.String() != c.dst || !bytes.Equal(got.TLVs, c.h.TLVs) {
+			t.Fatalf("%q: %+v", b, got)
+		}
+		if rest, _ := r.ReadString(0); rest != "rest" {
+			t.Fatalf("%q: rest %q", b, rest)
+		}
+	}
+	for _, bad := range []string{"PROXY TCP4 192.0.2.1 ::1 1 2\r\n", "PROXY TCP4 192.0.2.1 192.0.2.2 1\r\n", "PROXY UDP4 a b c d\r\n", "GET / HTTP/1.1\r\n", "\r\n\r\n\x00\r\nQUIT\n\x31\x11\x00\x00"} {
+		if _, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader([]byte(bad)))); err == nil {
+			t.Fatalf("%q accepted", bad)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		clients []string
+		dests   []string
+	)
+	backend := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		mu.Lock()
+		clients = append(clients, req.ClientIP().String())
+		dests = append(dests, ProxyDestinationFrom(ctx).String())
+		mu.Unlock()
+		return NewReply(req.Message), nil
+	})}
+	serve := func(l *ProxyListener) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+		l.Listener = ln
+		go backend.ServeTCP(l)
+		return ln.Addr().String()
+	}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	strict := serve(&ProxyListener{Trusted: loopback})
+	optional := serve(&ProxyListener{Trusted: loopback, Optional: true})
+	untrusted := serve(&ProxyListener{Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	nobody := serve(&ProxyListener{})
+
+	// 转发时带上客户端地址与客户端连接的地址
+	client := &net.UDPAddr{IP: net.ParseIP("203.0.113.9"), Port: 4444}
+	ctx := WithProxyDestination(context.Background(), &net.UDPAddr{IP: net.ParseIP("198.51.100.53"), Port: 53})
+	for _, version := range []int{1, 2} {
+		fwd := ForwardHandler(&Resolver{Server: strict, Transport: TCPTransport{Dialer: ChainDialer(nil, WithProxyHeader(version))}})
+		if _, err := fwd.ServeDNS(ctx, &DNSRequest{Message: NewQuery("example", DNSTypeA), RemoteAddr: client}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plain := &Resolver{Server: strict, Transport: TCPTransport{}}
+	if _, err := plain.Query(context.Background(), "example", DNSTypeA); err == nil {
+		t.Fatal("connection without header accepted")
+	}
+	for _, server := range []string{optional, untrusted, nobody} {
+		plain.Server = server
+		if _, err := plain.Query(context.Background(), "example", DNSTypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 不信任的来源不能通过头伪造客户端地址
+	for _, server := range []string{untrusted, nobody} {
+		fwd := ForwardHandler(&Resolver{Server: server, Timeout: 200 * time.Millisecond, Transport: TCPTransport{Dialer: ChainDialer(nil, WithProxyHeader(2))}})
+		if _, err := fwd.ServeDNS(ctx, &DNSRequest{Message: NewQuery("example", DNSTypeA), RemoteAddr: client}); err == nil {
+			t.Fatal("header from an untrusted source accepted")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(clients) != 5 || clients[0] != "203.0.113.9" || clients[1] != "203.0.113.9" || clients[2] != "127.0.0.1" || clients[3] != "127.0.0.1" || clients[4] != "127.0.0.1" {
+		t.Fatalf("clients = %v", clients)
+	}
+	if dests[0] != "198.51.100.53:53" || dests[1] != "198.51.100.53:53" || dests[2] != optional {
+		t.Fatalf("destinations = %v", dests)
+	}
+
+	all := &ProxyListener{Trusted: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}}
+	addr := &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1}
+	if (&ProxyListener{}).trusted(addr) || !all.trusted(addr) {
+		t.Fatal("empty Trusted must trust nobody, 0.0.0.0/0 everyone")
+	}
+}
@@ -0,0 +1,165 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// PublicSuffixListURL publicsuffix.org 发布的列表
+const PublicSuffixListURL = "https://publicsuffix.org/list/public_suffix_list.dat"
+
+const maxPublicSuffixListBytes = 16 << 20
+
+var (
+	ErrPublicSuffix       = errors.New("domain is a public suffix")
+	ErrPublicSuffixStatus = errors.New("unexpected public suffix list http status")
+)
+
+// PublicSuffixList 从 public_suffix_list.dat 解析的规则, 可以并发使用
+type PublicSuffixList struct {
+	rules      map[string]bool // 规则 -> 是否属于 ICANN 部分, 通配规则以 "*." 开头
+	exceptions map[string]bool // 去掉 "!" 的例外规则
+}
+
+// ParsePublicSuffixList 解析 publicsuffix.org 格式的列表, 规则应当已转换为 punycode
+func ParsePublicSuffixList(r io.Reader) (*PublicSuffixList, error) {
+	l := &PublicSuffixList{rules: map[string]bool{}, exceptions: map[string]bool{}}
+	icann := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.Contains(line, "===BEGIN ICANN DOMAINS==="):
+			icann = true
+			continue
+		case strings.Contains(line, "===END ICANN DOMAINS==="):
+			icann = false
+			continue
+		case line == "" || strings.HasPrefix(line, "//"):
+			continue
+		}
+		// 规则在第一个空白处结束
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.ToLower(line)
+		if strings.HasPrefix(line, "!") {
+			l.exceptions[line[1:]] = icann
+		} else {
+			l.rules[line] = icann
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(l.rules) == 0 {
+		return nil, errors.New("empty public suffix list")
+	}
+	return l, nil
+}
+
+// FetchPublicSuffixList 从 url 下载并解析列表, url 为空时使用 PublicSuffixListURL, client 为空时使用 http.DefaultClient
+func FetchPublicSuffixList(ctx context.Context, client *http.Client, url string) (*PublicSuffixList, error) {
+	if url == "" {
+		url = PublicSuffixListURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(ErrPublicSuffixStatus, resp.Status)
+	}
+	return ParsePublicSuffixList(io.LimitReader(resp.Body, maxPublicSuffixListBytes))
+}
+
+// PublicSuffix 返回 domain 的公共后缀, 以及匹配的规则是否属于 ICANN 部分.
+// 没有规则匹配时按 "*" 处理, 即最后一个 label
+func (l *PublicSuffixList) PublicSuffix(domain string) (string, bool) {
+	labels := strings.Split(normalizeDomain(domain), ".")
+	// 例外规则优先, 公共后缀为去掉最左 label 的部分
+	for i := range labels {
+		if icann, ok := l.exceptions[strings.Join(labels[i:], ".")]; ok && i+1 < len(labels) {
+			return strings.Join(labels[i+1:], "."), icann
+		}
+	}
+	// 其余规则中 label 最多的一条生效
+	for i := range labels {
+		if icann, ok := l.rules[strings.Join(labels[i:], ".")]; ok {
+			return strings.Join(labels[i:], "."), icann
+		}
+		if i+1 < len(labels) {
+			if icann, ok := l.rules["*."+strings.Join(labels[i+1:], ".")]; ok {
+				return strings.Join(labels[i:], "."), icann
+			}
+		}
+	}
+	return labels[len(labels)-1], false
+}
+
+// RegistrableDomain 返回 domain 的可注册域名 (eTLD+1), domain 本身是公共后缀时返回 ErrPublicSuffix
+func (l *PublicSuffixList) RegistrableDomain(domain string) (string, error) {
+	suffix, _ := l.PublicSuffix(domain)
+	return registrableDomain(normalizeDomain(domain), suffix)
+}
+
+func registrableDomain(domain, suffix string) (string, error) {
+	if domain == suffix {
+		return "", errors.WithMessage(ErrPublicSuffix, domain)
+	}
+	head := strings.TrimSuffix(domain, "."+suffix)
+	if i := strings.LastIndexByte(head, '.'); i >= 0 {
+		head = head[i+1:]
+	}
+	if head == "" {
+		return "", errors.WithMessage(ErrEmptyLabel, domain)
+	}
+	return head + "." + suffix, nil
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+var (
+	pslMu     sync.RWMutex
+	pslGlobal *PublicSuffixList
+)
+
+// SetPublicSuffixList 替换 PublicSuffix 与 RegistrableDomain 使用的列表, 为空时恢复内置快照
+func SetPublicSuffixList(l *PublicSuffixList) {
+	pslMu.Lock()
+	pslGlobal = l
+	pslMu.Unlock()
+}
+
+// PublicSuffix 使用 SetPublicSuffixList 设置的列表, 未设置时使用 golang.org/x/net/publicsuffix 内置的快照
+func PublicSuffix(domain string) (string, bool) {
+	pslMu.RLock()
+	l := pslGlobal
+	pslMu.RUnlock()
+	if l != nil {
+		return l.PublicSuffix(domain)
+	}
+	return publicsuffix.PublicSuffix(normalizeDomain(domain))
+}
+
+// RegistrableDomain 返回 domain 的可注册域名 (eTLD+1), 用于按组织对域名分组
+func RegistrableDomain(domain string) (string, error) {
+	suffix, _ := PublicSuffix(domain)
+	return registrableDomain(normalizeDomain(domain), suffix)
+}
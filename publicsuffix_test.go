@@ -0,0 +1,63 @@
+package netx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testPublicSuffixList = `// ===BEGIN ICANN DOMAINS===
+com
+uk
+co.uk
+*.ck
+!www.ck
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+blogspot.com
+// ===END PRIVATE DOMAINS===
+`
+
+func TestPublicSuffixList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testPublicSuffixList))
+	}))
+	defer srv.Close()
+	l, err := FetchPublicSuffixList(context.Background(), nil, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		domain, suffix string
+		icann          bool
+		registrable    string
+	}{
+		{"www.Example.co.uk.", "co.uk", true, "example.co.uk"},
+		{"a.b.blogspot.com", "blogspot.com", false, "b.blogspot.com"},
+		{"a.b.foo.ck", "foo.ck", true, "b.foo.ck"},
+		{"a.www.ck", "ck", true, "www.ck"},
+		{"example.test", "test", false, "example.test"},
+		{"co.uk", "co.uk", true, ""},
+	}
+	for _, c := range cases {
+		suffix, icann := l.PublicSuffix(c.domain)
+		if suffix != c.suffix || icann != c.icann {
+			t.Errorf("%s suffix = %s %v, want %s %v", c.domain, suffix, icann, c.suffix, c.icann)
+		}
+		registrable, _ := l.RegistrableDomain(c.domain)
+		if registrable != c.registrable {
+			t.Errorf("%s registrable = %s, want %s", c.domain, registrable, c.registrable)
+		}
+	}
+
+	// 内置快照
+	if d, err := RegistrableDomain("mail.example.co.uk"); err != nil || d != "example.co.uk" {
+		t.Fatalf("registrable = %s, %v", d, err)
+	}
+	SetPublicSuffixList(l)
+	defer SetPublicSuffixList(nil)
+	if d, _ := RegistrableDomain("a.b.foo.ck"); d != "b.foo.ck" {
+		t.Fatalf("registrable = %s", d)
+	}
+}
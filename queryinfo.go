@@ -0,0 +1,112 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+type queryInfoKey struct{}
+
+// QueryInfo 一次查询的耗时明细, 通过 WithQueryInfo 放入 context 后由内置的 Transport 填写.
+// 拦截器重试时 Attempts 累加, 其余字段为最后一次尝试的值. 不能在并发的查询之间共享
+type QueryInfo struct {
+	Transport string // 最后一次尝试使用的方式: udp, tcp, tls, https, unix 或 pipe
+	Server    string // 应答的服务器地址, 经代理时为代理地址
+	Attempts  int    // Transport 被调用的次数
+
+	Resolve   time.Duration // 解析服务器主机名, 服务器为 IP 或由 Dialer 自行解析时为 0
+	Connect   time.Duration // 建立连接, 包括 TLS 握手. 复用连接时为 0
+	Reused    bool          // 是否复用了连接池中的连接
+	Resumed   bool          // 新建的 TLS 连接是否恢复了之前的会话
+	Write     time.Duration // 写出请求
+	FirstByte time.Duration // 从写完请求到收到响应的第一个字节
+	Total     time.Duration // Resolver.Exchange 的总耗时, 直接调用 Transport 时为 0
+
+	wroteAt time.Time
+}
+
+// WithQueryInfo 返回携带 info 的 context, 使用它的查询会把耗时明细写入 info
+//
+//	info := &QueryInfo{}
+//	resp, err := r.Query(WithQueryInfo(ctx, info), "example.com", DNSTypeA)
+func WithQueryInfo(ctx context.Context, info *QueryInfo) context.Context {
+	return context.WithValue(ctx, queryInfoKey{}, info)
+}
+
+func queryInfoFrom(ctx context.Context) *QueryInfo {
+	info, _ := ctx.Value(queryInfoKey{}).(*QueryInfo)
+	return info
+}
+
+// attempt 开始一次新的尝试, 清除上一次尝试的明细. 以下方法 i 为空时什么也不做
+func (i *QueryInfo) attempt(transport string) {
+	if i == nil {
+		return
+	}
+	i.Attempts++
+	i.Transport, i.Server = transport, ""
+	i.Resolve, i.Connect, i.Reused, i.Resumed, i.Write, i.FirstByte = 0, 0, false, false, 0, 0
+}
+
+// dial 建立连接并记录耗时. resolve 为 true 时先单独解析主机名, 依次尝试每个地址, 只用于默认的 net.Dialer
+func (i *QueryInfo) dial(ctx context.Context, dialer Dialer, resolve bool, network, server string) (net.Conn, error) {
+	if i == nil {
+		return dialer.DialContext(ctx, network, server)
+	}
+	start := time.Now()
+	host, port, err := net.SplitHostPort(server)
+	if !resolve || err != nil || net.ParseIP(host) != nil {
+		conn, err := dialer.DialContext(ctx, network, server)
+		i.connected(conn, start)
+		return conn, err
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	i.Resolve = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	var conn net.Conn
+	for _, addr := range addrs {
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			break
+		}
+	}
+	i.connected(conn, start)
+	return conn, err
+}
+
+func (i *QueryInfo) connected(conn net.Conn, start time.Time) {
+	if conn == nil {
+		return
+	}
+	i.Connect = time.Since(start)
+	if pc, ok := conn.(*PooledConn); ok {
+		if !pc.idleAt.IsZero() {
+			i.Connect, i.Reused = 0, true
+		}
+		conn = pc.Conn
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		i.Transport = "tls"
+		i.Resumed = !i.Reused && tc.ConnectionState().DidResume
+	}
+	i.Server = conn.RemoteAddr().String()
+}
+
+func (i *QueryInfo) wrote(start time.Time) {
+	if i == nil {
+		return
+	}
+	i.wroteAt = time.Now()
+	i.Write = i.wroteAt.Sub(start)
+}
+
+func (i *QueryInfo) firstByte() {
+	if i == nil {
+		return
+	}
+	i.FirstByte = time.Since(i.wroteAt)
+}
@@ -0,0 +1,65 @@
+package netx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueryInfo(t *testing.T) {
+	udpAddr, tcpAddr := startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		return NewReply(req.Message), nil
+	})})
+	pool := &ConnPool{}
+	defer pool.Close()
+	for _, c := range []struct {
+		transport string
+		server    string
+		rt        RoundTripper
+		reused    bool
+	}{
+		{"udp", udpAddr, UDPTransport{}, false},
+		{"tcp", tcpAddr, TCPTransport{Pool: pool}, false},
+		{"tcp", tcpAddr, TCPTransport{Pool: pool}, true},
+		{"udp", udpAddr, &UDPPoolTransport{Sockets: 1}, true},
+	} {
+		info := &QueryInfo{}
+		r := &Resolver{Server: c.server, Transport: c.rt}
+		resp, err := r.Query(WithQueryInfo(context.Background(), info), "example.com", DNSTypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Release()
+		if info.Transport != c.transport || info.Server != c.server || info.Attempts != 1 || info.Reused != c.reused {
+			t.Fatalf("%s info = %+v", c.transport, info)
+		}
+		if info.Total <= 0 || info.Total < info.Connect+info.Write+info.FirstByte {
+			t.Fatalf("%s timings = %+v", c.transport, info)
+		}
+		if !c.reused && info.Connect <= 0 {
+			t.Fatalf("%s connect = %v", c.transport, info.Connect)
+		}
+		if p, ok := c.rt.(*UDPPoolTransport); ok {
+			_ = p.Close()
+		}
+	}
+
+	// 重试的拦截器再次调用 Transport 时累加尝试次数
+	info := &QueryInfo{}
+	retry := func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			if _, err := next.RoundTrip(ctx, "127.0.0.1:1", req); err == nil {
+				t.Error("closed port answered")
+			}
+			return next.RoundTrip(ctx, server, req)
+		})
+	}
+	r := &Resolver{Server: tcpAddr, Transport: TCPTransport{}, Interceptors: []Interceptor{retry}}
+	resp, err := r.Query(WithQueryInfo(context.Background(), info), "example.com", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Release()
+	if info.Attempts != 2 || info.Server != tcpAddr {
+		t.Fatalf("info = %+v", info)
+	}
+}
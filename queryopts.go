@@ -0,0 +1,79 @@
+package netx
+
+// optDO OPT 记录 TTL 中的 DO 位, RFC 3225
+const optDO = 1 << 15
+
+// QueryOption 修改 NewQuery 构造的请求
+type QueryOption func(m *DNSMessage)
+
+// WithClass 设置问题的类别, 如查询 version.bind 时使用 DNSClassChaos
+func WithClass(class uint16) QueryOption {
+	return func(m *DNSMessage) {
+		for _, q := range m.Questions {
+			q.QuestionClass = class
+		}
+	}
+}
+
+// WithOpCode 设置操作码, 如 DNSOpCodeNotify
+func WithOpCode(opcode uint16) QueryOption {
+	return func(m *DNSMessage) { m.Header.Flags.OpCode = opcode }
+}
+
+// WithRecursionDesired 设置 RD 位, 默认期望递归, 直接询问权威服务器时可以关闭
+func WithRecursionDesired(rd bool) QueryOption {
+	return func(m *DNSMessage) { m.Header.Flags.RD = boolBit(rd) }
+}
+
+// WithAuthenticData 设置 AD 位, 要求递归服务器在响应中标明数据是否经过 DNSSEC 验证 (RFC 6840)
+func WithAuthenticData() QueryOption {
+	return func(m *DNSMessage) { m.Header.Flags.Z |= flagAD }
+}
+
+// WithCheckingDisabled 设置 CD 位, 要求递归服务器不做 DNSSEC 验证, 用于排查验证失败的域名
+func WithCheckingDisabled() QueryOption {
+	return func(m *DNSMessage) { m.Header.Flags.Z |= flagCD }
+}
+
+// WithDNSSECOK 添加 OPT 记录并设置 DO 位, 要求服务器返回 RRSIG 等 DNSSEC 记录
+func WithDNSSECOK() QueryOption {
+	return func(m *DNSMessage) {
+		if m.OPT() == nil {
+			m.SetEDNS(0, nil)
+		}
+		m.OPT().TTL |= optDO
+	}
+}
+
+// WithEDNSOptions 添加 OPT 记录并附加 options, udpSize 为 0 时保留已有的大小或使用 1232
+func WithEDNSOptions(udpSize uint16, options ...EDNSOption) QueryOption {
+	return func(m *DNSMessage) {
+		if opt := m.OPT(); opt != nil && udpSize == 0 {
+			udpSize = opt.Class
+		}
+		m.SetEDNS(udpSize, append(m.EDNSOptions(), options...))
+	}
+}
+
+// AuthenticData 响应的 AD 位
+func (f *DNSFlags) AuthenticData() bool {
+	return f.Z&flagAD != 0
+}
+
+// CheckingDisabled 请求的 CD 位
+func (f *DNSFlags) CheckingDisabled() bool {
+	return f.Z&flagCD != 0
+}
+
+// DNSSECOK 请求的 OPT 记录中是否设置了 DO 位
+func (d *DNSMessage) DNSSECOK() bool {
+	opt := d.OPT()
+	return opt != nil && opt.TTL&optDO != 0
+}
+
+func boolBit(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
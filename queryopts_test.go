@@ -0,0 +1,37 @@
+package netx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueryOptions(t *testing.T) {
+	requests := make(chan *DNSMessage, 1)
+	udpAddr, _ := startDNSServer(t, &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		requests <- req.Message.CopyToOwn()
+		resp := NewReply(req.Message)
+		resp.Header.Flags.Z = req.Message.Header.Flags.Z & flagAD
+		return resp, nil
+	})})
+	r := &Resolver{Server: udpAddr}
+	resp, err := r.Query(context.Background(), "version.bind", DNSTypeTXT,
+		WithClass(DNSClassChaos), WithRecursionDesired(false), WithAuthenticData(), WithCheckingDisabled(),
+		WithDNSSECOK(), WithEDNSOptions(4096, EDNSOption{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Header.Flags.AuthenticData() || resp.Header.Flags.CheckingDisabled() {
+		t.Fatalf("response flags = %+v", resp.Header.Flags)
+	}
+	got := <-requests
+	f := got.Header.Flags
+	if got.Questions[0].QuestionClass != DNSClassChaos || f.RD != 0 || !f.AuthenticData() || !f.CheckingDisabled() {
+		t.Fatalf("request = %+v, flags %+v", got.Questions[0], f)
+	}
+	if !got.DNSSECOK() || got.UDPSize() != 4096 || len(got.EDNSOptions()) != 1 || got.Header.AdditionalRRs != 1 {
+		t.Fatalf("request edns = %+v", got.OPT())
+	}
+	if m := NewQuery("example.com", DNSTypeA, WithOpCode(DNSOpCodeNotify)); m.Header.Flags.OpCode != DNSOpCodeNotify || m.DNSSECOK() {
+		t.Fatalf("notify query = %+v", m.Header.Flags)
+	}
+}
@@ -0,0 +1,212 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"net"
+	"time"
+)
+
+const (
+	ndOptSourceLinkAddr = 1
+	ndOptPrefixInfo     = 3
+	ndOptMTU            = 5
+	ndOptRDNSS          = 25
+	ndOptDNSSL          = 31
+	ndOptDNR            = 144
+)
+
+var (
+	// AllRouters ff02::2
+	AllRouters = net.ParseIP("ff02::2")
+
+	ErrRAShort  = errors.New("router advertisement too short")
+	ErrRAOption = errors.New("malformed router advertisement option")
+)
+
+// RAPrefix 前缀信息选项
+type RAPrefix struct {
+	Prefix            *net.IPNet
+	OnLink            bool
+	Autonomous        bool // 可用于 SLAAC
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// RouterAdvertisement RFC 4861 路由通告, 包含 RFC 8106 的 DNS 选项与 RFC 9463 的 DNR 选项
+type RouterAdvertisement struct {
+	Router         net.IP
+	HopLimit       uint8
+	Managed        bool // M 标志, 地址由 DHCPv6 分配
+	Other          bool // O 标志, 其他配置由 DHCPv6 提供
+	RouterLifetime time.Duration
+	ReachableTime  time.Duration
+	RetransTimer   time.Duration
+	SourceLinkAddr net.HardwareAddr
+	MTU            uint32
+	Prefixes       []*RAPrefix
+	RDNSS          []net.IP
+	RDNSSLifetime  time.Duration
+	DNSSL          []string
+	DNSSLLifetime  time.Duration
+	DNR            []*DesignatedResolver // 加密端点, 按优先级排序
+	DNRLifetime    time.Duration
+}
+
+// ParseRouterAdvertisement 解析 ICMPv6 type 134 的消息体 (不含 type/code/checksum)
+func ParseRouterAdvertisement(router net.IP, body []byte) (*RouterAdvertisement, error) {
+	if len(body) < 12 {
+		return nil, ErrRAShort
+	}
+	ra := &RouterAdvertisement{
+		Router:         router,
+		HopLimit:       body[0],
+		Managed:        body[1]&0x80 != 0,
+		Other:          body[1]&0x40 != 0,
+		RouterLifetime: time.Duration(binary.BigEndian.Uint16(body[2:4])) * time.Second,
+		ReachableTime:  time.Duration(binary.BigEndian.Uint32(body[4:8])) * time.Millisecond,
+		RetransTimer:   time.Duration(binary.BigEndian.Uint32(body[8:12])) * time.Millisecond,
+	}
+	opts := body[12:]
+	for len(opts) > 0 {
+		if len(opts) < 2 || opts[1] == 0 || int(opts[1])*8 > len(opts) {
+			return nil, ErrRAOption
+		}
+		typ, length := opts[0], int(opts[1])*8
+		data := opts[2:length]
+		switch typ {
+		case ndOptSourceLinkAddr:
+			ra.SourceLinkAddr = net.HardwareAddr(append([]byte(nil), data...))
+		case ndOptMTU:
+			if len(data) >= 6 {
+				ra.MTU = binary.BigEndian.Uint32(data[2:6])
+			}
+		case ndOptPrefixInfo:
+			if len(data) < 30 || data[0] > 128 {
+				return nil, ErrRAOption
+			}
+			ra.Prefixes = append(ra.Prefixes, &RAPrefix{
+				Prefix:            &net.IPNet{IP: net.IP(append([]byte(nil), data[14:30]...)), Mask: net.CIDRMask(int(data[0]), 128)},
+				OnLink:            data[1]&0x80 != 0,
+				Autonomous:        data[1]&0x40 != 0,
+				ValidLifetime:     time.Duration(binary.BigEndian.Uint32(data[2:6])) * time.Second,
+				PreferredLifetime: time.Duration(binary.BigEndian.Uint32(data[6:10])) * time.Second,
+			})
+		case ndOptRDNSS:
+			if len(data) < 6 {
+				return nil, ErrRAOption
+			}
+			ra.RDNSSLifetime = time.Duration(binary.BigEndian.Uint32(data[2:6])) * time.Second
+			for i := 6; i+16 <= len(data); i += 16 {
+				ra.RDNSS = append(ra.RDNSS, net.IP(append([]byte(nil), data[i:i+16]...)))
+			}
+		case ndOptDNSSL:
+			if len(data) < 6 {
+				return nil, ErrRAOption
+			}
+			ra.DNSSLLifetime = time.Duration(binary.BigEndian.Uint32(data[2:6])) * time.Second
+			names, err := parseNameList(data[6:])
+			if err != nil {
+				return nil, errors.WithMessage(err, "dnssl")
+			}
+			ra.DNSSL = append(ra.DNSSL, names...)
+		case ndOptDNR:
+			// 格式错误的 DNR 选项被丢弃, 不影响其他选项
+			if resolvers, lifetime, err := parseRADNR(data); err == nil {
+				ra.DNR = append(ra.DNR, resolvers...)
+				ra.DNRLifetime = lifetime
+			}
+		}
+		opts = opts[length:]
+	}
+	sortDesignated(ra.DNR)
+	return ra, nil
+}
+
+// ListenRouterAdvertisements 在 ifi 上接收路由通告, 并先发送一次路由请求 (solicit 为 true 时).
+// 每收到一个通告调用一次 fn, 直到 ctx 取消. 需要 raw socket 权限
+func ListenRouterAdvertisements(ctx context.Context, ifi *net.Interface, solicit bool, fn func(*RouterAdvertisement)) error {
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return errors.WithMessage(err, "listen icmpv6 error")
+	}
+	defer conn.Close()
+
+	p := conn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterAdvertisement)
+	if err := p.SetICMPFilter(&filter); err != nil {
+		return err
+	}
+	if ifi != nil {
+		if err := p.SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+	// 路由通告的 hop limit 必须为 255
+	if err := p.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagInterface, true); err != nil {
+		return err
+	}
+
+	if solicit {
+		if err := p.SetMulticastHopLimit(255); err != nil {
+			return err
+		}
+		msg := icmp.Message{Type: ipv6.ICMPTypeRouterSolicitation, Body: &icmp.RawBody{Data: make([]byte, 4)}}
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		dst := &net.IPAddr{IP: AllRouters}
+		if ifi != nil {
+			dst.Zone = ifi.Name
+		}
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			return errors.WithMessage(err, "send router solicitation error")
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	return readRouterAdvertisements(ctx, p, ifi, fn)
+}
+
+// raConn 接收 ICMPv6 报文及其控制信息, 由 *ipv6.PacketConn 实现
+type raConn interface {
+	ReadFrom(b []byte) (int, *ipv6.ControlMessage, net.Addr, error)
+}
+
+// readRouterAdvertisements 从 conn 读取路由通告直到出错, 丢弃 hop limit 不为 255 或来自其他网卡的报文
+func readRouterAdvertisements(ctx context.Context, conn raConn, ifi *net.Interface, fn func(*RouterAdvertisement)) error {
+	buf := make([]byte, 65535)
+	for {
+		n, cm, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if cm != nil && (cm.HopLimit != 255 || ifi != nil && cm.IfIndex != ifi.Index) {
+			continue
+		}
+		if n < 4 || buf[0] != byte(ipv6.ICMPTypeRouterAdvertisement) {
+			continue
+		}
+		var router net.IP
+		if addr, ok := src.(*net.IPAddr); ok {
+			router = addr.IP
+		}
+		ra, err := ParseRouterAdvertisement(router, buf[4:n])
+		if err != nil {
+			continue
+		}
+		fn(ra)
+	}
+}
@@ -0,0 +1,93 @@
+package netx
+
+import (
+	"context"
+	"golang.org/x/net/ipv6"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRouterAdvertisement(t *testing.T) {
+	body := []byte{
+		64, 0x40, 0x07, 0x08, // hop limit, O 标志, router lifetime 1800s
+		0, 0, 0, 0, 0, 0, 0, 0,
+		// prefix 2001:db8:1::/64, L+A
+		3, 4, 64, 0xC0, 0, 0, 0x0e, 0x10, 0, 0, 0x07, 0x08, 0, 0, 0, 0,
+		0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		// RDNSS 2001:db8::53
+		25, 3, 0, 0, 0, 0, 0x0e, 0x10,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53,
+		// DNSSL example.com
+		31, 3, 0, 0, 0, 0, 0x0e, 0x10,
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 0, 0,
+	}
+	ra, err := ParseRouterAdvertisement(net.ParseIP("fe80::1"), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ra.Other || ra.Managed || ra.RouterLifetime != 1800*time.Second {
+		t.Fatalf("unexpected flags %+v", ra)
+	}
+	if len(ra.Prefixes) != 1 || ra.Prefixes[0].Prefix.String() != "2001:db8:1::/64" || !ra.Prefixes[0].Autonomous {
+		t.Fatalf("unexpected prefixes %+v", ra.Prefixes)
+	}
+	if len(ra.RDNSS) != 1 || !ra.RDNSS[0].Equal(net.ParseIP("2001:db8::53")) {
+		t.Fatalf("unexpected rdnss %v", ra.RDNSS)
+	}
+	if len(ra.DNSSL) != 1 || ra.DNSSL[0] != "example.com" {
+		t.Fatalf("unexpected dnssl %v", ra.DNSSL)
+	}
+}
+
+// fakeRAConn 依次返回 packets, 之后返回 net.ErrClosed
+type fakeRAConn struct {
+	packets []fakeRAPacket
+}
+
+type fakeRAPacket struct {
+	data []byte
+	cm   *ipv6.ControlMessage
+}
+
+func (c *fakeRAConn) ReadFrom(b []byte) (int, *ipv6.ControlMessage, net.Addr, error) {
+	if len(c.packets) == 0 {
+		return 0, nil, nil, net.ErrClosed
+	}
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return copy(b, p.data), p.cm, &net.IPAddr{IP: net.ParseIP("fe80::1"), Zone: "test0"}, nil
+}
+
+func TestReadRouterAdvertisements(t *testing.T) {
+	ifi := &net.Interface{Name: "test0", Index: 2}
+	ra := func(hopLimit uint8) []byte {
+		return []byte{byte(ipv6.ICMPTypeRouterAdvertisement), 0, 0, 0, hopLimit, 0x80, 0, 60, 0, 0, 0, 0, 0, 0, 0, 0}
+	}
+	good := &ipv6.ControlMessage{HopLimit: 255, IfIndex: 2}
+	conn := &fakeRAConn{packets: []fakeRAPacket{
+		// 被转发过, 来自其他网卡, 不是路由通告, 太短的报文都被丢弃
+		{ra(1), &ipv6.ControlMessage{HopLimit: 64, IfIndex: 2}},
+		{ra(2), &ipv6.ControlMessage{HopLimit: 255, IfIndex: 3}},
+		{[]byte{byte(ipv6.ICMPTypeRouterSolicitation), 0, 0, 0, 0, 0, 0, 0}, good},
+		{ra(3)[:10], good},
+		{ra(4), good},
+	}}
+	var got []*RouterAdvertisement
+	err := readRouterAdvertisements(context.Background(), conn, ifi, func(ra *RouterAdvertisement) {
+		got = append(got, ra)
+	})
+	if err != net.ErrClosed {
+		t.Fatalf("read: %v", err)
+	}
+	if len(got) != 1 || got[0].HopLimit != 4 || !got[0].Managed || got[0].RouterLifetime != time.Minute || !got[0].Router.Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("advertisements %+v", got)
+	}
+
+	// ctx 取消后关闭 socket 导致的错误返回 ctx.Err()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := readRouterAdvertisements(ctx, &fakeRAConn{}, nil, func(*RouterAdvertisement) {}); err != context.Canceled {
+		t.Fatalf("canceled: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package netx
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	rdapBootstrapDNS  = "https://data.iana.org/rdap/dns.json"
+	rdapBootstrapIPv4 = "https://data.iana.org/rdap/ipv4.json"
+	rdapBootstrapIPv6 = "https://data.iana.org/rdap/ipv6.json"
+	maxRDAPBytes      = 4 << 20
+)
+
+var (
+	ErrRDAPNoServer = errors.New("no rdap server for query")
+	ErrRDAPStatus   = errors.New("unexpected rdap http status")
+)
+
+// RDAPEvent 注册、过期、更新等事件
+type RDAPEvent struct {
+	Action string `json:"eventAction"`
+	Date   string `json:"eventDate"`
+	Actor  string `json:"eventActor,omitempty"`
+}
+
+type RDAPEntity struct {
+	Handle   string            `json:"handle"`
+	Roles    []string          `json:"roles"`
+	VCard    json.RawMessage   `json:"vcardArray,omitempty"`
+	Entities []*RDAPEntity     `json:"entities,omitempty"`
+	Events   []*RDAPEvent      `json:"events,omitempty"`
+	PublicID []json.RawMessage `json:"publicIds,omitempty"`
+}
+
+type RDAPNameserver struct {
+	LDHName string `json:"ldhName"`
+}
+
+// RDAPResponse domain 与 ip network 两种对象共用的字段
+type RDAPResponse struct {
+	ObjectClassName string            `json:"objectClassName"`
+	Handle          string            `json:"handle"`
+	LDHName         string            `json:"ldhName,omitempty"`
+	UnicodeName     string            `json:"unicodeName,omitempty"`
+	Status          []string          `json:"status"`
+	Events          []*RDAPEvent      `json:"events"`
+	Entities        []*RDAPEntity     `json:"entities"`
+	Nameservers     []*RDAPNameserver `json:"nameservers,omitempty"`
+
+	// ip network
+	StartAddress string `json:"startAddress,omitempty"`
+	EndAddress   string `json:"endAddress,omitempty"`
+	IPVersion    string `json:"ipVersion,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Country      string `json:"country,omitempty"`
+
+	// Raw 原始 JSON, 便于读取未定义的扩展字段
+	Raw json.RawMessage `json:"-"`
+}
+
+type rdapBootstrap struct {
+	Services [][][]string `json:"services"`
+}
+
+// RDAPClient 通过 IANA bootstrap 文件找到对应的 RDAP 服务器
+type RDAPClient struct {
+	HTTPClient *http.Client
+
+	mu         sync.Mutex
+	bootstraps map[string]*rdapBootstrap
+}
+
+func (c *RDAPClient) client() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *RDAPClient) get(ctx context.Context, url string, v interface{}) (json.RawMessage, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(ErrRDAPStatus, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRDAPBytes))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, errors.WithMessage(err, "decode rdap json error")
+	}
+	return body, nil
+}
+
+func (c *RDAPClient) bootstrap(ctx context.Context, url string) (*rdapBootstrap, error) {
+	c.mu.Lock()
+	b := c.bootstraps[url]
+	c.mu.Unlock()
+	if b != nil {
+		return b, nil
+	}
+	b = &rdapBootstrap{}
+	if _, err := c.get(ctx, url, b); err != nil {
+		return nil, errors.WithMessage(err, "fetch bootstrap error")
+	}
+	c.mu.Lock()
+	if c.bootstraps == nil {
+		c.bootstraps = map[string]*rdapBootstrap{}
+	}
+	c.bootstraps[url] = b
+	c.mu.Unlock()
+	return b, nil
+}
+
+// Domain 查询域名的注册信息
+func (c *RDAPClient) Domain(ctx context.Context, domain string) (*RDAPResponse, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	b, err := c.bootstrap(ctx, rdapBootstrapDNS)
+	if err != nil {
+		return nil, err
+	}
+	// 选择匹配最长后缀的服务
+	var base string
+	best := -1
+	for _, service := range b.Services {
+		if len(service) < 2 || len(service[1]) == 0 {
+			continue
+		}
+		for _, tld := range service[0] {
+			tld = strings.ToLower(tld)
+			if (domain == tld || strings.HasSuffix(domain, "."+tld)) && len(tld) > best {
+				best = len(tld)
+				base = service[1][0]
+			}
+		}
+	}
+	if base == "" {
+		return nil, errors.WithMessage(ErrRDAPNoServer, domain)
+	}
+	return c.query(ctx, base, "domain/"+domain)
+}
+
+// IP 查询 ip 所属网段的注册信息
+func (c *RDAPClient) IP(ctx context.Context, ip string) (*RDAPResponse, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, ErrInvalidIP
+	}
+	url := rdapBootstrapIPv6
+	if addr.To4() != nil {
+		url = rdapBootstrapIPv4
+	}
+	b, err := c.bootstrap(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	var base string
+	best := -1
+	for _, service := range b.Services {
+		if len(service) < 2 || len(service[1]) == 0 {
+			continue
+		}
+		for _, cidr := range service[0] {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil || !ipNet.Contains(addr) {
+				continue
+			}
+			if ones, _ := ipNet.Mask.Size(); ones > best {
+				best = ones
+				base = service[1][0]
+			}
+		}
+	}
+	if base == "" {
+		return nil, errors.WithMessage(ErrRDAPNoServer, ip)
+	}
+	return c.query(ctx, base, "ip/"+addr.String())
+}
+
+func (c *RDAPClient) query(ctx context.Context, base, path string) (*RDAPResponse, error) {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	result := &RDAPResponse{}
+	raw, err := c.get(ctx, base+path, result)
+	if err != nil {
+		return nil, err
+	}
+	result.Raw = raw
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/netip"
+)
+
+var ErrRebinding = errors.New("answer contains an internal address")
+
+// RebindProtection 拒绝外部名字解析到内网地址的应答 (DNS rebinding), 内网地址包括私有、回环、
+// 链路本地、未指定地址、0.0.0.0/8 与 CGNAT 的 100.64.0.0/10, AAAA 中的 IPv4 映射地址与 NAT64
+// 地址 (64:ff9b::/96) 按其中的 IPv4 判断. 只检查回答部分的 A/AAAA,
+// 按问题中的名字判断是否允许, 因此外部名字通过 CNAME 指向内部名字同样被拒绝. 零值可用
+type RebindProtection struct {
+	// AllowedNames 可以解析到内网地址的名字及其子域名, 例如 corp.example 或 home.arpa
+	AllowedNames []string
+	// AllowedPrefixes 不视为内网的网段, 例如需要访问的某个内网服务
+	AllowedPrefixes []netip.Prefix
+}
+
+// Check 返回 resp 中第一个不允许的地址, 没有时为 nil
+func (p *RebindProtection) Check(resp *DNSMessage) error {
+	if len(resp.Questions) != 1 || p.nameAllowed(resp.Questions[0].QuestionName) {
+		return nil
+	}
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeA && rr.RRType != DNSTypeAAAA {
+			continue
+		}
+		addr, err := netip.ParseAddr(rr.RData)
+		if err != nil {
+			continue
+		}
+		if addr = embeddedIPv4(addr); internalAddr(addr) && !p.prefixAllowed(addr) {
+			return errors.WithMessage(ErrRebinding, resp.Questions[0].QuestionName+" "+addr.String())
+		}
+	}
+	return nil
+}
+
+func (p *RebindProtection) nameAllowed(name string) bool {
+	for _, zone := range p.AllowedNames {
+		if inZone(name, normalizeDomain(zone)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RebindProtection) prefixAllowed(addr netip.Addr) bool {
+	for _, prefix := range p.AllowedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// thisNetwork 0.0.0.0/8, 许多系统把其中的地址当作本机 (RFC 1122 3.2.1.3)
+	thisNetwork = netip.MustParsePrefix("0.0.0.0/8")
+	// sharedAddressSpace 运营商级 NAT 的地址 (RFC 6598)
+	sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+	// nat64Prefix NAT64 的知名前缀 (RFC 6052), 后 32 位为 IPv4 地址
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+)
+
+// embeddedIPv4 返回 IPv4 映射地址与 NAT64 地址中的 IPv4, 其它地址原样返回
+func embeddedIPv4(addr netip.Addr) netip.Addr {
+	addr = addr.Unmap()
+	if nat64Prefix.Contains(addr) {
+		b := addr.As16()
+		return netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]})
+	}
+	return addr
+}
+
+func internalAddr(addr netip.Addr) bool {
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		thisNetwork.Contains(addr) || sharedAddressSpace.Contains(addr)
+}
+
+// Interceptor 应答中含有不允许的地址时返回 ErrRebinding
+func (p *RebindProtection) Interceptor() Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := next.RoundTrip(ctx, server, req)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.Check(resp); err != nil {
+				resp.Release()
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+}
+
+// WithRebindProtection 服务端的 RebindProtection, 应答中含有不允许的地址时改为 REFUSED, 不返回任何记录
+func WithRebindProtection(p *RebindProtection) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp, err := next.ServeDNS(ctx, req)
+			if err != nil || resp == nil || p.Check(resp) == nil {
+				return resp, err
+			}
+			resp.Release()
+			refused := NewReply(req.Message)
+			refused.Header.Flags.RCode = DNSRCodeRefused
+			return refused, nil
+		})
+	}
+}
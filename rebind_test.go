@@ -0,0 +1,69 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/netip"
+	"testing"
+)
+
+func TestRebindProtection(t *testing.T) {
+	answers := map[string][]*DNSResourceRecode{
+		"public.example":   {aRecord("public.example", "192.0.2.1")},
+		"evil.example":     {aRecord("evil.example", "192.0.2.1"), aRecord("evil.example", "10.0.0.1")},
+		"loop.example":     {aRecord("loop.example", "127.0.0.1")},
+		"mapped.example":   {{Name: "mapped.example", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "::ffff:192.168.1.1"}},
+		"link.example":     {{Name: "link.example", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "fe80::1"}},
+		"nas.corp.example": {aRecord("nas.corp.example", "192.168.1.10")},
+		"svc.example":      {aRecord("svc.example", "10.1.2.3")},
+		"zero.example":     {aRecord("zero.example", "0.1.2.3")},
+		"cgnat.example":    {aRecord("cgnat.example", "100.64.1.1")},
+		"nat64.example":    {{Name: "nat64.example", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "64:ff9b::a00:1"}},
+		"public64.example": {{Name: "public64.example", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 60, RData: "64:ff9b::c000:201"}},
+	}
+	base := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		for _, rr := range answers[req.Message.Questions[0].QuestionName] {
+			resp.ResourceRecodes = append(resp.ResourceRecodes, rr.Copy())
+		}
+		resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+		return resp, nil
+	})
+	p := &RebindProtection{
+		AllowedNames:    []string{"Corp.Example."},
+		AllowedPrefixes: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+	}
+
+	r := &Resolver{Server: "pipe", Transport: PipeTransport{Server: &DNSServer{Handler: base}}, Interceptors: []Interceptor{p.Interceptor()}}
+	h := ChainHandler(base, WithRebindProtection(p))
+	cases := map[string]bool{
+		"public.example":   true,
+		"evil.example":     false,
+		"loop.example":     false,
+		"mapped.example":   false,
+		"link.example":     false,
+		"nas.corp.example": true,
+		"svc.example":      true,
+		"zero.example":     false,
+		"cgnat.example":    false,
+		"nat64.example":    false,
+		"public64.example": true,
+	}
+	for name, ok := range cases {
+		qtype := uint16(DNSTypeA)
+		if answers[name][0].RRType == DNSTypeAAAA {
+			qtype = DNSTypeAAAA
+		}
+		_, err := r.Query(context.Background(), name, qtype)
+		if ok && err != nil || !ok && !errors.Is(err, ErrRebinding) {
+			t.Fatalf("client %s: %v", name, err)
+		}
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery(name, qtype), Network: "udp"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refused := resp.Header.Flags.RCode == DNSRCodeRefused; refused == ok || refused && len(resp.ResourceRecodes) != 0 {
+			t.Fatalf("server %s: rcode %d, %d records", name, resp.Header.Flags.RCode, len(resp.ResourceRecodes))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var ErrRecordNotFound = errors.New("record name not found")
+
+// RecordSource 服务器的记录来源, 如服务发现系统.
+// 名字不存在时返回 ErrRecordNotFound, 名字存在但没有 qtype 记录时返回空
+type RecordSource interface {
+	LookupRecords(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error)
+}
+
+// RecordSourceFunc 允许将普通函数作为 RecordSource 使用
+type RecordSourceFunc func(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error)
+
+func (f RecordSourceFunc) LookupRecords(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error) {
+	return f(ctx, name, qtype)
+}
+
+// RecordWatcher RecordSource 可选实现的接口, 记录可能变化时调用 fn (例如用于清除缓存), 直到 ctx 结束或出错
+type RecordWatcher interface {
+	Watch(ctx context.Context, fn func()) error
+}
+
+// WithRecordSource 由 source 权威应答 zone 及其下的名字, 其它请求交给 next.
+// source 返回的记录 Name 为空时使用问题中的名字, 查询失败时应答 SERVFAIL
+func WithRecordSource(zone string, source RecordSource) ServerMiddleware {
+	zone = normalizeDomain(zone)
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			msg := req.Message
+			if len(msg.Questions) != 1 || !inZone(msg.Questions[0].QuestionName, zone) {
+				return next.ServeDNS(ctx, req)
+			}
+			q := msg.Questions[0]
+			resp := NewReply(msg)
+			resp.Header.Flags.AA = 1
+			records, err := source.LookupRecords(ctx, normalizeDomain(q.QuestionName), q.QuestionType)
+			if errors.Is(err, ErrRecordNotFound) {
+				resp.Header.Flags.RCode = DNSRCodeNXDomain
+				return resp, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, rr := range records {
+				answer := *rr
+				if answer.Name == "" {
+					answer.Name = q.QuestionName
+				}
+				if answer.Class == 0 {
+					answer.Class = DNSClassIn
+				}
+				resp.ResourceRecodes = append(resp.ResourceRecodes, &answer)
+			}
+			resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+			return resp, nil
+		})
+	}
+}
+
+// inZone name 是否等于 zone 或在 zone 之下, zone 为空时表示根
+func inZone(name, zone string) bool {
+	name = normalizeDomain(name)
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// addressRecord 按 ip 的地址族构造 A 或 AAAA 记录, qtype 不匹配或 ip 无效时返回空
+func addressRecord(name, ip string, qtype uint16, ttl uint32) *DNSResourceRecode {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return nil
+	case addr.To4() != nil && qtype == DNSTypeA:
+		return &DNSResourceRecode{Name: name, RRType: DNSTypeA, Class: DNSClassIn, TTL: ttl, RData: addr.String()}
+	case addr.To4() == nil && qtype == DNSTypeAAAA:
+		return &DNSResourceRecode{Name: name, RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: ttl, RData: addr.String()}
+	}
+	return nil
+}
+
+// srvRecord 构造 SRV 记录
+func srvRecord(name string, priority, weight, port int, target string, ttl uint32) *DNSResourceRecode {
+	return &DNSResourceRecode{
+		Name:   name,
+		RRType: DNSTypeSRV,
+		Class:  DNSClassIn,
+		TTL:    ttl,
+		RData:  strconv.Itoa(priority) + " " + strconv.Itoa(weight) + " " + strconv.Itoa(port) + " " + target,
+	}
+}
+
+// txtRecord 构造 TXT 记录, 超过 255 字节的文本拆分为多个字符串
+func txtRecord(name, text string, ttl uint32) *DNSResourceRecode {
+	data := []byte{}
+	for {
+		n := len(text)
+		if n > 255 {
+			n = 255
+		}
+		data = append(append(data, byte(n)), text[:n]...)
+		if text = text[n:]; text == "" {
+			break
+		}
+	}
+	return &DNSResourceRecode{Name: name, RRType: DNSTypeTXT, Class: DNSClassIn, TTL: ttl, Data: data}
+}
@@ -0,0 +1,175 @@
+package netx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// rdata 拼接记录的 RData, 出错时返回错误信息
+func rdata(records []*DNSResourceRecode, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	var result []string
+	for _, rr := range records {
+		result = append(result, rr.RData)
+	}
+	return strings.Join(result, ", ")
+}
+
+func TestWithRecordSource(t *testing.T) {
+	source := RecordSourceFunc(func(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error) {
+		if name != "api.svc.example" {
+			return nil, ErrRecordNotFound
+		}
+		return []*DNSResourceRecode{srvRecord("", 0, 100, 8080, "node1.svc.example.", 5)}, nil
+	})
+	udpAddr, _ := startDNSServer(t, &DNSServer{Middlewares: []ServerMiddleware{WithRecordSource("svc.example.", source)}})
+	r := &Resolver{Server: udpAddr}
+	srvs, err := r.LookupSRV(context.Background(), "", "", "API.svc.example")
+	if err != nil || len(srvs) != 1 || srvs[0].Port != 8080 || srvs[0].Target != "node1.svc.example" {
+		t.Fatalf("srv = %+v, %v", srvs, err)
+	}
+	if _, err := r.LookupHost(context.Background(), "missing.svc.example"); !isNXDomain(err) {
+		t.Fatalf("missing = %v", err)
+	}
+	if _, err := r.LookupHost(context.Background(), "www.other.example"); err == nil || !strings.Contains(err.Error(), "rcode: 5") {
+		t.Fatalf("other zone = %v", err)
+	}
+}
+
+func TestKubernetesSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/default/services/web":
+			io.WriteString(w, `{"spec":{"clusterIP":"10.96.0.10","clusterIPs":["10.96.0.10","fd00::a"],"ports":[{"name":"http","protocol":"TCP","port":80}]}}`)
+		case "/api/v1/namespaces/default/services/db":
+			io.WriteString(w, `{"spec":{"clusterIP":"None","ports":[{"name":"pg","protocol":"TCP","port":5432}]}}`)
+		case "/api/v1/namespaces/default/endpoints/db":
+			io.WriteString(w, `{"subsets":[{"addresses":[{"ip":"10.1.0.5","hostname":"db-0"},{"ip":"10.1.0.6"}],"ports":[{"name":"pg","port":5432}]}]}`)
+		case "/api/v1/namespaces/default/services/ext":
+			io.WriteString(w, `{"spec":{"type":"ExternalName","externalName":"example.com."}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	s := &KubernetesSource{APIServer: srv.URL, Token: "secret"}
+	ctx := context.Background()
+
+	if got := rdata(s.LookupRecords(ctx, "web.default.svc.cluster.local.", DNSTypeAAAA)); got != "fd00::a" {
+		t.Fatalf("web aaaa = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "_http._tcp.web.default.svc.cluster.local", DNSTypeSRV)); got != "0 100 80 web.default.svc.cluster.local." {
+		t.Fatalf("web srv = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "db.default.svc.cluster.local", DNSTypeA)); got != "10.1.0.5, 10.1.0.6" {
+		t.Fatalf("db = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "db-0.db.default.svc.cluster.local", DNSTypeA)); got != "10.1.0.5" {
+		t.Fatalf("db-0 = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "_pg._tcp.db.default.svc.cluster.local", DNSTypeSRV)); got != "0 100 5432 db-0.db.default.svc.cluster.local., 0 100 5432 10-1-0-6.db.default.svc.cluster.local." {
+		t.Fatalf("db srv = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "ext.default.svc.cluster.local", DNSTypeA)); got != "example.com." {
+		t.Fatalf("ext = %s", got)
+	}
+	if _, err := s.LookupRecords(ctx, "nope.default.svc.cluster.local", DNSTypeA); err != ErrRecordNotFound {
+		t.Fatalf("nope = %v", err)
+	}
+}
+
+func TestEtcdSource(t *testing.T) {
+	kvs := map[string]string{
+		"/skydns/com/example/www":      `{"host":"192.0.2.1","ttl":60}`,
+		"/skydns/com/example/www/x2":   `{"host":"192.0.2.2","port":8080,"priority":10,"weight":5}`,
+		"/skydns/com/example/www-1":    `{"host":"192.0.2.9"}`,
+		"/skydns/com/example/alias":    `{"host":"www.example.com"}`,
+		"/skydns/com/example/txt/info": `{"text":"hello"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		key, _ := base64.StdEncoding.DecodeString(req["key"])
+		end, _ := base64.StdEncoding.DecodeString(req["range_end"])
+		var result []etcdKV
+		for k, v := range kvs {
+			if k >= string(key) && k < string(end) {
+				result = append(result, etcdKV{Key: base64.StdEncoding.EncodeToString([]byte(k)), Value: base64.StdEncoding.EncodeToString([]byte(v))})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"kvs": result})
+	}))
+	defer srv.Close()
+	s := &EtcdSource{Endpoint: srv.URL}
+	ctx := context.Background()
+
+	records, err := s.LookupRecords(ctx, "www.example.com", DNSTypeA)
+	got := rdata(records, err)
+	if got != "192.0.2.1, 192.0.2.2" && got != "192.0.2.2, 192.0.2.1" {
+		t.Fatalf("www = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "alias.example.com", DNSTypeA)); got != "www.example.com" {
+		t.Fatalf("alias = %s", got)
+	}
+	records, err = s.LookupRecords(ctx, "txt.example.com", DNSTypeTXT)
+	if err != nil || len(records) != 1 || string(records[0].Data) != "\x05hello" {
+		t.Fatalf("txt = %v, %v", records, err)
+	}
+	if _, err := s.LookupRecords(ctx, "none.example.com", DNSTypeA); err != ErrRecordNotFound {
+		t.Fatalf("none = %v", err)
+	}
+}
+
+func TestConsulSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/health/service/web":
+			if r.URL.Query().Get("passing") != "1" {
+				t.Errorf("query = %s", r.URL.RawQuery)
+			}
+			if r.URL.Query().Get("tag") == "v2" {
+				io.WriteString(w, `[]`)
+				return
+			}
+			io.WriteString(w, `[{"Node":{"Node":"n1","Address":"10.0.0.1","Datacenter":"dc1"},"Service":{"Port":8080}},
+				{"Node":{"Node":"n2","Address":"10.0.0.2","Datacenter":"dc1"},"Service":{"Address":"10.0.1.2","Port":8081}}]`)
+		case "/v1/catalog/node/n1":
+			io.WriteString(w, `{"Node":{"Address":"10.0.0.1"}}`)
+		case "/v1/catalog/node/n9":
+			io.WriteString(w, `null`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	s := &ConsulSource{Address: srv.URL}
+	ctx := context.Background()
+
+	if got := rdata(s.LookupRecords(ctx, "web.service.consul", DNSTypeA)); got != "10.0.0.1, 10.0.1.2" {
+		t.Fatalf("web = %s", got)
+	}
+	if got := rdata(s.LookupRecords(ctx, "web.service.dc1.consul", DNSTypeSRV)); got != "1 1 8080 n1.node.dc1.consul., 1 1 8081 n2.node.dc1.consul." {
+		t.Fatalf("web srv = %s", got)
+	}
+	if _, err := s.LookupRecords(ctx, "v2.web.service.consul", DNSTypeA); err != ErrRecordNotFound {
+		t.Fatalf("tag = %v", err)
+	}
+	if got := rdata(s.LookupRecords(ctx, "n1.node.consul", DNSTypeA)); got != "10.0.0.1" {
+		t.Fatalf("node = %s", got)
+	}
+	if _, err := s.LookupRecords(ctx, "n9.node.consul", DNSTypeA); err != ErrRecordNotFound {
+		t.Fatalf("missing node = %v", err)
+	}
+}
@@ -0,0 +1,479 @@
+package netx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var (
+	ErrTxIDMismatch      = errors.New("response txid mismatch")
+	ErrMultipleQuestions = errors.New("only one question per message is supported")
+)
+
+// RoundTripper 发送一个 DNS 请求并返回响应, 与 http.RoundTripper 类似
+type RoundTripper interface {
+	RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error)
+}
+
+// RoundTripperFunc 允许将普通函数作为 RoundTripper 使用
+type RoundTripperFunc func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error)
+
+func (f RoundTripperFunc) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	return f(ctx, server, req)
+}
+
+// UDPTransport 通过 UDP 发送请求
+type UDPTransport struct {
+	// Dialer 不为空时通过它建立连接, 例如使用 SOCKS5Dialer 经代理查询
+	Dialer Dialer
+	// Guard 不为空时丢弃 TxID 或问题不匹配的响应并继续等待, 同时向 Guard 报告;
+	// Guard 判定上游可能受到投毒攻击时改用 TCP
+	Guard *PoisonGuard
+}
+
+func (t UDPTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	if t.Guard != nil {
+		if t.Guard.UseTCP(server) {
+			return TCPTransport{Dialer: t.Dialer}.RoundTrip(ctx, server, req)
+		}
+		defer t.Guard.begin(server, req)()
+	}
+	info := queryInfoFrom(ctx)
+	info.attempt("udp")
+	var dialer Dialer = &net.Dialer{}
+	if t.Dialer != nil {
+		dialer = t.Dialer
+	}
+	conn, err := info.dial(ctx, dialer, t.Dialer == nil, "udp", server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithMessage(err, "set deadline error")
+		}
+	}
+
+	toByte, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if _, err := conn.Write(toByte); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+	info.wrote(start)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	var mismatch error
+	for {
+		length, err := conn.Read(buf)
+		if err != nil {
+			if mismatch != nil {
+				return nil, mismatch
+			}
+			return nil, errors.WithMessage(err, "read error")
+		}
+		info.firstByte()
+		resp, err := UnpackPooled(buf[:length])
+		if err == nil && resp.Header.TxID == req.Header.TxID && (t.Guard == nil || sameQuestion(req, resp)) {
+			return resp, nil
+		}
+		if t.Guard == nil {
+			if err != nil {
+				return nil, err
+			}
+			resp.Release()
+			return nil, ErrTxIDMismatch
+		}
+		event := PoisonUnsolicited
+		if err == nil && resp.Header.TxID != req.Header.TxID && sameQuestion(req, resp) {
+			event = PoisonTxIDMismatch
+		}
+		resp.Release()
+		t.Guard.Report(server, event)
+		mismatch = ErrTxIDMismatch
+	}
+}
+
+// TCPTransport 通过 TCP 发送请求 (RFC 7766), Dialer 使用 WithTLS 时即为 DNS over TLS
+type TCPTransport struct {
+	// Dialer 为空时直接连接
+	Dialer Dialer
+	// Pool 不为空时复用连接, 此时忽略 Dialer, 由 Pool.Dialer 建立连接
+	Pool *ConnPool
+}
+
+func (t TCPTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	info := queryInfoFrom(ctx)
+	info.attempt("tcp")
+	var dialer Dialer = &net.Dialer{}
+	if t.Pool != nil {
+		dialer = t.Pool
+	} else if t.Dialer != nil {
+		dialer = t.Dialer
+	}
+	conn, err := info.dial(ctx, dialer, t.Pool == nil && t.Dialer == nil, "tcp", server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	resp, err := tcpExchange(ctx, conn, req)
+	if err != nil {
+		if pc, ok := conn.(*PooledConn); ok {
+			pc.MarkBroken()
+		}
+	}
+	_ = conn.Close()
+	return resp, err
+}
+
+// tcpExchange 以两字节长度前缀收发一条消息
+func tcpExchange(ctx context.Context, conn net.Conn, req *DNSMessage) (*DNSMessage, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithMessage(err, "set deadline error")
+		}
+	}
+	toByte, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	if len(toByte) > 0xFFFF {
+		return nil, errors.New("dns message too long")
+	}
+	info := queryInfoFrom(ctx)
+	start := time.Now()
+	if _, err := conn.Write(append([]byte{byte(len(toByte) >> 8), byte(len(toByte))}, toByte...)); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+	info.wrote(start)
+
+	size := make([]byte, 2)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	info.firstByte()
+	buf := getBuffer()[:int(size[0])<<8|int(size[1])]
+	defer putBuffer(buf)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	resp, err := UnpackPooled(buf)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.TxID != req.Header.TxID {
+		resp.Release()
+		return nil, ErrTxIDMismatch
+	}
+	return resp, nil
+}
+
+// Resolver 向 Server 发起 DNS 查询
+type Resolver struct {
+	Server  string        // 服务器地址, host:port
+	Timeout time.Duration // 单次查询超时, 默认 5s
+
+	// Transport 为空时使用 UDPTransport
+	Transport RoundTripper
+	// Interceptors 按顺序包裹 Transport, 第一个拦截器最先看到请求
+	Interceptors []Interceptor
+	// NameProfile Lookup 系列方法检查调用者传入的名字的规则, 不合法时不发送请求, 返回 ErrInvalidName.
+	// 方法自己加上的服务标签 (例如 LookupSRV 的 _service._proto) 不受影响
+	NameProfile NameProfile
+}
+
+func (r *Resolver) transport() RoundTripper {
+	var rt RoundTripper = UDPTransport{}
+	if r.Transport != nil {
+		rt = r.Transport
+	}
+	for i := len(r.Interceptors) - 1; i >= 0; i-- {
+		rt = r.Interceptors[i](rt)
+	}
+	return rt
+}
+
+// Exchange 发送 req 并返回响应. 内置的 Transport 返回的响应来自对象池, 可以在使用完后调用 Release.
+// Lookup 系列方法在提取结果后回收响应, AfterReceive 等拦截器需要保留响应时先 CopyToOwn.
+// 一个请求只能有一个问题, 否则返回 ErrMultipleQuestions
+func (r *Resolver) Exchange(ctx context.Context, req *DNSMessage) (*DNSMessage, error) {
+	if len(req.Questions) > 1 {
+		return nil, ErrMultipleQuestions
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if info := queryInfoFrom(ctx); info != nil {
+		start := time.Now()
+		defer func() { info.Total = time.Since(start) }()
+	}
+	return r.transport().RoundTrip(ctx, r.Server, req)
+}
+
+// Warm 预先建立到 Server 的连接, 减少第一次查询的延迟. Transport 为使用连接池的 TCPTransport 时
+// 在池中放入 MaxIdle 个空闲连接 (DoT 时已完成 TLS 握手); 其它 Transport 直接发送一个根 NS 查询,
+// 使 DoH 的 HTTP 连接保持打开, WithTLS 缓存会话票据. 不经过 Interceptors
+func (r *Resolver) Warm(ctx context.Context) error {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if t, ok := r.Transport.(TCPTransport); ok && t.Pool != nil {
+		n := t.Pool.maxIdle()
+		if t.Pool.MaxActive > 0 && t.Pool.MaxActive < n {
+			n = t.Pool.MaxActive
+		}
+		conns := make([]*PooledConn, 0, n)
+		defer func() {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+		}()
+		for len(conns) < n {
+			c, err := t.Pool.Get(ctx, "tcp", r.Server)
+			if err != nil {
+				return err
+			}
+			conns = append(conns, c)
+		}
+		return nil
+	}
+	rt := r.Transport
+	if rt == nil {
+		rt = UDPTransport{}
+	}
+	resp, err := rt.RoundTrip(ctx, r.Server, NewQuery("", DNSTypeNS))
+	if err != nil {
+		return err
+	}
+	resp.Release()
+	return nil
+}
+
+// Query 查询 host 的 qtype 记录, opts 可以修改类别与标志位, 如 WithClass(DNSClassChaos)
+func (r *Resolver) Query(ctx context.Context, host string, qtype uint16, opts ...QueryOption) (*DNSMessage, error) {
+	return r.Exchange(ctx, NewQuery(host, qtype, opts...))
+}
+
+// randTxID 返回 crypto/rand 生成的事务 ID, 可预测的 ID 会让离路径的攻击者伪造应答
+func randTxID() uint16 {
+	return uint16(randUint32())
+}
+
+// randUint32 返回 crypto/rand 生成的随机数, 用于 DHCP xid 等同样需要不可预测的标识
+func randUint32() uint32 {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// NewQuery 构造一个期望递归的标准查询, 再依次应用 opts
+func NewQuery(host string, qtype uint16, opts ...QueryOption) *DNSMessage {
+	m := &DNSMessage{
+		Header: &DNSHeader{
+			TxID: randTxID(),
+			Flags: &DNSFlags{
+				RD: 1,
+			},
+			Questions: 1,
+		},
+		Questions: []*DNSQuestion{
+			{
+				QuestionName:  host,
+				QuestionType:  qtype,
+				QuestionClass: DNSClassIn,
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// RCodeError 响应码不为 0 时返回
+type RCodeError struct {
+	RCode uint16
+}
+
+func (e *RCodeError) Error() string {
+	return "dns response rcode: " + strconv.Itoa(int(e.RCode)) + " (" + DNSRCode(e.RCode).String() + ")"
+}
+
+// LookupHost 查询 host 的 A 记录
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	if err := r.NameProfile.Check(host); err != nil {
+		return nil, err
+	}
+	return r.lookup(ctx, host, DNSTypeA)
+}
+
+// LookupAddr 反向查询 ip 对应的域名
+func (r *Resolver) LookupAddr(ctx context.Context, ip string) ([]string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, ErrInvalidIP
+	}
+	return r.lookup(ctx, ReverseName(addr), DNSTypePTR)
+}
+
+// LookupTXT 查询 name 的 TXT 记录, 每条记录的多个字符串直接拼接
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err := r.NameProfile.Check(name); err != nil {
+		return nil, err
+	}
+	return r.lookupTXT(ctx, name)
+}
+
+func (r *Resolver) lookupTXT(ctx context.Context, name string, opts ...QueryOption) ([]string, error) {
+	resp, err := r.Query(ctx, name, DNSTypeTXT, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Release()
+	if resp.Header.Flags.RCode != DNSRCodeSuccess {
+		return nil, &RCodeError{RCode: resp.Header.Flags.RCode}
+	}
+	var result []string
+	for _, rr := range resp.Answers() {
+		if rr.RRType != DNSTypeTXT {
+			continue
+		}
+		txt, err := parseTXT(rr.Data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, txt)
+	}
+	return result, nil
+}
+
+// LookupMX 查询 name 的 MX 记录, 按优先级排序
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if err := r.NameProfile.Check(name); err != nil {
+		return nil, err
+	}
+	records, err := r.lookup(ctx, name, DNSTypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var result []*net.MX
+	for _, record := range records {
+		fields := strings.Fields(record)
+		if len(fields) != 2 {
+			continue
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			continue
+		}
+		result = append(result, &net.MX{Host: fields[1], Pref: uint16(pref)})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Pref < result[j].Pref })
+	return result, nil
+}
+
+// LookupSRV 查询 _service._proto.name 的 SRV 记录, service 与 proto 都为空时直接查询 name. 按优先级排序, 同优先级权重高的在前
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, error) {
+	if err := r.NameProfile.Check(name); err != nil {
+		return nil, err
+	}
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	records, err := r.lookup(ctx, name, DNSTypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	var result []*net.SRV
+	for _, record := range records {
+		srv, err := parseSRV(record)
+		if err != nil {
+			continue
+		}
+		result = append(result, srv)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Priority != result[j].Priority {
+			return result[i].Priority < result[j].Priority
+		}
+		return result[i].Weight > result[j].Weight
+	})
+	return result, nil
+}
+
+// parseSRV 解析 "优先级 权重 端口 域名" 格式的 RData
+func parseSRV(rdata string) (*net.SRV, error) {
+	fields := strings.Fields(rdata)
+	if len(fields) != 4 {
+		return nil, ErrBadRData
+	}
+	var values [3]uint16
+	for i, field := range fields[:3] {
+		v, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, ErrBadRData
+		}
+		values[i] = uint16(v)
+	}
+	return &net.SRV{Priority: values[0], Weight: values[1], Port: values[2], Target: fields[3]}, nil
+}
+
+// isNXDomain err 是否为 NXDOMAIN 应答
+func isNXDomain(err error) bool {
+	var rcode *RCodeError
+	return errors.As(err, &rcode) && rcode.RCode == DNSRCodeNXDomain
+}
+
+// parseTXT 拼接 RDATA 中以长度为前缀的字符串
+func parseTXT(data []byte) (string, error) {
+	var sb strings.Builder
+	for len(data) > 0 {
+		n := int(data[0])
+		if 1+n > len(data) {
+			return "", ErrBadRData
+		}
+		sb.Write(data[1 : 1+n])
+		data = data[1+n:]
+	}
+	return sb.String(), nil
+}
+
+func (r *Resolver) lookup(ctx context.Context, name string, qtype uint16) ([]string, error) {
+	resp, err := r.Query(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Release()
+	if resp.Header.Flags.RCode != DNSRCodeSuccess {
+		return nil, &RCodeError{RCode: resp.Header.Flags.RCode}
+	}
+	var result []string
+	for _, rr := range resp.Answers() {
+		if rr.RRType == qtype && rr.RData != "" {
+			result = append(result, rr.RData)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// UDPListenOptions ListenUDPReusePort 的选项, 零值表示默认
+type UDPListenOptions struct {
+	// Sockets socket 数量, 默认 runtime.NumCPU()
+	Sockets int
+	// ReadBuffer WriteBuffer 每个 socket 的内核收发缓冲区大小, 为 0 时使用系统默认
+	ReadBuffer  int
+	WriteBuffer int
+	// Binding 不为空时绑定到源地址或网卡
+	Binding *Binding
+}
+
+func (o *UDPListenOptions) sockets() int {
+	if o != nil && o.Sockets > 0 {
+		return o.Sockets
+	}
+	return runtime.NumCPU()
+}
+
+// ListenUDPReusePort 用 SO_REUSEPORT 在同一地址上打开多个 udp socket, 内核按来源地址在它们之间分配请求.
+// 端口为 0 时所有 socket 使用第一个 socket 分配到的端口. 不支持 SO_REUSEPORT 的系统只打开一个 socket
+func ListenUDPReusePort(ctx context.Context, network, address string, opts *UDPListenOptions) ([]net.PacketConn, error) {
+	n := opts.sockets()
+	var binding *Binding
+	if opts != nil {
+		binding = opts.Binding
+	}
+	address, err := binding.listenAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+	reuse := true
+	lc := net.ListenConfig{
+		Control: func(network, address string, raw syscall.RawConn) error {
+			if err := binding.control(network, address, raw); err != nil {
+				return err
+			}
+			var serr error
+			if err := raw.Control(func(fd uintptr) {
+				serr = setSockoptReusePort(fd)
+			}); err != nil {
+				return err
+			}
+			if serr == ErrNotSupported {
+				reuse = false
+				return nil
+			}
+			return serr
+		},
+	}
+	var conns []net.PacketConn
+	for i := 0; i < n; i++ {
+		conn, err := lc.ListenPacket(ctx, network, address)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		if err := setUDPBuffers(conn, opts); err != nil {
+			_ = conn.Close()
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+		if !reuse {
+			break
+		}
+		address = conn.LocalAddr().String()
+	}
+	return conns, nil
+}
+
+func setUDPBuffers(conn net.PacketConn, opts *UDPListenOptions) error {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok || opts == nil {
+		return nil
+	}
+	if opts.ReadBuffer > 0 {
+		if err := udp.SetReadBuffer(opts.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.WriteBuffer > 0 {
+		if err := udp.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeUDPConns 在每个 conn 上运行一个 ServeUDP 读取循环, 直到全部结束, 返回第一个错误
+func (s *DNSServer) ServeUDPConns(conns []net.PacketConn) error {
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn net.PacketConn) {
+			errs <- s.ServeUDP(conn)
+		}(conn)
+	}
+	var first error
+	for range conns {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,46 @@
+package netx
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+func TestListenUDPReusePort(t *testing.T) {
+	conns, err := ListenUDPReusePort(context.Background(), "udp", "127.0.0.1:0", &UDPListenOptions{Sockets: 4, ReadBuffer: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 4
+	if runtime.GOOS == "windows" || runtime.GOOS == "solaris" {
+		want = 1
+	}
+	if len(conns) != want {
+		t.Fatalf("sockets = %d, want %d", len(conns), want)
+	}
+	for _, conn := range conns[1:] {
+		if conn.LocalAddr().String() != conns[0].LocalAddr().String() {
+			t.Fatalf("addr = %s, want %s", conn.LocalAddr(), conns[0].LocalAddr())
+		}
+	}
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{Name: "www.example.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "192.0.2.1"}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeUDPConns(conns) }()
+	r := &Resolver{Server: conns[0].LocalAddr().String()}
+	for i := 0; i < 20; i++ {
+		if _, err := r.LookupHost(context.Background(), "www.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("serve = %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotReverseName = errors.New("not a reverse lookup name")
+	ErrBadPrefix      = errors.New("prefix not supported")
+)
+
+const hexDigit = "0123456789abcdef"
+
+// ReverseName 返回 ip 对应的 in-addr.arpa 或 ip6.arpa 名字, ipv4 映射的 ipv6 地址按 ipv4 处理
+func ReverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return strconv.Itoa(int(v4[3])) + "." + strconv.Itoa(int(v4[2])) + "." +
+			strconv.Itoa(int(v4[1])) + "." + strconv.Itoa(int(v4[0])) + ".in-addr.arpa"
+	}
+	var sb strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		sb.WriteByte(hexDigit[ip[i]&0x0F])
+		sb.WriteByte('.')
+		sb.WriteByte(hexDigit[ip[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa")
+	return sb.String()
+}
+
+// ReverseAddr 与 ReverseName 相同, 参数为 netip.Addr
+func ReverseAddr(addr netip.Addr) string {
+	return ReverseName(net.IP(addr.AsSlice()))
+}
+
+// ParseReverseName 从 in-addr.arpa 或 ip6.arpa 名字取回地址, 不区分大小写, 可以带末尾的点.
+// 也接受 RFC 2317 无类别委派中的名字, 如 5.0/26.2.0.192.in-addr.arpa
+func ParseReverseName(name string) (net.IP, error) {
+	lower := strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case strings.HasSuffix(lower, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(lower, ".in-addr.arpa"), ".")
+		if len(labels) == 5 && isClasslessLabel(labels[1]) {
+			labels = append(labels[:1], labels[2:]...)
+		}
+		if len(labels) != 4 {
+			return nil, errors.WithMessage(ErrNotReverseName, name)
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 10, 8)
+			if err != nil || label != strconv.FormatUint(v, 10) {
+				return nil, errors.WithMessage(ErrNotReverseName, name)
+			}
+			ip[3-i] = byte(v)
+		}
+		return ip, nil
+	case strings.HasSuffix(lower, ".ip6.arpa"):
+		labels := strings.Split(strings.TrimSuffix(lower, ".ip6.arpa"), ".")
+		if len(labels) != 32 {
+			return nil, errors.WithMessage(ErrNotReverseName, name)
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, label := range labels {
+			if len(label) != 1 || strings.IndexByte(hexDigit, label[0]) < 0 {
+				return nil, errors.WithMessage(ErrNotReverseName, name)
+			}
+			nibble := byte(strings.IndexByte(hexDigit, label[0]))
+			if i%2 == 0 {
+				ip[15-i/2] |= nibble
+			} else {
+				ip[15-i/2] |= nibble << 4
+			}
+		}
+		return ip, nil
+	}
+	return nil, errors.WithMessage(ErrNotReverseName, name)
+}
+
+// isClasslessLabel RFC 2317 中表示子网的 label, 如 0/26 或 0-63
+func isClasslessLabel(label string) bool {
+	return strings.ContainsAny(label, "/-")
+}
+
+// ReverseZone 返回包含 prefix 的反向区域名. ipv4 按 8 位, ipv6 按 4 位向下取整,
+// 如 10.1.0.0/16 为 1.10.in-addr.arpa, 192.0.2.0/26 为 2.0.192.in-addr.arpa
+func ReverseZone(prefix netip.Prefix) string {
+	addr := prefix.Addr()
+	step, suffix := 8, "in-addr.arpa"
+	if !addr.Is4() {
+		step, suffix = 4, "ip6.arpa"
+	}
+	labels := strings.Split(ReverseAddr(addr), ".")
+	keep := prefix.Bits() / step
+	// 去掉末尾的 in-addr.arpa 或 ip6.arpa 两个 label 后, 保留高位的 keep 个
+	labels = labels[:len(labels)-2]
+	return strings.Join(append(labels[len(labels)-keep:], suffix), ".")
+}
+
+// ClasslessReverseZone 返回 RFC 2317 中为 prefix 委派的区域名, 如 192.0.2.64/26 为 64/26.2.0.192.in-addr.arpa.
+// prefix 必须是 /25 到 /32 的 ipv4 网段
+func ClasslessReverseZone(prefix netip.Prefix) (string, error) {
+	prefix = prefix.Masked()
+	if !prefix.Addr().Is4() || prefix.Bits() <= 24 {
+		return "", errors.WithMessage(ErrBadPrefix, prefix.String())
+	}
+	a := prefix.Addr().As4()
+	return strconv.Itoa(int(a[3])) + "/" + strconv.Itoa(prefix.Bits()) + "." + ReverseZone(netip.PrefixFrom(prefix.Addr(), 24)), nil
+}
+
+// ClasslessReverseName 返回 ip 在 RFC 2317 委派区域中的名字, 父区域中 ReverseName(ip) 以 CNAME 指向该名字
+func ClasslessReverseName(ip net.IP, prefix netip.Prefix) (string, error) {
+	zone, err := ClasslessReverseZone(prefix)
+	if err != nil {
+		return "", err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || !prefix.Contains(addr.Unmap()) {
+		return "", errors.WithMessagef(ErrBadPrefix, "%v not in %v", ip, prefix)
+	}
+	a := addr.Unmap().As4()
+	return strconv.Itoa(int(a[3])) + "." + zone, nil
+}
@@ -0,0 +1,60 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestReverseName(t *testing.T) {
+	for ip, name := range map[string]string{
+		"192.0.2.5":   "5.2.0.192.in-addr.arpa",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	} {
+		if got := ReverseName(net.ParseIP(ip)); got != name {
+			t.Errorf("ReverseName(%s) = %s", ip, got)
+		}
+		if got := ReverseAddr(netip.MustParseAddr(ip)); got != name {
+			t.Errorf("ReverseAddr(%s) = %s", ip, got)
+		}
+		back, err := ParseReverseName(name + ".")
+		if err != nil || !back.Equal(net.ParseIP(ip)) {
+			t.Errorf("ParseReverseName(%s) = %v, %v", name, back, err)
+		}
+	}
+	if ip, err := ParseReverseName("5.0/26.2.0.192.IN-ADDR.ARPA"); err != nil || ip.String() != "192.0.2.5" {
+		t.Fatalf("classless name = %v, %v", ip, err)
+	}
+	for _, name := range []string{"2.0.192.in-addr.arpa", "256.2.0.192.in-addr.arpa", "05.2.0.192.in-addr.arpa", "x.ip6.arpa", "www.example.com"} {
+		if _, err := ParseReverseName(name); errors.Cause(err) != ErrNotReverseName {
+			t.Errorf("ParseReverseName(%s) error = %v", name, err)
+		}
+	}
+
+	for prefix, zone := range map[string]string{
+		"10.0.0.0/8":      "10.in-addr.arpa",
+		"10.1.0.0/16":     "1.10.in-addr.arpa",
+		"192.0.2.64/26":   "2.0.192.in-addr.arpa",
+		"2001:db8::/32":   "8.b.d.0.1.0.0.2.ip6.arpa",
+		"2001:db8:1::/50": "1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	} {
+		if got := ReverseZone(netip.MustParsePrefix(prefix)); got != zone {
+			t.Errorf("ReverseZone(%s) = %s, want %s", prefix, got, zone)
+		}
+	}
+
+	prefix := netip.MustParsePrefix("192.0.2.64/26")
+	if zone, err := ClasslessReverseZone(prefix); err != nil || zone != "64/26.2.0.192.in-addr.arpa" {
+		t.Fatalf("ClasslessReverseZone = %s, %v", zone, err)
+	}
+	if name, err := ClasslessReverseName(net.ParseIP("192.0.2.70"), prefix); err != nil || name != "70.64/26.2.0.192.in-addr.arpa" {
+		t.Fatalf("ClasslessReverseName = %s, %v", name, err)
+	}
+	if _, err := ClasslessReverseName(net.ParseIP("192.0.2.5"), prefix); errors.Cause(err) != ErrBadPrefix {
+		t.Fatalf("address outside prefix error = %v", err)
+	}
+	if _, err := ClasslessReverseZone(netip.MustParsePrefix("192.0.0.0/16")); errors.Cause(err) != ErrBadPrefix {
+		t.Fatalf("/16 error = %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRootMirrorRetry = time.Minute
+
+var ErrRootMirrorUnavailable = errors.New("no root zone mirror available")
+
+// DefaultRootMirrorServers 允许传输根区域的服务器 (RFC 8806 附录 A)
+var DefaultRootMirrorServers = []string{
+	"170.247.170.2:53", // b.root-servers.net
+	"192.33.4.12:53",   // c.root-servers.net
+	"199.7.91.13:53",   // d.root-servers.net
+	"192.5.5.241:53",   // f.root-servers.net
+	"192.112.36.4:53",  // g.root-servers.net
+	"193.0.14.129:53",  // k.root-servers.net
+	"192.0.32.132:53",  // lax.xfr.dns.icann.org
+	"192.0.47.132:53",  // iad.xfr.dns.icann.org
+}
+
+// RootMirror RFC 8806 的本地根区域副本: 通过 AXFR 取得根区域, 全部签名通过 DNSSEC 验证后才使用,
+// 按 SOA 的 refresh 与 retry 检查序列号, 超过 expire 没有成功检查时认为副本过期, 不再使用. 可以并发使用
+type RootMirror struct {
+	// Servers 依次尝试的服务器 (host:port), 为空时使用 DefaultRootMirrorServers
+	Servers []string
+	// Anchors 验证根区域 DNSKEY 的信任锚, 为空时使用根区域的 KSK
+	Anchors []*DS
+	// Dialer 连接服务器使用的 Dialer, 可以经 WithTLS 完成 XoT
+	Dialer Dialer
+	// Timeout 查询 SOA 与读取每条传输消息的超时, 默认同 Resolver
+	Timeout time.Duration
+
+	mu      sync.RWMutex
+	zone    *Zone
+	timers  soaTimers
+	checked time.Time // 最后一次成功检查序列号的时间
+	lastErr error
+}
+
+// RootMirrorStatus 副本的状态
+type RootMirrorStatus struct {
+	Serial      uint32    `json:"serial"`
+	Loaded      bool      `json:"loaded"`
+	Stale       bool      `json:"stale"`
+	LastChecked time.Time `json:"last_checked,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// soaTimers SOA RDATA 中的序列号与时间
+type soaTimers struct {
+	serial, refresh, retry, expire, minimum uint32
+}
+
+func parseSOATimers(rr *DNSResourceRecode) (soaTimers, bool) {
+	var t soaTimers
+	if rr == nil {
+		return t, false
+	}
+	fields := strings.Fields(rr.RData)
+	if len(fields) != 7 {
+		return t, false
+	}
+	for i, p := range []*uint32{&t.serial, &t.refresh, &t.retry, &t.expire, &t.minimum} {
+		v, err := strconv.ParseUint(fields[2+i], 10, 32)
+		if err != nil {
+			return t, false
+		}
+		*p = uint32(v)
+	}
+	return t, true
+}
+
+func (m *RootMirror) servers() []string {
+	if len(m.Servers) > 0 {
+		return m.Servers
+	}
+	return DefaultRootMirrorServers
+}
+
+// Refresh 检查服务器上的序列号, 比本地新或者还没有副本时传输并验证整个区域, 返回是否更新了副本.
+// 所有服务器都失败时返回最后一个错误, 原有的副本保持不变
+func (m *RootMirror) Refresh(ctx context.Context) (bool, error) {
+	err := ErrRootMirrorUnavailable
+	for _, server := range m.servers() {
+		var updated bool
+		if updated, err = m.refresh(ctx, server); err == nil {
+			return updated, nil
+		}
+	}
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+	return false, err
+}
+
+func (m *RootMirror) refresh(ctx context.Context, server string) (bool, error) {
+	r := &Resolver{Server: server, Timeout: m.Timeout, Transport: TCPTransport{Dialer: m.Dialer}}
+	req := NewQuery("", DNSTypeSOA)
+	req.Header.Flags.RD = 0
+	resp, err := r.Exchange(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	var remote soaTimers
+	ok := false
+	for _, rr := range resp.Answers() {
+		if rr.RRType == DNSTypeSOA && normalizeDomain(rr.Name) == "" {
+			remote, ok = parseSOATimers(rr)
+		}
+	}
+	resp.Release()
+	if !ok {
+		return false, errors.WithMessage(ErrBadTransfer, "no soa from "+server)
+	}
+	m.mu.RLock()
+	current, loaded := m.timers, m.zone != nil
+	m.mu.RUnlock()
+	if loaded && !SerialLess(current.serial, remote.serial) {
+		// 服务器的序列号比本地旧时不使用这个服务器
+		if err := CheckSerial(current.serial, remote.serial); err != nil {
+			return false, errors.WithMessage(err, server)
+		}
+		m.mu.Lock()
+		m.checked, m.lastErr = time.Now(), nil
+		m.mu.Unlock()
+		return false, nil
+	}
+
+	z, err := r.TransferZone(ctx, "")
+	if err != nil {
+		return false, err
+	}
+	timers, ok := parseSOATimers(z.SOA())
+	if !ok {
+		return false, errors.WithMessage(ErrBadTransfer, "bad soa from "+server)
+	}
+	if loaded && !SerialLess(current.serial, timers.serial) {
+		return false, errors.WithMessage(&SerialRegressionError{Old: current.serial, New: timers.serial}, server)
+	}
+	anchors := m.Anchors
+	if len(anchors) == 0 {
+		anchors = (&DNSSECValidator{}).anchors("")
+	}
+	if err := verifyZone(z, anchors, time.Now()); err != nil {
+		return false, err
+	}
+	if err := checkZONEMD(z); err != nil {
+		return false, errors.WithMessage(err, server)
+	}
+	m.mu.Lock()
+	m.zone, m.timers, m.checked, m.lastErr = z, timers, time.Now(), nil
+	m.mu.Unlock()
+	return true, nil
+}
+
+// Run 按 SOA 的 refresh 间隔调用 Refresh, 失败时按 retry 间隔重试, 直到 ctx 结束
+func (m *RootMirror) Run(ctx context.Context) error {
+	for {
+		_, err := m.Refresh(ctx)
+		m.mu.RLock()
+		wait := time.Duration(m.timers.refresh) * time.Second
+		if err != nil {
+			wait = time.Duration(m.timers.retry) * time.Second
+		}
+		m.mu.RUnlock()
+		if wait <= 0 {
+			wait = defaultRootMirrorRetry
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Zone 返回当前可用的副本, 没有副本或已过期时返回空
+func (m *RootMirror) Zone() *Zone {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.zone == nil || m.stale(time.Now()) {
+		return nil
+	}
+	return m.zone
+}
+
+func (m *RootMirror) stale(now time.Time) bool {
+	return !now.Before(m.checked.Add(time.Duration(m.timers.expire) * time.Second))
+}
+
+// Status 返回副本的状态
+func (m *RootMirror) Status() RootMirrorStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s := RootMirrorStatus{Loaded: m.zone != nil, LastChecked: m.checked}
+	if m.zone != nil {
+		s.Serial = m.timers.serial
+		s.Expires = m.checked.Add(time.Duration(m.timers.expire) * time.Second)
+		s.Stale = m.stale(time.Now())
+	}
+	if m.lastErr != nil {
+		s.Error = m.lastErr.Error()
+	}
+	return s
+}
+
+// ServeDNS 用副本作为根服务器应答, 可以监听在回环地址上供迭代解析器使用.
+// 没有可用的副本时应答 SERVFAIL, 让解析器改用真正的根服务器
+func (m *RootMirror) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	z := m.Zone()
+	if z == nil {
+		resp := NewReply(req.Message)
+		resp.Header.Flags.RCode = DNSRCodeServFail
+		return resp, nil
+	}
+	return z.ServeDNS(ctx, req)
+}
+
+// WithRootMirror 根区域能直接给出权威应答的请求 (例如不存在的顶级域名与根区域本身的数据) 用副本应答,
+// 不再转发; 引荐, 设置了 DO 位的请求 (副本不附带不存在证明) 以及副本不可用时交给 next
+func WithRootMirror(m *RootMirror) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			z := m.Zone()
+			if z == nil || len(req.Message.Questions) != 1 || req.Message.DNSSECOK() {
+				return next.ServeDNS(ctx, req)
+			}
+			resp, err := z.ServeDNS(ctx, req)
+			if err != nil || resp.Header.Flags.AA == 0 {
+				return next.ServeDNS(ctx, req)
+			}
+			resp.Header.Flags.AA, resp.Header.Flags.RA = 0, 1
+			return resp, nil
+		})
+	}
+}
+
+// verifyZone 验证区域的签名: 顶点的 DNSKEY 必须由 anchors 指向的密钥签名, 其余权威数据
+// (委派点只有 DS 与 NSEC, 委派之下的胶水记录不签名) 都必须有能用这些 DNSKEY 验证的签名
+func verifyZone(z *Zone, anchors []*DS, now time.Time) error {
+	keyset := z.Records(z.Origin, DNSTypeDNSKEY)
+	var keys []*DNSKEY
+	for _, rr := range keyset {
+		if key, err := ParseDNSKEY(rr.Data); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return errors.WithMessage(ErrDNSSECBogus, "no dnskey at zone apex")
+	}
+	var anchored []*DNSKEY
+	for _, key := range keys {
+		if matchesAnyDS(anchors, z.Origin, key) {
+			anchored = append(anchored, key)
+		}
+	}
+	if !verifiedByAny(coveringRRSIGs(z.Records(z.Origin, DNSTypeRRSIG), keyset[0]), anchored, keyset, now) {
+		return errors.WithMessage(ErrDNSSECBogus, "dnskey rrset not signed by a trust anchor")
+	}
+	for name, rrs := range z.records {
+		if name != z.Origin && z.delegation(parentName(name)) != nil {
+			continue
+		}
+		cut := name != z.Origin && len(filterType(rrs, DNSTypeNS)) > 0
+		for _, rrset := range groupRRsets(rrs) {
+			t := rrset[0].RRType
+			if t == DNSTypeRRSIG || (cut && t != DNSTypeDS && t != DNSTypeNSEC && t != DNSTypeNSEC3) {
+				continue
+			}
+			if !verifiedByAny(coveringRRSIGs(rrs, rrset[0]), keys, rrset, now) {
+				return errors.WithMessage(ErrDNSSECBogus, "unsigned or bad rrset "+name+"/"+DNSType(t).String())
+			}
+		}
+	}
+	return nil
+}
+
+func verifiedByAny(sigs []*RRSIG, keys []*DNSKEY, rrset []*DNSResourceRecode, now time.Time) bool {
+	for _, sig := range sigs {
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && VerifyRRSIG(sig, key, rrset, now) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rootSource 通过 TCP 应答 SOA 与 AXFR 的根区域服务器
+type rootSource struct {
+	mu        sync.Mutex
+	records   []*DNSResourceRecode // 第一条为 SOA
+	transfers int
+}
+
+func (s *rootSource) set(records []*DNSResourceRecode) {
+	s.mu.Lock()
+	s.records = records
+	s.mu.Unlock()
+}
+
+func (s *rootSource) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					s.mu.Lock()
+					resp := NewReply(req)
+					resp.Header.Flags.AA = 1
+					switch req.Questions[0].QuestionType {
+					case DNSTypeSOA:
+						resp.ResourceRecodes = s.records[:1]
+					case DNSTypeAXFR:
+						s.transfers++
+						resp.ResourceRecodes = append(append([]*DNSResourceRecode{}, s.records...), s.records[0])
+					}
+					s.mu.Unlock()
+					resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+					b, err := resp.ToByte()
+					if err != nil {
+						return
+					}
+					if _, err = conn.Write(append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (s *rootSource) transferCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transfers
+}
+
+// signedRoot 返回用 key 签名的根区域
+func signedRoot(t *testing.T, key *testZoneKey, serial int, extra ...*DNSResourceRecode) []*DNSResourceRecode {
+	soa := []*DNSResourceRecode{{Name: "", RRType: DNSTypeSOA, Class: DNSClassIn, TTL: 86400, RData: "a.root-servers.com. nstld.com. " + strconv.Itoa(serial) + " 1800 900 604800 86400"}}
+	ns := []*DNSResourceRecode{{Name: "", RRType: DNSTypeNS, Class: DNSClassIn, TTL: 518400, RData: "a.root-servers.com"}}
+	keys := []*DNSResourceRecode{key.dnskey()}
+	ds := []*DNSResourceRecode{{Name: "com", RRType: DNSTypeDS, Class: DNSClassIn, TTL: 86400, Data: key.ds(t).Pack()}}
+	records := append(soa, key.sign(t, soa, 0))
+	records = append(records, ns[0], key.sign(t, ns, 0), keys[0], key.sign(t, keys, 0), ds[0], key.sign(t, ds, 1))
+	// 委派与胶水记录不签名
+	records = append(records,
+		&DNSResourceRecode{Name: "com", RRType: DNSTypeNS, Class: DNSClassIn, TTL: 172800, RData: "a.gtld-servers.com"},
+		&DNSResourceRecode{Name: "a.gtld-servers.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 172800, RData: "192.0.2.30"},
+		&DNSResourceRecode{Name: "a.root-servers.com", RRType: DNSTypeA, Class: DNSClassIn, TTL: 518400, RData: "192.0.2.1"},
+	)
+	return append(records, extra...)
+}
+
+func TestRootMirror(t *testing.T) {
+	key := newTestZoneKey(t, "", DNSSECAlgED25519)
+	src := &rootSource{records: signedRoot(t, key, 2024010101)}
+	m := &RootMirror{Servers: []string{"127.0.0.1:1", src.serve(t)}, Anchors: []*DS{key.ds(t)}, Timeout: time.Second}
+	ctx := context.Background()
+
+	if m.Zone() != nil {
+		t.Fatal("zone before refresh")
+	}
+	if updated, err := m.Refresh(ctx); err != nil || !updated {
+		t.Fatalf("first refresh: %v, %v", updated, err)
+	}
+	if s := m.Status(); s.Serial != 2024010101 || s.Stale || !s.Loaded || s.Error != "" {
+		t.Fatalf("status %+v", s)
+	}
+	// 序列号没有变化时不传输
+	if updated, err := m.Refresh(ctx); err != nil || updated || src.transferCount() != 1 {
+		t.Fatalf("unchanged serial: %v, %v, %d transfers", updated, err, src.transferCount())
+	}
+
+	forwarded := 0
+	next := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		forwarded++
+		return NewReply(req.Message), nil
+	})
+	h := ChainHandler(next, WithRootMirror(m))
+	for _, c := range []struct {
+		name    string
+		qtype   uint16
+		local   bool
+		rcode   uint16
+		answers int
+	}{
+		{"nonexistent-tld", DNSTypeA, true, DNSRCodeNXDomain, 0},
+		{".", DNSTypeNS, true, DNSRCodeSuccess, 1},
+		{".", DNSTypeMX, true, DNSRCodeSuccess, 0},
+		{"www.com", DNSTypeA, false, DNSRCodeSuccess, 0},
+	} {
+		before := forwarded
+		resp, err := h.ServeDNS(ctx, &DNSRequest{Message: NewQuery(c.name, c.qtype)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (forwarded == before) != c.local || resp.Header.Flags.RCode != c.rcode || len(resp.Answers()) != c.answers {
+			t.Fatalf("%s: forwarded %v, rcode %d, answers %d", c.name, forwarded != before, resp.Header.Flags.RCode, len(resp.Answers()))
+		}
+		if c.local && (resp.Header.Flags.AA != 0 || resp.Header.Flags.RA != 1) {
+			t.Fatalf("%s: flags %+v", c.name, resp.Header.Flags)
+		}
+	}
+	// 作为根服务器时返回引荐
+	resp, _ := m.ServeDNS(ctx, &DNSRequest{Message: NewQuery("www.com", DNSTypeA)})
+	if len(resp.Authorities()) != 1 || len(resp.Additionals()) != 1 {
+		t.Fatalf("referral %+v", resp.ResourceRecodes)
+	}
+
+	// 新的序列号传输新的区域
+	tld := []*DNSResourceRecode{{Name: "net", RRType: DNSTypeNS, Class: DNSClassIn, TTL: 172800, RData: "a.gtld-servers.com"}}
+	src.set(signedRoot(t, key, 2024010102, tld...))
+	if updated, err := m.Refresh(ctx); err != nil || !updated || m.Status().Serial != 2024010102 {
+		t.Fatalf("new serial: %v, %v", updated, err)
+	}
+	if m.Zone().Records("net", DNSTypeNS) == nil {
+		t.Fatal("new delegation missing")
+	}
+
+	// 服务器上的序列号回退时不使用它
+	src.set(signedRoot(t, key, 2024010101))
+	var regression *SerialRegressionError
+	if _, err := m.Refresh(ctx); !errors.As(err, &regression) || regression.Old != 2024010102 {
+		t.Fatalf("regression: %v", err)
+	}
+
+	// 签名错误的区域不使用
+	bad := signedRoot(t, key, 2024010103)
+	bad = append(bad, &DNSResourceRecode{Name: "", RRType: DNSTypeTXT, Class: DNSClassIn, TTL: 60, RData: "unsigned"})
+	src.set(bad)
+	if _, err := m.Refresh(ctx); err == nil || m.Status().Serial != 2024010102 || m.Status().Error == "" {
+		t.Fatalf("unsigned rrset accepted: %v", err)
+	}
+	other := newTestZoneKey(t, "", DNSSECAlgED25519)
+	src.set(signedRoot(t, other, 2024010104))
+	if _, err := m.Refresh(ctx); err == nil {
+		t.Fatal("zone signed by an untrusted key accepted")
+	}
+
+	// 超过 expire 没有成功检查时不再使用
+	m.mu.Lock()
+	m.checked = time.Now().Add(-8 * 24 * time.Hour)
+	m.mu.Unlock()
+	if m.Zone() != nil || !m.Status().Stale {
+		t.Fatal("stale zone still used")
+	}
+	before := forwarded
+	if _, err := h.ServeDNS(ctx, &DNSRequest{Message: NewQuery("nonexistent-tld", DNSTypeA)}); err != nil || forwarded != before+1 {
+		t.Fatal("stale mirror not bypassed")
+	}
+	if resp, _ = m.ServeDNS(ctx, &DNSRequest{Message: NewQuery(".", DNSTypeNS)}); resp.Header.Flags.RCode != DNSRCodeServFail {
+		t.Fatalf("stale rcode %d", resp.Header.Flags.RCode)
+	}
+}
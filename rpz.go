@@ -0,0 +1,355 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var ErrRPZTrigger = errors.New("invalid rpz trigger")
+
+// RPZAction 响应策略区域 (RPZ) 的动作
+type RPZAction int
+
+const (
+	RPZNXDomain  RPZAction = iota // CNAME .
+	RPZNoData                     // CNAME *.
+	RPZPassthru                   // CNAME rpz-passthru., 不再检查后续规则与区域
+	RPZDrop                       // CNAME rpz-drop., 不应答
+	RPZTCPOnly                    // CNAME rpz-tcp-only., UDP 请求应答 TC 要求客户端改用 TCP
+	RPZLocalData                  // 其它记录, 用这些记录代替真实的回答
+)
+
+var rpzActionNames = [...]string{"NXDOMAIN", "NODATA", "PASSTHRU", "DROP", "TCP-ONLY", "Local-Data"}
+
+func (a RPZAction) String() string {
+	if int(a) < len(rpzActionNames) {
+		return rpzActionNames[a]
+	}
+	return "RPZAction(" + strconv.Itoa(int(a)) + ")"
+}
+
+// RPZTrigger 触发器类型, 按优先级从高到低排列
+type RPZTrigger int
+
+const (
+	RPZTriggerQName   RPZTrigger = iota // 问题名字
+	RPZTriggerIP                        // 回答中的 A/AAAA 地址, <前缀长度>.<倒序地址>.rpz-ip
+	RPZTriggerNSDName                   // 响应中授权服务器的名字, <名字>.rpz-nsdname
+	RPZTriggerNSIP                      // 响应中授权服务器的地址, <前缀长度>.<倒序地址>.rpz-nsip
+)
+
+// rpzRule 一个触发器对应的动作, Local-Data 的记录名字在应答时替换为问题名字
+type rpzRule struct {
+	action  RPZAction
+	records []*DNSResourceRecode
+}
+
+type rpzNet struct {
+	prefix netip.Prefix
+	rule   *rpzRule
+}
+
+// rpzNames 名字触发器, 精确名字与 "*." 通配分开存放
+type rpzNames struct {
+	exact    map[string]*rpzRule
+	wildcard map[string]*rpzRule
+}
+
+func (n *rpzNames) add(name string, rule *rpzRule) {
+	if n.exact == nil {
+		n.exact, n.wildcard = map[string]*rpzRule{}, map[string]*rpzRule{}
+	}
+	if strings.HasPrefix(name, "*.") {
+		n.wildcard[name[2:]] = rule
+	} else {
+		n.exact[name] = rule
+	}
+}
+
+// match 精确名字优先, 其次是最长的通配
+func (n *rpzNames) match(name string) *rpzRule {
+	name = normalizeDomain(name)
+	if r := n.exact[name]; r != nil {
+		return r
+	}
+	for p := parentName(name); p != ""; p = parentName(p) {
+		if r := n.wildcard[p]; r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// RPZ 一个响应策略区域, 由普通区域解释而来, 构建完成后可以并发使用
+type RPZ struct {
+	Origin string
+
+	qname   rpzNames
+	nsdname rpzNames
+	ip      []rpzNet
+	nsip    []rpzNet
+}
+
+// LoadRPZ 从主文件格式读取策略区域
+func LoadRPZ(r io.Reader, origin string) (*RPZ, error) {
+	z, err := ParseZone(r, origin)
+	if err != nil {
+		return nil, err
+	}
+	return NewRPZ(z)
+}
+
+// NewRPZ 解释区域中的触发器与动作, 区域可以来自 ParseZone 或 Resolver.TransferZone.
+// 顶点上的 SOA 与 NS 记录不是规则
+func NewRPZ(z *Zone) (*RPZ, error) {
+	p := &RPZ{Origin: z.Origin}
+	for _, owner := range z.Names() {
+		if owner == z.Origin {
+			continue
+		}
+		rule := rpzRuleOf(z.Records(owner, 0))
+		name := strings.TrimSuffix(owner, "."+z.Origin)
+		var err error
+		switch {
+		case strings.HasSuffix(name, ".rpz-ip"):
+			err = p.addNet(&p.ip, strings.TrimSuffix(name, ".rpz-ip"), rule)
+		case strings.HasSuffix(name, ".rpz-nsip"):
+			err = p.addNet(&p.nsip, strings.TrimSuffix(name, ".rpz-nsip"), rule)
+		case strings.HasSuffix(name, ".rpz-nsdname"):
+			p.nsdname.add(strings.TrimSuffix(name, ".rpz-nsdname"), rule)
+		case strings.HasSuffix(name, ".rpz-client-ip"):
+			// 按客户端地址的触发器不在支持范围内
+		default:
+			p.qname.add(name, rule)
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, owner)
+		}
+	}
+	return p, nil
+}
+
+// rpzRuleOf 由触发器名字上的记录得到动作, 特殊的 CNAME 目标表示对应的动作
+func rpzRuleOf(records []*DNSResourceRecode) *rpzRule {
+	if len(records) == 1 && records[0].RRType == DNSTypeCName {
+		switch normalizeDomain(records[0].RData) {
+		case "":
+			return &rpzRule{action: RPZNXDomain}
+		case "*":
+			return &rpzRule{action: RPZNoData}
+		case "rpz-passthru":
+			return &rpzRule{action: RPZPassthru}
+		case "rpz-drop":
+			return &rpzRule{action: RPZDrop}
+		case "rpz-tcp-only":
+			return &rpzRule{action: RPZTCPOnly}
+		}
+	}
+	return &rpzRule{action: RPZLocalData, records: records}
+}
+
+func (p *RPZ) addNet(nets *[]rpzNet, trigger string, rule *rpzRule) error {
+	prefix, err := parseRPZPrefix(trigger)
+	if err != nil {
+		return err
+	}
+	*nets = append(*nets, rpzNet{prefix: prefix, rule: rule})
+	return nil
+}
+
+// parseRPZPrefix 解析 <前缀长度>.<倒序地址>, IPv6 中的 zz 表示 ::
+func parseRPZPrefix(trigger string) (netip.Prefix, error) {
+	labels := strings.Split(trigger, ".")
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil || len(labels) < 2 {
+		return netip.Prefix{}, ErrRPZTrigger
+	}
+	parts := labels[1:]
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	var s string
+	if len(parts) == 4 && !strings.Contains(trigger, "zz") {
+		s = strings.Join(parts, ".")
+	} else {
+		s = strings.Replace(strings.Join(parts, ":"), "zz", "", 1)
+		if strings.HasPrefix(s, ":") {
+			s = ":" + s
+		}
+		if strings.HasSuffix(s, ":") {
+			s += ":"
+		}
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil || bits < 0 || bits > addr.BitLen() {
+		return netip.Prefix{}, ErrRPZTrigger
+	}
+	prefix := netip.PrefixFrom(addr, bits)
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, ErrRPZTrigger
+	}
+	return prefix, nil
+}
+
+// matchNet 最长前缀匹配
+func matchNet(nets []rpzNet, addr netip.Addr) *rpzRule {
+	var best *rpzNet
+	for i := range nets {
+		n := &nets[i]
+		if n.prefix.Contains(addr) && (best == nil || n.prefix.Bits() > best.prefix.Bits()) {
+			best = n
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.rule
+}
+
+// MatchQName 返回问题名字命中的动作, 用于在处理器中查询策略
+func (p *RPZ) MatchQName(name string) (RPZAction, bool) {
+	if r := p.qname.match(name); r != nil {
+		return r.action, true
+	}
+	return 0, false
+}
+
+// matchResponse 依次检查 IP, NSDNAME 与 NSIP 触发器. 只能看到响应中的内容:
+// 授权部分的 NS 记录与附加部分的胶水地址
+func (p *RPZ) matchResponse(resp *DNSMessage) (*rpzRule, RPZTrigger) {
+	addrOf := func(rr *DNSResourceRecode) (netip.Addr, bool) {
+		if rr.RRType != DNSTypeA && rr.RRType != DNSTypeAAAA {
+			return netip.Addr{}, false
+		}
+		addr, err := netip.ParseAddr(rr.RData)
+		return addr, err == nil
+	}
+	for _, rr := range resp.Answers() {
+		if addr, ok := addrOf(rr); ok {
+			if r := matchNet(p.ip, addr); r != nil {
+				return r, RPZTriggerIP
+			}
+		}
+	}
+	var nsNames []string
+	for _, rr := range resp.Authorities() {
+		if rr.RRType == DNSTypeNS {
+			nsNames = append(nsNames, normalizeDomain(rr.RData))
+			if r := p.nsdname.match(rr.RData); r != nil {
+				return r, RPZTriggerNSDName
+			}
+		}
+	}
+	for _, rr := range resp.Additionals() {
+		addr, ok := addrOf(rr)
+		if !ok {
+			continue
+		}
+		for _, ns := range nsNames {
+			if normalizeDomain(rr.Name) != ns {
+				continue
+			}
+			if r := matchNet(p.nsip, addr); r != nil {
+				return r, RPZTriggerNSIP
+			}
+		}
+	}
+	return nil, 0
+}
+
+// RPZHit 命中的策略, 由 WithRPZ 的 onHit 报告
+type RPZHit struct {
+	Zone    string
+	Trigger RPZTrigger
+	Action  RPZAction
+}
+
+// WithRPZ 按顺序应用策略区域: 先出现的区域优先, 同一区域内按 RPZTrigger 的顺序.
+// QNAME 触发器在转发前检查, 其它触发器检查 next 返回的响应. Local-Data 中的 CNAME 原样返回, 不继续解析.
+// onHit 不为空时在命中 PASSTHRU 以外的动作后调用
+func WithRPZ(onHit func(req *DNSRequest, hit RPZHit), zones ...*RPZ) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			if len(req.Message.Questions) != 1 {
+				return next.ServeDNS(ctx, req)
+			}
+			qname := req.Message.Questions[0].QuestionName
+			// 第一个命中问题名字的区域, 之前的区域仍可能由响应触发
+			first := len(zones)
+			var qrule *rpzRule
+			for i, z := range zones {
+				if r := z.qname.match(qname); r != nil {
+					first, qrule = i, r
+					break
+				}
+			}
+			apply := func(i int, trigger RPZTrigger, rule *rpzRule, resp *DNSMessage) (*DNSMessage, error) {
+				// TCP 请求不受 TCP-ONLY 影响
+				if rule.action == RPZPassthru || rule.action == RPZTCPOnly && req.Network != "udp" {
+					if resp == nil {
+						return next.ServeDNS(ctx, req)
+					}
+					return resp, nil
+				}
+				if onHit != nil {
+					onHit(req, RPZHit{Zone: zones[i].Origin, Trigger: trigger, Action: rule.action})
+				}
+				return rpzReply(req, rule), nil
+			}
+			if first == 0 {
+				return apply(0, RPZTriggerQName, qrule, nil)
+			}
+			resp, err := next.ServeDNS(ctx, req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			for i := 0; i < first; i++ {
+				if rule, trigger := zones[i].matchResponse(resp); rule != nil {
+					return apply(i, trigger, rule, resp)
+				}
+			}
+			if qrule != nil {
+				return apply(first, RPZTriggerQName, qrule, resp)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// rpzReply 按动作构造应答, RPZDrop 返回空表示不应答
+func rpzReply(req *DNSRequest, rule *rpzRule) *DNSMessage {
+	resp := NewReply(req.Message)
+	resp.Header.Flags.RA = 1
+	q := req.Message.Questions[0]
+	switch rule.action {
+	case RPZNXDomain:
+		resp.Header.Flags.RCode = DNSRCodeNXDomain
+	case RPZDrop:
+		return nil
+	case RPZTCPOnly:
+		resp.Header.Flags.TC = 1
+	case RPZLocalData:
+		var answers []*DNSResourceRecode
+		for _, rr := range rule.records {
+			if rr.RRType == q.QuestionType || rr.RRType == DNSTypeCName {
+				c := rr.Copy()
+				c.Name = q.QuestionName
+				answers = append(answers, c)
+			}
+		}
+		// 有 CNAME 时只返回 CNAME
+		for _, rr := range answers {
+			if rr.RRType == DNSTypeCName && q.QuestionType != DNSTypeCName {
+				answers = []*DNSResourceRecode{rr}
+				break
+			}
+		}
+		resp.ResourceRecodes = answers
+		resp.Header.AnswerRRs = uint16(len(answers))
+	}
+	return resp
+}
@@ -0,0 +1,176 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRPZ = `$TTL 300
+@ SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60
+@ NS ns.rpz.example.
+bad.example.com CNAME .
+*.bad.example.com CNAME .
+empty.example.com CNAME *.
+ok.bad.example.com CNAME rpz-passthru.
+drop.example.com CNAME rpz-drop.
+tcp.example.com CNAME rpz-tcp-only.
+walled.example.com A 192.0.2.250
+walled.example.com AAAA 2001:db8::250
+alias.example.com CNAME garden.example.net.
+24.0.113.0.203.rpz-ip CNAME .
+128.1.zz.db8.2001.rpz-ip CNAME *.
+ns.evil.example.rpz-nsdname CNAME .
+32.9.100.51.198.rpz-nsip CNAME *.
+`
+
+func TestRPZ(t *testing.T) {
+	p, err := LoadRPZ(strings.NewReader(testRPZ), "rpz.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 上游: nsip.example 的授权服务器胶水地址为 198.51.100.9
+	upstream := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		add := func(rr *DNSResourceRecode) {
+			rr.Name, rr.Class, rr.TTL = q.QuestionName, DNSClassIn, 60
+			resp.ResourceRecodes = append(resp.ResourceRecodes, rr)
+			resp.Header.AnswerRRs++
+		}
+		switch q.QuestionName {
+		case "ipbad.example.org":
+			add(&DNSResourceRecode{RRType: DNSTypeA, RData: "203.0.113.7"})
+		case "ip6bad.example.org":
+			add(&DNSResourceRecode{RRType: DNSTypeAAAA, RData: "2001:db8::1"})
+		case "nsdname.example.org", "nsip.example.org":
+			ns := "ns.evil.example"
+			if q.QuestionName == "nsip.example.org" {
+				ns = "ns.other.example"
+			}
+			add(&DNSResourceRecode{RRType: DNSTypeA, RData: "192.0.2.1"})
+			resp.ResourceRecodes = append(resp.ResourceRecodes,
+				&DNSResourceRecode{Name: "example.org", RRType: DNSTypeNS, Class: DNSClassIn, TTL: 60, RData: ns},
+				&DNSResourceRecode{Name: ns, RRType: DNSTypeA, Class: DNSClassIn, TTL: 60, RData: "198.51.100.9"})
+			resp.Header.AuthorityRRs, resp.Header.AdditionalRRs = 1, 1
+		default:
+			add(&DNSResourceRecode{RRType: DNSTypeA, RData: "192.0.2.1"})
+		}
+		return resp, nil
+	})
+	hits := make(chan RPZHit, 16)
+	udpAddr, tcpAddr := startDNSServer(t, &DNSServer{Handler: upstream, Middlewares: []ServerMiddleware{
+		WithRPZ(func(req *DNSRequest, hit RPZHit) { hits <- hit }, p),
+	}})
+	r := &Resolver{Server: udpAddr}
+	query := func(r *Resolver, name string, qtype uint16) *DNSMessage {
+		resp, err := r.Query(context.Background(), name, qtype)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return resp
+	}
+	for _, c := range []struct {
+		name  string
+		qtype uint16
+		rcode uint16
+		want  string // 第一个回答的 RData, 空表示没有回答
+	}{
+		{"bad.example.com", DNSTypeA, DNSRCodeNXDomain, ""},
+		{"x.bad.example.com", DNSTypeA, DNSRCodeNXDomain, ""},
+		{"ok.bad.example.com", DNSTypeA, DNSRCodeSuccess, "192.0.2.1"},
+		{"empty.example.com", DNSTypeA, DNSRCodeSuccess, ""},
+		{"walled.example.com", DNSTypeAAAA, DNSRCodeSuccess, "2001:db8::250"},
+		{"alias.example.com", DNSTypeA, DNSRCodeSuccess, "garden.example.net"},
+		{"ipbad.example.org", DNSTypeA, DNSRCodeNXDomain, ""},
+		{"ip6bad.example.org", DNSTypeAAAA, DNSRCodeSuccess, ""},
+		{"nsdname.example.org", DNSTypeA, DNSRCodeNXDomain, ""},
+		{"nsip.example.org", DNSTypeA, DNSRCodeSuccess, ""},
+		{"clean.example.org", DNSTypeA, DNSRCodeSuccess, "192.0.2.1"},
+	} {
+		resp := query(r, c.name, c.qtype)
+		got := ""
+		if answers := resp.Answers(); len(answers) > 0 {
+			got = answers[0].RData
+			if answers[0].Name != c.name {
+				t.Fatalf("%s: answer owner %s", c.name, answers[0].Name)
+			}
+		}
+		if resp.Header.Flags.RCode != c.rcode || got != c.want {
+			t.Fatalf("%s: rcode %d answer %q", c.name, resp.Header.Flags.RCode, got)
+		}
+		resp.Release()
+	}
+	if hit := <-hits; hit.Zone != "rpz.example" || hit.Trigger != RPZTriggerQName || hit.Action != RPZNXDomain {
+		t.Fatalf("hit = %+v", hit)
+	}
+
+	resp := query(r, "tcp.example.com", DNSTypeA)
+	if resp.Header.Flags.TC != 1 {
+		t.Fatal("tcp-only over udp not truncated")
+	}
+	resp = query(&Resolver{Server: tcpAddr, Transport: TCPTransport{}}, "tcp.example.com", DNSTypeA)
+	if resp.Header.Flags.TC != 0 || len(resp.Answers()) != 1 {
+		t.Fatalf("tcp-only over tcp = %+v", resp)
+	}
+	short := &Resolver{Server: udpAddr, Timeout: 100 * time.Millisecond}
+	if _, err := short.Query(context.Background(), "drop.example.com", DNSTypeA); err == nil {
+		t.Fatal("dropped query answered")
+	}
+	if action, ok := p.MatchQName("a.bad.example.com."); !ok || action != RPZNXDomain || action.String() != "NXDOMAIN" {
+		t.Fatalf("MatchQName = %v, %v", action, ok)
+	}
+}
+
+func TestTransferZone(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	z, err := ParseZone(strings.NewReader(testRPZ), "rpz.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := readTCPMessage(conn)
+		if err != nil || req.Questions[0].QuestionType != DNSTypeAXFR {
+			return
+		}
+		// SOA 在首尾, 其余记录分成两条消息
+		records := []*DNSResourceRecode{z.SOA()}
+		for _, name := range z.Names() {
+			for _, rr := range z.Records(name, 0) {
+				if rr.RRType != DNSTypeSOA {
+					records = append(records, rr)
+				}
+			}
+		}
+		records = append(records, z.SOA())
+		half := len(records) / 2
+		for _, part := range [][]*DNSResourceRecode{records[:half], records[half:]} {
+			resp := NewReply(req)
+			resp.ResourceRecodes = part
+			resp.Header.AnswerRRs = uint16(len(part))
+			b, _ := resp.ToByte()
+			_, _ = conn.Write(append([]byte{byte(len(b) >> 8), byte(len(b))}, b...))
+		}
+	}()
+	got, err := (&Resolver{Server: ln.Addr().String()}).TransferZone(context.Background(), "rpz.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Names()) != len(z.Names()) || len(got.Records("walled.example.com.rpz.example", 0)) != 2 || got.SOA() == nil {
+		t.Fatalf("names = %v", got.Names())
+	}
+	if _, err := NewRPZ(got); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+var ErrRRSetMismatch = errors.New("record does not belong to rrset")
+
+// RRSet 名字, 类型与类别都相同的一组记录. 按 RFC 2181 一个 RRSet 只有一个 TTL, 记录的 TTL 不同时取最小值,
+// RDATA 相同的记录只保留一条. 名字不区分大小写, 保留第一条记录的写法
+type RRSet struct {
+	Name   string
+	RRType uint16
+	Class  uint16
+	TTL    uint32
+
+	records []*DNSResourceRecode
+}
+
+// NewRRSet 由 records 构造 RRSet, records 不能为空且必须属于同一个 RRSet
+func NewRRSet(records ...*DNSResourceRecode) (*RRSet, error) {
+	if len(records) == 0 {
+		return nil, errors.WithMessage(ErrRRSetMismatch, "no records")
+	}
+	first := records[0]
+	s := &RRSet{Name: first.Name, RRType: first.RRType, Class: first.Class, TTL: first.TTL}
+	for _, r := range records {
+		if err := s.Add(r); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// GroupRRSets 按 (名字, 类型, 类别) 分组, 按每组第一次出现的顺序返回
+func GroupRRSets(records []*DNSResourceRecode) []*RRSet {
+	var sets []*RRSet
+	index := map[string]*RRSet{}
+	for _, r := range records {
+		k := rrsetKey(r.Name, r.RRType, r.Class)
+		s, ok := index[k]
+		if !ok {
+			s = &RRSet{Name: r.Name, RRType: r.RRType, Class: r.Class, TTL: r.TTL}
+			index[k] = s
+			sets = append(sets, s)
+		}
+		_ = s.Add(r)
+	}
+	return sets
+}
+
+func rrsetKey(name string, rrtype, class uint16) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "\x00" + strconv.Itoa(int(rrtype)) + "\x00" + strconv.Itoa(int(class))
+}
+
+// Belongs r 是否属于 s
+func (s *RRSet) Belongs(r *DNSResourceRecode) bool {
+	return r.RRType == s.RRType && r.Class == s.Class && strings.EqualFold(strings.TrimSuffix(r.Name, "."), strings.TrimSuffix(s.Name, "."))
+}
+
+// Add 添加 r 的副本, TTL 较小时降低整个 RRSet 的 TTL. RDATA 已经存在时只更新 TTL
+func (s *RRSet) Add(r *DNSResourceRecode) error {
+	if !s.Belongs(r) {
+		return errors.WithMessage(ErrRRSetMismatch, recordString(r))
+	}
+	if r.TTL < s.TTL || len(s.records) == 0 {
+		s.TTL = r.TTL
+	}
+	if s.Contains(r) {
+		return nil
+	}
+	c := r.Copy()
+	c.Name, c.NamePos, c.RDLength = s.Name, 0, 0
+	s.records = append(s.records, c)
+	return nil
+}
+
+// Contains s 中是否有与 r RDATA 相同的记录
+func (s *RRSet) Contains(r *DNSResourceRecode) bool {
+	if !s.Belongs(r) {
+		return false
+	}
+	rdata := recordRData(r)
+	for _, have := range s.records {
+		if recordRData(have) == rdata {
+			return true
+		}
+	}
+	return false
+}
+
+// Len 记录数
+func (s *RRSet) Len() int {
+	return len(s.records)
+}
+
+// Records 返回记录的副本, TTL 都为 s.TTL
+func (s *RRSet) Records() []*DNSResourceRecode {
+	records := make([]*DNSResourceRecode, 0, len(s.records))
+	for _, r := range s.records {
+		c := r.Copy()
+		c.TTL = s.TTL
+		records = append(records, c)
+	}
+	return records
+}
+
+// Union 返回包含 s 与 o 所有记录的新 RRSet, TTL 取两者的最小值
+func (s *RRSet) Union(o *RRSet) (*RRSet, error) {
+	u := s.clone()
+	for _, r := range o.Records() {
+		if err := u.Add(r); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// Subtract 返回 s 中不在 o 中的记录, TTL 不变. o 属于其它 RRSet 时返回 s 的副本
+func (s *RRSet) Subtract(o *RRSet) *RRSet {
+	d := &RRSet{Name: s.Name, RRType: s.RRType, Class: s.Class, TTL: s.TTL}
+	for _, r := range s.records {
+		if !o.Contains(r) {
+			d.records = append(d.records, r.Copy())
+		}
+	}
+	return d
+}
+
+// Equal s 与 o 是否为同一个 RRSet 且记录相同, 不比较 TTL 与记录顺序
+func (s *RRSet) Equal(o *RRSet) bool {
+	same := s.RRType == o.RRType && s.Class == o.Class && strings.EqualFold(strings.TrimSuffix(s.Name, "."), strings.TrimSuffix(o.Name, "."))
+	return same && s.Len() == o.Len() && s.Subtract(o).Len() == 0
+}
+
+func (s *RRSet) clone() *RRSet {
+	c := *s
+	c.records = nil
+	for _, r := range s.records {
+		c.records = append(c.records, r.Copy())
+	}
+	return &c
+}
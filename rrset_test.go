@@ -0,0 +1,55 @@
+package netx
+
+import (
+	"testing"
+)
+
+func TestRRSet(t *testing.T) {
+	a := func(name, ip string, ttl uint32) *DNSResourceRecode {
+		return &DNSResourceRecode{Name: name, RRType: DNSTypeA, Class: DNSClassIn, TTL: ttl, RData: ip}
+	}
+	records := []*DNSResourceRecode{
+		a("www.example.com", "192.0.2.1", 300),
+		{Name: "www.example.com", RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: 300, RData: "2001:db8::1"},
+		a("WWW.example.com.", "192.0.2.2", 60),
+		a("www.example.com", "192.0.2.1", 120),
+	}
+	sets := GroupRRSets(records)
+	if len(sets) != 2 {
+		t.Fatalf("got %d rrsets", len(sets))
+	}
+	s := sets[0]
+	if s.RRType != DNSTypeA || s.TTL != 60 || s.Len() != 2 {
+		t.Fatalf("A rrset = %+v, %d records", s, s.Len())
+	}
+	for _, r := range s.Records() {
+		if r.TTL != 60 || r.Name != "www.example.com" {
+			t.Fatalf("record = %+v", r)
+		}
+	}
+	if err := s.Add(records[1]); err == nil {
+		t.Fatal("AAAA added to A rrset")
+	}
+	if _, err := NewRRSet(); err == nil {
+		t.Fatal("empty rrset created")
+	}
+
+	other, err := NewRRSet(a("www.example.com", "192.0.2.2", 600), a("www.example.com", "192.0.2.3", 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.Union(other)
+	if err != nil || u.Len() != 3 || u.TTL != 30 || s.Len() != 2 {
+		t.Fatalf("union = %+v, %v", u, err)
+	}
+	d := u.Subtract(s)
+	if d.Len() != 1 || d.Records()[0].RData != "192.0.2.3" {
+		t.Fatalf("subtract = %+v", d.Records())
+	}
+	if !u.Subtract(other).Equal(s.Subtract(other)) {
+		t.Fatal("union minus other differs from original")
+	}
+	if _, err := s.Union(sets[1]); err == nil {
+		t.Fatal("union of A and AAAA rrsets")
+	}
+}
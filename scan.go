@@ -0,0 +1,374 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoPorts        = errors.New("no ports to scan")
+	ErrScanTooWide    = errors.New("cidr too large to scan")
+	ErrSYNIPv6        = errors.New("syn scan only supports ipv4 targets")
+	ErrDuplicateProbe = errors.New("probe for the same address and port is in flight")
+)
+
+// maxScanHosts 单个 CIDR 展开的最大主机数
+const maxScanHosts = 1 << 16
+
+type ScanMode int
+
+const (
+	ScanConnect ScanMode = iota // 完整的 TCP 三次握手
+	ScanSYN                     // 只发送 SYN (半开扫描), 需要 raw socket 权限, 仅支持 ipv4
+)
+
+// ScanOptions 零值字段使用默认值
+type ScanOptions struct {
+	Mode        ScanMode
+	Ports       []int
+	Concurrency int           // 并发探测数, 默认 100
+	Timeout     time.Duration // 单个端口超时, 默认 1s
+
+	Banner bool // 读取服务端主动发送的 banner
+	TLS    bool // 对开放端口尝试 TLS 握手
+
+	// Resolver 不为空时用于解析目标中的域名
+	Resolver *Resolver
+}
+
+type ScanResult struct {
+	IP     net.IP
+	Port   int
+	Open   bool
+	Banner string
+
+	// TLS 握手成功时有效
+	TLSVersion    uint16
+	TLSCipher     uint16
+	TLSSubject    string
+	TLSServerName string
+
+	Err error
+}
+
+// Scan 扫描 targets 中每个地址的 Ports, targets 可以是 ip, 域名或 CIDR.
+// 结果按完成顺序写入返回的 channel, 全部完成或 ctx 取消后 channel 关闭
+func Scan(ctx context.Context, targets []string, opts *ScanOptions) (<-chan *ScanResult, error) {
+	o := ScanOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if len(o.Ports) == 0 {
+		return nil, ErrNoPorts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 100
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+
+	ips, err := scanTargets(ctx, targets, o.Resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	var syn *synScanner
+	if o.Mode == ScanSYN {
+		if syn, err = newSYNScanner(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make(chan *ScanResult)
+	go func() {
+		defer close(results)
+		if syn != nil {
+			defer syn.close()
+		}
+		sem := make(chan struct{}, o.Concurrency)
+		var wg sync.WaitGroup
+	loop:
+		for _, ip := range ips {
+			for _, port := range o.Ports {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					break loop
+				}
+				wg.Add(1)
+				go func(ip net.IP, port int) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					result := scanPort(ctx, ip, port, &o, syn)
+					select {
+					case results <- result:
+					case <-ctx.Done():
+					}
+				}(ip, port)
+			}
+		}
+		wg.Wait()
+	}()
+	return results, nil
+}
+
+func scanTargets(ctx context.Context, targets []string, resolver *Resolver) ([]net.IP, error) {
+	var ips []net.IP
+	for _, target := range targets {
+		if strings.Contains(target, "/") {
+			_, ipNet, err := net.ParseCIDR(target)
+			if err != nil {
+				return nil, err
+			}
+			ones, bits := ipNet.Mask.Size()
+			if bits-ones > 16 {
+				return nil, errors.WithMessage(ErrScanTooWide, target)
+			}
+			ip := ipNet.IP
+			for i := 0; i < 1<<uint(bits-ones) && i < maxScanHosts; i++ {
+				ips = append(ips, ip)
+				ip = nextIP(ip)
+			}
+			continue
+		}
+		if ip := net.ParseIP(target); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		var addrs []string
+		var err error
+		if resolver != nil {
+			addrs, err = resolver.LookupHost(ctx, target)
+		} else {
+			addrs, err = net.DefaultResolver.LookupHost(ctx, target)
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, target)
+		}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// nextIP 返回 ip + 1
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func scanPort(ctx context.Context, ip net.IP, port int, o *ScanOptions, syn *synScanner) *ScanResult {
+	result := &ScanResult{IP: ip, Port: port}
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	if syn != nil {
+		result.Open, result.Err = syn.probe(ctx, ip, port)
+		if !result.Open || !o.Banner && !o.TLS {
+			return result
+		}
+	}
+
+	var dialer net.Dialer
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		if syn == nil {
+			result.Err = err
+		}
+		return result
+	}
+	result.Open = true
+	if o.Banner {
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetReadDeadline(deadline)
+		}
+		buf := make([]byte, 512)
+		n, _ := conn.Read(buf)
+		result.Banner = strings.TrimSpace(string(buf[:n]))
+	}
+	_ = conn.Close()
+
+	if o.TLS {
+		scanTLS(ctx, address, result)
+	}
+	return result
+}
+
+func scanTLS(ctx context.Context, address string, result *ScanResult) {
+	dialer := tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	result.TLSVersion = state.Version
+	result.TLSCipher = state.CipherSuite
+	result.TLSServerName = state.ServerName
+	if len(state.PeerCertificates) > 0 {
+		result.TLSSubject = state.PeerCertificates[0].Subject.String()
+	}
+}
+
+// synKey 一次探测的目标地址, 目标端口与本地端口
+type synKey struct {
+	ip      string
+	port    int
+	srcPort int
+}
+
+type synWaiter struct {
+	isn uint32 // SYN 的序号, 回应的确认号必须是 isn+1
+	ch  chan bool
+}
+
+// synScanner 通过 raw socket 发送 SYN 并接收 SYN-ACK/RST
+type synScanner struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	waiters map[synKey]*synWaiter
+}
+
+func newSYNScanner() (*synScanner, error) {
+	conn, err := net.ListenIP("ip4:tcp", &net.IPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen raw tcp error")
+	}
+	s := &synScanner{conn: conn, waiters: map[synKey]*synWaiter{}}
+	go s.read()
+	return s, nil
+}
+
+func (s *synScanner) close() {
+	_ = s.conn.Close()
+}
+
+// read 把回应交给对应的探测: 源地址, 源端口与目的端口都要匹配, 并且确认了探测的 SYN,
+// 其他连接的报文段与伪造的 RST 或 SYN-ACK 被忽略
+func (s *synScanner) read() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 20 {
+			continue
+		}
+		flags := buf[13]
+		key := synKey{
+			ip:      addr.(*net.IPAddr).IP.String(),
+			port:    int(binary.BigEndian.Uint16(buf[0:2])),
+			srcPort: int(binary.BigEndian.Uint16(buf[2:4])),
+		}
+		s.mu.Lock()
+		w := s.waiters[key]
+		s.mu.Unlock()
+		if w == nil || flags&0x10 == 0 || binary.BigEndian.Uint32(buf[8:12]) != w.isn+1 {
+			continue
+		}
+		var open bool
+		switch {
+		case flags&0x12 == 0x12: // SYN+ACK
+			open = true
+		case flags&0x04 != 0: // RST
+		default:
+			continue
+		}
+		select {
+		case w.ch <- open:
+		default:
+		}
+	}
+}
+
+func (s *synScanner) probe(ctx context.Context, ip net.IP, port int) (bool, error) {
+	dst := ip.To4()
+	if dst == nil {
+		return false, ErrSYNIPv6
+	}
+	// 借助 UDP 连接获得到达目标时使用的本地地址
+	udp, err := net.Dial("udp4", net.JoinHostPort(dst.String(), strconv.Itoa(port)))
+	if err != nil {
+		return false, err
+	}
+	src := udp.LocalAddr().(*net.UDPAddr).IP.To4()
+	_ = udp.Close()
+
+	srcPort := 32768 + int(randUint32()%28232)
+	key := synKey{ip: dst.String(), port: port, srcPort: srcPort}
+	w := &synWaiter{isn: randUint32(), ch: make(chan bool, 1)}
+	s.mu.Lock()
+	if _, busy := s.waiters[key]; busy {
+		s.mu.Unlock()
+		return false, ErrDuplicateProbe
+	}
+	s.waiters[key] = w
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiters, key)
+		s.mu.Unlock()
+	}()
+
+	segment := synSegment(src, dst, srcPort, port, w.isn)
+	if _, err := s.conn.WriteTo(segment, &net.IPAddr{IP: dst}); err != nil {
+		return false, err
+	}
+	select {
+	case open := <-w.ch:
+		return open, nil
+	case <-ctx.Done():
+		// 被过滤的端口不会有任何回应
+		return false, nil
+	}
+}
+
+// synSegment 构造一个序号为 isn 的 20 字节 TCP SYN 报文段
+func synSegment(src, dst net.IP, srcPort, dstPort int, isn uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(b[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint32(b[4:8], isn)
+	b[12] = 5 << 4 // data offset
+	b[13] = 0x02   // SYN
+	binary.BigEndian.PutUint16(b[14:16], 65535)
+
+	// 校验和包含伪首部
+	var sum uint32
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src)
+	copy(pseudo[4:8], dst)
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(b)))
+	for _, data := range [][]byte{pseudo, b} {
+		for i := 0; i+1 < len(data); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+		}
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	binary.BigEndian.PutUint16(b[16:18], ^uint16(sum))
+	return b
+}
@@ -0,0 +1,143 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-netx\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	// 监听后立即关闭得到一个没有服务的端口
+	closedLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := closedLn.Addr().(*net.TCPAddr).Port
+	_ = closedLn.Close()
+	open := ln.Addr().(*net.TCPAddr).Port
+
+	results, err := Scan(context.Background(), []string{"127.0.0.1"}, &ScanOptions{Ports: []int{open, closed}, Banner: true, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int]*ScanResult{}
+	for r := range results {
+		got[r.Port] = r
+	}
+	if r := got[open]; r == nil || !r.Open || r.Banner != "SSH-2.0-netx" || r.Err != nil || !r.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("open port: %+v", r)
+	}
+	if r := got[closed]; r == nil || r.Open || r.Err == nil {
+		t.Fatalf("closed port: %+v", r)
+	}
+
+	if _, err := Scan(context.Background(), []string{"127.0.0.1"}, nil); err != ErrNoPorts {
+		t.Fatalf("no ports: %v", err)
+	}
+	if _, err := Scan(context.Background(), []string{"10.0.0.0/15"}, &ScanOptions{Ports: []int{22}}); errors.Cause(err) != ErrScanTooWide {
+		t.Fatalf("wide cidr: %v", err)
+	}
+}
+
+func TestScanTargets(t *testing.T) {
+	ips, err := scanTargets(context.Background(), []string{"192.0.2.254/31", "198.51.100.255/30", "2001:db8::1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.254", "192.0.2.255", "198.51.100.252", "198.51.100.253", "198.51.100.254", "198.51.100.255", "2001:db8::1"}
+	if len(ips) != len(want) {
+		t.Fatalf("ips = %v", ips)
+	}
+	for i, ip := range ips {
+		if ip.String() != want[i] {
+			t.Fatalf("ips = %v", ips)
+		}
+	}
+	// /16 是允许的最大范围
+	if ips, err := scanTargets(context.Background(), []string{"10.1.0.0/16"}, nil); err != nil || len(ips) != maxScanHosts || ips[len(ips)-1].String() != "10.1.255.255" {
+		t.Fatalf("/16: %d, %v", len(ips), err)
+	}
+	if _, err := scanTargets(context.Background(), []string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+}
+
+// segmentConn 把 in 中的报文段作为 raw socket 收到的数据交给 synScanner
+type segmentConn struct {
+	net.PacketConn
+	in chan segmentFrom
+}
+
+type segmentFrom struct {
+	ip      string
+	segment []byte
+}
+
+func (c *segmentConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	s, ok := <-c.in
+	if !ok {
+		return 0, nil, net.ErrClosed
+	}
+	return copy(b, s.segment), &net.IPAddr{IP: net.ParseIP(s.ip)}, nil
+}
+
+func TestSYNScannerRead(t *testing.T) {
+	conn := &segmentConn{in: make(chan segmentFrom)}
+	defer close(conn.in)
+	s := &synScanner{conn: conn, waiters: map[synKey]*synWaiter{}}
+	w := &synWaiter{isn: 1000, ch: make(chan bool, 1)}
+	s.waiters[synKey{ip: "192.0.2.1", port: 80, srcPort: 40000}] = w
+	go s.read()
+	reply := func(ip string, srcPort, dstPort int, ack uint32, flags byte) segmentFrom {
+		b := make([]byte, 20)
+		binary.BigEndian.PutUint16(b[0:2], uint16(srcPort))
+		binary.BigEndian.PutUint16(b[2:4], uint16(dstPort))
+		binary.BigEndian.PutUint32(b[8:12], ack)
+		b[13] = flags
+		return segmentFrom{ip: ip, segment: b}
+	}
+
+	for _, flags := range []byte{0x12, 0x14} {
+		for _, seg := range []segmentFrom{
+			reply("192.0.2.2", 80, 40000, 1001, flags),       // 其他主机
+			reply("192.0.2.1", 81, 40000, 1001, flags),       // 其他端口
+			reply("192.0.2.1", 80, 40001, 1001, flags),       // 其他连接
+			reply("192.0.2.1", 80, 40000, 1000, flags),       // 没有确认探测的 SYN
+			reply("192.0.2.1", 80, 40000, 1001, flags&^0x10), // 没有 ACK
+			reply("192.0.2.1", 80, 40000, 1001, 0x10),        // 既不是 SYN-ACK 也不是 RST
+		} {
+			conn.in <- seg
+		}
+	}
+	// 上一个报文段处理完才会读取下一个
+	conn.in <- reply("192.0.2.2", 0, 0, 0, 0)
+	if len(w.ch) != 0 {
+		t.Fatalf("matched a foreign segment: %v", <-w.ch)
+	}
+	conn.in <- reply("192.0.2.1", 80, 40000, 1001, 0x14)
+	if open := <-w.ch; open {
+		t.Fatal("rst reported open")
+	}
+	conn.in <- reply("192.0.2.1", 80, 40000, 1001, 0x12)
+	if open := <-w.ch; !open {
+		t.Fatal("syn-ack reported closed")
+	}
+}
@@ -0,0 +1,88 @@
+package netx
+
+import (
+	"strconv"
+	"time"
+)
+
+// SerialLess 按 RFC 1982 序列号算术判断 a 是否早于 b. 两者相差正好 2^31 时没有定义, 返回 false
+func SerialLess(a, b uint32) bool {
+	return a != b && b-a != 1<<31 && int32(b-a) > 0
+}
+
+// SerialAdd 按 RFC 1982 3.1 加上 n, n 不能超过 2^31-1
+func SerialAdd(s, n uint32) uint32 {
+	if n > 1<<31-1 {
+		n = 1<<31 - 1
+	}
+	return s + n
+}
+
+// SOASerial 返回 SOA 记录的序列号
+func SOASerial(rr *DNSResourceRecode) (uint32, bool) {
+	if rr == nil || rr.RRType != DNSTypeSOA {
+		return 0, false
+	}
+	t, ok := parseSOATimers(rr)
+	return t.serial, ok
+}
+
+// SerialRegressionError 新的序列号早于已有的序列号, 例如传输得到的区域比本地的旧
+type SerialRegressionError struct {
+	Old, New uint32
+}
+
+func (e *SerialRegressionError) Error() string {
+	return "soa serial went backwards from " + strconv.FormatUint(uint64(e.Old), 10) + " to " + strconv.FormatUint(uint64(e.New), 10)
+}
+
+// CheckSerial 检查序列号从 old 变为 new 是否回退, 不变或前进时返回空.
+// 两者相差正好 2^31 时同样认为是回退
+func CheckSerial(old, new uint32) error {
+	if old == new || SerialLess(old, new) {
+		return nil
+	}
+	return &SerialRegressionError{Old: old, New: new}
+}
+
+// SerialStrategy 自动增加 SOA 序列号的方式
+type SerialStrategy int
+
+const (
+	// SerialIncrement 加一
+	SerialIncrement SerialStrategy = iota
+	// SerialDate YYYYMMDDnn (UTC), 当前值早于当天的 YYYYMMDD00 时使用它, 否则加一
+	SerialDate
+	// SerialUnixTime 当前的 Unix 时间, 不晚于当前值时加一
+	SerialUnixTime
+)
+
+func (s SerialStrategy) String() string {
+	switch s {
+	case SerialIncrement:
+		return "increment"
+	case SerialDate:
+		return "date"
+	case SerialUnixTime:
+		return "unixtime"
+	}
+	return "SerialStrategy(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Next 返回 current 之后的序列号, 结果总是按 RFC 1982 晚于 current
+func (s SerialStrategy) Next(current uint32, now time.Time) uint32 {
+	var candidate uint32
+	switch s {
+	case SerialDate:
+		y, m, d := now.UTC().Date()
+		candidate = uint32(y*1000000 + int(m)*10000 + d*100)
+	case SerialUnixTime:
+		candidate = uint32(now.Unix())
+	default:
+		return current + 1
+	}
+	if SerialLess(current, candidate) {
+		return candidate
+	}
+	return current + 1
+}
@@ -0,0 +1,74 @@
+package netx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSerialArithmetic(t *testing.T) {
+	for _, c := range []struct {
+		a, b uint32
+		less bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{1, 1, false},
+		{0xffffffff, 0, true}, // 回绕
+		{0, 0xffffffff, false},
+		{0, 1<<31 - 1, true},
+		{0, 1 << 31, false}, // 没有定义
+		{1 << 31, 0, false},
+	} {
+		if got := SerialLess(c.a, c.b); got != c.less {
+			t.Fatalf("SerialLess(%d, %d) = %v", c.a, c.b, got)
+		}
+	}
+	if s := SerialAdd(0xfffffff0, 0x20); s != 0x10 || SerialAdd(0, 1<<31) != 1<<31-1 {
+		t.Fatalf("SerialAdd = %d", s)
+	}
+
+	if err := CheckSerial(10, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSerial(0xfffffffe, 3); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckSerial(2024010102, 2024010101)
+	if r, ok := err.(*SerialRegressionError); !ok || r.Old != 2024010102 || r.Error() != "soa serial went backwards from 2024010102 to 2024010101" {
+		t.Fatalf("regression: %v", err)
+	}
+}
+
+func TestSerialStrategy(t *testing.T) {
+	now := time.Date(2024, 3, 5, 23, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	for _, c := range []struct {
+		s       SerialStrategy
+		current uint32
+		want    uint32
+	}{
+		{SerialIncrement, 41, 42},
+		{SerialIncrement, 0xffffffff, 0},
+		{SerialDate, 7, 2024030500},
+		{SerialDate, 2024030500, 2024030501},
+		{SerialDate, 2024030499, 2024030500},
+		{SerialDate, 2024030599, 2024030600}, // 同一天超过 99 次时借用下一天
+		{SerialDate, 2024040100, 2024040101}, // 当前值已经在未来
+		{SerialUnixTime, 1, uint32(now.Unix())},
+		{SerialUnixTime, uint32(now.Unix()), uint32(now.Unix()) + 1},
+	} {
+		if got := c.s.Next(c.current, now); got != c.want {
+			t.Fatalf("%s.Next(%d) = %d, want %d", c.s, c.current, got, c.want)
+		}
+	}
+	if SerialDate.String() != "date" || SerialStrategy(9).String() != "SerialStrategy(9)" {
+		t.Fatal("String")
+	}
+
+	rr := &DNSResourceRecode{Name: "example", RRType: DNSTypeSOA, RData: "ns.example. admin.example. 2024030501 3600 600 86400 300"}
+	if s, ok := SOASerial(rr); !ok || s != 2024030501 {
+		t.Fatalf("SOASerial = %d, %v", s, ok)
+	}
+	if _, ok := SOASerial(aRecord("example", "192.0.2.1")); ok {
+		t.Fatal("serial from an A record")
+	}
+}
@@ -0,0 +1,262 @@
+package netx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SinkholeQuery 被 Sinkhole 记录的一个请求
+type SinkholeQuery struct {
+	Time       time.Time     `json:"time"`
+	Client     string        `json:"client,omitempty"` // 客户端地址, 包括端口
+	Network    string        `json:"network"`
+	TxID       uint16        `json:"id"`
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Class      string        `json:"class"`
+	OpCode     string        `json:"opcode"`
+	RD         bool          `json:"rd"`
+	CD         bool          `json:"cd"`
+	EDNS       bool          `json:"edns"`
+	UDPSize    int           `json:"udp_size,omitempty"`
+	DO         bool          `json:"do,omitempty"`
+	Subnet     string        `json:"subnet,omitempty"` // ECS 选项
+	EDNSCodes  []uint16      `json:"edns_options,omitempty"`
+	Sinkholed  bool          `json:"sinkholed"` // 为假时请求交给了下一个处理器
+	Answers    []string      `json:"answers,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	RCode      string        `json:"rcode"`
+	MessageLen int           `json:"message_len"`
+}
+
+// SinkholeSink 接收 Sinkhole 记录的请求. Record 在处理请求的 goroutine 中调用, 不应阻塞
+type SinkholeSink interface {
+	Record(q *SinkholeQuery)
+}
+
+// SinkholeSinkFunc 允许将普通函数作为 SinkholeSink 使用
+type SinkholeSinkFunc func(q *SinkholeQuery)
+
+func (f SinkholeSinkFunc) Record(q *SinkholeQuery) { f(q) }
+
+// SinkholeJSONLog 每个请求写一行 JSON, 可以并发使用
+type SinkholeJSONLog struct {
+	// Out 日志输出, 为空时使用 os.Stderr
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+func (l *SinkholeJSONLog) Record(q *SinkholeQuery) {
+	line, err := json.Marshal(q)
+	if err != nil {
+		return
+	}
+	out := l.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	l.mu.Lock()
+	_, _ = out.Write(append(line, '\n'))
+	l.mu.Unlock()
+}
+
+// Sinkhole 把所有名字 (或 Match 匹配的名字) 解析到固定的地址, 并把每个请求的完整信息交给 Sink,
+// 用于恶意软件分析环境与受控网络. A 与 AAAA 查询应答 IPv4 与 IPv6, ANY 两者都应答,
+// 其他类型应答 NOERROR 无数据. 零值把所有名字解析到 0.0.0.0 与 ::, 可以并发使用
+type Sinkhole struct {
+	IPv4 netip.Addr // 无效时为 0.0.0.0
+	IPv6 netip.Addr // 无效时为 ::
+	TTL  uint32     // 为 0 时使用 60
+	// Match 为空时处理所有名字, 否则只处理匹配的名字, 其它请求交给下一个处理器
+	Match NameMatcher
+	// Sink 为空时只做统计
+	Sink SinkholeSink
+	// RecordAll 也记录交给下一个处理器的请求
+	RecordAll bool
+	// MaxStatsKeys 统计中 Names 与 Clients 各自最多记录的数量, 默认 10000,
+	// 超出时只保留计数最多的一半, 避免随机子域名或伪造的来源耗尽内存
+	MaxStatsKeys int
+
+	mu      sync.Mutex
+	stats   SinkholeStats
+	started time.Time
+}
+
+// SinkholeStats Sinkhole 的统计, Names 与 Clients 为被 sinkhole 的请求数, 数量受 Sinkhole.MaxStatsKeys 限制
+type SinkholeStats struct {
+	Started   time.Time         `json:"started"`
+	Queries   uint64            `json:"queries"`
+	Sinkholed uint64            `json:"sinkholed"`
+	Names     map[string]uint64 `json:"names"`
+	Clients   map[string]uint64 `json:"clients"`
+	Types     map[string]uint64 `json:"types"`
+}
+
+// Stats 返回当前统计的副本
+func (s *Sinkhole) Stats() SinkholeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := s.stats
+	snap.Started = s.started
+	snap.Names, snap.Clients, snap.Types = map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	for k, v := range s.stats.Names {
+		snap.Names[k] = v
+	}
+	for k, v := range s.stats.Clients {
+		snap.Clients[k] = v
+	}
+	for k, v := range s.stats.Types {
+		snap.Types[k] = v
+	}
+	return snap
+}
+
+// ResetStats 清空统计, 例如每次分析开始时
+func (s *Sinkhole) ResetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = SinkholeStats{}
+	s.started = time.Now()
+}
+
+func (s *Sinkhole) count(req *DNSRequest, sinkholed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	s.stats.Queries++
+	if !sinkholed {
+		return
+	}
+	if s.stats.Names == nil {
+		s.stats.Names, s.stats.Clients, s.stats.Types = map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	}
+	s.stats.Sinkholed++
+	q := req.Message.Questions[0]
+	s.increment(s.stats.Names, normalizeDomain(q.QuestionName))
+	s.stats.Types[DNSType(q.QuestionType).String()]++
+	if ip := req.ClientIP(); ip != nil {
+		s.increment(s.stats.Clients, ip.String())
+	}
+}
+
+// increment 增加 m[key], 新的 key 超出 MaxStatsKeys 时先淘汰计数较少的一半
+func (s *Sinkhole) increment(m map[string]uint64, key string) {
+	max := s.MaxStatsKeys
+	if max <= 0 {
+		max = 10000
+	}
+	if _, ok := m[key]; !ok && len(m) >= max {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return m[keys[i]] > m[keys[j]] })
+		for _, k := range keys[max/2:] {
+			delete(m, k)
+		}
+	}
+	m[key]++
+}
+
+// reply 构造 sinkhole 应答
+func (s *Sinkhole) reply(req *DNSMessage) *DNSMessage {
+	q := req.Questions[0]
+	resp := NewReply(req)
+	resp.Header.Flags.AA, resp.Header.Flags.RA = 1, 1
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = 60
+	}
+	v4, v6 := s.IPv4, s.IPv6
+	if !v4.Is4() {
+		v4 = netip.IPv4Unspecified()
+	}
+	if !v6.Is6() {
+		v6 = netip.IPv6Unspecified()
+	}
+	if q.QuestionType == DNSTypeA || q.QuestionType == DNSTypeANY {
+		resp.ResourceRecodes = append(resp.ResourceRecodes, &DNSResourceRecode{Name: q.QuestionName, RRType: DNSTypeA, Class: DNSClassIn, TTL: ttl, RData: v4.String()})
+	}
+	if q.QuestionType == DNSTypeAAAA || q.QuestionType == DNSTypeANY {
+		resp.ResourceRecodes = append(resp.ResourceRecodes, &DNSResourceRecode{Name: q.QuestionName, RRType: DNSTypeAAAA, Class: DNSClassIn, TTL: ttl, RData: v6.String()})
+	}
+	resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+	return resp
+}
+
+// record 整理请求与应答交给 Sink
+func (s *Sinkhole) record(req *DNSRequest, resp *DNSMessage, err error, sinkholed bool, start time.Time) {
+	m := req.Message
+	q := &SinkholeQuery{
+		Time:      start,
+		Network:   req.Network,
+		TxID:      m.Header.TxID,
+		OpCode:    DNSOpCode(m.Header.Flags.OpCode).String(),
+		RD:        m.Header.Flags.RD == 1,
+		CD:        m.Header.Flags.CheckingDisabled(),
+		Sinkholed: sinkholed,
+		Duration:  time.Since(start),
+	}
+	if req.RemoteAddr != nil {
+		q.Client = req.RemoteAddr.String()
+	}
+	if len(m.Questions) > 0 {
+		qq := m.Questions[0]
+		q.Name, q.Type, q.Class = qq.QuestionName, DNSType(qq.QuestionType).String(), DNSClass(qq.QuestionClass).String()
+	}
+	if b, e := m.ToByte(); e == nil {
+		q.MessageLen = len(b)
+	}
+	if opt := m.OPT(); opt != nil {
+		q.EDNS, q.UDPSize, q.DO = true, m.UDPSize(), opt.TTL&optDO != 0
+		for _, o := range m.EDNSOptions() {
+			q.EDNSCodes = append(q.EDNSCodes, o.Code)
+		}
+		if ecs := m.ClientSubnet(); ecs != nil {
+			q.Subnet = (&net.IPNet{IP: ecs.IP, Mask: net.CIDRMask(int(ecs.SourcePrefix), len(ecs.IP)*8)}).String()
+		}
+	}
+	switch {
+	case err != nil:
+		q.RCode = DNSRCode(DNSRCodeServFail).String()
+	case resp != nil:
+		q.RCode = DNSRCode(resp.Header.Flags.RCode).String()
+		for _, rr := range resp.Answers() {
+			q.Answers = append(q.Answers, DNSType(rr.RRType).String()+" "+rr.RData)
+		}
+	}
+	s.Sink.Record(q)
+}
+
+// WithSinkhole 服务端的 Sinkhole, 只有一个问题的查询 (opcode QUERY) 才会被 sinkhole
+func WithSinkhole(s *Sinkhole) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			start := time.Now()
+			m := req.Message
+			sinkholed := len(m.Questions) == 1 && m.Header.Flags.OpCode == DNSOpCodeQuery &&
+				(s.Match == nil || s.Match.Blocked(m.Questions[0].QuestionName))
+			s.count(req, sinkholed)
+			var resp *DNSMessage
+			var err error
+			if sinkholed {
+				resp = s.reply(m)
+			} else {
+				resp, err = next.ServeDNS(ctx, req)
+			}
+			if s.Sink != nil && (sinkholed || s.RecordAll) {
+				s.record(req, resp, err, sinkholed, start)
+			}
+			return resp, err
+		})
+	}
+}
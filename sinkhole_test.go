@@ -0,0 +1,102 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+func TestSinkhole(t *testing.T) {
+	var out bytes.Buffer
+	blocked := NewDomainBlocklist("c2.example")
+	s := &Sinkhole{
+		IPv4:      netip.MustParseAddr("192.0.2.66"),
+		TTL:       10,
+		Match:     blocked,
+		Sink:      &SinkholeJSONLog{Out: &out},
+		RecordAll: true,
+	}
+	next := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{aRecord(req.Message.Questions[0].QuestionName, "198.51.100.1")}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})
+	h := ChainHandler(next, WithSinkhole(s))
+	client := &net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 5353}
+	serve := func(name string, qtype uint16, opts ...QueryOption) *DNSMessage {
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery(name, qtype, opts...), Network: "udp", RemoteAddr: client})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := serve("beacon.c2.example", DNSTypeA, WithDNSSECOK()); len(resp.Answers()) != 1 || resp.Answers()[0].RData != "192.0.2.66" || resp.Answers()[0].TTL != 10 {
+		t.Fatalf("unexpected sinkhole answer %+v", resp.Answers())
+	}
+	if resp := serve("c2.example", DNSTypeANY, WithCheckingDisabled()); len(resp.Answers()) != 2 || resp.Answers()[1].RData != "::" {
+		t.Fatalf("unexpected ANY answer %+v", resp.Answers())
+	}
+	if resp := serve("c2.example", DNSTypeMX); len(resp.Answers()) != 0 || resp.Header.Flags.RCode != DNSRCodeSuccess {
+		t.Fatalf("unexpected MX answer %+v", resp)
+	}
+	if resp := serve("www.example", DNSTypeA); resp.Answers()[0].RData != "198.51.100.1" {
+		t.Fatalf("unmatched name was sinkholed: %+v", resp.Answers())
+	}
+
+	var records []SinkholeQuery
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var q SinkholeQuery
+		if err := dec.Decode(&q); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, q)
+	}
+	if len(records) != 4 || !records[0].Sinkholed || !records[0].DO || records[0].Client != "10.0.0.5:5353" || records[0].Type != "A" ||
+		records[0].CD || !records[1].CD {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if records[3].Sinkholed || records[3].Name != "www.example" || len(records[3].Answers) != 1 {
+		t.Fatalf("unexpected passthrough record %+v", records[3])
+	}
+
+	stats := s.Stats()
+	if stats.Queries != 4 || stats.Sinkholed != 3 || stats.Names["c2.example"] != 2 || stats.Clients["10.0.0.5"] != 3 || stats.Types["ANY"] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	s.ResetStats()
+	if stats := s.Stats(); stats.Queries != 0 || len(stats.Names) != 0 {
+		t.Fatalf("stats not reset %+v", stats)
+	}
+}
+
+func TestSinkholeStatsLimit(t *testing.T) {
+	s := &Sinkhole{MaxStatsKeys: 10}
+	h := ChainHandler(nil, WithSinkhole(s))
+	serve := func(name string, client net.IP) {
+		req := &DNSRequest{Message: NewQuery(name, DNSTypeA), Network: "udp", RemoteAddr: &net.UDPAddr{IP: client, Port: 5353}}
+		if _, err := h.ServeDNS(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 常见的名字与客户端先出现多次, 之后是大量随机子域名与伪造的来源
+	for i := 0; i < 3; i++ {
+		serve("c2.example", net.ParseIP("10.0.0.5"))
+	}
+	for i := 0; i < 1000; i++ {
+		serve(strconv.Itoa(i)+".c2.example", net.IPv4(198, 51, byte(i>>8), byte(i)))
+	}
+	stats := s.Stats()
+	if len(stats.Names) > 10 || len(stats.Clients) > 10 || stats.Sinkholed != 1003 {
+		t.Fatalf("names %d, clients %d, sinkholed %d", len(stats.Names), len(stats.Clients), stats.Sinkholed)
+	}
+	if stats.Names["c2.example"] != 3 || stats.Clients["10.0.0.5"] != 3 || stats.Names["999.c2.example"] != 1 {
+		t.Fatalf("top entries lost: %v %v", stats.Names, stats.Clients)
+	}
+}
@@ -0,0 +1,18 @@
+package netx
+
+import (
+	"syscall"
+)
+
+// setSockoptDF 设置 DF 标志, 并忽略内核缓存的路径 MTU 以便探测
+func setSockoptDF(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+}
+
+// bindToDeviceSupported Binding.Interface 使用 SO_BINDTODEVICE
+const bindToDeviceSupported = true
+
+// setSockoptBindToDevice 将 socket 绑定到指定网卡
+func setSockoptBindToDevice(fd uintptr, ifname string) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package netx
+
+func setSockoptDF(fd uintptr) error {
+	return ErrNotSupported
+}
+
+const bindToDeviceSupported = false
+
+func setSockoptBindToDevice(fd uintptr, ifname string) error {
+	return ErrNotSupported
+}
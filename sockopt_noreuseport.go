@@ -0,0 +1,8 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package netx
+
+func setSockoptReusePort(fd uintptr) error {
+	return ErrNotSupported
+}
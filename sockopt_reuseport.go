@@ -0,0 +1,13 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build aix darwin dragonfly freebsd linux netbsd openbsd
+
+package netx
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setSockoptReusePort 允许多个 socket 绑定同一地址, 内核在它们之间分配数据包
+func setSockoptReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,34 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package netx
+
+func setSockoptTTL(fd uintptr, ttl int) error {
+	return ErrNotSupported
+}
+
+const bindEphemeralSupported = false
+
+func bindEphemeralPort(fd uintptr) (int, error) {
+	return 0, ErrNotSupported
+}
+
+func addrInUse(err error) bool {
+	return false
+}
+
+func netUnreachable(err error) bool {
+	return false
+}
+
+func hostUnreachable(err error) bool {
+	return false
+}
+
+func connRefused(err error) bool {
+	return false
+}
+
+func msgTooLong(err error) bool {
+	return false
+}
@@ -0,0 +1,57 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+func setSockoptTTL(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// bindEphemeralSupported 可以在 Dialer.Control 中预先绑定本地端口
+const bindEphemeralSupported = true
+
+// bindEphemeralPort 把 ipv4 socket 绑定到系统分配的端口并返回该端口, 之后 connect 使用这个端口
+func bindEphemeralPort(fd uintptr) (int, error) {
+	if err := syscall.Bind(int(fd), &syscall.SockaddrInet4{}); err != nil {
+		return 0, err
+	}
+	sa, err := syscall.Getsockname(int(fd))
+	if err != nil {
+		return 0, err
+	}
+	addr, ok := sa.(*syscall.SockaddrInet4)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return addr.Port, nil
+}
+
+// addrInUse err 是否为本地地址已被占用
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// netUnreachable err 是否为网络不可达
+func netUnreachable(err error) bool {
+	return errors.Is(err, syscall.ENETUNREACH)
+}
+
+// hostUnreachable err 是否为主机不可达
+func hostUnreachable(err error) bool {
+	return errors.Is(err, syscall.EHOSTUNREACH)
+}
+
+// connRefused err 是否为连接被拒绝
+func connRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// msgTooLong err 是否为报文超过已知的 MTU
+func msgTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
@@ -0,0 +1,53 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+func setSockoptTTL(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// bindEphemeralSupported windows 上 Dial 总是自己 bind, 不能预先绑定
+const bindEphemeralSupported = false
+
+func bindEphemeralPort(fd uintptr) (int, error) {
+	return 0, ErrNotSupported
+}
+
+// wsaeAddrInUse WSAEADDRINUSE, syscall.EADDRINUSE 在 windows 上不是 winsock 的错误码
+const wsaeAddrInUse = syscall.Errno(10048)
+
+// addrInUse err 是否为本地地址已被占用
+func addrInUse(err error) bool {
+	return errors.Is(err, wsaeAddrInUse)
+}
+
+// winsock 的错误码, 与 syscall 中同名的常量不同
+const (
+	wsaeMsgSize     = syscall.Errno(10040)
+	wsaeNetUnreach  = syscall.Errno(10051)
+	wsaeConnRefused = syscall.Errno(10061)
+	wsaeHostUnreach = syscall.Errno(10065)
+)
+
+// netUnreachable err 是否为网络不可达
+func netUnreachable(err error) bool {
+	return errors.Is(err, wsaeNetUnreach)
+}
+
+// hostUnreachable err 是否为主机不可达
+func hostUnreachable(err error) bool {
+	return errors.Is(err, wsaeHostUnreach)
+}
+
+// connRefused err 是否为连接被拒绝
+func connRefused(err error) bool {
+	return errors.Is(err, wsaeConnRefused)
+}
+
+// msgTooLong err 是否为报文超过已知的 MTU
+func msgTooLong(err error) bool {
+	return errors.Is(err, wsaeMsgSize)
+}
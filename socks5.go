@@ -0,0 +1,342 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	socksVersion = 5
+
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksAuthNoAccept = 0xFF
+
+	socksCmdConnect      = 1
+	socksCmdUDPAssociate = 3
+
+	socksAtypIPv4   = 1
+	socksAtypDomain = 3
+	socksAtypIPv6   = 4
+)
+
+var (
+	ErrSOCKSVersion = errors.New("unexpected socks version")
+	ErrSOCKSAuth    = errors.New("socks authentication failed")
+	ErrSOCKSAddress = errors.New("invalid socks address")
+	ErrSOCKSNetwork = errors.New("socks network must be tcp or udp")
+)
+
+var socksReplyMessages = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "ttl expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// SOCKSReplyError 代理返回的应答码不为 0
+type SOCKSReplyError struct {
+	Code byte
+}
+
+func (e *SOCKSReplyError) Error() string {
+	if msg, ok := socksReplyMessages[e.Code]; ok {
+		return "socks reply: " + msg
+	}
+	return "socks reply: " + strconv.Itoa(int(e.Code))
+}
+
+// socksAppendAddr 按 SOCKS5 格式写入 host:port, host 不是 ip 时交给代理解析
+func socksAppendAddr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.WithMessage(ErrSOCKSAddress, address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xFFFF {
+		return nil, errors.WithMessage(ErrSOCKSAddress, address)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			b = append(b, socksAtypIPv4)
+			b = append(b, v4...)
+		} else {
+			b = append(b, socksAtypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, errors.WithMessage(ErrSOCKSAddress, address)
+		}
+		b = append(b, socksAtypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// socksReadAddr 读取 SOCKS5 格式的地址, 返回 host:port
+func socksReadAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, 4)
+		if atyp[0] == socksAtypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "", err
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", ErrSOCKSAddress
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// SOCKS5Dialer 通过 SOCKS5 代理建立 tcp 连接 (CONNECT) 或收发 udp (UDP ASSOCIATE)
+type SOCKS5Dialer struct {
+	ProxyAddress string
+	// Username 不为空时使用 RFC 1929 用户名密码认证
+	Username string
+	Password string
+	// Forward 用于连接代理, 为空时直接连接
+	Forward Dialer
+}
+
+func (d *SOCKS5Dialer) forward() Dialer {
+	if d.Forward != nil {
+		return d.Forward
+	}
+	return &net.Dialer{}
+}
+
+// handshake 连接代理并完成认证与请求, 返回控制连接与代理应答中的地址
+func (d *SOCKS5Dialer) handshake(ctx context.Context, cmd byte, address string) (net.Conn, string, error) {
+	conn, err := d.forward().DialContext(ctx, "tcp", d.ProxyAddress)
+	if err != nil {
+		return nil, "", errors.WithMessage(err, "dial socks proxy error")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	bound, err := d.negotiate(conn, cmd, address)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, bound, nil
+}
+
+func (d *SOCKS5Dialer) negotiate(conn net.Conn, cmd byte, address string) (string, error) {
+	methods := []byte{socksAuthNone}
+	if d.Username != "" {
+		methods = []byte{socksAuthPassword}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return "", err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return "", err
+	}
+	if reply[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if len(d.Username) > 255 || len(d.Password) > 255 {
+			return "", errors.WithMessage(ErrSOCKSAuth, "username or password too long")
+		}
+		b := []byte{1, byte(len(d.Username))}
+		b = append(b, d.Username...)
+		b = append(b, byte(len(d.Password)))
+		b = append(b, d.Password...)
+		if _, err := conn.Write(b); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return "", err
+		}
+		if reply[1] != 0 {
+			return "", ErrSOCKSAuth
+		}
+	default:
+		return "", errors.WithMessage(ErrSOCKSAuth, "no acceptable authentication method")
+	}
+
+	req, err := socksAppendAddr([]byte{socksVersion, cmd, 0}, address)
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	if head[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+	if head[1] != 0 {
+		return "", &SOCKSReplyError{Code: head[1]}
+	}
+	return socksReadAddr(conn)
+}
+
+// DialContext network 为 tcp 时使用 CONNECT, 为 udp 时使用 UDP ASSOCIATE 并只与 address 通信
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		conn, _, err := d.handshake(ctx, socksCmdConnect, address)
+		return conn, err
+	case "udp", "udp4", "udp6":
+		pc, err := d.ListenPacket(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &socksUDPConn{socksPacketConn: pc.(*socksPacketConn), remote: address}, nil
+	}
+	return nil, errors.WithMessage(ErrSOCKSNetwork, network)
+}
+
+// ListenPacket 建立 UDP ASSOCIATE, 返回的 PacketConn 可以与任意地址通信, 目标地址可以是域名.
+// 控制连接断开后 PacketConn 随之关闭
+func (d *SOCKS5Dialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	ctrl, bound, err := d.handshake(ctx, socksCmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	relay, err := net.ResolveUDPAddr("udp", bound)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+	// 代理返回未指定地址时使用控制连接的对端地址
+	if relay.IP.IsUnspecified() {
+		host, _, _ := net.SplitHostPort(ctrl.RemoteAddr().String())
+		if ip := net.ParseIP(host); ip != nil {
+			relay.IP = ip
+		}
+	}
+	udp, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+	pc := &socksPacketConn{UDPConn: udp, ctrl: ctrl, relay: relay}
+	go func() {
+		_, _ = io.Copy(io.Discard, ctrl)
+		_ = pc.Close()
+	}()
+	return pc, nil
+}
+
+// socksAddr 代理转发的 udp 报文来源为域名时使用
+type socksAddr string
+
+func (a socksAddr) Network() string { return "udp" }
+func (a socksAddr) String() string  { return string(a) }
+
+type socksPacketConn struct {
+	*net.UDPConn
+	ctrl  net.Conn
+	relay *net.UDPAddr
+	once  sync.Once
+}
+
+func (c *socksPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+262)
+	for {
+		n, from, err := c.UDPConn.ReadFromUDP(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		// 只接受来自中继的报文, 丢弃分片报文
+		if !from.IP.Equal(c.relay.IP) || from.Port != c.relay.Port || n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		address, err := socksReadAddr(r)
+		if err != nil {
+			continue
+		}
+		var addr net.Addr = socksAddr(address)
+		host, port, _ := net.SplitHostPort(address)
+		if ip := net.ParseIP(host); ip != nil {
+			p, _ := strconv.Atoi(port)
+			addr = &net.UDPAddr{IP: ip, Port: p}
+		}
+		return copy(b, buf[n-r.Len():n]), addr, nil
+	}
+}
+
+func (c *socksPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	packet, err := socksAppendAddr([]byte{0, 0, 0}, addr.String())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.UDPConn.WriteToUDP(append(packet, b...), c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socksPacketConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		_ = c.ctrl.Close()
+		err = c.UDPConn.Close()
+	})
+	return err
+}
+
+// socksUDPConn 只与 remote 通信的 udp 连接
+type socksUDPConn struct {
+	*socksPacketConn
+	remote string
+}
+
+func (c *socksUDPConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *socksUDPConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, socksAddr(c.remote))
+}
+
+func (c *socksUDPConn) RemoteAddr() net.Addr {
+	return socksAddr(c.remote)
+}
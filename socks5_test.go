@@ -0,0 +1,97 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+// fakeSOCKS 只支持用户名密码认证与 CONNECT 的最小代理
+func fakeSOCKS(t *testing.T, user, pass string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				head := make([]byte, 2)
+				_, _ = io.ReadFull(conn, head)
+				_, _ = io.ReadFull(conn, make([]byte, head[1]))
+				_, _ = conn.Write([]byte{socksVersion, socksAuthPassword})
+				_, _ = io.ReadFull(conn, head)
+				u := make([]byte, head[1])
+				_, _ = io.ReadFull(conn, u)
+				_, _ = io.ReadFull(conn, head[:1])
+				p := make([]byte, head[0])
+				_, _ = io.ReadFull(conn, p)
+				if string(u) != user || string(p) != pass {
+					_, _ = conn.Write([]byte{1, 1})
+					return
+				}
+				_, _ = conn.Write([]byte{1, 0})
+				req := make([]byte, 3)
+				_, _ = io.ReadFull(conn, req)
+				address, err := socksReadAddr(conn)
+				if err != nil {
+					return
+				}
+				target, err := net.Dial("tcp", address)
+				if err != nil {
+					_, _ = conn.Write([]byte{socksVersion, 5, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				reply, _ := socksAppendAddr([]byte{socksVersion, 0, 0}, target.LocalAddr().String())
+				_, _ = conn.Write(reply)
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestSOCKS5DialerConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err == nil {
+			_, _ = io.Copy(conn, conn)
+			conn.Close()
+		}
+	}()
+	proxy := fakeSOCKS(t, "user", "secret")
+	defer proxy.Close()
+
+	d := &SOCKS5Dialer{ProxyAddress: proxy.Addr().String(), Username: "user", Password: "secret"}
+	conn, err := d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg := []byte("hello socks")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("unexpected echo %q %v", got, err)
+	}
+
+	d.Password = "wrong"
+	if _, err := d.DialContext(context.Background(), "tcp", echo.Addr().String()); err != ErrSOCKSAuth {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+}
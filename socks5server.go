@@ -0,0 +1,366 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrSOCKSDenied = errors.New("socks request denied by rule")
+
+// SOCKSAuthenticator 服务端认证插件
+type SOCKSAuthenticator interface {
+	// Method 认证方法编号, 如 0x00 (无认证), 0x02 (用户名密码)
+	Method() byte
+	// Authenticate 在选定方法后完成子协商, 返回用户名
+	Authenticate(conn io.ReadWriter) (string, error)
+}
+
+// SOCKSNoAuth 不认证
+type SOCKSNoAuth struct{}
+
+func (SOCKSNoAuth) Method() byte { return socksAuthNone }
+
+func (SOCKSNoAuth) Authenticate(conn io.ReadWriter) (string, error) { return "", nil }
+
+// SOCKSPasswordAuth RFC 1929 用户名密码认证
+type SOCKSPasswordAuth struct {
+	Validate func(user, password string) bool
+}
+
+func (SOCKSPasswordAuth) Method() byte { return socksAuthPassword }
+
+func (a SOCKSPasswordAuth) Authenticate(conn io.ReadWriter) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	if head[0] != 1 {
+		return "", ErrSOCKSVersion
+	}
+	user := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, head[:1]); err != nil {
+		return "", err
+	}
+	password := make([]byte, head[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", err
+	}
+	if a.Validate == nil || !a.Validate(string(user), string(password)) {
+		_, _ = conn.Write([]byte{1, 1})
+		return "", ErrSOCKSAuth
+	}
+	_, err := conn.Write([]byte{1, 0})
+	return string(user), err
+}
+
+// SOCKSRequest 交给规则判断的请求, UDP ASSOCIATE 中的每个目标地址都会单独判断
+type SOCKSRequest struct {
+	User        string
+	Command     byte // 1 CONNECT, 3 UDP ASSOCIATE
+	Client      net.Addr
+	Destination string // 客户端请求的 host:port
+	IP          net.IP // 解析后的目标地址
+	Port        int
+}
+
+// SOCKSRule 返回 false 时拒绝请求
+type SOCKSRule func(req *SOCKSRequest) bool
+
+// SOCKSAllowNetworks 只允许访问 nets 中的目标地址
+func SOCKSAllowNetworks(nets ...*net.IPNet) SOCKSRule {
+	return func(req *SOCKSRequest) bool {
+		for _, n := range nets {
+			if n.Contains(req.IP) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SOCKSDenyNetworks 拒绝访问 nets 中的目标地址, 常用于禁止访问内网
+func SOCKSDenyNetworks(nets ...*net.IPNet) SOCKSRule {
+	allow := SOCKSAllowNetworks(nets...)
+	return func(req *SOCKSRequest) bool {
+		return !allow(req)
+	}
+}
+
+// SOCKS5Server 可嵌入的 SOCKS5 服务端, 支持 CONNECT 与 UDP ASSOCIATE
+type SOCKS5Server struct {
+	// Auth 按客户端提供的顺序匹配, 为空时不认证
+	Auth []SOCKSAuthenticator
+	// Rules 全部返回 true 时才允许请求
+	Rules []SOCKSRule
+	// Resolver 不为空时由它解析目标域名, 否则使用系统解析
+	Resolver *Resolver
+	// Dialer 用于出站 tcp 连接, 为空时直接连接
+	Dialer Dialer
+	// HandshakeTimeout 认证与请求阶段的超时, 默认 10s
+	HandshakeTimeout time.Duration
+}
+
+// Serve 接受 ln 上的连接直到 ln 关闭
+func (s *SOCKS5Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn 处理一个客户端连接, 返回时连接已关闭
+func (s *SOCKS5Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	user, err := s.authenticate(conn)
+	if err != nil {
+		return
+	}
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(conn, head); err != nil || head[0] != socksVersion {
+		return
+	}
+	address, err := socksReadAddr(conn)
+	if err != nil {
+		s.reply(conn, 8, nil)
+		return
+	}
+	req := &SOCKSRequest{User: user, Command: head[1], Client: conn.RemoteAddr(), Destination: address}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	switch head[1] {
+	case socksCmdConnect:
+		s.connect(ctx, conn, req)
+	case socksCmdUDPAssociate:
+		s.associate(conn, req)
+	default:
+		s.reply(conn, 7, nil)
+	}
+}
+
+func (s *SOCKS5Server) authenticate(conn net.Conn) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	if head[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	auth := s.Auth
+	if len(auth) == 0 {
+		auth = []SOCKSAuthenticator{SOCKSNoAuth{}}
+	}
+	for _, m := range methods {
+		for _, a := range auth {
+			if a.Method() != m {
+				continue
+			}
+			if _, err := conn.Write([]byte{socksVersion, m}); err != nil {
+				return "", err
+			}
+			return a.Authenticate(conn)
+		}
+	}
+	_, _ = conn.Write([]byte{socksVersion, socksAuthNoAccept})
+	return "", ErrSOCKSAuth
+}
+
+// reply 发送应答, bound 为空时使用 0.0.0.0:0
+func (s *SOCKS5Server) reply(conn net.Conn, code byte, bound net.Addr) {
+	address := "0.0.0.0:0"
+	if bound != nil {
+		address = bound.String()
+	}
+	b, err := socksAppendAddr([]byte{socksVersion, code, 0}, address)
+	if err != nil {
+		b, _ = socksAppendAddr([]byte{socksVersion, code, 0}, "0.0.0.0:0")
+	}
+	_, _ = conn.Write(b)
+}
+
+// resolve 解析 req.Destination 并检查规则
+func (s *SOCKS5Server) resolve(ctx context.Context, req *SOCKSRequest) error {
+	host, portStr, err := net.SplitHostPort(req.Destination)
+	if err != nil {
+		return err
+	}
+	req.Port, _ = strconv.Atoi(portStr)
+	req.IP = net.ParseIP(host)
+	if req.IP == nil {
+		var addrs []string
+		if s.Resolver != nil {
+			addrs, err = s.Resolver.LookupHost(ctx, host)
+		} else {
+			addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+		}
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			if req.IP = net.ParseIP(addr); req.IP != nil {
+				break
+			}
+		}
+		if req.IP == nil {
+			return errors.WithMessage(ErrNoAddress, host)
+		}
+	}
+	for _, rule := range s.Rules {
+		if !rule(req) {
+			return ErrSOCKSDenied
+		}
+	}
+	return nil
+}
+
+// socksReplyCode 将出站错误转换为应答码
+func socksReplyCode(err error) byte {
+	switch {
+	case err == ErrSOCKSDenied:
+		return 2
+	case netUnreachable(err):
+		return 3
+	case hostUnreachable(err):
+		return 4
+	case connRefused(err):
+		return 5
+	}
+	return 1
+}
+
+func (s *SOCKS5Server) connect(ctx context.Context, conn net.Conn, req *SOCKSRequest) {
+	if err := s.resolve(ctx, req); err != nil {
+		s.reply(conn, socksReplyCode(err), nil)
+		return
+	}
+	var dialer Dialer = &net.Dialer{}
+	if s.Dialer != nil {
+		dialer = s.Dialer
+	}
+	target, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(req.IP.String(), strconv.Itoa(req.Port)))
+	if err != nil {
+		s.reply(conn, socksReplyCode(err), nil)
+		return
+	}
+	defer target.Close()
+	s.reply(conn, 0, target.LocalAddr())
+	_ = conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(target, conn)
+		// 客户端关闭写方向后通知目标
+		if tcp, ok := target.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+		close(done)
+	}()
+	_, _ = io.Copy(conn, target)
+	_ = conn.Close()
+	<-done
+}
+
+// associate 为客户端建立 udp 中继, 控制连接关闭时结束. 只转发客户端发送过的目标地址发来的报文
+func (s *SOCKS5Server) associate(conn net.Conn, req *SOCKSRequest) {
+	local, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(local)})
+	if err != nil {
+		s.reply(conn, 1, nil)
+		return
+	}
+	defer relay.Close()
+	outbound, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		s.reply(conn, 1, nil)
+		return
+	}
+	defer outbound.Close()
+	s.reply(conn, 0, relay.LocalAddr())
+	_ = conn.SetDeadline(time.Time{})
+
+	clientHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	clientIP := net.ParseIP(clientHost)
+	var mu sync.Mutex
+	var client *net.UDPAddr
+	peers := map[netip.AddrPort]bool{}
+
+	// 目标 -> 客户端
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := outbound.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			peer := from.AddrPort()
+			mu.Lock()
+			dst, known := client, peers[netip.AddrPortFrom(peer.Addr().Unmap(), peer.Port())]
+			mu.Unlock()
+			if dst == nil || !known {
+				continue
+			}
+			packet, err := socksAppendAddr([]byte{0, 0, 0}, from.String())
+			if err != nil {
+				continue
+			}
+			_, _ = relay.WriteToUDP(append(packet, buf[:n]...), dst)
+		}
+	}()
+	// 客户端 -> 目标
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// 只接受控制连接所在主机发来的不分片报文
+			if !from.IP.Equal(clientIP) || n < 4 || buf[2] != 0 {
+				continue
+			}
+			r := bytes.NewReader(buf[3:n])
+			address, err := socksReadAddr(r)
+			if err != nil {
+				continue
+			}
+			target := *req
+			target.Destination = address
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = s.resolve(ctx, &target)
+			cancel()
+			if err != nil {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(target.IP)
+			mu.Lock()
+			client = from
+			peers[netip.AddrPortFrom(ip.Unmap(), uint16(target.Port))] = true
+			mu.Unlock()
+			_, _ = outbound.WriteToUDP(buf[n-r.Len():n], &net.UDPAddr{IP: target.IP, Port: target.Port})
+		}
+	}()
+	_, _ = io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,149 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSOCKS5Server(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	udpEcho, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpEcho.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := udpEcho.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udpEcho.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, denied, _ := net.ParseCIDR("10.0.0.0/8")
+	server := &SOCKS5Server{
+		Auth: []SOCKSAuthenticator{SOCKSPasswordAuth{Validate: func(user, password string) bool {
+			return user == "user" && password == "secret"
+		}}},
+		Rules: []SOCKSRule{SOCKSDenyNetworks(denied)},
+	}
+	go func() { _ = server.Serve(ln) }()
+
+	ctx := context.Background()
+	d := &SOCKS5Dialer{ProxyAddress: ln.Addr().String(), Username: "user", Password: "secret"}
+	for _, network := range []string{"tcp", "udp"} {
+		target := echo.Addr().String()
+		if network == "udp" {
+			target = udpEcho.LocalAddr().String()
+		}
+		conn, err := d.DialContext(ctx, network, target)
+		if err != nil {
+			t.Fatal(network, err)
+		}
+		msg := []byte("ping over " + network)
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 64)
+		n, err := conn.Read(got)
+		if err != nil || !bytes.Equal(got[:n], msg) {
+			t.Fatalf("%s: unexpected echo %q %v", network, got[:n], err)
+		}
+		conn.Close()
+	}
+
+	_, err = d.DialContext(ctx, "tcp", "10.1.2.3:80")
+	if e, ok := err.(*SOCKSReplyError); !ok || e.Code != 2 {
+		t.Fatalf("expected ruleset denial, got %v", err)
+	}
+
+	// 目标拒绝连接时应答 5
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	_, err = d.DialContext(ctx, "tcp", closed.Addr().String())
+	if e, ok := err.(*SOCKSReplyError); !ok || e.Code != 5 {
+		t.Fatalf("expected connection refused, got %v", err)
+	}
+}
+
+func TestSOCKS5ServerUDPFilter(t *testing.T) {
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// 知道中继出口地址的第三方先于目标发送
+			_, _ = stranger.WriteTo([]byte("spoofed"), addr)
+			_, _ = target.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() { _ = (&SOCKS5Server{}).Serve(ln) }()
+
+	pc, err := (&SOCKS5Dialer{ProxyAddress: ln.Addr().String()}).ListenPacket(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.WriteTo([]byte("ping"), target.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" || from.String() != target.LocalAddr().String() {
+		t.Fatalf("read %q from %v: %v", buf[:n], from, err)
+	}
+	_ = pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, from, err := pc.ReadFrom(buf); err == nil {
+		t.Fatalf("relayed %q from %v", buf[:n], from)
+	}
+}
@@ -0,0 +1,546 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SPFResult check_host() 的结果 (RFC 7208 2.6)
+type SPFResult string
+
+const (
+	SPFNone      SPFResult = "none"
+	SPFNeutral   SPFResult = "neutral"
+	SPFPass      SPFResult = "pass"
+	SPFFail      SPFResult = "fail"
+	SPFSoftFail  SPFResult = "softfail"
+	SPFTempError SPFResult = "temperror"
+	SPFPermError SPFResult = "permerror"
+)
+
+const (
+	maxSPFLookups     = 10
+	maxSPFVoidLookups = 2
+	maxSPFNames       = 10 // mx 与 ptr 最多检查的名字数
+)
+
+var (
+	ErrSPFSyntax      = errors.New("invalid spf record")
+	ErrSPFLookupLimit = errors.New("spf dns lookup limit exceeded")
+	ErrSPFVoidLimit   = errors.New("spf void lookup limit exceeded")
+)
+
+// SPFMechanism 带限定符的机制, 如 -all, include:example.com, a/24
+type SPFMechanism struct {
+	Qualifier byte   // '+', '-', '~' 或 '?'
+	Name      string // all, include, a, mx, ptr, ip4, ip6, exists
+	Domain    string // 域名参数, 可能包含宏, 为空时使用当前域名
+	IPNet     *net.IPNet
+	Prefix4   int // a 与 mx 的 ipv4 前缀长度, 默认 32
+	Prefix6   int // a 与 mx 的 ipv6 前缀长度, 默认 128
+}
+
+func (m *SPFMechanism) result() SPFResult {
+	switch m.Qualifier {
+	case '-':
+		return SPFFail
+	case '~':
+		return SPFSoftFail
+	case '?':
+		return SPFNeutral
+	}
+	return SPFPass
+}
+
+// SPFRecord 解析后的 v=spf1 记录
+type SPFRecord struct {
+	Mechanisms  []*SPFMechanism
+	Redirect    string
+	Explanation string
+}
+
+// ParseSPF 解析 SPF 记录, 未知的修饰符会被忽略
+func ParseSPF(txt string) (*SPFRecord, error) {
+	terms := strings.Fields(txt)
+	if len(terms) == 0 || !strings.EqualFold(terms[0], "v=spf1") {
+		return nil, errors.WithMessage(ErrSPFSyntax, "missing v=spf1")
+	}
+	record := &SPFRecord{}
+	for _, term := range terms[1:] {
+		// 修饰符: 名字之后紧跟 =
+		if i := strings.IndexAny(term, "=:/"); i > 0 && term[i] == '=' {
+			name, value := strings.ToLower(term[:i]), term[i+1:]
+			switch name {
+			case "redirect":
+				if record.Redirect != "" {
+					return nil, errors.WithMessage(ErrSPFSyntax, "duplicate redirect")
+				}
+				record.Redirect = value
+			case "exp":
+				if record.Explanation != "" {
+					return nil, errors.WithMessage(ErrSPFSyntax, "duplicate exp")
+				}
+				record.Explanation = value
+			}
+			continue
+		}
+		m, err := parseSPFMechanism(term)
+		if err != nil {
+			return nil, err
+		}
+		record.Mechanisms = append(record.Mechanisms, m)
+	}
+	return record, nil
+}
+
+func parseSPFMechanism(term string) (*SPFMechanism, error) {
+	m := &SPFMechanism{Qualifier: '+', Prefix4: 32, Prefix6: 128}
+	if strings.IndexByte("+-~?", term[0]) >= 0 {
+		m.Qualifier = term[0]
+		term = term[1:]
+	}
+	i := strings.IndexAny(term, ":/")
+	if i < 0 {
+		i = len(term)
+	}
+	m.Name = strings.ToLower(term[:i])
+	rest := term[i:]
+	bad := errors.WithMessage(ErrSPFSyntax, term)
+
+	switch m.Name {
+	case "all":
+		if rest != "" {
+			return nil, bad
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(rest, ":") || len(rest) == 1 {
+			return nil, bad
+		}
+		m.Domain = rest[1:]
+	case "ptr":
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") || len(rest) == 1 {
+				return nil, bad
+			}
+			m.Domain = rest[1:]
+		}
+	case "a", "mx":
+		if strings.HasPrefix(rest, ":") {
+			end := strings.IndexByte(rest, '/')
+			if end < 0 {
+				end = len(rest)
+			}
+			m.Domain = rest[1:end]
+			rest = rest[end:]
+			if m.Domain == "" {
+				return nil, bad
+			}
+		}
+		// dual-cidr-length: /n, //n 或 /n//n
+		if rest != "" {
+			v4, v6 := rest, ""
+			if j := strings.Index(rest, "//"); j >= 0 {
+				v4, v6 = rest[:j], rest[j+2:]
+				if !spfPrefix(v6, 128, &m.Prefix6) {
+					return nil, bad
+				}
+			}
+			if v4 != "" && (!strings.HasPrefix(v4, "/") || !spfPrefix(v4[1:], 32, &m.Prefix4)) {
+				return nil, bad
+			}
+		}
+	case "ip4", "ip6":
+		if !strings.HasPrefix(rest, ":") {
+			return nil, bad
+		}
+		addr, bits, size := rest[1:], 32, net.IPv4len
+		if m.Name == "ip6" {
+			bits, size = 128, net.IPv6len
+		}
+		prefix := bits
+		if j := strings.IndexByte(addr, '/'); j >= 0 {
+			if !spfPrefix(addr[j+1:], bits, &prefix) {
+				return nil, bad
+			}
+			addr = addr[:j]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || (m.Name == "ip4") != (ip.To4() != nil) {
+			return nil, bad
+		}
+		if size == net.IPv4len {
+			ip = ip.To4()
+		}
+		mask := net.CIDRMask(prefix, bits)
+		m.IPNet = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	default:
+		return nil, errors.WithMessage(ErrSPFSyntax, "unknown mechanism "+m.Name)
+	}
+	return m, nil
+}
+
+func spfPrefix(s string, max int, prefix *int) bool {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > max || s == "" || s[0] == '+' {
+		return false
+	}
+	*prefix = n
+	return true
+}
+
+// spfCheck 一次 check_host() 调用的状态, include 与 redirect 共享查询计数
+type spfCheck struct {
+	r            *Resolver
+	ip           net.IP
+	sender       string
+	local        string
+	senderDomain string
+	helo         string
+	lookups      int
+	voids        int
+}
+
+// CheckSPF 按 RFC 7208 对 ip 与发件人 sender 执行 check_host(), domain 通常为 sender 的域名或 HELO 名.
+// 结果为 temperror 或 permerror 时 error 说明原因
+func (r *Resolver) CheckSPF(ctx context.Context, ip net.IP, domain, sender string) (SPFResult, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	c := &spfCheck{r: r, ip: ip, helo: domain}
+	if v4 := ip.To4(); v4 != nil {
+		c.ip = v4
+	}
+	c.local, c.senderDomain = "postmaster", domain
+	if i := strings.LastIndexByte(sender, '@'); i >= 0 {
+		if i > 0 {
+			c.local = sender[:i]
+		}
+		c.senderDomain = sender[i+1:]
+	} else if sender != "" {
+		c.senderDomain = sender
+	}
+	c.sender = c.local + "@" + c.senderDomain
+	return c.checkHost(ctx, domain)
+}
+
+func (c *spfCheck) checkHost(ctx context.Context, domain string) (SPFResult, error) {
+	if _, err := splitName(domain); err != nil || !strings.Contains(domain, ".") {
+		return SPFNone, nil
+	}
+	txts, err := c.r.LookupTXT(ctx, domain)
+	if err != nil {
+		if isNXDomain(err) {
+			return SPFNone, nil
+		}
+		return SPFTempError, err
+	}
+	txt, err := uniqueRecord(domain, txts, "v=spf1")
+	if err != nil {
+		return SPFPermError, err
+	}
+	if txt == "" {
+		return SPFNone, nil
+	}
+	record, err := ParseSPF(txt)
+	if err != nil {
+		return SPFPermError, err
+	}
+
+	for _, m := range record.Mechanisms {
+		matched, result, err := c.match(ctx, domain, m)
+		if err != nil {
+			return result, err
+		}
+		if matched {
+			return m.result(), nil
+		}
+	}
+	if record.Redirect == "" {
+		return SPFNeutral, nil
+	}
+	if err := c.count(); err != nil {
+		return SPFPermError, err
+	}
+	target, err := c.expand(record.Redirect, domain)
+	if err != nil {
+		return SPFPermError, err
+	}
+	result, err := c.checkHost(ctx, target)
+	if result == SPFNone {
+		return SPFPermError, errors.WithMessage(ErrSPFSyntax, "redirect to domain without spf: "+target)
+	}
+	return result, err
+}
+
+// count 计入一次 DNS 机制查询
+func (c *spfCheck) count() error {
+	c.lookups++
+	if c.lookups > maxSPFLookups {
+		return ErrSPFLookupLimit
+	}
+	return nil
+}
+
+// query 查询 name 的 qtype 记录, NXDOMAIN 与空应答计为 void lookup
+func (c *spfCheck) query(ctx context.Context, name string, qtype uint16) ([]string, SPFResult, error) {
+	records, err := c.r.lookup(ctx, name, qtype)
+	if err != nil && !isNXDomain(err) {
+		return nil, SPFTempError, err
+	}
+	if len(records) == 0 {
+		c.voids++
+		if c.voids > maxSPFVoidLookups {
+			return nil, SPFPermError, ErrSPFVoidLimit
+		}
+	}
+	return records, "", nil
+}
+
+// addrType 与被检查 ip 相同族的地址类型
+func (c *spfCheck) addrType() uint16 {
+	if len(c.ip) == net.IPv4len {
+		return DNSTypeA
+	}
+	return DNSTypeAAAA
+}
+
+// matchAddrs addrs 中是否有地址与 ip 在同一前缀内
+func (c *spfCheck) matchAddrs(addrs []string, m *SPFMechanism) bool {
+	bits, prefix := 128, m.Prefix6
+	if len(c.ip) == net.IPv4len {
+		bits, prefix = 32, m.Prefix4
+	}
+	mask := net.CIDRMask(prefix, bits)
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		if len(ip) == len(c.ip) && ip.Mask(mask).Equal(c.ip.Mask(mask)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *spfCheck) match(ctx context.Context, domain string, m *SPFMechanism) (bool, SPFResult, error) {
+	switch m.Name {
+	case "all":
+		return true, "", nil
+	case "ip4", "ip6":
+		return len(c.ip) == len(m.IPNet.IP) && m.IPNet.Contains(c.ip), "", nil
+	}
+
+	if err := c.count(); err != nil {
+		return false, SPFPermError, err
+	}
+	target := domain
+	if m.Domain != "" {
+		var err error
+		if target, err = c.expand(m.Domain, domain); err != nil {
+			return false, SPFPermError, err
+		}
+	}
+
+	switch m.Name {
+	case "include":
+		result, err := c.checkHost(ctx, target)
+		switch result {
+		case SPFPass:
+			return true, "", nil
+		case SPFFail, SPFSoftFail, SPFNeutral:
+			return false, "", nil
+		case SPFTempError:
+			return false, SPFTempError, err
+		case SPFNone:
+			return false, SPFPermError, errors.WithMessage(ErrSPFSyntax, "include of domain without spf: "+target)
+		}
+		return false, SPFPermError, err
+	case "a":
+		addrs, result, err := c.query(ctx, target, c.addrType())
+		if err != nil {
+			return false, result, err
+		}
+		return c.matchAddrs(addrs, m), "", nil
+	case "mx":
+		mxs, result, err := c.query(ctx, target, DNSTypeMX)
+		if err != nil {
+			return false, result, err
+		}
+		if len(mxs) > maxSPFNames {
+			return false, SPFPermError, errors.WithMessage(ErrSPFLookupLimit, "too many mx records")
+		}
+		for _, mx := range mxs {
+			fields := strings.Fields(mx)
+			if len(fields) != 2 {
+				continue
+			}
+			addrs, result, err := c.query(ctx, fields[1], c.addrType())
+			if err != nil {
+				return false, result, err
+			}
+			if c.matchAddrs(addrs, m) {
+				return true, "", nil
+			}
+		}
+		return false, "", nil
+	case "ptr":
+		names, result, err := c.query(ctx, ReverseName(c.ip), DNSTypePTR)
+		if err != nil {
+			// ptr 查询失败视为不匹配
+			if result == SPFTempError {
+				return false, "", nil
+			}
+			return false, result, err
+		}
+		if len(names) > maxSPFNames {
+			names = names[:maxSPFNames]
+		}
+		target = strings.ToLower(target)
+		for _, name := range names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if name != target && !strings.HasSuffix(name, "."+target) {
+				continue
+			}
+			addrs, err := c.r.lookup(ctx, name, c.addrType())
+			if err != nil {
+				continue
+			}
+			if c.matchAddrs(addrs, &SPFMechanism{Prefix4: 32, Prefix6: 128}) {
+				return true, "", nil
+			}
+		}
+		return false, "", nil
+	case "exists":
+		addrs, result, err := c.query(ctx, target, DNSTypeA)
+		if err != nil {
+			return false, result, err
+		}
+		return len(addrs) > 0, "", nil
+	}
+	return false, SPFPermError, errors.WithMessage(ErrSPFSyntax, "unknown mechanism "+m.Name)
+}
+
+// expand 展开 domain-spec 中的宏 (RFC 7208 7), 结果超过 253 字节时从左侧截断 label
+func (c *spfCheck) expand(spec, domain string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			sb.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", errors.WithMessage(ErrSPFSyntax, "bad macro "+spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			sb.WriteByte('%')
+		case '_':
+			sb.WriteByte(' ')
+		case '-':
+			sb.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 2 {
+				return "", errors.WithMessage(ErrSPFSyntax, "bad macro "+spec)
+			}
+			value, err := c.macro(spec[i+1:i+end], domain)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(value)
+			i += end
+		default:
+			return "", errors.WithMessage(ErrSPFSyntax, "bad macro "+spec)
+		}
+	}
+	name := strings.TrimSuffix(sb.String(), ".")
+	for len(name) > 253 {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return name, nil
+}
+
+// macro 展开 {} 中的一个宏: 字母, 可选的保留数量, 可选的 r, 可选的分隔符
+func (c *spfCheck) macro(body, domain string) (string, error) {
+	letter := body[0]
+	var value string
+	switch letter | 0x20 {
+	case 's':
+		value = c.sender
+	case 'l':
+		value = c.local
+	case 'o':
+		value = c.senderDomain
+	case 'd':
+		value = domain
+	case 'i':
+		if len(c.ip) == net.IPv4len {
+			value = c.ip.String()
+		} else {
+			// ipv6 以点分隔的半字节表示
+			rev := strings.TrimSuffix(ReverseName(c.ip), ".ip6.arpa")
+			parts := strings.Split(rev, ".")
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+			value = strings.Join(parts, ".")
+		}
+	case 'p':
+		value = "unknown"
+	case 'v':
+		value = "ip6"
+		if len(c.ip) == net.IPv4len {
+			value = "in-addr"
+		}
+	case 'h':
+		value = c.helo
+	default:
+		return "", errors.WithMessage(ErrSPFSyntax, "bad macro letter "+string(letter))
+	}
+
+	rest := body[1:]
+	keep := 0
+	for len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' {
+		keep = keep*10 + int(rest[0]-'0')
+		rest = rest[1:]
+	}
+	reverse := false
+	if len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse = true
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", errors.WithMessage(ErrSPFSyntax, "bad macro delimiter "+rest)
+		}
+		delimiters = rest
+	}
+	if keep > 0 || reverse || delimiters != "." {
+		parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+		if reverse {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+	}
+	if letter >= 'A' && letter <= 'Z' {
+		value = strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	}
+	return value, nil
+}
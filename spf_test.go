@@ -0,0 +1,91 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// zoneResolver 按名字与类型从 zone 中应答, 名字不存在时返回 NXDOMAIN
+func zoneResolver(zone map[string][]*DNSResourceRecode) *Resolver {
+	return &Resolver{
+		Transport: RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			q := req.Questions[0]
+			resp := &DNSMessage{Header: &DNSHeader{TxID: req.Header.TxID, Flags: &DNSFlags{QR: 1}}}
+			records, ok := zone[q.QuestionName]
+			if !ok {
+				resp.Header.Flags.RCode = 3
+				return resp, nil
+			}
+			for _, rr := range records {
+				if rr.RRType == q.QuestionType {
+					resp.ResourceRecodes = append(resp.ResourceRecodes, rr)
+				}
+			}
+			resp.Header.AnswerRRs = uint16(len(resp.ResourceRecodes))
+			return resp, nil
+		}),
+	}
+}
+
+func txtRR(txt string) *DNSResourceRecode {
+	return &DNSResourceRecode{RRType: DNSTypeTXT, Class: DNSClassIn, Data: append([]byte{byte(len(txt))}, txt...)}
+}
+
+func TestCheckSPF(t *testing.T) {
+	r := zoneResolver(map[string][]*DNSResourceRecode{
+		"example.com": {
+			txtRR("v=spf1 ip4:192.0.2.0/24 include:_spf.example.net a:mail.example.com mx -all"),
+			{RRType: DNSTypeMX, Class: DNSClassIn, RData: "10 mx.example.com"},
+		},
+		"_spf.example.net":  {txtRR("v=spf1 ip6:2001:db8::/32 ~all")},
+		"mail.example.com":  {{RRType: DNSTypeA, Class: DNSClassIn, RData: "198.51.100.10"}},
+		"mx.example.com":    {{RRType: DNSTypeA, Class: DNSClassIn, RData: "203.0.113.5"}},
+		"redir.example.org": {txtRR("v=spf1 redirect=example.com")},
+		"loop.example.com":  {txtRR("v=spf1 include:loop.example.com -all")},
+		"two.example.com":   {txtRR("v=spf1 -all"), txtRR("v=spf1 +all")},
+	})
+	cases := []struct {
+		ip     string
+		domain string
+		want   SPFResult
+	}{
+		{"192.0.2.7", "example.com", SPFPass},
+		{"198.51.100.10", "example.com", SPFPass},
+		{"203.0.113.5", "example.com", SPFPass},
+		{"2001:db8::1", "example.com", SPFPass},
+		{"10.0.0.1", "example.com", SPFFail},
+		{"192.0.2.1", "redir.example.org", SPFPass},
+		{"10.0.0.1", "redir.example.org", SPFFail},
+		{"10.0.0.1", "loop.example.com", SPFPermError},
+		{"10.0.0.1", "two.example.com", SPFPermError},
+		{"10.0.0.1", "none.example.com", SPFNone},
+	}
+	for _, c := range cases {
+		got, err := r.CheckSPF(context.Background(), net.ParseIP(c.ip), c.domain, "user@"+c.domain)
+		if got != c.want {
+			t.Errorf("%s %s = %s (%v), want %s", c.ip, c.domain, got, err, c.want)
+		}
+	}
+}
+
+func TestSPFMacro(t *testing.T) {
+	c := &spfCheck{ip: net.ParseIP("192.0.2.3").To4(), sender: "strong-bad@email.example.com", local: "strong-bad", senderDomain: "email.example.com"}
+	cases := map[string]string{
+		"%{ir}.%{v}._spf.%{d2}": "3.2.0.192.in-addr._spf.example.com",
+		"%{l-}":                 "strong.bad",
+		"%{lr-}":                "bad.strong",
+		"%{l1r-}":               "strong",
+		"%{d4}":                 "email.example.com",
+		"%{o}%%%_":              "email.example.com% ",
+	}
+	for spec, want := range cases {
+		got, err := c.expand(spec, "email.example.com")
+		if err != nil || got != want {
+			t.Errorf("%s = %q (%v), want %q", spec, got, err, want)
+		}
+	}
+	if _, err := ParseSPF("v=spf1 a:example.com/33 -all"); err == nil {
+		t.Error("bad prefix accepted")
+	}
+}
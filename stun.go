@@ -0,0 +1,529 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	stunMagic       = 0x2112A442
+	stunHeaderLen   = 20
+	stunFingerprint = 0x5354554E
+	stunPort        = "3478"
+	stunTLSPort     = "5349"
+
+	STUNBindingRequest = 0x0001
+	STUNBindingSuccess = 0x0101
+	STUNBindingError   = 0x0111
+)
+
+// STUN attribute
+const (
+	STUNAttrMappedAddress    = 0x0001
+	STUNAttrChangeRequest    = 0x0003
+	STUNAttrErrorCode        = 0x0009
+	STUNAttrXORMappedAddress = 0x0020
+	STUNAttrSoftware         = 0x8022
+	STUNAttrResponseOrigin   = 0x802B
+	STUNAttrOtherAddress     = 0x802C
+	STUNAttrFingerprint      = 0x8028
+)
+
+// CHANGE-REQUEST 标志
+const (
+	stunChangeIP   = 0x04
+	stunChangePort = 0x02
+)
+
+var (
+	ErrSTUNShort          = errors.New("stun message too short")
+	ErrSTUNMagic          = errors.New("stun magic cookie mismatch")
+	ErrSTUNAttribute      = errors.New("malformed stun attribute")
+	ErrSTUNFingerprint    = errors.New("stun fingerprint mismatch")
+	ErrSTUNError          = errors.New("stun server returned error")
+	ErrSTUNNoMapped       = errors.New("stun response has no mapped address")
+	ErrSTUNNoOtherAddress = errors.New("stun server does not support rfc 5780")
+	ErrSTUNTimeout        = errors.New("no stun reply")
+	ErrSTUNNetwork        = errors.New("stun network must be udp, tcp or tls")
+)
+
+// STUNAttribute 按出现顺序保存
+type STUNAttribute struct {
+	Type  uint16
+	Value []byte
+}
+
+// STUNMessage RFC 5389 报文
+type STUNMessage struct {
+	Type          uint16
+	TransactionID [12]byte
+	Attributes    []*STUNAttribute
+}
+
+// NewSTUNMessage 生成带随机事务 ID 的报文
+func NewSTUNMessage(msgType uint16) *STUNMessage {
+	m := &STUNMessage{Type: msgType}
+	_, _ = rand.Read(m.TransactionID[:])
+	return m
+}
+
+// Attribute 返回第一个 typ 对应的属性值
+func (m *STUNMessage) Attribute(typ uint16) []byte {
+	for _, a := range m.Attributes {
+		if a.Type == typ {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// ToByte 编码报文并在末尾追加 FINGERPRINT
+func (m *STUNMessage) ToByte() ([]byte, error) {
+	var buffer bytes.Buffer
+	_ = binary.Write(&buffer, binary.BigEndian, m.Type)
+	_ = binary.Write(&buffer, binary.BigEndian, uint16(0))
+	_ = binary.Write(&buffer, binary.BigEndian, uint32(stunMagic))
+	buffer.Write(m.TransactionID[:])
+	for _, a := range m.Attributes {
+		if len(a.Value) > 0xFFFF {
+			return nil, ErrSTUNAttribute
+		}
+		_ = binary.Write(&buffer, binary.BigEndian, a.Type)
+		_ = binary.Write(&buffer, binary.BigEndian, uint16(len(a.Value)))
+		buffer.Write(a.Value)
+		// 属性按 4 字节对齐
+		for i := len(a.Value); i%4 != 0; i++ {
+			buffer.WriteByte(0)
+		}
+	}
+	b := buffer.Bytes()
+	// 计算 FINGERPRINT 时长度字段需要包含 FINGERPRINT 本身
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-stunHeaderLen+8))
+	fp := make([]byte, 8)
+	binary.BigEndian.PutUint16(fp[0:2], STUNAttrFingerprint)
+	binary.BigEndian.PutUint16(fp[2:4], 4)
+	binary.BigEndian.PutUint32(fp[4:8], crc32.ChecksumIEEE(b)^stunFingerprint)
+	return append(b, fp...), nil
+}
+
+// ParseSTUNMessage 解析 STUN 报文, 存在 FINGERPRINT 时会校验
+func ParseSTUNMessage(b []byte) (*STUNMessage, error) {
+	if len(b) < stunHeaderLen {
+		return nil, ErrSTUNShort
+	}
+	if binary.BigEndian.Uint32(b[4:8]) != stunMagic {
+		return nil, ErrSTUNMagic
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length%4 != 0 || stunHeaderLen+length > len(b) {
+		return nil, ErrSTUNShort
+	}
+	b = b[:stunHeaderLen+length]
+	m := &STUNMessage{Type: binary.BigEndian.Uint16(b[0:2])}
+	copy(m.TransactionID[:], b[8:20])
+	for i := stunHeaderLen; i < len(b); {
+		if i+4 > len(b) {
+			return nil, ErrSTUNAttribute
+		}
+		typ := binary.BigEndian.Uint16(b[i : i+2])
+		size := int(binary.BigEndian.Uint16(b[i+2 : i+4]))
+		if i+4+size > len(b) {
+			return nil, ErrSTUNAttribute
+		}
+		value := b[i+4 : i+4+size]
+		if typ == STUNAttrFingerprint {
+			if size != 4 || crc32.ChecksumIEEE(b[:i])^stunFingerprint != binary.BigEndian.Uint32(value) {
+				return nil, ErrSTUNFingerprint
+			}
+		}
+		m.Attributes = append(m.Attributes, &STUNAttribute{Type: typ, Value: append([]byte(nil), value...)})
+		i += 4 + (size+3)/4*4
+	}
+	return m, nil
+}
+
+// address 解析 MAPPED-ADDRESS 格式的属性, xor 为 true 时按 XOR-MAPPED-ADDRESS 处理
+func (m *STUNMessage) address(typ uint16, xor bool) (net.IP, int, error) {
+	v := m.Attribute(typ)
+	if v == nil {
+		return nil, 0, ErrSTUNNoMapped
+	}
+	if len(v) < 4 {
+		return nil, 0, ErrSTUNAttribute
+	}
+	var ip net.IP
+	switch v[1] {
+	case 0x01:
+		if len(v) != 8 {
+			return nil, 0, ErrSTUNAttribute
+		}
+		ip = net.IP(append([]byte(nil), v[4:8]...))
+	case 0x02:
+		if len(v) != 20 {
+			return nil, 0, ErrSTUNAttribute
+		}
+		ip = net.IP(append([]byte(nil), v[4:20]...))
+	default:
+		return nil, 0, ErrSTUNAttribute
+	}
+	port := int(binary.BigEndian.Uint16(v[2:4]))
+	if xor {
+		port ^= stunMagic >> 16
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint32(key[0:4], stunMagic)
+		copy(key[4:], m.TransactionID[:])
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip, port, nil
+}
+
+// MappedAddress 返回反射地址, 优先使用 XOR-MAPPED-ADDRESS
+func (m *STUNMessage) MappedAddress() (net.IP, int, error) {
+	if m.Attribute(STUNAttrXORMappedAddress) != nil {
+		return m.address(STUNAttrXORMappedAddress, true)
+	}
+	return m.address(STUNAttrMappedAddress, false)
+}
+
+// err 将 error 响应转换为 ErrSTUNError
+func (m *STUNMessage) err() error {
+	if m.Type != STUNBindingError {
+		return nil
+	}
+	v := m.Attribute(STUNAttrErrorCode)
+	if len(v) < 4 {
+		return ErrSTUNError
+	}
+	code := int(v[2]&0x07)*100 + int(v[3])
+	return errors.WithMessage(ErrSTUNError, strconv.Itoa(code)+" "+string(v[4:]))
+}
+
+// STUNOptions 零值字段使用默认值
+type STUNOptions struct {
+	Timeout time.Duration // 单次等待应答的时间, 默认 500ms, tcp/tls 为整个请求的超时
+	Retries int           // udp 重传次数, 默认 5
+
+	TLSConfig *tls.Config // network 为 tls 时使用, 为空时按 server 的主机名校验证书
+	Dialer    Dialer      // tcp/tls 使用, 为空时直接连接
+}
+
+func (o *STUNOptions) withDefaults() STUNOptions {
+	v := STUNOptions{}
+	if o != nil {
+		v = *o
+	}
+	if v.Timeout <= 0 {
+		v.Timeout = 500 * time.Millisecond
+	}
+	if v.Retries <= 0 {
+		v.Retries = 5
+	}
+	return v
+}
+
+// STUNResponse binding 请求结果
+type STUNResponse struct {
+	Server string
+	Local  net.Addr // 本地地址
+	Mapped net.Addr // NAT 外部看到的地址 (server reflexive address)
+
+	// 服务器支持 RFC 5780 时有效
+	OtherAddress   *net.UDPAddr
+	ResponseOrigin *net.UDPAddr
+
+	RTT     time.Duration
+	Message *STUNMessage
+}
+
+// STUNBinding 向 server 发送 binding 请求获取反射地址.
+// network 为 udp, tcp 或 tls, server 未指定端口时使用 3478 (tls 为 5349)
+func STUNBinding(ctx context.Context, network, server string, opts *STUNOptions) (*STUNResponse, error) {
+	o := opts.withDefaults()
+	port := stunPort
+	if network == "tls" {
+		port = stunTLSPort
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, port)
+	}
+
+	switch network {
+	case "udp", "udp4", "udp6":
+		raddr, err := net.ResolveUDPAddr(network, server)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP(network, nil)
+		if err != nil {
+			return nil, errors.WithMessage(err, "listen udp error")
+		}
+		defer conn.Close()
+		resp, err := stunTransact(ctx, conn, raddr, NewSTUNMessage(STUNBindingRequest), &o)
+		if err != nil {
+			return nil, err
+		}
+		resp.Server = server
+		return resp, nil
+	case "tcp", "tcp4", "tcp6", "tls":
+		return stunStream(ctx, network, server, &o)
+	}
+	return nil, errors.WithMessage(ErrSTUNNetwork, network)
+}
+
+// stunTransact 在 conn 上发送 req 到 dst 并按 RFC 5389 重传, 应答只按事务 ID 匹配, 不检查来源地址
+func stunTransact(ctx context.Context, conn net.PacketConn, dst *net.UDPAddr, req *STUNMessage, o *STUNOptions) (*STUNResponse, error) {
+	b, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < o.Retries; attempt++ {
+		start := time.Now()
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			return nil, errors.WithMessage(err, "write stun error")
+		}
+		deadline := start.Add(o.Timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			m, err := ParseSTUNMessage(buf[:n])
+			if err != nil || m.TransactionID != req.TransactionID {
+				continue
+			}
+			return newSTUNResponse(m, conn.LocalAddr(), time.Since(start), false)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrSTUNTimeout
+}
+
+func stunStream(ctx context.Context, network, server string, o *STUNOptions) (*STUNResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+	var dialer Dialer = &net.Dialer{}
+	if o.Dialer != nil {
+		dialer = o.Dialer
+	}
+	if network == "tls" {
+		dialer = WithTLS(o.TLSConfig)(dialer)
+		network = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	req := NewSTUNMessage(STUNBindingRequest)
+	b, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if _, err := conn.Write(b); err != nil {
+		return nil, errors.WithMessage(err, "write stun error")
+	}
+	// 流式传输时报文长度由首部给出
+	header := make([]byte, stunHeaderLen)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, errors.WithMessage(err, "read stun error")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, errors.WithMessage(err, "read stun error")
+	}
+	m, err := ParseSTUNMessage(append(header, body...))
+	if err != nil {
+		return nil, err
+	}
+	if m.TransactionID != req.TransactionID {
+		return nil, errors.WithMessage(ErrSTUNTimeout, "transaction id mismatch")
+	}
+	resp, err := newSTUNResponse(m, conn.LocalAddr(), time.Since(start), true)
+	if err != nil {
+		return nil, err
+	}
+	resp.Server = server
+	return resp, nil
+}
+
+func newSTUNResponse(m *STUNMessage, local net.Addr, rtt time.Duration, stream bool) (*STUNResponse, error) {
+	if err := m.err(); err != nil {
+		return nil, err
+	}
+	ip, port, err := m.MappedAddress()
+	if err != nil {
+		return nil, err
+	}
+	resp := &STUNResponse{Local: local, RTT: rtt, Message: m}
+	if stream {
+		resp.Mapped = &net.TCPAddr{IP: ip, Port: port}
+	} else {
+		resp.Mapped = &net.UDPAddr{IP: ip, Port: port}
+	}
+	if ip, port, err := m.address(STUNAttrOtherAddress, false); err == nil {
+		resp.OtherAddress = &net.UDPAddr{IP: ip, Port: port}
+	}
+	if ip, port, err := m.address(STUNAttrResponseOrigin, false); err == nil {
+		resp.ResponseOrigin = &net.UDPAddr{IP: ip, Port: port}
+	}
+	return resp, nil
+}
+
+// NATBehavior RFC 4787 中的映射或过滤行为
+type NATBehavior int
+
+const (
+	NATUnknown              NATBehavior = iota
+	NATEndpointIndependent              // 与目的地址无关
+	NATAddressDependent                 // 与目的 ip 有关
+	NATAddressPortDependent             // 与目的 ip 和端口都有关
+)
+
+func (b NATBehavior) String() string {
+	switch b {
+	case NATEndpointIndependent:
+		return "endpoint-independent"
+	case NATAddressDependent:
+		return "address-dependent"
+	case NATAddressPortDependent:
+		return "address-and-port-dependent"
+	}
+	return "unknown"
+}
+
+// NATType ClassifyNAT 的结果
+type NATType struct {
+	Local     net.Addr
+	Mapped    net.Addr
+	NoNAT     bool // 反射地址与本地地址相同
+	Mapping   NATBehavior
+	Filtering NATBehavior
+}
+
+// ClassifyNAT 按 RFC 5780 第 4.3, 4.4 节测试 NAT 的映射与过滤行为.
+// server 必须支持 OTHER-ADDRESS 与 CHANGE-REQUEST, 否则返回 ErrSTUNNoOtherAddress
+func ClassifyNAT(ctx context.Context, server string, opts *STUNOptions) (*NATType, error) {
+	o := opts.withDefaults()
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, stunPort)
+	}
+	primary, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen udp error")
+	}
+	defer conn.Close()
+
+	// test I: 获取反射地址与备用地址
+	first, err := stunTransact(ctx, conn, primary, NewSTUNMessage(STUNBindingRequest), &o)
+	if err != nil {
+		return nil, err
+	}
+	if first.OtherAddress == nil {
+		return nil, ErrSTUNNoOtherAddress
+	}
+	result := &NATType{Local: conn.LocalAddr(), Mapped: first.Mapped}
+	mapped := first.Mapped.(*net.UDPAddr)
+	if stunIsLocal(mapped, conn.LocalAddr().(*net.UDPAddr).Port) {
+		result.NoNAT = true
+		result.Mapping = NATEndpointIndependent
+	} else {
+		// test II: 发往备用 ip, 主端口
+		second, err := stunTransact(ctx, conn, &net.UDPAddr{IP: first.OtherAddress.IP, Port: primary.Port}, NewSTUNMessage(STUNBindingRequest), &o)
+		if err != nil {
+			return nil, errors.WithMessage(err, "mapping test II")
+		}
+		if stunSameAddr(second.Mapped, mapped) {
+			result.Mapping = NATEndpointIndependent
+		} else {
+			// test III: 发往备用 ip, 备用端口
+			third, err := stunTransact(ctx, conn, first.OtherAddress, NewSTUNMessage(STUNBindingRequest), &o)
+			if err != nil {
+				return nil, errors.WithMessage(err, "mapping test III")
+			}
+			if stunSameAddr(third.Mapped, second.Mapped.(*net.UDPAddr)) {
+				result.Mapping = NATAddressDependent
+			} else {
+				result.Mapping = NATAddressPortDependent
+			}
+		}
+	}
+
+	// 过滤测试收不到应答属于正常结果, 缩短重传次数
+	filter := o
+	filter.Retries = 2
+	changed := func(flags byte) bool {
+		req := NewSTUNMessage(STUNBindingRequest)
+		req.Attributes = append(req.Attributes, &STUNAttribute{Type: STUNAttrChangeRequest, Value: []byte{0, 0, 0, flags}})
+		_, err := stunTransact(ctx, conn, primary, req, &filter)
+		return err == nil
+	}
+	switch {
+	case changed(stunChangeIP | stunChangePort):
+		result.Filtering = NATEndpointIndependent
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case changed(stunChangePort):
+		result.Filtering = NATAddressDependent
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	default:
+		result.Filtering = NATAddressPortDependent
+	}
+	return result, nil
+}
+
+func stunSameAddr(a net.Addr, b *net.UDPAddr) bool {
+	u, ok := a.(*net.UDPAddr)
+	return ok && u.Port == b.Port && u.IP.Equal(b.IP)
+}
+
+// stunIsLocal 判断 addr 是否为本机网卡上的地址与端口
+func stunIsLocal(addr *net.UDPAddr, port int) bool {
+	if addr.Port != port {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// stunReflect 对每个请求回复来源地址, 用于测试
+func stunReflect(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req, err := ParseSTUNMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			v := make([]byte, 8)
+			v[1] = 0x01
+			binary.BigEndian.PutUint16(v[2:4], uint16(addr.Port)^stunMagic>>16)
+			binary.BigEndian.PutUint32(v[4:8], binary.BigEndian.Uint32(addr.IP.To4())^stunMagic)
+			resp := &STUNMessage{
+				Type:          STUNBindingSuccess,
+				TransactionID: req.TransactionID,
+				Attributes:    []*STUNAttribute{{Type: STUNAttrXORMappedAddress, Value: v}},
+			}
+			b, _ := resp.ToByte()
+			_, _ = conn.WriteToUDP(b, addr)
+		}
+	}()
+	return conn
+}
+
+func TestSTUNBinding(t *testing.T) {
+	server := stunReflect(t)
+	defer server.Close()
+
+	resp, err := STUNBinding(context.Background(), "udp4", server.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped := resp.Mapped.(*net.UDPAddr)
+	if !mapped.IP.Equal(net.IPv4(127, 0, 0, 1)) || mapped.Port != resp.Local.(*net.UDPAddr).Port {
+		t.Fatalf("unexpected mapped address %v, local %v", resp.Mapped, resp.Local)
+	}
+
+	// 篡改报文后 fingerprint 校验失败
+	b, err := NewSTUNMessage(STUNBindingRequest).ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[10] ^= 0xFF
+	if _, err := ParseSTUNMessage(b); err != ErrSTUNFingerprint {
+		t.Fatalf("expected fingerprint error, got %v", err)
+	}
+}
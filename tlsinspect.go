@@ -0,0 +1,286 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrStartTLS         = errors.New("starttls negotiation failed")
+	ErrStartTLSProtocol = errors.New("unsupported starttls protocol")
+)
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// TLSInspectOptions 零值字段使用默认值
+type TLSInspectOptions struct {
+	ServerName string         // SNI 与证书校验使用的名字, 默认取地址中的主机
+	StartTLS   string         // smtp, imap 或 pop3, 为空时直接握手
+	Timeout    time.Duration  // 连接与握手的总超时, 默认 10s
+	Dialer     Dialer         // 为空时直接连接
+	RootCAs    *x509.CertPool // 为空时使用系统根证书
+}
+
+// CertificateInfo 证书中常用的字段
+type CertificateInfo struct {
+	Subject            string
+	Issuer             string
+	SerialNumber       string
+	NotBefore          time.Time
+	NotAfter           time.Time
+	DNSNames           []string
+	IPAddresses        []net.IP
+	KeyAlgorithm       string // RSA, ECDSA 或 Ed25519
+	KeyBits            int
+	SignatureAlgorithm string
+	IsCA               bool
+	SHA256             string // DER 编码的 sha256, 十六进制
+	Certificate        *x509.Certificate
+}
+
+func newCertificateInfo(cert *x509.Certificate) *CertificateInfo {
+	sum := sha256.Sum256(cert.Raw)
+	info := &CertificateInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       cert.SerialNumber.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        cert.IPAddresses,
+		KeyAlgorithm:       cert.PublicKeyAlgorithm.String(),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		IsCA:               cert.IsCA,
+		SHA256:             hex.EncodeToString(sum[:]),
+		Certificate:        cert,
+	}
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		info.KeyBits = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.KeyBits = key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		info.KeyBits = 256
+	}
+	return info
+}
+
+// TLSReport 一次握手的检查结果
+type TLSReport struct {
+	Address     string
+	ServerName  string
+	Version     string
+	CipherSuite string
+	// Chain 服务端发送的证书, 第一个为叶子证书
+	Chain []*CertificateInfo
+	// VerifyError 为空时证书链可信且与 ServerName 匹配
+	VerifyError error
+	// NotAfter 链中最早的过期时间
+	NotAfter time.Time
+	// OCSPStapled 服务端是否装订了 OCSP 应答, 以下字段只在装订时有效
+	OCSPStapled    bool
+	OCSPStatus     string // good, revoked 或 unknown
+	OCSPNextUpdate time.Time
+	OCSPError      error // 应答无法解析或签名错误
+}
+
+// Verified 证书链是否通过校验
+func (r *TLSReport) Verified() bool {
+	return r.VerifyError == nil
+}
+
+// InspectTLS 连接 address (host:port) 完成握手并检查证书链. 证书不可信时仍返回结果, 原因见 VerifyError
+func InspectTLS(ctx context.Context, address string, opts *TLSInspectOptions) (*TLSReport, error) {
+	o := TLSInspectOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Dialer == nil {
+		o.Dialer = &net.Dialer{}
+	}
+	if o.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		o.ServerName = host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+	conn, err := o.Dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if o.StartTLS != "" {
+		if err := startTLS(conn, o.StartTLS); err != nil {
+			return nil, err
+		}
+	}
+
+	// 握手时不校验, 以便在证书不可信时也能返回证书链
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: o.ServerName, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, errors.WithMessage(err, "tls handshake error")
+	}
+	state := tlsConn.ConnectionState()
+	_ = tlsConn.Close()
+
+	report := &TLSReport{
+		Address:     address,
+		ServerName:  o.ServerName,
+		Version:     tlsVersionNames[state.Version],
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	if report.Version == "" {
+		report.Version = "0x" + strconv.FormatUint(uint64(state.Version), 16)
+	}
+	for _, cert := range state.PeerCertificates {
+		report.Chain = append(report.Chain, newCertificateInfo(cert))
+		if report.NotAfter.IsZero() || cert.NotAfter.Before(report.NotAfter) {
+			report.NotAfter = cert.NotAfter
+		}
+	}
+	report.VerifyError = verifyChain(state.PeerCertificates, o.ServerName, o.RootCAs)
+
+	if len(state.OCSPResponse) > 0 {
+		report.OCSPStapled = true
+		var issuer *x509.Certificate
+		if len(state.PeerCertificates) > 1 {
+			issuer = state.PeerCertificates[1]
+		}
+		resp, err := ocsp.ParseResponse(state.OCSPResponse, issuer)
+		if err != nil {
+			report.OCSPError = err
+		} else {
+			switch resp.Status {
+			case ocsp.Good:
+				report.OCSPStatus = "good"
+			case ocsp.Revoked:
+				report.OCSPStatus = "revoked"
+			default:
+				report.OCSPStatus = "unknown"
+			}
+			report.OCSPNextUpdate = resp.NextUpdate
+		}
+	}
+	return report, nil
+}
+
+func verifyChain(certs []*x509.Certificate, serverName string, roots *x509.CertPool) error {
+	if len(certs) == 0 {
+		return errors.New("no peer certificates")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// startTLS 在明文协议中协商升级为 tls
+func startTLS(conn net.Conn, protocol string) error {
+	r := bufio.NewReader(conn)
+	switch strings.ToLower(protocol) {
+	case "smtp":
+		if err := smtpReply(r, "220"); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte("EHLO netx\r\n")); err != nil {
+			return err
+		}
+		if err := smtpReply(r, "250"); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte("STARTTLS\r\n")); err != nil {
+			return err
+		}
+		return smtpReply(r, "220")
+	case "imap":
+		if err := expectLine(r, "* OK"); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte("a001 STARTTLS\r\n")); err != nil {
+			return err
+		}
+		// 跳过未标记的应答
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return errors.WithMessage(ErrStartTLS, err.Error())
+			}
+			if strings.HasPrefix(line, "a001 ") {
+				if !strings.HasPrefix(line, "a001 OK") {
+					return errors.WithMessage(ErrStartTLS, strings.TrimSpace(line))
+				}
+				return nil
+			}
+		}
+	case "pop3":
+		if err := expectLine(r, "+OK"); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte("STLS\r\n")); err != nil {
+			return err
+		}
+		return expectLine(r, "+OK")
+	}
+	return errors.WithMessage(ErrStartTLSProtocol, protocol)
+}
+
+// smtpReply 读取可能跨多行的应答并检查应答码
+func smtpReply(r *bufio.Reader, code string) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return errors.WithMessage(ErrStartTLS, err.Error())
+		}
+		if len(line) < 4 || line[:3] != code {
+			return errors.WithMessage(ErrStartTLS, strings.TrimSpace(line))
+		}
+		if line[3] != '-' {
+			return nil
+		}
+	}
+}
+
+func expectLine(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return errors.WithMessage(ErrStartTLS, err.Error())
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return errors.WithMessage(ErrStartTLS, strings.TrimSpace(line))
+	}
+	return nil
+}
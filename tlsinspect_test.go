@@ -0,0 +1,108 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedCert 生成 name 的自签名证书, names 中的 IP 地址放入 IPAddresses
+func selfSignedCert(t *testing.T, name string, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func TestInspectTLS(t *testing.T) {
+	cert := selfSignedCert(t, "mail.example.com")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				_, _ = conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+				_, _ = r.ReadString('\n')
+				_, _ = conn.Write([]byte("250-mail.example.com\r\n250 STARTTLS\r\n"))
+				_, _ = r.ReadString('\n')
+				_, _ = conn.Write([]byte("220 ready\r\n"))
+				_ = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	opts := &TLSInspectOptions{ServerName: "mail.example.com", StartTLS: "smtp", RootCAs: roots}
+	report, err := InspectTLS(context.Background(), ln.Addr().String(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Verified() {
+		t.Fatal(report.VerifyError)
+	}
+	if len(report.Chain) != 1 || report.Chain[0].KeyAlgorithm != "ECDSA" || report.Chain[0].KeyBits != 256 {
+		t.Fatalf("chain = %+v", report.Chain)
+	}
+	if len(report.Chain[0].DNSNames) != 1 || report.Chain[0].DNSNames[0] != "mail.example.com" {
+		t.Fatalf("sans = %v", report.Chain[0].DNSNames)
+	}
+	if report.OCSPStapled {
+		t.Fatal("unexpected ocsp staple")
+	}
+
+	// 名字不匹配时仍返回证书链
+	opts.ServerName = "www.example.com"
+	report, err = InspectTLS(context.Background(), ln.Addr().String(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified() || len(report.Chain) != 1 {
+		t.Fatalf("verify error = %v, chain = %d", report.VerifyError, len(report.Chain))
+	}
+}
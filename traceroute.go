@@ -0,0 +1,472 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrNotSupported  = errors.New("not supported on this platform")
+	ErrTraceIPv6     = errors.New("traceroute only supports ipv4 targets")
+	ErrNoAddress     = errors.New("no address found for host")
+	ErrProbeTimeout  = errors.New("probe timeout")
+	ErrUnknownMethod = errors.New("unknown trace mode")
+)
+
+type TraceMode int
+
+const (
+	TraceICMP TraceMode = iota // ICMP Echo
+	TraceUDP                   // UDP 到高位端口, 目标返回端口不可达
+	TraceTCP                   // TCP SYN (connect), 目标返回 SYN-ACK 或 RST
+)
+
+// TraceOptions 零值字段使用默认值. 发送探测需要 raw socket 权限 (root 或 CAP_NET_RAW)
+type TraceOptions struct {
+	Mode     TraceMode
+	MaxHops  int           // 默认 30
+	Probes   int           // 每跳探测次数, 默认 3
+	Timeout  time.Duration // 单次探测超时, 默认 2s
+	Port     int           // UDP 起始端口默认 33434, TCP 端口默认 80
+	Parallel int           // 同时探测的跳数, 默认 4
+
+	// Resolver 不为空时用于解析目标地址以及每跳地址的反向域名
+	Resolver *Resolver
+}
+
+// TraceProbe 单次探测结果, Err 不为空时其余字段无效
+type TraceProbe struct {
+	Addr net.IP
+	Name string
+	RTT  time.Duration
+	Err  error
+}
+
+type TraceHop struct {
+	TTL     int
+	Probes  []*TraceProbe
+	Reached bool // 该跳已到达目标
+}
+
+// Traceroute 探测到 host 的路径, 到达目标或达到 MaxHops 后返回
+func Traceroute(ctx context.Context, host string, opts *TraceOptions) ([]*TraceHop, error) {
+	o := TraceOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxHops <= 0 {
+		o.MaxHops = 30
+	}
+	if o.Probes <= 0 {
+		o.Probes = 3
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Parallel <= 0 {
+		o.Parallel = 4
+	}
+	if o.Port <= 0 {
+		o.Port = 33434
+		if o.Mode == TraceTCP {
+			o.Port = 80
+		}
+	}
+	if o.Mode != TraceICMP && o.Mode != TraceUDP && o.Mode != TraceTCP {
+		return nil, ErrUnknownMethod
+	}
+
+	dst, err := traceTarget(ctx, host, o.Resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTracer(&o, dst)
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+
+	var hops []*TraceHop
+	for ttl := 1; ttl <= o.MaxHops; ttl += o.Parallel {
+		batch := make([]*TraceHop, 0, o.Parallel)
+		var wg sync.WaitGroup
+		for i := ttl; i < ttl+o.Parallel && i <= o.MaxHops; i++ {
+			hop := &TraceHop{TTL: i, Probes: make([]*TraceProbe, o.Probes)}
+			batch = append(batch, hop)
+			for j := 0; j < o.Probes; j++ {
+				wg.Add(1)
+				go func(hop *TraceHop, j int) {
+					defer wg.Done()
+					hop.Probes[j] = t.probe(ctx, hop.TTL)
+				}(hop, j)
+			}
+		}
+		wg.Wait()
+
+		reached := false
+		for _, hop := range batch {
+			for _, p := range hop.Probes {
+				if p.Err == nil && p.Addr.Equal(dst) {
+					hop.Reached = true
+				}
+			}
+			hops = append(hops, hop)
+			if hop.Reached {
+				reached = true
+				break
+			}
+		}
+		if reached || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if o.Resolver != nil {
+		traceReverse(ctx, o.Resolver, hops)
+	}
+	return hops, ctx.Err()
+}
+
+func traceTarget(ctx context.Context, host string, resolver *Resolver) (net.IP, error) {
+	var addrs []string
+	var err error
+	if resolver != nil {
+		addrs, err = resolver.LookupHost(ctx, host)
+	} else {
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr).To4(); ip != nil {
+			return ip, nil
+		}
+	}
+	if len(addrs) > 0 {
+		return nil, ErrTraceIPv6
+	}
+	return nil, ErrNoAddress
+}
+
+func traceReverse(ctx context.Context, resolver *Resolver, hops []*TraceHop) {
+	names := map[string]string{}
+	for _, hop := range hops {
+		for _, p := range hop.Probes {
+			if p.Err != nil {
+				continue
+			}
+			ip := p.Addr.String()
+			name, ok := names[ip]
+			if !ok {
+				if result, err := resolver.LookupAddr(ctx, ip); err == nil && len(result) > 0 {
+					name = result[0]
+				}
+				names[ip] = name
+			}
+			p.Name = name
+		}
+	}
+}
+
+type traceReply struct {
+	addr    net.IP
+	reached bool
+}
+
+// tracer 在一个 raw ICMP socket 上接收所有探测的回应, 按 key 分发
+type tracer struct {
+	opts *TraceOptions
+	dst  net.IP
+	conn *icmp.PacketConn
+	id   int
+
+	mu      sync.Mutex
+	seq     int
+	waiters map[int]chan traceReply
+}
+
+func newTracer(opts *TraceOptions, dst net.IP) (*tracer, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen icmp error")
+	}
+	t := &tracer{
+		opts:    opts,
+		dst:     dst,
+		conn:    conn,
+		id:      os.Getpid() & 0xFFFF,
+		waiters: map[int]chan traceReply{},
+	}
+	go t.read()
+	return t, nil
+}
+
+func (t *tracer) close() {
+	_ = t.conn.Close()
+}
+
+func (t *tracer) register(key int) chan traceReply {
+	ch := make(chan traceReply, 1)
+	t.add(key, ch)
+	return ch
+}
+
+func (t *tracer) add(key int, ch chan traceReply) {
+	t.mu.Lock()
+	t.waiters[key] = ch
+	t.mu.Unlock()
+}
+
+func (t *tracer) unregister(key int) {
+	t.mu.Lock()
+	delete(t.waiters, key)
+	t.mu.Unlock()
+}
+
+func (t *tracer) read() {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil {
+			continue
+		}
+		addr := net.ParseIP(peer.String()).To4()
+		key, reply, ok := t.match(msg, addr)
+		if !ok {
+			continue
+		}
+		t.mu.Lock()
+		ch := t.waiters[key]
+		t.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- reply:
+			default:
+			}
+		}
+	}
+}
+
+// match 根据回应找到对应的探测 key
+func (t *tracer) match(msg *icmp.Message, addr net.IP) (int, traceReply, bool) {
+	var inner []byte
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if t.opts.Mode != TraceICMP || msg.Type != ipv4.ICMPTypeEchoReply || body.ID != t.id {
+			return 0, traceReply{}, false
+		}
+		return body.Seq, traceReply{addr: addr, reached: true}, true
+	case *icmp.TimeExceeded:
+		inner = body.Data
+	case *icmp.DstUnreach:
+		inner = body.Data
+	default:
+		return 0, traceReply{}, false
+	}
+
+	// 内部是原始 IP 头加上至少 8 字节的上层协议头
+	if len(inner) < 20 {
+		return 0, traceReply{}, false
+	}
+	ihl := int(inner[0]&0x0F) * 4
+	if len(inner) < ihl+8 || !net.IP(inner[16:20]).Equal(t.dst) {
+		return 0, traceReply{}, false
+	}
+	header := inner[ihl:]
+	reply := traceReply{addr: addr, reached: addr.Equal(t.dst)}
+	switch t.opts.Mode {
+	case TraceICMP:
+		if int(binary.BigEndian.Uint16(header[4:6])) != t.id {
+			return 0, traceReply{}, false
+		}
+		return int(binary.BigEndian.Uint16(header[6:8])), reply, true
+	default:
+		// UDP 与 TCP 都以源端口区分探测
+		return int(binary.BigEndian.Uint16(header[0:2])), reply, true
+	}
+}
+
+func (t *tracer) probe(ctx context.Context, ttl int) *TraceProbe {
+	ctx, cancel := context.WithTimeout(ctx, t.opts.Timeout)
+	defer cancel()
+	switch t.opts.Mode {
+	case TraceUDP:
+		return t.probeUDP(ctx, ttl)
+	case TraceTCP:
+		return t.probeTCP(ctx, ttl)
+	default:
+		return t.probeICMP(ctx, ttl)
+	}
+}
+
+func (t *tracer) wait(ctx context.Context, ch chan traceReply, start time.Time) *TraceProbe {
+	select {
+	case reply := <-ch:
+		return &TraceProbe{Addr: reply.addr, RTT: time.Since(start)}
+	case <-ctx.Done():
+		return &TraceProbe{Err: ErrProbeTimeout}
+	}
+}
+
+func (t *tracer) probeICMP(ctx context.Context, ttl int) *TraceProbe {
+	t.mu.Lock()
+	t.seq = (t.seq + 1) & 0xFFFF
+	seq := t.seq
+	t.mu.Unlock()
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: t.id, Seq: seq, Data: []byte("netx-traceroute")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return &TraceProbe{Err: err}
+	}
+	ch := t.register(seq)
+	defer t.unregister(seq)
+
+	// TTL 是 socket 级别的设置, 并发探测时需要串行化设置与发送
+	t.mu.Lock()
+	start := time.Now()
+	err = t.conn.IPv4PacketConn().SetTTL(ttl)
+	if err == nil {
+		_, err = t.conn.WriteTo(b, &net.IPAddr{IP: t.dst})
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return &TraceProbe{Err: err}
+	}
+	return t.wait(ctx, ch, start)
+}
+
+func (t *tracer) probeUDP(ctx context.Context, ttl int) *TraceProbe {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return &TraceProbe{Err: err}
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	ch := t.register(port)
+	defer t.unregister(port)
+
+	if err := ipv4.NewPacketConn(conn).SetTTL(ttl); err != nil {
+		return &TraceProbe{Err: err}
+	}
+	start := time.Now()
+	if _, err := conn.WriteTo([]byte("netx-traceroute"), &net.UDPAddr{IP: t.dst, Port: t.opts.Port + ttl - 1}); err != nil {
+		return &TraceProbe{Err: err}
+	}
+	return t.wait(ctx, ch, start)
+}
+
+// tcpProbeRetries 预留的本地端口在 dial 之前被占用时重试的次数
+const tcpProbeRetries = 3
+
+func (t *tracer) probeTCP(ctx context.Context, ttl int) *TraceProbe {
+	for i := 0; ; i++ {
+		p := t.probeTCPOnce(ctx, ttl)
+		if i >= tcpProbeRetries || !addrInUse(p.Err) {
+			return p
+		}
+	}
+}
+
+// probeTCPOnce 在 connect 之前绑定系统分配的本地端口并登记, 以便从 ICMP 回应中识别该探测.
+// 不能预先绑定的平台先预留一个端口, 它在关闭后可能被其它 socket 占用, 此时 dial 失败并由 probeTCP 重试
+func (t *tracer) probeTCPOnce(ctx context.Context, ttl int) *TraceProbe {
+	ch := make(chan traceReply, 1)
+	var mu sync.Mutex
+	port, finished := 0, false
+	defer func() {
+		mu.Lock()
+		finished = true
+		if port != 0 {
+			t.unregister(port)
+		}
+		mu.Unlock()
+	}()
+
+	var dialer net.Dialer
+	if !bindEphemeralSupported {
+		l, err := net.Listen("tcp4", ":0")
+		if err != nil {
+			return &TraceProbe{Err: err}
+		}
+		port = l.Addr().(*net.TCPAddr).Port
+		_ = l.Close()
+		t.add(port, ch)
+		dialer.LocalAddr = &net.TCPAddr{Port: port}
+	}
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		bound := 0
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			if serr = setSockoptTTL(fd, ttl); serr == nil && bindEphemeralSupported {
+				bound, serr = bindEphemeralPort(fd)
+			}
+		}); err != nil {
+			return err
+		}
+		if serr != nil || !bindEphemeralSupported {
+			return serr
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// 探测已经超时返回, 不再登记
+		if finished {
+			return ErrProbeTimeout
+		}
+		port = bound
+		t.add(port, ch)
+		return nil
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := dialer.DialContext(ctx, "tcp4", net.JoinHostPort(t.dst.String(), strconv.Itoa(t.opts.Port)))
+		if err == nil {
+			_ = conn.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case reply := <-ch:
+		return &TraceProbe{Addr: reply.addr, RTT: time.Since(start)}
+	case err := <-done:
+		// 建立连接或被 RST 拒绝都说明到达了目标
+		if err == nil || connRefused(err) {
+			return &TraceProbe{Addr: t.dst, RTT: time.Since(start)}
+		}
+		// 没有发出 SYN 的错误不会有 ICMP 回应
+		mu.Lock()
+		registered := port != 0
+		mu.Unlock()
+		if !registered || addrInUse(err) {
+			return &TraceProbe{Err: err}
+		}
+		select {
+		case reply := <-ch:
+			return &TraceProbe{Addr: reply.addr, RTT: time.Since(start)}
+		case <-ctx.Done():
+			return &TraceProbe{Err: ErrProbeTimeout}
+		}
+	case <-ctx.Done():
+		return &TraceProbe{Err: ErrProbeTimeout}
+	}
+}
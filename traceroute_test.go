@@ -0,0 +1,142 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// traceInner 构造 ICMP 差错报文中引用的原始数据报: ihl 字节的 IPv4 头加上 8 字节的上层协议头
+func traceInner(dst net.IP, ihl int, header []byte) []byte {
+	b := make([]byte, ihl, ihl+len(header))
+	b[0] = 4<<4 | byte(ihl/4)
+	copy(b[16:20], dst.To4())
+	return append(b, header...)
+}
+
+// echoHeader ICMP Echo 请求的前 8 字节
+func echoHeader(id, seq int) []byte {
+	b := []byte{8, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(b[4:6], uint16(id))
+	binary.BigEndian.PutUint16(b[6:8], uint16(seq))
+	return b
+}
+
+// portHeader UDP 或 TCP 头的前 8 字节, 只填端口
+func portHeader(src, dst int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b[0:2], uint16(src))
+	binary.BigEndian.PutUint16(b[2:4], uint16(dst))
+	return b
+}
+
+func TestTracerMatch(t *testing.T) {
+	dst := net.IPv4(192, 0, 2, 9).To4()
+	router := net.IPv4(198, 51, 100, 1).To4()
+	other := net.IPv4(203, 0, 113, 1)
+	exceeded := func(inner []byte) *icmp.Message {
+		return &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: inner}}
+	}
+	unreach := func(inner []byte) *icmp.Message {
+		return &icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: inner}}
+	}
+	cases := []struct {
+		name    string
+		mode    TraceMode
+		msg     *icmp.Message
+		addr    net.IP
+		ok      bool
+		key     int
+		reached bool
+	}{
+		{"icmp echo reply", TraceICMP, &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: 7}}, dst, true, 7, true},
+		{"icmp echo reply other id", TraceICMP, &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x4321, Seq: 7}}, dst, false, 0, false},
+		{"icmp echo request", TraceICMP, &icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 0x1234, Seq: 7}}, dst, false, 0, false},
+		{"icmp time exceeded", TraceICMP, exceeded(traceInner(dst, 20, echoHeader(0x1234, 9))), router, true, 9, false},
+		{"icmp ip options", TraceICMP, exceeded(traceInner(dst, 24, echoHeader(0x1234, 10))), router, true, 10, false},
+		{"icmp time exceeded other id", TraceICMP, exceeded(traceInner(dst, 20, echoHeader(0x4321, 9))), router, false, 0, false},
+		{"icmp other destination", TraceICMP, exceeded(traceInner(other, 20, echoHeader(0x1234, 9))), router, false, 0, false},
+		{"icmp truncated", TraceICMP, exceeded(traceInner(dst, 20, echoHeader(0x1234, 9))[:24]), router, false, 0, false},
+		{"udp time exceeded", TraceUDP, exceeded(traceInner(dst, 20, portHeader(40000, 33434))), router, true, 40000, false},
+		{"udp port unreachable", TraceUDP, unreach(traceInner(dst, 20, portHeader(40001, 33435))), dst, true, 40001, true},
+		{"udp echo reply", TraceUDP, &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: 7}}, dst, false, 0, false},
+		{"udp other destination", TraceUDP, unreach(traceInner(other, 20, portHeader(40001, 33435))), router, false, 0, false},
+		{"tcp time exceeded", TraceTCP, exceeded(traceInner(dst, 20, portHeader(50000, 80))), router, true, 50000, false},
+		{"tcp filtered", TraceTCP, unreach(traceInner(dst, 20, portHeader(50001, 80))), router, true, 50001, false},
+		{"tcp packet too big", TraceTCP, &icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Body: &icmp.PacketTooBig{MTU: 1280}}, router, false, 0, false},
+	}
+	for _, c := range cases {
+		tr := &tracer{opts: &TraceOptions{Mode: c.mode}, dst: dst, id: 0x1234}
+		key, reply, ok := tr.match(c.msg, c.addr)
+		if ok != c.ok || key != c.key {
+			t.Fatalf("%s: key %d, ok %v", c.name, key, ok)
+		}
+		if ok && (!reply.addr.Equal(c.addr) || reply.reached != c.reached) {
+			t.Fatalf("%s: reply %+v", c.name, reply)
+		}
+	}
+}
+
+func TestTracerProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	dst := net.IPv4(127, 0, 0, 1).To4()
+	tr := &tracer{opts: &TraceOptions{Mode: TraceTCP, Port: ln.Addr().(*net.TCPAddr).Port, Timeout: 2 * time.Second}, dst: dst, waiters: map[int]chan traceReply{}}
+	// 并发的探测各自使用系统分配的端口, 不会因为端口被占用而失败
+	var wg sync.WaitGroup
+	probes := make([]*TraceProbe, 32)
+	for i := range probes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			probes[i] = tr.probe(context.Background(), 64)
+		}(i)
+	}
+	wg.Wait()
+	for i, p := range probes {
+		if p.Err != nil || !p.Addr.Equal(dst) {
+			t.Fatalf("probe %d: %+v", i, p)
+		}
+	}
+	if len(tr.waiters) != 0 {
+		t.Fatalf("waiters left: %v", tr.waiters)
+	}
+	if !bindEphemeralSupported {
+		return
+	}
+	// 登记的端口就是连接实际使用的端口
+	bound := 0
+	dialer := net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) { bound, serr = bindEphemeralPort(fd) }); err != nil {
+			return err
+		}
+		return serr
+	}}
+	conn, err := dialer.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if port := conn.LocalAddr().(*net.TCPAddr).Port; bound == 0 || port != bound {
+		t.Fatalf("bound %d, local port %d", bound, port)
+	}
+}
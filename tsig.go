@@ -0,0 +1,237 @@
+package netx
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTSIGKey       = errors.New("invalid tsig key")
+	ErrTSIGAlgorithm = errors.New("unsupported tsig algorithm")
+	ErrTSIGMissing   = errors.New("message has no tsig record")
+	ErrTSIGBadSig    = errors.New("tsig signature mismatch")
+	ErrTSIGBadTime   = errors.New("tsig time outside the fudge window")
+	ErrTSIGRejected  = errors.New("peer rejected tsig")
+)
+
+const (
+	// defaultTSIGFudge RFC 8945 6.5 建议的时间误差
+	defaultTSIGFudge = 300 * time.Second
+	// DefaultTSIGAlgorithm 与 nsupdate 和 tsig-keygen 的默认值相同
+	DefaultTSIGAlgorithm = "hmac-sha256"
+)
+
+// tsigHashes RFC 8945 6 的算法名字
+var tsigHashes = map[string]func() hash.Hash{
+	"hmac-md5.sig-alg.reg.int": md5.New,
+	"hmac-sha1":                sha1.New,
+	"hmac-sha224":              sha256.New224,
+	"hmac-sha256":              sha256.New,
+	"hmac-sha384":              sha512.New384,
+	"hmac-sha512":              sha512.New,
+}
+
+// TSIGKey 事务签名 (TSIG, RFC 8945) 的共享密钥, 服务器与客户端使用相同的 Name, Algorithm 与 Secret
+type TSIGKey struct {
+	Name      string
+	Algorithm string // 为空时使用 DefaultTSIGAlgorithm, hmac-md5 可以简写
+	Secret    []byte
+	Fudge     time.Duration // 允许的时间误差, 默认 300s
+}
+
+// ParseTSIGKey 解析 nsupdate -y 的格式 [算法:]名字:base64 密钥
+func ParseTSIGKey(s string) (*TSIGKey, error) {
+	parts := strings.Split(s, ":")
+	k := &TSIGKey{}
+	switch len(parts) {
+	case 2:
+		k.Name = parts[0]
+	case 3:
+		k.Algorithm, k.Name = parts[0], parts[1]
+	default:
+		return nil, errors.WithMessage(ErrTSIGKey, "want [algorithm:]name:secret")
+	}
+	secret, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil || len(secret) == 0 || k.Name == "" {
+		return nil, errors.WithMessage(ErrTSIGKey, "bad name or base64 secret")
+	}
+	k.Secret = secret
+	if _, err := k.hash(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// algorithm 返回规范的算法名字, 不带末尾的点
+func (k *TSIGKey) algorithm() string {
+	alg := normalizeDomain(k.Algorithm)
+	switch alg {
+	case "":
+		return DefaultTSIGAlgorithm
+	case "hmac-md5":
+		return "hmac-md5.sig-alg.reg.int"
+	}
+	return alg
+}
+
+func (k *TSIGKey) hash() (func() hash.Hash, error) {
+	h, ok := tsigHashes[k.algorithm()]
+	if !ok {
+		return nil, errors.WithMessage(ErrTSIGAlgorithm, k.Algorithm)
+	}
+	return h, nil
+}
+
+func (k *TSIGKey) fudge() uint16 {
+	if k.Fudge <= 0 {
+		return uint16(defaultTSIGFudge / time.Second)
+	}
+	if k.Fudge/time.Second > 0xFFFF {
+		return 0xFFFF
+	}
+	return uint16(k.Fudge / time.Second)
+}
+
+// tsigVariables TSIG 记录中参与计算的字段, RFC 8945 4.3.3
+type tsigVariables struct {
+	signed uint64 // 48 位的秒数
+	fudge  uint16
+	err    uint16
+	other  []byte
+}
+
+// mac 计算 packet (不含 TSIG 记录, ID 为原始 ID) 的 MAC. requestMAC 不为空时为响应, 先加入请求的 MAC
+func (k *TSIGKey) mac(packet, requestMAC []byte, v tsigVariables) ([]byte, error) {
+	h, err := k.hash()
+	if err != nil {
+		return nil, err
+	}
+	name, err := appendName(nil, normalizeDomain(k.Name))
+	if err != nil {
+		return nil, errors.WithMessage(ErrTSIGKey, err.Error())
+	}
+	alg, _ := appendName(nil, k.algorithm())
+	m := hmac.New(h, k.Secret)
+	if requestMAC != nil {
+		m.Write(appendUint16(nil, uint16(len(requestMAC))))
+		m.Write(requestMAC)
+	}
+	m.Write(packet)
+	b := appendUint32(appendUint16(name, DNSClassAny), 0)
+	b = append(b, alg...)
+	b = appendUint16(appendUint32(b, uint32(v.signed>>16)), uint16(v.signed))
+	b = appendUint16(appendUint16(b, v.fudge), v.err)
+	b = append(appendUint16(b, uint16(len(v.other))), v.other...)
+	m.Write(b)
+	return m.Sum(nil), nil
+}
+
+// Sign 在 packet 末尾加上 TSIG 记录并增加 ARCOUNT, 返回签名后的报文与其中的 MAC.
+// 签名响应时 requestMAC 为请求中的 MAC, 签名请求时为空
+func (k *TSIGKey) Sign(packet, requestMAC []byte, now time.Time) (signed, mac []byte, err error) {
+	if len(packet) < 12 {
+		return nil, nil, ErrShortBuffer
+	}
+	v := tsigVariables{signed: uint64(now.Unix()) & (1<<48 - 1), fudge: k.fudge()}
+	if mac, err = k.mac(packet, requestMAC, v); err != nil {
+		return nil, nil, err
+	}
+	name, _ := appendName(nil, normalizeDomain(k.Name))
+	alg, _ := appendName(nil, k.algorithm())
+	rdata := append(alg, byte(v.signed>>40), byte(v.signed>>32))
+	rdata = appendUint16(appendUint32(rdata, uint32(v.signed)), v.fudge)
+	rdata = append(appendUint16(rdata, uint16(len(mac))), mac...)
+	// 原始 ID, 错误与其它数据
+	rdata = appendUint16(appendUint16(appendUint16(rdata, binary.BigEndian.Uint16(packet)), 0), 0)
+
+	signed = make([]byte, 0, len(packet)+len(name)+10+len(rdata))
+	signed = append(signed, packet...)
+	signed = appendUint32(appendUint16(appendUint16(append(signed, name...), DNSTypeTSIG), DNSClassAny), 0)
+	signed = append(appendUint16(signed, uint16(len(rdata))), rdata...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, mac, nil
+}
+
+// Verify 验证 packet 最后的 TSIG 记录, 返回其中的 MAC, 用于签名对应的响应.
+// 验证响应时 requestMAC 为请求中的 MAC. 对方在 TSIG 中报告错误 (例如 BADKEY) 时返回 ErrTSIGRejected
+func (k *TSIGKey) Verify(packet, requestMAC []byte, now time.Time) ([]byte, error) {
+	p, err := NewDNSParser(packet)
+	if err != nil {
+		return nil, err
+	}
+	var start int
+	var h DNSRecordHeader
+	var rdata []byte
+	for {
+		rh, err := p.NextRecordHeader()
+		if err == ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h, start = rh, p.start
+		if rdata, err = p.RData(); err != nil {
+			return nil, err
+		}
+	}
+	if h.RRType != DNSTypeTSIG || h.Section != DNSSectionAdditional {
+		return nil, ErrTSIGMissing
+	}
+	name, _ := h.Name()
+	u := &unpacker{msg: rdata}
+	alg, _, err := u.name()
+	if err != nil {
+		return nil, errors.WithMessage(ErrBadRData, "tsig algorithm")
+	}
+	fixed, err := u.next(10)
+	if err != nil {
+		return nil, errors.WithMessage(ErrBadRData, "tsig")
+	}
+	v := tsigVariables{
+		signed: uint64(binary.BigEndian.Uint16(fixed))<<32 | uint64(binary.BigEndian.Uint32(fixed[2:])),
+		fudge:  binary.BigEndian.Uint16(fixed[6:]),
+	}
+	mac, err := u.next(int(binary.BigEndian.Uint16(fixed[8:])))
+	if err != nil {
+		return nil, errors.WithMessage(ErrBadRData, "tsig mac")
+	}
+	tail, err := u.next(6)
+	if err != nil {
+		return nil, errors.WithMessage(ErrBadRData, "tsig")
+	}
+	v.err = binary.BigEndian.Uint16(tail[2:])
+	if v.other, err = u.next(int(binary.BigEndian.Uint16(tail[4:]))); err != nil {
+		return nil, errors.WithMessage(ErrBadRData, "tsig other data")
+	}
+	if v.err != 0 {
+		return nil, errors.WithMessage(ErrTSIGRejected, DNSRCode(v.err).String())
+	}
+	if normalizeDomain(name) != normalizeDomain(k.Name) || normalizeDomain(alg) != k.algorithm() {
+		return nil, errors.WithMessage(ErrTSIGKey, "signed with "+name+" "+alg)
+	}
+	// 去掉 TSIG 记录, 恢复原始 ID 与 ARCOUNT 之后计算
+	msg := append([]byte{}, packet[:start]...)
+	copy(msg, tail[:2])
+	binary.BigEndian.PutUint16(msg[10:], binary.BigEndian.Uint16(msg[10:])-1)
+	want, err := k.mac(msg, requestMAC, v)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, want) {
+		return nil, ErrTSIGBadSig
+	}
+	if d := now.Unix() - int64(v.signed); d > int64(v.fudge) || -d > int64(v.fudge) {
+		return nil, ErrTSIGBadTime
+	}
+	return append([]byte{}, mac...), nil
+}
@@ -0,0 +1,85 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestTSIG(t *testing.T) {
+	key, err := ParseTSIGKey("hmac-sha256:Update-Key.:c2VjcmV0LXNlY3JldC1zZWNyZXQ=")
+	if err != nil || key.Name != "Update-Key." || string(key.Secret) != "secret-secret-secret" {
+		t.Fatalf("ParseTSIGKey = %+v, %v", key, err)
+	}
+	for _, bad := range []string{"key", "hmac-sha256:key:!!", "hmac-foo:key:c2VjcmV0"} {
+		if _, err := ParseTSIGKey(bad); err == nil {
+			t.Fatalf("ParseTSIGKey(%q) accepted", bad)
+		}
+	}
+	now := time.Unix(1700000000, 0)
+	u := NewUpdate("example.com")
+	u.Add(&DNSResourceRecode{Name: "www.example.com", RRType: DNSTypeA, TTL: 300, RData: "192.0.2.1"})
+	packet, err := u.Message().ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, mac, err := key.Sign(packet, nil, now)
+	if err != nil || len(mac) != 32 {
+		t.Fatalf("Sign: %v", err)
+	}
+	// 服务器用同一个密钥验证, 名字不区分大小写
+	server := &TSIGKey{Name: "update-key", Secret: key.Secret}
+	if got, err := server.Verify(signed, nil, now.Add(time.Minute)); err != nil || string(got) != string(mac) {
+		t.Fatalf("Verify: %v", err)
+	}
+	msg, err := Unpack(signed)
+	if err != nil || len(msg.Additionals()) != 1 || msg.Additionals()[0].RRType != DNSTypeTSIG {
+		t.Fatalf("signed message: %v", err)
+	}
+
+	// 响应的签名包含请求的 MAC
+	resp := NewReply(msg)
+	resp.ResourceRecodes, resp.Header.AdditionalRRs = nil, 0
+	packet, _ = resp.ToByte()
+	signedResp, _, err := server.Sign(packet, mac, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := key.Verify(signedResp, mac, now); err != nil {
+		t.Fatalf("Verify response: %v", err)
+	}
+	if _, err := key.Verify(signedResp, nil, now); !errors.Is(err, ErrTSIGBadSig) {
+		t.Fatalf("response without request mac: %v", err)
+	}
+
+	tampered := append([]byte{}, signed...)
+	tampered[len(packet)-1] ^= 1
+	for name, c := range map[string]struct {
+		packet []byte
+		key    *TSIGKey
+		now    time.Time
+		want   error
+	}{
+		"tampered":  {tampered, key, now, ErrTSIGBadSig},
+		"secret":    {signed, &TSIGKey{Name: key.Name, Secret: []byte("other")}, now, ErrTSIGBadSig},
+		"key name":  {signed, &TSIGKey{Name: "other", Secret: key.Secret}, now, ErrTSIGKey},
+		"algorithm": {signed, &TSIGKey{Name: key.Name, Algorithm: "hmac-sha512", Secret: key.Secret}, now, ErrTSIGKey},
+		"time":      {signed, key, now.Add(10 * time.Minute), ErrTSIGBadTime},
+		"unsigned":  {packet, key, now, ErrTSIGMissing},
+	} {
+		if _, err := c.key.Verify(c.packet, nil, c.now); !errors.Is(err, c.want) {
+			t.Fatalf("%s: %v, want %v", name, err, c.want)
+		}
+	}
+
+	for _, alg := range []string{"hmac-md5", "hmac-sha1", "hmac-sha224", "hmac-sha384", "hmac-sha512."} {
+		k := &TSIGKey{Name: "k", Algorithm: alg, Secret: []byte("s")}
+		signed, _, err := k.Sign(packet, nil, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := k.Verify(signed, nil, now); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package netx
+
+import (
+	"context"
+	"time"
+)
+
+// TTLRange TTL 的上下限, 为 0 表示不限制该方向
+type TTLRange struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// clamp 返回限制后的 ttl (秒)
+func (r TTLRange) clamp(ttl uint32) uint32 {
+	if min := uint32(r.Min / time.Second); ttl < min {
+		ttl = min
+	}
+	if max := uint32(r.Max / time.Second); max > 0 && ttl > max {
+		ttl = max
+	}
+	return ttl
+}
+
+// TTLPolicy 改写响应中记录的 TTL, 例如避免下游缓存保存记录数天, 或者让故障切换更快生效.
+// 默认使用 Default, 问题名字在 Override 设置的域名之下时使用最长匹配的范围. 零值不修改 TTL.
+// 设置完成后可以并发使用
+type TTLPolicy struct {
+	Default TTLRange
+	domains map[string]TTLRange
+}
+
+// Override 为 domain 及其下的名字设置单独的范围, 替换 Default 而不是与之叠加
+func (p *TTLPolicy) Override(domain string, r TTLRange) {
+	if p.domains == nil {
+		p.domains = map[string]TTLRange{}
+	}
+	p.domains[normalizeDomain(domain)] = r
+}
+
+// Range 返回 name 使用的范围
+func (p *TTLPolicy) Range(name string) TTLRange {
+	if len(p.domains) != 0 {
+		for n := normalizeDomain(name); ; n = parentName(n) {
+			if r, ok := p.domains[n]; ok {
+				return r
+			}
+			if n == "" {
+				break
+			}
+		}
+	}
+	return p.Default
+}
+
+// Apply 按问题名字的范围修改 msg 中所有记录的 TTL, 包括否定应答中的 SOA. OPT 的 TTL 字段不是 TTL, 保持不变.
+// RRSIG 中的原始 TTL 不受影响, 因此签名仍然可以验证
+func (p *TTLPolicy) Apply(msg *DNSMessage) {
+	if len(msg.Questions) == 0 {
+		return
+	}
+	r := p.Range(msg.Questions[0].QuestionName)
+	if r.Min <= 0 && r.Max <= 0 {
+		return
+	}
+	for _, rr := range msg.ResourceRecodes {
+		if rr.RRType != DNSTypeOPT {
+			rr.TTL = r.clamp(rr.TTL)
+		}
+	}
+}
+
+// ClampTTL 客户端在收到响应后按 p 修改 TTL
+func ClampTTL(p *TTLPolicy) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+			resp, err := next.RoundTrip(ctx, server, req)
+			if err == nil && resp != nil {
+				p.Apply(resp)
+			}
+			return resp, err
+		})
+	}
+}
+
+// WithTTLPolicy 服务端按 p 修改应答的 TTL. 放在 WithCache 之后时缓存按修改后的 TTL 保存与老化,
+// 放在之前时只影响发给客户端的应答
+func WithTTLPolicy(p *TTLPolicy) ServerMiddleware {
+	return func(next DNSHandler) DNSHandler {
+		return DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+			resp, err := next.ServeDNS(ctx, req)
+			if err == nil && resp != nil {
+				p.Apply(resp)
+			}
+			return resp, err
+		})
+	}
+}
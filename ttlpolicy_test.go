@@ -0,0 +1,54 @@
+package netx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTTLPolicy(t *testing.T) {
+	p := &TTLPolicy{Default: TTLRange{Min: time.Minute, Max: time.Hour}}
+	p.Override("api.example.", TTLRange{Max: 5 * time.Second})
+	handler := DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		resp := NewReply(req.Message)
+		name := req.Message.Questions[0].QuestionName
+		resp.ResourceRecodes = []*DNSResourceRecode{
+			{Name: name, RRType: DNSTypeCName, Class: DNSClassIn, TTL: 86400 * 7, RData: "cdn.example.net"},
+			{Name: "cdn.example.net", RRType: DNSTypeA, Class: DNSClassIn, TTL: 20, RData: "192.0.2.1"},
+			{Name: "", RRType: DNSTypeOPT, Class: 1232, TTL: 0x8000},
+		}
+		resp.Header.AnswerRRs, resp.Header.AdditionalRRs = 2, 1
+		return resp, nil
+	})
+	h := ChainHandler(handler, WithTTLPolicy(p))
+	for name, want := range map[string][2]uint32{
+		"www.example":        {3600, 60},
+		"v1.API.example.com": {3600, 60},
+		"v1.API.example":     {5, 5},
+		"api.example":        {5, 5},
+	} {
+		q := NewQuery(name, DNSTypeA)
+		resp, err := h.ServeDNS(context.Background(), &DNSRequest{Message: q, Network: "udp"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs := resp.ResourceRecodes
+		if rrs[0].TTL != want[0] || rrs[1].TTL != want[1] || rrs[2].TTL != 0x8000 {
+			t.Fatalf("%s: ttl %d %d %d, want %v", name, rrs[0].TTL, rrs[1].TTL, rrs[2].TTL, want)
+		}
+	}
+
+	// 客户端, 零值不修改
+	rt := RoundTripperFunc(func(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+		return handler.ServeDNS(ctx, &DNSRequest{Message: req})
+	})
+	q := NewQuery("api.example", DNSTypeA)
+	resp, err := ClampTTL(&TTLPolicy{})(rt).RoundTrip(context.Background(), "", q)
+	if err != nil || resp.ResourceRecodes[0].TTL != 86400*7 {
+		t.Fatalf("zero policy: %v", err)
+	}
+	resp, err = ClampTTL(p)(rt).RoundTrip(context.Background(), "", q)
+	if err != nil || resp.ResourceRecodes[0].TTL != 5 {
+		t.Fatalf("client clamp: %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// udpBatchSize 每次 recvmmsg/sendmmsg 最多处理的数据包
+	udpBatchSize = 32
+	// udpBatchBuffer 批量读取时每个数据包的缓冲区, 超出的部分被截断
+	udpBatchBuffer = 4096
+)
+
+// batchConn 用 recvmmsg/sendmmsg 批量收发的 udp socket
+type batchConn struct {
+	net.PacketConn
+	rw interface {
+		ReadBatch(ms []ipv4.Message, flags int) (int, error)
+		WriteBatch(ms []ipv4.Message, flags int) (int, error)
+	}
+	v6 bool // ipv6 socket 不能用 sendmmsg 发往 ipv4 地址 (双栈时为映射地址)
+}
+
+// newBatchConn 在支持批量收发的系统上包装 conn, 否则返回空
+func newBatchConn(conn net.PacketConn) *batchConn {
+	if !udpBatchSupported {
+		return nil
+	}
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	local, ok := udp.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	if local.IP.To4() != nil {
+		return &batchConn{PacketConn: conn, rw: ipv4.NewPacketConn(udp)}
+	}
+	return &batchConn{PacketConn: conn, rw: ipv6.NewPacketConn(udp), v6: true}
+}
+
+func newBatchMessages(n, size int) []ipv4.Message {
+	ms := make([]ipv4.Message, n)
+	for i := range ms {
+		ms[i].Buffers = [][]byte{make([]byte, size)}
+	}
+	return ms
+}
+
+// udpPacket 待发送的数据包
+type udpPacket struct {
+	b    []byte
+	addr net.Addr
+	done func(err error) // 发送后调用, 可以为空
+}
+
+// writeLoop 从 out 取出数据包批量发送, 直到 out 或 stop 关闭
+func (c *batchConn) writeLoop(out <-chan *udpPacket, stop <-chan struct{}) {
+	ms := make([]ipv4.Message, 0, udpBatchSize)
+	batch := make([]*udpPacket, 0, udpBatchSize)
+	for {
+		var p *udpPacket
+		select {
+		case p = <-out:
+		case <-stop:
+			return
+		}
+		if p == nil {
+			return
+		}
+		batch = append(batch[:0], p)
+		// 不等待, 只取已经排队的数据包
+	drain:
+		for len(batch) < udpBatchSize {
+			select {
+			case p, ok := <-out:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, p)
+			default:
+				break drain
+			}
+		}
+		ms = ms[:0]
+		for _, p := range batch {
+			if c.v6 && udpAddrIs4(p.addr) {
+				_, err := c.WriteTo(p.b, p.addr)
+				p.finish(err)
+				continue
+			}
+			ms = append(ms, ipv4.Message{Buffers: [][]byte{p.b}, Addr: p.addr})
+		}
+		c.writeBatch(ms, batch)
+	}
+}
+
+// writeBatch 发送 ms, 出错时逐个发送剩余的数据包. batch 中已单独发送的数据包不在 ms 中
+func (c *batchConn) writeBatch(ms []ipv4.Message, batch []*udpPacket) {
+	sent := 0
+	for sent < len(ms) {
+		n, err := c.rw.WriteBatch(ms[sent:], 0)
+		if err != nil || n == 0 {
+			break
+		}
+		sent += n
+	}
+	i := 0
+	for _, p := range batch {
+		if c.v6 && udpAddrIs4(p.addr) {
+			continue
+		}
+		var err error
+		if i >= sent {
+			_, err = c.WriteTo(p.b, p.addr)
+		}
+		p.finish(err)
+		i++
+	}
+}
+
+func (p *udpPacket) finish(err error) {
+	if p.done != nil {
+		p.done(err)
+	}
+}
+
+func udpAddrIs4(addr net.Addr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	return ok && a.IP.To4() != nil
+}
+
+// serveUDPBatch 与 ServeUDP 相同, 通过 recvmmsg 读取请求, sendmmsg 发送响应
+func (s *DNSServer) serveUDPBatch(bc *batchConn, h DNSHandler) error {
+	out := make(chan *udpPacket, udpBatchSize)
+	var pending sync.WaitGroup
+	defer func() {
+		// 处理中的请求写出响应后再结束发送循环
+		go func() {
+			pending.Wait()
+			close(out)
+		}()
+	}()
+	go bc.writeLoop(out, nil)
+	done := func(error) { atomic.AddInt64(&s.inflight, -1) }
+
+	ms := newBatchMessages(udpBatchSize, udpBatchBuffer)
+	for {
+		n, err := bc.rw.ReadBatch(ms, 0)
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		for _, m := range ms[:n] {
+			packet := append(getBuffer()[:0], m.Buffers[0][:m.N]...)
+			addr := m.Addr
+			atomic.AddInt64(&s.inflight, 1)
+			pending.Add(1)
+			go func() {
+				defer pending.Done()
+				resp := s.serve(h, packet, "udp", addr, bc.LocalAddr())
+				putBuffer(packet)
+				if resp == nil {
+					done(nil)
+					return
+				}
+				out <- &udpPacket{b: resp, addr: addr, done: done}
+			}()
+		}
+	}
+}
+
+// UDPPoolTransport 在固定数量的 udp socket 上复用请求, 按服务器地址与 TxID 匹配响应, linux 上批量收发.
+// 与每次新建 socket 的 UDPTransport 相比系统调用更少, 但源端口固定, 只依赖随机 TxID 防止伪造响应,
+// 适合高频查询可信的上游. 零值可用, 可以并发使用, 不再使用时调用 Close
+type UDPPoolTransport struct {
+	// Sockets socket 数量, 默认 runtime.NumCPU()
+	Sockets int
+
+	mu     sync.Mutex
+	conns  map[string][]*udpPoolConn // "udp4" 或 "udp6" -> socket
+	next   uint32
+	closed bool
+}
+
+type udpPoolConn struct {
+	conn  net.PacketConn
+	batch *batchConn
+	out   chan *udpPacket
+	stop  chan struct{} // 读取循环结束时关闭
+
+	mu      sync.Mutex
+	pending map[udpPoolKey]chan udpPoolResult
+	err     error // 读取循环结束的原因
+}
+
+type udpPoolKey struct {
+	server string
+	id     uint16
+}
+
+type udpPoolResult struct {
+	b   []byte
+	err error
+}
+
+func (t *UDPPoolTransport) RoundTrip(ctx context.Context, server string, req *DNSMessage) (*DNSMessage, error) {
+	info := queryInfoFrom(ctx)
+	info.attempt("udp")
+	start := time.Now()
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	if info != nil {
+		info.Resolve, info.Server, info.Reused = time.Since(start), addr.String(), true
+	}
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	c, err := t.conn(network)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	key, ch, err := c.register(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unregister(key)
+	// 线上使用不冲突的随机 TxID, 响应中恢复为 req 的 TxID
+	binary.BigEndian.PutUint16(packet, key.id)
+	start = time.Now()
+	if c.batch != nil {
+		p := &udpPacket{b: packet, addr: addr, done: func(err error) {
+			if err != nil {
+				c.deliver(key, udpPoolResult{err: errors.WithMessage(err, "write error")})
+			}
+		}}
+		select {
+		case c.out <- p:
+		case <-c.stop:
+			return nil, ErrPoolClosed
+		case <-ctx.Done():
+			return nil, errors.WithMessage(ctx.Err(), "write error")
+		}
+	} else if _, err := c.conn.WriteTo(packet, addr); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+	info.wrote(start)
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		info.firstByte()
+		resp, err := UnpackPooled(r.b)
+		putBuffer(r.b)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.TxID = req.Header.TxID
+		return resp, nil
+	case <-ctx.Done():
+		return nil, errors.WithMessage(ctx.Err(), "read error")
+	}
+}
+
+// conn 轮流选择 network 的 socket, 第一次使用时打开
+func (t *UDPPoolTransport) conn(network string) (*udpPoolConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrPoolClosed
+	}
+	if t.conns == nil {
+		t.conns = map[string][]*udpPoolConn{}
+	}
+	conns := t.conns[network]
+	if conns == nil {
+		n := t.Sockets
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		for i := 0; i < n; i++ {
+			conn, err := net.ListenPacket(network, ":0")
+			if err != nil {
+				for _, c := range conns {
+					_ = c.conn.Close()
+				}
+				return nil, err
+			}
+			c := &udpPoolConn{
+				conn:    conn,
+				batch:   newBatchConn(conn),
+				stop:    make(chan struct{}),
+				pending: map[udpPoolKey]chan udpPoolResult{},
+			}
+			if c.batch != nil {
+				c.out = make(chan *udpPacket, udpBatchSize)
+				go c.batch.writeLoop(c.out, c.stop)
+			}
+			go c.readLoop()
+			conns = append(conns, c)
+		}
+		t.conns[network] = conns
+	}
+	t.next++
+	return conns[int(t.next)%len(conns)], nil
+}
+
+// Close 关闭所有 socket, 等待中的请求返回 ErrPoolClosed
+func (t *UDPPoolTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for _, conns := range t.conns {
+		for _, c := range conns {
+			_ = c.conn.Close()
+		}
+	}
+	t.conns = nil
+	return nil
+}
+
+// register 为发往 addr 的请求分配未使用的 TxID
+func (c *udpPoolConn) register(addr *net.UDPAddr) (udpPoolKey, chan udpPoolResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return udpPoolKey{}, nil, c.err
+	}
+	key := udpPoolKey{server: addr.String()}
+	for {
+		key.id = randTxID()
+		if _, ok := c.pending[key]; !ok {
+			break
+		}
+	}
+	ch := make(chan udpPoolResult, 1)
+	c.pending[key] = ch
+	return key, ch, nil
+}
+
+func (c *udpPoolConn) unregister(key udpPoolKey) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	c.mu.Unlock()
+}
+
+// deliver 将结果交给等待 key 的请求, 只有第一个结果生效. 没有请求等待时返回 false
+func (c *udpPoolConn) deliver(key udpPoolKey, r udpPoolResult) bool {
+	c.mu.Lock()
+	ch, ok := c.pending[key]
+	delete(c.pending, key)
+	c.mu.Unlock()
+	if ok {
+		ch <- r
+	}
+	return ok
+}
+
+func (c *udpPoolConn) readLoop() {
+	var err error
+	if c.batch != nil {
+		ms := newBatchMessages(udpBatchSize, 65535)
+		for err == nil {
+			var n int
+			if n, err = c.batch.rw.ReadBatch(ms, 0); err != nil {
+				break
+			}
+			for _, m := range ms[:n] {
+				c.receive(m.Buffers[0][:m.N], m.Addr)
+			}
+		}
+	} else {
+		buf := make([]byte, 65535)
+		for err == nil {
+			var n int
+			var addr net.Addr
+			n, addr, err = c.conn.ReadFrom(buf)
+			if err == nil {
+				c.receive(buf[:n], addr)
+			}
+		}
+	}
+	close(c.stop)
+	c.mu.Lock()
+	c.err = ErrPoolClosed
+	pending := c.pending
+	c.pending = map[udpPoolKey]chan udpPoolResult{}
+	c.mu.Unlock()
+	for _, ch := range pending {
+		ch <- udpPoolResult{err: ErrPoolClosed}
+	}
+}
+
+func (c *udpPoolConn) receive(b []byte, addr net.Addr) {
+	if len(b) < 12 || addr == nil {
+		return
+	}
+	packet := append(getBuffer()[:0], b...)
+	if !c.deliver(udpPoolKey{server: addr.String(), id: binary.BigEndian.Uint16(b)}, udpPoolResult{b: packet}) {
+		putBuffer(packet)
+	}
+}
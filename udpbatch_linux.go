@@ -0,0 +1,4 @@
+package netx
+
+// udpBatchSupported recvmmsg/sendmmsg 只在 linux 上真正批量处理
+const udpBatchSupported = true
@@ -0,0 +1,59 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUDPPoolTransport(t *testing.T) {
+	s := &DNSServer{Handler: DNSHandlerFunc(func(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+		q := req.Message.Questions[0]
+		resp := NewReply(req.Message)
+		resp.ResourceRecodes = []*DNSResourceRecode{{RRType: DNSTypeTXT, Class: DNSClassIn, Name: q.QuestionName, TTL: 60, Data: append([]byte{byte(len(q.QuestionName))}, q.QuestionName...)}}
+		resp.Header.AnswerRRs = 1
+		return resp, nil
+	})}
+	servers := []string{}
+	udpAddr, _ := startDNSServer(t, s)
+	servers = append(servers, udpAddr)
+	if conn, err := net.ListenPacket("udp", "[::1]:0"); err == nil {
+		go s.ServeUDP(conn)
+		t.Cleanup(func() { _ = conn.Close() })
+		servers = append(servers, conn.LocalAddr().String())
+	}
+
+	transport := &UDPPoolTransport{Sockets: 2}
+	defer transport.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &Resolver{Server: servers[i%len(servers)], Transport: transport}
+			name := "q" + strconv.Itoa(i) + ".example.com"
+			txts, err := r.LookupTXT(context.Background(), name)
+			if err == nil && (len(txts) != 1 || txts[0] != name) {
+				err = errors.New("unexpected answer " + strings.Join(txts, ","))
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = transport.Close()
+	if _, err := (&Resolver{Server: udpAddr, Transport: transport}).LookupTXT(context.Background(), "x.example.com"); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("after close = %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// ErrLimitExceeded 可以用 errors.Is 判断错误是否为 *LimitError
+var ErrLimitExceeded = errors.New("dns message limit exceeded")
+
+// LimitError 报文超出 UnpackOptions 中的某个上限
+type LimitError struct {
+	Limit string // "message size", "pointer jumps", "name expansion", "records" 或 "rdata length"
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return "dns message exceeds " + e.Limit + " limit " + strconv.Itoa(e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func newUnpacker(data []byte, o *UnpackOptions) *unpacker {
+	u := &unpacker{msg: data, opts: o}
+	if o != nil && o.MaxNameExpansion > 0 {
+		u.expanded = new(int)
+	}
+	return u
+}
+
+// expand 记录展开了 n 字节的名字
+func (u *unpacker) expand(n int) error {
+	if u.expanded == nil {
+		return nil
+	}
+	*u.expanded += n
+	if *u.expanded > u.opts.MaxNameExpansion {
+		return &LimitError{Limit: "name expansion", Max: u.opts.MaxNameExpansion}
+	}
+	return nil
+}
+
+func (u *unpacker) maxPointerJumps() int {
+	if u.opts == nil || u.opts.MaxPointerJumps <= 0 {
+		return defaultMaxPointerJumps
+	}
+	return u.opts.MaxPointerJumps
+}
+
+func (o *UnpackOptions) maxMessageSize() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxMessageSize
+}
+
+func (o *UnpackOptions) maxRecords() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxRecords
+}
+
+func (o *UnpackOptions) maxRDataLength() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxRDataLength
+}
@@ -0,0 +1,66 @@
+package netx
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+// bombMessage 第一个问题为长名字, 其余问题都是指向它的压缩指针
+func bombMessage(questions int) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[4:], uint16(questions))
+	b, _ = appendName(b, strings.Repeat("a", 60)+"."+strings.Repeat("b", 60)+"."+strings.Repeat("c", 60)+".example")
+	b = append(b, 0, 1, 0, 1)
+	for i := 1; i < questions; i++ {
+		b = append(b, 0xC0, 12, 0, 1, 0, 1)
+	}
+	return b
+}
+
+func TestUnpackLimits(t *testing.T) {
+	bomb := bombMessage(1000)
+	m, err := Unpack(bomb)
+	if err != nil || len(m.Questions) != 1000 {
+		t.Fatalf("default Unpack = %v", err)
+	}
+
+	check := func(name string, o *UnpackOptions, data []byte, limit string) {
+		t.Helper()
+		_, _, err := o.Unpack(data)
+		var le *LimitError
+		if !errors.Is(err, ErrLimitExceeded) || !errors.As(err, &le) || le.Limit != limit {
+			t.Errorf("%s: error = %v, want %s limit", name, err, limit)
+		}
+	}
+	check("expansion", &UnpackOptions{MaxNameExpansion: 16 << 10}, bomb, "name expansion")
+	check("lenient expansion", &UnpackOptions{Mode: UnpackLenient, MaxNameExpansion: 16 << 10}, bomb, "name expansion")
+	check("records", &UnpackOptions{MaxRecords: 100}, bomb, "records")
+	check("size", &UnpackOptions{MaxMessageSize: 512}, bomb, "message size")
+
+	// 每个问题的名字都由一个 label 与指向前一个问题的指针组成, 第 n 个问题需要跳转 n-1 次
+	jumps := make([]byte, 12)
+	binary.BigEndian.PutUint16(jumps[4:], 4)
+	prev := len(jumps)
+	jumps = append(jumps, 1, 'a', 0, 0, 1, 0, 1)
+	for _, label := range []byte("bcd") {
+		at := len(jumps)
+		jumps = append(jumps, 1, label, 0xC0, byte(prev), 0, 1, 0, 1)
+		prev = at
+	}
+	if m, _, err := (&UnpackOptions{MaxPointerJumps: 3}).Unpack(jumps); err != nil || m.Questions[3].QuestionName != "d.c.b.a" {
+		t.Fatalf("3 jumps = %v", err)
+	}
+	check("jumps", &UnpackOptions{MaxPointerJumps: 2}, jumps, "pointer jumps")
+
+	rr := &DNSMessage{
+		Header:          &DNSHeader{Flags: &DNSFlags{}, AnswerRRs: 1},
+		ResourceRecodes: []*DNSResourceRecode{{Name: "x.example", RRType: 99, Class: DNSClassIn, Data: make([]byte, 300)}},
+	}
+	b, _ := rr.ToByte()
+	check("rdata", &UnpackOptions{MaxRDataLength: 255}, b, "rdata length")
+	if _, _, err := (&UnpackOptions{MaxRDataLength: 300}).Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// UnpackMode 解析报文时对格式错误的容忍程度
+type UnpackMode int
+
+const (
+	// UnpackDefault 格式错误时返回错误, 忽略报文末尾多余的字节
+	UnpackDefault UnpackMode = iota
+	// UnpackStrict 在 UnpackDefault 的基础上拒绝末尾多余的字节 (计数少于实际内容) 与长度不对的 A/AAAA 记录, 适合服务器
+	UnpackStrict
+	// UnpackLenient 尽量保留能解析的部分: RDATA 无法解析时保留原始数据, 问题或记录损坏时截断并修正计数.
+	// 每处问题记录为一条 UnpackWarning, 适合抓包分析
+	UnpackLenient
+)
+
+var ErrTrailingData = errors.New("trailing data after message")
+
+// UnpackOptions 解析选项, 零值与 Unpack 相同
+type UnpackOptions struct {
+	Mode UnpackMode
+
+	// 以下上限用于防御恶意构造的报文, 超出时返回 *LimitError, 宽松模式也不会保留. 为 0 时使用默认值
+
+	// MaxMessageSize 报文长度, 默认不限制
+	MaxMessageSize int
+	// MaxPointerJumps 一个名字中压缩指针的跳转次数, 默认 64
+	MaxPointerJumps int
+	// MaxNameExpansion 所有名字 (包括 RDATA 中的) 展开后的总字节数, 默认不限制.
+	// 压缩指针使很短的报文可以展开为大量的名字
+	MaxNameExpansion int
+	// MaxRecords 问题与记录的总数, 在解析前按头部的计数检查, 默认不限制
+	MaxRecords int
+	// MaxRDataLength 单条记录的 RDATA 长度, 默认不限制
+	MaxRDataLength int
+}
+
+// UnpackWarning 宽松模式下被跳过或保留为原始数据的内容
+type UnpackWarning struct {
+	Offset int // 出现问题的位置在报文中的偏移
+	Err    error
+}
+
+func (w *UnpackWarning) Error() string {
+	return "offset " + strconv.Itoa(w.Offset) + ": " + w.Err.Error()
+}
+
+// Unpack 按选项解析完整报文, 只有 UnpackLenient 会返回警告. o 为空时使用默认值
+func (o *UnpackOptions) Unpack(data []byte) (*DNSMessage, []*UnpackWarning, error) {
+	u := newUnpacker(data, o)
+	m := &DNSMessage{Header: &DNSHeader{Flags: &DNSFlags{}}}
+	newQuestion := func() *DNSQuestion { return &DNSQuestion{} }
+	newRecord := func() *DNSResourceRecode { return &DNSResourceRecode{} }
+	if err := u.message(m, newQuestion, newRecord); err != nil {
+		return nil, nil, err
+	}
+	return m, u.warnings, nil
+}
+
+// UnpackPooled 与 Unpack 相同, 结果取自对象池, 见 UnpackPooled
+func (o *UnpackOptions) UnpackPooled(data []byte) (*DNSMessage, []*UnpackWarning, error) {
+	u := newUnpacker(data, o)
+	m := messagePool.Get().(*DNSMessage)
+	m.pooled = true
+	if err := u.message(m, getQuestion, getRecord); err != nil {
+		m.Release()
+		return nil, nil, err
+	}
+	return m, u.warnings, nil
+}
+
+func (u *unpacker) mode() UnpackMode {
+	if u.opts == nil {
+		return UnpackDefault
+	}
+	return u.opts.Mode
+}
+
+func (u *unpacker) warn(off int, err error) {
+	u.warnings = append(u.warnings, &UnpackWarning{Offset: off, Err: err})
+}
@@ -0,0 +1,75 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestUnpackMode(t *testing.T) {
+	msg := benchMessage()
+	packet, err := msg.ToByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict := &UnpackOptions{Mode: UnpackStrict}
+	lenient := &UnpackOptions{Mode: UnpackLenient}
+
+	// 末尾多余的字节
+	trailing := append(append([]byte{}, packet...), 0, 0, 0)
+	if _, err := Unpack(trailing); err != nil {
+		t.Fatalf("default mode rejected trailing bytes: %v", err)
+	}
+	if _, _, err := strict.Unpack(trailing); errors.Cause(err) != ErrTrailingData {
+		t.Fatalf("strict trailing error = %v", err)
+	}
+	if _, warnings, err := lenient.Unpack(trailing); err != nil || len(warnings) != 1 || warnings[0].Offset != len(packet) {
+		t.Fatalf("lenient trailing = %v, %v", warnings, err)
+	}
+	if _, warnings, err := strict.Unpack(packet); err != nil || warnings != nil {
+		t.Fatalf("strict rejected a valid message: %v, %v", warnings, err)
+	}
+
+	// 长度不对的 A 记录
+	bad := &DNSMessage{
+		Header:          &DNSHeader{Flags: &DNSFlags{QR: 1}, AnswerRRs: 1},
+		ResourceRecodes: []*DNSResourceRecode{{Name: "a.example", RRType: DNSTypeA, Class: DNSClassIn, Data: []byte{1, 2, 3, 4, 5}}},
+	}
+	b, _ := bad.ToByte()
+	if m, err := Unpack(b); err != nil || len(m.ResourceRecodes[0].Data) != 5 {
+		t.Fatalf("default A length 5 = %v", err)
+	}
+	if _, _, err := strict.Unpack(b); errors.Cause(err) != ErrBadRData {
+		t.Fatalf("strict A length 5 error = %v", err)
+	}
+
+	// MX 中的压缩指针指向后面, 宽松模式保留原始数据
+	bad.ResourceRecodes[0] = &DNSResourceRecode{Name: "a.example", RRType: DNSTypeMX, Class: DNSClassIn, Data: []byte{0, 10, 0xC0, 0x7F}}
+	b, _ = bad.ToByte()
+	if _, err := Unpack(b); errors.Cause(err) != ErrBadPointer {
+		t.Fatalf("default bad MX error = %v", err)
+	}
+	m, warnings, err := lenient.Unpack(b)
+	if err != nil || len(warnings) != 1 || len(m.ResourceRecodes[0].Data) != 4 {
+		t.Fatalf("lenient bad MX = %v, %v", warnings, err)
+	}
+
+	// 最后一条记录被截断, 宽松模式保留前面的记录并修正计数
+	m, warnings, err = lenient.Unpack(packet[:len(packet)-10])
+	if err != nil || len(warnings) != 1 {
+		t.Fatalf("lenient truncated = %v, %v", warnings, err)
+	}
+	if m.Header.AnswerRRs != 4 || m.Header.AuthorityRRs != 0 || len(m.ResourceRecodes) != 4 {
+		t.Fatalf("lenient truncated header = %+v, %d records", m.Header, len(m.ResourceRecodes))
+	}
+	if _, err := m.ToByte(); err != nil {
+		t.Fatal(err)
+	}
+	m, warnings, err = lenient.UnpackPooled(packet[:20])
+	if err != nil || len(warnings) != 1 || m.Header.Questions != 0 || m.Header.AnswerRRs != 0 {
+		t.Fatalf("lenient truncated question = %+v, %v, %v", m, warnings, err)
+	}
+	m.Release()
+	if _, _, err := lenient.Unpack(packet[:5]); err == nil {
+		t.Fatal("lenient accepted a short header")
+	}
+}
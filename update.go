@@ -0,0 +1,212 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+// DNSUpdate 动态更新 (RFC 2136) 请求的内容: 区域, 前提条件与更新, 与 nsupdate 的 prereq 和 update 命令一一对应.
+// 前提条件全部满足时服务器才按顺序执行更新, 见 DynamicZone
+type DNSUpdate struct {
+	Zone    string
+	Prereqs []*DNSResourceRecode
+	Updates []*DNSResourceRecode
+}
+
+// NewUpdate 返回更新 zone 的空请求
+func NewUpdate(zone string) *DNSUpdate {
+	return &DNSUpdate{Zone: zone}
+}
+
+// emptyRecord 没有 RDATA 的记录, 用于前提条件与删除
+func emptyRecord(name string, class, rrtype uint16) *DNSResourceRecode {
+	return &DNSResourceRecode{Name: name, RRType: rrtype, Class: class, Data: []byte{}}
+}
+
+// NameInUse 要求 name 存在 (prereq yxdomain)
+func (u *DNSUpdate) NameInUse(name string) {
+	u.Prereqs = append(u.Prereqs, emptyRecord(name, DNSClassAny, DNSTypeANY))
+}
+
+// NameNotInUse 要求 name 不存在 (prereq nxdomain)
+func (u *DNSUpdate) NameNotInUse(name string) {
+	u.Prereqs = append(u.Prereqs, emptyRecord(name, DNSClassNone, DNSTypeANY))
+}
+
+// RRsetExists 要求 name 有 rrtype 的记录 (prereq yxrrset 不带数据)
+func (u *DNSUpdate) RRsetExists(name string, rrtype uint16) {
+	u.Prereqs = append(u.Prereqs, emptyRecord(name, DNSClassAny, rrtype))
+}
+
+// RRsetNotExists 要求 name 没有 rrtype 的记录 (prereq nxrrset)
+func (u *DNSUpdate) RRsetNotExists(name string, rrtype uint16) {
+	u.Prereqs = append(u.Prereqs, emptyRecord(name, DNSClassNone, rrtype))
+}
+
+// RRsetEquals 要求 rrs 所在的 rrset 与 rrs 完全相同 (prereq yxrrset 带数据), 忽略 rrs 的 TTL
+func (u *DNSUpdate) RRsetEquals(rrs ...*DNSResourceRecode) {
+	for _, rr := range rrs {
+		c := rr.Copy()
+		c.Class, c.TTL = DNSClassIn, 0
+		u.Prereqs = append(u.Prereqs, c)
+	}
+}
+
+// Add 添加记录 (update add)
+func (u *DNSUpdate) Add(rrs ...*DNSResourceRecode) {
+	for _, rr := range rrs {
+		c := rr.Copy()
+		c.Class = DNSClassIn
+		u.Updates = append(u.Updates, c)
+	}
+}
+
+// Delete 删除与 rrs 数据相同的记录 (update delete 带数据)
+func (u *DNSUpdate) Delete(rrs ...*DNSResourceRecode) {
+	for _, rr := range rrs {
+		c := rr.Copy()
+		c.Class, c.TTL = DNSClassNone, 0
+		u.Updates = append(u.Updates, c)
+	}
+}
+
+// DeleteRRset 删除 name 上 rrtype 的所有记录 (update delete 名字 类型)
+func (u *DNSUpdate) DeleteRRset(name string, rrtype uint16) {
+	u.Updates = append(u.Updates, emptyRecord(name, DNSClassAny, rrtype))
+}
+
+// DeleteName 删除 name 上的所有记录 (update delete 名字)
+func (u *DNSUpdate) DeleteName(name string) {
+	u.Updates = append(u.Updates, emptyRecord(name, DNSClassAny, DNSTypeANY))
+}
+
+// Message 构造 UPDATE 报文: 区域在问题部分, 前提条件在回答部分, 更新在授权部分
+func (u *DNSUpdate) Message() *DNSMessage {
+	records := make([]*DNSResourceRecode, 0, len(u.Prereqs)+len(u.Updates))
+	records = append(append(records, u.Prereqs...), u.Updates...)
+	return &DNSMessage{
+		Header: &DNSHeader{
+			TxID:         randTxID(),
+			Flags:        &DNSFlags{OpCode: DNSOpCodeUpdate},
+			Questions:    1,
+			AnswerRRs:    uint16(len(u.Prereqs)),
+			AuthorityRRs: uint16(len(u.Updates)),
+		},
+		Questions:       []*DNSQuestion{{QuestionName: u.Zone, QuestionType: DNSTypeSOA, QuestionClass: DNSClassIn}},
+		ResourceRecodes: records,
+	}
+}
+
+// UpdateClient 向主服务器发送 UPDATE 请求. Key 不为空时用 TSIG 签名请求, 并要求响应带有正确的签名.
+// 请求先通过 udp 发送, 响应被截断或 TCP 为真时使用 tcp
+type UpdateClient struct {
+	Server  string // host:port
+	Key     *TSIGKey
+	TCP     bool
+	Timeout time.Duration // 默认 5s
+	// Dialer 为空时直接连接
+	Dialer Dialer
+}
+
+// Update 发送 u, 返回服务器的响应. 响应码不为 0 时不返回错误, 由调用者检查
+func (c *UpdateClient) Update(ctx context.Context, u *DNSUpdate) (*DNSMessage, error) {
+	return c.Exchange(ctx, u.Message())
+}
+
+// Exchange 发送任意请求, 例如带 TSIG 的 SOA 查询或 NOTIFY
+func (c *UpdateClient) Exchange(ctx context.Context, req *DNSMessage) (*DNSMessage, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	packet, err := req.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	var mac []byte
+	if c.Key != nil {
+		if packet, mac, err = c.Key.Sign(packet, nil, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	var raw []byte
+	if !c.TCP && len(packet) <= 512 {
+		raw, err = c.exchange(ctx, "udp", packet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if raw == nil || raw[2]&0x02 != 0 {
+		if raw, err = c.exchange(ctx, "tcp", packet); err != nil {
+			return nil, err
+		}
+	}
+	if c.Key != nil {
+		if _, err := c.Key.Verify(raw, mac, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := Unpack(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.TxID != req.Header.TxID {
+		return nil, ErrTxIDMismatch
+	}
+	return resp, nil
+}
+
+// exchange 收发一个未解析的报文, 至少包含头部
+func (c *UpdateClient) exchange(ctx context.Context, network string, packet []byte) ([]byte, error) {
+	var dialer Dialer = &net.Dialer{}
+	if c.Dialer != nil {
+		dialer = c.Dialer
+	}
+	conn, err := dialer.DialContext(ctx, network, c.Server)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dial error")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if network == "udp" {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, errors.WithMessage(err, "write error")
+		}
+		buf := make([]byte, 0xFFFF)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, errors.WithMessage(err, "read error")
+			}
+			// 丢弃 ID 不匹配的报文
+			if n >= 12 && buf[0] == packet[0] && buf[1] == packet[1] {
+				return buf[:n], nil
+			}
+		}
+	}
+	out := make([]byte, 2, 2+len(packet))
+	binary.BigEndian.PutUint16(out, uint16(len(packet)))
+	if _, err := conn.Write(append(out, packet...)); err != nil {
+		return nil, errors.WithMessage(err, "write error")
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, errors.WithMessage(err, "read error")
+	}
+	if len(buf) < 12 {
+		return nil, ErrShortBuffer
+	}
+	return buf, nil
+}
@@ -0,0 +1,91 @@
+package netx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpdateClient(t *testing.T) {
+	d := NewDynamicZone(parseDynamicZone(t))
+	d.AllowUpdate = func(req *DNSRequest) bool { return true }
+	udp, tcp := startDNSServer(t, &DNSServer{Handler: d})
+	ctx := context.Background()
+
+	u := NewUpdate("example")
+	u.NameNotInUse("new.example")
+	u.RRsetExists("mail.example", DNSTypeMX)
+	u.Add(&DNSResourceRecode{Name: "new.example", RRType: DNSTypeA, TTL: 60, RData: "192.0.2.9"})
+	u.DeleteRRset("www.example", DNSTypeA)
+	u.Delete(&DNSResourceRecode{Name: "mail.example", RRType: DNSTypeMX, RData: "10 mx.example"})
+	resp, err := (&UpdateClient{Server: udp}).Update(ctx, u)
+	if err != nil || resp.Header.Flags.RCode != DNSRCodeSuccess {
+		t.Fatalf("Update = %v, %v", resp, err)
+	}
+	if got := d.Records("new.example", DNSTypeA); len(got) != 1 || got[0].TTL != 60 {
+		t.Fatalf("added: %v", got)
+	}
+	if len(d.Records("www.example", DNSTypeA)) != 0 || len(d.Records("mail.example", DNSTypeMX)) != 0 {
+		t.Fatal("records not deleted")
+	}
+
+	// 前提条件不满足, 通过 tcp 发送
+	u = NewUpdate("example")
+	u.NameNotInUse("new.example")
+	u.RRsetEquals(&DNSResourceRecode{Name: "ns.example", RRType: DNSTypeA, TTL: 300, RData: "192.0.2.53"})
+	u.DeleteName("new.example")
+	resp, err = (&UpdateClient{Server: tcp, TCP: true}).Update(ctx, u)
+	if err != nil || resp.Header.Flags.RCode != DNSRCodeYXDomain || len(d.Records("new.example", 0)) == 0 {
+		t.Fatalf("prerequisite: %v, %v", resp, err)
+	}
+}
+
+func TestUpdateClientTSIG(t *testing.T) {
+	key := &TSIGKey{Name: "update", Secret: []byte("0123456789abcdef")}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 0xFFFF)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mac, err := key.Verify(buf[:n], nil, time.Now())
+			req, _ := Unpack(buf[:n])
+			resp := NewReply(req)
+			resp.ResourceRecodes = nil
+			resp.Header.AnswerRRs, resp.Header.AuthorityRRs, resp.Header.AdditionalRRs = 0, 0, 0
+			if err != nil {
+				resp.Header.Flags.RCode = DNSRCodeNotAuth
+			}
+			out, _ := resp.ToByte()
+			if req.Questions[0].QuestionName != "unsigned.example" {
+				out, _, _ = key.Sign(out, mac, time.Now())
+			}
+			_, _ = conn.WriteTo(out, addr)
+		}
+	}()
+
+	c := &UpdateClient{Server: conn.LocalAddr().String(), Key: key}
+	u := NewUpdate("example")
+	u.Add(&DNSResourceRecode{Name: "www.example", RRType: DNSTypeA, TTL: 60, RData: "192.0.2.9"})
+	resp, err := c.Update(context.Background(), u)
+	if err != nil || resp.Header.Flags.RCode != DNSRCodeSuccess {
+		t.Fatalf("signed update = %v, %v", resp, err)
+	}
+	// 服务器不认识的密钥
+	c.Key = &TSIGKey{Name: "update", Secret: []byte("wrong")}
+	if _, err := c.Update(context.Background(), u); !errors.Is(err, ErrTSIGBadSig) {
+		t.Fatalf("wrong secret: %v", err)
+	}
+	c.Key = key
+	if _, err := c.Update(context.Background(), NewUpdate("unsigned.example")); !errors.Is(err, ErrTSIGMissing) {
+		t.Fatalf("unsigned response: %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr       = "239.255.255.250:1900"
+	upnpSearchType = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	maxUPnPBytes   = 1 << 20
+
+	// UPnP 错误码 725 OnlyPermanentLeasesSupported
+	upnpOnlyPermanent = "725"
+)
+
+var (
+	ErrUPnPNoService = errors.New("device has no wan connection service")
+	ErrUPnPStatus    = errors.New("unexpected upnp http status")
+)
+
+// upnpServiceTypes 按优先级排列
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []upnpService `xml:"serviceList>service"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// find 深度优先查找 serviceType 对应的服务
+func (d *upnpDevice) find(serviceType string) *upnpService {
+	for i := range d.Services {
+		if d.Services[i].ServiceType == serviceType {
+			return &d.Services[i]
+		}
+	}
+	for i := range d.Devices {
+		if s := d.Devices[i].find(serviceType); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// UPnPClient UPnP IGD 的 WANIPConnection/WANPPPConnection 服务客户端
+type UPnPClient struct {
+	ControlURL  string
+	ServiceType string
+	HTTPClient  *http.Client // 为空时使用 5s 超时的默认客户端
+}
+
+func (c *UPnPClient) client() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: 5 * time.Second}
+}
+
+// DiscoverUPnP 通过 SSDP 搜索局域网内的 IGD, 在 wait 时间内收集应答
+func DiscoverUPnP(ctx context.Context, wait time.Duration) ([]*UPnPClient, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "listen udp error")
+	}
+	defer conn.Close()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: " + strconv.Itoa(int((wait+time.Second-1)/time.Second)) + "\r\n" +
+		"ST: " + upnpSearchType + "\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return nil, errors.WithMessage(err, "write ssdp error")
+	}
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var locations []string
+	seen := map[string]bool{}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+			i := strings.IndexByte(line, ':')
+			if i < 0 || !strings.EqualFold(strings.TrimSpace(line[:i]), "location") {
+				continue
+			}
+			if location := strings.TrimSpace(line[i+1:]); !seen[location] {
+				seen[location] = true
+				locations = append(locations, location)
+			}
+		}
+	}
+
+	var clients []*UPnPClient
+	for _, location := range locations {
+		if c, err := NewUPnPClient(ctx, location, nil); err == nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients, nil
+}
+
+// NewUPnPClient 读取 location 指向的设备描述, 找到 WAN 连接服务的控制地址
+func NewUPnPClient(ctx context.Context, location string, client *http.Client) (*UPnPClient, error) {
+	c := &UPnPClient{HTTPClient: client}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get device description error")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(ErrUPnPStatus, resp.Status)
+	}
+	var root upnpRoot
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxUPnPBytes)).Decode(&root); err != nil {
+		return nil, errors.WithMessage(err, "decode device description error")
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return nil, err
+		}
+	}
+	for _, serviceType := range upnpServiceTypes {
+		service := root.Device.find(serviceType)
+		if service == nil {
+			continue
+		}
+		control, err := base.Parse(strings.TrimSpace(service.ControlURL))
+		if err != nil {
+			return nil, err
+		}
+		c.ControlURL = control.String()
+		c.ServiceType = serviceType
+		return c, nil
+	}
+	return nil, ErrUPnPNoService
+}
+
+// soap 调用 action, args 按顺序写入请求, 返回应答中的各个参数
+func (c *UPnPClient) soap(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + c.ServiceType + `">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg[0] + ">")
+		_ = xml.EscapeText(&body, []byte(arg[1]))
+		body.WriteString("</" + arg[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ControlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+c.ServiceType+"#"+action+`"`)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, errors.WithMessage(err, action)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUPnPBytes))
+	if err != nil {
+		return nil, err
+	}
+	values, err := soapValues(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, action)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if code, ok := values["errorCode"]; ok {
+			return values, errors.WithMessage(ErrPortMapRefused, action+": upnp error "+code+" "+values["errorDescription"])
+		}
+		return nil, errors.WithMessage(ErrUPnPStatus, resp.Status)
+	}
+	return values, nil
+}
+
+// soapValues 收集所有叶子元素的文本, 应答参数与 UPnPError 都是叶子元素
+func soapValues(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var name string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if name == t.Name.Local {
+				values[name] = strings.TrimSpace(text.String())
+			}
+			name = ""
+		}
+	}
+}
+
+// ExternalIP GetExternalIPAddress
+func (c *UPnPClient) ExternalIP(ctx context.Context) (net.IP, error) {
+	values, err := c.soap(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(values["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, errors.WithMessage(ErrInvalidIP, values["NewExternalIPAddress"])
+	}
+	return ip, nil
+}
+
+// localAddr 本机访问控制地址时使用的地址, 作为映射的内部地址
+func (c *UPnPClient) localAddr() (net.IP, error) {
+	u, err := url.Parse(c.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// AddMapping AddPortMapping. ExternalPort 为 0 时与 InternalPort 相同,
+// 网关只支持永久映射时改为创建永久映射, 返回的 Lifetime 为 0
+func (c *UPnPClient) AddMapping(ctx context.Context, m *PortMapping) (*PortMapping, error) {
+	protocol := strings.ToUpper(m.Protocol)
+	if protocol != "TCP" && protocol != "UDP" {
+		return nil, ErrPortMapProtocol
+	}
+	local, err := c.localAddr()
+	if err != nil {
+		return nil, err
+	}
+	result := *m
+	if result.ExternalPort == 0 {
+		result.ExternalPort = m.InternalPort
+	}
+	add := func(lifetime time.Duration) (map[string]string, error) {
+		return c.soap(ctx, "AddPortMapping", [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", strconv.Itoa(result.ExternalPort)},
+			{"NewProtocol", protocol},
+			{"NewInternalPort", strconv.Itoa(m.InternalPort)},
+			{"NewInternalClient", local.String()},
+			{"NewEnabled", "1"},
+			{"NewPortMappingDescription", m.Description},
+			{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+		})
+	}
+	values, err := add(m.Lifetime)
+	if err != nil && m.Lifetime > 0 && values["errorCode"] == upnpOnlyPermanent {
+		result.Lifetime = 0
+		_, err = add(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Acquired = time.Now()
+	if ip, err := c.ExternalIP(ctx); err == nil {
+		result.ExternalIP = ip
+	}
+	return &result, nil
+}
+
+// DeleteMapping DeletePortMapping
+func (c *UPnPClient) DeleteMapping(ctx context.Context, m *PortMapping) error {
+	port := m.ExternalPort
+	if port == 0 {
+		port = m.InternalPort
+	}
+	_, err := c.soap(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(port)},
+		{"NewProtocol", strings.ToUpper(m.Protocol)},
+	})
+	return err
+}
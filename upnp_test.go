@@ -0,0 +1,86 @@
+package netx
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList><device>
+      <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+      <deviceList><device>
+        <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+        <serviceList><service>
+          <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+          <controlURL>/ctl/IPConn</controlURL>
+        </service></serviceList>
+      </device></deviceList>
+    </device></deviceList>
+  </device>
+</root>`
+
+func TestUPnPClient(t *testing.T) {
+	var added string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/desc.xml" {
+			_, _ = w.Write([]byte(testIGDDescription))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		switch {
+		case strings.HasSuffix(action, `#GetExternalIPAddress"`):
+			_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">` +
+				`<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>` +
+				`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+		case strings.HasSuffix(action, `#AddPortMapping"`):
+			if strings.Contains(string(body), "<NewLeaseDuration>3600<") {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>` +
+					`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode>` +
+					`<errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError></detail>` +
+					`</s:Fault></s:Body></s:Envelope>`))
+				return
+			}
+			added = string(body)
+			_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+				`<u:AddPortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"/></s:Body></s:Envelope>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := NewUPnPClient(ctx, server.URL+"/desc.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.ControlURL != server.URL+"/ctl/IPConn" {
+		t.Fatalf("unexpected control url %s", client.ControlURL)
+	}
+	ip, err := client.ExternalIP(ctx)
+	if err != nil || ip.String() != "203.0.113.7" {
+		t.Fatalf("unexpected external ip %v %v", ip, err)
+	}
+
+	// 网关只支持永久映射时回退到 lease 0
+	m, err := client.AddMapping(ctx, &PortMapping{Protocol: "tcp", InternalPort: 8080, Lifetime: 3600e9, Description: "a&b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Lifetime != 0 || m.ExternalPort != 8080 || m.ExternalIP.String() != "203.0.113.7" {
+		t.Fatalf("unexpected mapping %+v", m)
+	}
+	if !strings.Contains(added, "<NewPortMappingDescription>a&amp;b</NewPortMappingDescription>") {
+		t.Fatalf("description not escaped: %s", added)
+	}
+}
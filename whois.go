@@ -0,0 +1,141 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	whoisIANA     = "whois.iana.org"
+	whoisPort     = "43"
+	maxWhoisBytes = 1 << 20
+)
+
+var ErrWhoisLoop = errors.New("whois referral loop")
+
+// WhoisOptions 零值字段使用默认值
+type WhoisOptions struct {
+	Server       string        // 起始服务器, 默认 whois.iana.org
+	Timeout      time.Duration // 单个服务器超时, 默认 10s
+	MaxReferrals int           // 最多跟随的转介次数, 默认 3
+	Dialer       Dialer        // 为空时直接连接
+}
+
+// WhoisResponse 按查询顺序保存每一个服务器的原始应答
+type WhoisResponse struct {
+	Servers []string
+	Bodies  []string
+}
+
+// Body 最后一个 (最权威的) 应答
+func (w *WhoisResponse) Body() string {
+	if len(w.Bodies) == 0 {
+		return ""
+	}
+	return w.Bodies[len(w.Bodies)-1]
+}
+
+// Whois 查询域名或 ip, 从 IANA 开始自动跟随 refer/whois 转介
+func Whois(ctx context.Context, query string, opts *WhoisOptions) (*WhoisResponse, error) {
+	o := WhoisOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Server == "" {
+		o.Server = whoisIANA
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.MaxReferrals <= 0 {
+		o.MaxReferrals = 3
+	}
+	if o.Dialer == nil {
+		o.Dialer = &net.Dialer{}
+	}
+
+	resp := &WhoisResponse{}
+	server := o.Server
+	seen := map[string]bool{}
+	for i := 0; i <= o.MaxReferrals && server != ""; i++ {
+		if seen[whoisAddress(server)] {
+			return resp, ErrWhoisLoop
+		}
+		seen[whoisAddress(server)] = true
+		body, err := whoisQuery(ctx, o.Dialer, server, query, o.Timeout)
+		if err != nil {
+			if len(resp.Bodies) > 0 {
+				// 转介的服务器不可用时保留已有结果
+				return resp, nil
+			}
+			return nil, err
+		}
+		resp.Servers = append(resp.Servers, server)
+		resp.Bodies = append(resp.Bodies, body)
+		next := whoisReferral(body)
+		if whoisAddress(next) == whoisAddress(server) {
+			// 注册商在 "Registrar WHOIS Server" 中写的是自己, 转介到此结束
+			break
+		}
+		server = next
+	}
+	return resp, nil
+}
+
+// whoisAddress 带端口的服务器地址, 用于比较服务器是否相同
+func whoisAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, whoisPort)
+	}
+	return strings.ToLower(server)
+}
+
+func whoisQuery(ctx context.Context, dialer Dialer, server, query string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", whoisAddress(server))
+	if err != nil {
+		return "", errors.WithMessage(err, "dial "+server)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := io.WriteString(conn, query+"\r\n"); err != nil {
+		return "", errors.WithMessage(err, "write error")
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(conn, maxWhoisBytes))
+	if err != nil {
+		return "", errors.WithMessage(err, "read error")
+	}
+	return string(body), nil
+}
+
+// whoisReferral 从应答中找出下一个 whois 服务器
+func whoisReferral(body string) string {
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:idx]))
+		value := strings.TrimSpace(line[idx+1:])
+		switch key {
+		case "refer", "whois", "registrar whois server", "referralserver":
+			value = strings.TrimPrefix(value, "whois://")
+			value = strings.TrimPrefix(value, "rwhois://")
+			if value != "" && !strings.Contains(value, "/") {
+				return strings.ToLower(value)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// startWhoisServer 在 127.0.0.1 上监听, 对每个查询返回 body(查询)
+func startWhoisServer(t *testing.T) (net.Listener, func(body func(query string) string)) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln, func(body func(query string) string) {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					line, err := bufio.NewReader(conn).ReadString('\n')
+					if err != nil || !strings.HasSuffix(line, "\r\n") {
+						return
+					}
+					_, _ = conn.Write([]byte(body(strings.TrimSuffix(line, "\r\n"))))
+				}()
+			}
+		}()
+	}
+}
+
+func TestWhois(t *testing.T) {
+	iana, serveIANA := startWhoisServer(t)
+	registrar, serveRegistrar := startWhoisServer(t)
+	serveIANA(func(query string) string {
+		return "% IANA WHOIS server\nrefer:        " + registrar.Addr().String() + "\n"
+	})
+	serveRegistrar(func(query string) string {
+		return "Domain Name: " + strings.ToUpper(query) + "\nRegistrar WHOIS Server: " + registrar.Addr().String() + "\n"
+	})
+	ctx := context.Background()
+
+	// 注册商转介到自己时结束, 不是循环
+	resp, err := Whois(ctx, "example.com", &WhoisOptions{Server: iana.Addr().String()})
+	if err != nil || len(resp.Servers) != 2 || resp.Servers[1] != registrar.Addr().String() || !strings.HasPrefix(resp.Body(), "Domain Name: EXAMPLE.COM") {
+		t.Fatalf("Whois = %+v, %v", resp, err)
+	}
+	if resp, err := Whois(ctx, "example.com", &WhoisOptions{Server: registrar.Addr().String()}); err != nil || len(resp.Servers) != 1 {
+		t.Fatalf("self referral = %+v, %v", resp, err)
+	}
+
+	a, serveA := startWhoisServer(t)
+	b, serveB := startWhoisServer(t)
+	serveA(func(string) string { return "whois: " + b.Addr().String() + "\n" })
+	serveB(func(string) string { return "ReferralServer: whois://" + a.Addr().String() + "\n" })
+	resp, err = Whois(ctx, "192.0.2.1", &WhoisOptions{Server: a.Addr().String()})
+	if errors.Cause(err) != ErrWhoisLoop || len(resp.Servers) != 2 {
+		t.Fatalf("loop = %+v, %v", resp, err)
+	}
+}
+
+// rewriteTransport 把所有请求发给 target, 用于替换 IANA 的 bootstrap 地址
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRDAPBootstrap(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		var v interface{}
+		switch r.URL.Path {
+		case "/rdap/dns.json":
+			v = rdapBootstrap{Services: [][][]string{
+				{{"com"}, {"https://rdap.example/com/"}},
+				{{"example.com"}, {"https://rdap.example/special"}},
+			}}
+		case "/rdap/ipv4.json":
+			v = rdapBootstrap{Services: [][][]string{
+				{{"192.0.0.0/8"}, {"https://rdap.example/wide/"}},
+				{{"192.0.2.0/24"}, {"https://rdap.example/narrow/"}},
+			}}
+		case "/special/domain/www.example.com":
+			v = RDAPResponse{ObjectClassName: "domain", LDHName: "www.example.com"}
+		case "/narrow/ip/192.0.2.1":
+			v = RDAPResponse{ObjectClassName: "ip network", StartAddress: "192.0.2.0", Country: "ZZ"}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(v)
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	c := &RDAPClient{HTTPClient: &http.Client{Transport: rewriteTransport{target: target}}}
+	ctx := context.Background()
+
+	// 选择最长的后缀与最长的前缀
+	d, err := c.Domain(ctx, "WWW.Example.com.")
+	if err != nil || d.LDHName != "www.example.com" || len(d.Raw) == 0 {
+		t.Fatalf("Domain = %+v, %v", d, err)
+	}
+	ip, err := c.IP(ctx, "192.0.2.1")
+	if err != nil || ip.Country != "ZZ" {
+		t.Fatalf("IP = %+v, %v", ip, err)
+	}
+	if _, err := c.Domain(ctx, "example.org"); errors.Cause(err) != ErrRDAPNoServer {
+		t.Fatalf("unknown tld: %v", err)
+	}
+	if _, err := c.Domain(ctx, "missing.com"); errors.Cause(err) != ErrRDAPStatus {
+		t.Fatalf("missing domain: %v", err)
+	}
+	// bootstrap 文件只下载一次
+	n := 0
+	for _, p := range paths {
+		if p == "/rdap/dns.json" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("dns bootstrap fetched %d times", n)
+	}
+}
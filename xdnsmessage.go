@@ -0,0 +1,29 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ToXMessage 转换为 golang.org/x/net/dns/dnsmessage 的消息, 便于在已经使用 dnsmessage 的代码中逐步引入 netx.
+// 转换经过线上格式, 所有字段与记录 (包括 OPT 与未知类型) 都不会丢失
+func ToXMessage(m *DNSMessage) (*dnsmessage.Message, error) {
+	b, err := m.ToByte()
+	if err != nil {
+		return nil, err
+	}
+	x := &dnsmessage.Message{}
+	if err := x.Unpack(b); err != nil {
+		return nil, errors.WithMessage(err, "dnsmessage unpack error")
+	}
+	return x, nil
+}
+
+// FromXMessage 由 dnsmessage 的消息转换, 名字去掉末尾的点, 与 Unpack 的结果相同
+func FromXMessage(x *dnsmessage.Message) (*DNSMessage, error) {
+	b, err := x.Pack()
+	if err != nil {
+		return nil, errors.WithMessage(err, "dnsmessage pack error")
+	}
+	return Unpack(b)
+}
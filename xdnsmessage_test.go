@@ -0,0 +1,50 @@
+package netx
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+	"net/netip"
+	"testing"
+)
+
+func TestXMessage(t *testing.T) {
+	m := benchMessage()
+	m.SetEDNS(1232, nil)
+	x, err := ToXMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ID != 0x1234 || !x.Response || !x.RecursionAvailable || len(x.Answers) != 4 || len(x.Authorities) != 1 || len(x.Additionals) != 1 {
+		t.Fatalf("x = %+v", x.Header)
+	}
+	if x.Questions[0].Name.String() != "www.example.com." || x.Answers[0].Header.Name.String() != "www.example.com." {
+		t.Fatalf("names = %v %v", x.Questions[0].Name, x.Answers[0].Header.Name)
+	}
+	if a, ok := x.Answers[1].Body.(*dnsmessage.AResource); !ok || netip.AddrFrom4(a.A).String() != "192.0.2.1" {
+		t.Fatalf("a = %+v", x.Answers[1].Body)
+	}
+	if opt, ok := x.Additionals[0].Body.(*dnsmessage.OPTResource); !ok || x.Additionals[0].Header.Class != 1232 || len(opt.Options) != 0 {
+		t.Fatalf("opt = %+v", x.Additionals[0])
+	}
+
+	back, err := FromXMessage(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := benchMessage()
+	want.SetEDNS(1232, nil)
+	want.ResourceRecodes[0].NamePos, want.ResourceRecodes[0].Name = 0, "www.example.com"
+	if !back.Equal(want) {
+		t.Fatalf("back = %+v", back)
+	}
+
+	// 从 dnsmessage 构造的查询
+	name := dnsmessage.MustNewName("example.org.")
+	q := &dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 7, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET}},
+	}
+	req, err := FromXMessage(q)
+	if err != nil || req.Header.TxID != 7 || req.Header.Flags.RD != 1 || req.Questions[0].QuestionName != "example.org" || req.Questions[0].QuestionType != DNSTypeAAAA {
+		t.Fatalf("req = %+v, %v", req, err)
+	}
+}
@@ -0,0 +1,693 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxCNAMEChain = 8
+
+var (
+	ErrZoneSyntax  = errors.New("zone file syntax error")
+	ErrOutOfZone   = errors.New("record is out of zone")
+	ErrUnknownType = errors.New("unknown record type")
+)
+
+// Zone 内存中的权威区域. 名字均为小写且不带末尾的点, 构建完成后可以并发查询
+type Zone struct {
+	Origin  string
+	records map[string][]*DNSResourceRecode
+	// nonTerminals 下面有记录的名字, 其中没有记录的是空非终端 (ENT)
+	nonTerminals map[string]bool
+}
+
+// NewZone 返回空区域
+func NewZone(origin string) *Zone {
+	return &Zone{Origin: normalizeDomain(origin), records: map[string][]*DNSResourceRecode{}, nonTerminals: map[string]bool{}}
+}
+
+// ZoneMatchKind 区域查找名字的结果
+type ZoneMatchKind int
+
+const (
+	ZoneNXDomain ZoneMatchKind = iota // 名字不存在, 也没有可用的通配符
+	ZoneExact                         // 名字本身有记录
+	ZoneWildcard                      // 由最近祖先下的通配符合成 (RFC 4592)
+	ZoneENT                           // 空非终端: 名字没有记录但下面有, 应答 NODATA
+)
+
+func (k ZoneMatchKind) String() string {
+	switch k {
+	case ZoneExact:
+		return "exact"
+	case ZoneWildcard:
+		return "wildcard"
+	case ZoneENT:
+		return "empty-non-terminal"
+	}
+	return "nxdomain"
+}
+
+// ZoneMatch Match 的结果, 供处理器构造否定应答或 DNSSEC 的不存在证明
+type ZoneMatch struct {
+	Kind ZoneMatchKind
+	// Owner 提供记录的名字, 通配时为 *.<ClosestEncloser>
+	Owner string
+	// ClosestEncloser 存在的最近祖先 (RFC 4592 closest encloser), Kind 为 ZoneExact 或 ZoneENT 时为名字本身
+	ClosestEncloser string
+	// Records Owner 上的全部记录, 通配时为副本且名字改为查询的名字
+	Records []*DNSResourceRecode
+}
+
+// Add 添加记录, rr.Name 必须是区域内的绝对名字
+func (z *Zone) Add(rr *DNSResourceRecode) error {
+	name := normalizeDomain(rr.Name)
+	if !inZone(name, z.Origin) {
+		return errors.WithMessage(ErrOutOfZone, rr.Name)
+	}
+	if rr.Class == 0 {
+		rr.Class = DNSClassIn
+	}
+	rr.Name = name
+	z.records[name] = append(z.records[name], rr)
+	for n := name; n != z.Origin && n != ""; {
+		n = parentName(n)
+		z.nonTerminals[n] = true
+	}
+	return nil
+}
+
+// Remove 删除与 rr 的名字, 类型, 类别和 RDATA 都相同的记录, 不比较 TTL, 返回是否删除
+func (z *Zone) Remove(rr *DNSResourceRecode) bool {
+	name := normalizeDomain(rr.Name)
+	rrs := z.records[name]
+	for i, r := range rrs {
+		if !sameRecord(r, rr) {
+			continue
+		}
+		if len(rrs) == 1 {
+			delete(z.records, name)
+			z.rebuildNonTerminals()
+		} else {
+			z.records[name] = append(append([]*DNSResourceRecode{}, rrs[:i]...), rrs[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+func (z *Zone) rebuildNonTerminals() {
+	z.nonTerminals = map[string]bool{}
+	for name := range z.records {
+		for n := name; n != z.Origin && n != ""; {
+			n = parentName(n)
+			z.nonTerminals[n] = true
+		}
+	}
+}
+
+// sameRecord 名字, 类型, 类别与 RDATA 是否相同, 类别为 0 时视为 IN
+func sameRecord(a, b *DNSResourceRecode) bool {
+	if normalizeDomain(a.Name) != normalizeDomain(b.Name) || a.RRType != b.RRType {
+		return false
+	}
+	ca, cb := a.Class, b.Class
+	if ca == 0 {
+		ca = DNSClassIn
+	}
+	if cb == 0 {
+		cb = DNSClassIn
+	}
+	if ca != cb {
+		return false
+	}
+	da, err := a.packRData()
+	if err != nil {
+		return false
+	}
+	db, err := b.packRData()
+	return err == nil && bytes.Equal(da, db)
+}
+
+// exists 名字是否存在, 包括空非终端
+func (z *Zone) exists(name string) bool {
+	_, ok := z.records[name]
+	return ok || z.nonTerminals[name]
+}
+
+// Match 按 RFC 4592 查找 name: 名字存在 (包括空非终端) 时不使用通配符, 否则只使用最近祖先下的 *.
+// 不检查子区域授权
+func (z *Zone) Match(name string) ZoneMatch {
+	name = normalizeDomain(name)
+	if rrs, ok := z.records[name]; ok {
+		return ZoneMatch{Kind: ZoneExact, Owner: name, ClosestEncloser: name, Records: rrs}
+	}
+	if z.nonTerminals[name] {
+		return ZoneMatch{Kind: ZoneENT, Owner: name, ClosestEncloser: name}
+	}
+	m := ZoneMatch{Kind: ZoneNXDomain}
+	for n := parentName(name); inZone(n, z.Origin); n = parentName(n) {
+		if z.exists(n) {
+			m.ClosestEncloser = n
+			break
+		}
+		if n == "" {
+			break
+		}
+	}
+	if m.ClosestEncloser == "" && !z.exists("") {
+		return m
+	}
+	wildcard := "*"
+	if m.ClosestEncloser != "" {
+		wildcard += "." + m.ClosestEncloser
+	}
+	rrs, ok := z.records[wildcard]
+	if !ok {
+		return m
+	}
+	m.Kind, m.Owner = ZoneWildcard, wildcard
+	for _, rr := range rrs {
+		c := *rr
+		c.Name = name
+		m.Records = append(m.Records, &c)
+	}
+	return m
+}
+
+// Records 返回 name 上 qtype 的记录, qtype 为 0 时返回全部. 不做通配符匹配
+func (z *Zone) Records(name string, qtype uint16) []*DNSResourceRecode {
+	return filterType(z.records[normalizeDomain(name)], qtype)
+}
+
+// Names 返回区域中所有有记录的名字
+func (z *Zone) Names() []string {
+	names := make([]string, 0, len(z.records))
+	for name := range z.records {
+		names = append(names, name)
+	}
+	return names
+}
+
+// SOA 返回区域顶点的 SOA 记录
+func (z *Zone) SOA() *DNSResourceRecode {
+	if soa := z.Records(z.Origin, DNSTypeSOA); len(soa) > 0 {
+		return soa[0]
+	}
+	return nil
+}
+
+// LookupRecords 实现 RecordSource, 支持通配符与空非终端
+func (z *Zone) LookupRecords(ctx context.Context, name string, qtype uint16) ([]*DNSResourceRecode, error) {
+	m := z.Match(name)
+	if m.Kind == ZoneNXDomain {
+		return nil, ErrRecordNotFound
+	}
+	return filterType(m.Records, qtype), nil
+}
+
+// filterType 返回 rrs 中 qtype 的记录, qtype 为 0 时返回全部
+func filterType(rrs []*DNSResourceRecode, qtype uint16) []*DNSResourceRecode {
+	var result []*DNSResourceRecode
+	for _, rr := range rrs {
+		if qtype == 0 || rr.RRType == qtype {
+			result = append(result, rr)
+		}
+	}
+	return result
+}
+
+// delegation 返回 name 路径上顶点之下最近的 NS 记录, 即子区域的授权
+func (z *Zone) delegation(name string) []*DNSResourceRecode {
+	var cut []*DNSResourceRecode
+	for n := name; n != z.Origin && inZone(n, z.Origin); n = parentName(n) {
+		if ns := z.Records(n, DNSTypeNS); len(ns) > 0 {
+			cut = ns
+		}
+	}
+	return cut
+}
+
+// parentName 去掉最左边的 label
+func parentName(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// ServeDNS 权威应答: 在区域内跟随 CNAME, 子区域返回引荐, 否定应答在授权部分附带 SOA.
+// 通配符与空非终端按 Match 处理
+func (z *Zone) ServeDNS(ctx context.Context, req *DNSRequest) (*DNSMessage, error) {
+	resp := NewReply(req.Message)
+	if len(req.Message.Questions) != 1 || !inZone(req.Message.Questions[0].QuestionName, z.Origin) {
+		resp.Header.Flags.RCode = DNSRCodeRefused
+		return resp, nil
+	}
+	q := req.Message.Questions[0]
+	name := normalizeDomain(q.QuestionName)
+	var answers, authority, additional []*DNSResourceRecode
+	resp.Header.Flags.AA = 1
+	for i := 0; ; i++ {
+		if ns := z.delegation(name); ns != nil {
+			// 引荐不是权威应答, 附带区域内的胶水记录
+			resp.Header.Flags.AA = 0
+			authority = ns
+			for _, rr := range ns {
+				additional = append(additional, z.Records(rr.RData, DNSTypeA)...)
+				additional = append(additional, z.Records(rr.RData, DNSTypeAAAA)...)
+			}
+			break
+		}
+		m := z.Match(name)
+		if m.Kind == ZoneNXDomain {
+			// CNAME 链的目标不存在时同样为 NXDOMAIN (RFC 6604)
+			resp.Header.Flags.RCode = DNSRCodeNXDomain
+			authority = z.negative()
+			break
+		}
+		if rrs := filterType(m.Records, q.QuestionType); len(rrs) > 0 {
+			answers = append(answers, rrs...)
+			break
+		}
+		cname := filterType(m.Records, DNSTypeCName)
+		if len(cname) == 0 || q.QuestionType == DNSTypeCName {
+			authority = z.negative()
+			break
+		}
+		answers = append(answers, cname[0])
+		name = normalizeDomain(cname[0].RData)
+		if !inZone(name, z.Origin) || i >= maxCNAMEChain {
+			break
+		}
+	}
+	resp.ResourceRecodes = append(append(append(resp.ResourceRecodes, answers...), authority...), additional...)
+	resp.Header.AnswerRRs = uint16(len(answers))
+	resp.Header.AuthorityRRs = uint16(len(authority))
+	resp.Header.AdditionalRRs = uint16(len(additional))
+	return resp, nil
+}
+
+// negative 否定应答的 SOA, TTL 取 SOA 本身 TTL 与 minimum 中较小的一个 (RFC 2308)
+func (z *Zone) negative() []*DNSResourceRecode {
+	soa := z.SOA()
+	if soa == nil {
+		return nil
+	}
+	rr := *soa
+	fields := strings.Fields(rr.RData)
+	if len(fields) == 7 {
+		if minimum, err := strconv.ParseUint(fields[6], 10, 32); err == nil && uint32(minimum) < rr.TTL {
+			rr.TTL = uint32(minimum)
+		}
+	}
+	return []*DNSResourceRecode{&rr}
+}
+
+// ParseZone 解析主文件格式 (RFC 1035) 的区域, 支持 $ORIGIN, $TTL, 括号续行, 省略名字/TTL/类别.
+// origin 为 $ORIGIN 出现之前的初始值, 同时作为区域顶点
+func ParseZone(r io.Reader, origin string) (*Zone, error) {
+	z := NewZone(origin)
+	p := &zoneParser{origin: z.Origin, ttl: 3600}
+	scanner := bufio.NewScanner(r)
+	var pending []string
+	depth, start := 0, 0
+	for line := 1; scanner.Scan(); line++ {
+		tokens, open, err := tokenizeZoneLine(scanner.Text())
+		if err != nil {
+			return nil, errors.WithMessagef(err, "line %d", line)
+		}
+		if depth == 0 {
+			start = line
+			// 以空白开头的行沿用上一条记录的名字
+			if len(tokens) > 0 && scanner.Text() != "" && (scanner.Text()[0] == ' ' || scanner.Text()[0] == '\t') {
+				tokens = append([]string{""}, tokens...)
+			}
+		}
+		pending = append(pending, tokens...)
+		if depth += open; depth < 0 {
+			return nil, errors.WithMessagef(ErrZoneSyntax, "line %d: unbalanced parentheses", line)
+		}
+		if depth > 0 || len(pending) == 0 {
+			continue
+		}
+		rr, err := p.parse(pending)
+		pending = nil
+		if err != nil {
+			return nil, errors.WithMessagef(err, "line %d", start)
+		}
+		if rr == nil {
+			continue
+		}
+		if err := z.Add(rr); err != nil {
+			return nil, errors.WithMessagef(err, "line %d", start)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, errors.WithMessage(ErrZoneSyntax, "unclosed parentheses")
+	}
+	return z, nil
+}
+
+// WriteTo 按规范顺序以主文件格式写出区域的全部记录, 名字都写作绝对名字, 顶点的 SOA 在最前.
+// 没有文本格式的类型按 RFC 3597 写作 \# 与十六进制的 RDATA, ParseZone 可以读回
+func (z *Zone) WriteTo(w io.Writer) (int64, error) {
+	names := z.Names()
+	sort.Slice(names, func(i, j int) bool { return canonicalCompare(names[i], names[j]) < 0 })
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, name := range names {
+		rrs := append([]*DNSResourceRecode{}, z.records[name]...)
+		order := func(t uint16) int {
+			if t == DNSTypeSOA {
+				return -1
+			}
+			return int(t)
+		}
+		sort.SliceStable(rrs, func(i, j int) bool { return order(rrs[i].RRType) < order(rrs[j].RRType) })
+		for _, rr := range rrs {
+			m, err := bw.WriteString(FormatRR(rr) + "\n")
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, bw.Flush()
+}
+
+// FormatRR 以主文件格式的一行返回记录, 不带换行
+func FormatRR(rr *DNSResourceRecode) string {
+	return absoluteName(rr.Name) + "\t" + strconv.FormatUint(uint64(rr.TTL), 10) + "\t" + DNSClass(rr.Class).String() +
+		"\t" + DNSType(rr.RRType).String() + "\t" + rdataText(rr)
+}
+
+// absoluteName 返回带末尾点的名字
+func absoluteName(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// rdataText 返回 RDATA 的主文件格式
+func rdataText(rr *DNSResourceRecode) string {
+	if rr.Data == nil {
+		fields := strings.Fields(rr.RData)
+		for _, i := range zoneNameFields[rr.RRType] {
+			if i < len(fields) {
+				fields[i] = absoluteName(fields[i])
+			}
+		}
+		return strings.Join(fields, " ")
+	}
+	if rr.RRType == DNSTypeTXT && len(rr.Data) > 0 {
+		var parts []string
+		for data := rr.Data; len(data) > 0; data = data[1+int(data[0]):] {
+			if 1+int(data[0]) > len(data) {
+				parts = nil
+				break
+			}
+			s := string(data[1 : 1+int(data[0])])
+			if strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r > 0x7e }) >= 0 {
+				// 主文件解析不支持 \DDD 转义
+				parts = nil
+				break
+			}
+			parts = append(parts, `"`+strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)+`"`)
+		}
+		if parts != nil {
+			return strings.Join(parts, " ")
+		}
+	}
+	return `\# ` + strconv.Itoa(len(rr.Data)) + " " + hex.EncodeToString(rr.Data)
+}
+
+// ParseRR 解析一行主文件格式的记录, 相对名字以 origin 补全, 省略 TTL 时使用 ttl
+func ParseRR(line, origin string, ttl uint32) (*DNSResourceRecode, error) {
+	tokens, open, err := tokenizeZoneLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if open != 0 || len(tokens) == 0 || strings.HasPrefix(tokens[0], "$") {
+		return nil, ErrZoneSyntax
+	}
+	return (&zoneParser{origin: normalizeDomain(origin), ttl: ttl}).parse(tokens)
+}
+
+type zoneParser struct {
+	origin string
+	ttl    uint32
+	last   string // 上一条记录的名字
+}
+
+// absolute 将相对名字补全为绝对名字
+func (p *zoneParser) absolute(name string) string {
+	switch {
+	case name == "@":
+		return p.origin
+	case strings.HasSuffix(name, "."):
+		return normalizeDomain(name)
+	case p.origin == "":
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name) + "." + p.origin
+}
+
+// parse 解析一条记录或指令, 指令返回空记录
+func (p *zoneParser) parse(tokens []string) (*DNSResourceRecode, error) {
+	switch strings.ToUpper(tokens[0]) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return nil, ErrZoneSyntax
+		}
+		p.origin = p.absolute(tokens[1])
+		return nil, nil
+	case "$TTL":
+		if len(tokens) != 2 {
+			return nil, ErrZoneSyntax
+		}
+		ttl, err := parseTTL(tokens[1])
+		if err != nil {
+			return nil, err
+		}
+		p.ttl = ttl
+		return nil, nil
+	}
+	rr := &DNSResourceRecode{Class: DNSClassIn, TTL: p.ttl}
+	if tokens[0] == "" {
+		rr.Name = p.last
+	} else {
+		rr.Name = p.absolute(tokens[0])
+	}
+	if rr.Name == "" && tokens[0] == "" {
+		return nil, errors.WithMessage(ErrZoneSyntax, "missing owner name")
+	}
+	p.last = rr.Name
+	rest := tokens[1:]
+	// TTL 与类别可以任意顺序出现
+	for len(rest) > 0 {
+		if strings.EqualFold(rest[0], "IN") {
+			rest = rest[1:]
+			continue
+		}
+		if ttl, err := parseTTL(rest[0]); err == nil {
+			rr.TTL = ttl
+			rest = rest[1:]
+			continue
+		}
+		break
+	}
+	if len(rest) == 0 {
+		return nil, errors.WithMessage(ErrZoneSyntax, "missing record type")
+	}
+	rtype, err := ParseType(rest[0])
+	generic := len(rest) > 1 && rest[1] == `\#`
+	if err != nil || !generic && !zoneTypes[uint16(rtype)] {
+		return nil, errors.WithMessage(ErrUnknownType, rest[0])
+	}
+	rr.RRType = uint16(rtype)
+	if generic {
+		if rr.Data, err = parseGenericRData(rest[2:]); err != nil {
+			return nil, errors.WithMessage(err, strings.ToUpper(rest[0]))
+		}
+		return rr, nil
+	}
+	if err := p.rdata(rr, rest[1:]); err != nil {
+		return nil, errors.WithMessage(err, strings.ToUpper(rest[0]))
+	}
+	return rr, nil
+}
+
+// zoneTypes 区域文件支持的记录类型
+var zoneTypes = map[uint16]bool{
+	DNSTypeA: true, DNSTypeNS: true, DNSTypeCName: true, DNSTypeSOA: true, DNSTypePTR: true,
+	DNSTypeMX: true, DNSTypeTXT: true, DNSTypeAAAA: true, DNSTypeSRV: true, DNSTypeZONEMD: true,
+}
+
+// zoneNameFields 每种文本 RData 类型中名字字段的位置
+var zoneNameFields = map[uint16][]int{
+	DNSTypeNS: {0}, DNSTypeCName: {0}, DNSTypePTR: {0}, DNSTypeMX: {1}, DNSTypeSRV: {3}, DNSTypeSOA: {0, 1},
+}
+
+// parseGenericRData 解析 RFC 3597 5 的 \# 长度 十六进制数据
+func parseGenericRData(fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return nil, ErrBadRData
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, ErrBadRData
+	}
+	data, err := hex.DecodeString(strings.Join(fields[1:], ""))
+	if err != nil || len(data) != n {
+		return nil, ErrBadRData
+	}
+	return append([]byte{}, data...), nil
+}
+
+// rdata 按类型检查字段数量, 补全其中的名字
+func (p *zoneParser) rdata(rr *DNSResourceRecode, fields []string) error {
+	nameFields := zoneNameFields[rr.RRType]
+	count := 1
+	switch rr.RRType {
+	case DNSTypeMX:
+		count = 2
+	case DNSTypeSRV:
+		count = 4
+	case DNSTypeSOA:
+		count = 7
+	case DNSTypeTXT:
+		if len(fields) == 0 {
+			return ErrBadRData
+		}
+		rr.Data = nil
+		for _, s := range fields {
+			if len(s) > 255 {
+				return ErrBadRData
+			}
+			rr.Data = append(append(rr.Data, byte(len(s))), s...)
+		}
+		return nil
+	case DNSTypeZONEMD:
+		// 序列号 方案 算法 十六进制摘要, 摘要可以分成多段
+		if len(fields) < 4 {
+			return ErrBadRData
+		}
+		serial, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return ErrBadRData
+		}
+		scheme, err1 := strconv.ParseUint(fields[1], 10, 8)
+		alg, err2 := strconv.ParseUint(fields[2], 10, 8)
+		digest, err3 := hex.DecodeString(strings.Join(fields[3:], ""))
+		if err1 != nil || err2 != nil || err3 != nil {
+			return ErrBadRData
+		}
+		rr.Data = (&ZONEMD{Serial: uint32(serial), Scheme: uint8(scheme), HashAlgorithm: uint8(alg), Digest: digest}).Pack()
+		return nil
+	}
+	if len(fields) != count {
+		return ErrBadRData
+	}
+	for _, i := range nameFields {
+		fields[i] = p.absolute(fields[i])
+		if fields[i] == "" {
+			fields[i] = "."
+		}
+	}
+	if rr.RRType == DNSTypeSOA {
+		for i := 2; i < 7; i++ {
+			v, err := parseTTL(fields[i])
+			if err != nil {
+				return err
+			}
+			fields[i] = strconv.FormatUint(uint64(v), 10)
+		}
+	}
+	rr.RData = strings.Join(fields, " ")
+	// 编码一次以检查 RData 格式
+	_, err := rr.packRData()
+	return err
+}
+
+// parseTTL 解析秒数或带 s/m/h/d/w 单位的时间, 如 1h30m
+func parseTTL(s string) (uint32, error) {
+	if s == "" {
+		return 0, ErrZoneSyntax
+	}
+	var total, n uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n, digits = n*10+uint64(c-'0'), true
+			if n > 1<<32 {
+				return 0, ErrZoneSyntax
+			}
+			continue
+		}
+		unit, ok := map[rune]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}[c]
+		if !ok || !digits {
+			return 0, ErrZoneSyntax
+		}
+		total, n, digits = total+n*unit, 0, false
+	}
+	total += n
+	if total > 0xFFFFFFFF {
+		return 0, ErrZoneSyntax
+	}
+	return uint32(total), nil
+}
+
+// tokenizeZoneLine 按空白拆分, 保留引号中的内容, 去掉 ; 之后的注释. 返回括号的净打开数
+func tokenizeZoneLine(line string) ([]string, int, error) {
+	var tokens []string
+	open := 0
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			return tokens, open, nil
+		case c == '(':
+			open++
+			i++
+		case c == ')':
+			open--
+			i++
+		case c == '"':
+			var sb strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				sb.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, 0, errors.WithMessage(ErrZoneSyntax, "unterminated quoted string")
+			}
+			i++
+			tokens = append(tokens, sb.String())
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+	return tokens, open, nil
+}
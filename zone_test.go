@@ -0,0 +1,171 @@
+package netx
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const testZone = `
+$ORIGIN example.com.
+$TTL 1h
+@       IN SOA ns1 hostmaster (
+                2024010101 ; serial
+                1h 15m 1w 300 )
+        IN NS  ns1
+ns1     IN A   192.0.2.53
+www  60 IN A   192.0.2.1
+        IN AAAA 2001:db8::1
+alias   IN CNAME www
+out     IN CNAME www.example.net.
+mail    IN MX  10 mx.example.net.
+txt     IN TXT "v=spf1 -all" "second; part"
+_sip._udp IN SRV 0 5 5060 sip
+sub     IN NS  ns.sub
+ns.sub  IN A   192.0.2.54
+`
+
+func TestZone(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(testZone), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if soa := z.SOA(); soa == nil || soa.RData != "ns1.example.com hostmaster.example.com 2024010101 3600 900 604800 300" || soa.TTL != 3600 {
+		t.Fatalf("soa = %+v", soa)
+	}
+	if rr := z.Records("www.example.com", DNSTypeAAAA); len(rr) != 1 || rr[0].TTL != 3600 {
+		t.Fatalf("aaaa = %+v", rr)
+	}
+	if rr := z.Records("txt.example.com", DNSTypeTXT); len(rr) != 1 || string(rr[0].Data) != "\x0bv=spf1 -all\x0csecond; part" {
+		t.Fatalf("txt = %q", rr[0].Data)
+	}
+	if rr := z.Records("_sip._udp.example.com", DNSTypeSRV); len(rr) != 1 || rr[0].RData != "0 5 5060 sip.example.com" {
+		t.Fatalf("srv = %+v", rr)
+	}
+
+	serve := func(name string, qtype uint16) *DNSMessage {
+		resp, err := z.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery(name, qtype)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 编码后再解析, 确认记录格式正确
+		b, err := resp.ToByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, err = Unpack(b); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := serve("ALIAS.example.com", DNSTypeA)
+	if resp.Header.Flags.AA != 1 || len(resp.Answers()) != 2 || resp.Answers()[1].RData != "192.0.2.1" || resp.Answers()[1].TTL != 60 {
+		t.Fatalf("alias = %+v", resp.Answers())
+	}
+	if resp := serve("out.example.com", DNSTypeA); len(resp.Answers()) != 1 || resp.Header.Flags.RCode != 0 {
+		t.Fatalf("out = %+v", resp.Answers())
+	}
+	resp = serve("nope.example.com", DNSTypeA)
+	if resp.Header.Flags.RCode != 3 || len(resp.Authorities()) != 1 || resp.Authorities()[0].TTL != 300 {
+		t.Fatalf("nxdomain = %+v", resp.Header.Flags)
+	}
+	if resp := serve("www.example.com", DNSTypeMX); resp.Header.Flags.RCode != 0 || len(resp.Answers()) != 0 || len(resp.Authorities()) != 1 {
+		t.Fatal("nodata")
+	}
+	resp = serve("host.sub.example.com", DNSTypeA)
+	if resp.Header.Flags.AA != 0 || len(resp.Authorities()) != 1 || len(resp.Additionals()) != 1 || resp.Additionals()[0].RData != "192.0.2.54" {
+		t.Fatalf("referral = %+v", resp)
+	}
+	if resp := serve("www.example.org", DNSTypeA); resp.Header.Flags.RCode != 5 {
+		t.Fatal("out of zone")
+	}
+
+	for _, bad := range []string{"www IN A 999.0.0.1", "www IN BOGUS x", "www IN MX 10", `www IN TXT "open`} {
+		if _, err := ParseRR(bad, "example.com", 60); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+	if _, err := ParseZone(strings.NewReader("www.example.net. IN A 192.0.2.1"), "example.com"); err == nil {
+		t.Fatal("out of zone record accepted")
+	}
+}
+
+// RFC 4592 2.2.1 的示例区域
+const wildcardZone = `
+$ORIGIN example.
+@                 SOA ns1 hostmaster 1 1h 15m 1w 300
+                  NS  ns1
+ns1               A   192.0.2.53
+*                 TXT "this is a wildcard"
+                  MX  10 host1
+host1             A   192.0.2.1
+_ssh._tcp.host1   SRV 0 0 22 host1
+_ssh._tcp.host2   SRV 0 0 22 host2
+subdel            NS  ns.subdel
+*.alias           CNAME host1
+`
+
+func TestZoneWildcard(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(wildcardZone), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name  string
+		kind  ZoneMatchKind
+		owner string
+		ce    string
+	}{
+		{"host1.example", ZoneExact, "host1.example", "host1.example"},
+		{"host3.example", ZoneWildcard, "*.example", "example"},
+		{"foo.bar.example", ZoneWildcard, "*.example", "example"},
+		{"_tcp.host1.example", ZoneENT, "_tcp.host1.example", "_tcp.host1.example"},
+		{"host2.example", ZoneENT, "host2.example", "host2.example"},
+		{"_telnet._tcp.host1.example", ZoneNXDomain, "", "_tcp.host1.example"},
+		{"ghost.*.example", ZoneNXDomain, "", "*.example"},
+		{"*.example", ZoneExact, "*.example", "*.example"},
+		{"x.alias.example", ZoneWildcard, "*.alias.example", "alias.example"},
+	} {
+		m := z.Match(c.name)
+		if m.Kind != c.kind || m.Owner != c.owner || m.ClosestEncloser != c.ce {
+			t.Fatalf("%s: %s %q %q", c.name, m.Kind, m.Owner, m.ClosestEncloser)
+		}
+	}
+	if m := z.Match("host3.example"); len(m.Records) != 2 || m.Records[0].Name != "host3.example" || z.Records("*.example", 0)[0].Name != "*.example" {
+		t.Fatalf("synthesized = %+v", m.Records)
+	}
+
+	serve := func(name string, qtype uint16) *DNSMessage {
+		resp, err := z.ServeDNS(context.Background(), &DNSRequest{Message: NewQuery(name, qtype)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := serve("host3.example", DNSTypeMX)
+	if resp.Header.Flags.RCode != 0 || len(resp.Answers()) != 1 || resp.Answers()[0].Name != "host3.example" {
+		t.Fatalf("wildcard = %+v", resp.Answers())
+	}
+	if resp := serve("host3.example", DNSTypeA); resp.Header.Flags.RCode != 0 || len(resp.Answers()) != 0 || len(resp.Authorities()) != 1 {
+		t.Fatal("wildcard nodata")
+	}
+	if resp := serve("host2.example", DNSTypeTXT); resp.Header.Flags.RCode != 0 || len(resp.Answers()) != 0 || len(resp.Authorities()) != 1 {
+		t.Fatal("empty non-terminal must not be nxdomain or match the wildcard")
+	}
+	if resp := serve("_telnet._tcp.host1.example", DNSTypeTXT); resp.Header.Flags.RCode != 3 {
+		t.Fatal("wildcard must not match below an existing name")
+	}
+	resp = serve("x.alias.example", DNSTypeA)
+	if len(resp.Answers()) != 2 || resp.Answers()[0].Name != "x.alias.example" || resp.Answers()[1].RData != "192.0.2.1" {
+		t.Fatalf("wildcard cname = %+v", resp.Answers())
+	}
+	if resp := serve("host.subdel.example", DNSTypeTXT); resp.Header.Flags.AA != 0 || len(resp.Answers()) != 0 {
+		t.Fatal("wildcard must not match below a delegation")
+	}
+	if rrs, err := z.LookupRecords(context.Background(), "host2.example", DNSTypeA); err != nil || len(rrs) != 0 {
+		t.Fatal("ent lookup")
+	}
+	if _, err := z.LookupRecords(context.Background(), "a._tcp.host2.example", DNSTypeA); err != ErrRecordNotFound {
+		t.Fatal("nxdomain lookup")
+	}
+}
@@ -0,0 +1,260 @@
+package netx
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultJournalSize    = 1 << 20
+	defaultJournalEntries = 1000
+)
+
+var ErrBadJournal = errors.New("corrupt zone journal")
+
+// ZoneJournal 动态区域的持久化: Path 按顺序追加每次更新的 ZoneDiff, Path + ".snapshot" 保存某一时刻的整个区域.
+// 日志超过 MaxSize 字节或 MaxEntries 条时写入新的快照并清空日志. 每条记录带 CRC,
+// 启动时丢弃末尾不完整的记录 (写入过程中崩溃), 跳过快照中已经包含的更新
+type ZoneJournal struct {
+	Path string
+	// MaxSize 默认 1 MiB
+	MaxSize int64
+	// MaxEntries 默认 1000
+	MaxEntries int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	entries int
+}
+
+func (j *ZoneJournal) snapshotPath() string {
+	return j.Path + ".snapshot"
+}
+
+// Load 读取快照并重放日志, 没有快照时以 initial 为起点. 之后 Append 追加到日志末尾
+func (j *ZoneJournal) Load(initial *Zone) (*Zone, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	z := initial
+	if data, err := os.ReadFile(j.snapshotPath()); err == nil {
+		snapshot, _, err := decodeJournalEntry(data)
+		if err != nil {
+			return nil, errors.WithMessage(err, "read snapshot")
+		}
+		origin := ""
+		for _, rr := range snapshot.Added {
+			if rr.RRType == DNSTypeSOA {
+				origin = rr.Name
+			}
+		}
+		z = NewZone(origin)
+		applyDiff(z, snapshot)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if z == nil || z.SOA() == nil {
+		return nil, ErrNoSOA
+	}
+
+	f, err := os.OpenFile(j.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	off, entries := 0, 0
+	for off < len(data) {
+		diff, n, err := decodeJournalEntry(data[off:])
+		if err != nil {
+			// 末尾不完整的记录是写入时崩溃留下的, 截断; 之后还有数据时日志已经损坏
+			if len(data)-off >= 8 && off+8+int(binary.BigEndian.Uint32(data[off:])) < len(data) {
+				_ = f.Close()
+				return nil, err
+			}
+			break
+		}
+		current, _ := parseSOATimers(z.SOA())
+		from, to, ok := diff.serials()
+		switch {
+		case !ok:
+			_ = f.Close()
+			return nil, errors.WithMessage(ErrBadJournal, "entry without soa change")
+		case from == current.serial:
+			applyDiff(z, diff)
+		case SerialLess(current.serial, to):
+			_ = f.Close()
+			return nil, errors.WithMessage(ErrBadJournal, "gap in serials")
+		}
+		off += n
+		entries++
+	}
+	if err := f.Truncate(int64(off)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(int64(off), io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if j.file != nil {
+		_ = j.file.Close()
+	}
+	j.file, j.size, j.entries = f, int64(off), entries
+	return z, nil
+}
+
+// Append 追加一次更新并同步到磁盘
+func (j *ZoneJournal) Append(diff *ZoneDiff) error {
+	entry, err := encodeJournalEntry(diff)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		if j.file, err = os.OpenFile(j.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return err
+		}
+	}
+	if _, err := j.file.Write(entry); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.size += int64(len(entry))
+	j.entries++
+	return nil
+}
+
+// compact 日志超过上限时写入 z 的快照
+func (j *ZoneJournal) compact(z *Zone) error {
+	max, maxEntries := j.MaxSize, j.MaxEntries
+	if max <= 0 {
+		max = defaultJournalSize
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultJournalEntries
+	}
+	j.mu.Lock()
+	over := j.size > max || j.entries >= maxEntries
+	j.mu.Unlock()
+	if !over {
+		return nil
+	}
+	return j.Snapshot(z)
+}
+
+// Snapshot 把整个区域写入快照文件 (先写临时文件再改名), 然后清空日志. 调用者需保证期间 z 不被修改
+func (j *ZoneJournal) Snapshot(z *Zone) error {
+	names := z.Names()
+	sort.Strings(names)
+	snapshot := &ZoneDiff{}
+	for _, name := range names {
+		snapshot.Added = append(snapshot.Added, z.Records(name, 0)...)
+	}
+	entry, err := encodeJournalEntry(snapshot)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(j.Path), filepath.Base(j.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(entry); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.snapshotPath())
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	// 快照已经包含日志中的全部更新, 在这里崩溃时重放会跳过它们
+	if j.file != nil {
+		if err := j.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	j.size, j.entries = 0, 0
+	return nil
+}
+
+// Close 关闭日志文件
+func (j *ZoneJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// encodeJournalEntry 编码为 长度(4) CRC32(4) 删除数(4) 添加数(4) 记录..., 记录为不压缩的线上格式
+func encodeJournalEntry(diff *ZoneDiff) ([]byte, error) {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(diff.Deleted)))
+	binary.BigEndian.PutUint32(b[12:], uint32(len(diff.Added)))
+	var err error
+	for _, rr := range append(append([]*DNSResourceRecode{}, diff.Deleted...), diff.Added...) {
+		c := *rr
+		c.NamePos = 0
+		if b, err = c.appendTo(b); err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-8))
+	binary.BigEndian.PutUint32(b[4:], crc32.ChecksumIEEE(b[8:]))
+	return b, nil
+}
+
+// decodeJournalEntry 返回 data 开头的一条记录与它的长度
+func decodeJournalEntry(data []byte) (*ZoneDiff, int, error) {
+	if len(data) < 16 {
+		return nil, 0, ErrBadJournal
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 8 || len(data)-8 < size || crc32.ChecksumIEEE(data[8:8+size]) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, 0, ErrBadJournal
+	}
+	payload := data[8 : 8+size]
+	deleted, added := int(binary.BigEndian.Uint32(payload)), int(binary.BigEndian.Uint32(payload[4:]))
+	u := &unpacker{msg: payload, off: 8}
+	diff := &ZoneDiff{}
+	for i := 0; i < deleted+added; i++ {
+		rr, err := u.resource()
+		if err != nil {
+			return nil, 0, errors.WithMessage(ErrBadJournal, err.Error())
+		}
+		if i < deleted {
+			diff.Deleted = append(diff.Deleted, rr)
+		} else {
+			diff.Added = append(diff.Added, rr)
+		}
+	}
+	if u.off != len(payload) {
+		return nil, 0, ErrBadJournal
+	}
+	return diff, 8 + size, nil
+}
@@ -0,0 +1,106 @@
+package netx
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestZoneJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.journal")
+	open := func(j *ZoneJournal) *DynamicZone {
+		t.Helper()
+		d, err := OpenDynamicZone(parseDynamicZone(t), j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = j.Close() })
+		return d
+	}
+	add := func(d *DynamicZone, name, ip string) {
+		t.Helper()
+		if err := d.Apply(&ZoneDiff{Added: []*DNSResourceRecode{aRecord(name, ip)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := open(&ZoneJournal{Path: path})
+	add(d, "a.example", "192.0.2.10")
+	add(d, "b.example", "192.0.2.11")
+	if err := d.Apply(&ZoneDiff{Deleted: []*DNSResourceRecode{aRecord("www.example", "192.0.2.1")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Apply(&ZoneDiff{Added: []*DNSResourceRecode{aRecord("a.other", "192.0.2.1")}}); err == nil {
+		t.Fatal("out of zone record accepted")
+	}
+	_ = d.Journal.Close()
+
+	// 重启后重放日志
+	d = open(&ZoneJournal{Path: path})
+	if d.Serial() != 13 || len(d.Records("a.example", DNSTypeA)) != 1 || len(d.Records("www.example", DNSTypeA)) != 1 {
+		t.Fatalf("replay: serial %d", d.Serial())
+	}
+
+	// 末尾不完整的记录被丢弃
+	add(d, "c.example", "192.0.2.12")
+	_ = d.Journal.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	d = open(&ZoneJournal{Path: path})
+	if d.Serial() != 13 || len(d.Records("c.example", 0)) != 0 {
+		t.Fatalf("torn entry replayed: serial %d", d.Serial())
+	}
+	add(d, "c.example", "192.0.2.13")
+	_ = d.Journal.Close()
+	d = open(&ZoneJournal{Path: path})
+	if got := d.Records("c.example", DNSTypeA); d.Serial() != 14 || len(got) != 1 || got[0].RData != "192.0.2.13" {
+		t.Fatalf("append after truncation: serial %d, %v", d.Serial(), got)
+	}
+	_ = d.Journal.Close()
+
+	// 超过条数时写入快照并清空日志
+	d = open(&ZoneJournal{Path: path, MaxEntries: 3})
+	for i := 0; i < 5; i++ {
+		add(d, "n"+strconv.Itoa(i)+".example", "192.0.2.20")
+	}
+	if _, err := os.Stat(path + ".snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() == 0 {
+		t.Fatal("entries after the snapshot were lost")
+	}
+	_ = d.Journal.Close()
+	// 快照之后日志没有清空 (在改名与清空之间崩溃) 时跳过已经包含的更新
+	journal, _ := os.ReadFile(path)
+	d = open(&ZoneJournal{Path: path})
+	if d.Serial() != 19 || len(d.Records("n4.example", DNSTypeA)) != 1 || len(d.Records("a.example", DNSTypeA)) != 1 {
+		t.Fatalf("snapshot + journal: serial %d", d.Serial())
+	}
+	if err := d.Journal.Snapshot(d.zone); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Journal.Close()
+	if err := os.WriteFile(path, journal, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if d = open(&ZoneJournal{Path: path}); d.Serial() != 19 {
+		t.Fatalf("stale journal replayed: serial %d", d.Serial())
+	}
+	_ = d.Journal.Close()
+
+	// 中间损坏的日志报错
+	corrupt := append([]byte{}, journal...)
+	corrupt[10] ^= 0xff
+	if err := os.WriteFile(path, append(corrupt, journal...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDynamicZone(parseDynamicZone(t), &ZoneJournal{Path: path}); err == nil {
+		t.Fatal("corrupt journal accepted")
+	}
+}
@@ -0,0 +1,204 @@
+package netx
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash"
+	"sort"
+)
+
+// ZONEMD 的方案与散列算法 (RFC 8976 5)
+const (
+	ZONEMDSchemeSimple = 1
+
+	ZONEMDHashSHA384 = 1
+	ZONEMDHashSHA512 = 2
+)
+
+// zonemdMinDigest 验证时摘要的最短长度 (RFC 8976 2.2.4)
+const zonemdMinDigest = 12
+
+var (
+	ErrNoZONEMD          = errors.New("zone has no zonemd record")
+	ErrZONEMDUnsupported = errors.New("no usable zonemd record")
+	ErrZONEMDMismatch    = errors.New("zone digest mismatch")
+)
+
+// ZONEMD 区域摘要记录 (RFC 8976)
+type ZONEMD struct {
+	Serial        uint32
+	Scheme        uint8
+	HashAlgorithm uint8
+	Digest        []byte
+}
+
+// ParseZONEMD 解析 ZONEMD 记录的 RDATA
+func ParseZONEMD(data []byte) (*ZONEMD, error) {
+	if len(data) < 6 {
+		return nil, ErrBadRData
+	}
+	return &ZONEMD{
+		Serial:        binary.BigEndian.Uint32(data),
+		Scheme:        data[4],
+		HashAlgorithm: data[5],
+		Digest:        append([]byte{}, data[6:]...),
+	}, nil
+}
+
+// Pack 编码为 RDATA
+func (z *ZONEMD) Pack() []byte {
+	b := appendUint32(nil, z.Serial)
+	return append(append(b, z.Scheme, z.HashAlgorithm), z.Digest...)
+}
+
+func zonemdHash(alg uint8) hash.Hash {
+	switch alg {
+	case ZONEMDHashSHA384:
+		return sha512.New384()
+	case ZONEMDHashSHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+// ZoneDigest 按 SIMPLE 方案 (RFC 8976 3.3) 计算区域的摘要: 全部记录 (包括胶水) 的规范形式按规范顺序
+// 相连后散列, 不包括顶点的 ZONEMD 与覆盖它的 RRSIG, 重复的记录只计一次
+func ZoneDigest(z *Zone, hashAlg uint8) ([]byte, error) {
+	h := zonemdHash(hashAlg)
+	if h == nil {
+		return nil, errors.WithMessage(ErrZONEMDUnsupported, "unknown hash algorithm")
+	}
+	names := z.Names()
+	sort.Slice(names, func(i, j int) bool { return canonicalCompare(names[i], names[j]) < 0 })
+	for _, name := range names {
+		owner, err := appendName(nil, name)
+		if err != nil {
+			return nil, err
+		}
+		type entry struct {
+			rrtype uint16
+			rdata  []byte
+			rr     *DNSResourceRecode
+		}
+		var entries []entry
+		for _, rr := range z.records[name] {
+			if name == z.Origin && (rr.RRType == DNSTypeZONEMD || rr.RRType == DNSTypeRRSIG && coveredType(rr) == DNSTypeZONEMD) {
+				continue
+			}
+			rdata, err := canonicalRData(rr)
+			if err != nil {
+				return nil, errors.WithMessage(err, name+"/"+DNSType(rr.RRType).String())
+			}
+			entries = append(entries, entry{rr.RRType, rdata, rr})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].rrtype != entries[j].rrtype {
+				return entries[i].rrtype < entries[j].rrtype
+			}
+			return bytes.Compare(entries[i].rdata, entries[j].rdata) < 0
+		})
+		for i, e := range entries {
+			if i > 0 && e.rrtype == entries[i-1].rrtype && bytes.Equal(e.rdata, entries[i-1].rdata) {
+				continue
+			}
+			b := appendUint16(append([]byte{}, owner...), e.rrtype)
+			b = appendUint16(b, e.rr.Class)
+			b = appendUint32(b, e.rr.TTL)
+			b = appendUint16(b, uint16(len(e.rdata)))
+			h.Write(append(b, e.rdata...))
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// coveredType RRSIG 记录覆盖的类型
+func coveredType(rr *DNSResourceRecode) uint16 {
+	if len(rr.Data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(rr.Data)
+}
+
+// AddZONEMD 计算区域的摘要并替换顶点的 ZONEMD 记录, hashAlgs 为空时使用 SHA-384.
+// 签名的区域需要在之后重新签名 ZONEMD
+func AddZONEMD(z *Zone, hashAlgs ...uint8) error {
+	soa := z.SOA()
+	serial, ok := SOASerial(soa)
+	if !ok {
+		return ErrNoSOA
+	}
+	if len(hashAlgs) == 0 {
+		hashAlgs = []uint8{ZONEMDHashSHA384}
+	}
+	for _, rr := range z.Records(z.Origin, DNSTypeZONEMD) {
+		z.Remove(rr)
+	}
+	var rrs []*DNSResourceRecode
+	for _, alg := range hashAlgs {
+		digest, err := ZoneDigest(z, alg)
+		if err != nil {
+			return err
+		}
+		md := &ZONEMD{Serial: serial, Scheme: ZONEMDSchemeSimple, HashAlgorithm: alg, Digest: digest}
+		rrs = append(rrs, &DNSResourceRecode{Name: z.Origin, RRType: DNSTypeZONEMD, Class: DNSClassIn, TTL: soa.TTL, Data: md.Pack()})
+	}
+	for _, rr := range rrs {
+		if err := z.Add(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyZONEMD 按 RFC 8976 4 验证区域的摘要: 只使用序列号与 SOA 相同且方案与算法已知的
+// ZONEMD 记录, 其中任一条摘要相符即通过. 没有 ZONEMD 时返回 ErrNoZONEMD
+func VerifyZONEMD(z *Zone) error {
+	serial, ok := SOASerial(z.SOA())
+	if !ok {
+		return ErrNoSOA
+	}
+	rrs := z.Records(z.Origin, DNSTypeZONEMD)
+	if len(rrs) == 0 {
+		return ErrNoZONEMD
+	}
+	var usable []*ZONEMD
+	seen := map[[2]uint8]bool{}
+	for _, rr := range rrs {
+		md, err := ParseZONEMD(rr.Data)
+		if err != nil {
+			return errors.WithMessage(err, "zonemd")
+		}
+		// 方案与算法相同的记录不能多于一条, 否则整个 rrset 不可用 (RFC 8976 2.4)
+		pair := [2]uint8{md.Scheme, md.HashAlgorithm}
+		if seen[pair] {
+			return errors.WithMessage(ErrZONEMDUnsupported, "duplicate scheme and hash algorithm")
+		}
+		seen[pair] = true
+		if md.Serial == serial && md.Scheme == ZONEMDSchemeSimple && zonemdHash(md.HashAlgorithm) != nil && len(md.Digest) >= zonemdMinDigest {
+			usable = append(usable, md)
+		}
+	}
+	if len(usable) == 0 {
+		return ErrZONEMDUnsupported
+	}
+	for _, md := range usable {
+		digest, err := ZoneDigest(z, md.HashAlgorithm)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(digest, md.Digest) {
+			return nil
+		}
+	}
+	return ErrZONEMDMismatch
+}
+
+// checkZONEMD 加载或传输区域后验证摘要, 没有 ZONEMD 的区域不检查
+func checkZONEMD(z *Zone) error {
+	if err := VerifyZONEMD(z); err != nil && !errors.Is(err, ErrNoZONEMD) {
+		return errors.WithMessage(err, "verify zonemd")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package netx
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// RFC 8976 附录 A.1
+const zonemdExample = `example.      86400  IN  SOA     ns1 admin 2018031900 (
+                                 1800 900 604800 86400 )
+              86400  IN  NS      ns1
+              86400  IN  NS      ns2
+              86400  IN  ZONEMD  2018031900 1 1 (
+                                 c68090d90a7aed71
+                                 6bc459f9340e3d7c
+                                 1370d4d24b7e2fc3
+                                 a1ddc0b9a87153b9
+                                 a9713b3c9ae5cc27
+                                 777f98b8e730044c )
+ns1           3600   IN  A       203.0.113.63
+ns2           3600   IN  AAAA    2001:db8::63
+`
+
+func TestZONEMD(t *testing.T) {
+	parse := func(text string) *Zone {
+		t.Helper()
+		z, err := ParseZone(strings.NewReader(text), "example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return z
+	}
+	z := parse(zonemdExample)
+	if err := VerifyZONEMD(z); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改数据后摘要不符, 重新生成后通过
+	_ = z.Add(aRecord("www.example", "192.0.2.1"))
+	if err := VerifyZONEMD(z); !errors.Is(err, ErrZONEMDMismatch) {
+		t.Fatalf("tampered zone: %v", err)
+	}
+	if err := AddZONEMD(z, ZONEMDHashSHA384, ZONEMDHashSHA512); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyZONEMD(z); err != nil || len(z.Records("example", DNSTypeZONEMD)) != 2 {
+		t.Fatalf("regenerated: %v", err)
+	}
+	// 重复的记录只计一次
+	digest, _ := ZoneDigest(z, ZONEMDHashSHA384)
+	_ = z.Add(aRecord("www.example", "192.0.2.1"))
+	if again, _ := ZoneDigest(z, ZONEMDHashSHA384); string(again) != string(digest) {
+		t.Fatal("duplicate record changed the digest")
+	}
+
+	// 序列号不同或算法未知的记录不可用
+	z = parse(strings.Replace(zonemdExample, "ZONEMD  2018031900 1 1", "ZONEMD  2018031800 1 1", 1))
+	if err := VerifyZONEMD(z); !errors.Is(err, ErrZONEMDUnsupported) {
+		t.Fatalf("serial mismatch: %v", err)
+	}
+	z = parse(strings.Replace(zonemdExample, "ZONEMD  2018031900 1 1", "ZONEMD  2018031900 1 240", 1))
+	if err := VerifyZONEMD(z); !errors.Is(err, ErrZONEMDUnsupported) {
+		t.Fatalf("unknown hash: %v", err)
+	}
+	if err := VerifyZONEMD(parseDynamicZone(t)); !errors.Is(err, ErrNoZONEMD) {
+		t.Fatalf("no zonemd: %v", err)
+	}
+
+	// 加载配置时验证
+	dir := t.TempDir()
+	tampered := strings.Replace(zonemdExample, "203.0.113.63", "203.0.113.64", 1)
+	if err := os.WriteFile(filepath.Join(dir, "example.zone"), []byte(tampered), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &ServerConfig{dir: dir}
+	if _, err := c.zone(ZoneConfig{Origin: "example", File: "example.zone"}); !errors.Is(err, ErrZONEMDMismatch) {
+		t.Fatalf("config load: %v", err)
+	}
+}
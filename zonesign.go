@@ -0,0 +1,445 @@
+package netx
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"github.com/pkg/errors"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultSignatureValidity = 30 * 24 * time.Hour
+
+// signatureSkew 签名的生效时间提前于当前时间, 以容忍验证者的时钟偏差
+const signatureSkew = time.Hour
+
+var ErrNoSigningKey = errors.New("no signing key")
+
+// SigningKey 签名区域使用的密钥. Signer 可以是内存中的私钥, 也可以是 HSM 或 KMS 中的密钥
+type SigningKey struct {
+	DNSKEY *DNSKEY
+	Signer crypto.Signer
+}
+
+// NewSigningKey 由 signer 的公钥生成 DNSKEY. 支持 ECDSA P-256/P-384, Ed25519 与 RSA (RSASHA256).
+// flags 为 DNSKEYFlagZone, KSK 再加上 DNSKEYFlagSEP
+func NewSigningKey(signer crypto.Signer, flags uint16) (*SigningKey, error) {
+	key := &DNSKEY{Flags: flags, Protocol: 3}
+	switch pub := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		size := 32
+		switch pub.Curve {
+		case elliptic.P256():
+			key.Algorithm = DNSSECAlgECDSAP256SHA256
+		case elliptic.P384():
+			key.Algorithm, size = DNSSECAlgECDSAP384SHA384, 48
+		default:
+			return nil, errors.WithMessage(ErrDNSSECAlgorithm, "ecdsa curve "+pub.Curve.Params().Name)
+		}
+		x, y := make([]byte, size), make([]byte, size)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		key.PublicKey = append(x, y...)
+	case ed25519.PublicKey:
+		key.Algorithm, key.PublicKey = DNSSECAlgED25519, append([]byte{}, pub...)
+	case *rsa.PublicKey:
+		// RFC 3110 2: 指数长度, 指数, 模数
+		e := big.NewInt(int64(pub.E)).Bytes()
+		if len(e) > 255 {
+			return nil, errors.WithMessage(ErrDNSSECAlgorithm, "rsa exponent too long")
+		}
+		key.Algorithm = DNSSECAlgRSASHA256
+		key.PublicKey = append(append([]byte{byte(len(e))}, e...), pub.N.Bytes()...)
+	default:
+		return nil, ErrDNSSECAlgorithm
+	}
+	return &SigningKey{DNSKEY: key, Signer: signer}, nil
+}
+
+// Sign 返回 rrset 的 RRSIG 记录, zone 为签名者的名字
+func (k *SigningKey) Sign(rrset []*DNSResourceRecode, zone string, inception, expiration time.Time) (*DNSResourceRecode, error) {
+	if len(rrset) == 0 {
+		return nil, ErrBadRData
+	}
+	owner := normalizeDomain(rrset[0].Name)
+	sig := &RRSIG{
+		TypeCovered: rrset[0].RRType,
+		Algorithm:   k.DNSKEY.Algorithm,
+		Labels:      uint8(nameLabels(owner)),
+		OrigTTL:     rrset[0].TTL,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      k.DNSKEY.KeyTag(),
+		SignerName:  normalizeDomain(zone),
+	}
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Signature, err = k.signData(data); err != nil {
+		return nil, err
+	}
+	b, err := sig.Pack()
+	if err != nil {
+		return nil, err
+	}
+	return &DNSResourceRecode{Name: owner, RRType: DNSTypeRRSIG, Class: rrset[0].Class, TTL: rrset[0].TTL, Data: b}, nil
+}
+
+// signData 按算法散列并签名, ECDSA 的 ASN.1 签名转为定长的 r||s (RFC 6605 4)
+func (k *SigningKey) signData(data []byte) ([]byte, error) {
+	switch k.DNSKEY.Algorithm {
+	case DNSSECAlgED25519:
+		return k.Signer.Sign(rand.Reader, data, crypto.Hash(0))
+	case DNSSECAlgRSASHA256:
+		sum := sha256.Sum256(data)
+		return k.Signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case DNSSECAlgRSASHA512:
+		sum := sha512.Sum512(data)
+		return k.Signer.Sign(rand.Reader, sum[:], crypto.SHA512)
+	case DNSSECAlgECDSAP256SHA256, DNSSECAlgECDSAP384SHA384:
+		var digest []byte
+		size, hash := 32, crypto.SHA256
+		if k.DNSKEY.Algorithm == DNSSECAlgECDSAP384SHA384 {
+			sum := sha512.Sum384(data)
+			digest, size, hash = sum[:], 48, crypto.SHA384
+		} else {
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		}
+		der, err := k.Signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &rs); err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 2*size)
+		rs.R.FillBytes(sig[:size])
+		rs.S.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, errors.WithMessagef(ErrDNSSECAlgorithm, "algorithm %d", k.DNSKEY.Algorithm)
+}
+
+// NSEC3Params 签名时生成 NSEC3 链的参数, RFC 9276 建议不加盐且迭代次数为 0
+type NSEC3Params struct {
+	Iterations uint16
+	Salt       []byte
+}
+
+// SignStats 一次签名的统计
+type SignStats struct {
+	// Signed 新生成的签名数
+	Signed int
+	// Reused 从上一次签名结果中沿用的签名数
+	Reused int
+}
+
+// ZoneSigner 离线签名区域. 带 SEP 标志的密钥 (KSK) 签名 DNSKEY, 其余密钥 (ZSK) 签名其它数据,
+// 只有一种密钥时由它签名全部数据
+type ZoneSigner struct {
+	Keys []*SigningKey
+	// NSEC3 不为空时生成 NSEC3 链, 否则生成 NSEC 链
+	NSEC3 *NSEC3Params
+	// Validity 签名的有效期, 默认 30 天
+	Validity time.Duration
+	// Refresh 沿用的签名至少还要有效这么久, 默认 Validity 的四分之一
+	Refresh time.Duration
+}
+
+// Sign 返回签名后的区域: 去掉 z 中原有的 RRSIG 与 NSEC/NSEC3 记录, 在顶点加入密钥的 DNSKEY, 生成否定证明链并签名
+// 全部权威数据, 有 ZONEMD 时重新计算摘要. previous 为上一次的签名结果时, 内容没有变化且签名仍然足够新的
+// rrset 沿用原有的签名
+func (s *ZoneSigner) Sign(z, previous *Zone) (*Zone, SignStats, error) {
+	var stats SignStats
+	if len(s.Keys) == 0 {
+		return nil, stats, ErrNoSigningKey
+	}
+	soa := z.SOA()
+	timers, ok := parseSOATimers(soa)
+	if !ok {
+		return nil, stats, ErrNoSOA
+	}
+	out := NewZone(z.Origin)
+	for _, rrs := range z.records {
+		for _, rr := range rrs {
+			switch rr.RRType {
+			case DNSTypeRRSIG, DNSTypeNSEC, DNSTypeNSEC3, DNSTypeNSEC3PARAM:
+				continue
+			}
+			if err := out.Add(rr.Copy()); err != nil {
+				return nil, stats, err
+			}
+		}
+	}
+	for _, k := range s.Keys {
+		rr := &DNSResourceRecode{Name: out.Origin, RRType: DNSTypeDNSKEY, Class: DNSClassIn, TTL: soa.TTL, Data: k.DNSKEY.Pack()}
+		if !containsRecord(out.Records(out.Origin, DNSTypeDNSKEY), rr) {
+			_ = out.Add(rr)
+		}
+	}
+
+	// 否定应答的 TTL 取 SOA 的 TTL 与 minimum 中较小的 (RFC 9077)
+	ttl := soa.TTL
+	if timers.minimum < ttl {
+		ttl = timers.minimum
+	}
+	var err error
+	if s.NSEC3 != nil {
+		err = addNSEC3Chain(out, s.NSEC3, ttl)
+	} else {
+		err = addNSECChain(out, ttl)
+	}
+	if err != nil {
+		return nil, stats, err
+	}
+
+	var ksk, zsk []*SigningKey
+	for _, k := range s.Keys {
+		if k.DNSKEY.Flags&DNSKEYFlagSEP != 0 {
+			ksk = append(ksk, k)
+		} else {
+			zsk = append(zsk, k)
+		}
+	}
+	if len(ksk) == 0 {
+		ksk = zsk
+	} else if len(zsk) == 0 {
+		zsk = ksk
+	}
+	validity := s.Validity
+	if validity <= 0 {
+		validity = defaultSignatureValidity
+	}
+	refresh := s.Refresh
+	if refresh <= 0 {
+		refresh = validity / 4
+	}
+	now := time.Now()
+	inception, expiration := now.Add(-signatureSkew), now.Add(validity)
+
+	sign := func(rrset []*DNSResourceRecode) error {
+		keys := zsk
+		if rrset[0].RRType == DNSTypeDNSKEY {
+			keys = ksk
+		}
+		name := normalizeDomain(rrset[0].Name)
+		var old []*RRSIG
+		if previous != nil && sameRRset(previous.Records(name, rrset[0].RRType), rrset) {
+			old = coveringRRSIGs(previous.Records(name, DNSTypeRRSIG), rrset[0])
+		}
+	next:
+		for _, k := range keys {
+			for _, sig := range old {
+				if sig.KeyTag == k.DNSKEY.KeyTag() && sig.Algorithm == k.DNSKEY.Algorithm &&
+					SerialLess(uint32(now.Add(refresh).Unix()), sig.Expiration) && VerifyRRSIG(sig, k.DNSKEY, rrset, now) == nil {
+					b, err := sig.Pack()
+					if err != nil {
+						return err
+					}
+					_ = out.Add(&DNSResourceRecode{Name: name, RRType: DNSTypeRRSIG, Class: rrset[0].Class, TTL: rrset[0].TTL, Data: b})
+					stats.Reused++
+					continue next
+				}
+			}
+			rr, err := k.Sign(rrset, out.Origin, inception, expiration)
+			if err != nil {
+				return errors.WithMessage(err, name+"/"+DNSType(rrset[0].RRType).String())
+			}
+			_ = out.Add(rr)
+			stats.Signed++
+		}
+		return nil
+	}
+
+	zonemd := zonemdAlgorithms(out)
+	names := out.Names()
+	sort.Slice(names, func(i, j int) bool { return canonicalCompare(names[i], names[j]) < 0 })
+	for _, name := range names {
+		if name != out.Origin && out.delegation(parentName(name)) != nil {
+			// 委派之下的胶水不签名
+			continue
+		}
+		cut := name != out.Origin && len(out.Records(name, DNSTypeNS)) > 0
+		for _, rrset := range groupRRsets(out.Records(name, 0)) {
+			t := rrset[0].RRType
+			if t == DNSTypeRRSIG || cut && t != DNSTypeDS && t != DNSTypeNSEC || name == out.Origin && t == DNSTypeZONEMD && zonemd != nil {
+				continue
+			}
+			if err := sign(rrset); err != nil {
+				return nil, stats, err
+			}
+		}
+	}
+	// ZONEMD 的摘要包括其它记录的签名, 最后计算并签名
+	if zonemd != nil {
+		if err := AddZONEMD(out, zonemd...); err != nil {
+			return nil, stats, err
+		}
+		if err := sign(out.Records(out.Origin, DNSTypeZONEMD)); err != nil {
+			return nil, stats, err
+		}
+	}
+	return out, stats, nil
+}
+
+// zonemdAlgorithms 返回顶点的 ZONEMD 使用的已知散列算法, 没有 ZONEMD 时返回空
+func zonemdAlgorithms(z *Zone) []uint8 {
+	var algs []uint8
+	for _, rr := range z.Records(z.Origin, DNSTypeZONEMD) {
+		if md, err := ParseZONEMD(rr.Data); err == nil && md.Scheme == ZONEMDSchemeSimple && zonemdHash(md.HashAlgorithm) != nil {
+			algs = append(algs, md.HashAlgorithm)
+		}
+	}
+	return algs
+}
+
+// containsRecord rrs 中是否有与 rr 相同的记录, 不比较 TTL
+func containsRecord(rrs []*DNSResourceRecode, rr *DNSResourceRecode) bool {
+	for _, r := range rrs {
+		if sameRecord(r, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRRset 两个 rrset 的 TTL 与规范形式的 RDATA 集合是否相同
+func sameRRset(a, b []*DNSResourceRecode) bool {
+	ka, kb := rrsetContent(a), rrsetContent(b)
+	return ka != nil && bytes.Equal(ka, kb)
+}
+
+// rrsetContent 返回 TTL 与排序去重后的规范 RDATA, 用于比较 rrset
+func rrsetContent(rrs []*DNSResourceRecode) []byte {
+	if len(rrs) == 0 {
+		return nil
+	}
+	var rdatas [][]byte
+	for _, rr := range rrs {
+		rdata, err := canonicalRData(rr)
+		if err != nil {
+			return nil
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+	key := appendUint32(nil, rrs[0].TTL)
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		key = append(appendUint16(key, uint16(len(rdata))), rdata...)
+	}
+	return key
+}
+
+// authoritativeNames 返回区域中的权威名字 (不含委派之下的胶水) 按规范顺序排列, 以及其中的委派点
+func authoritativeNames(z *Zone) ([]string, map[string]bool) {
+	var names []string
+	cuts := map[string]bool{}
+	for _, name := range z.Names() {
+		if name != z.Origin && z.delegation(parentName(name)) != nil {
+			continue
+		}
+		names = append(names, name)
+		if name != z.Origin && len(z.Records(name, DNSTypeNS)) > 0 {
+			cuts[name] = true
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return canonicalCompare(names[i], names[j]) < 0 })
+	return names, cuts
+}
+
+// presentTypes 名字上已有的类型, 委派点只计 NS 与 DS
+func presentTypes(z *Zone, name string, cut bool) []uint16 {
+	var types []uint16
+	seen := map[uint16]bool{}
+	for _, rr := range z.Records(name, 0) {
+		if seen[rr.RRType] || cut && rr.RRType != DNSTypeNS && rr.RRType != DNSTypeDS {
+			continue
+		}
+		seen[rr.RRType] = true
+		types = append(types, rr.RRType)
+	}
+	return types
+}
+
+// addNSECChain 为每个权威名字生成 NSEC 记录 (RFC 4035 2.3)
+func addNSECChain(z *Zone, ttl uint32) error {
+	names, cuts := authoritativeNames(z)
+	for i, name := range names {
+		next := names[(i+1)%len(names)]
+		types := append(presentTypes(z, name, cuts[name]), DNSTypeRRSIG, DNSTypeNSEC)
+		data, err := (&NSEC{NextDomain: next, Types: types}).Pack()
+		if err != nil {
+			return err
+		}
+		if err := z.Add(&DNSResourceRecode{Name: name, RRType: DNSTypeNSEC, Class: DNSClassIn, TTL: ttl, Data: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNSEC3Chain 为每个权威名字与空非终端生成 NSEC3 记录, 并在顶点加入 NSEC3PARAM (RFC 5155 7.1)
+func addNSEC3Chain(z *Zone, params *NSEC3Params, ttl uint32) error {
+	if len(params.Salt) > 255 {
+		return errors.WithMessage(ErrBadRData, "nsec3 salt too long")
+	}
+	param := append([]byte{1, 0, byte(params.Iterations >> 8), byte(params.Iterations), byte(len(params.Salt))}, params.Salt...)
+	// NSEC3PARAM 的 TTL 为 0 (RFC 5155 4)
+	if err := z.Add(&DNSResourceRecode{Name: z.Origin, RRType: DNSTypeNSEC3PARAM, Class: DNSClassIn, Data: param}); err != nil {
+		return err
+	}
+	names, cuts := authoritativeNames(z)
+	// 空非终端也需要 NSEC3, 否则无法证明它们存在
+	all := map[string]bool{}
+	for _, name := range names {
+		for n := name; n != z.Origin && !all[n]; n = parentName(n) {
+			all[n] = true
+		}
+	}
+	all[z.Origin] = true
+	type entry struct {
+		hash  []byte
+		types []uint16
+	}
+	var entries []entry
+	for name := range all {
+		types := presentTypes(z, name, cuts[name])
+		// 没有 DS 的委派点只有 NS, 不签名
+		if len(types) > 0 && (!cuts[name] || len(z.Records(name, DNSTypeDS)) > 0) {
+			types = append(types, DNSTypeRRSIG)
+		}
+		entries = append(entries, entry{nsec3Hash(name, params.Salt, params.Iterations), types})
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].hash, entries[j].hash) < 0 })
+	for i, e := range entries {
+		next := entries[(i+1)%len(entries)]
+		if len(entries) > 1 && bytes.Equal(e.hash, next.hash) {
+			return errors.WithMessage(ErrBadRData, "nsec3 hash collision")
+		}
+		n := &NSEC3{HashAlgorithm: 1, Iterations: params.Iterations, Salt: params.Salt, NextHashed: next.hash, Types: e.types}
+		owner := strings.ToLower(nsec3Encoding.EncodeToString(e.hash))
+		if z.Origin != "" {
+			owner += "." + z.Origin
+		}
+		if err := z.Add(&DNSResourceRecode{Name: owner, RRType: DNSTypeNSEC3, Class: DNSClassIn, TTL: ttl, Data: n.Pack()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
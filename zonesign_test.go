@@ -0,0 +1,174 @@
+package netx
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+const unsignedZoneText = `$TTL 3600
+@	IN SOA ns.example. admin.example. 1 3600 600 86400 300
+	IN NS ns.example.
+ns	IN A 192.0.2.53
+www	IN A 192.0.2.1
+	IN TXT "hello \"world\"" "second"
+*.wild	IN A 192.0.2.2
+a.b	IN A 192.0.2.3
+sub	IN NS ns.sub.example.
+ns.sub	IN A 192.0.2.54
+`
+
+func newSigningKeys(t *testing.T) (ksk, zsk *SigningKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ksk, err = NewSigningKey(priv, DNSKEYFlagZone|DNSKEYFlagSEP); err != nil {
+		t.Fatal(err)
+	}
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zsk, err = NewSigningKey(edPriv, DNSKEYFlagZone); err != nil {
+		t.Fatal(err)
+	}
+	return ksk, zsk
+}
+
+// denialCache 返回载入区域中 NSEC/NSEC3 记录的 NSECCache
+func denialCache(z *Zone) *NSECCache {
+	c := &NSECCache{}
+	expires := time.Now().Add(time.Hour)
+	c.addSOA(z.Origin, []*DNSResourceRecode{z.SOA()}, expires)
+	for _, name := range z.Names() {
+		for _, rr := range z.Records(name, 0) {
+			if rr.RRType == DNSTypeNSEC || rr.RRType == DNSTypeNSEC3 {
+				c.add(z.Origin, []*DNSResourceRecode{rr}, expires)
+			}
+		}
+	}
+	return c
+}
+
+func TestZoneSigner(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ksk, zsk := newSigningKeys(t)
+	anchor, _ := ksk.DNSKEY.ToDS("example", DSDigestSHA256)
+	s := &ZoneSigner{Keys: []*SigningKey{ksk, zsk}}
+	signed, stats, err := s.Sign(z, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyZone(signed, []*DS{anchor}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// KSK 只签名 DNSKEY
+	for _, sig := range coveringRRSIGs(signed.Records("example", DNSTypeRRSIG), &DNSResourceRecode{Name: "example", RRType: DNSTypeSOA}) {
+		if sig.KeyTag != zsk.DNSKEY.KeyTag() {
+			t.Fatal("soa signed by the ksk")
+		}
+	}
+	if len(signed.Records("ns.sub.example", DNSTypeRRSIG)) != 0 || len(signed.Records("ns.sub.example", DNSTypeNSEC)) != 0 {
+		t.Fatal("glue signed")
+	}
+	if len(signed.Records("sub.example", DNSTypeRRSIG)) != 1 {
+		t.Fatal("delegation without ds should only sign its nsec")
+	}
+	c := denialCache(signed)
+	if resp := c.Synthesize(NewQuery("none.example", DNSTypeA)); resp == nil || resp.Header.Flags.RCode != DNSRCodeNXDomain {
+		t.Fatalf("nxdomain from the nsec chain: %+v", resp)
+	}
+	if resp := c.Synthesize(NewQuery("www.example", DNSTypeAAAA)); resp == nil || resp.Header.Flags.RCode != DNSRCodeSuccess {
+		t.Fatalf("nodata from the nsec chain: %+v", resp)
+	}
+
+	// 写出后读回, 结果不变
+	var buf bytes.Buffer
+	if _, err := signed.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "example.\t3600\tIN\tSOA\tns.example. admin.example. 1 ") {
+		t.Fatalf("zone text starts with %q", strings.SplitN(buf.String(), "\n", 2)[0])
+	}
+	previous, err := ParseZone(&buf, "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyZone(previous, []*DS{anchor}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if txt := previous.Records("www.example", DNSTypeTXT); len(txt) != 1 || !bytes.Equal(txt[0].Data, z.Records("www.example", DNSTypeTXT)[0].Data) {
+		t.Fatalf("txt round trip: %v", txt)
+	}
+
+	// 增量签名: 没有变化时全部沿用
+	again, st, err := s.Sign(z, previous)
+	if err != nil || st.Signed != 0 || st.Reused != stats.Signed {
+		t.Fatalf("unchanged zone: %v %+v, first %+v", err, st, stats)
+	}
+	if err := verifyZone(again, []*DS{anchor}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// 修改一个 rrset 与新增一个名字: 该 rrset, 新名字的 A 与 NSEC, 以及前一个名字的 NSEC
+	_ = z.Add(aRecord("www.example", "192.0.2.9"))
+	_ = z.Add(aRecord("new.example", "192.0.2.10"))
+	if _, st, err = s.Sign(z, previous); err != nil || st.Signed != 4 {
+		t.Fatalf("changed zone: %v %+v", err, st)
+	}
+	// 快要过期的签名重新生成
+	if _, st, err = (&ZoneSigner{Keys: s.Keys, Refresh: 60 * 24 * time.Hour}).Sign(z, previous); err != nil || st.Reused != 0 {
+		t.Fatalf("refresh: %v %+v", err, st)
+	}
+}
+
+func TestZoneSignerNSEC3(t *testing.T) {
+	z, err := ParseZone(strings.NewReader(unsignedZoneText), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = z.Add(&DNSResourceRecode{Name: "sub.example", RRType: DNSTypeDS, Class: DNSClassIn, TTL: 3600, Data: (&DS{KeyTag: 1, Algorithm: 13, DigestType: 2, Digest: make([]byte, 32)}).Pack()})
+	md := &ZONEMD{Serial: 1, Scheme: ZONEMDSchemeSimple, HashAlgorithm: ZONEMDHashSHA384}
+	_ = z.Add(&DNSResourceRecode{Name: "example", RRType: DNSTypeZONEMD, Class: DNSClassIn, TTL: 3600, Data: md.Pack()})
+
+	ksk, _ := newSigningKeys(t)
+	anchor, _ := ksk.DNSKEY.ToDS("example", DSDigestSHA256)
+	s := &ZoneSigner{Keys: []*SigningKey{ksk}, NSEC3: &NSEC3Params{Salt: []byte{0xab, 0xcd}}}
+	signed, _, err := s.Sign(z, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyZone(signed, []*DS{anchor}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyZONEMD(signed); err != nil {
+		t.Fatal(err)
+	}
+	if len(signed.Records("example", DNSTypeNSEC3PARAM)) != 1 || len(signed.Records("example", DNSTypeNSEC)) != 0 {
+		t.Fatal("nsec3param")
+	}
+	// 6 个权威名字与空非终端 b.example, wild.example
+	n := 0
+	for _, name := range signed.Names() {
+		n += len(signed.Records(name, DNSTypeNSEC3))
+	}
+	if n != 8 {
+		t.Fatalf("%d nsec3 records", n)
+	}
+	c := denialCache(signed)
+	if resp := c.Synthesize(NewQuery("none.example", DNSTypeA)); resp == nil || resp.Header.Flags.RCode != DNSRCodeNXDomain {
+		t.Fatalf("nxdomain from the nsec3 chain: %+v", resp)
+	}
+	if resp := c.Synthesize(NewQuery("b.example", DNSTypeA)); resp == nil || resp.Header.Flags.RCode != DNSRCodeSuccess {
+		t.Fatalf("nodata for the empty non-terminal: %+v", resp)
+	}
+}